@@ -0,0 +1,348 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/process"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// PackageManagerAPK selects the apk backend, for Alpine based images
+	PackageManagerAPK pkg.PackageManager = "apk"
+
+	// apkDefaultKeysDir holds the keys trusted by the host
+	apkDefaultKeysDir = "/etc/apk/keys"
+
+	// apkInstalledDB is the database of installed packages within the root
+	apkInstalledDB = "lib/apk/db/installed"
+
+	// apkKeysDir holds the keys trusted within the root
+	apkKeysDir = "etc/apk/keys"
+
+	// apkRepositories lists the repositories enabled within the root
+	apkRepositories = "etc/apk/repositories"
+)
+
+// ErrNoGroups is returned when the package manager has no concept of groups
+var ErrNoGroups = errors.New("Package manager does not support groups, list the packages instead")
+
+func init() {
+	Register(&Backend{
+		Name:          PackageManagerAPK,
+		Distributions: []string{"alpine"},
+		NewManager:    NewAPKManager,
+		NewRootQuery: func(root string) (Query, error) {
+			return NewAPKRootQuery(root), nil
+		},
+	})
+}
+
+// An APKManager installs packages into the root with apk --root
+type APKManager struct {
+	arch           string
+	keysDir        string
+	keys           []string // Trusted by the profile, in addition to keysDir
+	allowUntrusted bool
+	root           string
+}
+
+// NewAPKManager will return the apk manager for the configuration
+func NewAPKManager(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error) {
+	a := &APKManager{
+		arch:           conf.APK.Arch,
+		keysDir:        conf.APK.KeysDir,
+		allowUntrusted: conf.APK.AllowUntrusted,
+	}
+	if a.arch == "" {
+		a.arch = conf.Image.Arch
+	}
+	if a.keysDir == "" {
+		a.keysDir = apkDefaultKeysDir
+	}
+	if !filepath.IsAbs(a.keysDir) {
+		return nil, fmt.Errorf("apk.keys_dir must be absolute: %v", a.keysDir)
+	}
+	for _, key := range conf.APK.Keys {
+		if !filepath.IsAbs(key) {
+			key = filepath.Join(baseDir, key)
+		}
+		a.keys = append(a.keys, key)
+	}
+	return a, nil
+}
+
+// Init will ensure apk is available on the host
+func (a *APKManager) Init() error {
+	_, err := exec.LookPath("apk")
+	return err
+}
+
+// apk will run apk against the root
+func (a *APKManager) apk(args ...string) error {
+	cmdArgs := []string{"--root", a.root, "--no-progress"}
+	if a.arch != "" {
+		cmdArgs = append(cmdArgs, "--arch", a.arch)
+	}
+	if a.allowUntrusted {
+		cmdArgs = append(cmdArgs, "--allow-untrusted")
+	} else {
+		cmdArgs = append(cmdArgs, "--keys-dir", filepath.Join(a.root, apkKeysDir))
+	}
+	cmd := exec.Command("apk", append(cmdArgs, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// InitRoot will install the trusted keys into the root, with only the
+// repositories of the profile enabled
+func (a *APKManager) InitRoot(root string) error {
+	a.root = root
+	if err := a.installKeys(); err != nil {
+		return err
+	}
+	// Reused roots would otherwise gain duplicate repositories
+	if err := os.Remove(filepath.Join(root, apkRepositories)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Bootstrap will create the apk database within the empty root. No
+// repository is needed, the keys were already installed by InitRoot.
+func (a *APKManager) Bootstrap(repos []*spec.OpRepo) error {
+	return a.apk("--initdb", "add")
+}
+
+// installKeys will install the trusted keys into the root, which are used to
+// verify the packages and allow the image to verify its own updates
+func (a *APKManager) installKeys() error {
+	keysDir := filepath.Join(a.root, apkKeysDir)
+	if err := os.MkdirAll(keysDir, 00755); err != nil {
+		return err
+	}
+	if a.allowUntrusted {
+		return nil
+	}
+	keys, err := filepath.Glob(filepath.Join(a.keysDir, "*.pub"))
+	if err != nil {
+		return err
+	}
+	keys = append(keys, a.keys...)
+	if len(keys) == 0 {
+		return fmt.Errorf("No apk keys found in %v, set apk.keys or apk.allow_untrusted", a.keysDir)
+	}
+	for _, key := range keys {
+		data, err := ioutil.ReadFile(key)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(keysDir, filepath.Base(key)), data, 00644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRepo will enable the repository within the root. apk repositories have
+// no names, so the identifier is only kept as a comment.
+func (a *APKManager) AddRepo(identifier, uri string) error {
+	path := filepath.Join(a.root, apkRepositories)
+	fi, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 00644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fi, "# %v\n%v\n", identifier, uri); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
+
+// InstallGroups always fails, as apk has no groups
+func (a *APKManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	return ErrNoGroups
+}
+
+// InstallPackages will install the packages into the root. Ignoring safety
+// allows packages to overwrite files owned by others.
+func (a *APKManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	args := []string{"add"}
+	if ignoreSafety {
+		args = append(args, "--force-overwrite")
+	}
+	return a.apk(append(args, packages...)...)
+}
+
+// RemovePackages will remove the packages from the root, along with anything
+// depending on them unless ignoreSafety is set
+func (a *APKManager) RemovePackages(ignoreSafety bool, names []string) error {
+	args := []string{"del"}
+	if ignoreSafety {
+		args = append(args, "--force-broken-world")
+	} else {
+		args = append(args, "--rdepends")
+	}
+	return a.apk(append(args, names...)...)
+}
+
+// FinalizeRoot will empty the package cache of the root
+func (a *APKManager) FinalizeRoot() error {
+	cache := filepath.Join(a.root, "var", "cache", "apk")
+	entries, err := ioutil.ReadDir(cache)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(cache, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup does nothing, as apk leaves no processes behind
+func (a *APKManager) Cleanup() error {
+	return nil
+}
+
+// apkPackage is an entry of the installed database
+type apkPackage struct {
+	Name     string
+	Version  string
+	Licenses []string
+}
+
+// APKQuery answers queries by reading the installed database of a rootfs,
+// which needs no apk on the host
+type APKQuery struct {
+	root     string
+	packages map[string]*apkPackage
+}
+
+// NewAPKRootQuery will answer queries using the database of the rootfs
+func NewAPKRootQuery(root string) *APKQuery {
+	return &APKQuery{root: root}
+}
+
+// load will parse the installed database, where each package is a block of
+// "X:value" lines, i.e. "P:busybox"
+func (a *APKQuery) load() error {
+	if a.packages != nil {
+		return nil
+	}
+	fi, err := os.Open(filepath.Join(a.root, apkInstalledDB))
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	a.packages = make(map[string]*apkPackage)
+	var cur *apkPackage
+	sc := bufio.NewScanner(fi)
+	for sc.Scan() {
+		line := sc.Text()
+		if len(line) < 2 || line[1] != ':' {
+			cur = nil
+			continue
+		}
+		key, value := line[0], line[2:]
+		if key == 'P' {
+			cur = &apkPackage{Name: value}
+			a.packages[value] = cur
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		switch key {
+		case 'V':
+			cur.Version = value
+		case 'L':
+			// SPDX style expressions, i.e. "MIT AND GPL-2.0-only"
+			for _, id := range strings.Fields(value) {
+				if id != "AND" && id != "OR" {
+					cur.Licenses = append(cur.Licenses, strings.Trim(id, "()"))
+				}
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// get will return the named installed package
+func (a *APKQuery) get(name string) (*apkPackage, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	p, ok := a.packages[name]
+	if !ok {
+		return nil, fmt.Errorf("Package is not installed: %v", name)
+	}
+	return p, nil
+}
+
+// Close does nothing, as the root is not ours
+func (a *APKQuery) Close() error {
+	return nil
+}
+
+// InstalledPackages will list all packages installed in the root
+func (a *APKQuery) InstalledPackages() ([]string, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for name := range a.packages {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Version will report the version and release of the package, i.e. "1.36.1-r5"
+func (a *APKQuery) Version(name string) (string, error) {
+	p, err := a.get(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Version, nil
+}
+
+// Licenses will report the license identifiers of the package
+func (a *APKQuery) Licenses(name string) ([]string, error) {
+	p, err := a.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Licenses, nil
+}
@@ -0,0 +1,103 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const apkInstalled = `C:Q1abc=
+P:busybox
+V:1.36.1-r5
+A:x86_64
+L:GPL-2.0-only
+
+C:Q1def=
+P:musl
+V:1.2.4-r2
+L:MIT AND (BSD-2-Clause OR GPL-2.0-or-later)
+`
+
+func TestAPKQuery(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-apk")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	db := filepath.Join(root, apkInstalledDB)
+	os.MkdirAll(filepath.Dir(db), 00755)
+	if err := ioutil.WriteFile(db, []byte(apkInstalled), 00644); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+	q := NewAPKRootQuery(root)
+	names, err := q.InstalledPackages()
+	if err != nil || !reflect.DeepEqual(names, []string{"busybox", "musl"}) {
+		t.Fatalf("Wrong installed packages: %v %v", names, err)
+	}
+	if v, err := q.Version("busybox"); err != nil || v != "1.36.1-r5" {
+		t.Fatalf("Wrong version: %v %v", v, err)
+	}
+	licenses, err := q.Licenses("musl")
+	if err != nil || !reflect.DeepEqual(licenses, []string{"MIT", "BSD-2-Clause", "GPL-2.0-or-later"}) {
+		t.Fatalf("Wrong licenses: %v %v", licenses, err)
+	}
+	if _, err := q.Version("glibc"); err == nil {
+		t.Fatalf("Missing packages should be reported")
+	}
+}
+
+func TestAPKKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-apk")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &config.ImageConfiguration{}
+	conf.APK.KeysDir = filepath.Join(dir, "host")
+	m, err := NewAPKManager(conf, dir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	a := m.(*APKManager)
+	a.root = filepath.Join(dir, "root")
+	if err := a.installKeys(); err == nil {
+		t.Fatalf("Missing keys should be reported")
+	}
+
+	os.MkdirAll(conf.APK.KeysDir, 00755)
+	ioutil.WriteFile(filepath.Join(conf.APK.KeysDir, "alpine.rsa.pub"), []byte("host"), 00644)
+	ioutil.WriteFile(filepath.Join(dir, "local.rsa.pub"), []byte("local"), 00644)
+	conf.APK.Keys = []string{"local.rsa.pub"}
+	m, _ = NewAPKManager(conf, dir)
+	a = m.(*APKManager)
+	a.root = filepath.Join(dir, "root")
+	if err := a.installKeys(); err != nil {
+		t.Fatalf("Failed to install keys: %v", err)
+	}
+	for _, key := range []string{"alpine.rsa.pub", "local.rsa.pub"} {
+		if _, err := os.Stat(filepath.Join(a.root, apkKeysDir, key)); err != nil {
+			t.Fatalf("Key %v not trusted within the root: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"io/ioutil"
+	"libuspin/chroot"
+	"libuspin/config"
+	"libuspin/process"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// PackageManagerAPT selects the apt backend, for Debian and Ubuntu based images
+	PackageManagerAPT pkg.PackageManager = "apt"
+
+	// dpkgStatus is the database of installed packages within the root
+	dpkgStatus = "var/lib/dpkg/status"
+
+	// aptSourcesList is replaced with the repositories of the profile
+	aptSourcesList = "etc/apt/sources.list"
+
+	// aptArchives holds the packages fetched into the root
+	aptArchives = "var/cache/apt/archives"
+
+	// aptTrustedDir holds the keys trusted by apt within the root
+	aptTrustedDir = "etc/apt/trusted.gpg.d"
+
+	// dpkgConfigDir holds configuration fragments read by dpkg
+	dpkgConfigDir = "etc/dpkg/dpkg.cfg.d"
+)
+
+// debianArch maps the uname style architectures used by USpin to Debian's
+var debianArch = map[string]string{
+	"x86_64":  "amd64",
+	"i686":    "i386",
+	"aarch64": "arm64",
+	"armv7l":  "armhf",
+	"ppc64le": "ppc64el",
+}
+
+func init() {
+	Register(&Backend{
+		Name:          PackageManagerAPT,
+		Distributions: []string{"debian", "ubuntu"},
+		NewManager:    NewAPTManager,
+		NewRootQuery: func(root string) (Query, error) {
+			return NewDpkgRootQuery(root), nil
+		},
+	})
+}
+
+// An APTManager bootstraps the root with debootstrap from the first
+// repository, and then installs everything else with apt-get inside it.
+type APTManager struct {
+	conf    config.SectionAPT
+	arch    string
+	root    string
+	sources []string // Lines of the generated sources.list
+	mirror  string   // Bootstrapped from, the first repository
+
+	bootstrapped bool
+	updated      bool // Whether the package lists match the sources
+}
+
+// NewAPTManager will return the apt manager for the configuration
+func NewAPTManager(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error) {
+	a := &APTManager{conf: conf.APT, arch: conf.APT.Arch}
+	if strings.TrimSpace(a.conf.Suite) == "" {
+		return nil, errors.New("apt.suite must be set to use the apt package manager")
+	}
+	if len(a.conf.Components) == 0 {
+		a.conf.Components = []string{"main"}
+	}
+	if a.conf.Variant == "" {
+		a.conf.Variant = "minbase"
+	}
+	if a.arch == "" {
+		if a.arch = debianArch[conf.Image.Arch]; a.arch == "" {
+			a.arch = conf.Image.Arch
+		}
+	}
+	return a, nil
+}
+
+// Init will ensure debootstrap is available on the host
+func (a *APTManager) Init() error {
+	_, err := exec.LookPath("debootstrap")
+	return err
+}
+
+// InitRoot will remember the root, which is bootstrapped by Bootstrap or
+// otherwise on first use. A populated root is reused as is.
+func (a *APTManager) InitRoot(root string) error {
+	a.root = root
+	_, err := os.Stat(filepath.Join(root, dpkgStatus))
+	a.bootstrapped = err == nil
+	return nil
+}
+
+// aptSource returns the sources.list line of the repository. The URI may
+// carry its own suite and components, i.e. for security updates:
+// "http://security.debian.org/debian-security bookworm-security main"
+func aptSource(conf *config.SectionAPT, uri string) string {
+	fields := strings.Fields(uri)
+	if len(fields) == 1 {
+		fields = append(fields, conf.Suite)
+		fields = append(fields, conf.Components...)
+	}
+	return "deb " + strings.Join(fields, " ")
+}
+
+// AddRepo will enable the repository, the first of which is used to
+// bootstrap the root
+func (a *APTManager) AddRepo(identifier, uri string) error {
+	if a.mirror == "" {
+		a.mirror = strings.Fields(uri)[0]
+	}
+	a.sources = append(a.sources, fmt.Sprintf("# %v\n%v", identifier, aptSource(&a.conf, uri)))
+	a.updated = false
+	if a.bootstrapped {
+		return a.writeSources()
+	}
+	return nil
+}
+
+// writeSources will replace the sources.list of the root
+func (a *APTManager) writeSources() error {
+	data := "# Generated by USpin\n" + strings.Join(a.sources, "\n") + "\n"
+	return ioutil.WriteFile(filepath.Join(a.root, aptSourcesList), []byte(data), 00644)
+}
+
+// Bootstrap will create the base system with debootstrap from the first
+// repository
+func (a *APTManager) Bootstrap(repos []*spec.OpRepo) error {
+	if len(repos) == 0 {
+		return ErrNoBootstrapRepo
+	}
+	if a.mirror == "" {
+		a.mirror = strings.Fields(repos[0].RepoURI)[0]
+	}
+	return a.bootstrap()
+}
+
+// bootstrap will create the base system with debootstrap, unless the root
+// was already populated
+func (a *APTManager) bootstrap() error {
+	if a.bootstrapped {
+		return nil
+	}
+	if a.mirror == "" {
+		return errors.New("apt needs a repository to bootstrap from")
+	}
+	args := []string{
+		"--variant=" + a.conf.Variant,
+		"--arch=" + a.arch,
+		"--components=" + strings.Join(a.conf.Components, ","),
+	}
+	if a.conf.Keyring != "" {
+		args = append(args, "--keyring="+a.conf.Keyring)
+	}
+	args = append(args, a.conf.Suite, a.root, a.mirror)
+	cmd := exec.Command("debootstrap", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("debootstrap failed: %v", err)
+	}
+	a.bootstrapped = true
+	if err := a.installKeyring(); err != nil {
+		return err
+	}
+	return a.writeSources()
+}
+
+// installKeyring will have apt within the root trust the keyring which
+// verified the bootstrap, as the archive may not be signed by the keys of
+// the distribution
+func (a *APTManager) installKeyring() error {
+	if a.conf.Keyring == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(a.conf.Keyring)
+	if err != nil {
+		return err
+	}
+	// apt only reads armored keys from .asc files
+	name := "uspin.gpg"
+	if filepath.Ext(a.conf.Keyring) == ".asc" {
+		name = "uspin.asc"
+	}
+	dir := filepath.Join(a.root, aptTrustedDir)
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 00644)
+}
+
+// shellJoin will quote the arguments for a POSIX shell
+func shellJoin(args []string) string {
+	var quoted []string
+	for _, arg := range args {
+		quoted = append(quoted, "'"+strings.Replace(arg, "'", "'\\''", -1)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
+// run will run the command inside the bootstrapped root, refreshing the
+// package lists first if the sources changed
+func (a *APTManager) run(args ...string) error {
+	if err := a.bootstrap(); err != nil {
+		return err
+	}
+	script := shellJoin(args)
+	if !a.updated {
+		script = "apt-get update && " + script
+	}
+	c := chroot.New(a.root, nil, []string{"DEBIAN_FRONTEND=noninteractive"})
+	if err := c.Enter(); err != nil {
+		return err
+	}
+	err := c.Run(script)
+	if lerr := c.Leave(); err == nil {
+		err = lerr
+	}
+	if err == nil {
+		a.updated = true
+	}
+	return err
+}
+
+// install will run apt-get install for the names
+func (a *APTManager) install(ignoreSafety bool, names []string) error {
+	args := []string{"apt-get", "-y", "install"}
+	if !a.conf.Recommends {
+		args = append(args, "--no-install-recommends")
+	}
+	if ignoreSafety {
+		args = append(args, "--allow-remove-essential", "--allow-downgrades")
+	}
+	return a.run(append(args, names...)...)
+}
+
+// InstallGroups will install the groups as tasks, i.e. "ubuntu-desktop^".
+// Debian tasks are also available as task-* metapackages, which may be
+// listed as plain packages instead.
+func (a *APTManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	var tasks []string
+	for _, group := range groups {
+		tasks = append(tasks, group+"^")
+	}
+	return a.install(ignoreSafety, tasks)
+}
+
+// InstallPackages will install the packages into the root
+func (a *APTManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	return a.install(ignoreSafety, packages)
+}
+
+// RemovePackages will remove the packages from the root, along with anything
+// depending on them unless ignoreSafety is set
+func (a *APTManager) RemovePackages(ignoreSafety bool, names []string) error {
+	if ignoreSafety {
+		return a.run(append([]string{"dpkg", "--remove", "--force-depends"}, names...)...)
+	}
+	return a.run(append([]string{"apt-get", "-y", "remove"}, names...)...)
+}
+
+// FilterFiles will have dpkg leave out the files of the classes, which it
+// still records as installed
+func (a *APTManager) FilterFiles(classes []config.FileClass) error {
+	if len(classes) == 0 {
+		return nil
+	}
+	data := "# Generated by USpin\n"
+	for _, class := range classes {
+		for _, pattern := range config.FileClassPatterns[class] {
+			data += "path-exclude=" + dpkgPattern(pattern) + "\n"
+		}
+	}
+	dir := filepath.Join(a.root, dpkgConfigDir)
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "uspin-filter"), []byte(data), 00644)
+}
+
+// dpkgPattern returns the dpkg path-exclude glob of the exclude pattern. A
+// dpkg "*" also matches "/", so a trailing "/**" needs only one.
+func dpkgPattern(pattern string) string {
+	if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+		return prefix + "/*"
+	}
+	return pattern
+}
+
+// CheckDatabase will audit dpkg for partially installed packages, and apt
+// for broken dependencies
+func (a *APTManager) CheckDatabase() error {
+	if err := a.run("dpkg", "--audit"); err != nil {
+		return err
+	}
+	return a.run("apt-get", "check")
+}
+
+// PackageCache returns the directory of packages fetched into the root
+func (a *APTManager) PackageCache() string {
+	return aptArchives
+}
+
+// FinalizeRoot will empty the package cache of the root
+func (a *APTManager) FinalizeRoot() error {
+	return a.run("apt-get", "clean")
+}
+
+// Cleanup does nothing, as the chroot is left after every command
+func (a *APTManager) Cleanup() error {
+	return nil
+}
+
+// dpkgPackage is an installed entry of the dpkg status database
+type dpkgPackage struct {
+	Version string
+	Depends []string
+}
+
+// DpkgQuery answers queries by reading the dpkg status database of a rootfs,
+// which needs no apt on the host
+type DpkgQuery struct {
+	root     string
+	packages map[string]*dpkgPackage
+}
+
+// NewDpkgRootQuery will answer queries using the database of the rootfs
+func NewDpkgRootQuery(root string) *DpkgQuery {
+	return &DpkgQuery{root: root}
+}
+
+// parseDepends will return the package names of a Depends field, using the
+// first of any alternatives, i.e. "libc6 (>= 2.34), awk | mawk"
+func parseDepends(value string) []string {
+	var ret []string
+	for _, dep := range strings.Split(value, ",") {
+		dep = strings.TrimSpace(strings.Split(dep, "|")[0])
+		if idx := strings.IndexAny(dep, " (:"); idx > 0 {
+			dep = dep[:idx]
+		}
+		if dep != "" {
+			ret = append(ret, dep)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// load will parse the status database, where each package is a block of
+// "Field: value" lines. Only installed packages are kept.
+func (d *DpkgQuery) load() error {
+	if d.packages != nil {
+		return nil
+	}
+	fi, err := os.Open(filepath.Join(d.root, dpkgStatus))
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	d.packages = make(map[string]*dpkgPackage)
+	var name, status string
+	cur := &dpkgPackage{}
+	flush := func() {
+		if name != "" && strings.HasSuffix(status, " installed") {
+			d.packages[name] = cur
+		}
+		name, status, cur = "", "", &dpkgPackage{}
+	}
+	sc := bufio.NewScanner(fi)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		// Continuation lines of multi-line fields, i.e. Description
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch fields[0] {
+		case "Package":
+			name = value
+		case "Status":
+			status = value
+		case "Version":
+			cur.Version = value
+		case "Depends", "Pre-Depends":
+			cur.Depends = append(cur.Depends, parseDepends(value)...)
+		}
+	}
+	flush()
+	return sc.Err()
+}
+
+// get will return the named installed package
+func (d *DpkgQuery) get(name string) (*dpkgPackage, error) {
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	p, ok := d.packages[name]
+	if !ok {
+		return nil, fmt.Errorf("Package is not installed: %v", name)
+	}
+	return p, nil
+}
+
+// Close does nothing, as the root is not ours
+func (d *DpkgQuery) Close() error {
+	return nil
+}
+
+// InstalledPackages will list all packages installed in the root
+func (d *DpkgQuery) InstalledPackages() ([]string, error) {
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for name := range d.packages {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Version will report the version of the package, i.e. "2.36-9+deb12u4"
+func (d *DpkgQuery) Version(name string) (string, error) {
+	p, err := d.get(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Version, nil
+}
+
+// Dependencies will report the direct dependencies of the package
+func (d *DpkgQuery) Dependencies(name string) ([]string, error) {
+	p, err := d.get(name)
+	if err != nil {
+		return nil, err
+	}
+	deps := append([]string(nil), p.Depends...)
+	sort.Strings(deps)
+	return deps, nil
+}
@@ -0,0 +1,141 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const dpkgStatusData = `Package: libc6
+Status: install ok installed
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: mawk
+Status: deinstall ok config-files
+Version: 1.3.4
+
+Package: bash
+Status: install ok installed
+Pre-Depends: libc6 (>= 2.36), libtinfo6 (>= 6)
+Depends: base-files (>= 2.1.12), debianutils (>= 5.6-0.1)
+Version: 5.2.15-2+b2
+`
+
+func TestDpkgQuery(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-dpkg")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	status := filepath.Join(root, dpkgStatus)
+	os.MkdirAll(filepath.Dir(status), 00755)
+	if err := ioutil.WriteFile(status, []byte(dpkgStatusData), 00644); err != nil {
+		t.Fatalf("Failed to write status: %v", err)
+	}
+	q := NewDpkgRootQuery(root)
+	names, err := q.InstalledPackages()
+	if err != nil || !reflect.DeepEqual(names, []string{"bash", "libc6"}) {
+		t.Fatalf("Wrong installed packages: %v %v", names, err)
+	}
+	if v, err := q.Version("bash"); err != nil || v != "5.2.15-2+b2" {
+		t.Fatalf("Wrong version: %v %v", v, err)
+	}
+	deps, err := q.Dependencies("bash")
+	if err != nil || !reflect.DeepEqual(deps, []string{"base-files", "debianutils", "libc6", "libtinfo6"}) {
+		t.Fatalf("Wrong dependencies: %v %v", deps, err)
+	}
+}
+
+func TestAptSource(t *testing.T) {
+	conf := &config.ImageConfiguration{}
+	conf.Image.Arch = "x86_64"
+	if _, err := NewAPTManager(conf, ""); err == nil {
+		t.Fatalf("apt.suite should be required")
+	}
+	conf.APT.Suite = "bookworm"
+	m, err := NewAPTManager(conf, "")
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	a := m.(*APTManager)
+	if a.arch != "amd64" {
+		t.Fatalf("Wrong architecture: %v", a.arch)
+	}
+	if s := aptSource(&a.conf, "http://deb.debian.org/debian"); s != "deb http://deb.debian.org/debian bookworm main" {
+		t.Fatalf("Wrong source: %v", s)
+	}
+	security := "http://security.debian.org/debian-security bookworm-security main contrib"
+	if s := aptSource(&a.conf, security); s != "deb "+security {
+		t.Fatalf("Repositories should keep their own suite: %v", s)
+	}
+}
+
+func TestAPTKeyring(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-apt")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	keyring := filepath.Join(root, "archive.asc")
+	if err := ioutil.WriteFile(keyring, []byte("key"), 00644); err != nil {
+		t.Fatalf("Failed to write keyring: %v", err)
+	}
+	a := &APTManager{root: filepath.Join(root, "rootfs")}
+	a.conf.Keyring = keyring
+	if err := a.installKeyring(); err != nil {
+		t.Fatalf("Failed to install keyring: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(a.root, aptTrustedDir, "uspin.asc")); err != nil || string(data) != "key" {
+		t.Fatalf("Keyring not trusted within the root: %v", err)
+	}
+	if err := a.Bootstrap(nil); err != ErrNoBootstrapRepo {
+		t.Fatalf("Bootstrapping without repositories should fail: %v", err)
+	}
+}
+
+func TestDpkgFilter(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-apt")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	a := &APTManager{root: root}
+	if err := a.FilterFiles([]config.FileClass{config.FileClassDocs}); err != nil {
+		t.Fatalf("Failed to filter files: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, dpkgConfigDir, "uspin-filter"))
+	if err != nil {
+		t.Fatalf("Failed to read filter: %v", err)
+	}
+	if !strings.Contains(string(data), "path-exclude=/usr/share/doc/*\n") {
+		t.Fatalf("Documentation not excluded:\n%s", data)
+	}
+	if p := dpkgPattern("/usr/share/locale/*/LC_MESSAGES/*.mo"); p != "/usr/share/locale/*/LC_MESSAGES/*.mo" {
+		t.Fatalf("Wrong pattern: %v", p)
+	}
+}
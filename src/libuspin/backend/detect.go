@@ -0,0 +1,125 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PackageManagerAuto selects the backend matching the distribution of the
+// seed rootfs, or of the host when there is no seed
+const PackageManagerAuto pkg.PackageManager = "auto"
+
+// ErrNoOSRelease is returned when a rootfs has no os-release to detect from
+var ErrNoOSRelease = errors.New("No os-release found")
+
+// osReleasePaths are the locations of os-release within a rootfs, in order
+var osReleasePaths = []string{"etc/os-release", "usr/lib/os-release"}
+
+// ParseOSRelease will parse the KEY=value lines of an os-release file
+func ParseOSRelease(r io.Reader) (map[string]string, error) {
+	ret := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ret[kv[0]] = strings.Trim(kv[1], "\"'")
+	}
+	return ret, sc.Err()
+}
+
+// DetectOSRelease will return the backend of the distribution described by
+// the os-release fields, trying ID before each of ID_LIKE
+func DetectOSRelease(release map[string]string) (pkg.PackageManager, error) {
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+	for _, id := range ids {
+		for _, name := range Names() {
+			for _, dist := range backends[pkg.PackageManager(name)].Distributions {
+				if id == dist {
+					return pkg.PackageManager(name), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("No package manager backend for the distribution '%v'", release["ID"])
+}
+
+// readOSRelease will read os-release from the rootfs, which is either a
+// directory or a tarball of one
+func readOSRelease(base string) ([]byte, error) {
+	st, err := os.Stat(base)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		for _, path := range osReleasePaths {
+			data, err := ioutil.ReadFile(filepath.Join(base, path))
+			if err == nil {
+				return data, nil
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		return nil, ErrNoOSRelease
+	}
+	// Let tar handle the compression, and the leading "./" of member names
+	args := []string{"-xOf", base, "--wildcards", "--no-anchored"}
+	for _, path := range osReleasePaths {
+		args = append(args, path)
+	}
+	// Missing members fail tar, but the others are still extracted
+	data, _ := exec.Command("tar", args...).Output()
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrNoOSRelease
+	}
+	return data, nil
+}
+
+// Detect will return the backend for the distribution of the rootfs, either
+// a directory or tarball, or of the host if base is empty. ErrNoOSRelease is
+// returned if the rootfs doesn't say which distribution it is.
+func Detect(base string) (pkg.PackageManager, error) {
+	if base == "" {
+		base = "/"
+	}
+	data, err := readOSRelease(base)
+	if err != nil {
+		return "", err
+	}
+	release, err := ParseOSRelease(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	return DetectOSRelease(release)
+}
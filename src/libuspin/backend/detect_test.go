@@ -0,0 +1,78 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectOSRelease(t *testing.T) {
+	for data, want := range map[string]string{
+		"NAME=\"Rocky Linux\"\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n": "dnf",
+		"ID=ubuntu\nID_LIKE=debian\n":                                          "apt",
+		"ID=\"opensuse-tumbleweed\"\nID_LIKE=\"opensuse suse\"\n":              "zypper",
+		"# Void\nID=\"void\"\n":                                                "xbps",
+		"ID=solus\n":                                                           "eopkg",
+	} {
+		release, err := ParseOSRelease(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to parse os-release: %v", err)
+		}
+		if got, err := DetectOSRelease(release); err != nil || string(got) != want {
+			t.Fatalf("Wrong backend for %q: %v %v", data, got, err)
+		}
+	}
+	if _, err := DetectOSRelease(map[string]string{"ID": "gentoo"}); err == nil {
+		t.Fatalf("Unknown distributions should not be detected")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-detect")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	release := filepath.Join(root, "usr/lib/os-release")
+	os.MkdirAll(filepath.Dir(release), 00755)
+	os.MkdirAll(filepath.Join(root, "etc"), 00755)
+	if err := ioutil.WriteFile(release, []byte("ID=alpine\n"), 00644); err != nil {
+		t.Fatalf("Failed to write os-release: %v", err)
+	}
+	os.Symlink("../usr/lib/os-release", filepath.Join(root, "etc/os-release"))
+	if got, err := Detect(root); err != nil || got != PackageManagerAPK {
+		t.Fatalf("Wrong backend for the directory: %v %v", got, err)
+	}
+
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not available")
+	}
+	tarball := filepath.Join(dir, "base.tar.gz")
+	if out, err := exec.Command("tar", "-czf", tarball, "-C", root, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to create tarball: %v %s", err, out)
+	}
+	if got, err := Detect(tarball); err != nil || got != PackageManagerAPK {
+		t.Fatalf("Wrong backend for the tarball: %v %v", got, err)
+	}
+}
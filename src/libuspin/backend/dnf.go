@@ -0,0 +1,292 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/process"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackageManagerDNF selects the dnf backend, for Fedora based images
+const PackageManagerDNF pkg.PackageManager = "dnf"
+
+// dnfRepoDir holds the repositories enabled within the root. Only these are
+// used, never those of the host.
+const dnfRepoDir = "etc/yum.repos.d"
+
+func init() {
+	Register(&Backend{
+		Name:          PackageManagerDNF,
+		Distributions: []string{"fedora", "rhel", "centos"},
+		NewManager:    NewDNFManager,
+		NewRootQuery: func(root string) (Query, error) {
+			return NewRPMRootQuery(root), nil
+		},
+	})
+}
+
+// A DNFManager installs packages into the root with dnf --installroot
+type DNFManager struct {
+	conf config.SectionDNF
+	arch string // Foreign architecture to install, empty for the host
+	root string
+}
+
+// NewDNFManager will return the dnf manager for the configuration
+func NewDNFManager(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error) {
+	if strings.TrimSpace(conf.DNF.Releasever) == "" {
+		return nil, errors.New("dnf.releasever must be set to use the dnf package manager")
+	}
+	d := &DNFManager{conf: conf.DNF}
+	if conf.Image.Arch != config.HostArch() {
+		d.arch = conf.Image.Arch
+	}
+	return d, nil
+}
+
+// Init will ensure dnf and rpm are available on the host
+func (d *DNFManager) Init() error {
+	for _, bin := range []string{"dnf", "rpm"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InitRoot will prepare the repository directory within the root
+func (d *DNFManager) InitRoot(root string) error {
+	d.root = root
+	return os.MkdirAll(filepath.Join(root, dnfRepoDir), 00755)
+}
+
+// Bootstrap will create the rpm database within the empty root and import
+// the keys of the profile, so that packages are verified against them
+// rather than whatever the host trusts
+func (d *DNFManager) Bootstrap(repos []*spec.OpRepo) error {
+	if err := d.rpm("--initdb"); err != nil {
+		return err
+	}
+	for _, key := range strings.Fields(d.conf.GPGKeys) {
+		if err := d.rpm("--import", strings.TrimPrefix(key, "file://")); err != nil {
+			return fmt.Errorf("Failed to import key %v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// rpm will run rpm against the root
+func (d *DNFManager) rpm(args ...string) error {
+	cmd := exec.Command("rpm", append([]string{"--root", d.root}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// dnfRepo returns the .repo file enabling the repository
+func dnfRepo(conf *config.SectionDNF, identifier, uri string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by USpin\n[%v]\nname=%v\n", identifier, identifier)
+	if strings.Contains(uri, "metalink") {
+		fmt.Fprintf(&buf, "metalink=%v\n", uri)
+	} else {
+		fmt.Fprintf(&buf, "baseurl=%v\n", uri)
+	}
+	fmt.Fprintf(&buf, "enabled=1\n")
+	if conf.GPGKeys != "" {
+		fmt.Fprintf(&buf, "gpgcheck=1\ngpgkey=%v\n", conf.GPGKeys)
+	} else {
+		fmt.Fprintf(&buf, "gpgcheck=0\n")
+	}
+	return buf.Bytes()
+}
+
+// AddRepo will enable the repository within the root
+func (d *DNFManager) AddRepo(identifier, uri string) error {
+	path := filepath.Join(d.root, dnfRepoDir, identifier+".repo")
+	return ioutil.WriteFile(path, dnfRepo(&d.conf, identifier, uri), 00644)
+}
+
+// dnf will run dnf against the root, with only the root's repositories
+func (d *DNFManager) dnf(args ...string) error {
+	cmdArgs := []string{
+		"-y",
+		"--installroot", d.root,
+		"--releasever", d.conf.Releasever,
+		"--setopt=reposdir=" + filepath.Join(d.root, dnfRepoDir),
+		fmt.Sprintf("--setopt=install_weak_deps=%v", d.conf.WeakDeps),
+	}
+	if d.conf.NoDocs {
+		cmdArgs = append(cmdArgs, "--setopt=tsflags=nodocs")
+	}
+	// $basearch within the repositories follows the forced architecture
+	if d.arch != "" {
+		cmdArgs = append(cmdArgs, "--forcearch", d.arch)
+	}
+	cmd := exec.Command("dnf", append(cmdArgs, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// FilterFiles will have rpm leave out documentation. Other classes cannot
+// be filtered by rpm.
+func (d *DNFManager) FilterFiles(classes []config.FileClass) error {
+	for _, class := range classes {
+		if class == config.FileClassDocs {
+			d.conf.NoDocs = true
+		}
+	}
+	return nil
+}
+
+// CheckDatabase will have dnf check the root for broken dependencies and
+// duplicated packages
+func (d *DNFManager) CheckDatabase() error {
+	return d.dnf("check")
+}
+
+// InstallGroups will install the comps groups into the root. Ignoring safety
+// allows dnf to erase conflicting packages.
+func (d *DNFManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	args := []string{"group", "install"}
+	if ignoreSafety {
+		args = append(args, "--allowerasing")
+	}
+	return d.dnf(append(args, groups...)...)
+}
+
+// InstallPackages will install the packages into the root. Ignoring safety
+// allows dnf to erase conflicting packages.
+func (d *DNFManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	args := []string{"install"}
+	if ignoreSafety {
+		args = append(args, "--allowerasing")
+	}
+	return d.dnf(append(args, packages...)...)
+}
+
+// RemovePackages will remove the packages from the root, along with anything
+// depending on them unless ignoreSafety is set
+func (d *DNFManager) RemovePackages(ignoreSafety bool, names []string) error {
+	if !ignoreSafety {
+		return d.dnf(append([]string{"remove"}, names...)...)
+	}
+	cmd := exec.Command("rpm", append([]string{"--root", d.root, "-e", "--nodeps"}, names...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// FinalizeRoot will empty the package cache of the root
+func (d *DNFManager) FinalizeRoot() error {
+	return d.dnf("clean", "all")
+}
+
+// Cleanup does nothing, as dnf leaves no processes behind
+func (d *DNFManager) Cleanup() error {
+	return nil
+}
+
+// RPMQuery answers queries from the rpm database of a populated rootfs
+type RPMQuery struct {
+	root string
+}
+
+// NewRPMRootQuery will answer queries using the rpm database of the rootfs
+func NewRPMRootQuery(root string) *RPMQuery {
+	return &RPMQuery{root: root}
+}
+
+// rpm will query the database of the root and return the output lines
+func (r *RPMQuery) rpm(args ...string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("rpm", append([]string{"--root", r.root, "-q"}, args...)...)
+	cmd.Stderr = &stderr
+	process.Trace(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("rpm -q failed: %v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	var ret []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret, nil
+}
+
+// Close does nothing, as the root is not ours
+func (r *RPMQuery) Close() error {
+	return nil
+}
+
+// InstalledPackages will list all packages installed in the root
+func (r *RPMQuery) InstalledPackages() ([]string, error) {
+	names, err := r.rpm("-a", "--qf", "%{NAME}\\n")
+	if err != nil {
+		return nil, err
+	}
+	// Multilib packages are installed once per architecture
+	sort.Strings(names)
+	var ret []string
+	for i, name := range names {
+		if i == 0 || names[i-1] != name {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// Licenses will report the license expression of the package
+func (r *RPMQuery) Licenses(name string) ([]string, error) {
+	lines, err := r.rpm("--qf", "%{LICENSE}\\n", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return lines[:1], nil
+}
+
+// Version will report the version and release of the package
+func (r *RPMQuery) Version(name string) (string, error) {
+	lines, err := r.rpm("--qf", "%{VERSION}-%{RELEASE}\\n", name)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("Cannot determine version of %v", name)
+	}
+	return lines[0], nil
+}
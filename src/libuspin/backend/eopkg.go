@@ -0,0 +1,409 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"io"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/process"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	Register(&Backend{
+		Name:          pkg.PackageManagerEopkg,
+		Distributions: []string{"solus"},
+		NewManager:    NewEopkgManager,
+		NewQuery: func(repos []*spec.OpRepo) (Query, error) {
+			return NewEopkgQuery(repos)
+		},
+		NewRootQuery: func(root string) (Query, error) {
+			return NewEopkgRootQuery(root), nil
+		},
+	})
+}
+
+// EopkgQuery uses a scratch eopkg database to answer queries about the
+// repositories, so that the host database is never touched. It may also be
+// pointed at an already populated rootfs.
+type EopkgQuery struct {
+	root    string
+	scratch bool // Whether we own root and must remove it
+}
+
+// NewEopkgQuery will create a scratch root and enable the given repos within it
+func NewEopkgQuery(repos []*spec.OpRepo) (*EopkgQuery, error) {
+	root, err := ioutil.TempDir("", "uspin-query")
+	if err != nil {
+		return nil, err
+	}
+	e := &EopkgQuery{root: root, scratch: true}
+	for _, repo := range repos {
+		if _, err := e.eopkg("add-repo", repo.RepoName, repo.RepoURI); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// NewEopkgRootQuery will answer queries using the database of the given rootfs
+func NewEopkgRootQuery(root string) *EopkgQuery {
+	return &EopkgQuery{root: root}
+}
+
+// eopkg will run eopkg against the scratch root and return the output
+func (e *EopkgQuery) eopkg(args ...string) ([]byte, error) {
+	cmdArgs := append([]string{"-D", e.root, "-N", "-y"}, args...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("eopkg", cmdArgs...)
+	cmd.Stderr = &stderr
+	process.Trace(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("eopkg %v failed: %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// nameList will run the eopkg listing command and return the package names
+func (e *EopkgQuery) nameList(args ...string) ([]string, error) {
+	out, err := e.eopkg(args...)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// Lines look like "name - summary", repo headings end with ':'
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		ret = append(ret, strings.Fields(line)[0])
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// infoField will return the whitespace separated values of the given field
+// from the "eopkg info" output for a package, i.e. "Dependencies"
+func (e *EopkgQuery) infoField(name, field string) ([]string, error) {
+	out, err := e.eopkg("info", name)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), ":", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == field {
+			return strings.Fields(fields[1]), nil
+		}
+	}
+	return nil, nil
+}
+
+// ExpandGroup will list all packages available in the named component
+func (e *EopkgQuery) ExpandGroup(name string) ([]string, error) {
+	return e.nameList("list-available", "-c", name)
+}
+
+// Components will list all available components
+func (e *EopkgQuery) Components() ([]string, error) {
+	return e.nameList("list-components")
+}
+
+// InstalledPackages will list all packages installed in the root
+func (e *EopkgQuery) InstalledPackages() ([]string, error) {
+	return e.nameList("list-installed")
+}
+
+// Dependencies will parse the dependencies of the package from "eopkg info"
+func (e *EopkgQuery) Dependencies(name string) ([]string, error) {
+	deps, err := e.infoField(name, "Dependencies")
+	if err != nil {
+		return nil, err
+	}
+	for i, dep := range deps {
+		// Strip any version constraints, i.e. glibc{>= 2.24}
+		if idx := strings.IndexAny(dep, "{("); idx > 0 {
+			deps[i] = dep[:idx]
+		}
+	}
+	sort.Strings(deps)
+	return deps, nil
+}
+
+// Licenses will parse the licenses of the package from "eopkg info"
+func (e *EopkgQuery) Licenses(name string) ([]string, error) {
+	return e.infoField(name, "Licenses")
+}
+
+// Version will parse the version and release of the package from "eopkg info"
+func (e *EopkgQuery) Version(name string) (string, error) {
+	// Name                : nano, version: 2.7.1, release: 60
+	fields, err := e.infoField(name, "Name")
+	if err != nil {
+		return "", err
+	}
+	if len(fields) < 5 {
+		return "", fmt.Errorf("Cannot determine version of %v", name)
+	}
+	return strings.TrimSuffix(fields[2], ",") + "-" + fields[4], nil
+}
+
+const (
+	// eopkgCacheDir holds the packages fetched into a root
+	eopkgCacheDir = "var/cache/eopkg/packages"
+
+	// eopkgIndexDir holds the decompressed index of each repository
+	eopkgIndexDir = "var/lib/eopkg/index"
+)
+
+// eopkgIndex is the portion of a repository index needed to find the origin
+// of a package file
+type eopkgIndex struct {
+	Packages []struct {
+		URI string `xml:"PackageURI"`
+	} `xml:"Package"`
+}
+
+// repos will return the enabled repositories mapped to their index URIs
+func (e *EopkgQuery) repos() (map[string]string, error) {
+	out, err := e.eopkg("list-repo")
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string)
+	var name string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// Lines look like "Solus [active]", followed by the indented URI
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name = fields[0]
+		} else if name != "" {
+			ret[name] = fields[0]
+		}
+	}
+	return ret, nil
+}
+
+// Downloads will list the repository indexes and the packages left in the
+// eopkg cache of the root, resolving each package to its URL through the
+// stored repository indexes.
+func (e *EopkgQuery) Downloads() ([]*Download, error) {
+	repos, err := e.repos()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Download
+	origins := make(map[string]string)
+	for name, uri := range repos {
+		ret = append(ret, &Download{URL: uri})
+		idx, err := e.index(name)
+		if err != nil {
+			return nil, err
+		}
+		base := uri[:strings.LastIndex(uri, "/")+1]
+		for _, p := range idx.Packages {
+			origins[path.Base(p.URI)] = base + p.URI
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(e.root, eopkgCacheDir, "*.eopkg"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		d, err := digestFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if d.URL = origins[filepath.Base(file)]; d.URL == "" {
+			d.URL = filepath.Base(file)
+		}
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].URL < ret[j].URL })
+	return ret, nil
+}
+
+// index will load the stored index of the named repository, which may be
+// empty if it was never fetched
+func (e *EopkgQuery) index(name string) (*eopkgIndex, error) {
+	idx := &eopkgIndex{}
+	fi, err := os.Open(filepath.Join(e.root, eopkgIndexDir, name, "eopkg-index.xml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	defer fi.Close()
+	if err := xml.NewDecoder(fi).Decode(idx); err != nil {
+		return nil, fmt.Errorf("Invalid index for repository %v: %v", name, err)
+	}
+	return idx, nil
+}
+
+// digestFile will compute the size and SHA256 of a downloaded file
+func digestFile(path string) (*Download, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, fi)
+	if err != nil {
+		return nil, err
+	}
+	return &Download{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Close will remove the scratch root, if we created one
+func (e *EopkgQuery) Close() error {
+	if !e.scratch {
+		return nil
+	}
+	return os.RemoveAll(e.root)
+}
+
+// EopkgManager adds package removal to the libosdev eopkg manager, which only
+// knows how to install
+type EopkgManager struct {
+	pkg.Manager
+	root string
+}
+
+// NewEopkgManager will return the eopkg manager
+func NewEopkgManager(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error) {
+	manager, err := pkg.NewManager(pkg.PackageManagerEopkg)
+	if err != nil {
+		return nil, err
+	}
+	return &EopkgManager{Manager: manager}, nil
+}
+
+// InitRoot will remember the root so that packages can be removed from it
+func (e *EopkgManager) InitRoot(root string) error {
+	e.root = root
+	return e.Manager.InitRoot(root)
+}
+
+// Bootstrap will install the baselayout into the empty root, so that the
+// configuration of later packages finds the filesystem it expects. The
+// repositories are only enabled for the duration, as they are added again
+// by the operations of the profile.
+func (e *EopkgManager) Bootstrap(repos []*spec.OpRepo) error {
+	if len(repos) == 0 {
+		return ErrNoBootstrapRepo
+	}
+	var added []string
+	defer func() {
+		for _, name := range added {
+			e.eopkg("remove-repo", name)
+		}
+	}()
+	for _, repo := range repos {
+		name := "uspin-bootstrap-" + repo.RepoName
+		if err := e.eopkg("add-repo", name, repo.RepoURI); err != nil {
+			return err
+		}
+		added = append(added, name)
+	}
+	return e.eopkg("install", "--ignore-comar", "baselayout")
+}
+
+// eopkg will run eopkg against the root
+func (e *EopkgManager) eopkg(args ...string) error {
+	cmd := exec.Command("eopkg", append([]string{"-D", e.root, "-N", "-y"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// CheckDatabase will have eopkg check the files of every installed package.
+// Problems are only reported in the output, never the exit status.
+func (e *EopkgManager) CheckDatabase() error {
+	cmd := exec.Command("eopkg", "-D", e.root, "-N", "check")
+	process.Trace(cmd)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("eopkg check failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if problems := eopkgCheckProblems(out); len(problems) > 0 {
+		return &DatabaseError{Problems: problems}
+	}
+	return nil
+}
+
+// eopkgCheckProblems returns the missing and corrupted files reported in the
+// output of eopkg check, by package. Modified configuration files are expected.
+func eopkgCheckProblems(out []byte) []*DatabaseProblem {
+	var ret []*DatabaseProblem
+	var pkg string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "Checking integrity of ") {
+			if fields := strings.Fields(strings.TrimPrefix(line, "Checking integrity of ")); len(fields) > 0 {
+				pkg = fields[0]
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "Missing file:") || strings.HasPrefix(line, "Corrupted file:") {
+			ret = append(ret, &DatabaseProblem{Package: pkg, Message: line})
+		}
+	}
+	return ret
+}
+
+// PackageCache returns the directory of packages fetched into the root
+func (e *EopkgManager) PackageCache() string {
+	return eopkgCacheDir
+}
+
+// RemovePackages will remove the packages from the root, along with anything
+// depending on them unless ignoreSafety is set
+func (e *EopkgManager) RemovePackages(ignoreSafety bool, names []string) error {
+	args := []string{"remove"}
+	if ignoreSafety {
+		args = append(args, "--ignore-dependency", "--ignore-safety")
+	}
+	return e.eopkg(append(args, names...)...)
+}
@@ -0,0 +1,245 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package backend provides the package managers USpin can build with, found
+// by name in a registry. Each also provides read-only access to repositories,
+// allowing USpin to ask questions of the backend (such as the contents of a
+// group) without installing anything into a rootfs.
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"libuspin/config"
+	"libuspin/spec"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnsupportedQuery is returned when the backend cannot answer a query
+	ErrUnsupportedQuery = errors.New("Query not supported by this package manager")
+
+	// ErrNoBootstrapRepo is returned when bootstrapping needs a repository but
+	// the profile has none
+	ErrNoBootstrapRepo = errors.New("Bootstrapping an empty root needs a repository")
+)
+
+// A Query is a read-only view onto the repositories of a package manager.
+// Implementations provide their capabilities through the optional interfaces
+// in this package, i.e. GroupExpander and DependencyResolver.
+type Query interface {
+
+	// Close will tear down any scratch state the query needed
+	Close() error
+}
+
+// A GroupExpander can resolve a group or component into the concrete packages
+// that would be installed by it.
+type GroupExpander interface {
+
+	// ExpandGroup returns the sorted package names contained in the named group
+	ExpandGroup(name string) ([]string, error)
+}
+
+// A ComponentLister can list every available component, so that the children
+// of a component can be found
+type ComponentLister interface {
+
+	// Components returns the sorted names of all available components
+	Components() ([]string, error)
+}
+
+// A DependencyResolver can report the direct runtime dependencies of a package
+type DependencyResolver interface {
+
+	// Dependencies returns the sorted names of the packages that the named
+	// package directly depends on
+	Dependencies(name string) ([]string, error)
+}
+
+// A PackageLister can list the packages installed in a rootfs
+type PackageLister interface {
+
+	// InstalledPackages returns the sorted names of all installed packages
+	InstalledPackages() ([]string, error)
+}
+
+// A LicenseReporter can report the license identifiers of a package
+type LicenseReporter interface {
+
+	// Licenses returns the license identifiers declared by the named package
+	Licenses(name string) ([]string, error)
+}
+
+// A VersionReporter can report the version of a package
+type VersionReporter interface {
+
+	// Version returns the full version of the named package, including any
+	// release number, i.e. "2.7.1-60"
+	Version(name string) (string, error)
+}
+
+// An OriginReporter can report the repository a package was installed from
+type OriginReporter interface {
+
+	// Origin returns the repository the named package was installed from,
+	// or an empty string when the package database does not record it
+	Origin(name string) (string, error)
+}
+
+// A Download is a file fetched by the package manager during a build
+type Download struct {
+	URL    string `json:"url"`              // Only the filename when the origin is unknown
+	Size   int64  `json:"size,omitempty"`   // Unset for repository indexes, which change
+	SHA256 string `json:"sha256,omitempty"` // Unset for repository indexes, which change
+}
+
+// A DownloadReporter can list everything fetched into a populated rootfs
+type DownloadReporter interface {
+
+	// Downloads returns the repository indexes and packages fetched, sorted by URL
+	Downloads() ([]*Download, error)
+}
+
+// A PackageCacher is a manager which keeps the packages it downloads within
+// the root until FinalizeRoot, so they can be shared with later builds
+type PackageCacher interface {
+
+	// PackageCache returns the directory of downloaded packages, relative
+	// to the root
+	PackageCache() string
+}
+
+// A DatabaseChecker is a manager which can verify the consistency of the
+// package database within the root once everything has been installed
+type DatabaseChecker interface {
+
+	// CheckDatabase returns an error if any installed package has broken
+	// dependencies or is missing files, a *DatabaseError where the problems
+	// can be attributed to packages
+	CheckDatabase() error
+}
+
+// A DatabaseProblem is a damaged file or broken dependency of a package
+type DatabaseProblem struct {
+	Package string
+	Message string
+}
+
+func (p *DatabaseProblem) String() string {
+	return p.Package + ": " + p.Message
+}
+
+// A DatabaseError is returned by CheckDatabase with every problem found
+type DatabaseError struct {
+	Problems []*DatabaseProblem
+}
+
+func (e *DatabaseError) Error() string {
+	var problems []string
+	for _, p := range e.Problems {
+		problems = append(problems, p.String())
+	}
+	return fmt.Sprintf("%v problems found: %v", len(e.Problems), strings.Join(problems, ", "))
+}
+
+// A FileFilter is a manager which can leave classes of files out while
+// installing packages, keeping its database consistent. Files it cannot
+// filter are removed from the image afterwards instead.
+type FileFilter interface {
+
+	// FilterFiles will leave files of the classes out of every package
+	// installed from now on
+	FilterFiles(classes []config.FileClass) error
+}
+
+// A Bootstrapper is a manager which must create the base system of an empty
+// root, along with the keys trusted by its package manager, before any
+// packages can be installed. Roots built upon a seed are never bootstrapped.
+type Bootstrapper interface {
+
+	// Bootstrap will create the base system within the root passed to
+	// InitRoot, from the repositories of the profile in order
+	Bootstrap(repos []*spec.OpRepo) error
+}
+
+// A RepoRefresher is a manager whose AddRepo does not fetch the metadata of
+// the repository. The metadata of each repository is fetched separately by
+// RefreshRepos instead, so that several may be refreshed at once.
+type RepoRefresher interface {
+
+	// RefreshRepos will fetch the metadata of the named repositories, which
+	// have already been added
+	RefreshRepos(identifiers []string) error
+}
+
+// refreshParallel will call refresh for every repository, with at most jobs
+// running at once. The error of the first repository to fail, in the order
+// given, is returned once all have finished.
+func refreshParallel(identifiers []string, jobs int, refresh func(identifier string) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, len(identifiers))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, identifier := range identifiers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, identifier string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := refresh(identifier); err != nil {
+				errs[i] = fmt.Errorf("Failed to refresh repository %v: %v", identifier, err)
+			}
+		}(i, identifier)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewQuery will return a Query for the given package manager, with the
+// repositories already enabled.
+func NewQuery(pkgType pkg.PackageManager, repos []*spec.OpRepo) (Query, error) {
+	b, err := Lookup(pkgType)
+	if err != nil {
+		return nil, err
+	}
+	if b.NewQuery == nil {
+		return nil, ErrUnsupportedQuery
+	}
+	return b.NewQuery(repos)
+}
+
+// NewRootQuery will return a Query answered by the package manager database
+// within an existing rootfs, using the repositories already enabled there.
+func NewRootQuery(pkgType pkg.PackageManager, root string) (Query, error) {
+	b, err := Lookup(pkgType)
+	if err != nil {
+		return nil, err
+	}
+	if b.NewRootQuery == nil {
+		return nil, ErrUnsupportedQuery
+	}
+	return b.NewRootQuery(root)
+}
@@ -0,0 +1,77 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"libuspin/config"
+	"libuspin/spec"
+	"sort"
+	"strings"
+)
+
+// A Backend is a package manager which USpin can build images with. Backends
+// register themselves by name, and are selected with image.package_manager.
+type Backend struct {
+	Name pkg.PackageManager
+
+	// Distributions are the os-release IDs of the distributions using the
+	// backend, i.e. "fedora", used to detect the backend of a base rootfs
+	Distributions []string
+
+	// NewManager returns the manager used to install packages into a rootfs.
+	// Relative paths within the configuration are resolved against baseDir.
+	NewManager func(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error)
+
+	// NewQuery returns a Query with the given repositories enabled, and may
+	// be nil if unsupported
+	NewQuery func(repos []*spec.OpRepo) (Query, error)
+
+	// NewRootQuery returns a Query answered from an existing rootfs, and may
+	// be nil if unsupported
+	NewRootQuery func(root string) (Query, error)
+}
+
+var backends = make(map[pkg.PackageManager]*Backend)
+
+// Register will make the backend available by name. This is intended to be
+// called from init, and so panics on duplicate names.
+func Register(b *Backend) {
+	if _, ok := backends[b.Name]; ok {
+		panic("Duplicate package manager backend: " + string(b.Name))
+	}
+	backends[b.Name] = b
+}
+
+// Lookup will return the registered backend with the given name
+func Lookup(name pkg.PackageManager) (*Backend, error) {
+	if b, ok := backends[name]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("Unknown package manager '%v', available: %v", name, strings.Join(Names(), ", "))
+}
+
+// Names returns the sorted names of all registered backends
+func Names() []string {
+	var ret []string
+	for name := range backends {
+		ret = append(ret, string(name))
+	}
+	sort.Strings(ret)
+	return ret
+}
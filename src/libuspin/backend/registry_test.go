@@ -0,0 +1,109 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"errors"
+	"github.com/solus-project/libosdev/pkg"
+	"libuspin/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{"eopkg", "dnf"} {
+		if _, err := Lookup(pkg.PackageManager(name)); err != nil {
+			t.Fatalf("Backend %v should be registered: %v", name, err)
+		}
+	}
+	_, err := Lookup("pacman")
+	if err == nil || !strings.Contains(err.Error(), "apk, apt, dnf, eopkg, xbps, zypper") {
+		t.Fatalf("Unknown backends should list the available ones: %v", err)
+	}
+	if _, err := NewQuery(PackageManagerDNF, nil); err != ErrUnsupportedQuery {
+		t.Fatalf("Expected an unsupported query: %v", err)
+	}
+}
+
+func TestDNFRepo(t *testing.T) {
+	conf := &config.ImageConfiguration{}
+	if _, err := NewDNFManager(conf, ""); err == nil {
+		t.Fatalf("dnf.releasever should be required")
+	}
+	repo := string(dnfRepo(&conf.DNF, "fedora", "https://example.com/metalink?repo=fedora-$releasever"))
+	for _, want := range []string{"[fedora]\n", "metalink=https://example.com/", "gpgcheck=0\n"} {
+		if !strings.Contains(repo, want) {
+			t.Fatalf("Repo is missing %q:\n%v", want, repo)
+		}
+	}
+	conf.DNF.GPGKeys = "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora"
+	repo = string(dnfRepo(&conf.DNF, "local", "file:///srv/repo"))
+	for _, want := range []string{"baseurl=file:///srv/repo\n", "gpgcheck=1\ngpgkey=file:///etc/pki/"} {
+		if !strings.Contains(repo, want) {
+			t.Fatalf("Repo is missing %q:\n%v", want, repo)
+		}
+	}
+}
+
+func TestEopkgCheckProblems(t *testing.T) {
+	out := `Checking integrity of bash                          OK
+Checking integrity of nano
+Modified configuration file: /etc/nanorc
+Missing file: /usr/bin/nano
+Corrupted file: /usr/share/nano/c.nanorc
+Broken
+`
+	problems := eopkgCheckProblems([]byte(out))
+	if len(problems) != 2 || problems[0].String() != "nano: Missing file: /usr/bin/nano" || problems[1].Package != "nano" {
+		t.Fatalf("Wrong problems: %v", problems)
+	}
+}
+
+func TestRefreshParallel(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	var refreshed []string
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	err := refreshParallel(names, 2, func(identifier string) error {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		refreshed = append(refreshed, identifier)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if identifier == "c" || identifier == "e" {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	if most > 2 {
+		t.Fatalf("Too many repositories refreshed at once: %v", most)
+	}
+	if len(refreshed) != len(names) {
+		t.Fatalf("Every repository should be refreshed: %v", refreshed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "repository c") {
+		t.Fatalf("The first failing repository should be reported: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"sort"
+)
+
+// A TransactionKind is the package manager call a Transaction records
+type TransactionKind string
+
+const (
+	// TransactionAddRepo records AddRepo
+	TransactionAddRepo TransactionKind = "add-repo"
+
+	// TransactionInstallGroups records InstallGroups
+	TransactionInstallGroups TransactionKind = "install-groups"
+
+	// TransactionInstallPackages records InstallPackages
+	TransactionInstallPackages TransactionKind = "install-packages"
+
+	// TransactionRemovePackages records RemovePackages
+	TransactionRemovePackages TransactionKind = "remove-packages"
+)
+
+// A Transaction is a single call made of a ResolveOnly manager
+type Transaction struct {
+	Kind         TransactionKind `json:"kind"`
+	IgnoreSafety bool            `json:"ignoreSafety,omitempty"`
+	Names        []string        `json:"names"`
+	URI          string          `json:"uri,omitempty"`      // Only set when adding a repo
+	Packages     []string        `json:"packages,omitempty"` // Groups expanded by the query, if any
+}
+
+// ResolveOnly is a package manager which records what it is asked to do
+// instead of touching a rootfs, so that the order, batching and safety flags
+// of a build can be exercised and reported. Groups are expanded when the
+// Query is a GroupExpander, failing as the build would for unknown groups.
+type ResolveOnly struct {
+	Query        Query // Optional, resolves groups and components
+	Transactions []*Transaction
+}
+
+// NewResolveOnly will return a ResolveOnly manager answering from the query,
+// which may be nil to record the operations without resolving anything
+func NewResolveOnly(query Query) *ResolveOnly {
+	return &ResolveOnly{Query: query}
+}
+
+// record will store the transaction
+func (r *ResolveOnly) record(t *Transaction) {
+	r.Transactions = append(r.Transactions, t)
+}
+
+// Init does nothing, there is no package manager to initialise
+func (r *ResolveOnly) Init() error {
+	return nil
+}
+
+// InitRoot does nothing, as no rootfs is used
+func (r *ResolveOnly) InitRoot(root string) error {
+	return nil
+}
+
+// FinalizeRoot does nothing, as no rootfs is used
+func (r *ResolveOnly) FinalizeRoot() error {
+	return nil
+}
+
+// AddRepo will record the repository
+func (r *ResolveOnly) AddRepo(identifier, uri string) error {
+	r.record(&Transaction{Kind: TransactionAddRepo, Names: []string{identifier}, URI: uri})
+	return nil
+}
+
+// InstallGroups will record the groups, expanding them if possible
+func (r *ResolveOnly) InstallGroups(ignoreSafety bool, groups []string) error {
+	t := &Transaction{Kind: TransactionInstallGroups, IgnoreSafety: ignoreSafety, Names: groups}
+	if expander, ok := r.Query.(GroupExpander); ok {
+		seen := make(map[string]bool)
+		for _, group := range groups {
+			pkgs, err := expander.ExpandGroup(group)
+			if err != nil {
+				return err
+			}
+			for _, p := range pkgs {
+				if !seen[p] {
+					seen[p] = true
+					t.Packages = append(t.Packages, p)
+				}
+			}
+		}
+		sort.Strings(t.Packages)
+	}
+	r.record(t)
+	return nil
+}
+
+// InstallPackages will record the packages
+func (r *ResolveOnly) InstallPackages(ignoreSafety bool, packages []string) error {
+	r.record(&Transaction{Kind: TransactionInstallPackages, IgnoreSafety: ignoreSafety, Names: packages})
+	return nil
+}
+
+// RemovePackages will record the packages
+func (r *ResolveOnly) RemovePackages(ignoreSafety bool, packages []string) error {
+	r.record(&Transaction{Kind: TransactionRemovePackages, IgnoreSafety: ignoreSafety, Names: packages})
+	return nil
+}
+
+// Components will list the components known to the query
+func (r *ResolveOnly) Components() ([]string, error) {
+	lister, ok := r.Query.(ComponentLister)
+	if !ok {
+		return nil, ErrUnsupportedQuery
+	}
+	return lister.Components()
+}
+
+// Cleanup does nothing, as nothing was touched
+func (r *ResolveOnly) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,518 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"encoding/xml"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/pkg"
+	"io"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/process"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// PackageManagerXBPS selects the xbps backend, for Void Linux based images
+	PackageManagerXBPS pkg.PackageManager = "xbps"
+
+	// xbpsConfDir holds the configuration files read by xbps within the root
+	xbpsConfDir = "etc/xbps.d"
+
+	// xbpsKeysDir holds the repository keys trusted within the root
+	xbpsKeysDir = "var/db/xbps/keys"
+
+	// xbpsPkgDB is the database of installed packages within the root
+	xbpsPkgDB = "var/db/xbps/pkgdb-0.38.plist"
+
+	// xbpsVirtualConf selects the providers of virtual packages
+	xbpsVirtualConf = "20-uspin-virtualpkg.conf"
+
+	// xbpsCacheDir holds the packages fetched into the root
+	xbpsCacheDir = "var/cache/xbps"
+)
+
+func init() {
+	Register(&Backend{
+		Name:          PackageManagerXBPS,
+		Distributions: []string{"void"},
+		NewManager:    NewXBPSManager,
+		NewRootQuery: func(root string) (Query, error) {
+			return NewXBPSRootQuery(root), nil
+		},
+	})
+}
+
+// An XBPSManager installs packages into the root with xbps-install -r.
+//
+// Unknown repository keys are imported automatically unless the safety
+// policy is enforced, in which case only the keys of the profile are trusted.
+type XBPSManager struct {
+	arch    string
+	keys    []string
+	virtual map[string]string
+	safety  config.SafetyPolicy
+	root    string
+	repos   map[string]string // Repository URIs by name
+	jobs    int               // Repositories refreshed at once
+	synced  bool              // Repository indexes are current
+}
+
+// NewXBPSManager will return the xbps manager for the configuration
+func NewXBPSManager(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error) {
+	x := &XBPSManager{
+		arch:    conf.XBPS.Arch,
+		virtual: conf.XBPS.Virtual,
+		safety:  conf.Safety.Policy,
+		jobs:    conf.Image.RefreshJobs,
+	}
+	if x.arch == "" {
+		x.arch = conf.Image.Arch
+	}
+	for _, key := range conf.XBPS.Keys {
+		if !filepath.IsAbs(key) {
+			key = filepath.Join(baseDir, key)
+		}
+		if filepath.Ext(key) != ".plist" {
+			return nil, fmt.Errorf("xbps keys must be .plist files: %v", key)
+		}
+		x.keys = append(x.keys, key)
+	}
+	for name, provider := range x.virtual {
+		if provider == "" {
+			return nil, fmt.Errorf("No provider for the virtual package: %v", name)
+		}
+	}
+	return x, nil
+}
+
+// Init will ensure xbps is available on the host
+func (x *XBPSManager) Init() error {
+	for _, bin := range []string{"xbps-install", "xbps-remove"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run will run the xbps command against the root. Prompts read from stdin,
+// so are declined unless -y is passed.
+func (x *XBPSManager) run(name string, args ...string) error {
+	cmd := exec.Command(name, append([]string{"-r", x.root}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if x.arch != "" {
+		cmd.Env = append(os.Environ(), "XBPS_ARCH="+x.arch)
+	}
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// InitRoot will install the keys of the profile and configuration of the
+// virtual packages, with only the repositories of the profile enabled
+func (x *XBPSManager) InitRoot(root string) error {
+	x.root = root
+	x.repos = make(map[string]string)
+	x.synced = false
+	confDir := filepath.Join(root, xbpsConfDir)
+	if err := os.MkdirAll(confDir, 00755); err != nil {
+		return err
+	}
+	// Reused roots would otherwise keep stale repositories
+	repos, err := filepath.Glob(filepath.Join(confDir, "00-repository-*.conf"))
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if err := os.Remove(repo); err != nil {
+			return err
+		}
+	}
+	if err := x.installKeys(); err != nil {
+		return err
+	}
+	if x.safety == config.SafetyWarn {
+		log.WithFields(log.Fields{
+			"policy": x.safety,
+		}).Warning("Repository keys will be imported without verification")
+	}
+	return ioutil.WriteFile(filepath.Join(confDir, xbpsVirtualConf), xbpsVirtual(x.virtual), 00644)
+}
+
+// installKeys will install the trusted keys into the root, which are used to
+// verify the repositories and allow the image to verify its own updates
+func (x *XBPSManager) installKeys() error {
+	keysDir := filepath.Join(x.root, xbpsKeysDir)
+	if err := os.MkdirAll(keysDir, 00755); err != nil {
+		return err
+	}
+	for _, key := range x.keys {
+		data, err := ioutil.ReadFile(key)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(keysDir, filepath.Base(key)), data, 00644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xbpsVirtual returns the configuration selecting the provider of each
+// virtual package, used when resolving both requests and dependencies
+func xbpsVirtual(virtual map[string]string) []byte {
+	var names []string
+	for name := range virtual {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		buf = append(buf, fmt.Sprintf("virtualpkg=%v:%v\n", name, virtual[name])...)
+	}
+	return buf
+}
+
+// AddRepo will enable the repository within the root
+func (x *XBPSManager) AddRepo(identifier, uri string) error {
+	path := filepath.Join(x.root, xbpsConfDir, "00-repository-"+identifier+".conf")
+	x.repos[identifier] = uri
+	x.synced = false
+	return ioutil.WriteFile(path, []byte("repository="+uri+"\n"), 00644)
+}
+
+// syncArgs returns the xbps-install arguments fetching the repository
+// indexes, importing the keys of unknown repositories unless safety is
+// enforced
+func (x *XBPSManager) syncArgs() []string {
+	args := []string{"-S"}
+	if x.safety != config.SafetyEnforce {
+		args = append(args, "-y")
+	}
+	return args
+}
+
+// sync will fetch the indexes of every repository
+func (x *XBPSManager) sync() error {
+	if x.synced {
+		return nil
+	}
+	if err := x.run("xbps-install", x.syncArgs()...); err != nil {
+		return err
+	}
+	x.synced = true
+	return nil
+}
+
+// RefreshRepos will fetch the index of each repository separately, as each
+// is stored apart from the others
+func (x *XBPSManager) RefreshRepos(identifiers []string) error {
+	err := refreshParallel(identifiers, x.jobs, func(identifier string) error {
+		uri, ok := x.repos[identifier]
+		if !ok {
+			return fmt.Errorf("Unknown repository: %v", identifier)
+		}
+		return x.run("xbps-install", append(x.syncArgs(), "--ignore-conf-repos", "--repository", uri)...)
+	})
+	if err != nil {
+		return err
+	}
+	x.synced = true
+	return nil
+}
+
+// InstallGroups always fails, as xbps has no groups
+func (x *XBPSManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	return ErrNoGroups
+}
+
+// installArgs returns the xbps-install arguments installing the names.
+// Ignoring safety allows packages to overwrite files owned by others.
+func (x *XBPSManager) installArgs(ignoreSafety bool, names []string) []string {
+	args := []string{"-y"}
+	if ignoreSafety {
+		args = append(args, "--ignore-file-conflicts")
+	}
+	return append(args, names...)
+}
+
+// InstallPackages will install the packages into the root. Virtual packages
+// are installed using the provider from the xbps.virtual table.
+func (x *XBPSManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	if err := x.sync(); err != nil {
+		return err
+	}
+	return x.run("xbps-install", x.installArgs(ignoreSafety, packages)...)
+}
+
+// RemovePackages will remove the packages from the root, which fails while
+// other packages depend on them unless ignoreSafety is set
+func (x *XBPSManager) RemovePackages(ignoreSafety bool, names []string) error {
+	args := []string{"-y"}
+	if ignoreSafety {
+		args = append(args, "--force-revdeps")
+	}
+	return x.run("xbps-remove", append(args, names...)...)
+}
+
+// CheckDatabase will have xbps check every installed package for missing
+// files and dependencies
+func (x *XBPSManager) CheckDatabase() error {
+	return x.run("xbps-pkgdb", "-a")
+}
+
+// PackageCache returns the directory of packages fetched into the root
+func (x *XBPSManager) PackageCache() string {
+	return xbpsCacheDir
+}
+
+// FinalizeRoot will empty the package cache of the root
+func (x *XBPSManager) FinalizeRoot() error {
+	cache := filepath.Join(x.root, xbpsCacheDir)
+	entries, err := ioutil.ReadDir(cache)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(cache, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup does nothing, as xbps leaves no processes behind
+func (x *XBPSManager) Cleanup() error {
+	return nil
+}
+
+// xbpsPackage is an entry of the package database
+type xbpsPackage struct {
+	Name     string
+	Version  string
+	Licenses []string
+	Depends  []string
+	Origin   string
+}
+
+// XBPSQuery answers queries by reading the package database of a rootfs,
+// which needs no xbps on the host
+type XBPSQuery struct {
+	root     string
+	packages map[string]*xbpsPackage
+}
+
+// NewXBPSRootQuery will answer queries using the database of the rootfs
+func NewXBPSRootQuery(root string) *XBPSQuery {
+	return &XBPSQuery{root: root}
+}
+
+// plistValue will decode the plist value starting at start, as a string,
+// bool, []interface{} or map[string]interface{}
+func plistValue(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict", "array":
+		dict := make(map[string]interface{})
+		var array []interface{}
+		var key string
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := d.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				value, err := plistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				if start.Name.Local == "array" {
+					array = append(array, value)
+				} else {
+					dict[key] = value
+				}
+			case xml.EndElement:
+				if start.Name.Local == "array" {
+					return array, nil
+				}
+				return dict, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", d.Skip()
+	default:
+		var s string
+		err := d.DecodeElement(&s, &start)
+		return s, err
+	}
+}
+
+// decodePlist will decode the top level value of the property list
+func decodePlist(r io.Reader) (interface{}, error) {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := tok.(xml.StartElement); ok && t.Name.Local != "plist" {
+			return plistValue(d, t)
+		}
+	}
+}
+
+// xbpsDependName returns the package name of a dependency pattern, which is
+// either constrained, i.e. "glibc>=2.36_1", or an exact "glibc-2.36_1"
+func xbpsDependName(pattern string) string {
+	if i := strings.IndexAny(pattern, "<>="); i > 0 {
+		return pattern[:i]
+	}
+	if i := strings.LastIndex(pattern, "-"); i > 0 && strings.Contains(pattern[i:], "_") {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// load will parse the package database, a dictionary of the packages by name
+func (x *XBPSQuery) load() error {
+	if x.packages != nil {
+		return nil
+	}
+	fi, err := os.Open(filepath.Join(x.root, xbpsPkgDB))
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	db, err := decodePlist(fi)
+	if err != nil {
+		return fmt.Errorf("Failed to parse %v: %v", xbpsPkgDB, err)
+	}
+	entries, ok := db.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Unexpected format of %v", xbpsPkgDB)
+	}
+	x.packages = make(map[string]*xbpsPackage)
+	for name, entry := range entries {
+		props, ok := entry.(map[string]interface{})
+		// Internal entries such as alternatives have no package version
+		if !ok || props["state"] != "installed" {
+			continue
+		}
+		pkgver, _ := props["pkgver"].(string)
+		p := &xbpsPackage{Name: name, Version: strings.TrimPrefix(pkgver, name+"-")}
+		p.Origin, _ = props["repository"].(string)
+		if license, ok := props["license"].(string); ok {
+			for _, id := range strings.Split(license, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					p.Licenses = append(p.Licenses, id)
+				}
+			}
+		}
+		depends, _ := props["run_depends"].([]interface{})
+		for _, dep := range depends {
+			if pattern, ok := dep.(string); ok {
+				p.Depends = append(p.Depends, xbpsDependName(pattern))
+			}
+		}
+		x.packages[name] = p
+	}
+	return nil
+}
+
+// get will return the named installed package
+func (x *XBPSQuery) get(name string) (*xbpsPackage, error) {
+	if err := x.load(); err != nil {
+		return nil, err
+	}
+	p, ok := x.packages[name]
+	if !ok {
+		return nil, fmt.Errorf("Package is not installed: %v", name)
+	}
+	return p, nil
+}
+
+// Close does nothing, as the root is not ours
+func (x *XBPSQuery) Close() error {
+	return nil
+}
+
+// InstalledPackages will list all packages installed in the root
+func (x *XBPSQuery) InstalledPackages() ([]string, error) {
+	if err := x.load(); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for name := range x.packages {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Version will report the version and revision of the package, i.e. "5.2.21_1"
+func (x *XBPSQuery) Version(name string) (string, error) {
+	p, err := x.get(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Version, nil
+}
+
+// Licenses will report the license identifiers of the package
+func (x *XBPSQuery) Licenses(name string) ([]string, error) {
+	p, err := x.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Licenses, nil
+}
+
+// Origin will report the repository the package was installed from
+func (x *XBPSQuery) Origin(name string) (string, error) {
+	p, err := x.get(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Origin, nil
+}
+
+// Dependencies will report the direct dependencies of the package
+func (x *XBPSQuery) Dependencies(name string) ([]string, error) {
+	p, err := x.get(name)
+	if err != nil {
+		return nil, err
+	}
+	deps := append([]string(nil), p.Depends...)
+	sort.Strings(deps)
+	return deps, nil
+}
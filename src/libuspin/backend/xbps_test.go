@@ -0,0 +1,169 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const xbpsPkgDBData = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>_XBPS_ALTERNATIVES_</key>
+	<dict>
+		<key>awk</key>
+		<array>
+			<string>gawk</string>
+		</array>
+	</dict>
+	<key>bash</key>
+	<dict>
+		<key>automatic-install</key>
+		<true/>
+		<key>license</key>
+		<string>GPL-3.0-or-later</string>
+		<key>pkgver</key>
+		<string>bash-5.2.021_1</string>
+		<key>repository</key>
+		<string>https://repo-default.voidlinux.org/current</string>
+		<key>run_depends</key>
+		<array>
+			<string>readline>=8.0_1</string>
+			<string>glibc-2.36_1</string>
+		</array>
+		<key>state</key>
+		<string>installed</string>
+	</dict>
+	<key>gawk</key>
+	<dict>
+		<key>license</key>
+		<string>GPL-3.0-or-later, LGPL-2.1-or-later</string>
+		<key>pkgver</key>
+		<string>gawk-5.3.0_1</string>
+		<key>state</key>
+		<string>installed</string>
+	</dict>
+	<key>nano</key>
+	<dict>
+		<key>pkgver</key>
+		<string>nano-7.2_1</string>
+		<key>state</key>
+		<string>half-removed</string>
+	</dict>
+</dict>
+</plist>
+`
+
+func TestXBPSQuery(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-xbps")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	db := filepath.Join(root, xbpsPkgDB)
+	os.MkdirAll(filepath.Dir(db), 00755)
+	if err := ioutil.WriteFile(db, []byte(xbpsPkgDBData), 00644); err != nil {
+		t.Fatalf("Failed to write pkgdb: %v", err)
+	}
+	q := NewXBPSRootQuery(root)
+	names, err := q.InstalledPackages()
+	if err != nil || !reflect.DeepEqual(names, []string{"bash", "gawk"}) {
+		t.Fatalf("Wrong installed packages: %v %v", names, err)
+	}
+	if v, err := q.Version("bash"); err != nil || v != "5.2.021_1" {
+		t.Fatalf("Wrong version: %v %v", v, err)
+	}
+	if o, err := q.Origin("bash"); err != nil || o != "https://repo-default.voidlinux.org/current" {
+		t.Fatalf("Wrong origin: %v %v", o, err)
+	}
+	if o, err := q.Origin("gawk"); err != nil || o != "" {
+		t.Fatalf("Unrecorded origin should be empty: %v %v", o, err)
+	}
+	if l, err := q.Licenses("gawk"); err != nil || !reflect.DeepEqual(l, []string{"GPL-3.0-or-later", "LGPL-2.1-or-later"}) {
+		t.Fatalf("Wrong licenses: %v %v", l, err)
+	}
+	if d, err := q.Dependencies("bash"); err != nil || !reflect.DeepEqual(d, []string{"glibc", "readline"}) {
+		t.Fatalf("Wrong dependencies: %v %v", d, err)
+	}
+	if _, err := q.Version("nano"); err == nil {
+		t.Fatalf("Partially removed packages should not be reported")
+	}
+}
+
+func TestXBPSRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-xbps")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "keys", "60:ae:0c:d6:f0:95:17:80:bc:93:46:7a:89:af:a3:2d.plist")
+	os.MkdirAll(filepath.Dir(key), 00755)
+	if err := ioutil.WriteFile(key, []byte("<plist/>\n"), 00644); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	conf := &config.ImageConfiguration{}
+	conf.Safety.Policy = config.SafetyEnforce
+	conf.XBPS.Keys = []string{"keys/" + filepath.Base(key)}
+	conf.XBPS.Virtual = map[string]string{"awk": "gawk", "cron-daemon": "cronie"}
+	m, err := NewXBPSManager(conf, dir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	x := m.(*XBPSManager)
+
+	root := filepath.Join(dir, "root")
+	stale := filepath.Join(root, xbpsConfDir, "00-repository-old.conf")
+	os.MkdirAll(filepath.Dir(stale), 00755)
+	ioutil.WriteFile(stale, []byte("repository=https://example.com/old\n"), 00644)
+	if err := x.InitRoot(root); err != nil {
+		t.Fatalf("Failed to initialise root: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("Stale repositories should be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, xbpsKeysDir, filepath.Base(key))); err != nil {
+		t.Fatalf("Profile key not installed: %v", err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(root, xbpsConfDir, xbpsVirtualConf))
+	if string(data) != "virtualpkg=awk:gawk\nvirtualpkg=cron-daemon:cronie\n" {
+		t.Fatalf("Wrong virtual packages:\n%s", data)
+	}
+	if err := x.AddRepo("current", "https://repo-default.voidlinux.org/current"); err != nil {
+		t.Fatalf("Failed to add repo: %v", err)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(root, xbpsConfDir, "00-repository-current.conf"))
+	if string(data) != "repository=https://repo-default.voidlinux.org/current\n" {
+		t.Fatalf("Wrong repository:\n%s", data)
+	}
+	if args := x.installArgs(true, []string{"awk"}); strings.Join(args, " ") != "-y --ignore-file-conflicts awk" {
+		t.Fatalf("Wrong install arguments: %v", args)
+	}
+
+	conf.XBPS.Keys = []string{"void.pub"}
+	if _, err := NewXBPSManager(conf, dir); err == nil {
+		t.Fatalf("Keys which are not .plist files should be rejected")
+	}
+}
@@ -0,0 +1,203 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/pkg"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/process"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// PackageManagerZypper selects the zypper backend, for openSUSE based images
+const PackageManagerZypper pkg.PackageManager = "zypper"
+
+func init() {
+	Register(&Backend{
+		Name:          PackageManagerZypper,
+		Distributions: []string{"opensuse", "suse"},
+		NewManager:    NewZypperManager,
+		NewRootQuery: func(root string) (Query, error) {
+			return NewRPMRootQuery(root), nil
+		},
+	})
+}
+
+// A ZypperManager installs packages into the root with zypper --root.
+//
+// Repository keys are imported automatically unless the safety policy is
+// enforced, in which case only the keys of the profile are trusted.
+type ZypperManager struct {
+	conf   config.SectionZypper
+	safety config.SafetyPolicy
+	keys   []string
+	arch   string // Foreign architecture to install, empty for the host
+	root   string
+}
+
+// zyppConf is the configuration written within the root for a foreign
+// architecture, as zypper has no option to choose one
+const zyppConf = "etc/zypp/zypp.conf"
+
+// NewZypperManager will return the zypper manager for the configuration
+func NewZypperManager(conf *config.ImageConfiguration, baseDir string) (pkg.Manager, error) {
+	z := &ZypperManager{conf: conf.Zypper, safety: conf.Safety.Policy}
+	if conf.Image.Arch != config.HostArch() {
+		z.arch = conf.Image.Arch
+	}
+	for _, key := range conf.Zypper.Keys {
+		if !filepath.IsAbs(key) {
+			key = filepath.Join(baseDir, key)
+		}
+		z.keys = append(z.keys, key)
+	}
+	return z, nil
+}
+
+// Init will ensure zypper and rpm are available on the host
+func (z *ZypperManager) Init() error {
+	for _, bin := range []string{"zypper", "rpm"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run will run the command, streaming its output
+func (z *ZypperManager) run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	process.Trace(cmd)
+	return cmd.Run()
+}
+
+// globalArgs returns the options used for every zypper command
+func (z *ZypperManager) globalArgs() []string {
+	args := []string{"--root", z.root, "--non-interactive"}
+	if z.safety != config.SafetyEnforce {
+		args = append(args, "--gpg-auto-import-keys")
+	}
+	if z.arch != "" {
+		args = append(args, "--config", filepath.Join(z.root, zyppConf))
+	}
+	return args
+}
+
+// zypper will run zypper against the root
+func (z *ZypperManager) zypper(args ...string) error {
+	return z.run("zypper", append(z.globalArgs(), args...)...)
+}
+
+// InitRoot will create the rpm database within the root and import the keys
+// of the profile
+func (z *ZypperManager) InitRoot(root string) error {
+	z.root = root
+	if err := z.run("rpm", "--root", root, "--initdb"); err != nil {
+		return err
+	}
+	for _, key := range z.keys {
+		if err := z.run("rpm", "--root", root, "--import", key); err != nil {
+			return fmt.Errorf("Failed to import key %v: %v", key, err)
+		}
+	}
+	if z.arch != "" {
+		conf := filepath.Join(root, zyppConf)
+		if err := os.MkdirAll(filepath.Dir(conf), 00755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(conf, []byte(fmt.Sprintf("[main]\narch = %v\n", z.arch)), 00644); err != nil {
+			return err
+		}
+	}
+	if z.safety == config.SafetyWarn {
+		log.WithFields(log.Fields{
+			"policy": z.safety,
+		}).Warning("Repository keys will be imported without verification")
+	}
+	return nil
+}
+
+// CheckDatabase will have zypper verify the dependencies of the root
+// without attempting to fix them
+func (z *ZypperManager) CheckDatabase() error {
+	return z.zypper("verify", "--dry-run")
+}
+
+// addRepoArgs returns the zypper arguments adding the repository
+func (z *ZypperManager) addRepoArgs(identifier, uri string) []string {
+	args := []string{"addrepo", "--refresh"}
+	if priority, ok := z.conf.Priorities[identifier]; ok {
+		args = append(args, "--priority", fmt.Sprint(priority))
+	}
+	return append(args, uri, identifier)
+}
+
+// AddRepo will enable the repository within the root
+func (z *ZypperManager) AddRepo(identifier, uri string) error {
+	return z.zypper(z.addRepoArgs(identifier, uri)...)
+}
+
+// installArgs returns the zypper arguments installing the names. Ignoring
+// safety lets zypper pick a solution which breaks dependencies.
+func (z *ZypperManager) installArgs(ignoreSafety bool, names []string) []string {
+	args := []string{"install"}
+	if !z.conf.Recommends {
+		args = append(args, "--no-recommends")
+	}
+	if ignoreSafety {
+		args = append(args, "--force-resolution")
+	}
+	return append(args, names...)
+}
+
+// InstallGroups will install the groups as patterns, i.e. "enhanced_base"
+func (z *ZypperManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	args := z.installArgs(ignoreSafety, nil)
+	args = append(args, "--type", "pattern")
+	return z.zypper(append(args, groups...)...)
+}
+
+// InstallPackages will install the packages into the root
+func (z *ZypperManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	return z.zypper(z.installArgs(ignoreSafety, packages)...)
+}
+
+// RemovePackages will remove the packages from the root, along with anything
+// depending on them unless ignoreSafety is set
+func (z *ZypperManager) RemovePackages(ignoreSafety bool, names []string) error {
+	if ignoreSafety {
+		return z.run("rpm", append([]string{"--root", z.root, "-e", "--nodeps"}, names...)...)
+	}
+	return z.zypper(append([]string{"remove"}, names...)...)
+}
+
+// FinalizeRoot will empty the package cache of the root
+func (z *ZypperManager) FinalizeRoot() error {
+	return z.zypper("clean", "--all")
+}
+
+// Cleanup does nothing, as zypper leaves no processes behind
+func (z *ZypperManager) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backend
+
+import (
+	"libuspin/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestZypperArgs(t *testing.T) {
+	conf := &config.ImageConfiguration{}
+	conf.Safety.Policy = config.SafetyEnforce
+	conf.Zypper.Priorities = map[string]int{"packman": 90}
+	conf.Zypper.Keys = []string{"keys/packman.asc"}
+	m, err := NewZypperManager(conf, "/srv/profile")
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	z := m.(*ZypperManager)
+	z.root = "/tmp/root"
+	if !reflect.DeepEqual(z.keys, []string{"/srv/profile/keys/packman.asc"}) {
+		t.Fatalf("Keys should be relative to the profile: %v", z.keys)
+	}
+	if strings.Contains(strings.Join(z.globalArgs(), " "), "--gpg-auto-import-keys") {
+		t.Fatalf("Keys must not be imported automatically when safety is enforced")
+	}
+	z.safety = config.SafetyWarn
+	if !strings.Contains(strings.Join(z.globalArgs(), " "), "--gpg-auto-import-keys") {
+		t.Fatalf("Keys should be imported automatically unless safety is enforced")
+	}
+
+	args := z.addRepoArgs("packman", "https://example.com/packman")
+	if strings.Join(args, " ") != "addrepo --refresh --priority 90 https://example.com/packman packman" {
+		t.Fatalf("Wrong addrepo arguments: %v", args)
+	}
+	args = z.addRepoArgs("oss", "https://example.com/oss")
+	if strings.Join(args, " ") != "addrepo --refresh https://example.com/oss oss" {
+		t.Fatalf("Wrong addrepo arguments: %v", args)
+	}
+	args = z.installArgs(true, []string{"vim"})
+	if strings.Join(args, " ") != "install --no-recommends --force-resolution vim" {
+		t.Fatalf("Wrong install arguments: %v", args)
+	}
+
+	if strings.Contains(strings.Join(z.globalArgs(), " "), "--config") {
+		t.Fatalf("The host architecture needs no configuration")
+	}
+	conf.Image.Arch = "s390x"
+	if m, err = NewZypperManager(conf, "/srv/profile"); err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	z = m.(*ZypperManager)
+	z.root = "/tmp/root"
+	if !strings.Contains(strings.Join(z.globalArgs(), " "), "--config /tmp/root/etc/zypp/zypp.conf") {
+		t.Fatalf("Foreign architectures should use the configuration of the root: %v", z.globalArgs())
+	}
+}
@@ -0,0 +1,198 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"libuspin/chroot"
+	"libuspin/config"
+	"libuspin/disk"
+	"libuspin/tree"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// CustomKernelSource is where the kernel source is built within the chroot
+const CustomKernelSource = "/usr/src/uspin-kernel"
+
+// A CustomKernel installs a kernel that doesn't come from the repositories
+type CustomKernel struct {
+	conf    *config.SectionKernel
+	baseDir string // Relative paths are resolved against this
+}
+
+// NewCustomKernel will return a CustomKernel for the configuration, resolving
+// relative paths against baseDir.
+func NewCustomKernel(conf *config.SectionKernel, baseDir string) *CustomKernel {
+	return &CustomKernel{
+		conf:    conf,
+		baseDir: baseDir,
+	}
+}
+
+func (k *CustomKernel) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(k.baseDir, p)
+}
+
+// Install will build or extract the kernel into the chroot, registering it as
+// the kernel used by the bootloaders. The chroot must have been entered.
+func (k *CustomKernel) Install(c *chroot.Chroot) (string, error) {
+	var release string
+	var err error
+	if k.conf.Prebuilt != "" {
+		release, err = k.extract(c.Root)
+	} else {
+		release, err = k.build(c)
+	}
+	if err != nil {
+		return "", err
+	}
+	return release, RegisterKernel(c.Root, release)
+}
+
+// build will build the kernel source within the chroot
+func (k *CustomKernel) build(c *chroot.Chroot) (string, error) {
+	srcDir := filepath.Join(c.Root, CustomKernelSource)
+	os.RemoveAll(srcDir)
+	defer os.RemoveAll(srcDir)
+
+	source := k.path(k.conf.Source)
+	if st, err := os.Stat(source); err != nil {
+		return "", err
+	} else if st.IsDir() {
+		if err := tree.Copy(source, srcDir); err != nil {
+			return "", err
+		}
+	} else {
+		if err := os.MkdirAll(srcDir, 00755); err != nil {
+			return "", err
+		}
+		if err := commands.ExecStdoutArgs("tar", []string{"-xf", source, "-C", srcDir, "--strip-components=1"}); err != nil {
+			return "", err
+		}
+	}
+	if err := disk.CopyFile(k.path(k.conf.Config), filepath.Join(srcDir, ".config")); err != nil {
+		return "", err
+	}
+
+	script := []string{"set -e", "cd " + CustomKernelSource}
+	for i, patch := range k.conf.Patches {
+		name := fmt.Sprintf(".uspin-patch-%03d", i)
+		if err := disk.CopyFile(k.path(patch), filepath.Join(srcDir, name)); err != nil {
+			return "", err
+		}
+		script = append(script, "patch -p1 -i "+name)
+	}
+
+	jobs := k.conf.Jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+	mk := fmt.Sprintf("make LOCALVERSION=%q", k.conf.LocalVersion)
+	script = append(script,
+		mk+" olddefconfig",
+		fmt.Sprintf("%v -j%d", mk, jobs),
+		mk+" modules_install",
+		mk+" -s kernelrelease > .uspin-release",
+		"cp \"$("+mk+" -s image_name)\" \"/boot/kernel-$(cat .uspin-release)\"",
+	)
+	if err := c.Run(strings.Join(script, "\n")); err != nil {
+		return "", fmt.Errorf("Failed to build kernel: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(srcDir, ".uspin-release"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// extract will unpack the prebuilt kernel archive into the root
+func (k *CustomKernel) extract(root string) (string, error) {
+	archive := k.path(k.conf.Prebuilt)
+	out, err := exec.Command("tar", "-tf", archive).Output()
+	if err != nil {
+		return "", fmt.Errorf("Cannot list kernel archive %v: %v", archive, err)
+	}
+	release, err := kernelFromListing(out)
+	if err != nil {
+		return "", err
+	}
+	if err := commands.ExecStdoutArgs("tar", []string{"-xf", archive, "-C", root}); err != nil {
+		return "", err
+	}
+	if err := commands.ChrootExec(root, "depmod "+release); err != nil {
+		return "", err
+	}
+	return release, nil
+}
+
+// kernelFromListing will find the kernel release within a "tar -t" listing
+func kernelFromListing(listing []byte) (string, error) {
+	var releases []string
+	for _, line := range bytes.Split(listing, []byte("\n")) {
+		name := strings.TrimPrefix(string(line), "./")
+		if strings.HasPrefix(name, "boot/kernel-") {
+			releases = append(releases, strings.TrimPrefix(name, "boot/kernel-"))
+		}
+	}
+	if len(releases) != 1 {
+		return "", fmt.Errorf("Kernel archive must contain exactly one boot/kernel-*, found %d", len(releases))
+	}
+	if !bytes.Contains(listing, []byte("lib/modules/"+releases[0]+"/")) {
+		return "", fmt.Errorf("Kernel archive has no lib/modules/%v", releases[0])
+	}
+	return releases[0], nil
+}
+
+// RegisterKernel will point the kernel symlinks at the given release, so
+// that it is used for the initrd and bootloaders.
+func RegisterKernel(root, release string) error {
+	kernel := filepath.Join("boot", "kernel-"+release)
+	if _, err := os.Stat(filepath.Join(root, kernel)); err != nil {
+		return fmt.Errorf("Kernel not installed: %v", err)
+	}
+	links := map[string]string{
+		"vmlinuz":      kernel,
+		"boot/vmlinuz": filepath.Base(kernel),
+	}
+	var names []string
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(links[name], path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKernelFromListing(t *testing.T) {
+	listing := "./boot/\n./boot/kernel-4.9.0-uspin\n./lib/modules/4.9.0-uspin/\n./lib/modules/4.9.0-uspin/modules.dep\n"
+	release, err := kernelFromListing([]byte(listing))
+	if err != nil || release != "4.9.0-uspin" {
+		t.Fatalf("Incorrect kernel release: %v %v", release, err)
+	}
+	if _, err := kernelFromListing([]byte("boot/kernel-4.9.0\n")); err == nil {
+		t.Fatalf("Archives without modules should be rejected")
+	}
+}
+
+func TestRegisterKernel(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-kernel")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "boot"), 00755)
+	ioutil.WriteFile(filepath.Join(root, "boot", "kernel-4.4.36-lts"), nil, 00644)
+	ioutil.WriteFile(filepath.Join(root, "boot", "kernel-4.9.0-uspin"), nil, 00644)
+	os.Symlink("boot/kernel-4.4.36-lts", filepath.Join(root, "vmlinuz"))
+
+	if err := RegisterKernel(root, "4.9.0-uspin"); err != nil {
+		t.Fatalf("Failed to register kernel: %v", err)
+	}
+	for _, link := range []string{"vmlinuz", "boot/vmlinuz"} {
+		target, err := filepath.EvalSymlinks(filepath.Join(root, link))
+		if err != nil || filepath.Base(target) != "kernel-4.9.0-uspin" {
+			t.Fatalf("Incorrect %v target: %v %v", link, target, err)
+		}
+	}
+	if err := RegisterKernel(root, "5.0"); err == nil {
+		t.Fatalf("Missing kernels should not be registered")
+	}
+}
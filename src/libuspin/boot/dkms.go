@@ -0,0 +1,186 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/chroot"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A DKMSModule is an out of tree module source registered with DKMS
+type DKMSModule struct {
+	Name    string
+	Version string
+}
+
+// NewDKMSModule will parse a module in the name/version form
+func NewDKMSModule(id string) (*DKMSModule, error) {
+	splits := strings.Split(id, "/")
+	if len(splits) != 2 || splits[0] == "" || splits[1] == "" {
+		return nil, fmt.Errorf("Invalid DKMS module '%v', expected name/version", id)
+	}
+	return &DKMSModule{Name: splits[0], Version: splits[1]}, nil
+}
+
+// String returns the name/version form of the module
+func (d *DKMSModule) String() string {
+	return d.Name + "/" + d.Version
+}
+
+// SourcePath returns the relative location of the module source
+func (d *DKMSModule) SourcePath() string {
+	return filepath.Join("usr", "src", d.Name+"-"+d.Version)
+}
+
+// BuiltModules will return the kernel module names produced by this DKMS
+// module, as listed by BUILT_MODULE_NAME in the dkms.conf
+func (d *DKMSModule) BuiltModules(root string) ([]string, error) {
+	conf := filepath.Join(root, d.SourcePath(), "dkms.conf")
+	fi, err := os.Open(conf)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read DKMS configuration for %v: %v", d, err)
+	}
+	defer fi.Close()
+
+	var ret []string
+	sc := bufio.NewScanner(fi)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "BUILT_MODULE_NAME[") {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(line[eq+1:]), "\"'")
+		if name != "" {
+			ret = append(ret, name)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("DKMS module %v declares no BUILT_MODULE_NAME", d)
+	}
+	return ret, nil
+}
+
+// ListKernels will return the release of every kernel within the root, i.e.
+// every boot/kernel-$release with a matching lib/modules/$release
+func ListKernels(root string) ([]string, error) {
+	kernels, err := filepath.Glob(filepath.Join(root, "boot", "kernel-*"))
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, kernel := range kernels {
+		release := strings.TrimPrefix(filepath.Base(kernel), "kernel-")
+		if st, err := os.Stat(filepath.Join(root, "lib", "modules", release)); err != nil || !st.IsDir() {
+			continue
+		}
+		ret = append(ret, release)
+	}
+	if len(ret) == 0 {
+		return nil, ErrNoKernelFound
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// BuildDKMS will build and install each module against every kernel within
+// the chroot, which must have been entered, and verify the results.
+func BuildDKMS(c *chroot.Chroot, modules []*DKMSModule) error {
+	kernels, err := ListKernels(c.Root)
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		if _, err := os.Stat(filepath.Join(c.Root, module.SourcePath())); err != nil {
+			return fmt.Errorf("DKMS module %v is not installed: %v", module, err)
+		}
+		args := fmt.Sprintf("-m %v -v %v", module.Name, module.Version)
+		script := []string{
+			"set -e",
+			fmt.Sprintf("dkms status %v | grep -q . || dkms add %v", args, args),
+		}
+		for _, kernel := range kernels {
+			script = append(script, fmt.Sprintf("dkms install %v -k %v", args, kernel))
+		}
+		log.WithFields(log.Fields{
+			"module":  module,
+			"kernels": len(kernels),
+		}).Info("Building DKMS module")
+		if err := c.Run(strings.Join(script, "\n")); err != nil {
+			return fmt.Errorf("Failed to build DKMS module %v: %v", module, err)
+		}
+	}
+	return VerifyDKMS(c.Root, modules, kernels)
+}
+
+// VerifyDKMS will ensure every built module is installed for each kernel,
+// so that a failed build is caught now rather than at boot.
+func VerifyDKMS(root string, modules []*DKMSModule, kernels []string) error {
+	var missing []string
+	for _, kernel := range kernels {
+		installed, err := kernelModules(filepath.Join(root, "lib", "modules", kernel))
+		if err != nil {
+			return err
+		}
+		for _, module := range modules {
+			names, err := module.BuiltModules(root)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				if !installed[name] {
+					missing = append(missing, fmt.Sprintf("%v (%v) for %v", name, module, kernel))
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing DKMS modules: %v", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// kernelModules returns the set of module names within the modules directory
+func kernelModules(dir string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if idx := strings.Index(name, ".ko"); idx > 0 {
+			ret[name[:idx]] = true
+		}
+		return nil
+	})
+	return ret, err
+}
@@ -0,0 +1,85 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDKMSConf will fake an installed DKMS module source
+func writeDKMSConf(root string, module *DKMSModule, names ...string) error {
+	dir := filepath.Join(root, module.SourcePath())
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return err
+	}
+	var lines []string
+	for i, name := range names {
+		lines = append(lines, fmt.Sprintf("BUILT_MODULE_NAME[%d]=\"%v\"", i, name))
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "dkms.conf"), []byte(strings.Join(lines, "\n")+"\n"), 00644)
+}
+
+func TestVerifyDKMS(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-dkms")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, release := range []string{"4.4.36-lts", "4.9.0-current"} {
+		os.MkdirAll(filepath.Join(root, "boot"), 00755)
+		ioutil.WriteFile(filepath.Join(root, "boot", "kernel-"+release), nil, 00644)
+		os.MkdirAll(filepath.Join(root, "lib", "modules", release, "kernel", "drivers"), 00755)
+	}
+	// Kernels without modules are not considered
+	ioutil.WriteFile(filepath.Join(root, "boot", "kernel-3.0"), nil, 00644)
+
+	kernels, err := ListKernels(root)
+	if err != nil {
+		t.Fatalf("Failed to list kernels: %v", err)
+	}
+	if len(kernels) != 2 || kernels[0] != "4.4.36-lts" {
+		t.Fatalf("Incorrect kernels: %v", kernels)
+	}
+
+	module, err := NewDKMSModule("broadcom-wl/6.30.223.271")
+	if err != nil {
+		t.Fatalf("Failed to parse module: %v", err)
+	}
+	if err := writeDKMSConf(root, module, "wl"); err != nil {
+		t.Fatalf("Failed to write dkms.conf: %v", err)
+	}
+	modules := []*DKMSModule{module}
+
+	ioutil.WriteFile(filepath.Join(root, "lib", "modules", kernels[0], "kernel", "drivers", "wl.ko.xz"), nil, 00644)
+	if err := VerifyDKMS(root, modules, kernels); err == nil || !strings.Contains(err.Error(), kernels[1]) {
+		t.Fatalf("Missing module for %v not reported: %v", kernels[1], err)
+	}
+	ioutil.WriteFile(filepath.Join(root, "lib", "modules", kernels[1], "kernel", "drivers", "wl.ko"), nil, 00644)
+	if err := VerifyDKMS(root, modules, kernels); err != nil {
+		t.Fatalf("Failed to verify modules: %v", err)
+	}
+
+	if _, err := NewDKMSModule("broadcom-wl"); err == nil {
+		t.Fatalf("Modules without a version should be rejected")
+	}
+}
@@ -19,13 +19,19 @@ package boot
 import (
 	"fmt"
 	"github.com/solus-project/libosdev/commands"
+	"libuspin/initsys"
 	"strings"
 )
 
 var (
-	// DracutLiveOSModules are modules to enable for Live OS Usage
-	// TODO: Make systemd dependent on the presence of systemd in the sysroot
-	DracutLiveOSModules = []string{"dmsquash-live", "systemd", "pollcdrom"}
+	// DracutLiveOSModules are modules to enable for Live OS Usage. The
+	// systemd module is added for systemd images, see DracutInitModules.
+	DracutLiveOSModules = []string{"dmsquash-live", "pollcdrom"}
+
+	// DracutInitModules are the modules required by each init system
+	DracutInitModules = map[initsys.Type][]string{
+		initsys.TypeSystemd: {"systemd"},
+	}
 
 	// DracutLiveOSDrivers are drivers that should be shipped for LiveOS functionality to work
 	// TODO: Investigate now-dead stuff and curate this list
@@ -49,6 +55,31 @@ var (
 	}
 )
 
+// DracutPersistenceDrivers are also shipped with a persistent LiveOS, to
+// mount the persistence partition and overlay the changes onto the rootfs
+var DracutPersistenceDrivers = []string{
+	"ext4",
+	"overlay",
+	"loop",
+	"dm_snapshot",
+	"nls_cp437",
+	"nls_iso8859_1",
+}
+
+// DracutDiskDrivers are drivers that should be shipped to find the root
+// partition of disk images, on real and virtual hardware
+var DracutDiskDrivers = []string{
+	"ext4",
+	"xfs",
+	"btrfs",
+	"ahci",
+	"sd_mod",
+	"nvme",
+	"virtio_blk",
+	"virtio_scsi",
+	"virtio_pci",
+}
+
 // Dracut provides wrapping around dracut generation in chroots
 type Dracut struct {
 	// Additional options to pass to dracut when generating
@@ -0,0 +1,189 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"fmt"
+	"libuspin/config"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// EFIImagePath is where the ESP image is placed within the ISO tree, to be
+	// used as the El Torito alternative boot entry
+	EFIImagePath = "efi/efiboot.img"
+
+	// EFIBootBinary is the removable media path of the loader within the ESP
+	EFIBootBinary = "EFI/BOOT/BOOTX64.EFI"
+
+	// ShimLoaderBinary is the loader started by shim, from beside itself
+	ShimLoaderBinary = "EFI/BOOT/grubx64.efi"
+
+	// ShimMOKManagerBinary lets users enroll the signing certificate when
+	// shim refuses to start the loader
+	ShimMOKManagerBinary = "EFI/BOOT/mmx64.efi"
+)
+
+var (
+	// ESPBinaries are the host tools required to populate an ESP image
+	ESPBinaries = []string{
+		"mkfs.vfat",
+		"mmd",
+		"mcopy",
+	}
+)
+
+// LiveCmdline returns the kernel command line used to boot a LiveOS from
+// the ISO with the given label, keeping changes on the persistence
+// partition if it is enabled
+func LiveCmdline(label string, live *config.SectionLiveOS) string {
+	cmdline := fmt.Sprintf("root=live:CDLABEL=%v ro rd.luks=0 rd.md=0", label)
+	if persistence := live.PersistenceCmdline(); persistence != "" {
+		cmdline += " " + persistence
+	}
+	return cmdline
+}
+
+// An ESP is a FAT formatted EFI system partition image. It is populated with
+// mtools so that it never needs to be mounted.
+type ESP struct {
+	Path  string            // Path of the image file
+	Epoch time.Time         // Dates the volume and files when set, for reproducible images
+	files map[string]string // Target path within the ESP to the host source
+}
+
+// NewESP will return a new, empty, ESP to be created at path
+func NewESP(path string) *ESP {
+	return &ESP{
+		Path:  path,
+		files: make(map[string]string),
+	}
+}
+
+// checkESPBinaries will ensure the host can populate an ESP image
+func checkESPBinaries() error {
+	for _, bin := range ESPBinaries {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add will place the host file source at target within the ESP
+func (e *ESP) Add(source, target string) {
+	e.files[strings.TrimPrefix(target, "/")] = source
+}
+
+// Dirs returns every directory required within the ESP, parents first
+func (e *ESP) Dirs() []string {
+	seen := make(map[string]bool)
+	var ret []string
+	for target := range e.files {
+		for dir := path.Dir(target); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			ret = append(ret, dir)
+		}
+	}
+	// Parents always sort before their children
+	sort.Strings(ret)
+	return ret
+}
+
+// Size returns the size in kilobytes required to hold the ESP contents, with
+// room for the FAT tables and directory entries
+func (e *ESP) Size() (int64, error) {
+	var total int64
+	for _, source := range e.files {
+		st, err := os.Stat(source)
+		if err != nil {
+			return 0, err
+		}
+		// Account for cluster slack on each file
+		total += st.Size()/1024 + 4
+	}
+	return total + total/10 + 1024, nil
+}
+
+// Create will format the ESP image and copy the contents into it. A size of
+// zero will size the image to fit.
+func (e *ESP) Create(sizeMB int) error {
+	size := int64(sizeMB) * 1024
+	if size == 0 {
+		var err error
+		if size, err = e.Size(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mkfs := []string{"-C", "-n", "EFIBOOT", e.Path, fmt.Sprintf("%d", size)}
+	copyArgs := []string{"-i", e.Path}
+	if !e.Epoch.IsZero() {
+		// Fixed volume ID, and the (clamped) times of the sources
+		mkfs = append([]string{"--invariant"}, mkfs...)
+		copyArgs = append(copyArgs, "-m")
+	}
+	if err := hostTool("mkfs.vfat", mkfs...); err != nil {
+		return err
+	}
+	for _, dir := range e.Dirs() {
+		if err := hostTool("mmd", "-i", e.Path, "::/"+dir); err != nil {
+			return err
+		}
+	}
+	var targets []string
+	for target := range e.files {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		source := e.files[target]
+		if !e.Epoch.IsZero() {
+			if err := clampTime(source, e.Epoch); err != nil {
+				return err
+			}
+		}
+		if err := hostTool("mcopy", append(copyArgs, source, "::/"+target)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clampTime will ensure the file is dated no later than epoch
+func clampTime(path string, epoch time.Time) error {
+	st, err := os.Stat(path)
+	if err != nil || !st.ModTime().After(epoch) {
+		return err
+	}
+	return os.Chtimes(path, epoch, epoch)
+}
+
+// hostTool will run a tool of the host, including its output in any error
+func hostTool(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v failed: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
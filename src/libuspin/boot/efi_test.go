@@ -0,0 +1,84 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestESPLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-esp")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "kernel")
+	if err := ioutil.WriteFile(source, make([]byte, 64*1024), 00644); err != nil {
+		t.Fatalf("Failed to write kernel: %v", err)
+	}
+	esp := NewESP(filepath.Join(dir, "efiboot.img"))
+	esp.Add(source, EFIBootBinary)
+	esp.Add(source, "/loader/entries/uspin.conf")
+	esp.Add(source, "boot/kernel")
+
+	want := []string{"EFI", "EFI/BOOT", "boot", "loader", "loader/entries"}
+	if dirs := esp.Dirs(); !reflect.DeepEqual(dirs, want) {
+		t.Fatalf("Incorrect ESP directories: %v", dirs)
+	}
+	size, err := esp.Size()
+	if err != nil {
+		t.Fatalf("Failed to size ESP: %v", err)
+	}
+	if size < 3*64+1024 {
+		t.Fatalf("ESP too small for its contents: %vK", size)
+	}
+}
+
+func TestLoaderTemplates(t *testing.T) {
+	kernel := &Kernel{
+		TargetPath:   "boot/kernel",
+		TargetInitrd: "boot/initrd.img",
+	}
+	live := &config.SectionLiveOS{Persistence: config.PersistencePartition, PersistenceLabel: "persist"}
+	var grub, entry bytes.Buffer
+	tmpl := template.Must(template.New("grub").Parse(DefaultGrubTemplate))
+	if err := tmpl.Execute(&grub, GrubTemplate{Kernel: kernel, StartString: "Start", Cmdline: LiveCmdline("uspin.ISO", live)}); err != nil {
+		t.Fatalf("Failed to execute grub template: %v", err)
+	}
+	if !strings.Contains(grub.String(), "linux /boot/kernel root=live:CDLABEL=uspin.ISO ") {
+		t.Fatalf("Incorrect grub.cfg: %v", grub.String())
+	}
+	tmpl = template.Must(template.New("systemd-boot").Parse(DefaultSystemdBootTemplate))
+	if err := tmpl.Execute(&entry, SystemdBootTemplate{Kernel: kernel, Title: "USpin", Cmdline: LiveCmdline("uspin.ISO", live)}); err != nil {
+		t.Fatalf("Failed to execute systemd-boot template: %v", err)
+	}
+	if !strings.Contains(entry.String(), "options root=live:CDLABEL=uspin.ISO ro rd.luks=0 rd.md=0 rd.live.overlay=LABEL=persist rd.live.overlay.overlayfs=1 quiet") {
+		t.Fatalf("Persistence missing from loader entry: %v", entry.String())
+	}
+	if !strings.Contains(entry.String(), "initrd /boot/initrd.img\n") {
+		t.Fatalf("Incorrect loader entry: %v", entry.String())
+	}
+}
@@ -0,0 +1,108 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/disk"
+	"os"
+)
+
+// ExtlinuxTemplate is used to populate fields in the extlinux.conf
+type ExtlinuxTemplate struct {
+	Kernel  *Kernel
+	Title   string
+	Root    string // Root device, i.e. UUID=...
+	Cmdline string // Extra kernel command line options
+}
+
+// DefaultExtlinuxTemplate is the built-in template for extlinux.conf
+var DefaultExtlinuxTemplate = `# Generated by USpin
+default linux
+timeout 30
+prompt 0
+
+label linux
+  menu label {{.Title}}
+  linux /{{.Kernel.TargetPath}}
+  initrd /{{.Kernel.TargetInitrd}}
+  append root={{.Root}} rw {{.Cmdline}}
+`
+
+// installRaw will install extlinux to the /boot of the mounted root, and
+// write the boot sector to the disk
+func (s *SyslinuxLoader) installRaw(c ConfigurationSource) error {
+	bootdirTarget := c.JoinRootPath("boot", "extlinux")
+	if err := os.MkdirAll(bootdirTarget, 00755); err != nil {
+		return err
+	}
+	for _, asset := range SyslinuxAssets {
+		if err := disk.CopyFile(s.cachedAssets[asset], c.JoinRootPath("boot", "extlinux", asset)); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(c.JoinRootPath("boot", "extlinux", "extlinux.conf"))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	tmplData := ExtlinuxTemplate{
+		Kernel:  c.GetKernel(),
+		Title:   s.config.Branding.Title,
+		Root:    c.GetRootDevice(),
+		Cmdline: s.config.Disk.Cmdline,
+	}
+	if err := s.extlinuxTemplate.Execute(out, tmplData); err != nil {
+		return err
+	}
+
+	if err := commands.ExecStdoutArgs("extlinux", []string{"--install", bootdirTarget}); err != nil {
+		return err
+	}
+
+	// gptmbr.bin boots the partition marked LegacyBIOSBootable
+	mbr := "mbr.bin"
+	if s.config.Disk.PartitionTable == config.PartitionTableGPT {
+		mbr = "gptmbr.bin"
+	}
+	return writeBootSector(s.cachedAssets[mbr], c.GetBootDevice())
+}
+
+// writeBootSector will write the boot code to the start of the device,
+// leaving the partition table intact
+func writeBootSector(code, device string) error {
+	data, err := ioutil.ReadFile(code)
+	if err != nil {
+		return err
+	}
+	if len(data) > 440 {
+		return fmt.Errorf("Boot sector code is too large: %v", code)
+	}
+	fi, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := fi.WriteAt(data, 0); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
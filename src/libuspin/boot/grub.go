@@ -0,0 +1,249 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/disk"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// GrubTemplate is used to populate fields in the grub.cfg
+type GrubTemplate struct {
+	Kernel      *Kernel
+	StartString string
+	Cmdline     string
+}
+
+var (
+	// DefaultGrubTemplate is the built-in template for the ISO's grub.cfg
+	DefaultGrubTemplate = `set timeout=5
+set default=0
+
+menuentry "{{.StartString}}" {
+  linux /{{.Kernel.TargetPath}} {{.Cmdline}} quiet splash --
+  initrd /{{.Kernel.TargetInitrd}}
+}
+`
+
+	// GrubEmbeddedTemplate is built into the EFI binary to find the ISO by its
+	// label and load the grub.cfg from it
+	GrubEmbeddedTemplate = `search --no-floppy --set=root --label {{.}}
+set prefix=($root)/EFI/BOOT
+configfile $prefix/grub.cfg
+`
+
+	// GrubModules are built into the grub image, as modules cannot be loaded
+	// separately under Secure Boot
+	GrubModules = []string{
+		"part_gpt",
+		"part_msdos",
+		"fat",
+		"iso9660",
+		"search",
+		"search_label",
+		"normal",
+		"configfile",
+		"linux",
+		"all_video",
+		"gfxterm",
+	}
+
+	// GrubMkstandalone are the names of grub-mkstandalone across distributions
+	GrubMkstandalone = []string{
+		"grub-mkstandalone",
+		"grub2-mkstandalone",
+	}
+
+	// GrubModulePaths are searched for the x86_64-efi modules
+	GrubModulePaths = []string{
+		"/usr/lib/grub/x86_64-efi",
+		"/usr/lib/grub2/x86_64-efi",
+		"/usr/lib64/grub/x86_64-efi",
+		"/usr/share/grub2/x86_64-efi",
+	}
+)
+
+// GrubLoader installs a standalone grub EFI image to boot ISOs
+type GrubLoader struct {
+	mkstandalone string // Resolved grub-mkstandalone binary
+	modules      string // Directory of the x86_64-efi modules
+
+	config *config.ImageConfiguration
+
+	grubTemplate     *template.Template
+	embeddedTemplate *template.Template
+}
+
+// NewGrubLoader will return a newly created GrubLoader instance
+func NewGrubLoader() *GrubLoader {
+	return &GrubLoader{}
+}
+
+// Init will ensure grub-mkstandalone and the EFI modules are present
+func (g *GrubLoader) Init(c *config.ImageConfiguration) error {
+	for _, name := range GrubMkstandalone {
+		if bin, err := exec.LookPath(name); err == nil {
+			g.mkstandalone = bin
+			break
+		}
+	}
+	if g.mkstandalone == "" {
+		return errors.New("Cannot find grub-mkstandalone")
+	}
+	for _, dir := range GrubModulePaths {
+		if _, err := os.Stat(filepath.Join(dir, "linux.mod")); err == nil {
+			g.modules = dir
+			break
+		}
+	}
+	if g.modules == "" {
+		return errors.New("Cannot find the grub x86_64-efi modules")
+	}
+	if err := checkESPBinaries(); err != nil {
+		return err
+	}
+	var err error
+	if g.grubTemplate, err = template.New("grub").Parse(DefaultGrubTemplate); err != nil {
+		return err
+	}
+	if g.embeddedTemplate, err = template.New("grub-embedded").Parse(GrubEmbeddedTemplate); err != nil {
+		return err
+	}
+	g.config = c
+	return nil
+}
+
+// GetCapabilities will return UEFI support for ISOs
+func (g *GrubLoader) GetCapabilities() Capability {
+	return CapInstallUEFI | CapInstallISO
+}
+
+// Install will write the grub.cfg to the ISO, and create the ESP image
+// containing the standalone grub binary
+func (g *GrubLoader) Install(op Capability, c ConfigurationSource) error {
+	if op&CapInstallUEFI != CapInstallUEFI {
+		return ErrNotYetImplemented
+	}
+	bootdir := c.JoinDeployPath("EFI", "BOOT")
+	if err := os.MkdirAll(bootdir, 00755); err != nil {
+		return err
+	}
+	label := c.GetRootDevice()
+
+	cfg, err := os.Create(filepath.Join(bootdir, "grub.cfg"))
+	if err != nil {
+		return err
+	}
+	defer cfg.Close()
+	tmplData := GrubTemplate{
+		Kernel:      c.GetKernel(),
+		StartString: g.config.Branding.StartString,
+		Cmdline:     LiveCmdline(label, &g.config.LiveOS),
+	}
+	if err := g.grubTemplate.Execute(cfg, tmplData); err != nil {
+		return err
+	}
+
+	embedded, err := ioutil.TempFile("", "uspin-grub")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(embedded.Name())
+	if err := g.embeddedTemplate.Execute(embedded, label); err != nil {
+		embedded.Close()
+		return err
+	}
+	if err := embedded.Close(); err != nil {
+		return err
+	}
+
+	// Started by shim when there is one, which takes the removable media path.
+	// Also kept on the ISO for firmware that reads it directly.
+	signer := c.GetSigner()
+	target := EFIBootBinary
+	if signer != nil && signer.Shim != "" {
+		target = ShimLoaderBinary
+	}
+	binary := c.JoinDeployPath(target)
+	if err := hostTool(g.mkstandalone,
+		"--format=x86_64-efi",
+		"--directory="+g.modules,
+		"--output="+binary,
+		"--modules="+strings.Join(GrubModules, " "),
+		"--locales=",
+		"--fonts=",
+		fmt.Sprintf("boot/grub/grub.cfg=%v", embedded.Name())); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.JoinDeployPath(EFIImagePath)), 00755); err != nil {
+		return err
+	}
+	esp := NewESP(c.JoinDeployPath(EFIImagePath))
+	esp.Epoch = g.config.Image.Epoch()
+	esp.Add(binary, target)
+	if signer != nil {
+		if err := signer.Sign(binary); err != nil {
+			return err
+		}
+		if err := g.installShim(signer, esp, c); err != nil {
+			return err
+		}
+	}
+	return esp.Create(g.config.Boot.ESPSize)
+}
+
+// installShim will place the already signed shim at the removable media
+// path, so that it chains to grub, along with the MOK manager beside it
+func (g *GrubLoader) installShim(signer *Signer, esp *ESP, c ConfigurationSource) error {
+	if signer.Shim == "" {
+		return nil
+	}
+	if err := disk.CopyFile(signer.Shim, c.JoinDeployPath(EFIBootBinary)); err != nil {
+		return err
+	}
+	esp.Add(c.JoinDeployPath(EFIBootBinary), EFIBootBinary)
+
+	// Optional, but needed to enroll the certificate from the media
+	mok := filepath.Join(filepath.Dir(signer.Shim), "mmx64.efi")
+	if _, err := os.Stat(mok); err != nil {
+		return nil
+	}
+	if err := disk.CopyFile(mok, c.JoinDeployPath(ShimMOKManagerBinary)); err != nil {
+		return err
+	}
+	esp.Add(c.JoinDeployPath(ShimMOKManagerBinary), ShimMOKManagerBinary)
+	return nil
+}
+
+// GetSpecialFile will return the ESP image for the El Torito UEFI entry
+func (g *GrubLoader) GetSpecialFile(t FileType) string {
+	switch t {
+	case FileTypeEFIImage:
+		return EFIImagePath
+	default:
+		return ""
+	}
+}
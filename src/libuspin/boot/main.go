@@ -39,6 +39,10 @@ const (
 	// FileTypeBootMBR is the ISO MBR file. This permits hybrid ISO generation for
 	// both USB & CD.
 	FileTypeBootMBR FileType = "boot.mbr"
+
+	// FileTypeEFIImage is the FAT formatted ESP image used as the alternative
+	// El Torito entry, so that the ISO also boots on UEFI machines.
+	FileTypeEFIImage FileType = "efi.img"
 )
 
 // A Loader provides abstraction around various bootloader implementations.
@@ -84,6 +88,10 @@ type ConfigurationSource interface {
 	// GetKernel should return the default kernel, configured with the correct
 	// asset path
 	GetKernel() *Kernel
+
+	// GetSigner should return the Secure Boot signer for EFI binaries, or nil
+	// if they are not to be signed
+	GetSigner() *Signer
 }
 
 // Capability refers to the type of operations that a bootloader supports
@@ -116,6 +124,10 @@ func NewLoader(impl config.LoaderType) (Loader, error) {
 	switch impl {
 	case config.LoaderTypeSyslinux:
 		return NewSyslinuxLoader(), nil
+	case config.LoaderTypeGrub:
+		return NewGrubLoader(), nil
+	case config.LoaderTypeSystemdBoot:
+		return NewSystemdBootLoader(), nil
 	default:
 		return nil, ErrUnknownLoader
 	}
@@ -0,0 +1,72 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"libuspin/disk"
+	"os"
+	"os/exec"
+)
+
+// A Signer signs EFI binaries and kernels with sbsign, so that the media
+// boots on machines with Secure Boot enabled. The certificate must be
+// enrolled in the firmware, or in the MOK list when booting through shim.
+type Signer struct {
+	Key  string // Private key, PEM encoded
+	Cert string // Certificate of the key, PEM encoded
+	Shim string // Optional shim, already signed by the distribution
+}
+
+// NewSigner will return a Signer for the key and certificate, once sbsign
+// is known to be available on the host
+func NewSigner(key, cert, shim string) (*Signer, error) {
+	for _, bin := range []string{"sbsign", "sbverify"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range []string{key, cert, shim} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	return &Signer{Key: key, Cert: cert, Shim: shim}, nil
+}
+
+// Sign will sign the binary at path in place, and verify the signature
+func (s *Signer) Sign(path string) error {
+	signed := path + ".signed"
+	if err := hostTool("sbsign", "--key", s.Key, "--cert", s.Cert, "--output", signed, path); err != nil {
+		os.Remove(signed)
+		return err
+	}
+	if err := os.Rename(signed, path); err != nil {
+		return err
+	}
+	return hostTool("sbverify", "--cert", s.Cert, path)
+}
+
+// SignCopy will copy the host binary at source to target, and sign the copy
+func (s *Signer) SignCopy(source, target string) error {
+	if err := disk.CopyFile(source, target); err != nil {
+		return err
+	}
+	return s.Sign(target)
+}
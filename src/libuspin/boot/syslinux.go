@@ -18,9 +18,10 @@ package boot
 
 import (
 	"fmt"
-	"github.com/solus-project/libosdev/disk"
 	"libuspin/config"
+	"libuspin/disk"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"text/template"
 )
@@ -29,6 +30,7 @@ import (
 type IsolinuxTemplate struct {
 	Kernel      *Kernel
 	Label       string // CDLABEL
+	Cmdline     string // Kernel command line, including root=live:CDLABEL=
 	Title       string // Needs to come from config!
 	StartString string
 }
@@ -65,7 +67,7 @@ MENU TABMSGROW 11
 label live
   menu label {{.StartString}}
   kernel /{{.Kernel.TargetPath}}
-  append initrd=/{{.Kernel.TargetInitrd}} root=live:CDLABEL={{.Label}} ro rd.luks=0 rd.md=0 quiet splash --
+  append initrd=/{{.Kernel.TargetInitrd}} {{.Cmdline}} quiet splash --
 menu default
 label local
   menu label Boot from local drive
@@ -81,6 +83,7 @@ var (
 		"/usr/lib64/syslinux",
 		"/usr/lib/syslinux",
 		"/usr/share/syslinux",
+		"/usr/lib/syslinux/mbr",
 	}
 
 	// SyslinuxAssets is the core set of assets required by all syslinux usages
@@ -97,6 +100,12 @@ var (
 		"vesa.c32",
 		"isohdpfx.bin",
 	}
+
+	// SyslinuxAssetsRaw are the boot sectors written to disk images
+	SyslinuxAssetsRaw = []string{
+		"mbr.bin",
+		"gptmbr.bin",
+	}
 )
 
 // SyslinuxLoader wraps isolinux/syslinux into a single set of management
@@ -109,6 +118,7 @@ type SyslinuxLoader struct {
 	config *config.ImageConfiguration
 
 	isolinuxTemplate *template.Template
+	extlinuxTemplate *template.Template
 }
 
 // LocateAsset will attempt to find the given asset and then cache it
@@ -135,8 +145,17 @@ func (s *SyslinuxLoader) Init(c *config.ImageConfiguration) error {
 			return err
 		}
 	}
-	// TODO: Only call this when producing an ISO!
-	for _, item := range SyslinuxAssetsISO {
+	var assets []string
+	switch c.Image.Type {
+	case config.ImageTypeLiveOS:
+		assets = SyslinuxAssetsISO
+	case config.ImageTypeDisk:
+		assets = SyslinuxAssetsRaw
+		if _, err := exec.LookPath("extlinux"); err != nil {
+			return err
+		}
+	}
+	for _, item := range assets {
 		if err := s.LocateAsset(item); err != nil {
 			return err
 		}
@@ -148,13 +167,17 @@ func (s *SyslinuxLoader) Init(c *config.ImageConfiguration) error {
 		return err
 	}
 	s.isolinuxTemplate = tmpl
+	if s.extlinuxTemplate, err = template.New("extlinux").Parse(DefaultExtlinuxTemplate); err != nil {
+		return err
+	}
 	s.config = c
 	return nil
 }
 
-// GetCapabilities will return isolinux support only for syslinux right now
+// GetCapabilities will return isolinux support, and extlinux support for
+// raw disks
 func (s *SyslinuxLoader) GetCapabilities() Capability {
-	return CapInstallISO | CapInstallLegacy
+	return CapInstallISO | CapInstallLegacy | CapInstallRaw
 }
 
 // NewSyslinuxLoader will return a newly created SyslinuxLoader instance
@@ -167,7 +190,10 @@ func NewSyslinuxLoader() *SyslinuxLoader {
 
 // Install will do the real work of installing syslinux bootloader
 func (s *SyslinuxLoader) Install(op Capability, c ConfigurationSource) error {
-	// Currently we're only ever invoked as Legacy|ISO
+	if op&CapInstallRaw == CapInstallRaw {
+		return s.installRaw(c)
+	}
+
 	bootdirTarget := c.JoinDeployPath("isolinux")
 
 	// First off actually try to install the boot directory
@@ -200,6 +226,7 @@ func (s *SyslinuxLoader) Install(op Capability, c ConfigurationSource) error {
 	tmplData := IsolinuxTemplate{
 		Kernel:      c.GetKernel(),
 		Label:       label,
+		Cmdline:     LiveCmdline(label, &s.config.LiveOS),
 		Title:       brand,
 		StartString: str,
 	}
@@ -0,0 +1,163 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"errors"
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// SystemdBootTemplate is used to populate fields in the loader entry
+type SystemdBootTemplate struct {
+	Kernel  *Kernel
+	Title   string
+	Cmdline string
+}
+
+var (
+	// DefaultSystemdBootLoaderConf is the built-in loader/loader.conf
+	DefaultSystemdBootLoaderConf = `default uspin.conf
+timeout 5
+`
+
+	// DefaultSystemdBootTemplate is the built-in template for the loader entry
+	DefaultSystemdBootTemplate = `title {{.Title}}
+linux /{{.Kernel.TargetPath}}
+initrd /{{.Kernel.TargetInitrd}}
+options {{.Cmdline}} quiet splash
+`
+
+	// SystemdBootPaths are searched for the systemd-boot EFI binary
+	SystemdBootPaths = []string{
+		"/usr/lib/systemd/boot/efi",
+		"/usr/lib64/systemd/boot/efi",
+		"/lib/systemd/boot/efi",
+	}
+)
+
+// SystemdBootLoader installs systemd-boot to boot ISOs. Unlike grub it cannot
+// read the ISO, so the kernel and initrd are also copied into the ESP.
+type SystemdBootLoader struct {
+	binary string // Host systemd-bootx64.efi
+
+	config *config.ImageConfiguration
+
+	entryTemplate *template.Template
+}
+
+// NewSystemdBootLoader will return a newly created SystemdBootLoader instance
+func NewSystemdBootLoader() *SystemdBootLoader {
+	return &SystemdBootLoader{}
+}
+
+// Init will ensure the systemd-boot binary is present on the host
+func (s *SystemdBootLoader) Init(c *config.ImageConfiguration) error {
+	for _, dir := range SystemdBootPaths {
+		fpath := filepath.Join(dir, "systemd-bootx64.efi")
+		if _, err := os.Stat(fpath); err == nil {
+			s.binary = fpath
+			break
+		}
+	}
+	if s.binary == "" {
+		return errors.New("Cannot find systemd-bootx64.efi")
+	}
+	if err := checkESPBinaries(); err != nil {
+		return err
+	}
+	var err error
+	if s.entryTemplate, err = template.New("systemd-boot").Parse(DefaultSystemdBootTemplate); err != nil {
+		return err
+	}
+	s.config = c
+	return nil
+}
+
+// GetCapabilities will return UEFI support for ISOs
+func (s *SystemdBootLoader) GetCapabilities() Capability {
+	return CapInstallUEFI | CapInstallISO
+}
+
+// Install will create the ESP image with systemd-boot, its configuration
+// and the boot assets.
+func (s *SystemdBootLoader) Install(op Capability, c ConfigurationSource) error {
+	if op&CapInstallUEFI != CapInstallUEFI {
+		return ErrNotYetImplemented
+	}
+	tmp, err := ioutil.TempDir("", "uspin-systemd-boot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	loaderConf := filepath.Join(tmp, "loader.conf")
+	if err := ioutil.WriteFile(loaderConf, []byte(DefaultSystemdBootLoaderConf), 00644); err != nil {
+		return err
+	}
+	entry := filepath.Join(tmp, "uspin.conf")
+	out, err := os.Create(entry)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	kernel := c.GetKernel()
+	tmplData := SystemdBootTemplate{
+		Kernel:  kernel,
+		Title:   s.config.Branding.Title,
+		Cmdline: LiveCmdline(c.GetRootDevice(), &s.config.LiveOS),
+	}
+	if err := s.entryTemplate.Execute(out, tmplData); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.JoinDeployPath(EFIImagePath)), 00755); err != nil {
+		return err
+	}
+	binary := s.binary
+	if signer := c.GetSigner(); signer != nil {
+		// Never sign the binary of the host in place
+		binary = filepath.Join(tmp, "systemd-bootx64.efi")
+		if err := signer.SignCopy(s.binary, binary); err != nil {
+			return err
+		}
+	}
+	esp := NewESP(c.JoinDeployPath(EFIImagePath))
+	esp.Epoch = s.config.Image.Epoch()
+	esp.Add(binary, EFIBootBinary)
+	esp.Add(loaderConf, "loader/loader.conf")
+	esp.Add(entry, "loader/entries/uspin.conf")
+	esp.Add(c.JoinDeployPath(kernel.TargetPath), kernel.TargetPath)
+	esp.Add(c.JoinDeployPath(kernel.TargetInitrd), kernel.TargetInitrd)
+	return esp.Create(s.config.Boot.ESPSize)
+}
+
+// GetSpecialFile will return the ESP image for the El Torito UEFI entry
+func (s *SystemdBootLoader) GetSpecialFile(t FileType) string {
+	switch t {
+	case FileTypeEFIImage:
+		return EFIImagePath
+	default:
+		return ""
+	}
+}
@@ -17,6 +17,7 @@
 package build
 
 import (
+	"errors"
 	"fmt"
 	"libuspin"
 	"libuspin/config"
@@ -61,16 +62,53 @@ type Builder interface {
 	// OS files
 	GetRootDir() string
 
+	// GetWorkspace is used by implementations to return the workspace directory,
+	// where build state such as the plan is stored. This is known once Init
+	// has been called, so that it may be locked before PrepareWorkspace.
+	GetWorkspace() string
+
 	// Cleanup should be used by implementations to do any required cleanup operations,
 	// including killing processes, unmounting anything, etc.
 	Cleanup()
 }
 
+// BootFiles describe how to boot the finished image directly
+type BootFiles struct {
+	Media   string // The image itself, i.e. the ISO
+	Kernel  string
+	Initrd  string
+	Cmdline string // Kernel command line, without any console= options
+}
+
+// A BootTarget is a Builder whose images can be booted directly, i.e. to be
+// tested within a virtual machine.
+type BootTarget interface {
+
+	// BootFiles returns the boot files of the finished image
+	BootFiles() (*BootFiles, error)
+}
+
+// A CachedRootfs is a Builder that can reopen the rootfs left behind by a
+// previous build, so that cheap stages may be re-run against it.
+type CachedRootfs interface {
+
+	// OpenWorkspace is used in place of PrepareWorkspace and CreateStorage,
+	// returning ErrNoCachedRootfs if there is nothing to reopen
+	OpenWorkspace() error
+}
+
+// ErrNoCachedRootfs is returned when no previous build can be reopened
+var ErrNoCachedRootfs = errors.New("No cached rootfs, a full build is required first")
+
 // NewBuilder will try to return a builder for the given type
 func NewBuilder(name config.ImageType) (Builder, error) {
 	switch name {
 	case config.ImageTypeLiveOS:
 		return NewLiveOSBuilder(), nil
+	case config.ImageTypeOCI:
+		return NewOCIBuilder(), nil
+	case config.ImageTypeDisk:
+		return NewDiskBuilder(), nil
 	default:
 		return nil, fmt.Errorf("Unknown builder: %v", name)
 	}
@@ -0,0 +1,370 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"libuspin"
+	"libuspin/boot"
+	"libuspin/config"
+	"libuspin/disk"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// gptLinuxFilesystem is the GPT partition type of the root partition
+	gptLinuxFilesystem = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+
+	// diskFirstSector leaves room for the partition table and alignment
+	diskFirstSector = 2048
+)
+
+// A DiskBuilder produces a partitioned, bootable disk image for virtual
+// machines and cloud deployments. The disk holds a single root partition
+// which also carries /boot, and is attached with a loop device while the
+// rootfs is installed.
+type DiskBuilder struct {
+	img          *libuspin.ImageSpec
+	workspace    string
+	rootfsDir    string
+	diskImg      string
+	loopDevice   string // Attached loop device, empty when detached
+	rootfsFormat string
+	rootUUID     string // Filesystem UUID of the root partition
+
+	loaders []boot.Loader
+	kernel  *boot.Kernel
+}
+
+// NewDiskBuilder should only be used by builder.go
+func NewDiskBuilder() *DiskBuilder {
+	return &DiskBuilder{}
+}
+
+// Init will initialise a DiskBuilder from the given spec
+func (d *DiskBuilder) Init(img *libuspin.ImageSpec) error {
+	d.img = img
+	d.rootfsFormat = img.Config.Disk.RootfsFormat
+
+	required := []string{"sfdisk", "losetup", "blkid"}
+	if img.Config.Disk.Format == config.DiskFormatQcow2 {
+		required = append(required, "qemu-img")
+	}
+	for _, bin := range required {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	if err := checkEmulation(img.Arch); err != nil {
+		return err
+	}
+
+	var err error
+	if d.workspace, err = filepath.Abs("./workspace"); err != nil {
+		return err
+	}
+
+	if d.loaders, err = boot.InitLoaders(img.Config, img.Config.Disk.Bootloaders); err != nil {
+		return err
+	}
+	if !boot.HaveLoaderWithMask(d.loaders, boot.CapInstallRaw|boot.CapInstallLegacy) {
+		return errors.New("No usable bootloader found. Need Raw|Legacy")
+	}
+	return nil
+}
+
+// setPaths will initialise our base variables within the workspace
+func (d *DiskBuilder) setPaths() {
+	d.rootfsDir = filepath.Join(d.workspace, "rootfs")
+	d.diskImg = filepath.Join(d.workspace, "disk.img")
+}
+
+// OpenWorkspace will reuse the disk.img of a previous build
+func (d *DiskBuilder) OpenWorkspace() error {
+	d.setPaths()
+	if _, err := os.Stat(d.diskImg); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoCachedRootfs
+		}
+		return err
+	}
+	return os.MkdirAll(d.rootfsDir, 00755)
+}
+
+// PrepareWorkspace will purge any previous workspace and create a new one
+func (d *DiskBuilder) PrepareWorkspace() error {
+	if err := os.RemoveAll(d.workspace); err != nil {
+		return err
+	}
+	d.setPaths()
+	return os.MkdirAll(d.rootfsDir, 00755)
+}
+
+// diskLayout returns the sfdisk script for a disk holding a single bootable
+// root partition, identified by the given UUIDs
+func diskLayout(table config.PartitionTable, diskID, partID string) string {
+	var buf bytes.Buffer
+	switch table {
+	case config.PartitionTableMBR:
+		// DOS disk identifiers are only 32 bits
+		fmt.Fprintf(&buf, "label: dos\nlabel-id: 0x%v\n\n", strings.Replace(diskID, "-", "", -1)[:8])
+		fmt.Fprintf(&buf, "start=%v, type=83, bootable\n", diskFirstSector)
+	default:
+		fmt.Fprintf(&buf, "label: gpt\nlabel-id: %v\n\n", strings.ToUpper(diskID))
+		fmt.Fprintf(&buf, "start=%v, type=%v, uuid=%v, name=root, attrs=\"LegacyBIOSBootable\"\n",
+			diskFirstSector, gptLinuxFilesystem, strings.ToUpper(partID))
+	}
+	return buf.String()
+}
+
+// CreateStorage will create and partition disk.img, and format the root
+// partition
+func (d *DiskBuilder) CreateStorage() error {
+	if err := disk.CreateSparseFile(d.diskImg, d.img.Config.Disk.Size); err != nil {
+		return err
+	}
+	diskID, err := d.img.IDs.New("disk")
+	if err != nil {
+		return err
+	}
+	partID, err := d.img.IDs.New("root-partition")
+	if err != nil {
+		return err
+	}
+	layout := diskLayout(d.img.Config.Disk.PartitionTable, diskID.String(), partID.String())
+	log.WithFields(log.Fields{
+		"table": d.img.Config.Disk.PartitionTable,
+		"size":  d.img.Config.Disk.Size,
+	}).Info("Partitioning disk")
+	cmd := exec.Command("sfdisk", "--quiet", "--no-reread", d.diskImg)
+	cmd.Stdin = strings.NewReader(layout)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to partition disk: %v", err)
+	}
+
+	if err := d.attach(); err != nil {
+		return err
+	}
+	defer d.detach()
+	if err := disk.FormatAs(d.rootPartition(), d.rootfsFormat); err != nil {
+		return err
+	}
+	return setFilesystemUUID(d.img, d.rootPartition(), d.rootfsFormat)
+}
+
+// attach will set up the loop device for disk.img, scanning its partitions
+func (d *DiskBuilder) attach() error {
+	if d.loopDevice != "" {
+		return nil
+	}
+	out, err := exec.Command("losetup", "--find", "--show", "--partscan", d.diskImg).Output()
+	if err != nil {
+		return fmt.Errorf("Failed to attach %v: %v", d.diskImg, err)
+	}
+	d.loopDevice = strings.TrimSpace(string(out))
+	log.WithFields(log.Fields{
+		"device": d.loopDevice,
+	}).Debug("Attached disk image")
+	return nil
+}
+
+// detach will remove the loop device, if attached
+func (d *DiskBuilder) detach() error {
+	if d.loopDevice == "" {
+		return nil
+	}
+	if err := commands.ExecStdoutArgs("losetup", []string{"-d", d.loopDevice}); err != nil {
+		return err
+	}
+	d.loopDevice = ""
+	return nil
+}
+
+// rootPartition returns the partition device of the attached loop device
+func (d *DiskBuilder) rootPartition() string {
+	return d.loopDevice + "p1"
+}
+
+// MountStorage will attach disk.img and mount the root partition
+func (d *DiskBuilder) MountStorage() error {
+	if err := d.attach(); err != nil {
+		return err
+	}
+	out, err := exec.Command("blkid", "-s", "UUID", "-o", "value", d.rootPartition()).Output()
+	if err != nil {
+		return fmt.Errorf("Failed to read UUID of %v: %v", d.rootPartition(), err)
+	}
+	d.rootUUID = strings.TrimSpace(string(out))
+	if err := disk.GetMountManager().Mount(d.rootPartition(), d.rootfsDir, d.rootfsFormat); err != nil {
+		return err
+	}
+	return setupEmulation(d.rootfsDir, d.img.Arch)
+}
+
+// CollectAssets will build the initramfs, write the fstab and install the
+// bootloader while the root partition is still mounted
+func (d *DiskBuilder) CollectAssets() error {
+	kernel, err := boot.GetKernelFromRoot(d.rootfsDir)
+	if err != nil {
+		return err
+	}
+	d.kernel = kernel
+	if d.kernel.TargetPath, err = filepath.Rel(d.rootfsDir, kernel.Path); err != nil {
+		return err
+	}
+
+	drac := boot.NewDracut(d.kernel)
+	drac.Modules = boot.DracutInitModules[d.img.Config.Image.Init]
+	drac.Drivers = boot.DracutDiskDrivers
+	if err := drac.Exec(d.rootfsDir); err != nil {
+		return err
+	}
+	d.kernel.TargetInitrd = strings.TrimPrefix(drac.OutputFilename, "/")
+
+	if err := d.writeFstab(); err != nil {
+		return err
+	}
+
+	caps := boot.CapInstallRaw | boot.CapInstallLegacy
+	return boot.GetLoaderWithMask(d.loaders, caps).Install(caps, d)
+}
+
+// writeFstab will add the root partition to /etc/fstab, unless the image
+// already mounts something at /
+func (d *DiskBuilder) writeFstab() error {
+	fstab := filepath.Join(d.rootfsDir, "etc", "fstab")
+	if fi, err := os.Open(fstab); err == nil {
+		sc := bufio.NewScanner(fi)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) > 1 && !strings.HasPrefix(fields[0], "#") && fields[1] == "/" {
+				fi.Close()
+				return nil
+			}
+		}
+		fi.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fstab), 00755); err != nil {
+		return err
+	}
+	fi, err := os.OpenFile(fstab, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 00644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fi, "UUID=%v / %v defaults 0 1\n", d.rootUUID, d.rootfsFormat); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
+
+// UnmountStorage will unmount and check the root partition, and detach
+// disk.img
+func (d *DiskBuilder) UnmountStorage() error {
+	if err := teardownEmulation(d.rootfsDir, d.img.Arch); err != nil {
+		return err
+	}
+	if err := disk.GetMountManager().Unmount(d.rootfsDir); err != nil {
+		return err
+	}
+	if err := disk.CheckFS(d.rootPartition(), d.rootfsFormat); err != nil {
+		return err
+	}
+	return d.detach()
+}
+
+// FinalizeImage will deliver disk.img in the configured format. The disk.img
+// is left within the workspace so that it may be reopened.
+func (d *DiskBuilder) FinalizeImage() error {
+	output, err := filepath.Abs(d.img.OutputFilename())
+	if err != nil {
+		return err
+	}
+	if d.img.Config.Disk.Format == config.DiskFormatQcow2 {
+		return commands.ExecStdoutArgs("qemu-img", []string{"convert", "-O", "qcow2", d.diskImg, output})
+	}
+	return commands.ExecStdoutArgs("cp", []string{"--sparse=always", d.diskImg, output})
+}
+
+// GetRootDir returns the path to the mounted root partition
+func (d *DiskBuilder) GetRootDir() string {
+	return d.rootfsDir
+}
+
+// GetWorkspace returns the path to the disk workspace
+func (d *DiskBuilder) GetWorkspace() string {
+	return d.workspace
+}
+
+// Cleanup will unmount the root partition and detach disk.img
+func (d *DiskBuilder) Cleanup() {
+	log.Info("Cleaning up")
+	disk.GetMountManager().UnmountAll()
+	if err := d.detach(); err != nil {
+		log.WithFields(log.Fields{
+			"device": d.loopDevice,
+			"error":  err,
+		}).Error("Failed to detach disk image")
+	}
+}
+
+//
+// The following are all ConfigurationSource methods
+//
+
+// GetBootDevice returns the loop device of the whole disk
+func (d *DiskBuilder) GetBootDevice() string {
+	return d.loopDevice
+}
+
+// GetRootDevice returns the root partition by filesystem UUID
+func (d *DiskBuilder) GetRootDevice() string {
+	return "UUID=" + d.rootUUID
+}
+
+// JoinDeployPath is the same as JoinRootPath, as /boot is on the root
+// partition
+func (d *DiskBuilder) JoinDeployPath(paths ...string) string {
+	return d.JoinRootPath(paths...)
+}
+
+// JoinRootPath will return a path within the mounted root partition
+func (d *DiskBuilder) JoinRootPath(paths ...string) string {
+	return filepath.Join(d.rootfsDir, filepath.Join(paths...))
+}
+
+// GetKernel returns our stored kernel object
+func (d *DiskBuilder) GetKernel() *boot.Kernel {
+	return d.kernel
+}
+
+// GetSigner always returns nil, as disk images are not signed
+func (d *DiskBuilder) GetSigner() *boot.Signer {
+	return nil
+}
@@ -0,0 +1,90 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskLayout(t *testing.T) {
+	diskID := "a5c1e2f0-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+	partID := "0f1e2d3c-4b5a-4968-8776-655443322110"
+
+	gpt := diskLayout(config.PartitionTableGPT, diskID, partID)
+	for _, want := range []string{
+		"label: gpt\n",
+		"label-id: A5C1E2F0-3B4D-4E5F-8A9B-0C1D2E3F4A5B\n",
+		"uuid=0F1E2D3C-4B5A-4968-8776-655443322110",
+		"attrs=\"LegacyBIOSBootable\"",
+	} {
+		if !strings.Contains(gpt, want) {
+			t.Fatalf("GPT layout is missing %q:\n%v", want, gpt)
+		}
+	}
+	mbr := diskLayout(config.PartitionTableMBR, diskID, partID)
+	for _, want := range []string{"label: dos\n", "label-id: 0xa5c1e2f0\n", "type=83, bootable\n"} {
+		if !strings.Contains(mbr, want) {
+			t.Fatalf("MBR layout is missing %q:\n%v", want, mbr)
+		}
+	}
+}
+
+func TestWriteFstab(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-disk")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &DiskBuilder{rootfsDir: dir, rootfsFormat: "ext4", rootUUID: "1234"}
+	for i := 0; i < 2; i++ {
+		if err := d.writeFstab(); err != nil {
+			t.Fatalf("Failed to write fstab: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "etc", "fstab"))
+	if err != nil || string(data) != "UUID=1234 / ext4 defaults 0 1\n" {
+		t.Fatalf("Root should be added to fstab exactly once: %q %v", data, err)
+	}
+}
+
+func TestDiskOpenWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-workspace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &DiskBuilder{workspace: dir}
+	if err := d.OpenWorkspace(); err != ErrNoCachedRootfs {
+		t.Fatalf("Expected no cached rootfs: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "disk.img"), nil, 00644); err != nil {
+		t.Fatalf("Failed to create disk.img: %v", err)
+	}
+	if err := d.OpenWorkspace(); err != nil {
+		t.Fatalf("Failed to reopen workspace: %v", err)
+	}
+	if d.GetRootDir() != filepath.Join(dir, "rootfs") {
+		t.Fatalf("Wrong root directory: %v", d.GetRootDir())
+	}
+}
@@ -0,0 +1,140 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/tree"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// binfmtDir holds the binfmt_misc handlers registered with the kernel
+	binfmtDir = "/proc/sys/fs/binfmt_misc"
+
+	// qemuArches maps each architecture to the name used by qemu-user
+	qemuArches = map[string]string{
+		"x86_64":  "x86_64",
+		"i686":    "i386",
+		"aarch64": "aarch64",
+		"armv7h":  "arm",
+		"ppc64le": "ppc64le",
+		"riscv64": "riscv64",
+		"s390x":   "s390x",
+	}
+)
+
+// NeedsEmulation determines if binaries of the architecture need qemu to
+// run on this host
+func NeedsEmulation(arch string) bool {
+	host := config.HostArch()
+	return arch != "" && arch != host && !(host == "x86_64" && arch == "i686")
+}
+
+// binfmtInterpreter will return the interpreter of the enabled binfmt_misc
+// handler for the architecture
+func binfmtInterpreter(arch string) (string, error) {
+	name, ok := qemuArches[arch]
+	if !ok {
+		return "", fmt.Errorf("Cannot emulate unknown architecture: %v", arch)
+	}
+	handler := "qemu-" + name
+	data, err := ioutil.ReadFile(filepath.Join(binfmtDir, handler))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("No binfmt_misc handler for %v, install qemu-user-static and register it with systemd-binfmt", handler)
+		}
+		return "", err
+	}
+	var interpreter string
+	enabled := false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "enabled":
+			enabled = true
+		case strings.HasPrefix(line, "interpreter "):
+			interpreter = strings.TrimSpace(strings.TrimPrefix(line, "interpreter "))
+		}
+	}
+	if !enabled {
+		return "", fmt.Errorf("The binfmt_misc handler for %v is disabled", handler)
+	}
+	if interpreter == "" {
+		return "", fmt.Errorf("The binfmt_misc handler for %v has no interpreter", handler)
+	}
+	return interpreter, nil
+}
+
+// checkEmulation will ensure that a foreign architecture can be emulated,
+// before anything is built
+func checkEmulation(arch string) error {
+	if !NeedsEmulation(arch) {
+		return nil
+	}
+	_, err := binfmtInterpreter(arch)
+	return err
+}
+
+// setupEmulation will copy the qemu interpreter for a foreign architecture
+// into the root, at the path the kernel will look for it, so that package
+// scripts may run within the chroot
+func setupEmulation(root, arch string) error {
+	if !NeedsEmulation(arch) {
+		return nil
+	}
+	interpreter, err := binfmtInterpreter(arch)
+	if err != nil {
+		return err
+	}
+	src, err := filepath.EvalSymlinks(interpreter)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(root, interpreter)
+	if err := os.MkdirAll(filepath.Dir(dst), 00755); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"arch":        arch,
+		"interpreter": interpreter,
+	}).Info("Emulating foreign architecture")
+	return tree.CopyFile(src, dst)
+}
+
+// teardownEmulation will remove the qemu interpreter from the root again
+func teardownEmulation(root, arch string) error {
+	if !NeedsEmulation(arch) {
+		return nil
+	}
+	interpreter, err := binfmtInterpreter(arch)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(root, interpreter)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
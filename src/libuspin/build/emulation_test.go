@@ -0,0 +1,74 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmulation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-emulation")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { binfmtDir = orig }(binfmtDir)
+	binfmtDir = filepath.Join(dir, "binfmt_misc")
+	root := filepath.Join(dir, "root")
+	for _, d := range []string{binfmtDir, root} {
+		if err := os.Mkdir(d, 00755); err != nil {
+			t.Fatalf("Failed to create %v: %v", d, err)
+		}
+	}
+
+	if NeedsEmulation(config.HostArch()) {
+		t.Fatalf("The host architecture should not need emulation")
+	}
+	arch := "aarch64"
+	if config.HostArch() == arch {
+		arch = "x86_64"
+	}
+	if err := checkEmulation(arch); err == nil {
+		t.Fatalf("Emulation should fail without a binfmt_misc handler")
+	}
+
+	// Any host binary will do as the interpreter
+	interpreter := filepath.Join(dir, "qemu-static")
+	if err := ioutil.WriteFile(interpreter, []byte("qemu"), 00755); err != nil {
+		t.Fatalf("Failed to write interpreter: %v", err)
+	}
+	handler := "enabled\ninterpreter " + interpreter + "\nflags: F\noffset 0\nmagic 7f454c46\n"
+	if err := ioutil.WriteFile(filepath.Join(binfmtDir, "qemu-"+qemuArches[arch]), []byte(handler), 00644); err != nil {
+		t.Fatalf("Failed to write handler: %v", err)
+	}
+	if err := setupEmulation(root, arch); err != nil {
+		t.Fatalf("Failed to set up emulation: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, interpreter)); err != nil {
+		t.Fatalf("Interpreter was not copied into the root: %v", err)
+	}
+	if err := teardownEmulation(root, arch); err != nil {
+		t.Fatalf("Failed to tear down emulation: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, interpreter)); !os.IsNotExist(err) {
+		t.Fatalf("Interpreter was left within the root: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"fmt"
+	"libuspin"
+	"libuspin/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExportDir is the workspace directory used while exporting additional outputs
+const ExportDir = "export"
+
+// Export will build the additional output format from the finished rootfs,
+// writing it next to the image so that it is delivered along with it. The
+// workspace is used for any intermediate files.
+func Export(img *libuspin.ImageSpec, format config.OutputFormat, rootfs, workspace string) (string, error) {
+	output, err := filepath.Abs(img.OutputFilename() + format.Suffix())
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(workspace, ExportDir, string(format))
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	switch format {
+	case config.OutputFormatOCI:
+		o := &OCIBuilder{
+			img:       img,
+			workspace: dir,
+			rootfsDir: rootfs,
+			layoutDir: filepath.Join(dir, "oci"),
+		}
+		err = o.archive(output)
+	case config.OutputFormatRootfs:
+		err = archiveRootfs(rootfs, output)
+	default:
+		err = fmt.Errorf("Unknown output format: %v", format)
+	}
+	if err != nil {
+		os.Remove(output)
+		return "", err
+	}
+	return output, nil
+}
+
+// archiveRootfs will write the rootfs to a tarball, preserving ownership
+// and extended attributes
+func archiveRootfs(rootfs, output string) error {
+	args := []string{"-C", rootfs, "--sort=name", "--numeric-owner", "--xattrs", "-cf", output, "."}
+	if out, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tar failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
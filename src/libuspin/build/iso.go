@@ -0,0 +1,157 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+const (
+	// RockRidgeMaxName is the longest file name, in bytes, Rock Ridge allows
+	RockRidgeMaxName = 255
+
+	// JolietMaxName is the longest file name, in UCS-2 characters, allowed
+	// by Joliet with the -joliet-long extension
+	JolietMaxName = 103
+
+	// ISOMaxPath is the longest path, in bytes, that Linux will resolve
+	ISOMaxPath = 4095
+)
+
+// isoNameOptions are passed to xorriso so that names are stored verbatim
+// using Rock Ridge (with continuation areas for long names & symlinks) and
+// as UCS-2 using Joliet for other operating systems.
+var isoNameOptions = []string{
+	"-input-charset",
+	"utf-8",
+	"-rational-rock",
+	"-joliet",
+	"-joliet-long",
+}
+
+// A PathIssue is a problem representing a path on the ISO
+type PathIssue struct {
+	Path    string // Relative to the root of the ISO
+	Problem string
+	Fatal   bool // The path cannot be represented at all
+}
+
+func (p *PathIssue) String() string {
+	return fmt.Sprintf("%v: %v", p.Path, p.Problem)
+}
+
+// jolietLength returns the length of the name in UCS-2 characters, and
+// whether every character could be represented.
+func jolietLength(name string) (int, bool) {
+	n := 0
+	representable := true
+	for _, r := range name {
+		n++
+		if r > 0xFFFF {
+			representable = false
+		}
+	}
+	return n, representable
+}
+
+// checkISOName will determine any problems with the given entry
+func checkISOName(rel string, info os.FileInfo, target string) []*PathIssue {
+	var issues []*PathIssue
+	name := info.Name()
+	add := func(fatal bool, format string, args ...interface{}) {
+		issues = append(issues, &PathIssue{
+			Path:    rel,
+			Problem: fmt.Sprintf(format, args...),
+			Fatal:   fatal,
+		})
+	}
+
+	if !utf8.ValidString(name) {
+		add(true, "Name is not valid UTF-8")
+		return issues
+	}
+	if len(name) > RockRidgeMaxName {
+		add(true, "Name is %d bytes, longer than the Rock Ridge limit of %d", len(name), RockRidgeMaxName)
+	}
+	if len(rel) > ISOMaxPath {
+		add(true, "Path is %d bytes, longer than the limit of %d", len(rel), ISOMaxPath)
+	}
+	n, representable := jolietLength(name)
+	if n > JolietMaxName {
+		add(false, "Name will be truncated to %d characters under Joliet", JolietMaxName)
+	}
+	if !representable {
+		add(false, "Name contains characters outside of UCS-2, which will be mangled under Joliet")
+	}
+	if info.Mode()&os.ModeSymlink != 0 && !utf8.ValidString(target) {
+		add(true, "Symlink target is not valid UTF-8")
+	}
+	return issues
+}
+
+// AuditISOTree will walk the tree destined for the ISO, reporting any paths
+// that cannot be represented faithfully.
+func AuditISOTree(root string) ([]*PathIssue, error) {
+	var issues []*PathIssue
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		issues = append(issues, checkISOName(rel, info, target)...)
+		return nil
+	})
+	return issues, err
+}
+
+// checkISOTree will log all issues with the tree, failing if any of them
+// would prevent creation of a faithful ISO.
+func checkISOTree(root string) error {
+	issues, err := AuditISOTree(root)
+	if err != nil {
+		return err
+	}
+	fatal := 0
+	for _, issue := range issues {
+		fields := log.Fields{
+			"path": issue.Path,
+		}
+		if issue.Fatal {
+			fatal++
+			log.WithFields(fields).Error(issue.Problem)
+		} else {
+			log.WithFields(fields).Warning(issue.Problem)
+		}
+	}
+	if fatal > 0 {
+		return fmt.Errorf("%d path(s) cannot be represented on the ISO", fatal)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTree generates a tree of pathological names for the ISO audit
+func createTree(t *testing.T, root string, names map[string]bool) {
+	for name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, nil, 00644); err != nil {
+			t.Fatalf("Failed to create %q: %v", name, err)
+		}
+	}
+}
+
+func TestAuditISOTree(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-iso")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// Names mapped to whether they should be reported
+	names := map[string]bool{
+		"boot/isolinux/isolinux.cfg":              false,
+		"docs/Übersicht/日本語.txt":                  false,
+		strings.Repeat("x", 120):                  true, // Joliet truncation
+		"emoji/\U0001F600.png":                    true, // Outside of UCS-2
+		"bad/\xff\xfe.txt":                        true, // Invalid UTF-8
+		strings.Repeat("deep/", 60) + "file.conf": false,
+	}
+	createTree(t, root, names)
+	target := strings.Repeat("a/", 600) + "target"
+	if err := os.Symlink(target, filepath.Join(root, "longlink")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	issues, err := AuditISOTree(root)
+	if err != nil {
+		t.Fatalf("Failed to audit tree: %v", err)
+	}
+	reported := make(map[string]*PathIssue)
+	for _, issue := range issues {
+		reported[issue.Path] = issue
+	}
+	for name, want := range names {
+		if _, ok := reported[name]; ok != want {
+			t.Fatalf("Incorrect report for %q: %v", name, reported[name])
+		}
+	}
+	if issue := reported["bad/\xff\xfe.txt"]; !issue.Fatal {
+		t.Fatalf("Invalid UTF-8 should be fatal")
+	}
+	if _, ok := reported["longlink"]; ok {
+		t.Fatalf("Long symlink targets are supported by Rock Ridge")
+	}
+	if err := checkISOTree(root); err == nil {
+		t.Fatalf("Tree with fatal issues should fail")
+	}
+}
+
+func TestISODateOptions(t *testing.T) {
+	args := strings.Join(isoDateOptions(time.Unix(1500000000, 0)), " ")
+	if args != "--modification-date=2017071402400000 --set_all_file_dates 2017071402400000" {
+		t.Fatalf("Wrong date options: %v", args)
+	}
+}
@@ -20,9 +20,10 @@ import (
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/commands"
-	"github.com/solus-project/libosdev/disk"
 	"libuspin"
 	"libuspin/boot"
+	"libuspin/config"
+	"libuspin/disk"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -38,6 +39,12 @@ var (
 const (
 	// DefaultImageSize is the size of the rootfs we try to create (4GB)
 	DefaultImageSize = 4000
+
+	// capsLegacy is required of the loader booting the ISO on BIOS machines
+	capsLegacy = boot.CapInstallISO | boot.CapInstallLegacy
+
+	// capsUEFI is required of the loader booting the ISO on UEFI machines
+	capsUEFI = boot.CapInstallISO | boot.CapInstallUEFI
 )
 
 func init() {
@@ -61,13 +68,17 @@ type LiveOSBuilder struct {
 	liveStagingDir string
 	workspace      string
 
-	cdlabel string // What to name the ISO
+	cdlabel     string // What to name the ISO
+	persistence string // Image of the persistence partition, if enabled
 
 	// For storing bootloader bits
 	loaders []boot.Loader
 
 	// The kernel to be used for booting
 	kernel *boot.Kernel
+
+	// Signs the EFI binaries and kernel for Secure Boot, if enabled
+	signer *boot.Signer
 }
 
 // NewLiveOSBuilder should only be used by builder.go
@@ -85,22 +96,48 @@ func (l *LiveOSBuilder) Init(img *libuspin.ImageSpec) error {
 			return err
 		}
 	}
+	if err := checkEmulation(img.Arch); err != nil {
+		return err
+	}
+	if err := checkPersistenceBinaries(img.Config.LiveOS.Persistence); err != nil {
+		return err
+	}
 
 	// rootfs.img particulars
 	l.rootfsFormat = l.img.Config.LiveOS.RootfsFormat
 	l.rootfsSize = l.img.Config.LiveOS.RootfsSize
 	l.cdlabel = l.img.Config.LiveOS.Label
 
-	// Init the bootloaders
-	if loaders, err := boot.InitLoaders(l.img.Config, l.img.Config.LiveOS.Bootloaders); err == nil {
+	// Resolved up front so that the workspace can be locked before use
+	var err error
+	if l.workspace, err = filepath.Abs("./workspace"); err != nil {
+		return err
+	}
+
+	// Init the bootloaders, with the UEFI loader after the legacy ones
+	loaderTypes := append([]config.LoaderType{}, l.img.Config.LiveOS.Bootloaders...)
+	if l.img.Config.Boot.Loader != "" {
+		loaderTypes = append(loaderTypes, l.img.Config.Boot.Loader)
+	}
+	if loaders, err := boot.InitLoaders(l.img.Config, loaderTypes); err == nil {
 		l.loaders = loaders
 	} else {
 		return err
 	}
 
-	// Primary bootloader is one with Legacy&ISO capability
-	if !boot.HaveLoaderWithMask(l.loaders, boot.CapInstallISO|boot.CapInstallLegacy) {
-		return errors.New("No usable bootloader found. Need ISO|Legacy")
+	if bconf := l.img.Config.Boot; bconf.SecureBoot() {
+		shim := bconf.Shim
+		if shim != "" {
+			shim = l.img.JoinPath(shim)
+		}
+		if l.signer, err = boot.NewSigner(l.img.JoinPath(bconf.SecureBootKey), l.img.JoinPath(bconf.SecureBootCert), shim); err != nil {
+			return err
+		}
+	}
+
+	// Need at least one of a Legacy&ISO or UEFI&ISO capable loader
+	if !boot.HaveLoaderWithMask(l.loaders, capsLegacy) && !boot.HaveLoaderWithMask(l.loaders, capsUEFI) {
+		return errors.New("No usable bootloader found. Need ISO|Legacy or ISO|UEFI")
 	}
 
 	return nil
@@ -111,12 +148,33 @@ func (l *LiveOSBuilder) JoinPath(paths ...string) string {
 	return filepath.Join(l.workspace, filepath.Join(paths...))
 }
 
+// setPaths will initialise our base variables within the workspace
+func (l *LiveOSBuilder) setPaths() {
+	l.rootfsDir = l.JoinPath("rootfs")
+	l.deployDir = l.JoinPath("deploy")
+	// Inside the ISO target
+	l.liveosDir = l.JoinPath("deploy", "LiveOS")
+	// Inside the workspace only
+	l.liveStagingDir = l.JoinPath("LiveOS")
+	l.rootfsImg = l.JoinPath("LiveOS", "rootfs.img")
+}
+
+// OpenWorkspace will reuse the rootfs.img of a previous build, without
+// purging the workspace.
+func (l *LiveOSBuilder) OpenWorkspace() error {
+	l.setPaths()
+	if _, err := os.Stat(l.rootfsImg); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoCachedRootfs
+		}
+		return err
+	}
+	return os.MkdirAll(l.rootfsDir, 00755)
+}
+
 // PrepareWorkspace sets up the required directories for the LiveOSBuilder
 func (l *LiveOSBuilder) PrepareWorkspace() error {
 	var err error
-	if l.workspace, err = filepath.Abs("./workspace"); err != nil {
-		return err
-	}
 
 	// Purge existing workspace always
 	if st, err := os.Stat(l.workspace); err == nil {
@@ -127,14 +185,7 @@ func (l *LiveOSBuilder) PrepareWorkspace() error {
 		}
 	}
 
-	// Initialise our base variables
-	l.rootfsDir = l.JoinPath("rootfs")
-	l.deployDir = l.JoinPath("deploy")
-	// Inside the ISO target
-	l.liveosDir = l.JoinPath("deploy", "LiveOS")
-	// Inside the workspace only
-	l.liveStagingDir = l.JoinPath("LiveOS")
-	l.rootfsImg = l.JoinPath("LiveOS", "rootfs.img")
+	l.setPaths()
 
 	// As and when we add new directories, populate them here
 	requiredDirs := []string{
@@ -164,7 +215,31 @@ func (l *LiveOSBuilder) CreateStorage() error {
 	if err := disk.FormatAs(l.rootfsImg, l.rootfsFormat); err != nil {
 		return err
 	}
-	return nil
+	return l.setRootfsUUID()
+}
+
+// setRootfsUUID will apply the rootfs UUID from the ImageSpec generator, so
+// that it is reproducible when the generator is seeded.
+func (l *LiveOSBuilder) setRootfsUUID() error {
+	return setFilesystemUUID(l.img, l.rootfsImg, l.rootfsFormat)
+}
+
+// setFilesystemUUID will apply the "rootfs" UUID from the ImageSpec generator
+// to the filesystem at path
+func setFilesystemUUID(img *libuspin.ImageSpec, path, format string) error {
+	id, err := img.IDs.New("rootfs")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "ext2", "ext3", "ext4":
+		return commands.ExecStdoutArgs("tune2fs", []string{"-U", id.String(), path})
+	default:
+		log.WithFields(log.Fields{
+			"format": format,
+		}).Warning("Cannot set filesystem UUID for this format")
+		return nil
+	}
 }
 
 // Cleanup currently does nothing within this builder
@@ -176,13 +251,19 @@ func (l *LiveOSBuilder) Cleanup() {
 // MountStorage will mount the rootfs.img so that the package manager can
 // take over
 func (l *LiveOSBuilder) MountStorage() error {
-	return disk.GetMountManager().Mount(l.rootfsImg, l.rootfsDir, l.rootfsFormat, "loop")
+	if err := disk.GetMountManager().Mount(l.rootfsImg, l.rootfsDir, l.rootfsFormat, "loop"); err != nil {
+		return err
+	}
+	return setupEmulation(l.rootfsDir, l.img.Arch)
 }
 
 // UnmountStorage will unmount the rootfs.img from earlier
 // This is the last point in which the storage is used, so we check the filesystem
 // is OK here.
 func (l *LiveOSBuilder) UnmountStorage() error {
+	if err := teardownEmulation(l.rootfsDir, l.img.Arch); err != nil {
+		return err
+	}
 	if err := disk.GetMountManager().Unmount(l.rootfsDir); err != nil {
 		return err
 	}
@@ -194,16 +275,24 @@ func (l *LiveOSBuilder) GetRootDir() string {
 	return l.rootfsDir
 }
 
+// GetWorkspace returns the path to the LiveOS workspace
+func (l *LiveOSBuilder) GetWorkspace() string {
+	return l.workspace
+}
+
 // The very last call in the chain, we seal the deal by spinning the ISO
 func (l *LiveOSBuilder) spinISO() error {
-	uefi := false
 	// Get absolute path for "./${name}"
-	outputFilename := l.img.Config.LiveOS.FileName
+	outputFilename := l.img.OutputFilename()
 	if o, err := filepath.Abs(outputFilename); err == nil {
 		outputFilename = o
 	} else {
 		return err
 	}
+	if err := checkISOTree(l.deployDir); err != nil {
+		return err
+	}
+
 	volumeID := l.cdlabel
 	command := []string{
 		"-no_rc", // Forbid reading startup files which may skew ISO generation
@@ -217,44 +306,56 @@ func (l *LiveOSBuilder) spinISO() error {
 		"-appid",
 		volumeID,
 	}
+	command = append(command, isoNameOptions...)
+	if image := &l.img.Config.Image; image.Reproducible() {
+		command = append(command, isoDateOptions(image.Epoch())...)
+	}
 
-	caps := boot.CapInstallISO | boot.CapInstallLegacy
-	bloader := boot.GetLoaderWithMask(l.loaders, caps)
-
-	bootbinFile := bloader.GetSpecialFile(boot.FileTypeBootElToritoBinary)
-	bootcatFile := bloader.GetSpecialFile(boot.FileTypeBootElToritoCatalog)
-	mbrFile := bloader.GetSpecialFile(boot.FileTypeBootMBR)
-
-	// This is where we'd install syslinux or other loader..
-	// Note we'll need to do investigation for GRUB to determine precisely how to
-	// get the cat and bin files
-	if bootbinFile != "" && bootcatFile != "" {
-		command = append(command, []string{
-			"-eltorito-boot",
-			bootbinFile,
-			"-eltorito-catalog",
-			bootcatFile,
-			"-no-emul-boot",
-			"-boot-load-size",
-			"4",
-			"-boot-info-table",
-		}...)
+	// Legacy boot via El Torito, and USB via the hybrid MBR
+	if bloader := boot.GetLoaderWithMask(l.loaders, capsLegacy); bloader != nil {
+		bootbinFile := bloader.GetSpecialFile(boot.FileTypeBootElToritoBinary)
+		bootcatFile := bloader.GetSpecialFile(boot.FileTypeBootElToritoCatalog)
+		mbrFile := bloader.GetSpecialFile(boot.FileTypeBootMBR)
+
+		if bootbinFile != "" && bootcatFile != "" {
+			command = append(command, []string{
+				"-eltorito-boot",
+				bootbinFile,
+				"-eltorito-catalog",
+				bootcatFile,
+				"-no-emul-boot",
+				"-boot-load-size",
+				"4",
+				"-boot-info-table",
+			}...)
+		}
+		if mbrFile != "" {
+			command = append(command, []string{
+				"-isohybrid-mbr",
+				mbrFile,
+			}...)
+		}
 	}
-	// Enable USB booting per the bootloader
-	if mbrFile != "" {
-		command = append(command, []string{
-			"-isohybrid-mbr",
-			mbrFile,
-		}...)
+	// UEFI boot via the ESP image, as an alternative El Torito entry and
+	// a GPT partition for USB
+	if uloader := boot.GetLoaderWithMask(l.loaders, capsUEFI); uloader != nil {
+		if efiImage := uloader.GetSpecialFile(boot.FileTypeEFIImage); efiImage != "" {
+			command = append(command, []string{
+				"-eltorito-alt-boot",
+				"-e",
+				efiImage,
+				"-no-emul-boot",
+				"-isohybrid-gpt-basdat",
+			}...)
+		}
 	}
-	// Still unused for now
-	if uefi {
+	// Changes are kept here once written to USB
+	if l.persistence != "" {
 		command = append(command, []string{
-			"-eltorito-alt-boot",
-			"-e",
-			"efi.img", // TODO: Use appropriate origin
-			"-no-emul-boot",
-			"-isohybrid-gpt-basdat",
+			"-append_partition",
+			"3",
+			persistencePartitionType(l.img.Config.LiveOS.Persistence),
+			l.persistence,
 		}...)
 	}
 	// Set the output filename and directory
@@ -268,11 +369,24 @@ func (l *LiveOSBuilder) spinISO() error {
 
 // Install the bootloader for the given image
 func (l *LiveOSBuilder) installBootloader() error {
-	// For now we're only interested in legacy install, so deal with that
-	caps := boot.CapInstallISO | boot.CapInstallLegacy
-	bloader := boot.GetLoaderWithMask(l.loaders, caps)
-
-	return bloader.Install(caps, l)
+	if bloader := boot.GetLoaderWithMask(l.loaders, capsLegacy); bloader != nil {
+		if err := bloader.Install(capsLegacy, l); err != nil {
+			return err
+		}
+	}
+	// Then the UEFI loader, which also builds the ESP image
+	if uloader := boot.GetLoaderWithMask(l.loaders, capsUEFI); uloader != nil {
+		// Loaders verify the kernel they start under Secure Boot
+		if l.signer != nil {
+			if err := l.signer.Sign(l.JoinDeployPath(l.kernel.TargetPath)); err != nil {
+				return err
+			}
+		}
+		if err := uloader.Install(capsUEFI, l); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // CollectAssets will collect the kernel and create a new initramfs to be used
@@ -303,8 +417,11 @@ func (l *LiveOSBuilder) CollectAssets() error {
 
 	// Attempt to build dracut image
 	drac := boot.NewDracut(l.kernel)
-	drac.Modules = boot.DracutLiveOSModules
+	drac.Modules = append(boot.DracutInitModules[l.img.Config.Image.Init], boot.DracutLiveOSModules...)
 	drac.Drivers = boot.DracutLiveOSDrivers
+	if l.img.Config.LiveOS.Persistence != "" {
+		drac.Drivers = append(append([]string{}, drac.Drivers...), boot.DracutPersistenceDrivers...)
+	}
 	drac.OutputFilename = "/live.img"
 
 	if err := drac.Exec(l.rootfsDir); err != nil {
@@ -328,9 +445,15 @@ func (l *LiveOSBuilder) CollectAssets() error {
 
 // FinalizeImage will go ahead and finish up the ISO construction
 func (l *LiveOSBuilder) FinalizeImage() error {
+	if l.img.Config.Image.Reproducible() {
+		if err := l.repackRootfs(); err != nil {
+			return err
+		}
+	}
+
 	// First up, create the squashfs
 	squash := filepath.Join(l.liveosDir, "squashfs.img")
-	if err := disk.CreateSquashfs(l.liveStagingDir, squash, l.img.Config.LiveOS.Compression); err != nil {
+	if err := createSquashfs(l.liveStagingDir, squash, &l.img.Config.Image); err != nil {
 		return err
 	}
 
@@ -339,6 +462,14 @@ func (l *LiveOSBuilder) FinalizeImage() error {
 		return err
 	}
 
+	if l.img.Config.LiveOS.Persistence != "" {
+		var err error
+		if l.persistence, err = l.createPersistence(); err != nil {
+			return err
+		}
+		defer os.Remove(l.persistence)
+	}
+
 	// TODO: Install bootloader, copy asset files, put kernel in place, etc.
 	return l.spinISO()
 }
@@ -371,3 +502,24 @@ func (l *LiveOSBuilder) JoinRootPath(paths ...string) string {
 func (l *LiveOSBuilder) GetKernel() *boot.Kernel {
 	return l.kernel
 }
+
+// GetSigner returns the Secure Boot signer, if enabled
+func (l *LiveOSBuilder) GetSigner() *boot.Signer {
+	return l.signer
+}
+
+// BootFiles returns the kernel and initrd from the ISO tree, to boot the ISO
+func (l *LiveOSBuilder) BootFiles() (*BootFiles, error) {
+	if l.kernel == nil {
+		return nil, boot.ErrNoKernelFound
+	}
+	// Booted as a CD, which has no persistence partition to wait for
+	live := l.img.Config.LiveOS
+	live.Persistence = ""
+	return &BootFiles{
+		Media:   l.img.OutputFilename(),
+		Kernel:  l.JoinDeployPath(l.kernel.TargetPath),
+		Initrd:  l.JoinDeployPath(l.kernel.TargetInitrd),
+		Cmdline: boot.LiveCmdline(l.cdlabel, &live),
+	}, nil
+}
@@ -0,0 +1,72 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-workspace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := &LiveOSBuilder{workspace: dir}
+	if err := l.OpenWorkspace(); err != ErrNoCachedRootfs {
+		t.Fatalf("Expected no cached rootfs: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "LiveOS"), 00755); err != nil {
+		t.Fatalf("Failed to create LiveOS: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "LiveOS", "rootfs.img"), nil, 00644); err != nil {
+		t.Fatalf("Failed to create rootfs.img: %v", err)
+	}
+	if err := l.OpenWorkspace(); err != nil {
+		t.Fatalf("Failed to reopen workspace: %v", err)
+	}
+	if l.GetRootDir() != filepath.Join(dir, "rootfs") {
+		t.Fatalf("Wrong root directory: %v", l.GetRootDir())
+	}
+}
+
+func TestSquashfsArgs(t *testing.T) {
+	image := &config.SectionImage{Compression: config.CompressionXZ}
+	args := strings.Join(squashfsArgs("LiveOS", "squashfs.img", image), " ")
+	if args != "LiveOS squashfs.img -noappend -comp xz" {
+		t.Fatalf("Wrong default arguments: %v", args)
+	}
+	image.Compression = config.CompressionZstd
+	image.CompressionLevel = 19
+	image.CompressionBlockSize = 1024
+	args = strings.Join(squashfsArgs("LiveOS", "squashfs.img", image), " ")
+	if args != "LiveOS squashfs.img -noappend -comp zstd -b 1024K -Xcompression-level 19" {
+		t.Fatalf("Wrong arguments: %v", args)
+	}
+	image.BuildProfile = config.BuildProfileReproducible
+	image.SourceDateEpoch = 1500000000
+	args = strings.Join(squashfsArgs("LiveOS", "squashfs.img", image), " ")
+	if !strings.HasSuffix(args, " -all-time 1500000000 -mkfs-time 1500000000") {
+		t.Fatalf("Wrong reproducible arguments: %v", args)
+	}
+}
@@ -0,0 +1,388 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"hash"
+	"io"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/disk"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ociMediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	ociRefName           = "org.opencontainers.image.ref.name"
+)
+
+// An ociDescriptor points at a blob within the image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociImageConfig is the runtime configuration of the container
+type ociImageConfig struct {
+	Env        []string `json:"Env,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+	User       string   `json:"User,omitempty"`
+}
+
+// ociConfig is the image configuration blob
+type ociConfig struct {
+	Created      string         `json:"created"`
+	Architecture string         `json:"architecture"`
+	OS           string         `json:"os"`
+	Config       ociImageConfig `json:"config"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ociManifest describes the config and layers of the image
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociIndex is the entry point of the image layout
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// dockerManifest is the "docker save" manifest.json entry, allowing the same
+// archive to be used with "docker load"
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// An OCIBuilder produces a container image from the rootfs. The rootfs is a
+// plain directory within the workspace, and the result is an OCI image layout
+// archive which also carries a "docker save" manifest.
+type OCIBuilder struct {
+	img       *libuspin.ImageSpec
+	workspace string
+	rootfsDir string
+	layoutDir string
+}
+
+// NewOCIBuilder should only be used by builder.go
+func NewOCIBuilder() *OCIBuilder {
+	return &OCIBuilder{}
+}
+
+// Init will initialise an OCIBuilder from the given spec
+func (o *OCIBuilder) Init(img *libuspin.ImageSpec) error {
+	o.img = img
+	if _, err := exec.LookPath("tar"); err != nil {
+		return err
+	}
+	if err := checkEmulation(img.Arch); err != nil {
+		return err
+	}
+	var err error
+	o.workspace, err = filepath.Abs("./workspace")
+	return err
+}
+
+// setPaths will initialise our base variables within the workspace
+func (o *OCIBuilder) setPaths() {
+	o.rootfsDir = filepath.Join(o.workspace, "rootfs")
+	o.layoutDir = filepath.Join(o.workspace, "oci")
+}
+
+// OpenWorkspace will reuse the rootfs of a previous build
+func (o *OCIBuilder) OpenWorkspace() error {
+	o.setPaths()
+	if _, err := os.Stat(o.rootfsDir); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoCachedRootfs
+		}
+		return err
+	}
+	return nil
+}
+
+// PrepareWorkspace will purge any previous workspace and create a new one
+func (o *OCIBuilder) PrepareWorkspace() error {
+	if err := os.RemoveAll(o.workspace); err != nil {
+		return err
+	}
+	o.setPaths()
+	for _, dir := range []string{o.workspace, o.rootfsDir, o.layoutDir} {
+		if err := os.MkdirAll(dir, 00755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateStorage does nothing, as the rootfs is a plain directory
+func (o *OCIBuilder) CreateStorage() error {
+	return nil
+}
+
+// MountStorage only sets up any emulation, as the rootfs is a plain directory
+func (o *OCIBuilder) MountStorage() error {
+	return setupEmulation(o.rootfsDir, o.img.Arch)
+}
+
+// CollectAssets does nothing, as containers are booted by the host kernel
+func (o *OCIBuilder) CollectAssets() error {
+	return nil
+}
+
+// UnmountStorage only removes any emulation, as the rootfs is a plain directory
+func (o *OCIBuilder) UnmountStorage() error {
+	return teardownEmulation(o.rootfsDir, o.img.Arch)
+}
+
+// FinalizeImage will write the image layout and archive it
+func (o *OCIBuilder) FinalizeImage() error {
+	output, err := filepath.Abs(o.img.OutputFilename())
+	if err != nil {
+		return err
+	}
+	return o.archive(output)
+}
+
+// archive will write the image layout and archive it to output
+func (o *OCIBuilder) archive(output string) error {
+	if err := o.writeLayout(); err != nil {
+		return err
+	}
+	args := []string{"-C", o.layoutDir, "--sort=name", "--numeric-owner", "--owner=0", "--group=0", "-cf", output, "."}
+	if out, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tar failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// GetRootDir returns the path to the rootfs directory
+func (o *OCIBuilder) GetRootDir() string {
+	return o.rootfsDir
+}
+
+// GetWorkspace returns the path to the OCI workspace
+func (o *OCIBuilder) GetWorkspace() string {
+	return o.workspace
+}
+
+// Cleanup will unmount anything left behind by the package manager
+func (o *OCIBuilder) Cleanup() {
+	log.Info("Cleaning up")
+	disk.GetMountManager().UnmountAll()
+}
+
+// tag returns the name and tag of the image, i.e. "minimal:latest"
+func (o *OCIBuilder) tag() string {
+	tag := o.img.Config.OCI.Tag
+	if tag == "" {
+		tag = o.img.Profile
+	}
+	if !strings.Contains(tag, ":") {
+		tag += ":latest"
+	}
+	return tag
+}
+
+// ociArch maps distribution architecture names onto those used by OCI
+func ociArch(arch string) string {
+	switch arch {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "i686":
+		return "386"
+	default:
+		return arch
+	}
+}
+
+// writeLayout will write the rootfs as a single layer image into the layout
+// directory
+func (o *OCIBuilder) writeLayout() error {
+	if err := os.MkdirAll(filepath.Join(o.layoutDir, "blobs", "sha256"), 00755); err != nil {
+		return err
+	}
+	layer, diffID, err := o.writeLayer()
+	if err != nil {
+		return err
+	}
+
+	created := time.Now()
+	if image := &o.img.Config.Image; image.Reproducible() {
+		created = image.Epoch()
+	}
+	conf := &ociConfig{
+		Created:      created.UTC().Format(time.RFC3339),
+		Architecture: ociArch(o.img.Arch),
+		OS:           "linux",
+		Config: ociImageConfig{
+			Env:        o.img.Config.OCI.Env,
+			Cmd:        o.img.Config.OCI.Cmd,
+			Entrypoint: o.img.Config.OCI.Entrypoint,
+			WorkingDir: o.img.Config.OCI.WorkingDir,
+			User:       o.img.Config.OCI.User,
+		},
+	}
+	conf.RootFS.Type = "layers"
+	conf.RootFS.DiffIDs = []string{diffID}
+	config, err := o.writeJSONBlob(ociMediaTypeConfig, conf)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := o.writeJSONBlob(ociMediaTypeManifest, &ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeManifest,
+		Config:        *config,
+		Layers:        []ociDescriptor{*layer},
+	})
+	if err != nil {
+		return err
+	}
+
+	tag := o.tag()
+	manifest.Annotations = map[string]string{ociRefName: tag[strings.LastIndex(tag, ":")+1:]}
+	files := map[string]interface{}{
+		"oci-layout": map[string]string{"imageLayoutVersion": "1.0.0"},
+		"index.json": &ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{*manifest}},
+		"manifest.json": []dockerManifest{{
+			Config:   blobPath(config.Digest),
+			RepoTags: []string{tag},
+			Layers:   []string{blobPath(layer.Digest)},
+		}},
+	}
+	for name, value := range files {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(o.layoutDir, name), data, 00644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobPath returns the path of a blob within the layout
+func blobPath(digest string) string {
+	return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+// writeJSONBlob will store the value as a blob
+func (o *OCIBuilder) writeJSONBlob(mediaType string, value interface{}) (*ociDescriptor, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	d := &ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+	return d, ioutil.WriteFile(filepath.Join(o.layoutDir, blobPath(d.Digest)), data, 00644)
+}
+
+// writeLayer will archive the rootfs into a compressed layer blob, returning
+// its descriptor and the digest of the uncompressed archive
+func (o *OCIBuilder) writeLayer() (*ociDescriptor, string, error) {
+	archive := filepath.Join(o.workspace, "layer.tar")
+	defer os.Remove(archive)
+	args := []string{"-C", o.rootfsDir, "--sort=name", "--numeric-owner", "--xattrs"}
+	if image := &o.img.Config.Image; image.Reproducible() {
+		args = append(args, fmt.Sprintf("--mtime=@%d", image.SourceDateEpoch), "--clamp-mtime")
+	}
+	args = append(args, "-cf", archive, ".")
+	if out, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("tar failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	in, err := os.Open(archive)
+	if err != nil {
+		return nil, "", err
+	}
+	defer in.Close()
+
+	tmp := filepath.Join(o.layoutDir, "layer.tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(tmp)
+
+	compressed := &countingHash{Hash: sha256.New()}
+	uncompressed := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(out, compressed))
+	if _, err := io.Copy(io.MultiWriter(gz, uncompressed), in); err != nil {
+		out.Close()
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return nil, "", err
+	}
+	if err := out.Close(); err != nil {
+		return nil, "", err
+	}
+
+	d := &ociDescriptor{
+		MediaType: ociMediaTypeLayer,
+		Digest:    "sha256:" + hex.EncodeToString(compressed.Sum(nil)),
+		Size:      compressed.n,
+	}
+	if err := os.Rename(tmp, filepath.Join(o.layoutDir, blobPath(d.Digest))); err != nil {
+		return nil, "", err
+	}
+	return d, "sha256:" + hex.EncodeToString(uncompressed.Sum(nil)), nil
+}
+
+// countingHash hashes and counts everything written to it
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.Hash.Write(p)
+}
@@ -0,0 +1,139 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOCIImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-oci")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &config.ImageConfiguration{}
+	conf.Image.Type = config.ImageTypeOCI
+	conf.Image.FileName = filepath.Join(dir, "minimal.tar")
+	if err := config.ValidateSectionOCI(&conf.OCI); err != nil {
+		t.Fatalf("Failed to validate oci section: %v", err)
+	}
+	o := &OCIBuilder{
+		img:       &libuspin.ImageSpec{Config: conf, Arch: "x86_64", Profile: "minimal"},
+		workspace: filepath.Join(dir, "workspace"),
+	}
+	if err := o.PrepareWorkspace(); err != nil {
+		t.Fatalf("Failed to prepare workspace: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(o.GetRootDir(), "hello"), []byte("world"), 00644); err != nil {
+		t.Fatalf("Failed to populate rootfs: %v", err)
+	}
+	if err := o.FinalizeImage(); err != nil {
+		t.Fatalf("Failed to finalize image: %v", err)
+	}
+
+	out, err := exec.Command("tar", "-tf", conf.Image.FileName).Output()
+	if err != nil {
+		t.Fatalf("Failed to list image: %v", err)
+	}
+	for _, name := range []string{"./oci-layout", "./index.json", "./manifest.json"} {
+		if !strings.Contains(string(out), name+"\n") {
+			t.Fatalf("Image is missing %v:\n%s", name, out)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(o.layoutDir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Failed to read docker manifest: %v", err)
+	}
+	var manifests []dockerManifest
+	if err := json.Unmarshal(data, &manifests); err != nil {
+		t.Fatalf("Invalid docker manifest: %v", err)
+	}
+	if len(manifests) != 1 || manifests[0].RepoTags[0] != "minimal:latest" || len(manifests[0].Layers) != 1 {
+		t.Fatalf("Wrong docker manifest: %+v", manifests)
+	}
+	layer := filepath.Join(o.layoutDir, manifests[0].Layers[0])
+	if out, err = exec.Command("tar", "-tzf", layer).Output(); err != nil || !strings.Contains(string(out), "./hello") {
+		t.Fatalf("Layer does not contain the rootfs: %v: %s", err, out)
+	}
+
+	var imageConf ociConfig
+	data, err = ioutil.ReadFile(filepath.Join(o.layoutDir, manifests[0].Config))
+	if err != nil || json.Unmarshal(data, &imageConf) != nil {
+		t.Fatalf("Failed to read image config: %v", err)
+	}
+	if imageConf.Architecture != "amd64" || imageConf.Config.Cmd[0] != "/bin/sh" || len(imageConf.RootFS.DiffIDs) != 1 {
+		t.Fatalf("Wrong image config: %+v", imageConf)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-export")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(rootfs, 00755); err != nil {
+		t.Fatalf("Failed to create rootfs: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "hello"), []byte("world"), 00644); err != nil {
+		t.Fatalf("Failed to populate rootfs: %v", err)
+	}
+	conf := &config.ImageConfiguration{}
+	conf.Image.Type = config.ImageTypeLiveOS
+	conf.Image.FileName = filepath.Join(dir, "minimal.iso")
+	if err := config.ValidateSectionOCI(&conf.OCI); err != nil {
+		t.Fatalf("Failed to validate oci section: %v", err)
+	}
+	img := &libuspin.ImageSpec{Config: conf, Arch: "x86_64", Profile: "minimal"}
+
+	for _, format := range []config.OutputFormat{config.OutputFormatOCI, config.OutputFormatRootfs} {
+		output, err := Export(img, format, rootfs, filepath.Join(dir, "workspace"))
+		if err != nil {
+			t.Fatalf("Failed to export %v: %v", format, err)
+		}
+		if output != conf.Image.FileName+format.Suffix() {
+			t.Fatalf("Incorrect output for %v: %v", format, output)
+		}
+		out, err := exec.Command("tar", "-tf", output).Output()
+		if err != nil {
+			t.Fatalf("Failed to list %v: %v", output, err)
+		}
+		want := "./index.json\n"
+		if format == config.OutputFormatRootfs {
+			want = "./hello\n"
+		}
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("Export %v is missing %v:\n%s", format, want, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "workspace", ExportDir, "oci")); !os.IsNotExist(err) {
+		t.Fatalf("Intermediate files should be removed")
+	}
+}
@@ -0,0 +1,117 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"github.com/solus-project/libosdev/commands"
+	"libuspin/boot"
+	"libuspin/config"
+	"libuspin/disk"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+)
+
+// persistenceBinaries returns the host tools needed to create the
+// persistence partition
+func persistenceBinaries(p config.Persistence) []string {
+	switch p {
+	case config.PersistencePartition:
+		return []string{"mkfs.ext4"}
+	case config.PersistenceFile:
+		return boot.ESPBinaries
+	default:
+		return nil
+	}
+}
+
+// checkPersistenceBinaries will ensure the host can create the persistence
+// partition, if enabled
+func checkPersistenceBinaries(p config.Persistence) error {
+	for _, bin := range persistenceBinaries(p) {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistencePartitionType returns the MBR type of the appended partition
+func persistencePartitionType(p config.Persistence) string {
+	if p == config.PersistenceFile {
+		return "0x0c" // FAT32 (LBA)
+	}
+	return "0x83" // Linux
+}
+
+// createPersistence will create the image of the persistence partition
+// within the workspace, to be appended to the hybrid ISO, returning its
+// path. Changes are only kept once the ISO has been written to USB.
+func (l *LiveOSBuilder) createPersistence() (string, error) {
+	live := &l.img.Config.LiveOS
+	image := filepath.Join(l.workspace, "persistence.img")
+	if err := os.Remove(image); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := disk.CreateSparseFile(image, live.PersistenceSize); err != nil {
+		return "", err
+	}
+	if live.Persistence == config.PersistencePartition {
+		id, err := l.img.IDs.New("persistence")
+		if err != nil {
+			return "", err
+		}
+		seed, err := l.img.IDs.New("persistence-hash-seed")
+		if err != nil {
+			return "", err
+		}
+		return image, commands.ExecStdoutArgs("mkfs.ext4", []string{
+			"-F",
+			"-q",
+			"-L", live.PersistenceLabel,
+			"-U", id.String(),
+			"-E", "hash_seed=" + seed.String(),
+			image,
+		})
+	}
+
+	// An empty overlay file on a FAT partition, leaving room for the FAT
+	mkfs := []string{"-n", live.PersistenceLabel, image}
+	copyArgs := []string{"-i", image}
+	if l.img.Config.Image.Reproducible() {
+		mkfs = append([]string{"--invariant"}, mkfs...)
+		copyArgs = append(copyArgs, "-m")
+	}
+	if err := commands.ExecStdoutArgs("mkfs.vfat", mkfs); err != nil {
+		return "", err
+	}
+	if err := commands.ExecStdoutArgs("mmd", []string{"-i", image, "::/" + path.Dir(config.PersistenceOverlayFile)}); err != nil {
+		return "", err
+	}
+	overlay := filepath.Join(l.workspace, "overlay.img")
+	defer os.Remove(overlay)
+	if err := disk.CreateSparseFile(overlay, live.PersistenceSize-live.PersistenceSize/16); err != nil {
+		return "", err
+	}
+	if epoch := l.img.Config.Image.Epoch(); !epoch.IsZero() {
+		if err := os.Chtimes(overlay, epoch, epoch); err != nil {
+			return "", err
+		}
+	}
+	return image, commands.ExecStdoutArgs("mcopy", append(copyArgs, overlay, "::/"+config.PersistenceOverlayFile))
+}
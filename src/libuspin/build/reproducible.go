@@ -0,0 +1,84 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"libuspin/disk"
+	"os"
+	"time"
+)
+
+// isoDateOptions are passed to xorriso so that the volume and every file
+// on the ISO are dated at the epoch
+func isoDateOptions(epoch time.Time) []string {
+	stamp := epoch.UTC().Format("20060102150405") + "00"
+	return []string{
+		"--modification-date=" + stamp,
+		"--set_all_file_dates",
+		stamp,
+	}
+}
+
+// repackRootfs will recreate the rootfs.img from its own contents, so that
+// it no longer records when and in which order the build wrote to it. The
+// filesystem is populated directly by mkfs, which takes its timestamps from
+// SOURCE_DATE_EPOCH, and hashes directories with a seed from the ImageSpec.
+func (l *LiveOSBuilder) repackRootfs() error {
+	switch l.rootfsFormat {
+	case "ext2", "ext3", "ext4":
+	default:
+		log.WithFields(log.Fields{
+			"format": l.rootfsFormat,
+		}).Warning("Cannot repack this rootfs format reproducibly")
+		return nil
+	}
+	id, err := l.img.IDs.New("rootfs")
+	if err != nil {
+		return err
+	}
+	seed, err := l.img.IDs.New("rootfs-hash-seed")
+	if err != nil {
+		return err
+	}
+
+	repacked := l.rootfsImg + ".repack"
+	defer os.Remove(repacked)
+	if err := disk.CreateSparseFile(repacked, l.rootfsSize); err != nil {
+		return err
+	}
+	mm := disk.GetMountManager()
+	if err := mm.Mount(l.rootfsImg, l.rootfsDir, l.rootfsFormat, "loop", "ro"); err != nil {
+		return err
+	}
+	err = commands.ExecStdoutArgs("mkfs."+l.rootfsFormat, []string{
+		"-F",
+		"-q",
+		"-d", l.rootfsDir,
+		"-U", id.String(),
+		"-E", "hash_seed=" + seed.String(),
+		repacked,
+	})
+	if uerr := mm.Unmount(l.rootfsDir); err == nil {
+		err = uerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(repacked, l.rootfsImg)
+}
@@ -0,0 +1,52 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"libuspin/config"
+)
+
+// squashfsArgs returns the mksquashfs arguments compressing dir into out per
+// the image configuration
+func squashfsArgs(dir, out string, image *config.SectionImage) []string {
+	args := []string{
+		dir,
+		out,
+		"-noappend",
+		"-comp",
+		string(image.Compression),
+	}
+	if image.CompressionBlockSize != 0 {
+		args = append(args, "-b", fmt.Sprintf("%dK", image.CompressionBlockSize))
+	}
+	if image.CompressionLevel != 0 {
+		args = append(args, "-Xcompression-level", fmt.Sprint(image.CompressionLevel))
+	}
+	// Every file and the filesystem itself are dated at the epoch
+	if image.Reproducible() {
+		epoch := fmt.Sprint(image.SourceDateEpoch)
+		args = append(args, "-all-time", epoch, "-mkfs-time", epoch)
+	}
+	return args
+}
+
+// createSquashfs will compress dir into the squashfs image out
+func createSquashfs(dir, out string, image *config.SectionImage) error {
+	return commands.ExecStdoutArgs("mksquashfs", squashfsArgs(dir, out, image))
+}
@@ -0,0 +1,179 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package checkpoint snapshots the rootfs after the expensive stages of a
+// build, so that a later build whose inputs to those stages are unchanged
+// can restore the snapshot instead of repeating them.
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Checkpoint is the stored state of the build after the named stage
+type Checkpoint struct {
+	Name    string    `json:"name"`
+	Key     string    `json:"key"` // Identifies the inputs of every stage up to this one
+	Created time.Time `json:"created"`
+	Files   []string  `json:"files,omitempty"` // Workspace files stored alongside the rootfs
+}
+
+// A Store is a directory of checkpoints, one per stage
+type Store struct {
+	dir string
+}
+
+// Open will open the store at dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// tar runs tar, including its output within any error
+func tar(args ...string) error {
+	out, err := exec.Command("tar", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tar failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// paths returns the locations of the metadata, rootfs archive and stored
+// files of the named checkpoint
+func (s *Store) paths(name string) (meta, archive, files string) {
+	base := filepath.Join(s.dir, filepath.Base(name))
+	return base + ".json", base + ".tar", base + ".files"
+}
+
+// Lookup will return the named checkpoint if it was stored with the key
+func (s *Store) Lookup(name, key string) (*Checkpoint, bool) {
+	meta, archive, _ := s.paths(name)
+	data, err := ioutil.ReadFile(meta)
+	if err != nil {
+		return nil, false
+	}
+	c := &Checkpoint{}
+	if err := json.Unmarshal(data, c); err != nil || c.Key != key {
+		return nil, false
+	}
+	if _, err := os.Stat(archive); err != nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// Save will snapshot root as the named checkpoint, replacing any previous
+// one. Paths in exclude, relative to root, keep their directory but not
+// their contents, i.e. mount points. The files are stored by base name.
+func (s *Store) Save(name, key, root string, exclude, files []string) error {
+	meta, archive, filesDir := s.paths(name)
+
+	// The metadata marks the checkpoint valid, so goes first and last
+	if err := os.Remove(meta); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	args := []string{"-C", root, "--numeric-owner", "--xattrs", "--xattrs-include=*", "--acls"}
+	for _, path := range exclude {
+		args = append(args, "--exclude", "./"+strings.Trim(path, "/")+"/*")
+	}
+	if err := tar(append(args, "-cf", archive+".tmp", ".")...); err != nil {
+		os.Remove(archive + ".tmp")
+		return err
+	}
+	if err := os.Rename(archive+".tmp", archive); err != nil {
+		return err
+	}
+
+	c := &Checkpoint{Name: name, Key: key, Created: time.Now().UTC()}
+	if err := os.RemoveAll(filesDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filesDir, 00755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := copyFile(file, filepath.Join(filesDir, filepath.Base(file))); err != nil {
+			return err
+		}
+		c.Files = append(c.Files, filepath.Base(file))
+	}
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(meta, data, 00644)
+}
+
+// Restore will extract the checkpoint into root, which should be empty, and
+// its stored files into dir. root is emptied again if extraction fails.
+func (s *Store) Restore(c *Checkpoint, root, dir string) error {
+	_, archive, filesDir := s.paths(c.Name)
+	if err := tar("-C", root, "--numeric-owner", "--xattrs", "--xattrs-include=*", "--acls", "-xpf", archive); err != nil {
+		if cleanErr := empty(root); cleanErr != nil {
+			return fmt.Errorf("%v, and cannot empty the rootfs: %v", err, cleanErr)
+		}
+		return err
+	}
+	for _, file := range c.Files {
+		if err := copyFile(filepath.Join(filesDir, file), filepath.Join(dir, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove will delete the named checkpoint, if it exists
+func (s *Store) Remove(name string) error {
+	meta, archive, filesDir := s.paths(name)
+	for _, path := range []string{meta, archive, filesDir} {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// empty will remove everything within dir, but not dir itself
+func empty(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile will copy the regular file at src to dst
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 00644)
+}
@@ -0,0 +1,93 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-checkpoint")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	workspace := filepath.Join(dir, "workspace")
+	files := map[string]string{
+		"root/etc/hostname":   "solus\n",
+		"root/proc/self":      "mounted\n",
+		"workspace/plan.json": "{}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 00755)
+		if err := ioutil.WriteFile(path, []byte(content), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	store, err := Open(filepath.Join(dir, "checkpoints"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, ok := store.Lookup("rootfs", "abc"); ok {
+		t.Fatalf("Empty store should have no checkpoints")
+	}
+	plan := filepath.Join(workspace, "plan.json")
+	if err := store.Save("rootfs", "abc", root, []string{"/proc"}, []string{plan}); err != nil {
+		t.Fatalf("Failed to save checkpoint: %v", err)
+	}
+	if _, ok := store.Lookup("rootfs", "def"); ok {
+		t.Fatalf("Checkpoints with other inputs should not be used")
+	}
+	c, ok := store.Lookup("rootfs", "abc")
+	if !ok {
+		t.Fatalf("Saved checkpoint not found")
+	}
+
+	os.RemoveAll(root)
+	os.RemoveAll(workspace)
+	os.MkdirAll(root, 00755)
+	os.MkdirAll(workspace, 00755)
+	if err := store.Restore(c, root, workspace); err != nil {
+		t.Fatalf("Failed to restore checkpoint: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "etc/hostname")); string(data) != "solus\n" {
+		t.Fatalf("Rootfs not restored: %q", data)
+	}
+	if st, err := os.Stat(filepath.Join(root, "proc")); err != nil || !st.IsDir() {
+		t.Fatalf("Excluded mount point should be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "proc/self")); !os.IsNotExist(err) {
+		t.Fatalf("Excluded contents should not be stored: %v", err)
+	}
+	if _, err := os.Stat(plan); err != nil {
+		t.Fatalf("Workspace files not restored: %v", err)
+	}
+
+	if err := store.Remove("rootfs"); err != nil {
+		t.Fatalf("Failed to remove checkpoint: %v", err)
+	}
+	if _, ok := store.Lookup("rootfs", "abc"); ok {
+		t.Fatalf("Removed checkpoint should not be found")
+	}
+}
@@ -0,0 +1,130 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package chroot
+
+import (
+	"bufio"
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// CCacheTarget is where the host ccache directory is mounted in the chroot
+const CCacheTarget = "/var/cache/ccache"
+
+// CCacheStats are the counters reported by "ccache --print-stats"
+type CCacheStats map[string]int64
+
+// Hits returns the total number of cache hits
+func (s CCacheStats) Hits() int64 {
+	return s["direct_cache_hit"] + s["preprocessed_cache_hit"]
+}
+
+// Misses returns the total number of cache misses
+func (s CCacheStats) Misses() int64 {
+	return s["cache_miss"]
+}
+
+// Sub returns the difference between the two sets of counters
+func (s CCacheStats) Sub(base CCacheStats) CCacheStats {
+	ret := make(CCacheStats)
+	for key, val := range s {
+		ret[key] = val - base[key]
+	}
+	return ret
+}
+
+// parseCCacheStats will parse the tab separated "--print-stats" output
+func parseCCacheStats(data []byte) CCacheStats {
+	ret := make(CCacheStats)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) != 2 {
+			continue
+		}
+		if val, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			ret[fields[0]] = val
+		}
+	}
+	return ret
+}
+
+// UseCCache will make the host cache directory available to every command,
+// with the compiler wrappers taking priority in the PATH.
+func (c *Chroot) UseCCache(dir, maxSize, wrappers string) {
+	c.Binds = append(c.Binds, &Bind{
+		Source: dir,
+		Target: CCacheTarget,
+		Create: true,
+	})
+	c.Env = append(c.Env, "CCACHE_DIR="+CCacheTarget)
+	if maxSize != "" {
+		c.Env = append(c.Env, "CCACHE_MAXSIZE="+maxSize)
+	}
+	c.path = wrappers + ":" + c.path
+	c.ccache = true
+}
+
+// CCacheStats will return the current ccache counters. The chroot must
+// have been entered.
+func (c *Chroot) CCacheStats() (CCacheStats, error) {
+	cmd := c.Command("ccache --print-stats")
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseCCacheStats(out), nil
+}
+
+// recordCCache will store the baseline counters on the first Enter, so that
+// statistics can be reported for this build alone.
+func (c *Chroot) recordCCache() {
+	if !c.ccache || c.ccacheBase != nil {
+		return
+	}
+	stats, err := c.CCacheStats()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to read ccache statistics")
+		return
+	}
+	c.ccacheBase = stats
+}
+
+// reportCCache will log the ccache statistics of this build so far
+func (c *Chroot) reportCCache() {
+	if c.ccacheBase == nil {
+		return
+	}
+	stats, err := c.CCacheStats()
+	if err != nil {
+		return
+	}
+	delta := stats.Sub(c.ccacheBase)
+	fields := log.Fields{
+		"hits":   delta.Hits(),
+		"misses": delta.Misses(),
+	}
+	if total := delta.Hits() + delta.Misses(); total > 0 {
+		fields["hitRate"] = strconv.FormatInt(100*delta.Hits()/total, 10) + "%"
+	}
+	log.WithFields(fields).Info("ccache statistics for this build")
+}
@@ -0,0 +1,192 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package chroot
+
+import (
+	"bufio"
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// BackupSuffix is appended to the image's own files while replaced
+	BackupSuffix = ".uspin-orig"
+
+	// GeneratedMarker begins every file written for the chroot session
+	GeneratedMarker = "# Written by uspin for the build chroot, restored on exit\n"
+)
+
+var (
+	// hostResolvConf is the resolver configuration of the host
+	hostResolvConf = "/etc/resolv.conf"
+
+	// resolvedUpstream lists the real servers behind the systemd-resolved stub
+	resolvedUpstream = "/run/systemd/resolve/resolv.conf"
+
+	// resolvedStubs are the systemd-resolved stub listener addresses
+	resolvedStubs = map[string]bool{
+		"127.0.0.53": true,
+		"127.0.0.54": true,
+	}
+)
+
+// isStubOnly returns true if every nameserver is a systemd-resolved stub
+func isStubOnly(data []byte) bool {
+	found := false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if !resolvedStubs[fields[1]] {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// resolvConf returns the resolver configuration used within the chroot. The
+// systemd-resolved stub is bypassed in favour of the upstream servers, as
+// the image has no resolved of its own running and may be in another netns.
+func resolvConf() ([]byte, error) {
+	data, err := ioutil.ReadFile(hostResolvConf)
+	if err != nil {
+		return nil, err
+	}
+	if isStubOnly(data) {
+		if upstream, err := ioutil.ReadFile(resolvedUpstream); err == nil {
+			data = upstream
+		} else {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warning("Host uses the systemd-resolved stub without upstream servers")
+		}
+	}
+	return append([]byte(GeneratedMarker), data...), nil
+}
+
+// nsswitchConf returns the image's nsswitch.conf with hosts resolved from
+// files and DNS alone. NSS modules such as resolve or mymachines cannot work
+// within the chroot, and may prevent falling back to DNS.
+func nsswitchConf(orig []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(GeneratedMarker)
+	hosts := false
+	sc := bufio.NewScanner(bytes.NewReader(orig))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "hosts:") {
+			line = "hosts: files dns"
+			hosts = true
+		}
+		buf.WriteString(line + "\n")
+	}
+	if len(orig) == 0 {
+		buf.WriteString("passwd: files\ngroup: files\nshadow: files\n")
+	}
+	if !hosts {
+		buf.WriteString("hosts: files dns\n")
+	}
+	return buf.Bytes()
+}
+
+// isGenerated returns true if the file was written for a chroot session
+func isGenerated(path string) bool {
+	if st, err := os.Lstat(path); err != nil || !st.Mode().IsRegular() {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	return err == nil && bytes.HasPrefix(data, []byte(GeneratedMarker))
+}
+
+// restoreFile will put the image's own file back in place, if any
+func (c *Chroot) restoreFile(name string) error {
+	path := c.target(name)
+	backup := path + BackupSuffix
+	if _, err := os.Lstat(backup); err != nil {
+		// Never remove the image's own file
+		if isGenerated(path) {
+			return os.Remove(path)
+		}
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(backup, path)
+}
+
+// replaceFile will move the image's file aside, which may well be a symlink,
+// and replace it for the duration of the session.
+func (c *Chroot) replaceFile(name string, data []byte) error {
+	path := c.target(name)
+	// Left behind by a session that never left, i.e. a crash
+	if err := c.restoreFile(name); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		if err := os.Rename(path, path+BackupSuffix); err != nil {
+			return err
+		}
+	}
+	c.replaced = append(c.replaced, name)
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 00644)
+}
+
+// setupDNS will make name resolution within the chroot use the host's
+// resolvers, whatever the image's own configuration is.
+func (c *Chroot) setupDNS() error {
+	resolv, err := resolvConf()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("No host resolver configuration, chroot will have no DNS")
+	} else if err := c.replaceFile("/etc/resolv.conf", resolv); err != nil {
+		return err
+	}
+
+	// A symlink would be resolved against the host, so only trust real files
+	var orig []byte
+	nsswitch := c.target("/etc/nsswitch.conf")
+	if st, err := os.Lstat(nsswitch); err == nil && st.Mode().IsRegular() && !isGenerated(nsswitch) {
+		if orig, err = ioutil.ReadFile(nsswitch); err != nil {
+			return err
+		}
+	}
+	return c.replaceFile("/etc/nsswitch.conf", nsswitchConf(orig))
+}
+
+// restoreDNS will restore the image's own resolver configuration
+func (c *Chroot) restoreDNS() error {
+	var failed error
+	for i := len(c.replaced) - 1; i >= 0; i-- {
+		if err := c.restoreFile(c.replaced[i]); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	c.replaced = nil
+	return failed
+}
@@ -0,0 +1,223 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package chroot runs commands within the rootfs during the build, with the
+// pseudo filesystems and any configured host directories mounted.
+package chroot
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"libuspin/config"
+	"libuspin/disk"
+	"libuspin/process"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Bind is a host directory to bind mount into the chroot
+type Bind struct {
+	Source   string // Absolute host path
+	Target   string // Absolute path within the chroot
+	ReadOnly bool
+	Create   bool // Create the source if it doesn't exist, i.e. caches
+}
+
+// BindsFromConfig will convert the configured mounts into binds, resolving
+// relative sources against baseDir.
+func BindsFromConfig(mounts []config.SectionMount, baseDir string) []*Bind {
+	var ret []*Bind
+	for _, m := range mounts {
+		source := m.Source
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(baseDir, source)
+		}
+		ret = append(ret, &Bind{
+			Source:   source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return ret
+}
+
+// A Chroot runs commands within the root directory. Mounts are registered
+// with the disk mount manager, so they are always torn down by the builder
+// cleanup even if Leave is never reached.
+type Chroot struct {
+	Root  string
+	Env   []string // Environment of every command, i.e. secrets
+	Binds []*Bind
+
+	Stdout io.Writer // Output of every command, os.Stdout unless redacted
+	Stderr io.Writer // Errors of every command, os.Stderr unless redacted
+
+	mounted  []string // Mounted targets, in order
+	replaced []string // Image files replaced for the session, see setupDNS
+	path     string   // PATH of every command
+
+	ccache     bool
+	ccacheBase CCacheStats // Counters when first entered
+}
+
+// DefaultPath is the PATH of commands within the chroot
+const DefaultPath = "/usr/sbin:/usr/bin:/sbin:/bin"
+
+// New will return a Chroot for the root directory
+func New(root string, binds []*Bind, env []string) *Chroot {
+	return &Chroot{
+		Root:   root,
+		Env:    env,
+		Binds:  binds,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		path:   DefaultPath,
+	}
+}
+
+// target will return the host path of the path within the chroot
+func (c *Chroot) target(path string) string {
+	return filepath.Join(c.Root, strings.TrimPrefix(filepath.Clean(path), "/"))
+}
+
+// mount will perform a single mount, tracking it for Leave
+func (c *Chroot) mount(target string, fn func() error) error {
+	if err := os.MkdirAll(target, 00755); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	c.mounted = append(c.mounted, target)
+	return nil
+}
+
+// Enter will mount the pseudo filesystems and binds within the chroot
+func (c *Chroot) Enter() error {
+	mm := disk.GetMountManager()
+	pseudo := []struct {
+		source string
+		target string
+		fs     string
+	}{
+		{"proc", "/proc", "proc"},
+		{"sysfs", "/sys", "sysfs"},
+		{"devtmpfs", "/dev", "devtmpfs"},
+		{"devpts", "/dev/pts", "devpts"},
+	}
+	for _, p := range pseudo {
+		p := p
+		if err := c.mount(c.target(p.target), func() error {
+			return mm.Mount(p.source, c.target(p.target), p.fs)
+		}); err != nil {
+			c.Leave()
+			return err
+		}
+	}
+	for _, b := range c.Binds {
+		b := b
+		if b.Create {
+			if err := os.MkdirAll(b.Source, 00755); err != nil {
+				c.Leave()
+				return err
+			}
+		}
+		if st, err := os.Stat(b.Source); err != nil || !st.IsDir() {
+			c.Leave()
+			return fmt.Errorf("Mount source is not a directory: %v", b.Source)
+		}
+		var opts []string
+		if b.ReadOnly {
+			opts = append(opts, "ro")
+		}
+		log.WithFields(log.Fields{
+			"source":   b.Source,
+			"target":   b.Target,
+			"readOnly": b.ReadOnly,
+		}).Debug("Bind mounting host directory")
+		if err := c.mount(c.target(b.Target), func() error {
+			return mm.BindMount(b.Source, c.target(b.Target), opts...)
+		}); err != nil {
+			c.Leave()
+			return err
+		}
+	}
+	if err := c.setupDNS(); err != nil {
+		c.Leave()
+		return err
+	}
+	c.recordCCache()
+	return nil
+}
+
+// Leave will restore the image's files and unmount everything mounted by
+// Enter, in reverse order
+func (c *Chroot) Leave() error {
+	c.reportCCache()
+	mm := disk.GetMountManager()
+	failed := c.restoreDNS()
+	for i := len(c.mounted) - 1; i >= 0; i-- {
+		if err := mm.Unmount(c.mounted[i]); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	c.mounted = nil
+	return failed
+}
+
+// Command returns a command running the shell snippet within the chroot
+func (c *Chroot) Command(script string) *exec.Cmd {
+	cmd := exec.Command("chroot", c.Root, "/bin/sh", "-c", script)
+	cmd.Env = append([]string{
+		"PATH=" + c.path,
+		"HOME=/root",
+		"LANG=C",
+	}, c.Env...)
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	process.Trace(cmd)
+	return cmd
+}
+
+// Run will run the shell snippet within the chroot, which must be entered
+func (c *Chroot) Run(script string) error {
+	err := c.Command(script).Run()
+	if ferr := c.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// A flusher holds back output until it is flushed, i.e. a secrets.Writer
+type flusher interface {
+	Flush() error
+}
+
+// Flush will write any output held back by the writers of the Chroot, once
+// a command has finished
+func (c *Chroot) Flush() error {
+	for _, w := range []io.Writer{c.Stdout, c.Stderr} {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
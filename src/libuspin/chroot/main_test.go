@@ -0,0 +1,115 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package chroot
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinds(t *testing.T) {
+	mounts := []config.SectionMount{
+		{Source: "artifacts", Target: "/var/artifacts", ReadOnly: true},
+		{Source: "/var/cache/ccache", Target: "/root/.ccache"},
+	}
+	for i := range mounts {
+		if err := config.ValidateSectionMount(&mounts[i]); err != nil {
+			t.Fatalf("Valid mount rejected: %v", err)
+		}
+	}
+	binds := BindsFromConfig(mounts, "/home/spins")
+	if binds[0].Source != "/home/spins/artifacts" || !binds[0].ReadOnly {
+		t.Fatalf("Incorrect bind: %v", binds[0])
+	}
+	c := New("/workspace/rootfs", binds, nil)
+	if target := c.target(binds[1].Target); target != "/workspace/rootfs/root/.ccache" {
+		t.Fatalf("Incorrect target: %v", target)
+	}
+	bad := &config.SectionMount{Source: "/tmp", Target: "/var/../../etc"}
+	if err := config.ValidateSectionMount(bad); err == nil {
+		t.Fatalf("Mount targets must not escape the chroot")
+	}
+}
+
+func TestCCache(t *testing.T) {
+	c := New("/workspace/rootfs", nil, nil)
+	c.UseCCache("/var/cache/uspin/ccache", "5G", config.DefaultCCacheWrappers)
+	if len(c.Binds) != 1 || c.Binds[0].Target != CCacheTarget {
+		t.Fatalf("ccache directory not mounted: %v", c.Binds)
+	}
+	env := c.Command("true").Env
+	if env[0] != "PATH="+config.DefaultCCacheWrappers+":"+DefaultPath {
+		t.Fatalf("ccache wrappers not in PATH: %v", env[0])
+	}
+
+	base := parseCCacheStats([]byte("stats_updated_timestamp\t1480557600\ndirect_cache_hit\t10\ncache_miss\t5\n"))
+	now := parseCCacheStats([]byte("direct_cache_hit\t40\npreprocessed_cache_hit\t5\ncache_miss\t10\n"))
+	delta := now.Sub(base)
+	if delta.Hits() != 35 || delta.Misses() != 5 {
+		t.Fatalf("Incorrect statistics: %v", delta)
+	}
+}
+
+func TestDNS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-chroot")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostResolvConf = filepath.Join(dir, "resolv.conf")
+	resolvedUpstream = filepath.Join(dir, "upstream.conf")
+	ioutil.WriteFile(hostResolvConf, []byte("nameserver 127.0.0.53\noptions edns0\n"), 00644)
+	ioutil.WriteFile(resolvedUpstream, []byte("nameserver 192.168.1.1\n"), 00644)
+
+	root := filepath.Join(dir, "root")
+	os.MkdirAll(filepath.Join(root, "etc"), 00755)
+	nsswitch := "passwd: files systemd\nhosts: files resolve [!UNAVAIL=return] dns\n"
+	ioutil.WriteFile(filepath.Join(root, "etc", "nsswitch.conf"), []byte(nsswitch), 00644)
+	os.Symlink("../run/systemd/resolve/stub-resolv.conf", filepath.Join(root, "etc", "resolv.conf"))
+
+	c := New(root, nil, nil)
+	if err := c.setupDNS(); err != nil {
+		t.Fatalf("Failed to set up DNS: %v", err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(root, "etc", "resolv.conf"))
+	if !strings.Contains(string(data), "nameserver 192.168.1.1") {
+		t.Fatalf("systemd-resolved stub not bypassed: %q", data)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(root, "etc", "nsswitch.conf"))
+	if !strings.Contains(string(data), "\nhosts: files dns\n") || !strings.Contains(string(data), "passwd: files systemd") {
+		t.Fatalf("Incorrect nsswitch.conf: %q", data)
+	}
+
+	if err := c.restoreDNS(); err != nil {
+		t.Fatalf("Failed to restore DNS: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "etc", "resolv.conf")); err != nil || target != "../run/systemd/resolve/stub-resolv.conf" {
+		t.Fatalf("resolv.conf symlink not restored: %v %v", target, err)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(root, "etc", "nsswitch.conf"))
+	if string(data) != nsswitch {
+		t.Fatalf("nsswitch.conf not restored: %q", data)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc", "nsswitch.conf"+BackupSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("Backup left behind: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+// SectionAPK is the configuration of the apk package manager backend
+type SectionAPK struct {
+	Arch           string   `toml:"arch"`            // Architecture to install, defaults to image.arch
+	KeysDir        string   `toml:"keys_dir"`        // Absolute path of the trusted keys, defaults to /etc/apk/keys
+	Keys           []string `toml:"keys"`            // Additional trusted keys, relative to the .spin file
+	AllowUntrusted bool     `toml:"allow_untrusted"` // Install packages without verifying signatures
+}
@@ -0,0 +1,27 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+// SectionAPT is the configuration of the apt package manager backend
+type SectionAPT struct {
+	Suite      string   `toml:"suite"`      // Release to bootstrap, i.e. "bookworm"
+	Components []string `toml:"components"` // Archive components, defaults to main
+	Arch       string   `toml:"arch"`       // Debian architecture, defaults to image.arch
+	Variant    string   `toml:"variant"`    // debootstrap variant, defaults to minbase
+	Keyring    string   `toml:"keyring"`    // Optional keyring verifying the archive
+	Recommends bool     `toml:"recommends"` // Install recommended packages
+}
@@ -0,0 +1,84 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SectionBoot describes the [boot] portion of a spin file, selecting the
+// UEFI bootloader installed alongside the legacy loaders
+type SectionBoot struct {
+	Loader  LoaderType `toml:"loader"`   // UEFI loader, grub or systemd-boot. Legacy boot only if empty
+	ESPSize int        `toml:"esp_size"` // Size of the EFI system partition image in megabytes, sized to fit if 0
+
+	// Secure Boot signing of the loader and kernel, relative to the .spin file
+	SecureBootKey  string `toml:"secure_boot_key"`  // PEM private key, signing is disabled if empty
+	SecureBootCert string `toml:"secure_boot_cert"` // PEM certificate enrolled in the firmware or MOK list
+	Shim           string `toml:"shim"`             // Optional signed shim which loads grub, i.e. /usr/share/shim/shimx64.efi
+}
+
+// SecureBoot returns true if the EFI binaries are to be signed
+func (b *SectionBoot) SecureBoot() bool {
+	return b.SecureBootKey != ""
+}
+
+// ValidateSectionBoot will determine if the boot configuration is valid for
+// the given image type
+func ValidateSectionBoot(b *SectionBoot, imageType ImageType) error {
+	if b.ESPSize < 0 {
+		return fmt.Errorf("boot.esp_size cannot be negative: %v", b.ESPSize)
+	}
+	b.Loader = LoaderType(strings.TrimSpace(string(b.Loader)))
+	switch b.Loader {
+	case "":
+		if b.SecureBootKey != "" || b.Shim != "" {
+			return errors.New("Secure Boot signing requires boot.loader")
+		}
+		return nil
+	case LoaderTypeGrub, LoaderTypeSystemdBoot:
+	default:
+		return invalidValue("boot.loader", b.Loader, string(LoaderTypeGrub), string(LoaderTypeSystemdBoot))
+	}
+	if imageType != ImageTypeLiveOS {
+		return fmt.Errorf("boot.loader is only supported for liveos images, not %v", imageType)
+	}
+	return validateSecureBoot(b)
+}
+
+// validateSecureBoot will ensure the signing keys are given together, and
+// that shim is only used with a loader it can chain to
+func validateSecureBoot(b *SectionBoot) error {
+	b.SecureBootKey = strings.TrimSpace(b.SecureBootKey)
+	b.SecureBootCert = strings.TrimSpace(b.SecureBootCert)
+	b.Shim = strings.TrimSpace(b.Shim)
+	if (b.SecureBootKey == "") != (b.SecureBootCert == "") {
+		return errors.New("boot.secure_boot_key and boot.secure_boot_cert must be set together")
+	}
+	if b.Shim == "" {
+		return nil
+	}
+	if !b.SecureBoot() {
+		return errors.New("boot.shim requires boot.secure_boot_key to sign the loader it starts")
+	}
+	if b.Loader != LoaderTypeGrub {
+		return fmt.Errorf("boot.shim is only supported with the %v loader", LoaderTypeGrub)
+	}
+	return nil
+}
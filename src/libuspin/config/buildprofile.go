@@ -0,0 +1,140 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"libuspin/uuid"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A BuildProfile bundles the settings for one trade-off between build time,
+// image size and reproducibility, so that each may be chosen at once
+type BuildProfile string
+
+const (
+	// BuildProfileFast builds as quickly as possible, at the cost of size
+	BuildProfileFast BuildProfile = "fast"
+
+	// BuildProfileSmall produces the smallest images, at the cost of time
+	BuildProfileSmall BuildProfile = "small"
+
+	// BuildProfileReproducible produces identical images from identical inputs
+	BuildProfileReproducible BuildProfile = "reproducible"
+)
+
+// SelectedBuildProfile is chosen on the command line, taking precedence over
+// image.build_profile
+var SelectedBuildProfile BuildProfile
+
+// SourceDateEpochEnv is the conventional variable holding the timestamp of
+// reproducible builds, in seconds since the epoch
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// NondeterministicPatterns are left out of reproducible images, as they
+// differ between builds from identical inputs, see tree.Exclude
+var NondeterministicPatterns = []string{
+	"/etc/ssh/ssh_host_*",
+	"/var/cache/ldconfig/aux-cache",
+	"/var/lib/dbus/machine-id",
+	"/var/lib/systemd/random-seed",
+	"/var/lib/urandom/random-seed",
+	"/var/log/*.log",
+}
+
+// SmallExcludeClasses are left out of images built with the small profile
+var SmallExcludeClasses = []FileClass{FileClassDocs, FileClassLocales}
+
+// applyBuildProfile will change the defaults to those of the build profile.
+// Anything set by the profile is left alone, and unknown build profiles are
+// reported by the validators.
+func applyBuildProfile(iconf *ImageConfiguration, md toml.MetaData) error {
+	if SelectedBuildProfile != "" {
+		iconf.Image.BuildProfile = SelectedBuildProfile
+	}
+	iconf.Image.BuildProfile = BuildProfile(strings.TrimSpace(string(iconf.Image.BuildProfile)))
+	switch iconf.Image.BuildProfile {
+	case BuildProfileFast:
+		if !md.IsDefined("image", "compression") {
+			iconf.Image.Compression = CompressionLZ4
+		}
+		if !md.IsDefined("image", "refresh_jobs") {
+			iconf.Image.RefreshJobs = 2 * DefaultRefreshJobs
+		}
+	case BuildProfileSmall:
+		if !md.IsDefined("image", "compression") {
+			iconf.Image.Compression = CompressionXZ
+		}
+		if !md.IsDefined("image", "compression_block_size") {
+			iconf.Image.CompressionBlockSize = 1024
+		}
+		if !md.IsDefined("image", "exclude_classes") {
+			iconf.Image.ExcludeClasses = append([]FileClass{}, SmallExcludeClasses...)
+		}
+		if !md.IsDefined("dnf", "no_docs") {
+			iconf.DNF.NoDocs = true
+		}
+	case BuildProfileReproducible:
+		// Identifiers follow the image rather than being random
+		if !md.IsDefined("ids", "seed") {
+			iconf.IDs.Seed = iconf.Image.FileName
+		}
+		if !md.IsDefined("ids", "machine_id") {
+			iconf.IDs.MachineID = uuid.MachineIDEmpty
+		}
+		if epoch, ok := os.LookupEnv(SourceDateEpochEnv); ok && !md.IsDefined("image", "source_date_epoch") {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(epoch), 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid %v: %v", SourceDateEpochEnv, epoch)
+			}
+			iconf.Image.SourceDateEpoch = seconds
+		}
+	}
+	return nil
+}
+
+// Reproducible returns true if the image is built with the reproducible
+// build profile
+func (i *SectionImage) Reproducible() bool {
+	return i.BuildProfile == BuildProfileReproducible
+}
+
+// Epoch returns the time every timestamp of a reproducible image is clamped
+// to, or the zero time for other builds
+func (i *SectionImage) Epoch() time.Time {
+	if !i.Reproducible() {
+		return time.Time{}
+	}
+	return time.Unix(i.SourceDateEpoch, 0).UTC()
+}
+
+// validateBuildProfile will ensure the build profile is known
+func validateBuildProfile(i *SectionImage) error {
+	if i.SourceDateEpoch < 0 {
+		return fmt.Errorf("image.source_date_epoch cannot be negative: %v", i.SourceDateEpoch)
+	}
+	switch i.BuildProfile {
+	case "", BuildProfileFast, BuildProfileSmall, BuildProfileReproducible:
+		return nil
+	default:
+		return invalidValue("image.build_profile", i.BuildProfile, string(BuildProfileFast), string(BuildProfileSmall), string(BuildProfileReproducible))
+	}
+}
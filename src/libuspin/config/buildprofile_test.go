@@ -0,0 +1,92 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-buildprofile")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	orig, err := ioutil.ReadFile(confTestPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	path := filepath.Join(dir, "small.spin")
+	data := strings.Replace(string(orig), "[image]\n", "[image]\nbuild_profile = \"small\"\n", 1)
+	if err := ioutil.WriteFile(path, []byte(data), 00644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if c.Image.CompressionBlockSize != 1024 || !reflect.DeepEqual(c.Image.ExcludeClasses, SmallExcludeClasses) {
+		t.Fatalf("Small defaults not applied: %v %v", c.Image.CompressionBlockSize, c.Image.ExcludeClasses)
+	}
+	if c.Image.Compression != CompressionGzip {
+		t.Fatalf("Profile settings should be kept: %v", c.Image.Compression)
+	}
+
+	// The command line wins over the profile
+	SelectedBuildProfile = BuildProfileReproducible
+	defer func() { SelectedBuildProfile = "" }()
+	if c, err = New(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if c.Image.BuildProfile != BuildProfileReproducible || c.IDs.Seed != c.Image.FileName {
+		t.Fatalf("Reproducible defaults not applied: %v %v", c.Image.BuildProfile, c.IDs.Seed)
+	}
+	if !c.Image.Epoch().Equal(time.Unix(0, 0)) {
+		t.Fatalf("Expected the epoch without %v, got %v", SourceDateEpochEnv, c.Image.Epoch())
+	}
+
+	// Timestamps and DATE follow SOURCE_DATE_EPOCH
+	os.Setenv(SourceDateEpochEnv, "1500000000")
+	defer os.Unsetenv(SourceDateEpochEnv)
+	if c, err = New(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !c.Image.Epoch().Equal(time.Unix(1500000000, 0)) || c.builtins["DATE"] != "20170714" {
+		t.Fatalf("Expected %v to be used, got %v %v", SourceDateEpochEnv, c.Image.Epoch(), c.builtins["DATE"])
+	}
+	patterns := c.Image.ExcludePatterns()
+	if len(patterns) < len(NondeterministicPatterns) || patterns[len(patterns)-1] != NondeterministicPatterns[len(NondeterministicPatterns)-1] {
+		t.Fatalf("Nondeterministic files should be excluded: %v", patterns)
+	}
+	os.Setenv(SourceDateEpochEnv, "yesterday")
+	if _, err := New(path); err == nil || !strings.Contains(err.Error(), SourceDateEpochEnv) {
+		t.Fatalf("Invalid %v should fail: %v", SourceDateEpochEnv, err)
+	}
+	os.Unsetenv(SourceDateEpochEnv)
+
+	SelectedBuildProfile = "quick"
+	if _, err := New(path); err == nil || !strings.Contains(err.Error(), "image.build_profile") {
+		t.Fatalf("Unknown build profiles should fail: %v", err)
+	}
+}
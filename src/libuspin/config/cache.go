@@ -0,0 +1,43 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"libuspin/pkgcache"
+	"strings"
+)
+
+// SectionPackageCache describes the [package_cache] portion of a spin file,
+// keeping downloaded packages between builds so that rebuilds only fetch
+// what has changed.
+type SectionPackageCache struct {
+	Directory string `toml:"directory"` // Host cache directory, shared between profiles, disabled if empty
+	Workers   int    `toml:"workers"`   // Concurrent downloads, defaults to pkgcache.DefaultWorkers
+}
+
+// ValidateSectionPackageCache will normalise the package cache configuration
+func ValidateSectionPackageCache(p *SectionPackageCache) error {
+	p.Directory = strings.TrimSpace(p.Directory)
+	if p.Workers < 0 {
+		return fmt.Errorf("package_cache.workers cannot be negative: %v", p.Workers)
+	}
+	if p.Workers == 0 {
+		p.Workers = pkgcache.DefaultWorkers
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"strings"
+)
+
+// DefaultCCacheWrappers is where Solus installs the ccache compiler wrappers
+const DefaultCCacheWrappers = "/usr/lib64/ccache/bin"
+
+// SectionCCache describes the [ccache] portion of a spin file, providing a
+// persistent compiler cache to anything compiled within the chroot, i.e.
+// kernel modules.
+type SectionCCache struct {
+	Directory string `toml:"directory"` // Host cache directory, ccache is disabled if empty
+	MaxSize   string `toml:"max_size"`  // Optional cache size limit, i.e. "5G"
+	Wrappers  string `toml:"wrappers"`  // Compiler wrapper directory within the chroot
+}
+
+// ValidateSectionCCache will normalise the ccache configuration
+func ValidateSectionCCache(c *SectionCCache) error {
+	c.Directory = strings.TrimSpace(c.Directory)
+	c.MaxSize = strings.TrimSpace(c.MaxSize)
+	if c.Wrappers = strings.TrimSpace(c.Wrappers); c.Wrappers == "" {
+		c.Wrappers = DefaultCCacheWrappers
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"libuspin/spec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Problem is a single issue found within a .spin file by Check
+type Problem struct {
+	Key     string // Dotted key or table concerned, if known
+	Line    int    // Line within the file, 0 if unknown
+	Message string
+	Warning bool // The file is still usable, i.e. deprecated features
+}
+
+// syntaxLine finds the line number within a TOML syntax error
+var syntaxLine = regexp.MustCompile(`line (\d+)`)
+
+// Check will report every problem with the configuration rather than stopping
+// at the first, along with the configuration if it could be decoded. Unlike
+// New, validation continues past each problem, so later problems may be a
+// consequence of earlier ones.
+func Check(cpath string) (*ImageConfiguration, []*Problem) {
+	iconf, data, unknown, err := load(cpath)
+	lines := keyLines(data)
+	var ret []*Problem
+	add := func(key, msg string, warning bool) {
+		ret = append(ret, &Problem{
+			Key:     key,
+			Line:    lines.find(key),
+			Message: msg,
+			Warning: warning,
+		})
+	}
+	for _, p := range unknown {
+		add(p.Key, p.Message, false)
+	}
+	if err != nil {
+		p := &Problem{Message: err.Error()}
+		if m := syntaxLine.FindStringSubmatch(err.Error()); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+		}
+		return nil, append(ret, p)
+	}
+	for _, n := range iconf.Deprecations {
+		add(n.Name, n.String(), true)
+	}
+	for _, v := range validators {
+		if err := v.check(iconf); err != nil {
+			add(v.key, err.Error(), false)
+		}
+	}
+	return iconf, ret
+}
+
+// KeyLine returns the line of the .spin file declaring the key, or the
+// closest table containing it, and 0 if it cannot be found
+func KeyLine(cpath, key string) int {
+	data, err := ioutil.ReadFile(cpath)
+	if err != nil {
+		return 0
+	}
+	data, _ = spec.Normalize(data)
+	return keyLines(data).find(key)
+}
+
+// lineIndex maps dotted keys and table names to the line declaring them
+type lineIndex map[string]int
+
+// find returns the line of the key, or of the closest table containing it
+func (l lineIndex) find(key string) int {
+	for key != "" {
+		if line, ok := l[key]; ok {
+			return line
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+// keyLines will index the tables and keys of a TOML document by line. Only
+// the first declaration of each is recorded.
+func keyLines(data []byte) lineIndex {
+	ret := make(lineIndex)
+	record := func(key string, line int) {
+		if _, ok := ret[key]; !ok {
+			ret[key] = line
+		}
+	}
+	table := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "["):
+			table = strings.TrimSpace(strings.Trim(line, "[]"))
+			record(table, lineno)
+		case strings.Contains(line, "="):
+			key := strings.Trim(strings.TrimSpace(line[:strings.Index(line, "=")]), `"`)
+			if table != "" {
+				key = table + "." + key
+			}
+			record(key, lineno)
+		}
+	}
+	return ret
+}
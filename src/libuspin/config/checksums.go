@@ -0,0 +1,70 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// ChecksumAlgorithm names a digest computed over each delivered artifact
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumSHA256 writes a .sha256sum file for each artifact
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+
+	// ChecksumSHA512 writes a .sha512sum file for each artifact
+	ChecksumSHA512 ChecksumAlgorithm = "sha512"
+)
+
+// Suffix returns the extension of the checksum files of the algorithm,
+// i.e. ".sha256sum"
+func (c ChecksumAlgorithm) Suffix() string {
+	return "." + string(c) + "sum"
+}
+
+// SectionChecksums describes the [checksums] portion of a spin file, run
+// as its own stage once the artifacts have been delivered.
+type SectionChecksums struct {
+	Algorithms []ChecksumAlgorithm `toml:"algorithms"` // Digests to compute, the stage is skipped if empty
+	SignKey    string              `toml:"sign_key"`   // Optional GPG key to detach-sign each checksum file
+}
+
+// ValidateSectionChecksums will ensure only known digests are requested
+func ValidateSectionChecksums(c *SectionChecksums) error {
+	c.SignKey = strings.TrimSpace(c.SignKey)
+	seen := make(map[ChecksumAlgorithm]bool)
+	var algorithms []ChecksumAlgorithm
+	for _, a := range c.Algorithms {
+		a = ChecksumAlgorithm(strings.ToLower(strings.TrimSpace(string(a))))
+		switch a {
+		case ChecksumSHA256, ChecksumSHA512:
+		default:
+			return invalidValue("checksums.algorithms", a, string(ChecksumSHA256), string(ChecksumSHA512))
+		}
+		if !seen[a] {
+			seen[a] = true
+			algorithms = append(algorithms, a)
+		}
+	}
+	c.Algorithms = algorithms
+	if c.SignKey != "" && len(c.Algorithms) == 0 {
+		return errors.New("checksums.sign_key requires checksums.algorithms")
+	}
+	return nil
+}
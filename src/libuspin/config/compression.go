@@ -0,0 +1,73 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Compression is the algorithm used to compress the root filesystem
+type Compression string
+
+const (
+	// CompressionGzip is widely supported, and the default
+	CompressionGzip Compression = "gzip"
+
+	// CompressionXZ gives the smallest images, but is the slowest
+	CompressionXZ Compression = "xz"
+
+	// CompressionZstd compresses almost as well as xz, and decompresses much faster
+	CompressionZstd Compression = "zstd"
+
+	// CompressionLZ4 is the fastest, for images where size matters little
+	CompressionLZ4 Compression = "lz4"
+)
+
+// compressionLevels are the levels supported by each algorithm, those absent
+// have no levels
+var compressionLevels = map[Compression][2]int{
+	CompressionGzip: {1, 9},
+	CompressionZstd: {1, 22},
+}
+
+// validateCompression will ensure the compression settings of the image are
+// supported, defaulting to gzip
+func validateCompression(i *SectionImage) error {
+	i.Compression = Compression(strings.TrimSpace(string(i.Compression)))
+	switch i.Compression {
+	case "":
+		i.Compression = CompressionGzip
+	case CompressionGzip, CompressionXZ, CompressionZstd, CompressionLZ4:
+	default:
+		return invalidValue("image.compression", i.Compression, string(CompressionGzip), string(CompressionXZ), string(CompressionZstd), string(CompressionLZ4))
+	}
+	if i.CompressionLevel != 0 {
+		levels, ok := compressionLevels[i.Compression]
+		if !ok {
+			return fmt.Errorf("image.compression_level is not supported by %v", i.Compression)
+		}
+		if i.CompressionLevel < levels[0] || i.CompressionLevel > levels[1] {
+			return fmt.Errorf("image.compression_level must be between %v and %v for %v", levels[0], levels[1], i.Compression)
+		}
+	}
+	// squashfs blocks are a power of two, from 4K to 1M
+	if size := i.CompressionBlockSize; size != 0 && (size < 4 || size > 1024 || size&(size-1) != 0) {
+		return fmt.Errorf("image.compression_block_size must be a power of two from 4 to 1024: %v", size)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A PartitionTable is the partitioning scheme of a disk image
+type PartitionTable string
+
+const (
+	// PartitionTableGPT is a GUID partition table, the default
+	PartitionTableGPT PartitionTable = "gpt"
+
+	// PartitionTableMBR is a legacy DOS partition table
+	PartitionTableMBR PartitionTable = "mbr"
+)
+
+// A DiskFormat is the file format of a disk image
+type DiskFormat string
+
+const (
+	// DiskFormatRaw is a plain image which may be written directly to a disk
+	DiskFormatRaw DiskFormat = "raw"
+
+	// DiskFormatQcow2 is converted from the raw image with qemu-img
+	DiskFormatQcow2 DiskFormat = "qcow2"
+)
+
+// SectionDisk is the disk image specific configuration
+type SectionDisk struct {
+	Size           int            `toml:"size"`            // Size of the disk in megabytes (default 4000)
+	PartitionTable PartitionTable `toml:"partition_table"` // Partitioning scheme, gpt or mbr
+	RootfsFormat   string         `toml:"rootfs_format"`   // Format of the root partition, defaults to ext4
+	Format         DiskFormat     `toml:"format"`          // File format of the image, raw or qcow2
+	Bootloaders    []LoaderType   `toml:"bootloaders"`     // Which bootloaders to install
+	Cmdline        string         `toml:"cmdline"`         // Extra kernel command line options
+}
+
+// ValidateSectionDisk will determine if the configuration is valid for a disk image
+func ValidateSectionDisk(d *SectionDisk) error {
+	if d.Size < 64 {
+		return fmt.Errorf("disk.size is too small: %vMB", d.Size)
+	}
+	switch d.PartitionTable {
+	case "":
+		d.PartitionTable = PartitionTableGPT
+	case PartitionTableGPT, PartitionTableMBR:
+	default:
+		return invalidValue("disk.partition_table", d.PartitionTable, string(PartitionTableGPT), string(PartitionTableMBR))
+	}
+	switch d.Format {
+	case "":
+		d.Format = DiskFormatRaw
+	case DiskFormatRaw, DiskFormatQcow2:
+	default:
+		return invalidValue("disk.format", d.Format, string(DiskFormatRaw), string(DiskFormatQcow2))
+	}
+	if d.RootfsFormat = strings.TrimSpace(d.RootfsFormat); d.RootfsFormat == "" {
+		d.RootfsFormat = "ext4"
+	}
+	for _, loader := range d.Bootloaders {
+		if loader != LoaderTypeSyslinux {
+			return invalidValue("disk.bootloaders", loader, string(LoaderTypeSyslinux))
+		}
+	}
+	d.Cmdline = strings.TrimSpace(d.Cmdline)
+	return nil
+}
@@ -0,0 +1,41 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SectionDKMS describes the [dkms] portion of a spin file, listing the out
+// of tree modules to be built against every kernel within the image.
+type SectionDKMS struct {
+	Modules []string `toml:"modules"` // Modules in "name/version" form, i.e. "broadcom-wl/6.30.223.271"
+}
+
+// ValidateSectionDKMS will ensure every module is in the name/version form
+func ValidateSectionDKMS(d *SectionDKMS) error {
+	for i, module := range d.Modules {
+		module = strings.TrimSpace(module)
+		splits := strings.Split(module, "/")
+		if len(splits) != 2 || splits[0] == "" || splits[1] == "" {
+			return fmt.Errorf("Invalid DKMS module '%v', expected name/version", module)
+		}
+		d.Modules[i] = module
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+// SectionDNF is the configuration of the dnf package manager backend
+type SectionDNF struct {
+	Releasever string `toml:"releasever"` // Release of the distribution, i.e. "40"
+	WeakDeps   bool   `toml:"weak_deps"`  // Install weak dependencies, i.e. Recommends
+	NoDocs     bool   `toml:"no_docs"`    // Leave out documentation to save space
+	GPGKeys    string `toml:"gpg_keys"`   // Optional key URL checked for every repository
+}
@@ -0,0 +1,332 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	tableHeader = regexp.MustCompile(`^\s*\[\s*([A-Za-z0-9_.\-]+)\s*\]`)
+	arrayHeader = regexp.MustCompile(`^\s*\[\[`)
+	keyLine     = regexp.MustCompile(`^\s*([A-Za-z0-9_\-]+)\s*=`)
+)
+
+// A Document is the text of a .spin file, allowing individual keys to be
+// changed by tooling without losing the comments and ordering of the file.
+// Keys within [[array]] tables cannot be addressed.
+type Document struct {
+	lines []string
+}
+
+// ParseDocument will return a Document for the given .spin file contents
+func ParseDocument(data []byte) (*Document, error) {
+	var raw map[string]interface{}
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, err
+	}
+	return &Document{lines: strings.Split(string(data), "\n")}, nil
+}
+
+// LoadDocument will read the .spin file at path into a Document
+func LoadDocument(path string) (*Document, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDocument(data)
+}
+
+// Bytes returns the current contents of the document
+func (d *Document) Bytes() []byte {
+	return []byte(strings.Join(d.lines, "\n"))
+}
+
+// Save will atomically replace the file at path with the document
+func (d *Document) Save(path string) error {
+	mode := os.FileMode(00644)
+	if st, err := os.Stat(path); err == nil {
+		mode = st.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, d.Bytes(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// splitKey splits a dotted key into its table and name
+func splitKey(key string) (string, string) {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// find returns the line range of the key's value, and the index after the
+// last key of its table. start is -1 if the key is not set, and end is -1
+// if the table does not exist.
+func (d *Document) find(key string) (start, stop, end int) {
+	table, name := splitKey(key)
+	start, end = -1, -1
+	if table == "" {
+		end = 0
+	}
+	current := ""
+	for i := 0; i < len(d.lines); i++ {
+		line := d.lines[i]
+		if arrayHeader.MatchString(line) {
+			current = "\x00"
+			continue
+		}
+		if m := tableHeader.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			if current == table {
+				end = i + 1
+			}
+			continue
+		}
+		m := keyLine.FindStringSubmatch(line)
+		if m == nil || current != table {
+			continue
+		}
+		last := valueEnd(d.lines, i)
+		if m[1] == name {
+			start, stop = i, last
+		}
+		end = last + 1
+		i = last
+	}
+	return start, stop, end
+}
+
+// valueEnd returns the last line of the value starting on line i, which
+// only differs from i for arrays split over multiple lines.
+func valueEnd(lines []string, i int) int {
+	depth := 0
+	for j := i; j < len(lines); j++ {
+		code, _ := splitComment(lines[j])
+		if j == i {
+			code = code[strings.Index(code, "=")+1:]
+		}
+		inString := byte(0)
+		for k := 0; k < len(code); k++ {
+			c := code[k]
+			switch {
+			case inString != 0:
+				if c == '\\' && inString == '"' {
+					k++
+				} else if c == inString {
+					inString = 0
+				}
+			case c == '"' || c == '\'':
+				inString = c
+			case c == '[':
+				depth++
+			case c == ']':
+				depth--
+			}
+		}
+		if depth <= 0 {
+			return j
+		}
+	}
+	return len(lines) - 1
+}
+
+// splitComment separates any trailing comment from the line
+func splitComment(line string) (string, string) {
+	inString := byte(0)
+	for k := 0; k < len(line); k++ {
+		c := line[k]
+		switch {
+		case inString != 0:
+			if c == '\\' && inString == '"' {
+				k++
+			} else if c == inString {
+				inString = 0
+			}
+		case c == '"' || c == '\'':
+			inString = c
+		case c == '#':
+			return line[:k], line[k:]
+		}
+	}
+	return line, ""
+}
+
+// Get returns the current value of the key, decoded from the document
+func (d *Document) Get(key string) (interface{}, bool) {
+	var raw map[string]interface{}
+	if _, err := toml.Decode(string(d.Bytes()), &raw); err != nil {
+		return nil, false
+	}
+	var v interface{} = raw
+	for _, part := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Set will change the value of the key in place, keeping any trailing
+// comment. Missing keys are added to the end of their table, and missing
+// tables to the end of the document.
+func (d *Document) Set(key string, value interface{}) error {
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	table, name := splitKey(key)
+	if !keyLine.MatchString(name + " =") {
+		return fmt.Errorf("Invalid key: %v", key)
+	}
+	line := name + " = " + encoded
+	start, stop, end := d.find(key)
+	var lines []string
+	switch {
+	case start >= 0:
+		indent := d.lines[start][:len(d.lines[start])-len(strings.TrimLeft(d.lines[start], " \t"))]
+		if _, comment := splitComment(d.lines[stop]); comment != "" {
+			line += " " + comment
+		}
+		lines = d.splice(start, stop+1, indent+line)
+	case end >= 0:
+		lines = d.splice(end, end, line)
+	default:
+		lines = append([]string{}, d.lines...)
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, "", "["+table+"]", line, "")
+	}
+	return d.replace(lines)
+}
+
+// Delete will remove the key from the document, returning false if it was
+// not set.
+func (d *Document) Delete(key string) (bool, error) {
+	start, stop, _ := d.find(key)
+	if start < 0 {
+		return false, nil
+	}
+	return true, d.replace(d.splice(start, stop+1))
+}
+
+// splice returns the lines with [from, to) replaced by repl
+func (d *Document) splice(from, to int, repl ...string) []string {
+	ret := append([]string{}, d.lines[:from]...)
+	ret = append(ret, repl...)
+	return append(ret, d.lines[to:]...)
+}
+
+// replace will only accept the new lines if they're still valid TOML
+func (d *Document) replace(lines []string) error {
+	var raw map[string]interface{}
+	if _, err := toml.Decode(strings.Join(lines, "\n"), &raw); err != nil {
+		return fmt.Errorf("Edit would produce an invalid .spin file: %v", err)
+	}
+	d.lines = lines
+	return nil
+}
+
+// quoteString returns s as a TOML basic string
+func quoteString(s string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// encodeValue returns the TOML representation of a value
+func encodeValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []string:
+		var parts []string
+		for _, s := range v {
+			parts = append(parts, quoteString(s))
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			s, err := encodeValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	return "", fmt.Errorf("Cannot store %T in a .spin file", value)
+}
+
+// ParseValue will interpret s as a TOML value, falling back to a plain
+// string, i.e. for values given on the command line.
+func ParseValue(s string) interface{} {
+	var raw map[string]interface{}
+	if _, err := toml.Decode("v = "+s, &raw); err == nil {
+		return raw["v"]
+	}
+	return s
+}
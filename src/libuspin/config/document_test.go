@@ -0,0 +1,92 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"testing"
+)
+
+const testDocument = `# Leading comment
+format = 2
+
+[image]
+packages = "minimal.packages" # Relative to the .spin
+filename = "Solus-1.2.1.iso"
+
+# Bootloaders go here
+[liveos]
+bootloaders = [
+    "syslinux",
+]
+label = "SolusLive"
+`
+
+func TestDocument(t *testing.T) {
+	d, err := ParseDocument([]byte(testDocument))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	if v, ok := d.Get("image.filename"); !ok || v != "Solus-1.2.1.iso" {
+		t.Fatalf("Wrong filename: %v", v)
+	}
+	if err := d.Set("image.packages", "other.packages"); err != nil {
+		t.Fatalf("Failed to set packages: %v", err)
+	}
+	if err := d.Set("liveos.bootloaders", []string{"syslinux", "grub"}); err != nil {
+		t.Fatalf("Failed to set bootloaders: %v", err)
+	}
+	if err := d.Set("liveos.compression", "xz"); err != nil {
+		t.Fatalf("Failed to add compression: %v", err)
+	}
+	if err := d.Set("ids.seed", "edition"); err != nil {
+		t.Fatalf("Failed to add seed: %v", err)
+	}
+	if ok, err := d.Delete("format"); !ok || err != nil {
+		t.Fatalf("Failed to delete format: %v", err)
+	}
+	if err := d.Set("image.packages", []interface{}{map[string]interface{}{}}); err == nil {
+		t.Fatalf("Stored an unsupported value")
+	}
+
+	want := `# Leading comment
+
+[image]
+packages = "other.packages" # Relative to the .spin
+filename = "Solus-1.2.1.iso"
+
+# Bootloaders go here
+[liveos]
+bootloaders = ["syslinux", "grub"]
+label = "SolusLive"
+compression = "xz"
+
+[ids]
+seed = "edition"
+`
+	if got := string(d.Bytes()); got != want {
+		t.Fatalf("Incorrect document:\n%v", got)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	if v := ParseValue("4096"); v != int64(4096) {
+		t.Fatalf("Wrong number: %#v", v)
+	}
+	if v := ParseValue("Solus-2.iso"); v != "Solus-2.iso" {
+		t.Fatalf("Wrong string: %#v", v)
+	}
+}
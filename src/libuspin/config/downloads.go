@@ -0,0 +1,38 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// SectionDownloads describes the [downloads] portion of a spin file, pinning
+// everything fetched by the package manager during a build.
+type SectionDownloads struct {
+	Lockfile string `toml:"lockfile"` // Declared downloads, written by "uspin downloads -lock"
+	Strict   bool   `toml:"strict"`   // Fail the build on any download not in the lockfile
+}
+
+// ValidateSectionDownloads will ensure strict mode has something to check against
+func ValidateSectionDownloads(d *SectionDownloads) error {
+	d.Lockfile = strings.TrimSpace(d.Lockfile)
+	if d.Strict && d.Lockfile == "" {
+		return errors.New("downloads.strict requires downloads.lockfile")
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+const (
+	// EncryptedPrefix marks the start of an age encrypted value within a
+	// .spin file, i.e. "ENC[age:YWdlLWVuY3J5cHRpb24...]"
+	EncryptedPrefix = "ENC[age:"
+
+	// EncryptedSuffix marks the end of an encrypted value
+	EncryptedSuffix = "]"
+
+	// KeyFileEnv names the environment variable holding the path to the age
+	// identity used to decrypt values
+	KeyFileEnv = "USPIN_KEY_FILE"
+)
+
+// ErrNoKeyFile is returned when encrypted values are found without a key
+var ErrNoKeyFile = errors.New("Encrypted values require an age identity, set " + KeyFileEnv)
+
+// A decryptFunc turns ciphertext into plaintext
+type decryptFunc func(ciphertext []byte) ([]byte, error)
+
+// IsEncrypted determines if the value is an encrypted value
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix) && strings.HasSuffix(value, EncryptedSuffix)
+}
+
+// Encrypt will encrypt the plaintext for the given age recipient, returning a
+// value suitable for use within a .spin file.
+func Encrypt(plaintext []byte, recipient string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("age", "--encrypt", "--recipient", recipient)
+	cmd.Stdin = bytes.NewReader(plaintext)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(stdout.Bytes()) + EncryptedSuffix, nil
+}
+
+// ageDecrypt will decrypt the ciphertext using the identity in KeyFileEnv
+func ageDecrypt(ciphertext []byte) ([]byte, error) {
+	keyFile := os.Getenv(KeyFileEnv)
+	if keyFile == "" {
+		return nil, ErrNoKeyFile
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command("age", "--decrypt", "--identity", keyFile)
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// decryptValue will decrypt a single encrypted value
+func decryptValue(value string, decrypt decryptFunc) (string, error) {
+	encoded := strings.TrimSuffix(strings.TrimPrefix(value, EncryptedPrefix), EncryptedSuffix)
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("Invalid encrypted value: %v", err)
+	}
+	plaintext, err := decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// decryptFields will replace every encrypted string within v, which must be
+// addressable, with its plaintext, returning every plaintext.
+func decryptFields(v reflect.Value, decrypt decryptFunc) ([]string, error) {
+	var plaintexts []string
+	err := rewriteStrings(v, "", func(key, value string) (string, error) {
+		if !IsEncrypted(value) {
+			return value, nil
+		}
+		plaintext, err := decryptValue(value, decrypt)
+		if err != nil {
+			return "", err
+		}
+		plaintexts = append(plaintexts, plaintext)
+		return plaintext, nil
+	})
+	return plaintexts, err
+}
+
+// Decrypted returns the plaintext of every encrypted value within the
+// configuration, which must be kept out of any output
+func (i *ImageConfiguration) Decrypted() []string {
+	return i.decrypted
+}
+
+// rewriteStrings will replace every string within v, which must be
+// addressable, with the result of rewrite. The key of each string is given
+// as it would be written within the .spin file, i.e. "image.filename".
+func rewriteStrings(v reflect.Value, key string, rewrite func(key, value string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return rewriteStrings(v.Elem(), key, rewrite)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("toml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if key != "" {
+				name = key + "." + name
+			}
+			if err := rewriteStrings(v.Field(i), name, rewrite); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := rewriteStrings(v.Index(i), key, rewrite); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			// Map values aren't addressable, so rewrite a copy
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(k))
+			if err := rewriteStrings(elem, fmt.Sprintf("%v.%v", key, k), rewrite); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+	case reflect.String:
+		value, err := rewrite(key, v.String())
+		if err != nil {
+			return err
+		}
+		if value != v.String() {
+			v.SetString(value)
+		}
+	}
+	return nil
+}
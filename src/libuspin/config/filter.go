@@ -0,0 +1,66 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+// A FileClass is a class of files which may be left out of the image. Where
+// the package manager supports it they are never installed, otherwise they
+// are removed along with image.exclude.
+type FileClass string
+
+const (
+	// FileClassDocs is documentation, including man and info pages
+	FileClassDocs FileClass = "docs"
+
+	// FileClassLocales is message translations
+	FileClassLocales FileClass = "locales"
+)
+
+// FileClassPatterns are the exclude patterns of each class, see tree.Exclude
+var FileClassPatterns = map[FileClass][]string{
+	FileClassDocs: {
+		"/usr/share/doc/**",
+		"/usr/share/gtk-doc/**",
+		"/usr/share/info/**",
+		"/usr/share/man/**",
+	},
+	FileClassLocales: {
+		"/usr/share/locale/*/LC_MESSAGES/*.mo",
+	},
+}
+
+// validateExcludeClasses will ensure every class is known
+func validateExcludeClasses(i *SectionImage) error {
+	for _, class := range i.ExcludeClasses {
+		if _, ok := FileClassPatterns[class]; !ok {
+			return invalidValue("image.exclude_classes", class, string(FileClassDocs), string(FileClassLocales))
+		}
+	}
+	return nil
+}
+
+// ExcludePatterns returns image.exclude along with the patterns of every
+// excluded class, and those of nondeterministic files in reproducible images
+func (i *SectionImage) ExcludePatterns() []string {
+	ret := append([]string{}, i.Exclude...)
+	for _, class := range i.ExcludeClasses {
+		ret = append(ret, FileClassPatterns[class]...)
+	}
+	if i.Reproducible() {
+		ret = append(ret, NondeterministicPatterns...)
+	}
+	return ret
+}
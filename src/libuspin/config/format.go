@@ -0,0 +1,190 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"libuspin/deprecation"
+	"os"
+)
+
+// CurrentFormat is the newest .spin format understood by this uspin.
+// Bump it, and add a migration from the previous format, whenever the
+// layout of the configuration changes.
+const CurrentFormat = 3
+
+// A Migration upgrades a raw configuration by exactly one format version,
+// returning a notice for every deprecated key it rewrites.
+type Migration func(raw map[string]interface{}) ([]*deprecation.Notice, error)
+
+// migrations is keyed by the format each migration upgrades *from*
+var migrations = map[int]Migration{
+	1: migrateV1,
+	2: migrateV2,
+}
+
+// FormatOf returns the declared format of the raw configuration. Files
+// predating the format key are format 1.
+func FormatOf(raw map[string]interface{}) (int, error) {
+	v, ok := raw["format"]
+	if !ok {
+		return 1, nil
+	}
+	var format int
+	switch n := v.(type) {
+	case int64:
+		format = int(n)
+	case int:
+		format = n
+	default:
+		return 0, fmt.Errorf("Invalid format: %v", v)
+	}
+	if format < 1 {
+		return 0, fmt.Errorf("Invalid format: %v", format)
+	}
+	if format > CurrentFormat {
+		return 0, fmt.Errorf("Format %v is newer than supported format %v, please update uspin", format, CurrentFormat)
+	}
+	return format, nil
+}
+
+// Migrate upgrades the raw configuration in place to CurrentFormat, returning
+// the format it was originally written in along with notices for each change.
+func Migrate(raw map[string]interface{}) (int, []*deprecation.Notice, error) {
+	from, err := FormatOf(raw)
+	if err != nil {
+		return 0, nil, err
+	}
+	var notes []*deprecation.Notice
+	for format := from; format < CurrentFormat; format++ {
+		migrate, ok := migrations[format]
+		if !ok {
+			return 0, nil, fmt.Errorf("Internal error: no migration from format %v", format)
+		}
+		n, err := migrate(raw)
+		if err != nil {
+			return 0, nil, err
+		}
+		notes = append(notes, n...)
+	}
+	raw["format"] = int64(CurrentFormat)
+	return from, notes, nil
+}
+
+// migrateV1 moves the output filename into [image], as output naming
+// is no longer specific to LiveOS images.
+func migrateV1(raw map[string]interface{}) ([]*deprecation.Notice, error) {
+	liveos, ok := raw["liveos"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	filename, ok := liveos["filename"]
+	if !ok {
+		return nil, nil
+	}
+	image, ok := raw["image"].(map[string]interface{})
+	if !ok {
+		image = make(map[string]interface{})
+		raw["image"] = image
+	}
+	if _, ok := image["filename"]; ok {
+		return nil, fmt.Errorf("Both liveos.filename and image.filename are set")
+	}
+	image["filename"] = filename
+	delete(liveos, "filename")
+	return []*deprecation.Notice{{
+		Name:        "liveos.filename",
+		Replacement: "image.filename",
+	}}, nil
+}
+
+// migrateV2 moves the compression into [image], as it is no longer specific
+// to LiveOS images and gained options of its own.
+func migrateV2(raw map[string]interface{}) ([]*deprecation.Notice, error) {
+	liveos, ok := raw["liveos"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	compression, ok := liveos["compression"]
+	if !ok {
+		return nil, nil
+	}
+	image, ok := raw["image"].(map[string]interface{})
+	if !ok {
+		image = make(map[string]interface{})
+		raw["image"] = image
+	}
+	if _, ok := image["compression"]; ok {
+		return nil, fmt.Errorf("Both liveos.compression and image.compression are set")
+	}
+	image["compression"] = compression
+	delete(liveos, "compression")
+	return []*deprecation.Notice{{
+		Name:        "liveos.compression",
+		Replacement: "image.compression",
+	}}, nil
+}
+
+// Encode will serialise the raw configuration back into TOML
+func Encode(raw map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MigrateFile will upgrade the .spin file at path to CurrentFormat in place,
+// keeping the original as path + ".bak". Comments are not preserved.
+// Nothing is written if the file is already current, or if dryRun is set.
+func MigrateFile(path string, dryRun bool) (int, []*deprecation.Notice, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	var raw map[string]interface{}
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return 0, nil, err
+	}
+	from, notes, err := Migrate(raw)
+	if err != nil || from == CurrentFormat || dryRun {
+		return from, notes, err
+	}
+	out, err := Encode(raw)
+	if err != nil {
+		return 0, nil, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := ioutil.WriteFile(path+".bak", data, st.Mode().Perm()); err != nil {
+		return 0, nil, err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, out, st.Mode().Perm()); err != nil {
+		return 0, nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, nil, err
+	}
+	return from, notes, nil
+}
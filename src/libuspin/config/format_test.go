@@ -0,0 +1,93 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(confTestPath, &raw); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	from, notes, err := Migrate(raw)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if from != 1 || len(notes) != 2 {
+		t.Fatalf("Unexpected migration from %v: %v", from, notes)
+	}
+	if raw["image"].(map[string]interface{})["filename"] != "Solus-1.2.1.iso" {
+		t.Fatalf("filename not moved into [image]: %v", raw)
+	}
+	if _, ok := raw["liveos"].(map[string]interface{})["filename"]; ok {
+		t.Fatalf("filename left in [liveos]")
+	}
+	if raw["image"].(map[string]interface{})["compression"] != "gzip" {
+		t.Fatalf("compression not moved into [image]: %v", raw)
+	}
+
+	raw["format"] = int64(CurrentFormat + 1)
+	if _, _, err := Migrate(raw); err == nil {
+		t.Fatalf("Accepted a format newer than supported")
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-format")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	orig, err := ioutil.ReadFile(confTestPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	path := filepath.Join(dir, "minimal.spin")
+	if err := ioutil.WriteFile(path, orig, 00644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, _, err := MigrateFile(path, false); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if bak, _ := ioutil.ReadFile(path + ".bak"); string(bak) != string(orig) {
+		t.Fatalf("Original not backed up")
+	}
+
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("Couldn't open migrated config: %v", err)
+	}
+	if c.Format != CurrentFormat || c.Image.FileName != "Solus-1.2.1.iso" {
+		t.Fatalf("Invalid migrated config: %v %v", c.Format, c.Image.FileName)
+	}
+	if len(c.Deprecations) != 0 {
+		t.Fatalf("Migrated config still deprecated: %v", c.Deprecations)
+	}
+
+	// Migrating again is a no-op
+	from, _, err := MigrateFile(path, false)
+	if err != nil || from != CurrentFormat {
+		t.Fatalf("Unexpected second migration from %v: %v", from, err)
+	}
+}
@@ -0,0 +1,38 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"libuspin/uuid"
+)
+
+// SectionIDs describes the [ids] portion of a spin file, controlling all
+// identifiers generated for the image.
+type SectionIDs struct {
+	Seed      string               `toml:"seed"`       // If set, all generated UUIDs are reproducible
+	MachineID uuid.MachineIDPolicy `toml:"machine_id"` // What /etc/machine-id should contain
+}
+
+// ValidateSectionIDs will ensure the machine-id policy is known
+func ValidateSectionIDs(i *SectionIDs) error {
+	switch i.MachineID {
+	case uuid.MachineIDEmpty, uuid.MachineIDUninitialized, uuid.MachineIDGenerate:
+		return nil
+	default:
+		return invalidValue("ids.machine_id", i.MachineID, string(uuid.MachineIDEmpty), string(uuid.MachineIDUninitialized), string(uuid.MachineIDGenerate))
+	}
+}
@@ -0,0 +1,56 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// SectionKernel describes the optional [kernel] portion of a spin file, used
+// to ship a kernel that isn't available from the repositories. Either a
+// source tree is built within the chroot, or a prebuilt archive containing
+// boot/kernel-$version and lib/modules/$version is extracted into the rootfs.
+type SectionKernel struct {
+	Source       string   `toml:"source"`        // Kernel source directory or tarball
+	Config       string   `toml:"config"`        // Kernel .config, updated with olddefconfig
+	Patches      []string `toml:"patches"`       // Patches applied with -p1, in order
+	LocalVersion string   `toml:"local_version"` // Appended to the kernel release, i.e. "-uspin"
+	Jobs         int      `toml:"jobs"`          // Parallel make jobs, defaults to the host CPU count
+	Prebuilt     string   `toml:"prebuilt"`      // Prebuilt kernel archive, instead of Source
+}
+
+// Enabled returns true if a custom kernel has been requested
+func (k *SectionKernel) Enabled() bool {
+	return k.Source != "" || k.Prebuilt != ""
+}
+
+// ValidateSectionKernel will ensure the kernel configuration is consistent
+func ValidateSectionKernel(k *SectionKernel) error {
+	k.Source = strings.TrimSpace(k.Source)
+	k.Prebuilt = strings.TrimSpace(k.Prebuilt)
+	if k.Source != "" && k.Prebuilt != "" {
+		return errors.New("kernel.source and kernel.prebuilt are mutually exclusive")
+	}
+	if k.Source != "" && strings.TrimSpace(k.Config) == "" {
+		return errors.New("kernel.config is required to build kernel.source")
+	}
+	if k.Jobs < 0 {
+		return errors.New("kernel.jobs cannot be negative")
+	}
+	return nil
+}
@@ -19,34 +19,110 @@ package config
 import (
 	"errors"
 	"fmt"
-	"github.com/solus-project/libosdev/disk"
 	"strings"
 )
 
+// A Persistence is where a LiveOS keeps changes across reboots, once the
+// image has been written to USB
+type Persistence string
+
+const (
+	// PersistencePartition keeps changes within an ext4 partition appended
+	// to the hybrid image
+	PersistencePartition Persistence = "partition"
+
+	// PersistenceFile keeps changes within LiveOS/overlay.img, an overlay
+	// file on a FAT partition appended to the hybrid image, which remains
+	// usable for other files, much like the casper-rw file of Ubuntu
+	PersistenceFile Persistence = "file"
+
+	// PersistenceOverlayFile is the overlay file used by PersistenceFile
+	PersistenceOverlayFile = "LiveOS/overlay.img"
+
+	// DefaultPersistenceLabel labels the persistence partition
+	DefaultPersistenceLabel = "uspin-persist"
+
+	// DefaultPersistenceFileLabel labels the FAT partition of PersistenceFile,
+	// as FAT labels are limited to 11 characters
+	DefaultPersistenceFileLabel = "USPIN-RW"
+
+	// DefaultPersistenceSize is the size of the persistence partition in
+	// megabytes
+	DefaultPersistenceSize = 1024
+)
+
 // SectionLiveOS is the Live ISO specific configuration
 type SectionLiveOS struct {
-	Compression  disk.CompressionType `toml:"compression"`   // The type of compression to use on the LiveOS
-	FileName     string               `toml:"filename"`      // The resulting filename for this image spin
-	RootfsSize   int                  `toml:"rootfs_size"`   // Size of the image in megabytes (default 4000)
-	RootfsFormat string               `toml:"rootfs_format"` // Format of the rootfs, defaults to ext4
+	RootfsSize   int    `toml:"rootfs_size"`   // Size of the image in megabytes (default 4000)
+	RootfsFormat string `toml:"rootfs_format"` // Format of the rootfs, defaults to ext4
 
 	Label string `toml:"label"` // Label to give the resulting ISO
 
 	BootDir string `toml:"bootdir"` // Where to store boot assets, i.e. boot/
 
 	Bootloaders []LoaderType `toml:"bootloaders"` // Which bootloaders to enable
+
+	Persistence      Persistence `toml:"persistence"`       // Keep changes across reboots from USB, disabled if empty
+	PersistenceSize  int         `toml:"persistence_size"`  // Size of the persistence partition in megabytes
+	PersistenceLabel string      `toml:"persistence_label"` // Label of the persistence partition
 }
 
-// ValidateSectionLiveOS will determine if the configuration is valid for a LiveOS
-func ValidateSectionLiveOS(l *SectionLiveOS) error {
-	switch l.Compression {
-	case disk.CompressionGzip, disk.CompressionXZ:
+// PersistenceCmdline returns the kernel command line options which keep
+// changes on the persistence partition, if enabled
+func (l *SectionLiveOS) PersistenceCmdline() string {
+	switch l.Persistence {
+	case PersistencePartition:
+		return fmt.Sprintf("rd.live.overlay=LABEL=%v rd.live.overlay.overlayfs=1", l.PersistenceLabel)
+	case PersistenceFile:
+		return fmt.Sprintf("rd.live.overlay=LABEL=%v:/%v", l.PersistenceLabel, PersistenceOverlayFile)
 	default:
-		return fmt.Errorf("Unknown compression type: %v", l.Compression)
+		return ""
 	}
-	l.FileName = strings.TrimSpace(l.FileName)
-	if l.FileName == "" {
-		return errors.New("Invalid filename for livecd")
+}
+
+// validatePersistence will ensure the persistence partition can be created,
+// applying the defaults of its type
+func validatePersistence(l *SectionLiveOS) error {
+	l.Persistence = Persistence(strings.TrimSpace(string(l.Persistence)))
+	l.PersistenceLabel = strings.TrimSpace(l.PersistenceLabel)
+	maxLabel := 16
+	switch l.Persistence {
+	case "":
+		return nil
+	case PersistencePartition:
+		if l.PersistenceLabel == "" {
+			l.PersistenceLabel = DefaultPersistenceLabel
+		}
+	case PersistenceFile:
+		if l.PersistenceLabel == "" {
+			l.PersistenceLabel = DefaultPersistenceFileLabel
+		}
+		maxLabel = 11
+	default:
+		return invalidValue("liveos.persistence", l.Persistence, string(PersistencePartition), string(PersistenceFile))
+	}
+	if len(l.PersistenceLabel) > maxLabel || strings.ContainsAny(l.PersistenceLabel, " /:") {
+		return fmt.Errorf("Invalid liveos.persistence_label, at most %v characters without spaces: %q", maxLabel, l.PersistenceLabel)
+	}
+	if l.PersistenceSize == 0 {
+		l.PersistenceSize = DefaultPersistenceSize
+	}
+	if l.PersistenceSize < 64 {
+		return fmt.Errorf("liveos.persistence_size must be at least 64 megabytes: %v", l.PersistenceSize)
+	}
+	// The overlay file cannot exceed the largest file FAT allows
+	if l.Persistence == PersistenceFile && l.PersistenceSize > 4096 {
+		return fmt.Errorf("liveos.persistence_size cannot exceed 4096 megabytes for a persistence file: %v", l.PersistenceSize)
+	}
+	return nil
+}
+
+// ValidateSectionLiveOS will determine if the configuration is valid for a LiveOS
+func ValidateSectionLiveOS(l *SectionLiveOS) error {
+	for _, loader := range l.Bootloaders {
+		if loader != LoaderTypeSyslinux {
+			return invalidValue("liveos.bootloaders", loader, string(LoaderTypeSyslinux))
+		}
 	}
 	l.BootDir = strings.TrimSpace(l.BootDir)
 	if strings.HasPrefix(l.BootDir, "/") {
@@ -56,5 +132,5 @@ func ValidateSectionLiveOS(l *SectionLiveOS) error {
 	if strings.Contains(l.Label, " ") || strings.Contains(l.Label, "/") {
 		return errors.New("Invalid label for LiveOS")
 	}
-	return nil
+	return validatePersistence(l)
 }
@@ -22,9 +22,21 @@ import (
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/pkg"
 	"io/ioutil"
+	"libuspin/deprecation"
+	"libuspin/firstboot"
+	"libuspin/initsys"
+	"libuspin/overlay"
+	"libuspin/spec"
+	"libuspin/tree"
+	"libuspin/uuid"
+	"libuspin/version"
 	"os"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // ImageType is the type of image that will be created
@@ -37,17 +49,95 @@ type LoaderType string
 const (
 	// ImageTypeLiveOS is an ISO type image that may also be USB compatible
 	ImageTypeLiveOS ImageType = "liveos"
+
+	// ImageTypeOCI is a container image in an OCI layout archive, which can
+	// also be loaded with "docker load"
+	ImageTypeOCI ImageType = "oci"
+
+	// ImageTypeDisk is a partitioned, bootable disk image for virtual machines
+	// and cloud deployments
+	ImageTypeDisk ImageType = "disk"
 )
 
 const (
 	// LoaderTypeSyslinux refers to syslinux + isolinux
 	LoaderTypeSyslinux LoaderType = "syslinux"
+
+	// LoaderTypeGrub refers to a standalone grub EFI image
+	LoaderTypeGrub LoaderType = "grub"
+
+	// LoaderTypeSystemdBoot refers to the systemd-boot EFI loader
+	LoaderTypeSystemdBoot LoaderType = "systemd-boot"
 )
 
+// DefaultRefreshJobs is the number of repositories refreshed at once
+const DefaultRefreshJobs = 4
+
 // SectionImage describes the [image] portion of a spin file
 type SectionImage struct {
-	Packages string    `toml:"packages"` // Path to the packages file
-	Type     ImageType `toml:"type"`     // Type of image to construct
+	Packages       string       `toml:"packages"`        // Path to the packages file
+	Profile        string       `toml:"profile"`         // Profile for conditional Packages blocks, defaults to the .spin name
+	FileName       string       `toml:"filename"`        // The resulting filename for this image spin
+	Type           ImageType    `toml:"type"`            // Type of image to construct
+	LicensePolicy  string       `toml:"license_policy"`  // Optional path to a license policy file
+	LicenseReport  string       `toml:"license_report"`  // Path within the image for the license report
+	Manifest       string       `toml:"manifest"`        // Path within the image for the package manifest, without extension
+	SBOM           []SBOMFormat `toml:"sbom"`            // Bills of materials written alongside the image
+	Overlay        string       `toml:"overlay"`         // Optional directory installed over the rootfs
+	OverlayMeta    string       `toml:"overlay_meta"`    // Overlay metadata, defaults to overlay + ".meta.toml"
+	Exclude        []string     `toml:"exclude"`         // Paths left out of the final media, see tree.Exclude
+	ExcludeClasses []FileClass  `toml:"exclude_classes"` // Classes of files left out while installing packages, see FileClass
+	GrowRoot       bool         `toml:"grow_root"`       // Grow the root filesystem to fit the disk on first boot
+	Tiny           bool         `toml:"tiny"`            // Lighter defaults for images in the tens of megabytes
+	BuildProfile   BuildProfile `toml:"build_profile"`   // Defaults for a trade-off between time, size and reproducibility
+
+	// Timestamp of reproducible builds in seconds, defaults to $SOURCE_DATE_EPOCH
+	SourceDateEpoch int64 `toml:"source_date_epoch"`
+
+	Compression          Compression `toml:"compression"`            // Compression of the root filesystem, defaults to gzip
+	CompressionLevel     int         `toml:"compression_level"`      // Level of gzip or zstd compression, the default of the algorithm if 0
+	CompressionBlockSize int         `toml:"compression_block_size"` // Block size in kilobytes, larger compresses better, default if 0
+
+	PackageManager pkg.PackageManager `toml:"package_manager"` // Package manager backend, defaults to eopkg, or "auto" to detect
+	Seed           string             `toml:"seed"`            // Existing rootfs to build upon, see the seed package
+	Arch           string             `toml:"arch"`            // Target architecture, defaults to the host, others are emulated with qemu
+	BatchSize      int                `toml:"batch_size"`      // Most packages or groups per transaction, unbounded if 0
+	RefreshJobs    int                `toml:"refresh_jobs"`    // Repositories refreshed at once where supported, defaults to 4
+
+	Init     initsys.Type `toml:"init"`     // Init system of the image, defaults to systemd
+	Services []string     `toml:"services"` // Packaged services to enable on boot
+
+	// Constraint on the uspin version building this profile, i.e. ">= 0.2, < 1.0"
+	RequiredVersion string `toml:"required_uspin_version"`
+
+	// Template for the artifact directory, i.e. "out/{{.Edition}}/{{.Date}}",
+	// relative to the working directory like the filename
+	OutputDir       string          `toml:"output_dir"`
+	OutputCollision OutputCollision `toml:"output_collision"` // Policy for existing artifacts
+
+	// Additional artifacts built from the same rootfs, i.e. ["oci", "rootfs"]
+	Outputs []OutputFormat `toml:"outputs"`
+}
+
+// IsDisk returns true if the image type is deployed directly to a disk
+func (i ImageType) IsDisk() bool {
+	return i == ImageTypeDisk
+}
+
+// validateInit will ensure the init system is supported, defaulting to systemd
+func validateInit(i *SectionImage) error {
+	i.Init = initsys.Type(strings.TrimSpace(string(i.Init)))
+	if i.Init == "" {
+		i.Init = initsys.TypeSystemd
+	}
+	var allowed []string
+	for _, t := range initsys.Types {
+		if t == i.Init {
+			return nil
+		}
+		allowed = append(allowed, string(t))
+	}
+	return invalidValue("image.init", i.Init, allowed...)
 }
 
 // SectionBranding describes the image branding rules
@@ -58,16 +148,87 @@ type SectionBranding struct {
 
 // ImageConfiguration is the configuration for an image build
 type ImageConfiguration struct {
+	Format   int             `toml:"format"` // Format version of the .spin file
 	Image    SectionImage    `toml:"image"`
 	Branding SectionBranding `toml:"branding"`
+	Boot     SectionBoot     `toml:"boot"`
 	LiveOS   SectionLiveOS   `toml:"liveos"`
+	OCI      SectionOCI      `toml:"oci"`
+	Disk     SectionDisk     `toml:"disk"`
+	DNF      SectionDNF      `toml:"dnf"`
+	APK      SectionAPK      `toml:"apk"`
+	APT      SectionAPT      `toml:"apt"`
+	Zypper   SectionZypper   `toml:"zypper"`
+	XBPS     SectionXBPS     `toml:"xbps"`
+	Scripts  SectionScripts  `toml:"scripts"`
 	Isolinux SectionIsolinux `toml:"isolinux"`
+	IDs      SectionIDs      `toml:"ids"`
+	Publish  SectionPublish  `toml:"publish"`
+	CCache   SectionCCache   `toml:"ccache"`
+	Kernel   SectionKernel   `toml:"kernel"`
+	DKMS     SectionDKMS     `toml:"dkms"`
+	Test     SectionTest     `toml:"test"`
+
+	Downloads    SectionDownloads    `toml:"downloads"`
+	PackageCache SectionPackageCache `toml:"package_cache"`
+	Proxy        SectionProxy        `toml:"proxy"`
+	Safety       SectionSafety       `toml:"safety"`
+	Checksums    SectionChecksums    `toml:"checksums"`
+	Tools        SectionTools        `toml:"tools"`
+	Status       SectionStatus       `toml:"status"`
+
+	// Host directories bind mounted while running commands in the chroot
+	Mounts []SectionMount `toml:"mounts"`
+
+	// Overrides of each additional output, by format
+	Output map[string]*SectionOutput `toml:"output"`
+
+	// Commands deferred until the first boot of the image
+	Firstboot []*firstboot.Task `toml:"firstboot"`
+
+	// Permission rules applied after packages and overlays, in order
+	Permissions []*overlay.Rule `toml:"permissions"`
+
+	// Secret names mapped to their sources, see the secrets package
+	Secrets map[string]string `toml:"secrets"`
+
+	// Values referenced as "${NAME}" throughout the configuration
+	Variables map[string]string `toml:"variables"`
+
+	// Deprecated keys found while loading the configuration
+	Deprecations []*deprecation.Notice `toml:"-"`
+
+	builtins  map[string]string // Variables provided by uspin, see builtinVariables
+	decrypted []string          // Plaintext of every encrypted value, see Decrypted
 }
 
 // New will return a new ImageConfiguration for the given path and attempt to
 // parse it. This function will return a nil ImageConfiguration if parsing
 // fails.
 func New(cpath string) (*ImageConfiguration, error) {
+	iconf, _, unknown, err := load(cpath)
+	if err != nil {
+		return nil, err
+	}
+	// Typos would otherwise silently fall back to the defaults
+	if err := unknownKeysError(unknown); err != nil {
+		return nil, err
+	}
+	for _, n := range iconf.Deprecations {
+		n.Warn()
+	}
+	for _, v := range validators {
+		if err := v.check(iconf); err != nil {
+			return nil, err
+		}
+	}
+	return iconf, nil
+}
+
+// load will read, migrate and decode the configuration without validating
+// it, returning the normalised contents of the file and any keys that were
+// not understood.
+func load(cpath string) (*ImageConfiguration, []byte, []*Problem, error) {
 	iconf := &ImageConfiguration{
 		LiveOS: SectionLiveOS{
 			RootfsFormat: "ext4",
@@ -79,6 +240,13 @@ func New(cpath string) (*ImageConfiguration, error) {
 			},
 			Label: "uspin.ISO",
 		},
+		Disk: SectionDisk{
+			Size:        4000,
+			Bootloaders: []LoaderType{LoaderTypeSyslinux},
+		},
+		IDs: SectionIDs{
+			MachineID: uuid.MachineIDEmpty,
+		},
 	}
 	var data []byte
 	var err error
@@ -86,36 +254,232 @@ func New(cpath string) (*ImageConfiguration, error) {
 
 	fi, err = os.Open(cpath)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	defer fi.Close()
 
 	// Read the configuration file in
 	if data, err = ioutil.ReadAll(fi); err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+
+	// Tolerate files edited on Windows
+	data, fixes := spec.Normalize(data)
+	for _, fix := range fixes {
+		log.WithFields(log.Fields{
+			"file": cpath,
+		}).Warning(fix)
+	}
+	original := data
+
+	var raw map[string]interface{}
+	if _, err = toml.Decode(string(data), &raw); err != nil {
+		return nil, original, nil, err
+	}
+
+	// Fail fast before anything else is interpreted by the wrong uspin
+	if image, ok := raw["image"].(map[string]interface{}); ok {
+		if required, ok := image["required_uspin_version"].(string); ok {
+			if err := version.Require(required); err != nil {
+				return nil, original, nil, err
+			}
+		}
+	}
+
+	// Bring older formats up to date before decoding
+	from, notes, err := Migrate(raw)
+	if err != nil {
+		return nil, original, nil, err
+	}
+	if from != CurrentFormat {
+		notes = append(notes, &deprecation.Notice{
+			Name:        fmt.Sprintf("format %v", from),
+			Replacement: fmt.Sprintf("format %v", CurrentFormat),
+			Message:     "run 'uspin migrate' to upgrade",
+		})
+		if data, err = Encode(raw); err != nil {
+			return nil, original, nil, err
+		}
 	}
 
 	// Attempt to populate config from the toml spin file
-	if _, err = toml.Decode(string(data), iconf); err != nil {
-		return nil, err
+	md, err := toml.Decode(string(data), iconf)
+	if err != nil {
+		return nil, original, nil, err
+	}
+	unknown := unknownKeys(md, iconf)
+	for _, n := range notes {
+		n.File = cpath
+	}
+	iconf.Deprecations = notes
+	applyTiny(iconf, md)
+	if err := applyBuildProfile(iconf, md); err != nil {
+		return nil, original, unknown, err
 	}
 
-	// Ensure errors is non empty!
-	iconf.Image.Packages = strings.TrimSpace(iconf.Image.Packages)
-	if iconf.Image.Packages == "" {
-		return nil, errors.New("image.packages cannot be empty")
+	// Substitute variables, so everything else sees the final values, with
+	// DATE following the epoch of reproducible builds
+	now := time.Now()
+	if iconf.Image.Reproducible() {
+		now = iconf.Image.Epoch()
+	}
+	if err := expandVariables(iconf, now); err != nil {
+		return nil, original, unknown, err
 	}
 
+	// Decrypt any encrypted values before validation
+	if iconf.decrypted, err = decryptFields(reflect.ValueOf(iconf), ageDecrypt); err != nil {
+		return nil, original, unknown, err
+	}
+	return iconf, original, unknown, nil
+}
+
+// A validator checks and normalises the part of the configuration at key
+type validator struct {
+	key   string
+	check func(iconf *ImageConfiguration) error
+}
+
+// validators are run in order once the configuration is decoded
+var validators = []validator{
+	{"image.packages", func(iconf *ImageConfiguration) error {
+		iconf.Image.Packages = strings.TrimSpace(iconf.Image.Packages)
+		if iconf.Image.Packages == "" {
+			return errors.New("image.packages cannot be empty")
+		}
+		return nil
+	}},
+	{"image.package_manager", func(iconf *ImageConfiguration) error {
+		iconf.Image.PackageManager = pkg.PackageManager(strings.TrimSpace(string(iconf.Image.PackageManager)))
+		if iconf.Image.PackageManager == "" {
+			iconf.Image.PackageManager = pkg.PackageManagerEopkg
+		}
+		return nil
+	}},
+	{"image.arch", func(iconf *ImageConfiguration) error {
+		if iconf.Image.Arch = strings.TrimSpace(iconf.Image.Arch); iconf.Image.Arch == "" {
+			iconf.Image.Arch = HostArch()
+		}
+		if strings.ContainsAny(iconf.Image.Arch, " /") {
+			return fmt.Errorf("Invalid architecture: %v", iconf.Image.Arch)
+		}
+		return nil
+	}},
+	{"image.batch_size", func(iconf *ImageConfiguration) error {
+		if iconf.Image.BatchSize < 0 {
+			return fmt.Errorf("batch_size cannot be negative: %v", iconf.Image.BatchSize)
+		}
+		return nil
+	}},
+	{"image.refresh_jobs", func(iconf *ImageConfiguration) error {
+		if iconf.Image.RefreshJobs < 0 {
+			return fmt.Errorf("refresh_jobs cannot be negative: %v", iconf.Image.RefreshJobs)
+		}
+		if iconf.Image.RefreshJobs == 0 {
+			iconf.Image.RefreshJobs = DefaultRefreshJobs
+		}
+		return nil
+	}},
+	{"image.filename", func(iconf *ImageConfiguration) error {
+		iconf.Image.FileName = strings.TrimSpace(iconf.Image.FileName)
+		if iconf.Image.FileName == "" {
+			return errors.New("image.filename cannot be empty")
+		}
+		return nil
+	}},
+	{"ids", func(iconf *ImageConfiguration) error { return ValidateSectionIDs(&iconf.IDs) }},
+	{"publish", func(iconf *ImageConfiguration) error { return ValidateSectionPublish(&iconf.Publish, iconf.Image.Arch) }},
+	{"ccache", func(iconf *ImageConfiguration) error { return ValidateSectionCCache(&iconf.CCache) }},
+	{"kernel", func(iconf *ImageConfiguration) error { return ValidateSectionKernel(&iconf.Kernel) }},
+	{"dkms", func(iconf *ImageConfiguration) error { return ValidateSectionDKMS(&iconf.DKMS) }},
+	{"test", func(iconf *ImageConfiguration) error { return ValidateSectionTest(&iconf.Test) }},
+	{"downloads", func(iconf *ImageConfiguration) error { return ValidateSectionDownloads(&iconf.Downloads) }},
+	{"package_cache", func(iconf *ImageConfiguration) error { return ValidateSectionPackageCache(&iconf.PackageCache) }},
+	{"proxy", func(iconf *ImageConfiguration) error { return ValidateSectionProxy(&iconf.Proxy) }},
+	{"safety", func(iconf *ImageConfiguration) error { return ValidateSectionSafety(&iconf.Safety) }},
+	{"checksums", func(iconf *ImageConfiguration) error { return ValidateSectionChecksums(&iconf.Checksums) }},
+	{"tools", func(iconf *ImageConfiguration) error { return ValidateSectionTools(&iconf.Tools) }},
+	{"status", func(iconf *ImageConfiguration) error { return ValidateSectionStatus(&iconf.Status) }},
+	{"boot", func(iconf *ImageConfiguration) error { return ValidateSectionBoot(&iconf.Boot, iconf.Image.Type) }},
+	{"scripts", func(iconf *ImageConfiguration) error { return ValidateSectionScripts(&iconf.Scripts) }},
+	{"mounts", func(iconf *ImageConfiguration) error {
+		for i := range iconf.Mounts {
+			if err := ValidateSectionMount(&iconf.Mounts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"image.outputs", func(iconf *ImageConfiguration) error { return ValidateOutput(&iconf.Image) }},
+	{"output", func(iconf *ImageConfiguration) error {
+		for name, output := range iconf.Output {
+			if err := ValidateSectionOutput(name, output, iconf.Image.Outputs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"image.build_profile", func(iconf *ImageConfiguration) error { return validateBuildProfile(&iconf.Image) }},
+	{"image.compression", func(iconf *ImageConfiguration) error { return validateCompression(&iconf.Image) }},
+	{"image.init", func(iconf *ImageConfiguration) error { return validateInit(&iconf.Image) }},
+	{"image.grow_root", func(iconf *ImageConfiguration) error {
+		if iconf.Image.GrowRoot && !iconf.Image.Type.IsDisk() {
+			return fmt.Errorf("grow_root is only supported for disk images, not %v", iconf.Image.Type)
+		}
+		return nil
+	}},
+	{"image.exclude_classes", func(iconf *ImageConfiguration) error { return validateExcludeClasses(&iconf.Image) }},
+	{"image.sbom", func(iconf *ImageConfiguration) error { return validateSBOM(&iconf.Image) }},
+	{"image.exclude", func(iconf *ImageConfiguration) error {
+		for _, pattern := range iconf.Image.Exclude {
+			if err := tree.ValidateExclude(pattern); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"firstboot", func(iconf *ImageConfiguration) error {
+		for _, task := range iconf.Firstboot {
+			if err := task.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"permissions", func(iconf *ImageConfiguration) error {
+		for _, rule := range iconf.Permissions {
+			if err := rule.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
 	// Validate the type
 	// TODO: Add more image types!
-	switch iconf.Image.Type {
-	case ImageTypeLiveOS:
-		if err := ValidateSectionLiveOS(&iconf.LiveOS); err != nil {
-			return nil, err
+	{"liveos", func(iconf *ImageConfiguration) error {
+		if iconf.Image.Type != ImageTypeLiveOS {
+			return nil
 		}
-	default:
-		return nil, fmt.Errorf("Unknown image type: %v", iconf.Image.Type)
-	}
-
-	return iconf, nil
+		return ValidateSectionLiveOS(&iconf.LiveOS)
+	}},
+	{"oci", func(iconf *ImageConfiguration) error {
+		if iconf.Image.Type != ImageTypeOCI {
+			return nil
+		}
+		return ValidateSectionOCI(&iconf.OCI)
+	}},
+	{"disk", func(iconf *ImageConfiguration) error {
+		if iconf.Image.Type != ImageTypeDisk {
+			return nil
+		}
+		return ValidateSectionDisk(&iconf.Disk)
+	}},
+	{"image.type", func(iconf *ImageConfiguration) error {
+		switch iconf.Image.Type {
+		case ImageTypeLiveOS, ImageTypeOCI, ImageTypeDisk:
+			return nil
+		default:
+			return invalidValue("image.type", iconf.Image.Type, string(ImageTypeLiveOS), string(ImageTypeOCI), string(ImageTypeDisk))
+		}
+	}},
 }
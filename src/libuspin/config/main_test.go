@@ -17,6 +17,11 @@
 package config
 
 import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -35,7 +40,208 @@ func TestConfig(t *testing.T) {
 	if c.Image.Type != "liveos" {
 		t.Fatalf("Invalid type")
 	}
-	if c.LiveOS.Compression != "gzip" {
-		t.Fatalf("Invalid compression: %v", c.LiveOS.Compression)
+	if c.Image.Compression != "gzip" {
+		t.Fatalf("Invalid compression: %v", c.Image.Compression)
+	}
+	if c.Image.FileName != "Solus-1.2.1.iso" {
+		t.Fatalf("Invalid filename: %v", c.Image.FileName)
+	}
+	// testdata is kept at format 1 to cover the migrations
+	if len(c.Deprecations) != 3 {
+		t.Fatalf("Expected deprecation notices: %v", c.Deprecations)
+	}
+}
+
+func TestDecryptFields(t *testing.T) {
+	// Reversing the ciphertext stands in for age
+	reverse := func(ciphertext []byte) ([]byte, error) {
+		ret := make([]byte, len(ciphertext))
+		for i, b := range ciphertext {
+			ret[len(ret)-1-i] = b
+		}
+		return ret, nil
+	}
+	encrypted := EncryptedPrefix + base64.StdEncoding.EncodeToString([]byte("terces")) + EncryptedSuffix
+	c := &ImageConfiguration{
+		Branding: SectionBranding{Title: encrypted},
+		Secrets:  map[string]string{"wifi": encrypted, "plain": "env:WIFI"},
+	}
+	plaintexts, err := decryptFields(reflect.ValueOf(c), reverse)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if len(plaintexts) != 2 || plaintexts[0] != "secret" || plaintexts[1] != "secret" {
+		t.Fatalf("Decrypted values not returned: %v", plaintexts)
+	}
+	if c.Branding.Title != "secret" || c.Secrets["wifi"] != "secret" {
+		t.Fatalf("Values not decrypted: %v %v", c.Branding.Title, c.Secrets)
+	}
+	if c.Secrets["plain"] != "env:WIFI" {
+		t.Fatalf("Plain value should be untouched: %v", c.Secrets["plain"])
+	}
+}
+
+func TestValidateSectionBoot(t *testing.T) {
+	b := &SectionBoot{SecureBootKey: "db.key"}
+	if err := ValidateSectionBoot(b, ImageTypeLiveOS); err == nil {
+		t.Fatalf("Signing should require a loader")
+	}
+	b.Loader = LoaderTypeSystemdBoot
+	if err := ValidateSectionBoot(b, ImageTypeLiveOS); err == nil {
+		t.Fatalf("Signing should require a certificate")
+	}
+	b.SecureBootCert = "db.crt"
+	b.Shim = "/usr/share/shim/shimx64.efi"
+	if err := ValidateSectionBoot(b, ImageTypeLiveOS); err == nil {
+		t.Fatalf("Shim should only chain to grub")
+	}
+	b.Loader = LoaderTypeGrub
+	if err := ValidateSectionBoot(b, ImageTypeLiveOS); err != nil || !b.SecureBoot() {
+		t.Fatalf("Failed to validate Secure Boot: %v", err)
+	}
+	if err := ValidateSectionBoot(b, ImageTypeDisk); err == nil {
+		t.Fatalf("UEFI loaders are only supported for liveos images")
+	}
+}
+
+func TestValidatePersistence(t *testing.T) {
+	l := &SectionLiveOS{Persistence: PersistencePartition}
+	if err := ValidateSectionLiveOS(l); err != nil {
+		t.Fatalf("Failed to validate persistence: %v", err)
+	}
+	if l.PersistenceLabel != DefaultPersistenceLabel || l.PersistenceSize != DefaultPersistenceSize {
+		t.Fatalf("Persistence defaults not applied: %v %v", l.PersistenceLabel, l.PersistenceSize)
+	}
+	if cmdline := l.PersistenceCmdline(); cmdline != "rd.live.overlay=LABEL=uspin-persist rd.live.overlay.overlayfs=1" {
+		t.Fatalf("Incorrect persistence cmdline: %v", cmdline)
+	}
+
+	// FAT labels are shorter, and files limited to 4GiB
+	l = &SectionLiveOS{Persistence: PersistenceFile, PersistenceLabel: DefaultPersistenceLabel}
+	if err := ValidateSectionLiveOS(l); err == nil {
+		t.Fatalf("Label should be too long for FAT")
+	}
+	l = &SectionLiveOS{Persistence: PersistenceFile}
+	if err := ValidateSectionLiveOS(l); err != nil {
+		t.Fatalf("Failed to validate persistence file: %v", err)
+	}
+	if cmdline := l.PersistenceCmdline(); cmdline != "rd.live.overlay=LABEL=USPIN-RW:/LiveOS/overlay.img" {
+		t.Fatalf("Incorrect persistence cmdline: %v", cmdline)
+	}
+	l.PersistenceSize = 8192
+	if err := ValidateSectionLiveOS(l); err == nil {
+		t.Fatalf("Persistence file should not exceed 4GiB")
+	}
+
+	l = &SectionLiveOS{Persistence: "casper"}
+	if err := ValidateSectionLiveOS(l); err == nil || !strings.Contains(err.Error(), "liveos.persistence") {
+		t.Fatalf("Unknown persistence should fail: %v", err)
+	}
+}
+
+func TestValidateOutputs(t *testing.T) {
+	i := &SectionImage{Type: ImageTypeOCI, Outputs: []OutputFormat{OutputFormatRootfs}}
+	if err := ValidateOutput(i); err != nil {
+		t.Fatalf("Failed to validate outputs: %v", err)
+	}
+	i.Outputs = append(i.Outputs, OutputFormatRootfs)
+	if err := ValidateOutput(i); err == nil {
+		t.Fatalf("Duplicate outputs should not validate")
+	}
+	i.Outputs = []OutputFormat{OutputFormatOCI}
+	if err := ValidateOutput(i); err == nil {
+		t.Fatalf("oci images should not also export oci")
+	}
+	i.Outputs = []OutputFormat{"squash"}
+	if err := ValidateOutput(i); err == nil || !strings.Contains(err.Error(), "oci, rootfs") {
+		t.Fatalf("Unknown outputs should list the allowed values: %v", err)
+	}
+}
+
+func TestValidateSectionOutput(t *testing.T) {
+	outputs := []OutputFormat{OutputFormatOCI}
+	o := &SectionOutput{Remove: []string{" kernel "}}
+	if err := ValidateSectionOutput("oci", o, outputs); err != nil || o.Remove[0] != "kernel" {
+		t.Fatalf("Failed to validate output: %v", err)
+	}
+	if err := ValidateSectionOutput("rootfs", o, outputs); err == nil {
+		t.Fatalf("Overrides should require the output to be declared")
+	}
+	o.Install = []string{""}
+	if err := ValidateSectionOutput("oci", o, outputs); err == nil {
+		t.Fatalf("Empty package names should not validate")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-check")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("format = 3\n\n[image]\npackages = \"x.packages\"\ntype = \"livos\"\nfilname = \"x.iso\"\n")
+	fi.Close()
+
+	conf, problems := Check(fi.Name())
+	if conf == nil {
+		t.Fatalf("Check should decode the configuration despite problems")
+	}
+	want := map[int]string{
+		3: "image.filename cannot be empty",
+		5: "Invalid value 'livos' for image.type",
+		6: "Unknown key 'image.filname'",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Incorrect problems: %v", problems)
+	}
+	for _, p := range problems {
+		if !strings.HasPrefix(p.Message, want[p.Line]) || p.Warning {
+			t.Fatalf("Unexpected problem on line %v: %v", p.Line, p.Message)
+		}
+	}
+	if _, err := New(fi.Name()); err == nil {
+		t.Fatalf("New should fail on the same file")
+	}
+}
+
+func TestExpandVariables(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-variables")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("format = 3\n\n[variables]\nNAME = \"Solus-${VERSION}\"\nVERSION = \"1.0\"\n\n" +
+		"[image]\npackages = \"x.packages\"\ntype = \"liveos\"\nfilename = \"${NAME}-${ARCH}.iso\"\narch = \"aarch64\"\n\n" +
+		"[[firstboot]]\nname = \"hello\"\ncommand = \"echo $${HOME}\"\n")
+	fi.Close()
+
+	Overrides["VERSION"] = "2.0"
+	defer delete(Overrides, "VERSION")
+	conf, err := New(fi.Name())
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if conf.Image.FileName != "Solus-2.0-aarch64.iso" {
+		t.Fatalf("Variables were not expanded: %v", conf.Image.FileName)
+	}
+	if conf.Firstboot[0].Command != "echo ${HOME}" {
+		t.Fatalf("Escaped reference was expanded: %v", conf.Firstboot[0].Command)
+	}
+	if conf.Publish.Arch != "aarch64" {
+		t.Fatalf("Publishing should default to the image architecture: %v", conf.Publish.Arch)
+	}
+	if value, ok := conf.Lookup("VERSION"); !ok || value != "2.0" {
+		t.Fatalf("Command line should override the configuration: %v", value)
+	}
+
+	delete(Overrides, "VERSION")
+	fi, err = os.Create(fi.Name())
+	if err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	fi.WriteString("format = 3\n\n[image]\npackages = \"x.packages\"\ntype = \"liveos\"\nfilename = \"${USPIN_UNDEFINED}.iso\"\n")
+	fi.Close()
+	if _, err := New(fi.Name()); err == nil || !strings.Contains(err.Error(), "image.filename") {
+		t.Fatalf("Undefined variables should fail, naming the key: %v", err)
 	}
 }
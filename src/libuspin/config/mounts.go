@@ -0,0 +1,53 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SectionMount describes a host directory bind mounted into the chroot
+// whenever USpin runs commands within it, i.e. a local artifact store:
+//
+//	[[mounts]]
+//	source = "/srv/artifacts"
+//	target = "/var/artifacts"
+//	read_only = true
+type SectionMount struct {
+	Source   string `toml:"source"`    // Host directory, relative to the .spin file if not absolute
+	Target   string `toml:"target"`    // Absolute path within the chroot
+	ReadOnly bool   `toml:"read_only"` // Mount read-only
+}
+
+// ValidateSectionMount will ensure the mount is usable
+func ValidateSectionMount(m *SectionMount) error {
+	m.Source = strings.TrimSpace(m.Source)
+	m.Target = strings.TrimSpace(m.Target)
+	if m.Source == "" {
+		return fmt.Errorf("Mount source cannot be empty")
+	}
+	if !strings.HasPrefix(m.Target, "/") {
+		return fmt.Errorf("Mount target must be absolute: %v", m.Target)
+	}
+	for _, part := range strings.Split(m.Target, "/") {
+		if part == ".." {
+			return fmt.Errorf("Mount target cannot leave the chroot: %v", m.Target)
+		}
+	}
+	return nil
+}
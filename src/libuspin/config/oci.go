@@ -0,0 +1,56 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SectionOCI is the container image specific configuration
+type SectionOCI struct {
+	Tag        string   `toml:"tag"`         // Name and tag of the image, defaults to "<profile>:latest"
+	Cmd        []string `toml:"cmd"`         // Default command, defaults to /bin/sh
+	Entrypoint []string `toml:"entrypoint"`  // Optional entrypoint
+	Env        []string `toml:"env"`         // Environment in "KEY=value" form, PATH is set if missing
+	WorkingDir string   `toml:"working_dir"` // Optional working directory
+	User       string   `toml:"user"`        // Optional user to run as
+}
+
+// ValidateSectionOCI will determine if the configuration is valid for an OCI image
+func ValidateSectionOCI(o *SectionOCI) error {
+	o.Tag = strings.TrimSpace(o.Tag)
+	if strings.ContainsAny(o.Tag, " /") {
+		return fmt.Errorf("Invalid tag for oci: %v", o.Tag)
+	}
+	if len(o.Cmd) == 0 && len(o.Entrypoint) == 0 {
+		o.Cmd = []string{"/bin/sh"}
+	}
+	havePath := false
+	for _, env := range o.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("Invalid environment for oci, expected KEY=value: %v", env)
+		}
+		if strings.HasPrefix(env, "PATH=") {
+			havePath = true
+		}
+	}
+	if !havePath {
+		o.Env = append(o.Env, "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"libuspin/tree"
+	"strings"
+	"text/template"
+)
+
+// An OutputCollision policy decides what happens when an artifact already
+// exists within the output directory.
+type OutputCollision string
+
+const (
+	// OutputCollisionOverwrite replaces existing artifacts (default)
+	OutputCollisionOverwrite OutputCollision = "overwrite"
+
+	// OutputCollisionError fails the build rather than replace an artifact
+	OutputCollisionError OutputCollision = "error"
+
+	// OutputCollisionSuffix appends "-N" to the artifact names, i.e.
+	// Solus-1.2.1-1.iso
+	OutputCollisionSuffix OutputCollision = "version-suffix"
+)
+
+// An OutputFormat is an additional artifact built from the same rootfs as
+// the image, named after the image with the suffix of the format.
+type OutputFormat string
+
+const (
+	// OutputFormatOCI is a container image archive, as built by the oci type
+	OutputFormatOCI OutputFormat = "oci"
+
+	// OutputFormatRootfs is a plain tarball of the rootfs
+	OutputFormatRootfs OutputFormat = "rootfs"
+)
+
+// Suffix returns the suffix appended to the image name for the format, i.e.
+// Solus-1.2.1.iso.oci.tar
+func (o OutputFormat) Suffix() string {
+	return "." + string(o) + ".tar"
+}
+
+// SectionOutput describes an [output.<format>] table, overriding the image
+// for one additional output. Overrides are applied to a snapshot of the
+// finished rootfs, leaving the image itself untouched.
+type SectionOutput struct {
+	Install []string `toml:"install"` // Packages only in this output
+	Remove  []string `toml:"remove"`  // Packages left out of this output, i.e. the kernel
+	Exclude []string `toml:"exclude"` // Further paths left out of this output, see tree.Exclude
+}
+
+// HasPackages returns true if the package manager is needed for the output
+func (o *SectionOutput) HasPackages() bool {
+	return len(o.Install) > 0 || len(o.Remove) > 0
+}
+
+// ValidateSectionOutput will ensure the overrides are of a declared output
+func ValidateSectionOutput(name string, o *SectionOutput, outputs []OutputFormat) error {
+	declared := false
+	for _, format := range outputs {
+		declared = declared || string(format) == name
+	}
+	if !declared {
+		return fmt.Errorf("output.%v overrides an output missing from image.outputs", name)
+	}
+	for _, list := range [][]string{o.Install, o.Remove} {
+		for i := range list {
+			if list[i] = strings.TrimSpace(list[i]); list[i] == "" {
+				return fmt.Errorf("output.%v cannot contain empty package names", name)
+			}
+		}
+	}
+	for _, pattern := range o.Exclude {
+		if err := tree.ValidateExclude(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOutputs will ensure each additional format is known, and isn't the
+// image itself
+func validateOutputs(i *SectionImage) error {
+	seen := make(map[OutputFormat]bool)
+	for _, o := range i.Outputs {
+		switch o {
+		case OutputFormatOCI, OutputFormatRootfs:
+		default:
+			return invalidValue("image.outputs", o, string(OutputFormatOCI), string(OutputFormatRootfs))
+		}
+		if seen[o] {
+			return fmt.Errorf("Duplicate output in image.outputs: %v", o)
+		}
+		if o == OutputFormatOCI && i.Type == ImageTypeOCI {
+			return fmt.Errorf("image.outputs cannot contain %v, it is already the image type", o)
+		}
+		seen[o] = true
+	}
+	return nil
+}
+
+// ValidateOutput will ensure the output directory template, collision
+// policy and additional formats of the image section are usable.
+func ValidateOutput(i *SectionImage) error {
+	i.OutputDir = strings.TrimSpace(i.OutputDir)
+	if _, err := template.New("output_dir").Option("missingkey=error").Parse(i.OutputDir); err != nil {
+		return fmt.Errorf("Invalid output_dir template: %v", err)
+	}
+	switch i.OutputCollision {
+	case "":
+		i.OutputCollision = OutputCollisionOverwrite
+	case OutputCollisionOverwrite, OutputCollisionError, OutputCollisionSuffix:
+	default:
+		return invalidValue("image.output_collision", i.OutputCollision, string(OutputCollisionOverwrite), string(OutputCollisionError), string(OutputCollisionSuffix))
+	}
+	return validateOutputs(i)
+}
@@ -0,0 +1,47 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"libuspin/proxy"
+	"strings"
+)
+
+// SectionProxy describes the [proxy] portion of a spin file, routing the
+// network access of the package manager through a recording proxy so that
+// builds can be reproduced exactly, even without repository snapshots.
+type SectionProxy struct {
+	Mode      proxy.Mode `toml:"mode"`      // Either "record" or "replay", disabled if empty
+	Directory string     `toml:"directory"` // Where the recording is kept
+}
+
+// ValidateSectionProxy will ensure the proxy has somewhere to record to
+func ValidateSectionProxy(p *SectionProxy) error {
+	p.Directory = strings.TrimSpace(p.Directory)
+	switch p.Mode {
+	case proxy.ModeOff:
+		return nil
+	case proxy.ModeRecord, proxy.ModeReplay:
+	default:
+		return invalidValue("proxy.mode", p.Mode, string(proxy.ModeRecord), string(proxy.ModeReplay))
+	}
+	if p.Directory == "" {
+		return errors.New("proxy.directory cannot be empty")
+	}
+	return nil
+}
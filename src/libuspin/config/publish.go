@@ -0,0 +1,107 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// PublishLayout determines how releases are arranged within the release tree
+type PublishLayout string
+
+const (
+	// PublishLayoutFlat places each release in a single directory
+	PublishLayoutFlat PublishLayout = "flat"
+
+	// PublishLayoutMirror arranges releases by release/edition/arch, with
+	// checksums, a signed index and "latest" symlinks, ready to rsync to
+	// public mirrors
+	PublishLayoutMirror PublishLayout = "mirror"
+)
+
+// SectionPublish describes the [publish] portion of a spin file, controlling
+// where successful builds are published to.
+type SectionPublish struct {
+	Directory string `toml:"directory"` // Root of the release tree, publishing is disabled if empty
+	Release   string `toml:"release"`   // Name of the release, defaults to the publishing date
+	Feed      bool   `toml:"feed"`      // Whether to maintain an Atom feed of releases
+	BaseURL   string `toml:"base_url"`  // Public URL of the directory, used for feed links
+
+	Layout  PublishLayout `toml:"layout"`   // Arrangement of the release tree, defaults to flat
+	Edition string        `toml:"edition"`  // Edition name for the mirror layout, defaults to the .spin name
+	Arch    string        `toml:"arch"`     // Architecture for the mirror layout, defaults to the host
+	SignKey string        `toml:"sign_key"` // Optional GPG key used to sign the release index
+
+	// Optional cosign signing of every artifact, either "keyless" or a key
+	// reference understood by cosign, i.e. a path or kms:// URI
+	Cosign string `toml:"cosign"`
+
+	// Identifies this builder within provenance attestations, defaults to
+	// the hostname
+	BuilderID string `toml:"builder_id"`
+}
+
+// CosignKeyless requests keyless (OIDC) cosign signing
+const CosignKeyless = "keyless"
+
+// ValidateSectionPublish will normalise the publishing configuration, with
+// arch being the architecture of the image
+func ValidateSectionPublish(p *SectionPublish, arch string) error {
+	p.Directory = strings.TrimSpace(p.Directory)
+	p.Release = strings.TrimSpace(p.Release)
+	p.BaseURL = strings.TrimSuffix(strings.TrimSpace(p.BaseURL), "/")
+	p.Edition = strings.TrimSpace(p.Edition)
+	p.Cosign = strings.TrimSpace(p.Cosign)
+	if p.BuilderID = strings.TrimSpace(p.BuilderID); p.BuilderID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		p.BuilderID = "uspin://" + host
+	}
+	if strings.Contains(p.Edition, "/") {
+		return fmt.Errorf("Invalid edition for publish: %v", p.Edition)
+	}
+	if p.Arch = strings.TrimSpace(p.Arch); p.Arch == "" {
+		p.Arch = arch
+	}
+	switch p.Layout {
+	case "":
+		p.Layout = PublishLayoutFlat
+	case PublishLayoutFlat, PublishLayoutMirror:
+	default:
+		return invalidValue("publish.layout", p.Layout, string(PublishLayoutFlat), string(PublishLayoutMirror))
+	}
+	return nil
+}
+
+// HostArch returns the conventional distribution name of the host architecture
+func HostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
@@ -0,0 +1,60 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"strings"
+)
+
+// SafetyPolicy controls how lines ignoring package manager safety, those
+// prefixed with '~' in the Packages file, are treated
+type SafetyPolicy string
+
+const (
+	// SafetyEnforce refuses to build if any line ignores safety
+	SafetyEnforce SafetyPolicy = "enforce"
+
+	// SafetyWarn reports every line ignoring safety, and what it affects
+	SafetyWarn SafetyPolicy = "warn"
+
+	// SafetyIgnore allows lines to ignore safety without comment
+	SafetyIgnore SafetyPolicy = "ignore"
+)
+
+// DefaultEssentialGroup holds the packages protected by safety checks on Solus
+const DefaultEssentialGroup = "system.base"
+
+// SectionSafety describes the [safety] portion of a spin file
+type SectionSafety struct {
+	Policy    SafetyPolicy `toml:"policy"`    // Defaults to warn
+	Essential string       `toml:"essential"` // Group of packages protected by safety checks
+}
+
+// ValidateSectionSafety will ensure the safety policy is known
+func ValidateSectionSafety(s *SectionSafety) error {
+	switch s.Policy {
+	case "":
+		s.Policy = SafetyWarn
+	case SafetyEnforce, SafetyWarn, SafetyIgnore:
+	default:
+		return invalidValue("safety.policy", s.Policy, string(SafetyEnforce), string(SafetyWarn), string(SafetyIgnore))
+	}
+	if s.Essential = strings.TrimSpace(s.Essential); s.Essential == "" {
+		s.Essential = DefaultEssentialGroup
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"strings"
+)
+
+// SBOMFormat names a Software Bill of Materials format, see the sbom package
+type SBOMFormat string
+
+const (
+	// SBOMSPDX is an SPDX 2.3 JSON document
+	SBOMSPDX SBOMFormat = "spdx"
+
+	// SBOMCycloneDX is a CycloneDX 1.5 JSON document
+	SBOMCycloneDX SBOMFormat = "cyclonedx"
+)
+
+// Suffix returns the extension of the document alongside the image,
+// i.e. ".spdx.json"
+func (s SBOMFormat) Suffix() string {
+	if s == SBOMCycloneDX {
+		return ".cdx.json"
+	}
+	return "." + string(s) + ".json"
+}
+
+// validateSBOM will ensure every requested format is known
+func validateSBOM(i *SectionImage) error {
+	seen := make(map[SBOMFormat]bool)
+	var formats []SBOMFormat
+	for _, f := range i.SBOM {
+		f = SBOMFormat(strings.ToLower(strings.TrimSpace(string(f))))
+		switch f {
+		case SBOMSPDX, SBOMCycloneDX:
+		default:
+			return invalidValue("image.sbom", f, string(SBOMSPDX), string(SBOMCycloneDX))
+		}
+		if !seen[f] {
+			seen[f] = true
+			formats = append(formats, f)
+		}
+	}
+	i.SBOM = formats
+	return nil
+}
@@ -0,0 +1,42 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// SectionScripts lists the shell scripts of the profile run at each phase of
+// the build, relative to the .spin file. See the hooks package.
+type SectionScripts struct {
+	PostInstall []string `toml:"post_install"` // Run within the rootfs once packages are installed
+	PreCompress []string `toml:"pre_compress"` // Run within the rootfs before it is sealed into the media
+	PostImage   []string `toml:"post_image"`   // Run on the host once the image is finished
+}
+
+// ValidateSectionScripts will ensure every script is named
+func ValidateSectionScripts(s *SectionScripts) error {
+	for _, list := range [][]string{s.PostInstall, s.PreCompress, s.PostImage} {
+		for i := range list {
+			if list[i] = strings.TrimSpace(list[i]); list[i] == "" {
+				return errors.New("scripts cannot contain empty paths")
+			}
+		}
+	}
+	return nil
+}
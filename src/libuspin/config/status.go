@@ -0,0 +1,39 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"strings"
+)
+
+// SectionStatus describes the [status] portion of a spin file. The result of
+// every build is written as "<profile>.status.json", for download pages and
+// dashboards, i.e.:
+//
+//	[status]
+//	directory = "/srv/www/status"
+//	badge = true
+type SectionStatus struct {
+	Directory string `toml:"directory"` // Where the status is written, defaults to the output directory
+	Badge     bool   `toml:"badge"`     // Also write an SVG badge as "<profile>.status.svg"
+}
+
+// ValidateSectionStatus will normalise the status configuration
+func ValidateSectionStatus(s *SectionStatus) error {
+	s.Directory = strings.TrimSpace(s.Directory)
+	return nil
+}
@@ -0,0 +1,172 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// distance returns the Levenshtein edit distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// suggest returns the option closest to value, or "" if none are close
+// enough to plausibly be what was meant.
+func suggest(value string, options []string) string {
+	best := ""
+	limit := len(value) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	for _, option := range options {
+		if d := distance(strings.ToLower(value), option); d <= limit {
+			best, limit = option, d-1
+		}
+	}
+	return best
+}
+
+// invalidValue returns an error for a value outside of the allowed set,
+// suggesting the nearest allowed value if there is one.
+func invalidValue(key string, value interface{}, allowed ...string) error {
+	msg := fmt.Sprintf("Invalid value '%v' for %v", value, key)
+	if s := suggest(fmt.Sprint(value), allowed); s != "" {
+		msg += fmt.Sprintf(", did you mean '%v'?", s)
+	}
+	return fmt.Errorf("%v Allowed values: %v", msg, strings.Join(allowed, ", "))
+}
+
+// knownKeys returns the dotted names of every key understood within the
+// given configuration type. Tables decoded into maps accept any key, and
+// are returned with a trailing ".".
+func knownKeys(t reflect.Type, prefix string) []string {
+	var ret []string
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("toml")
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		key := prefix + name
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Map:
+			ret = append(ret, key+".")
+		case reflect.Struct:
+			ret = append(ret, key)
+			ret = append(ret, knownKeys(ft, key+".")...)
+		default:
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+// unknownKeys returns a problem for every key in the file that was not
+// understood, suggesting the nearest known key for each.
+func unknownKeys(md toml.MetaData, conf interface{}) []*Problem {
+	known := knownKeys(reflect.TypeOf(conf), "")
+	var names []string
+	for _, k := range known {
+		if !strings.HasSuffix(k, ".") {
+			names = append(names, k)
+		}
+	}
+	reported := make(map[string]bool)
+	var ret []*Problem
+	for _, key := range md.Undecoded() {
+		name := key.String()
+		// Don't repeat ourselves for every key within an unknown table
+		if len(key) > 1 && reported[strings.Join(key[:len(key)-1], ".")] {
+			reported[name] = true
+			continue
+		}
+		if acceptsKey(known, name) {
+			continue
+		}
+		reported[name] = true
+		msg := fmt.Sprintf("Unknown key '%v'", name)
+		if s := suggest(name, names); s != "" {
+			msg += fmt.Sprintf(", did you mean '%v'?", s)
+		}
+		ret = append(ret, &Problem{Key: name, Message: msg})
+	}
+	return ret
+}
+
+// unknownKeysError combines the unknown keys into a single error, or nil if
+// there are none
+func unknownKeysError(unknown []*Problem) error {
+	if len(unknown) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, p := range unknown {
+		lines = append(lines, p.Message)
+	}
+	sort.Strings(lines)
+	return fmt.Errorf("Invalid .spin file:\n  %v", strings.Join(lines, "\n  "))
+}
+
+// acceptsKey returns true if the key falls within a table decoded as a map
+func acceptsKey(known []string, name string) bool {
+	for _, k := range known {
+		if strings.HasSuffix(k, ".") && strings.HasPrefix(name, k) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	if d := distance("kitten", "sitting"); d != 3 {
+		t.Fatalf("Wrong distance: %v", d)
+	}
+	options := []string{"gzip", "xz"}
+	if s := suggest("gzp", options); s != "gzip" {
+		t.Fatalf("Wrong suggestion for gzp: %v", s)
+	}
+	if s := suggest("lz4", options); s != "" {
+		t.Fatalf("Unexpected suggestion for lz4: %v", s)
+	}
+}
+
+// writeSpin will write a .spin file into dir, prefixed by the test config
+func writeSpin(t *testing.T, dir, extra string) string {
+	orig, err := ioutil.ReadFile(confTestPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	path := filepath.Join(dir, "test.spin")
+	if err := ioutil.WriteFile(path, append([]byte(extra+"\n"), orig...), 00644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-suggest")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = New(writeSpin(t, dir, "[ids]\nsed = \"a\""))
+	if err == nil || !strings.Contains(err.Error(), "did you mean 'ids.seed'?") {
+		t.Fatalf("Expected a suggestion for the unknown key: %v", err)
+	}
+
+	_, err = New(writeSpin(t, dir, "[ids]\nmachine_id = \"generat\""))
+	if err == nil || !strings.Contains(err.Error(), "did you mean 'generate'?") {
+		t.Fatalf("Expected a suggestion for the invalid value: %v", err)
+	}
+
+	// Secrets may be named freely
+	if _, err = New(writeSpin(t, dir, "[secrets]\nwifi = \"env:WIFI\"")); err != nil {
+		t.Fatalf("Rejected a secret: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A Screenshot is a capture of the framebuffer during the boot test, taken a
+// number of seconds after boot, or after the marker appears on the console.
+//
+//	[[test.screenshots]]
+//	name = "greeter"
+//	marker = "Started GNOME Display Manager"
+//	after = 10
+type Screenshot struct {
+	Name   string `toml:"name"`   // Used in the file name, i.e. Solus.iso.screenshot-greeter.png
+	After  int    `toml:"after"`  // Seconds after boot, or after the marker
+	Marker string `toml:"marker"` // Regular expression matched against the serial console
+}
+
+var screenshotName = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// ValidateScreenshot will ensure the screenshot can be taken
+func ValidateScreenshot(s *Screenshot) error {
+	if !screenshotName.MatchString(s.Name) {
+		return fmt.Errorf("Invalid screenshot name: '%v'", s.Name)
+	}
+	if s.After < 0 {
+		return fmt.Errorf("Screenshot %v cannot be taken before boot", s.Name)
+	}
+	if s.Marker == "" && s.After == 0 {
+		return fmt.Errorf("Screenshot %v needs a marker or a delay", s.Name)
+	}
+	if _, err := regexp.Compile(s.Marker); err != nil {
+		return fmt.Errorf("Invalid marker for screenshot %v: %v", s.Name, err)
+	}
+	return nil
+}
+
+// SectionTest describes the [test] portion of a spin file. When enabled, the
+// finished image is booted in QEMU with a serial console and each script is
+// run within it. Any failure fails the build before the image is delivered.
+type SectionTest struct {
+	Boot          bool     `toml:"boot"`           // Boot test the image, implied by scripts or screenshots
+	Scripts       []string `toml:"scripts"`        // Scripts run within the booted image, in order
+	QEMU          string   `toml:"qemu"`           // QEMU binary, defaults to the publish.arch emulator
+	Memory        int      `toml:"memory"`         // Memory in megabytes (default 2048)
+	CPUs          int      `toml:"cpus"`           // Virtual CPUs (default 2)
+	BootTimeout   int      `toml:"boot_timeout"`   // Seconds to reach the login prompt (default 600)
+	ScriptTimeout int      `toml:"script_timeout"` // Seconds allowed for each script (default 300)
+	User          string   `toml:"user"`           // Serial console login (default "root")
+	Password      string   `toml:"password"`       // Serial console password, if any
+
+	Screenshots []Screenshot `toml:"screenshots"` // Stored alongside the image
+}
+
+// Enabled returns true if the image should be boot tested
+func (t *SectionTest) Enabled() bool {
+	return t.Boot || len(t.Scripts) > 0 || len(t.Screenshots) > 0
+}
+
+// ValidateSectionTest will fill in the defaults for the boot test
+func ValidateSectionTest(t *SectionTest) error {
+	if t.Memory < 0 || t.CPUs < 0 || t.BootTimeout < 0 || t.ScriptTimeout < 0 {
+		return errors.New("test resources and timeouts cannot be negative")
+	}
+	if t.Memory == 0 {
+		t.Memory = 2048
+	}
+	if t.CPUs == 0 {
+		t.CPUs = 2
+	}
+	if t.BootTimeout == 0 {
+		t.BootTimeout = 600
+	}
+	if t.ScriptTimeout == 0 {
+		t.ScriptTimeout = 300
+	}
+	if t.User = strings.TrimSpace(t.User); t.User == "" {
+		t.User = "root"
+	}
+	t.QEMU = strings.TrimSpace(t.QEMU)
+	names := make(map[string]bool)
+	for i := range t.Screenshots {
+		if err := ValidateScreenshot(&t.Screenshots[i]); err != nil {
+			return err
+		}
+		if names[t.Screenshots[i].Name] {
+			return fmt.Errorf("Duplicate screenshot: %v", t.Screenshots[i].Name)
+		}
+		names[t.Screenshots[i].Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"github.com/BurntSushi/toml"
+)
+
+// TinyRootfsSize is the default size of tiny images in megabytes, in place of
+// the 4000 needed by desktop images
+const TinyRootfsSize = 256
+
+// TinyExclude are left out of tiny images, which have no use for documentation
+var TinyExclude = []string{
+	"/usr/share/doc/**",
+	"/usr/share/info/**",
+	"/usr/share/man/**",
+}
+
+// applyTiny will lighten the defaults for tiny images, such as rescue media
+// in the tens of megabytes. Anything set by the profile is left alone.
+func applyTiny(iconf *ImageConfiguration, md toml.MetaData) {
+	if !iconf.Image.Tiny {
+		return
+	}
+	if !md.IsDefined("liveos", "rootfs_size") {
+		iconf.LiveOS.RootfsSize = TinyRootfsSize
+	}
+	if !md.IsDefined("image", "compression") {
+		iconf.Image.Compression = CompressionXZ
+	}
+	if !md.IsDefined("disk", "size") {
+		iconf.Disk.Size = TinyRootfsSize
+	}
+	if !md.IsDefined("dnf", "no_docs") {
+		iconf.DNF.NoDocs = true
+	}
+	iconf.Image.Exclude = append(iconf.Image.Exclude, TinyExclude...)
+}
@@ -0,0 +1,56 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTiny(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-tiny")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	orig, err := ioutil.ReadFile(confTestPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	path := filepath.Join(dir, "tiny.spin")
+	data := strings.Replace(string(orig), "[image]\n", "[image]\ntiny = true\n", 1)
+	if err := ioutil.WriteFile(path, []byte(data), 00644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to load tiny config: %v", err)
+	}
+	if c.LiveOS.RootfsSize != TinyRootfsSize || !c.DNF.NoDocs {
+		t.Fatalf("Tiny defaults not applied: %v %v", c.LiveOS.RootfsSize, c.DNF.NoDocs)
+	}
+	if c.Image.Compression != "gzip" {
+		t.Fatalf("Profile settings should be kept: %v", c.Image.Compression)
+	}
+	if len(c.Image.Exclude) != len(TinyExclude) {
+		t.Fatalf("Documentation should be excluded: %v", c.Image.Exclude)
+	}
+}
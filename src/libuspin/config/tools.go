@@ -0,0 +1,76 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"libuspin/toolcache"
+	"sort"
+	"strings"
+)
+
+// SectionTools describes the [tools] portion of a spin file, a private
+// directory of statically linked helper tools which take priority over
+// those of the host, i.e.:
+//
+//	[tools]
+//	directory = "tools"
+//
+//	[tools.download.mksquashfs]
+//	url = "https://example.com/static/mksquashfs-4.6.1"
+//	sha256 = "..."
+type SectionTools struct {
+	Directory string                   `toml:"directory"` // Tool directory, relative to the .spin file, disabled if empty
+	Download  map[string]*ToolDownload `toml:"download"`  // Tools fetched into the directory, by name
+}
+
+// A ToolDownload is a helper tool fetched into the tool directory
+type ToolDownload struct {
+	URL    string `toml:"url"`
+	SHA256 string `toml:"sha256"`
+}
+
+// Tools returns the configured downloads, sorted by name
+func (t *SectionTools) Tools() []*toolcache.Tool {
+	var ret []*toolcache.Tool
+	for name, d := range t.Download {
+		ret = append(ret, &toolcache.Tool{Name: name, URL: d.URL, SHA256: d.SHA256})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// ValidateSectionTools will ensure every download is pinned to its digest
+func ValidateSectionTools(t *SectionTools) error {
+	t.Directory = strings.TrimSpace(t.Directory)
+	if t.Directory == "" && len(t.Download) > 0 {
+		return fmt.Errorf("tools.download requires tools.directory")
+	}
+	for name, d := range t.Download {
+		if d == nil {
+			return fmt.Errorf("tools.download.%v requires a url and sha256", name)
+		}
+		d.URL = strings.TrimSpace(d.URL)
+		d.SHA256 = strings.ToLower(strings.TrimSpace(d.SHA256))
+	}
+	for _, tool := range t.Tools() {
+		if err := tool.Validate(); err != nil {
+			return fmt.Errorf("tools.download: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"libuspin/spec"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Overrides are variables given on the command line, which take precedence
+// over the environment and the [variables] of the .spin file
+var Overrides = map[string]string{}
+
+// builtinVariables returns the variables every .spin file may reference
+func builtinVariables(iconf *ImageConfiguration, now time.Time) map[string]string {
+	arch := strings.TrimSpace(iconf.Image.Arch)
+	if arch == "" {
+		arch = HostArch()
+	}
+	return map[string]string{
+		"ARCH": arch,
+		"DATE": now.UTC().Format("20060102"),
+	}
+}
+
+// Lookup will resolve a variable referenced as "${NAME}" within the
+// configuration or its Packages file. Command line overrides win over the
+// environment, which wins over the [variables] of the .spin file, followed
+// by the builtin ARCH and DATE.
+func (i *ImageConfiguration) Lookup(name string) (string, bool) {
+	return i.lookup(name, i.Variables)
+}
+
+// lookup will resolve a variable like Lookup, using the given [variables]
+func (i *ImageConfiguration) lookup(name string, variables map[string]string) (string, bool) {
+	if value, ok := Overrides[name]; ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if value, ok := variables[name]; ok {
+		return value, true
+	}
+	value, ok := i.builtins[name]
+	return value, ok
+}
+
+// expandVariables will replace every variable reference within the decoded
+// configuration. The [variables] are expanded first, and may not refer to
+// each other.
+func expandVariables(iconf *ImageConfiguration, now time.Time) error {
+	iconf.builtins = builtinVariables(iconf, now)
+	outer := func(name string) (string, bool) { return iconf.lookup(name, nil) }
+	for name, value := range iconf.Variables {
+		if !spec.IsVariableName(name) {
+			return fmt.Errorf("Invalid variable name: '%v'", name)
+		}
+		expanded, err := spec.Expand(value, outer)
+		if err != nil {
+			return fmt.Errorf("%v in variables.%v", err, name)
+		}
+		iconf.Variables[name] = expanded
+	}
+
+	// Leave the expanded variables alone while rewriting everything else
+	variables := iconf.Variables
+	iconf.Variables = nil
+	defer func() { iconf.Variables = variables }()
+	return rewriteStrings(reflect.ValueOf(iconf), "", func(key, value string) (string, error) {
+		expanded, err := spec.Expand(value, func(name string) (string, bool) {
+			return iconf.lookup(name, variables)
+		})
+		if err != nil {
+			return "", fmt.Errorf("%v in %v", err, key)
+		}
+		return expanded, nil
+	})
+}
@@ -0,0 +1,24 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+// SectionXBPS is the configuration of the xbps package manager backend
+type SectionXBPS struct {
+	Arch    string            `toml:"arch"`    // Architecture to install, i.e. "x86_64-musl", defaults to image.arch
+	Keys    []string          `toml:"keys"`    // Trusted repository keys (.plist), relative to the .spin file
+	Virtual map[string]string `toml:"virtual"` // Provider of each virtual package, i.e. awk = "gawk"
+}
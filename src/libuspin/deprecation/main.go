@@ -0,0 +1,63 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package deprecation records uses of deprecated keys and operations, which
+// keep working but should be moved away from by profile maintainers.
+package deprecation
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+)
+
+// A Notice is a single use of a deprecated key or operation
+type Notice struct {
+	File        string `json:"file"`                  // File the deprecated usage was found in
+	Name        string `json:"name"`                  // Deprecated key or operation, i.e. "liveos.filename"
+	Replacement string `json:"replacement,omitempty"` // What should be used instead, if anything
+	Message     string `json:"message,omitempty"`     // Additional advice for the maintainer
+}
+
+func (n *Notice) String() string {
+	s := fmt.Sprintf("%v is deprecated", n.Name)
+	if n.Replacement != "" {
+		s += fmt.Sprintf(", use %v instead", n.Replacement)
+	}
+	if n.Message != "" {
+		s += " (" + n.Message + ")"
+	}
+	return s
+}
+
+// Warn will log the notice as a warning
+func (n *Notice) Warn() {
+	log.WithFields(log.Fields{
+		"file": n.File,
+	}).Warning(n)
+}
+
+// Error combines the notices into a single error, or nil if there are none
+func Error(notices []*Notice) error {
+	if len(notices) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, n := range notices {
+		lines = append(lines, fmt.Sprintf("%v: %v", n.File, n))
+	}
+	return fmt.Errorf("Profile uses %d deprecated features:\n  %v", len(notices), strings.Join(lines, "\n  "))
+}
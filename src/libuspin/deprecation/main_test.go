@@ -0,0 +1,36 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package deprecation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotice(t *testing.T) {
+	n := &Notice{File: "a.spin", Name: "liveos.filename", Replacement: "image.filename"}
+	if s := n.String(); s != "liveos.filename is deprecated, use image.filename instead" {
+		t.Fatalf("Unexpected notice: %v", s)
+	}
+	if err := Error(nil); err != nil {
+		t.Fatalf("Expected no error without notices: %v", err)
+	}
+	err := Error([]*Notice{n})
+	if err == nil || !strings.Contains(err.Error(), "a.spin: liveos.filename") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package disk
+
+import (
+	"github.com/solus-project/libosdev/disk"
+	"syscall"
+)
+
+// A MountManager tracks the mounts made during a build
+type MountManager = disk.MountManager
+
+// GetMountManager returns the shared MountManager
+func GetMountManager() *MountManager {
+	return disk.GetMountManager()
+}
+
+// CopyFile will copy the file at src to dst
+func CopyFile(src, dst string) error {
+	return disk.CopyFile(src, dst)
+}
+
+// CreateSparseFile will create a sparse file of the given size in megabytes
+func CreateSparseFile(path string, size int) error {
+	return disk.CreateSparseFile(path, size)
+}
+
+// FormatAs will create a filesystem of the given type within the file
+func FormatAs(path, format string) error {
+	return disk.FormatAs(path, format)
+}
+
+// CheckFS will check the filesystem of the given type within the file
+func CheckFS(path, format string) error {
+	return disk.CheckFS(path, format)
+}
+
+// Unmount will unmount the target, which need not be tracked by the
+// MountManager, i.e. a mount left behind by a build that died
+func Unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
@@ -0,0 +1,71 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !linux
+
+package disk
+
+// A MountManager tracks the mounts made during a build
+type MountManager struct{}
+
+// GetMountManager returns the shared MountManager
+func GetMountManager() *MountManager {
+	return &MountManager{}
+}
+
+// Mount is unsupported on this host
+func (m *MountManager) Mount(source, target, fs string, opts ...string) error {
+	return ErrUnsupportedHost
+}
+
+// BindMount is unsupported on this host
+func (m *MountManager) BindMount(source, target string, opts ...string) error {
+	return ErrUnsupportedHost
+}
+
+// Unmount is unsupported on this host
+func (m *MountManager) Unmount(target string) error {
+	return ErrUnsupportedHost
+}
+
+// UnmountAll does nothing on this host, where nothing can be mounted
+func (m *MountManager) UnmountAll() {
+}
+
+// CopyFile is unsupported on this host
+func CopyFile(src, dst string) error {
+	return ErrUnsupportedHost
+}
+
+// CreateSparseFile is unsupported on this host
+func CreateSparseFile(path string, size int) error {
+	return ErrUnsupportedHost
+}
+
+// FormatAs is unsupported on this host
+func FormatAs(path, format string) error {
+	return ErrUnsupportedHost
+}
+
+// CheckFS is unsupported on this host
+func CheckFS(path, format string) error {
+	return ErrUnsupportedHost
+}
+
+// Unmount is unsupported on this host
+func Unmount(target string) error {
+	return ErrUnsupportedHost
+}
@@ -0,0 +1,28 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package disk provides the disk image & mount handling of libosdev on Linux
+// hosts, where images are built. On other hosts every operation fails with
+// ErrUnsupportedHost, which allows the commands that never touch a disk,
+// such as "uspin plan" and "uspin validate", to run there.
+package disk
+
+import (
+	"errors"
+)
+
+// ErrUnsupportedHost is returned on hosts other than Linux
+var ErrUnsupportedHost = errors.New("Disk and mount operations require a Linux host")
@@ -0,0 +1,85 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"encoding/json"
+	"fmt"
+	"libuspin/backend"
+	"os"
+)
+
+// A DownloadLock declares every download a build is permitted to perform
+type DownloadLock struct {
+	Downloads []*backend.Download `json:"downloads"`
+}
+
+// LoadDownloadLock will load a lockfile previously stored with Write
+func LoadDownloadLock(path string) (*DownloadLock, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	l := &DownloadLock{}
+	if err := json.NewDecoder(fi).Decode(l); err != nil {
+		return nil, fmt.Errorf("Invalid download lockfile %v: %v", path, err)
+	}
+	return l, nil
+}
+
+// Write will store the lockfile at the given path
+func (l *DownloadLock) Write(path string) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(l); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Verify will return a description of every download that was not declared
+// in the lockfile, or that doesn't match the declared size and digest.
+// Declarations without a digest, i.e. repository indexes, only pin the URL.
+func (l *DownloadLock) Verify(downloads []*backend.Download) []string {
+	declared := make(map[string]*backend.Download)
+	for _, d := range l.Downloads {
+		declared[d.URL] = d
+	}
+	var ret []string
+	for _, d := range downloads {
+		want, ok := declared[d.URL]
+		switch {
+		case !ok:
+			ret = append(ret, fmt.Sprintf("Undeclared download: %v", d.URL))
+		case want.SHA256 != "" && (want.SHA256 != d.SHA256 || want.Size != d.Size):
+			ret = append(ret, fmt.Sprintf("Mismatched download: %v (%v, %v bytes), expected %v (%v bytes)", d.URL, d.SHA256, d.Size, want.SHA256, want.Size))
+		}
+	}
+	return ret
+}
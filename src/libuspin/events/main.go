@@ -0,0 +1,182 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package events emits the progress of a build as machine-readable JSON
+// lines, written to a file or socket alongside the human output, so that
+// build farms may follow a build without scraping its logs.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Kind is the type of an Event
+type Kind string
+
+const (
+	// KindBuildStart is emitted when a build starts
+	KindBuildStart Kind = "build-start"
+
+	// KindBuildEnd is emitted when a build completes, successfully or not
+	KindBuildEnd Kind = "build-end"
+
+	// KindStageStart is emitted when a stage starts
+	KindStageStart Kind = "stage-start"
+
+	// KindStageEnd is emitted when a stage completes, successfully or not
+	KindStageEnd Kind = "stage-end"
+
+	// KindTransaction is emitted after each package manager transaction
+	KindTransaction Kind = "transaction"
+
+	// KindPackages is emitted once the packages are installed, with counts
+	// of what was installed and downloaded
+	KindPackages Kind = "packages"
+
+	// KindLog is emitted for every warning or error logged
+	KindLog Kind = "log"
+)
+
+// An Event is a single line of output
+type Event struct {
+	Time     time.Time              `json:"time"`
+	Kind     Kind                   `json:"kind"`
+	Stage    string                 `json:"stage,omitempty"`
+	Level    string                 `json:"level,omitempty"`
+	Message  string                 `json:"message,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Duration float64                `json:"duration,omitempty"` // Seconds, for the end of builds and stages
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// An Emitter writes events as JSON lines. It is also a logging hook, so that
+// warnings and errors are emitted as KindLog events.
+type Emitter struct {
+	w     io.WriteCloser
+	enc   *json.Encoder
+	stage string    // Current stage, added to every event
+	start time.Time // Start of the current stage
+	mu    sync.Mutex
+}
+
+// Open will return an Emitter writing to the target. Targets of the form
+// "unix:/path" and "tcp:host:port" are sockets to connect to, anything else
+// is a file which is created or truncated.
+func Open(target string) (*Emitter, error) {
+	var w io.WriteCloser
+	var err error
+	switch {
+	case strings.HasPrefix(target, "unix:"):
+		w, err = net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+	case strings.HasPrefix(target, "tcp:"):
+		w, err = net.Dial("tcp", strings.TrimPrefix(target, "tcp:"))
+	default:
+		w, err = os.Create(target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open event output %v: %v", target, err)
+	}
+	return New(w), nil
+}
+
+// New will return an Emitter writing to w
+func New(w io.WriteCloser) *Emitter {
+	return &Emitter{w: w, enc: json.NewEncoder(w)}
+}
+
+// Emit will write the event, filling in the time and current stage
+func (e *Emitter) Emit(ev *Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.emit(ev)
+}
+
+// emit will write the event with the lock held
+func (e *Emitter) emit(ev *Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if ev.Stage == "" {
+		ev.Stage = e.stage
+	}
+	return e.enc.Encode(ev)
+}
+
+// StartStage will emit the start of the stage, which is then attributed to
+// every event until EndStage
+func (e *Emitter) StartStage(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stage = name
+	e.start = time.Now()
+	return e.emit(&Event{Kind: KindStageStart})
+}
+
+// EndStage will emit the end of the current stage, along with its error
+func (e *Emitter) EndStage(failure error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev := &Event{
+		Kind:     KindStageEnd,
+		Duration: time.Since(e.start).Seconds(),
+	}
+	if failure != nil {
+		ev.Error = failure.Error()
+	}
+	err := e.emit(ev)
+	e.stage = ""
+	return err
+}
+
+// Levels returns the levels emitted as KindLog events
+func (e *Emitter) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel, log.WarnLevel}
+}
+
+// Fire will emit the logged entry
+func (e *Emitter) Fire(entry *log.Entry) error {
+	ev := &Event{
+		Kind:    KindLog,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if len(entry.Data) > 0 {
+		ev.Fields = make(map[string]interface{}, len(entry.Data))
+		for k, v := range entry.Data {
+			// Errors would otherwise encode as an empty object
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			ev.Fields[k] = v
+		}
+	}
+	return e.Emit(ev)
+}
+
+// Close will close the underlying file or socket
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.w.Close()
+}
@@ -0,0 +1,84 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmitter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-events")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	e, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open events: %v", err)
+	}
+	e.Emit(&Event{Kind: KindBuildStart})
+	e.StartStage("rootfs")
+	e.Fire(&log.Entry{
+		Level:   log.WarnLevel,
+		Message: "Slow mirror",
+		Data:    log.Fields{"error": errors.New("timeout")},
+	})
+	e.EndStage(errors.New("failed"))
+	e.Emit(&Event{Kind: KindBuildEnd})
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close events: %v", err)
+	}
+
+	fi, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+	defer fi.Close()
+	var got []*Event
+	sc := bufio.NewScanner(fi)
+	for sc.Scan() {
+		ev := &Event{}
+		if err := json.Unmarshal(sc.Bytes(), ev); err != nil {
+			t.Fatalf("Invalid event line %q: %v", sc.Text(), err)
+		}
+		got = append(got, ev)
+	}
+	kinds := []Kind{KindBuildStart, KindStageStart, KindLog, KindStageEnd, KindBuildEnd}
+	if len(got) != len(kinds) {
+		t.Fatalf("Incorrect number of events: %v", len(got))
+	}
+	for i, kind := range kinds {
+		if got[i].Kind != kind {
+			t.Fatalf("Event %v is %v, expected %v", i, got[i].Kind, kind)
+		}
+	}
+	if got[2].Stage != "rootfs" || got[2].Fields["error"] != "timeout" {
+		t.Fatalf("Log event should belong to the stage with its fields: %v %v", got[2].Stage, got[2].Fields)
+	}
+	if got[3].Error != "failed" || got[4].Stage != "" {
+		t.Fatalf("Stage should end with its error: %v %v", got[3].Error, got[4].Stage)
+	}
+}
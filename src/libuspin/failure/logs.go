@@ -0,0 +1,85 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package failure
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// A LogRecorder is a logging hook keeping the most recent lines logged
+// within each stage of the build.
+type LogRecorder struct {
+	max    int
+	stage  string
+	stages []string // In the order they were entered
+	lines  map[string][]string
+	mu     sync.Mutex
+}
+
+// NewLogRecorder will return a LogRecorder keeping up to max lines per stage
+func NewLogRecorder(max int) *LogRecorder {
+	return &LogRecorder{
+		max:   max,
+		stage: "setup",
+		lines: make(map[string][]string),
+	}
+}
+
+// SetStage will attribute all following lines to the named stage
+func (r *LogRecorder) SetStage(name string) {
+	r.mu.Lock()
+	r.stage = name
+	r.mu.Unlock()
+}
+
+// Levels returns all levels, as the debug output is the most useful
+func (r *LogRecorder) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire will record the formatted entry
+func (r *LogRecorder) Fire(e *log.Entry) error {
+	line, err := e.String()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines, ok := r.lines[r.stage]
+	if !ok {
+		r.stages = append(r.stages, r.stage)
+	}
+	lines = append(lines, strings.TrimRight(line, "\n"))
+	if len(lines) > r.max {
+		lines = lines[len(lines)-r.max:]
+	}
+	r.lines[r.stage] = lines
+	return nil
+}
+
+// AddTo will store the recorded lines of each stage within the bundle
+func (r *LogRecorder) AddTo(b *Bundle) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, stage := range r.stages {
+		name := fmt.Sprintf("logs/%02d-%v.log", i, stage)
+		b.Add(name, []byte(strings.Join(r.lines[stage], "\n")+"\n"))
+	}
+}
@@ -0,0 +1,147 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package failure collects diagnostics about a failed build into a single
+// archive, suitable for attaching to bug reports.
+package failure
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Bundle is the set of files making up the diagnostics
+type Bundle struct {
+	names []string
+	files map[string][]byte
+}
+
+// New will return a new, empty Bundle
+func New() *Bundle {
+	return &Bundle{files: make(map[string][]byte)}
+}
+
+// Add will store data within the bundle under the given name
+func (b *Bundle) Add(name string, data []byte) {
+	if _, ok := b.files[name]; !ok {
+		b.names = append(b.names, name)
+	}
+	b.files[name] = data
+}
+
+// AddFile will store a copy of the file at path within the bundle. Missing
+// files are noted rather than failing, as the build may not have got far
+// enough to create them.
+func (b *Bundle) AddFile(name, path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		data = []byte(fmt.Sprintf("Unavailable: %v\n", err))
+	}
+	b.Add(name, data)
+}
+
+// sensitiveVar matches environment variables which are likely to be secret
+var sensitiveVar = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSW|KEY|CREDENTIAL|AUTH)`)
+
+// AddEnvironment will store the environment, with the values of any
+// variables that look sensitive removed.
+func (b *Bundle) AddEnvironment(env []string) {
+	var lines []string
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && sensitiveVar.MatchString(parts[0]) {
+			kv = parts[0] + "=<redacted>"
+		}
+		lines = append(lines, kv)
+	}
+	sort.Strings(lines)
+	b.Add("environment.txt", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// AddHostInfo will store a description of the build host
+func (b *Bundle) AddHostInfo(version string) {
+	host, _ := os.Hostname()
+	info := fmt.Sprintf("uspin: %v\nhost: %v\nos: %v\narch: %v\ngo: %v\ncpus: %v\ntime: %v\n",
+		version, host, runtime.GOOS, runtime.GOARCH, runtime.Version(), runtime.NumCPU(),
+		time.Now().UTC().Format(time.RFC3339))
+	for _, path := range []string{"/proc/version", "/etc/os-release"} {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			info += fmt.Sprintf("\n# %v\n%s", path, data)
+		}
+	}
+	if data, err := ioutil.ReadFile("/proc/meminfo"); err == nil {
+		lines := strings.SplitN(string(data), "\n", 4)
+		if len(lines) > 3 {
+			lines = lines[:3]
+		}
+		info += "\n# /proc/meminfo\n" + strings.Join(lines, "\n") + "\n"
+	}
+	b.Add("host.txt", []byte(info))
+}
+
+// Redact will pass the contents of every file through fn, i.e. to remove
+// secrets from the bundle.
+func (b *Bundle) Redact(fn func(string) string) {
+	for name, data := range b.files {
+		b.files[name] = []byte(fn(string(data)))
+	}
+}
+
+// Write will store the bundle as a gzip compressed tarball at path. Every
+// file is placed within a directory named after the archive.
+func (b *Bundle) Write(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	prefix := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	now := time.Now()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, name := range b.names {
+		data := b.files[name]
+		hdr := &tar.Header{
+			Name:    prefix + "/" + name,
+			Mode:    00644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
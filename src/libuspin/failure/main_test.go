@@ -0,0 +1,95 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package failure
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-failure")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := New()
+	b.Add("error.txt", []byte("Build failed: hunter2\n"))
+	b.AddFile("plan.json", filepath.Join(dir, "missing.json"))
+	b.AddEnvironment([]string{"HOME=/root", "GITHUB_TOKEN=abc"})
+	b.Redact(func(s string) string {
+		return strings.Replace(s, "hunter2", "<redacted>", -1)
+	})
+
+	path := filepath.Join(dir, "uspin-failure.tar.gz")
+	if err := b.Write(path); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open bundle: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Invalid gzip: %v", err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+	if files["uspin-failure/error.txt"] != "Build failed: <redacted>\n" {
+		t.Fatalf("Secret not redacted: %q", files["uspin-failure/error.txt"])
+	}
+	if !strings.HasPrefix(files["uspin-failure/plan.json"], "Unavailable") {
+		t.Fatalf("Missing file not noted: %q", files["uspin-failure/plan.json"])
+	}
+	if files["uspin-failure/environment.txt"] != "GITHUB_TOKEN=<redacted>\nHOME=/root\n" {
+		t.Fatalf("Sensitive environment not redacted: %q", files["uspin-failure/environment.txt"])
+	}
+}
+
+func TestLogRecorder(t *testing.T) {
+	r := NewLogRecorder(2)
+	for _, msg := range []string{"one", "two", "three"} {
+		r.Fire(&log.Entry{Message: msg, Logger: log.StandardLogger()})
+	}
+	r.SetStage("rootfs")
+	r.Fire(&log.Entry{Message: "four", Logger: log.StandardLogger()})
+
+	b := New()
+	r.AddTo(b)
+	if got := string(b.files["logs/00-setup.log"]); !strings.Contains(got, "two") || strings.Contains(got, "one") {
+		t.Fatalf("Wrong setup lines: %q", got)
+	}
+	if got := string(b.files["logs/01-rootfs.log"]); !strings.Contains(got, "four") {
+		t.Fatalf("Wrong rootfs lines: %q", got)
+	}
+}
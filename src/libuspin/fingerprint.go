@@ -0,0 +1,315 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"libuspin/backend"
+	"libuspin/overlay"
+	"libuspin/spec"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RepoFingerprint will return an identifier for the current state of the
+// repository index at the given URI, without downloading it. The identifier
+// changes whenever the index is updated.
+func RepoFingerprint(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		resp, err := http.Head(uri)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Cannot query repository %v: %v", uri, resp.Status)
+		}
+		return strings.Join([]string{
+			resp.Header.Get("ETag"),
+			resp.Header.Get("Last-Modified"),
+			resp.Header.Get("Content-Length"),
+		}, " "), nil
+	case "", "file":
+		st, err := os.Stat(u.Path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v %v", st.ModTime().UnixNano(), st.Size()), nil
+	default:
+		return "", fmt.Errorf("Cannot fingerprint repository scheme: %v", u.Scheme)
+	}
+}
+
+// hashFile will write the contents of the file into the hash
+func hashFile(w io.Writer, path string) error {
+	fi, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	_, err = io.Copy(w, fi)
+	return err
+}
+
+// Fingerprint will return an identifier for every input of the build: the
+// profile itself (every local file it reads, and the git revision of the
+// profile directory, if any) and the state of every repository it uses. If
+// the fingerprint is unchanged, a rebuild would produce the same image.
+func (i *ImageSpec) Fingerprint() (string, error) {
+	h := sha256.New()
+	for _, path := range i.Inputs() {
+		if err := hashInput(h, path); err != nil {
+			return "", err
+		}
+	}
+
+	if err := i.hashSpec(h); err != nil {
+		return "", err
+	}
+
+	// Uncommitted changes are already covered by the hashed inputs above
+	if rev, err := i.GitRevision(); err == nil {
+		fmt.Fprintf(h, "git %s\n", rev)
+	}
+
+	repoState, err := i.RepoState()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "repos %v", repoState)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GitRevision will return the commit of the git repository containing the
+// .spin file, if it is within one.
+func (i *ImageSpec) GitRevision() (string, error) {
+	rev, err := exec.Command("git", "-C", filepath.Dir(i.SpinFile), "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(rev)), nil
+}
+
+// RepoState will return an identifier for the state of all repositories used
+// by the ImageSpec, which changes whenever any of them is updated.
+func (i *ImageSpec) RepoState() (string, error) {
+	h := sha256.New()
+	for _, repo := range i.Repos() {
+		fp, err := RepoFingerprint(repo.RepoURI)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "repo %v %v %v\n", repo.RepoName, repo.RepoURI, fp)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PackageState will return an identifier for the versions of every package
+// the ImageSpec would install, including dependencies. Unlike RepoState, it
+// only changes when a repository update is relevant to this image.
+func (i *ImageSpec) PackageState(query backend.Query) (string, error) {
+	versions, ok := query.(backend.VersionReporter)
+	if !ok {
+		return "", backend.ErrUnsupportedQuery
+	}
+	expander, ok := query.(backend.GroupExpander)
+	if !ok {
+		return "", backend.ErrUnsupportedQuery
+	}
+	resolver, ok := query.(backend.DependencyResolver)
+	if !ok {
+		return "", backend.ErrUnsupportedQuery
+	}
+
+	plan := NewPlan(i)
+	if err := plan.ExpandGroups(expander); err != nil {
+		return "", err
+	}
+	if err := plan.ResolveDependencies(resolver); err != nil {
+		return "", err
+	}
+
+	if err := plan.ResolveVersions(versions); err != nil {
+		return "", err
+	}
+
+	var names []string
+	for name := range plan.Versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%v %v\n", name, plan.Versions[name])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// RootfsKey will return an identifier for the inputs of installing packages
+// into the rootfs: the Packages file and everything it includes, the package
+// manager configuration and the state of the repositories. Changes elsewhere
+// in the profile, i.e. to the media, leave it unchanged.
+func (i *ImageSpec) RootfsKey() (string, error) {
+	h := sha256.New()
+	for _, path := range i.SpecFiles {
+		fmt.Fprintf(h, "spec %v\n", path)
+		if err := hashFile(h, path); err != nil {
+			return "", err
+		}
+	}
+	hashStack(h, i.Stack)
+	conf := i.Config
+	inputs, err := json.Marshal([]interface{}{
+		i.Arch, i.Profile, conf.Image.PackageManager, conf.Image.Seed, conf.Image.ExcludeClasses,
+		conf.DNF, conf.APK, conf.APT, conf.Zypper, conf.XBPS, conf.Safety,
+	})
+	if err != nil {
+		return "", err
+	}
+	h.Write(inputs)
+	// Local seeds may be replaced in place, remote tags are trusted
+	if conf.Image.Seed != "" {
+		if st, err := os.Stat(i.JoinPath(conf.Image.Seed)); err == nil {
+			fmt.Fprintf(h, "seed %v %v\n", st.ModTime().UnixNano(), st.Size())
+		}
+	}
+	repoState, err := i.RepoState()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "repos %v", repoState)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ConfigureKey will return an identifier for the inputs of configuring the
+// rootfs on top of the installed packages: the .spin file itself, the
+// overlay, the post-install scripts and any local kernel.
+func (i *ImageSpec) ConfigureKey(rootfsKey string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "rootfs %v\n", rootfsKey)
+	if err := hashFile(h, i.SpinFile); err != nil {
+		return "", err
+	}
+	if err := i.hashSpec(h); err != nil {
+		return "", err
+	}
+	if i.Config.Image.Overlay != "" {
+		dir := i.JoinPath(i.Config.Image.Overlay)
+		if err := hashTree(h, dir); err != nil {
+			return "", err
+		}
+		meta := dir + overlay.MetaSuffix
+		if i.Config.Image.OverlayMeta != "" {
+			meta = i.JoinPath(i.Config.Image.OverlayMeta)
+		}
+		if err := hashFile(h, meta); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	for _, script := range i.Config.Scripts.PostInstall {
+		if err := hashFile(h, i.JoinPath(script)); err != nil {
+			return "", err
+		}
+	}
+	// The kernel source may be a directory or a tarball, hashTree handles both
+	kernel := i.Config.Kernel
+	for _, path := range append([]string{kernel.Source, kernel.Config, kernel.Prebuilt}, kernel.Patches...) {
+		if path == "" {
+			continue
+		}
+		fmt.Fprintf(h, "kernel %v\n", path)
+		if err := hashTree(h, i.JoinPath(path)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashStack will write every resolved operation into the hash. Variables
+// within the Packages files have already been substituted, so changing one
+// changes the hash even though the files are unchanged.
+func hashStack(w io.Writer, stack *spec.OpStack) {
+	for _, block := range stack.Blocks {
+		for _, op := range block.Ops {
+			fmt.Fprintf(w, "%T %+v\n", op, op)
+		}
+	}
+}
+
+// hashSpec will write the resolved operations and configuration into the
+// hash, i.e. after -var, environment and ${DATE} substitution
+func (i *ImageSpec) hashSpec(w io.Writer) error {
+	hashStack(w, i.Stack)
+	conf, err := json.Marshal(i.Config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "config %s\n", conf)
+	return nil
+}
+
+// hashInput will write the path and contents of the input into the hash,
+// which may be a file or a directory, or not exist at all
+func hashInput(w io.Writer, path string) error {
+	fmt.Fprintf(w, "input %v\n", path)
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		fmt.Fprintf(w, "missing\n")
+		return nil
+	}
+	return hashTree(w, path)
+}
+
+// hashTree will write the path, mode and contents of everything beneath dir
+// into the hash
+func hashTree(w io.Writer, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%v %v\n", rel, info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%v\n", link)
+		case info.Mode().IsRegular():
+			return hashFile(w, path)
+		}
+		return nil
+	})
+}
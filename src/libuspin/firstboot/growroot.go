@@ -0,0 +1,86 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package firstboot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// GrowRootScript is where the grow script is installed within the image
+const GrowRootScript = "/usr/lib/uspin/grow-root"
+
+// growRootTools must be present within the image to grow the root filesystem
+var growRootTools = map[string]string{
+	"/usr/bin/growpart": "cloud-utils",
+	"/usr/bin/lsblk":    "util-linux",
+	"/usr/bin/findmnt":  "util-linux",
+}
+
+// growRoot grows the partition holding / to the end of its disk, and then
+// the filesystem itself. growpart exits 1 when there is no room to grow.
+const growRoot = `#!/bin/sh
+# Generated by USpin
+set -e
+dev="$(findmnt -n -o SOURCE /)"
+fstype="$(findmnt -n -o FSTYPE /)"
+disk="/dev/$(lsblk -n -o PKNAME "$dev")"
+part="$(cat "/sys/class/block/$(basename "$dev")/partition")"
+
+growpart "$disk" "$part" || [ $? -eq 1 ]
+
+case "$fstype" in
+	ext2|ext3|ext4)
+		resize2fs "$dev"
+		;;
+	xfs)
+		xfs_growfs /
+		;;
+	btrfs)
+		btrfs filesystem resize max /
+		;;
+	*)
+		echo "Cannot grow $fstype filesystem on $dev" >&2
+		exit 1
+		;;
+esac
+`
+
+// GrowRootTask will install the grow script into the root, returning the
+// firstboot task which runs it.
+func GrowRootTask(root string) (*Task, error) {
+	for tool, provider := range growRootTools {
+		if _, err := os.Stat(filepath.Join(root, tool)); err != nil {
+			return nil, fmt.Errorf("grow_root requires %v, add %v to the packages", tool, provider)
+		}
+	}
+	script := filepath.Join(root, GrowRootScript)
+	if err := os.MkdirAll(filepath.Dir(script), 00755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(script, []byte(growRoot), 00755); err != nil {
+		return nil, err
+	}
+	return &Task{
+		Name:        "grow-root",
+		Description: "Grow the root filesystem to fit the disk",
+		Command:     GrowRootScript,
+		After:       []string{"systemd-remount-fs.service"},
+	}, nil
+}
@@ -0,0 +1,125 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package firstboot generates oneshot services for tasks deferred until the
+// first boot of a deployed image, such as generating SSH host keys.
+//
+// Each task runs at most once: it is guarded by a stamp file which is created
+// on success, and the service disables itself afterwards where the init
+// system allows it.
+package firstboot
+
+import (
+	"fmt"
+	"libuspin/initsys"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// UnitDir is where the generated units are installed within systemd images
+	UnitDir = initsys.SystemdUnitDir
+
+	// WantsDir is where the units are enabled within systemd images
+	WantsDir = initsys.SystemdWantsDir
+
+	// StampDir holds the stamp files of completed tasks within the image
+	StampDir = "/var/lib/uspin/firstboot"
+
+	// UnitPrefix is prepended to every generated unit name
+	UnitPrefix = "uspin-firstboot-"
+)
+
+var validName = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// A Task is a command to be run on the first boot of the image
+type Task struct {
+	Name        string   `toml:"name"`        // Unique name, used in the unit name
+	Description string   `toml:"description"` // Optional unit description
+	Command     string   `toml:"command"`     // Shell command to run
+	After       []string `toml:"after"`       // Units this task is ordered after
+	Before      []string `toml:"before"`      // Units this task is ordered before
+	Conditions  []string `toml:"conditions"`  // Paths which must exist, or not exist with a "!" prefix
+}
+
+// Validate will ensure the task is usable
+func (t *Task) Validate() error {
+	if !validName.MatchString(t.Name) {
+		return fmt.Errorf("Invalid firstboot task name: '%v'", t.Name)
+	}
+	if strings.TrimSpace(t.Command) == "" {
+		return fmt.Errorf("Firstboot task %v has no command", t.Name)
+	}
+	for _, cond := range t.Conditions {
+		if !strings.HasPrefix(strings.TrimPrefix(cond, "!"), "/") {
+			return fmt.Errorf("Firstboot task %v condition must be absolute: %v", t.Name, cond)
+		}
+	}
+	return nil
+}
+
+// UnitName returns the name of the unit generated for this task
+func (t *Task) UnitName() string {
+	return UnitPrefix + t.Name + ".service"
+}
+
+// StampPath returns the stamp file marking this task as complete
+func (t *Task) StampPath() string {
+	return filepath.Join(StampDir, t.Name)
+}
+
+// Oneshot returns the oneshot service running this task
+func (t *Task) Oneshot() *initsys.Oneshot {
+	desc := t.Description
+	if desc == "" {
+		desc = "First boot task: " + t.Name
+	}
+	return &initsys.Oneshot{
+		Name:        UnitPrefix + t.Name,
+		Description: desc,
+		Command:     t.Command,
+		After:       t.After,
+		Before:      t.Before,
+		Conditions:  t.Conditions,
+		Stamp:       t.StampPath(),
+	}
+}
+
+// Unit will generate the systemd unit for this task
+func (t *Task) Unit() []byte {
+	return initsys.SystemdUnit(t.Oneshot())
+}
+
+// Install will install and enable the services for each task within the
+// root, using the init system of the image
+func Install(root string, tasks []*Task, sys initsys.System) error {
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		if err := task.Validate(); err != nil {
+			return err
+		}
+		if seen[task.Name] {
+			return fmt.Errorf("Duplicate firstboot task: %v", task.Name)
+		}
+		seen[task.Name] = true
+
+		if err := sys.InstallOneshot(root, task.Oneshot()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
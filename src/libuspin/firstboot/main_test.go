@@ -0,0 +1,108 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package firstboot
+
+import (
+	"io/ioutil"
+	"libuspin/initsys"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnit(t *testing.T) {
+	task := &Task{
+		Name:       "ssh-keys",
+		Command:    "ssh-keygen -A && echo \"$HOME\"",
+		Before:     []string{"sshd.service"},
+		Conditions: []string{"!/etc/ssh/ssh_host_rsa_key"},
+	}
+	if err := task.Validate(); err != nil {
+		t.Fatalf("Failed to validate task: %v", err)
+	}
+	unit := string(task.Unit())
+	for _, want := range []string{
+		"After=local-fs.target\n",
+		"Before=sshd.service\n",
+		"ConditionPathExists=!/var/lib/uspin/firstboot/ssh-keys\n",
+		"ConditionPathExists=!/etc/ssh/ssh_host_rsa_key\n",
+		"ExecStart=/bin/sh -c \"ssh-keygen -A && echo \\\"$$HOME\\\"\"\n",
+		"ExecStartPost=/usr/bin/systemctl disable uspin-firstboot-ssh-keys.service\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Fatalf("Unit is missing %q:\n%v", want, unit)
+		}
+	}
+	if err := (&Task{Name: "bad name", Command: "true"}).Validate(); err == nil {
+		t.Fatalf("Invalid task names should be rejected")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-firstboot")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	sys, _ := initsys.New(initsys.TypeSystemd)
+	tasks := []*Task{{Name: "hello", Command: "echo hello"}}
+	if err := Install(root, tasks, sys); err != nil {
+		t.Fatalf("Failed to install tasks: %v", err)
+	}
+	// Installing again must replace the existing units
+	if err := Install(root, tasks, sys); err != nil {
+		t.Fatalf("Failed to reinstall tasks: %v", err)
+	}
+	link := filepath.Join(root, WantsDir, tasks[0].UnitName())
+	target, err := os.Readlink(link)
+	if err != nil || target != filepath.Join(UnitDir, tasks[0].UnitName()) {
+		t.Fatalf("Unit not enabled: %v %v", target, err)
+	}
+	if err := Install(root, append(tasks, tasks[0]), sys); err == nil {
+		t.Fatalf("Duplicate tasks should be rejected")
+	}
+}
+
+func TestGrowRootTask(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-firstboot")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	if _, err := GrowRootTask(root); err == nil {
+		t.Fatalf("Missing grow tools should be reported")
+	}
+	os.MkdirAll(filepath.Join(root, "usr", "bin"), 00755)
+	for tool := range growRootTools {
+		ioutil.WriteFile(filepath.Join(root, tool), nil, 00755)
+	}
+	task, err := GrowRootTask(root)
+	if err != nil {
+		t.Fatalf("Failed to create grow task: %v", err)
+	}
+	sys, _ := initsys.New(initsys.TypeOpenRC)
+	if err := Install(root, []*Task{task}, sys); err != nil {
+		t.Fatalf("Failed to install grow task: %v", err)
+	}
+	st, err := os.Stat(filepath.Join(root, GrowRootScript))
+	if err != nil || st.Mode().Perm() != 00755 {
+		t.Fatalf("Grow script not installed: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// GraphFormat is the output format of a dependency graph
+type GraphFormat string
+
+const (
+	// GraphFormatDot emits a graphviz digraph
+	GraphFormatDot GraphFormat = "dot"
+
+	// GraphFormatJSON emits the adjacency lists as JSON
+	GraphFormatJSON GraphFormat = "json"
+)
+
+// graphJSON is the serialised form of the dependency graph
+type graphJSON struct {
+	Packages     []string            `json:"packages"` // Explicitly requested packages
+	Groups       map[string][]string `json:"groups,omitempty"`
+	Dependencies map[string][]string `json:"dependencies"`
+}
+
+// WriteGraph will emit the dependency graph of the plan in the given format.
+// ResolveDependencies must have been called on the plan first.
+func (p *Plan) WriteGraph(w io.Writer, format GraphFormat) error {
+	switch format {
+	case GraphFormatDot:
+		return p.writeGraphDot(w)
+	case GraphFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(&graphJSON{
+			Packages:     p.Packages(),
+			Groups:       p.Expansions,
+			Dependencies: p.Dependencies,
+		})
+	default:
+		return fmt.Errorf("Unknown graph format: %v", format)
+	}
+}
+
+// writeGraphDot emits a graphviz digraph, with groups and explicitly requested
+// packages highlighted so that the origin of each package is obvious.
+func (p *Plan) writeGraphDot(w io.Writer) error {
+	fmt.Fprintf(w, "digraph \"%v\" {\n", p.ImageType)
+	fmt.Fprintf(w, "    node [shape=box];\n")
+
+	var groups []string
+	for group := range p.Expansions {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		fmt.Fprintf(w, "    \"@%v\" [shape=folder];\n", group)
+		for _, name := range p.Expansions[group] {
+			fmt.Fprintf(w, "    \"@%v\" -> \"%v\";\n", group, name)
+		}
+	}
+
+	for _, name := range p.Packages() {
+		fmt.Fprintf(w, "    \"%v\" [style=bold];\n", name)
+	}
+
+	var names []string
+	for name := range p.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, dep := range p.Dependencies[name] {
+			fmt.Fprintf(w, "    \"%v\" -> \"%v\";\n", name, dep)
+		}
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
@@ -0,0 +1,146 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package hooks runs the shell scripts of the profile at defined phases of
+// the build, such as creating users once packages are installed.
+//
+// Scripts of the rootfs phases run within the chroot, with the directory of
+// each script bind mounted read-only at ScriptDir. Scripts of the post-image
+// phase run on the host, from the directory of the .spin file.
+package hooks
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/chroot"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Phase is a point in the build at which scripts are run
+type Phase string
+
+const (
+	// PhasePostInstall runs within the rootfs once packages are installed
+	PhasePostInstall Phase = "post-install"
+
+	// PhasePreCompress runs within the rootfs before it is sealed into the media
+	PhasePreCompress Phase = "pre-compress"
+
+	// PhasePostImage runs on the host once the image is finished
+	PhasePostImage Phase = "post-image"
+)
+
+// ScriptDir is where the directory of each script is mounted within the rootfs
+const ScriptDir = "/run/uspin/scripts"
+
+// InChroot returns true if the scripts of the phase run within the rootfs
+func (p Phase) InChroot() bool {
+	return p != PhasePostImage
+}
+
+// A Runner runs scripts against the rootfs of a build
+type Runner struct {
+	Chroot  *chroot.Chroot // The rootfs, along with the binds and environment of the build
+	BaseDir string         // Relative scripts are found here
+	Env     []string       // Additional environment, i.e. USPIN_IMAGE
+}
+
+// resolve will return the absolute paths of the scripts, ensuring they exist
+// before anything is run
+func (r *Runner) resolve(scripts []string) ([]string, error) {
+	var ret []string
+	for _, script := range scripts {
+		if !filepath.IsAbs(script) {
+			script = filepath.Join(r.BaseDir, script)
+		}
+		if st, err := os.Stat(script); err != nil || st.IsDir() {
+			return nil, fmt.Errorf("Script not found: %v", script)
+		}
+		ret = append(ret, script)
+	}
+	return ret, nil
+}
+
+// Run will run each script of the phase in order, stopping at the first to fail
+func (r *Runner) Run(phase Phase, scripts []string) error {
+	paths, err := r.resolve(scripts)
+	if err != nil {
+		return err
+	}
+	env := append([]string{"USPIN_PHASE=" + string(phase)}, r.Env...)
+	for _, script := range paths {
+		log.WithFields(log.Fields{
+			"phase":  phase,
+			"script": script,
+		}).Info("Running script")
+		if phase.InChroot() {
+			err = r.runChroot(script, env)
+		} else {
+			err = r.runHost(script, env)
+		}
+		if err != nil {
+			return fmt.Errorf("%v script %v failed: %v", phase, filepath.Base(script), err)
+		}
+	}
+	return nil
+}
+
+// runChroot will run the script within the rootfs
+func (r *Runner) runChroot(script string, env []string) error {
+	binds := append([]*chroot.Bind(nil), r.Chroot.Binds...)
+	binds = append(binds, &chroot.Bind{
+		Source:   filepath.Dir(script),
+		Target:   ScriptDir,
+		ReadOnly: true,
+	})
+	c := chroot.New(r.Chroot.Root, binds, append(append([]string(nil), r.Chroot.Env...), env...))
+	c.Stdout, c.Stderr = r.Chroot.Stdout, r.Chroot.Stderr
+	if err := c.Enter(); err != nil {
+		return err
+	}
+	err := c.Run("/bin/sh " + shellQuote(filepath.Join(ScriptDir, filepath.Base(script))))
+	if lerr := c.Leave(); err == nil {
+		err = lerr
+	}
+	// Only remove the mountpoints, never anything the script left behind
+	for dir := ScriptDir; dir != "/run"; dir = filepath.Dir(dir) {
+		os.Remove(filepath.Join(r.Chroot.Root, dir))
+	}
+	return err
+}
+
+// runHost will run the script on the host, from the base directory
+func (r *Runner) runHost(script string, env []string) error {
+	cmd := exec.Command("/bin/sh", script)
+	cmd.Dir = r.BaseDir
+	cmd.Env = append(append(os.Environ(), r.Chroot.Env...), env...)
+	cmd.Env = append(cmd.Env, "USPIN_ROOT="+r.Chroot.Root)
+	cmd.Stdout = r.Chroot.Stdout
+	cmd.Stderr = r.Chroot.Stderr
+	err := cmd.Run()
+	if ferr := r.Chroot.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// shellQuote will quote the string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
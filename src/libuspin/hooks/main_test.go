@@ -0,0 +1,76 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package hooks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"libuspin/chroot"
+	"libuspin/secrets"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-hooks")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "echo \"$USPIN_PHASE $USPIN_IMAGE $SECRET\" > out\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "post.sh"), []byte(script), 00644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	r := &Runner{
+		Chroot:  chroot.New(filepath.Join(dir, "rootfs"), nil, []string{"SECRET=hunter2"}),
+		BaseDir: dir,
+		Env:     []string{"USPIN_IMAGE=image.iso"},
+	}
+	if err := r.Run(PhasePostImage, []string{"missing.sh"}); err == nil {
+		t.Fatalf("Missing scripts should be reported")
+	}
+	if err := r.Run(PhasePostImage, []string{"post.sh"}); err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil || string(data) != "post-image image.iso hunter2\n" {
+		t.Fatalf("Wrong script environment: %q %v", data, err)
+	}
+
+	store, err := secrets.NewStore(map[string]string{"SECRET": "value:hunter2"}, dir)
+	if err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	var out bytes.Buffer
+	r.Chroot.Stdout = secrets.NewWriter(&out, store)
+	ioutil.WriteFile(filepath.Join(dir, "leak.sh"), []byte("echo \"psk=$SECRET\"\nprintf hunter\n"), 00644)
+	if err := r.Run(PhasePostImage, []string{"leak.sh"}); err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	if out.String() != "psk="+secrets.Redacted+"\nhunter" {
+		t.Fatalf("Secrets not redacted from script output: %q", out.String())
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "fail.sh"), []byte("exit 3\n"), 00644)
+	err = r.Run(PhasePostImage, []string{"fail.sh", "post.sh"})
+	if err == nil || !strings.Contains(err.Error(), "fail.sh") {
+		t.Fatalf("Failing scripts should be reported: %v", err)
+	}
+}
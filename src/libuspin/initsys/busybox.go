@@ -0,0 +1,104 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package initsys
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// BusyboxInittab configures busybox init within the image
+	BusyboxInittab = "/etc/inittab"
+
+	// BusyboxScriptDir holds the generated oneshot scripts within the image
+	BusyboxScriptDir = "/usr/lib/uspin/busybox"
+)
+
+// busyboxDefaultInittab matches the behaviour of busybox init when there is
+// no inittab, which is lost as soon as one is written
+const busyboxDefaultInittab = `# Generated by USpin
+::sysinit:/etc/init.d/rcS
+::askfirst:-/bin/sh
+tty2::askfirst:-/bin/sh
+tty3::askfirst:-/bin/sh
+tty4::askfirst:-/bin/sh
+::ctrlaltdel:/sbin/reboot
+::shutdown:/bin/umount -a -r
+::restart:/sbin/init
+`
+
+// busybox runs everything from sysinit entries of the inittab, in the order
+// they are listed. There are no dependencies, so After and Before are
+// ignored and entries are appended after the existing rcS.
+type busybox struct{}
+
+func (s *busybox) Type() Type {
+	return TypeBusybox
+}
+
+// addInittab will append the line to the inittab, unless already present
+func addInittab(root, line string) error {
+	path := filepath.Join(root, BusyboxInittab)
+	fi, err := os.Open(path)
+	switch {
+	case err == nil:
+		sc := bufio.NewScanner(fi)
+		for sc.Scan() {
+			if strings.TrimSpace(sc.Text()) == line {
+				fi.Close()
+				return nil
+			}
+		}
+		fi.Close()
+	case os.IsNotExist(err):
+		if err := writeFile(root, BusyboxInittab, []byte(busyboxDefaultInittab), 00644); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 00644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, line); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (s *busybox) InstallOneshot(root string, o *Oneshot) error {
+	script := filepath.Join(BusyboxScriptDir, o.Name)
+	if err := writeFile(root, script, []byte("#!/bin/sh\n# Generated by USpin\n"+o.script()), 00755); err != nil {
+		return err
+	}
+	return addInittab(root, "::sysinit:"+script)
+}
+
+// Enable will start the /etc/init.d script from the inittab
+func (s *busybox) Enable(root, name string) error {
+	script := filepath.Join("/etc/init.d", name)
+	if !exists(root, script) {
+		return fmt.Errorf("Cannot enable %v: no such script %v", name, script)
+	}
+	return addInittab(root, "::sysinit:"+script+" start")
+}
@@ -0,0 +1,166 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package initsys abstracts the init system of the image, so that services
+// can be enabled and first boot tasks installed without assuming systemd.
+package initsys
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Type is the name of a supported init system
+type Type string
+
+const (
+	// TypeSystemd is the default init system
+	TypeSystemd Type = "systemd"
+
+	// TypeOpenRC uses /etc/init.d scripts and runlevels
+	TypeOpenRC Type = "openrc"
+
+	// TypeRunit uses the Void Linux layout of /etc/sv and core-services
+	TypeRunit Type = "runit"
+
+	// TypeS6 uses the Artix layout of s6-rc source definitions
+	TypeS6 Type = "s6"
+
+	// TypeBusybox uses the inittab of busybox init, for tiny images
+	TypeBusybox Type = "busybox"
+)
+
+// Types lists every supported init system
+var Types = []Type{TypeSystemd, TypeOpenRC, TypeRunit, TypeS6, TypeBusybox}
+
+// A Oneshot is a command run once during boot, after the local filesystems
+// are mounted and writable
+type Oneshot struct {
+	Name        string   // Service name, without any suffix
+	Description string   // Human readable description
+	Command     string   // Shell command to run
+	After       []string // Services this is ordered after
+	Before      []string // Services this is ordered before
+	Conditions  []string // Paths which must exist, or not exist with a "!" prefix
+	Stamp       string   // Created on success, the command never runs again once it exists
+}
+
+// A System installs and enables services within a root
+type System interface {
+
+	// Type returns the name of the init system
+	Type() Type
+
+	// InstallOneshot will install and enable the oneshot within the root
+	InstallOneshot(root string, o *Oneshot) error
+
+	// Enable will enable a service installed by a package to start on boot
+	Enable(root, name string) error
+}
+
+// New will return the init system of the given type
+func New(t Type) (System, error) {
+	switch t {
+	case TypeSystemd:
+		return &systemd{}, nil
+	case TypeOpenRC:
+		return &openrc{}, nil
+	case TypeRunit:
+		return &runit{}, nil
+	case TypeS6:
+		return &s6{}, nil
+	case TypeBusybox:
+		return &busybox{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown init system: %v", t)
+	}
+}
+
+// link will replace any existing link at path with one pointing to target,
+// after checking that target exists within the root
+func link(root, target, path string) error {
+	if _, err := os.Lstat(filepath.Join(root, target)); err != nil {
+		return fmt.Errorf("Cannot enable %v: %v", filepath.Base(target), err)
+	}
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
+// exists returns true if the path exists within the root
+func exists(root, path string) bool {
+	_, err := os.Lstat(filepath.Join(root, path))
+	return err == nil
+}
+
+// writeFile will write the file within the root, creating its directory
+func writeFile(root, path string, data []byte, mode os.FileMode) error {
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, mode); err != nil {
+		return err
+	}
+	// WriteFile leaves the mode of existing files alone
+	return os.Chmod(path, mode)
+}
+
+// shellQuote will quote the string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// script returns a shell script running the oneshot, for init systems with
+// no native support for conditions or stamps
+func (o *Oneshot) script() string {
+	var lines []string
+	if o.Stamp != "" {
+		lines = append(lines, fmt.Sprintf("[ -e %v ] && exit 0", shellQuote(o.Stamp)))
+	}
+	for _, cond := range o.Conditions {
+		if strings.HasPrefix(cond, "!") {
+			lines = append(lines, fmt.Sprintf("[ -e %v ] && exit 0", shellQuote(cond[1:])))
+		} else {
+			lines = append(lines, fmt.Sprintf("[ -e %v ] || exit 0", shellQuote(cond)))
+		}
+	}
+	lines = append(lines, "/bin/sh -c "+shellQuote(o.Command)+" || exit $?")
+	if o.Stamp != "" {
+		lines = append(lines, fmt.Sprintf("mkdir -p %v && touch %v", shellQuote(filepath.Dir(o.Stamp)), shellQuote(o.Stamp)))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// plainNames drops systemd unit names, i.e. "sshd.service", which mean
+// nothing to other init systems
+func plainNames(names []string) []string {
+	var ret []string
+	for _, name := range names {
+		if !strings.Contains(name, ".") {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
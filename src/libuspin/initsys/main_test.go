@@ -0,0 +1,164 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package initsys
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOneshotScript(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-initsys")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	stamp := filepath.Join(root, "stamp", "done")
+	out := filepath.Join(root, "out")
+	o := &Oneshot{
+		Name:       "hello",
+		Command:    "echo \"it's\" >> " + out,
+		Conditions: []string{"!" + filepath.Join(root, "skip")},
+		Stamp:      stamp,
+	}
+	for i := 0; i < 2; i++ {
+		if err := exec.Command("/bin/sh", "-c", o.script()).Run(); err != nil {
+			t.Fatalf("Failed to run script: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil || string(data) != "it's\n" {
+		t.Fatalf("Oneshot should run exactly once: %q %v", data, err)
+	}
+}
+
+func TestInstallOneshot(t *testing.T) {
+	o := &Oneshot{
+		Name:    "uspin-hello",
+		Command: "echo hello",
+		After:   []string{"systemd-remount-fs.service", "udev"},
+		Stamp:   "/var/lib/uspin/hello",
+	}
+	expect := map[Type][]string{
+		TypeSystemd: {"/usr/lib/systemd/system/uspin-hello.service", "/etc/systemd/system/multi-user.target.wants/uspin-hello.service"},
+		TypeOpenRC:  {"/etc/init.d/uspin-hello", "/etc/runlevels/default/uspin-hello"},
+		TypeRunit:   {"/etc/runit/core-services/90-uspin-hello.sh"},
+		TypeS6:      {"/etc/s6/sv/uspin-hello/up", "/etc/s6/sv/uspin-hello/dependencies.d/udev", "/etc/s6/adminsv/default/contents.d/uspin-hello"},
+		TypeBusybox: {"/etc/inittab", "/usr/lib/uspin/busybox/uspin-hello"},
+	}
+	for _, typ := range Types {
+		root, err := ioutil.TempDir("", "uspin-initsys")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(root)
+
+		sys, err := New(typ)
+		if err != nil {
+			t.Fatalf("Failed to get init system %v: %v", typ, err)
+		}
+		if err := sys.InstallOneshot(root, o); err != nil {
+			t.Fatalf("Failed to install oneshot for %v: %v", typ, err)
+		}
+		// Reinstalling must replace the existing files
+		if err := sys.InstallOneshot(root, o); err != nil {
+			t.Fatalf("Failed to reinstall oneshot for %v: %v", typ, err)
+		}
+		for _, path := range expect[typ] {
+			if _, err := os.Lstat(filepath.Join(root, path)); err != nil {
+				t.Fatalf("%v oneshot is missing %v: %v", typ, path, err)
+			}
+		}
+	}
+	if _, err := New("upstart"); err == nil {
+		t.Fatalf("Unknown init systems should be rejected")
+	}
+}
+
+func TestOpenRCScript(t *testing.T) {
+	script := string(openrcScript(&Oneshot{
+		Name:    "uspin-hello",
+		Command: "true",
+		After:   []string{"systemd-remount-fs.service", "udev"},
+		Before:  []string{"sshd"},
+	}))
+	for _, want := range []string{
+		"#!/sbin/openrc-run\n",
+		"\tneed localmount\n\tafter udev\n\tbefore sshd\n",
+		"rc-update del uspin-hello default",
+	} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("Script is missing %q:\n%v", want, script)
+		}
+	}
+	if strings.Contains(script, "systemd-remount-fs") {
+		t.Fatalf("systemd units should not be referenced:\n%v", script)
+	}
+}
+
+func TestEnable(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-initsys")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	sys, _ := New(TypeRunit)
+	if err := sys.Enable(root, "sshd"); err == nil {
+		t.Fatalf("Missing services should not be enabled")
+	}
+	os.MkdirAll(filepath.Join(root, RunitServiceDir, "sshd"), 00755)
+	if err := sys.Enable(root, "sshd"); err != nil {
+		t.Fatalf("Failed to enable service: %v", err)
+	}
+	target, err := os.Readlink(filepath.Join(root, RunitEnabledDir, "sshd"))
+	if err != nil || target != "/etc/sv/sshd" {
+		t.Fatalf("Service not enabled: %v %v", target, err)
+	}
+}
+
+func TestBusyboxInittab(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-initsys")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	sys, _ := New(TypeBusybox)
+	o := &Oneshot{Name: "uspin-hello", Command: "echo hello"}
+	for i := 0; i < 2; i++ {
+		if err := sys.InstallOneshot(root, o); err != nil {
+			t.Fatalf("Failed to install oneshot: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, BusyboxInittab))
+	if err != nil {
+		t.Fatalf("Failed to read inittab: %v", err)
+	}
+	inittab := string(data)
+	if !strings.HasPrefix(inittab, busyboxDefaultInittab) {
+		t.Fatalf("Default inittab should be kept:\n%v", inittab)
+	}
+	if strings.Count(inittab, "::sysinit:/usr/lib/uspin/busybox/uspin-hello\n") != 1 {
+		t.Fatalf("Oneshot should be added exactly once:\n%v", inittab)
+	}
+}
@@ -0,0 +1,75 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package initsys
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// OpenRCInitDir holds the service scripts within the image
+	OpenRCInitDir = "/etc/init.d"
+
+	// OpenRCRunlevelDir is where services are enabled within the image
+	OpenRCRunlevelDir = "/etc/runlevels/default"
+)
+
+type openrc struct{}
+
+func (s *openrc) Type() Type {
+	return TypeOpenRC
+}
+
+// openrcScript will generate the openrc-run script for the oneshot, which
+// removes itself from the runlevel once it has run successfully
+func openrcScript(o *Oneshot) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#!/sbin/openrc-run\n# Generated by USpin\n\n")
+	if o.Description != "" {
+		fmt.Fprintf(&buf, "description=%v\n\n", shellQuote(o.Description))
+	}
+	fmt.Fprintf(&buf, "depend() {\n\tneed localmount\n")
+	if after := plainNames(o.After); len(after) > 0 {
+		fmt.Fprintf(&buf, "\tafter %v\n", strings.Join(after, " "))
+	}
+	if before := plainNames(o.Before); len(before) > 0 {
+		fmt.Fprintf(&buf, "\tbefore %v\n", strings.Join(before, " "))
+	}
+	fmt.Fprintf(&buf, "}\n\nstart() {\n\tebegin %v\n\t(\n", shellQuote("Running "+o.Name))
+	for _, line := range strings.Split(strings.TrimSpace(o.script()), "\n") {
+		fmt.Fprintf(&buf, "\t\t%v\n", line)
+	}
+	fmt.Fprintf(&buf, "\t)\n\tret=$?\n")
+	fmt.Fprintf(&buf, "\t[ $ret -eq 0 ] && rc-update del %v default >/dev/null 2>&1\n", o.Name)
+	fmt.Fprintf(&buf, "\teend $ret\n}\n")
+	return buf.Bytes()
+}
+
+func (s *openrc) InstallOneshot(root string, o *Oneshot) error {
+	script := filepath.Join(OpenRCInitDir, o.Name)
+	if err := writeFile(root, script, openrcScript(o), 00755); err != nil {
+		return err
+	}
+	return s.Enable(root, o.Name)
+}
+
+func (s *openrc) Enable(root, name string) error {
+	return link(root, filepath.Join(OpenRCInitDir, name), filepath.Join(OpenRCRunlevelDir, name))
+}
@@ -0,0 +1,53 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package initsys
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	// RunitServiceDir holds the service directories within the image
+	RunitServiceDir = "/etc/sv"
+
+	// RunitEnabledDir is where services are enabled within the image
+	RunitEnabledDir = "/etc/runit/runsvdir/default"
+
+	// RunitCoreDir holds the scripts sourced during stage 1 of boot
+	RunitCoreDir = "/etc/runit/core-services"
+)
+
+type runit struct{}
+
+func (s *runit) Type() Type {
+	return TypeRunit
+}
+
+// InstallOneshot will add the oneshot to the core services, as runit has no
+// concept of oneshot services. These are sourced in order once the local
+// filesystems are mounted, so ordering is by filename alone and the oneshot
+// runs in a subshell to keep it from exiting stage 1.
+func (s *runit) InstallOneshot(root string, o *Oneshot) error {
+	script := fmt.Sprintf("# Generated by USpin\n(\n%v)\n", o.script())
+	path := filepath.Join(RunitCoreDir, "90-"+o.Name+".sh")
+	return writeFile(root, path, []byte(script), 00644)
+}
+
+func (s *runit) Enable(root, name string) error {
+	return link(root, filepath.Join(RunitServiceDir, name), filepath.Join(RunitEnabledDir, name))
+}
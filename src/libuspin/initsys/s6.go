@@ -0,0 +1,84 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package initsys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// S6SourceDir holds the s6-rc service definitions within the image
+	S6SourceDir = "/etc/s6/sv"
+
+	// S6DefaultBundle lists the services started on boot within the image
+	S6DefaultBundle = "/etc/s6/adminsv/default/contents.d"
+)
+
+// s6 writes s6-rc source definitions. The compiled database is not touched,
+// it is rebuilt from the sources by s6-db-reload on the first boot.
+type s6 struct{}
+
+// file is written into the root as part of a service definition
+type file struct {
+	path string
+	data string
+	mode os.FileMode
+}
+
+func (s *s6) Type() Type {
+	return TypeS6
+}
+
+func (s *s6) InstallOneshot(root string, o *Oneshot) error {
+	dir := filepath.Join(S6SourceDir, o.Name)
+	script := filepath.Join("/usr/lib/uspin/s6", o.Name)
+	files := []file{
+		{filepath.Join(dir, "type"), "oneshot\n", 00644},
+		{filepath.Join(dir, "up"), script + "\n", 00644},
+		{script, "#!/bin/sh\n# Generated by USpin\n" + o.script(), 00755},
+	}
+	deps := append([]string{"mount-filesystems"}, plainNames(o.After)...)
+	for _, dep := range deps {
+		files = append(files, file{filepath.Join(dir, "dependencies.d", dep), "", 00644})
+	}
+	for _, f := range files {
+		if err := writeFile(root, f.path, []byte(f.data), f.mode); err != nil {
+			return err
+		}
+	}
+	// s6-rc can only order a service after another, so reverse Before
+	for _, before := range plainNames(o.Before) {
+		dep := filepath.Join(S6SourceDir, before, "dependencies.d", o.Name)
+		if !exists(root, filepath.Join(S6SourceDir, before)) {
+			return fmt.Errorf("Cannot order %v before missing service %v", o.Name, before)
+		}
+		if err := writeFile(root, dep, nil, 00644); err != nil {
+			return err
+		}
+	}
+	return s.Enable(root, o.Name)
+}
+
+func (s *s6) Enable(root, name string) error {
+	if strings.Contains(name, "/") || !exists(root, filepath.Join(S6SourceDir, name)) {
+		return fmt.Errorf("Cannot enable %v: no such service in %v", name, S6SourceDir)
+	}
+	return writeFile(root, filepath.Join(S6DefaultBundle, name), nil, 00644)
+}
@@ -0,0 +1,97 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package initsys
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SystemdUnitDir is where generated units are installed within the image
+	SystemdUnitDir = "/usr/lib/systemd/system"
+
+	// SystemdWantsDir is where units are enabled within the image
+	SystemdWantsDir = "/etc/systemd/system/multi-user.target.wants"
+)
+
+type systemd struct{}
+
+func (s *systemd) Type() Type {
+	return TypeSystemd
+}
+
+// SystemdUnit will generate the systemd unit for the oneshot, which disables
+// itself once it has run successfully
+func SystemdUnit(o *Oneshot) []byte {
+	unitName := o.Name + ".service"
+	desc := o.Description
+	if desc == "" {
+		desc = o.Name
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by USpin\n[Unit]\nDescription=%v\n", desc)
+	after := append([]string{"local-fs.target"}, o.After...)
+	fmt.Fprintf(&buf, "After=%v\n", strings.Join(after, " "))
+	if len(o.Before) > 0 {
+		fmt.Fprintf(&buf, "Before=%v\n", strings.Join(o.Before, " "))
+	}
+	if o.Stamp != "" {
+		fmt.Fprintf(&buf, "ConditionPathExists=!%v\n", o.Stamp)
+	}
+	for _, cond := range o.Conditions {
+		fmt.Fprintf(&buf, "ConditionPathExists=%v\n", cond)
+	}
+	fmt.Fprintf(&buf, "\n[Service]\nType=oneshot\nRemainAfterExit=yes\n")
+	fmt.Fprintf(&buf, "ExecStart=/bin/sh -c %v\n", systemdQuote(o.Command))
+	if o.Stamp != "" {
+		fmt.Fprintf(&buf, "ExecStartPost=/bin/mkdir -p %v\n", filepath.Dir(o.Stamp))
+		fmt.Fprintf(&buf, "ExecStartPost=/bin/touch %v\n", o.Stamp)
+	}
+	fmt.Fprintf(&buf, "ExecStartPost=/usr/bin/systemctl disable %v\n", unitName)
+	fmt.Fprintf(&buf, "\n[Install]\nWantedBy=multi-user.target\n")
+	return buf.Bytes()
+}
+
+// systemdQuote will quote the command for use within a systemd Exec line
+func systemdQuote(command string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "%", "%%", "$", "$$", "\n", " ")
+	return "\"" + r.Replace(command) + "\""
+}
+
+func (s *systemd) InstallOneshot(root string, o *Oneshot) error {
+	unit := filepath.Join(SystemdUnitDir, o.Name+".service")
+	if err := writeFile(root, unit, SystemdUnit(o), 00644); err != nil {
+		return err
+	}
+	return s.Enable(root, o.Name)
+}
+
+// Enable will link the unit into multi-user.target, looking for it in the
+// vendor directory first and then /etc
+func (s *systemd) Enable(root, name string) error {
+	if !strings.Contains(name, ".") {
+		name += ".service"
+	}
+	target := filepath.Join(SystemdUnitDir, name)
+	if alt := filepath.Join("/etc/systemd/system", name); !exists(root, target) && exists(root, alt) {
+		target = alt
+	}
+	return link(root, target, filepath.Join(SystemdWantsDir, name))
+}
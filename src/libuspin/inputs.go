@@ -0,0 +1,95 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"libuspin/overlay"
+	"libuspin/secrets"
+	"libuspin/seed"
+	"sort"
+)
+
+// Inputs will return the absolute path of every local file and directory
+// read while building the ImageSpec: the .spin file, the Packages file and
+// everything it includes, the overlay, scripts, tools, a local seed, kernel
+// sources, keys and file secrets. Remote sources, host mounts and caches are
+// left out. Paths which don't exist are included, as creating one would
+// change the build.
+func (i *ImageSpec) Inputs() []string {
+	conf := i.Config
+	paths := []string{i.SpinFile}
+	paths = append(paths, i.SpecFiles...)
+	if conf.Image.Overlay != "" {
+		dir := i.JoinPath(conf.Image.Overlay)
+		meta := dir + overlay.MetaSuffix
+		if conf.Image.OverlayMeta != "" {
+			meta = i.JoinPath(conf.Image.OverlayMeta)
+		}
+		paths = append(paths, dir, meta)
+	}
+	if conf.Image.Seed != "" {
+		if s, err := seed.Resolve(conf.Image.Seed, i.BaseDir); err != nil {
+			paths = append(paths, i.JoinPath(conf.Image.Seed))
+		} else if s.Kind != seed.KindRemote {
+			paths = append(paths, s.Source)
+		}
+	}
+	files := [][]string{
+		{conf.Image.LicensePolicy, conf.Downloads.Lockfile, conf.Tools.Directory},
+		conf.Scripts.PostInstall,
+		conf.Scripts.PreCompress,
+		conf.Scripts.PostImage,
+		conf.Test.Scripts,
+		{conf.Kernel.Source, conf.Kernel.Config, conf.Kernel.Prebuilt},
+		conf.Kernel.Patches,
+		{conf.Boot.SecureBootKey, conf.Boot.SecureBootCert, conf.Boot.Shim},
+		{conf.APT.Keyring},
+		conf.APK.Keys,
+		conf.XBPS.Keys,
+		conf.Zypper.Keys,
+	}
+	for _, set := range files {
+		for _, path := range set {
+			if path != "" {
+				paths = append(paths, i.JoinPath(path))
+			}
+		}
+	}
+	paths = append(paths, secrets.Files(conf.Secrets, i.BaseDir)...)
+
+	seen := make(map[string]bool)
+	var ret []string
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			ret = append(ret, path)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ProfileInputs will return the Inputs of the .spin file, parsing it and its
+// Packages file without acting on anything else, so that a profile can be
+// verified before it is trusted.
+func ProfileInputs(spinFile string) ([]string, error) {
+	img, err := loadImageSpec(spinFile)
+	if err != nil {
+		return nil, err
+	}
+	return img.Inputs(), nil
+}
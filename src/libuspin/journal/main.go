@@ -0,0 +1,234 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package journal persists the progress of a build within its workspace,
+// so that the state of the workspace is known even after a crash.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is the name of the journal within the workspace
+const File = "journal.jsonl"
+
+// An Event is a transition of the build state
+type Event string
+
+const (
+	// EventBegin is recorded when a build starts
+	EventBegin Event = "begin"
+
+	// EventStart is recorded when a stage starts
+	EventStart Event = "start"
+
+	// EventFinish is recorded when a stage completes successfully
+	EventFinish Event = "finish"
+
+	// EventFail is recorded when a stage fails
+	EventFail Event = "fail"
+
+	// EventTakeover is recorded when a build takes over the workspace of an
+	// interrupted one
+	EventTakeover Event = "takeover"
+
+	// EventEnd is recorded when a build completes, successfully or not
+	EventEnd Event = "end"
+)
+
+// An Entry is a single line of the journal
+type Entry struct {
+	Time  time.Time `json:"time"`
+	PID   int       `json:"pid"`
+	Event Event     `json:"event"`
+	Stage string    `json:"stage,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// A Journal records the entries of the current build
+type Journal struct {
+	path    string
+	entries []*Entry // This build only
+	mu      sync.Mutex
+}
+
+// Open will return a Journal for the workspace. Nothing is written until
+// the first entry is recorded.
+func Open(workspace string) *Journal {
+	return &Journal{path: filepath.Join(workspace, File)}
+}
+
+// Record will durably append the entry before returning. If the journal has
+// gone, i.e. the workspace was purged, every entry of this build is written.
+func (j *Journal) Record(event Event, stage string, failure error) error {
+	e := &Entry{
+		Time:  time.Now().UTC(),
+		PID:   os.Getpid(),
+		Event: event,
+		Stage: stage,
+	}
+	if failure != nil {
+		e.Error = failure.Error()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, e)
+
+	write := []*Entry{e}
+	created := false
+	if _, err := os.Stat(j.path); os.IsNotExist(err) {
+		write = j.entries
+		created = true
+	}
+	var buf bytes.Buffer
+	for _, entry := range write {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 00644)
+	if err != nil {
+		return err
+	}
+	// Terminate any line left incomplete by a crash
+	if st, err := f.Stat(); err == nil && st.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, st.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte("\n"))
+		}
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if created {
+		return syncDir(filepath.Dir(j.path))
+	}
+	return nil
+}
+
+// syncDir ensures a newly created file survives a power loss
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Load will read every entry from the journal within the workspace. A
+// partially written last line, from a crash mid-write, is ignored.
+func Load(workspace string) ([]*Entry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(workspace, File))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ret []*Entry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		e := &Entry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	return ret, sc.Err()
+}
+
+// State is the state of the workspace as described by the journal
+type State struct {
+	PID       int       // Process of the most recent build
+	Began     time.Time // When the most recent build began
+	Completed []string  // Stages completed since the workspace was prepared
+	Running   string    // Stage started but never finished, if any
+	Failed    string    // Stage that failed, if any
+	Error     string    // Failure of the failed stage
+	Ended     bool      // The most recent build ended, successfully or not
+}
+
+// Replay will compute the state of the workspace from its entries. The
+// first stage resets progress, as it recreates the workspace.
+func Replay(entries []*Entry, firstStage string) *State {
+	s := &State{}
+	for _, e := range entries {
+		switch e.Event {
+		case EventBegin:
+			s.PID, s.Began, s.Ended = e.PID, e.Time, false
+			s.Running, s.Failed, s.Error = "", "", ""
+		case EventStart:
+			if e.Stage == firstStage {
+				s.Completed = nil
+			}
+			s.Running = e.Stage
+		case EventFinish:
+			s.Running = ""
+			s.Completed = appendUnique(s.Completed, e.Stage)
+		case EventFail:
+			s.Running = ""
+			s.Failed, s.Error = e.Stage, e.Error
+		case EventEnd:
+			s.Ended = true
+		}
+	}
+	return s
+}
+
+// Interrupted returns true if the most recent build never ended, i.e. it
+// crashed or the host lost power.
+func (s *State) Interrupted() bool {
+	return s.PID != 0 && !s.Ended
+}
+
+// Done returns true if the stage has completed in the workspace
+func (s *State) Done(stage string) bool {
+	for _, c := range s.Completed {
+		if c == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, i := range list {
+		if i == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
@@ -0,0 +1,73 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package journal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-journal")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	j := Open(dir)
+	j.Record(EventBegin, "", nil)
+	j.Record(EventStart, "prepare", nil)
+
+	// The prepare stage purges the workspace, losing the journal
+	os.Remove(filepath.Join(dir, File))
+	j.Record(EventFinish, "prepare", nil)
+	j.Record(EventStart, "rootfs", nil)
+
+	// Simulate a crash midway through writing a line
+	f, _ := os.OpenFile(filepath.Join(dir, File), os.O_WRONLY|os.O_APPEND, 00644)
+	f.WriteString(`{"time":"20`)
+	f.Close()
+
+	entries, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Failed to load journal: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %v", len(entries))
+	}
+	s := Replay(entries, "prepare")
+	if !s.Interrupted() || s.Running != "rootfs" || !s.Done("prepare") || s.Done("rootfs") {
+		t.Fatalf("Wrong state: %+v", s)
+	}
+
+	j = Open(dir)
+	j.Record(EventBegin, "", nil)
+	j.Record(EventStart, "rootfs", nil)
+	j.Record(EventFail, "rootfs", errors.New("eopkg failed"))
+	j.Record(EventEnd, "", nil)
+	entries, _ = Load(dir)
+	s = Replay(entries, "prepare")
+	if len(entries) != 8 {
+		t.Fatalf("Expected 8 entries, got %v", len(entries))
+	}
+	if s.Interrupted() || s.Failed != "rootfs" || s.Error != "eopkg failed" || !s.Done("prepare") {
+		t.Fatalf("Wrong state: %+v", s)
+	}
+}
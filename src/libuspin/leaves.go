@@ -0,0 +1,106 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A LeafReport lists the installed packages which nothing else in the image
+// depends upon, as candidates for trimming the Packages file. Dependencies
+// on virtual names are not resolved to their providers, so providers may be
+// reported as leaves.
+type LeafReport struct {
+	Leaves  []string `json:"leaves"`  // Requested by the profile, nothing depends on them
+	Orphans []string `json:"orphans"` // Installed automatically, nothing depends on them any more
+}
+
+// NewLeafReport will find the leaves of the installed packages, given the
+// direct dependencies of each and the packages requested by the profile
+func NewLeafReport(installed []string, deps map[string][]string, requested []string) *LeafReport {
+	isInstalled := make(map[string]bool)
+	for _, name := range installed {
+		isInstalled[name] = true
+	}
+	needed := make(map[string]bool)
+	for name, names := range deps {
+		if !isInstalled[name] {
+			continue
+		}
+		for _, dep := range names {
+			// Packages depending on themselves are still leaves
+			if dep != name {
+				needed[dep] = true
+			}
+		}
+	}
+	isRequested := make(map[string]bool)
+	for _, name := range requested {
+		isRequested[name] = true
+	}
+	r := &LeafReport{}
+	for _, name := range installed {
+		switch {
+		case needed[name]:
+		case isRequested[name]:
+			r.Leaves = append(r.Leaves, name)
+		default:
+			r.Orphans = append(r.Orphans, name)
+		}
+	}
+	sort.Strings(r.Leaves)
+	sort.Strings(r.Orphans)
+	return r
+}
+
+// Write will emit a human readable version of the report
+func (r *LeafReport) Write(w io.Writer) error {
+	fmt.Fprintf(w, "Requested packages nothing depends on (%v):\n", len(r.Leaves))
+	for _, name := range r.Leaves {
+		fmt.Fprintf(w, "    %v\n", name)
+	}
+	fmt.Fprintf(w, "\nAutomatically installed packages nothing depends on (%v):\n", len(r.Orphans))
+	for _, name := range r.Orphans {
+		fmt.Fprintf(w, "    %v\n", name)
+	}
+	return nil
+}
+
+// WriteJSON will emit the report in JSON format for machine consumption
+func (r *LeafReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(r)
+}
+
+// LoadLeafReport will load a report previously stored with WriteJSON
+func LoadLeafReport(path string) (*LeafReport, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	r := &LeafReport{}
+	if err := json.NewDecoder(fi).Decode(r); err != nil {
+		return nil, fmt.Errorf("Invalid leaf report %v: %v", path, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,144 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package license provides license compliance checking for the packages
+// installed into an image.
+package license
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"libuspin/backend"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An Action determines what happens when a policy is violated
+type Action string
+
+const (
+	// ActionFail will cause the build to fail on any violation
+	ActionFail Action = "fail"
+
+	// ActionWarn will only log violations
+	ActionWarn Action = "warn"
+)
+
+// A Policy describes which licenses are permitted within an image. Entries
+// in Allowed and Forbidden are case insensitive and may use shell globbing,
+// i.e. "GPL-*".
+type Policy struct {
+	Action     Action   `toml:"action"`     // What to do on violation, defaults to fail
+	Allowed    []string `toml:"allowed"`    // If set, only these licenses are permitted
+	Forbidden  []string `toml:"forbidden"`  // These licenses are never permitted
+	Exceptions []string `toml:"exceptions"` // Packages exempt from the policy
+}
+
+// A Violation is a single package license which breaks the policy
+type Violation struct {
+	Package string
+	License string
+	Reason  string
+}
+
+func (v *Violation) String() string {
+	return fmt.Sprintf("%v (%v): %v", v.Package, v.License, v.Reason)
+}
+
+// LoadPolicy will load the TOML policy file from the given path
+func LoadPolicy(path string) (*Policy, error) {
+	p := &Policy{
+		Action: ActionFail,
+	}
+	if _, err := toml.DecodeFile(path, p); err != nil {
+		return nil, err
+	}
+	switch p.Action {
+	case ActionFail, ActionWarn:
+	default:
+		return nil, fmt.Errorf("Unknown license policy action: %v", p.Action)
+	}
+	return p, nil
+}
+
+// matchAny returns true if the license matches any of the patterns
+func matchAny(patterns []string, license string) bool {
+	license = strings.ToLower(license)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), license); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check will evaluate the package to license mapping against the policy,
+// returning all violations found, sorted by package name.
+func (p *Policy) Check(licenses map[string][]string) []*Violation {
+	var ret []*Violation
+	exempt := make(map[string]bool)
+	for _, name := range p.Exceptions {
+		exempt[name] = true
+	}
+
+	var names []string
+	for name := range licenses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if exempt[name] {
+			continue
+		}
+		if len(licenses[name]) == 0 && len(p.Allowed) > 0 {
+			ret = append(ret, &Violation{name, "none", "No license declared"})
+			continue
+		}
+		for _, lic := range licenses[name] {
+			if matchAny(p.Forbidden, lic) {
+				ret = append(ret, &Violation{name, lic, "License is forbidden"})
+			} else if len(p.Allowed) > 0 && !matchAny(p.Allowed, lic) {
+				ret = append(ret, &Violation{name, lic, "License is not allowed"})
+			}
+		}
+	}
+	return ret
+}
+
+// Collect will query the backend for the licenses of every installed package
+func Collect(query backend.Query) (map[string][]string, error) {
+	lister, ok := query.(backend.PackageLister)
+	if !ok {
+		return nil, backend.ErrUnsupportedQuery
+	}
+	reporter, ok := query.(backend.LicenseReporter)
+	if !ok {
+		return nil, backend.ErrUnsupportedQuery
+	}
+	names, err := lister.InstalledPackages()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]string)
+	for _, name := range names {
+		if ret[name], err = reporter.Licenses(name); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
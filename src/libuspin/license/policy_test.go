@@ -0,0 +1,80 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package license
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	p := &Policy{
+		Action:     ActionFail,
+		Allowed:    []string{"GPL-*", "MIT", "BSD-3-Clause"},
+		Forbidden:  []string{"GPL-3.0*"},
+		Exceptions: []string{"firmware"},
+	}
+	licenses := map[string][]string{
+		"bash":     {"GPL-3.0-or-later"},
+		"nano":     {"GPL-2.0"},
+		"libfoo":   {"mit"},
+		"unknown":  nil,
+		"firmware": {"Proprietary"},
+		"blob":     {"Proprietary"},
+	}
+	violations := p.Check(licenses)
+	if len(violations) != 3 {
+		t.Fatalf("Expected 3 violations, got %v", violations)
+	}
+	if violations[0].Package != "bash" || violations[0].Reason != "License is forbidden" {
+		t.Fatalf("bash should be forbidden: %v", violations[0])
+	}
+	if violations[1].Package != "blob" || violations[2].Package != "unknown" {
+		t.Fatalf("Incorrect violations: %v %v", violations[1], violations[2])
+	}
+}
+
+func TestReport(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary root: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	docs := filepath.Join(root, "usr", "share", "doc", "nano")
+	if err := os.MkdirAll(docs, 00755); err != nil {
+		t.Fatalf("Cannot create doc dir: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(docs, "COPYING"), []byte("GPL text"), 00644)
+	ioutil.WriteFile(filepath.Join(docs, "README"), []byte("Not a license"), 00644)
+
+	report, err := Gather(root, map[string][]string{"nano": {"GPL-3.0"}, "bash": nil})
+	if err != nil {
+		t.Fatalf("Failed to gather licenses: %v", err)
+	}
+	if len(report) != 2 || report[1].Package != "nano" {
+		t.Fatalf("Incorrect report: %v", report)
+	}
+	if len(report[1].Texts) != 1 || report[1].Texts[0].Content != "GPL text" {
+		t.Fatalf("Incorrect license texts: %v", report[1].Texts)
+	}
+	if err := WriteHTML(ioutil.Discard, "Test", report); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+}
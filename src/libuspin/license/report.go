@@ -0,0 +1,151 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package license
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// TextPaths are the directories within the rootfs, relative to the package
+	// name, that are searched for license texts.
+	TextPaths = []string{
+		"usr/share/licenses",
+		"usr/share/doc",
+	}
+
+	// TextPrefixes are the filename prefixes considered to be license texts
+	TextPrefixes = []string{
+		"COPYING",
+		"LICENSE",
+		"LICENCE",
+		"COPYRIGHT",
+	}
+)
+
+// A Text is a single license file shipped by a package
+type Text struct {
+	Name    string // Basename of the license file
+	Content string
+}
+
+// A PackageLicense records the licenses of a single installed package
+type PackageLicense struct {
+	Package  string
+	Licenses []string
+	Texts    []Text
+}
+
+// isLicenseText will determine if the file name looks like a license
+func isLicenseText(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range TextPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// findTexts will read all license texts for the package from the rootfs
+func findTexts(root, name string) ([]Text, error) {
+	var ret []Text
+	for _, dir := range TextPaths {
+		files, err := ioutil.ReadDir(filepath.Join(root, dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, fi := range files {
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			// Everything in usr/share/licenses/$pkg is a license
+			if dir != TextPaths[0] && !isLicenseText(fi.Name()) {
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(root, dir, name, fi.Name()))
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, Text{Name: fi.Name(), Content: string(content)})
+		}
+	}
+	return ret, nil
+}
+
+// Gather will pair the license identifiers of each package with the license
+// texts found in the rootfs, sorted by package name.
+func Gather(root string, licenses map[string][]string) ([]*PackageLicense, error) {
+	var names []string
+	for name := range licenses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ret []*PackageLicense
+	for _, name := range names {
+		texts, err := findTexts(root, name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, &PackageLicense{
+			Package:  name,
+			Licenses: licenses[name],
+			Texts:    texts,
+		})
+	}
+	return ret, nil
+}
+
+// reportTemplate is the HTML license report
+var reportTemplate = template.Must(template.New("licenses").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - Licenses</title>
+</head>
+<body>
+<h1>{{.Title}} - Licenses</h1>
+<table>
+<tr><th>Package</th><th>Licenses</th></tr>
+{{range .Packages}}<tr><td><a href="#{{.Package}}">{{.Package}}</a></td><td>{{range $i, $l := .Licenses}}{{if $i}}, {{end}}{{$l}}{{end}}</td></tr>
+{{end}}</table>
+{{range .Packages}}
+<h2 id="{{.Package}}">{{.Package}}</h2>
+{{range .Texts}}<h3>{{.Name}}</h3>
+<pre>{{.Content}}</pre>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML will write a consolidated HTML license report
+func WriteHTML(w io.Writer, title string, pkgs []*PackageLicense) error {
+	return reportTemplate.Execute(w, struct {
+		Title    string
+		Packages []*PackageLicense
+	}{title, pkgs})
+}
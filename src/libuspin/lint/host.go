@@ -0,0 +1,261 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package lint
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// hostRoot is the root of the host, only changed by the tests
+	hostRoot = "/"
+
+	// mountInfo lists the mounts visible to the process
+	mountInfo = "/proc/self/mountinfo"
+
+	// hostname is used to find host entries within /etc/hosts
+	hostname = os.Hostname
+
+	// archMachines are the ELF machines allowed for each architecture
+	archMachines = map[string][]elf.Machine{
+		"x86_64":  {elf.EM_X86_64, elf.EM_386},
+		"i686":    {elf.EM_386},
+		"aarch64": {elf.EM_AARCH64},
+	}
+
+	// binDirs are scanned for executables of the wrong architecture
+	binDirs = []string{"/usr/bin", "/usr/sbin", "/bin", "/sbin"}
+)
+
+// sameContent returns true if both files exist, are not empty, and match
+func sameContent(a, b string) bool {
+	da, err := ioutil.ReadFile(a)
+	if err != nil || len(bytes.TrimSpace(da)) == 0 {
+		return false
+	}
+	db, err := ioutil.ReadFile(b)
+	return err == nil && bytes.Equal(da, db)
+}
+
+// HostContamination will report anything the host has left within the
+// rootfs, which must still be mounted. arch is the target architecture.
+func HostContamination(root, arch string) ([]*Issue, error) {
+	var issues []*Issue
+	for _, check := range []func(string, string) ([]*Issue, error){
+		checkResolvConf,
+		checkMachineID,
+		checkMounts,
+		checkHosts,
+		checkArch,
+	} {
+		found, err := check(root, arch)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+	return issues, nil
+}
+
+// checkResolvConf finds a copy of the host resolver configuration, or one
+// left behind by a chroot session which never left.
+func checkResolvConf(root, arch string) ([]*Issue, error) {
+	var issues []*Issue
+	if sameContent(filepath.Join(root, "etc", "resolv.conf"), filepath.Join(hostRoot, "etc", "resolv.conf")) {
+		issues = append(issues, &Issue{
+			Check:   "resolv.conf",
+			Path:    "/etc/resolv.conf",
+			Message: "Identical to the host resolver configuration",
+		})
+	}
+	backups, err := filepath.Glob(filepath.Join(root, "etc", "*.uspin-orig"))
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		issues = append(issues, &Issue{
+			Check:   "resolv.conf",
+			Path:    "/etc/" + filepath.Base(backup),
+			Message: "Image file still replaced by a chroot session",
+		})
+	}
+	return issues, nil
+}
+
+// checkMachineID ensures the host's identity wasn't copied in
+func checkMachineID(root, arch string) ([]*Issue, error) {
+	if !sameContent(filepath.Join(root, "etc", "machine-id"), filepath.Join(hostRoot, "etc", "machine-id")) {
+		return nil, nil
+	}
+	return []*Issue{{
+		Check:   "machine-id",
+		Path:    "/etc/machine-id",
+		Message: "Identical to the host machine-id",
+	}}, nil
+}
+
+// unescapeMount decodes the octal escapes used in mountinfo, i.e. \040
+func unescapeMount(path string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				buf.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		buf.WriteByte(path[i])
+	}
+	return buf.String()
+}
+
+// MountsBeneath returns every mount point at or beneath dir, in the order
+// they were mounted.
+func MountsBeneath(dir string) ([]string, error) {
+	data, err := ioutil.ReadFile(mountInfo)
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Clean(dir)
+	var ret []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		target := unescapeMount(fields[4])
+		if target == dir || strings.HasPrefix(target, dir+"/") {
+			ret = append(ret, target)
+		}
+	}
+	return ret, sc.Err()
+}
+
+// checkMounts finds anything still mounted beneath the rootfs, which would
+// otherwise be copied into the media.
+func checkMounts(root, arch string) ([]*Issue, error) {
+	mounts, err := MountsBeneath(root)
+	if err != nil {
+		return nil, err
+	}
+	root = filepath.Clean(root)
+	var issues []*Issue
+	for _, target := range mounts {
+		if target == root {
+			continue
+		}
+		issues = append(issues, &Issue{
+			Check:   "mounts",
+			Path:    strings.TrimPrefix(target, root),
+			Message: "Still mounted from the host",
+		})
+	}
+	return issues, nil
+}
+
+// checkHosts finds the host's name within /etc/hosts
+func checkHosts(root, arch string) ([]*Issue, error) {
+	host, err := hostname()
+	if err != nil || host == "" || host == "localhost" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "etc", "hosts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	short := strings.Split(host, ".")[0]
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if name == host || name == short {
+				return []*Issue{{
+					Check:   "hosts",
+					Path:    "/etc/hosts",
+					Message: "Contains the host name " + name + ": " + line,
+				}}, nil
+			}
+		}
+	}
+	return nil, sc.Err()
+}
+
+// checkArch finds executables built for another architecture
+func checkArch(root, arch string) ([]*Issue, error) {
+	machines, ok := archMachines[arch]
+	if !ok {
+		return nil, nil
+	}
+	var issues []*Issue
+	for _, dir := range binDirs {
+		// /bin and /sbin are commonly symlinks into /usr
+		if st, err := os.Lstat(filepath.Join(root, dir)); err != nil || !st.IsDir() {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+			fi, err := elf.Open(filepath.Join(root, dir, entry.Name()))
+			if err != nil {
+				continue // Scripts, etc
+			}
+			machine := fi.Machine
+			fi.Close()
+			if !hasMachine(machines, machine) {
+				issues = append(issues, &Issue{
+					Check:   "arch",
+					Path:    filepath.Join(dir, entry.Name()),
+					Message: "Built for " + machine.String() + ", not " + arch,
+				})
+			}
+		}
+	}
+	return issues, nil
+}
+
+func hasMachine(machines []elf.Machine, machine elf.Machine) bool {
+	for _, m := range machines {
+		if m == machine {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package lint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestHostContamination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-lint")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostRoot = filepath.Join(dir, "host")
+	root := filepath.Join(dir, "root")
+	mountInfo = filepath.Join(dir, "mountinfo")
+	hostname = func() (string, error) { return "builder.example.com", nil }
+
+	files := map[string]string{
+		"host/etc/resolv.conf":              "nameserver 10.0.0.1\n",
+		"host/etc/machine-id":               "0123456789abcdef0123456789abcdef\n",
+		"root/etc/resolv.conf":              "nameserver 10.0.0.1\n",
+		"root/etc/machine-id":               "fedcba9876543210fedcba9876543210\n",
+		"root/etc/nsswitch.conf.uspin-orig": "hosts: files\n",
+		"root/etc/hosts":                    "127.0.0.1 localhost\n127.0.1.1 builder # Added by the host\n",
+		"mountinfo":                         "22 1 8:1 / " + root + " rw - ext4 /dev/loop0 rw\n23 22 0:5 / " + root + "/var/cache\\040eopkg rw - tmpfs tmpfs rw\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 00755)
+		if err := ioutil.WriteFile(path, []byte(content), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	issues, err := HostContamination(root, "x86_64")
+	if err != nil {
+		t.Fatalf("Failed to check image: %v", err)
+	}
+	found := make(map[string]string)
+	for _, issue := range issues {
+		found[issue.Path] = issue.Check
+	}
+	want := map[string]string{
+		"/etc/resolv.conf":              "resolv.conf",
+		"/etc/nsswitch.conf.uspin-orig": "resolv.conf",
+		"/var/cache eopkg":              "mounts",
+		"/etc/hosts":                    "hosts",
+	}
+	if len(found) != len(want) {
+		t.Fatalf("Incorrect issues: %v", issues)
+	}
+	for path, check := range want {
+		if found[path] != check {
+			t.Fatalf("Missing %v issue for %v: %v", check, path, issues)
+		}
+	}
+	if Error(issues) == nil || Error(nil) != nil {
+		t.Fatalf("Issues should only be an error when present")
+	}
+}
+
+func TestArch(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-lint")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// The test binary itself serves as the foreign executable
+	data, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to read test binary: %v", err)
+	}
+	os.MkdirAll(filepath.Join(root, "usr", "bin"), 00755)
+	ioutil.WriteFile(filepath.Join(root, "usr", "bin", "foreign"), data, 00755)
+	ioutil.WriteFile(filepath.Join(root, "usr", "bin", "script"), []byte("#!/bin/sh\n"), 00755)
+
+	arch := "aarch64"
+	if runtime.GOARCH == "arm64" {
+		arch = "x86_64"
+	}
+	issues, err := checkArch(root, arch)
+	if err != nil {
+		t.Fatalf("Failed to check architecture: %v", err)
+	}
+	if len(issues) != 1 || issues[0].Path != "/usr/bin/foreign" {
+		t.Fatalf("Incorrect architecture issues: %v", issues)
+	}
+}
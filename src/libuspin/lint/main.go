@@ -0,0 +1,52 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package lint inspects the finished rootfs for problems that would only
+// otherwise be discovered once the image is booted.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An Issue is a single problem found within the rootfs
+type Issue struct {
+	Check   string // Name of the check reporting the issue
+	Path    string // Absolute path within the image, if any
+	Message string
+}
+
+func (i *Issue) String() string {
+	if i.Path == "" {
+		return fmt.Sprintf("[%v] %v", i.Check, i.Message)
+	}
+	return fmt.Sprintf("[%v] %v: %v", i.Check, i.Path, i.Message)
+}
+
+// Error combines the issues into a single error, or nil if there are none
+func Error(issues []*Issue) error {
+	if len(issues) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, issue := range issues {
+		lines = append(lines, issue.String())
+	}
+	sort.Strings(lines)
+	return fmt.Errorf("Image failed %d lint checks:\n  %v", len(issues), strings.Join(lines, "\n  "))
+}
@@ -0,0 +1,146 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package lock provides host wide file locks around resources shared between
+// uspin processes, such as workspaces and output directories.
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LockDir holds the lock files of every uspin process on the host
+var LockDir = "/run/lock/uspin"
+
+var (
+	// ErrLocked is returned by TryAcquire when another process holds the lock
+	ErrLocked = errors.New("Resource is locked by another process")
+)
+
+// A Lock is an exclusive hold on a resource, until released
+type Lock struct {
+	Resource string // Absolute path of the locked resource
+	fd       *os.File
+}
+
+// lockPath returns the lock file for the resource. The path is hashed to
+// avoid any ambiguity, with the basename retained for the curious admin.
+func lockPath(resource string) string {
+	sum := sha256.Sum256([]byte(resource))
+	return filepath.Join(LockDir, fmt.Sprintf("%v-%v.lock", filepath.Base(resource), hex.EncodeToString(sum[:8])))
+}
+
+// Acquire will lock the resource, waiting for any other holder to release it
+func Acquire(resource string) (*Lock, error) {
+	return acquire(resource, true)
+}
+
+// TryAcquire will lock the resource, returning ErrLocked rather than wait
+func TryAcquire(resource string) (*Lock, error) {
+	return acquire(resource, false)
+}
+
+func acquire(resource string, wait bool) (*Lock, error) {
+	resource, err := filepath.Abs(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(LockDir, 00755); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(lockPath(resource), os.O_RDWR|os.O_CREATE, 00644)
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := tryLock(fd)
+	if err == nil && held {
+		if !wait {
+			fd.Close()
+			return nil, ErrLocked
+		}
+		log.WithFields(log.Fields{
+			"resource": resource,
+			"pid":      owner(fd),
+		}).Warning("Waiting for lock held by another uspin process")
+		err = waitLock(fd)
+	}
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("Failed to lock %v: %v", resource, err)
+	}
+
+	// Record ourselves as the owner, purely for diagnostics
+	if err := fd.Truncate(0); err == nil {
+		fd.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{Resource: resource, fd: fd}, nil
+}
+
+// Owner returns the pid of the process holding the lock on the resource, or
+// 0 if it is not locked.
+func Owner(resource string) (int, error) {
+	l, err := TryAcquire(resource)
+	if err == nil {
+		return 0, l.Release()
+	}
+	if err != ErrLocked {
+		return 0, err
+	}
+	resource, err = filepath.Abs(resource)
+	if err != nil {
+		return 0, err
+	}
+	fd, err := os.Open(lockPath(resource))
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	return owner(fd), nil
+}
+
+// owner returns the pid recorded in the lock file, if any
+func owner(fd *os.File) int {
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// Release will give up the lock, allowing another process to take it
+func (l *Lock) Release() error {
+	if l.fd == nil {
+		return nil
+	}
+	l.fd.Truncate(0)
+	err := unlock(l.fd)
+	if cerr := l.fd.Close(); err == nil {
+		err = cerr
+	}
+	l.fd = nil
+	return err
+}
@@ -0,0 +1,61 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-lock")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	LockDir = filepath.Join(dir, "locks")
+
+	workspace := filepath.Join(dir, "workspace")
+	l, err := TryAcquire(workspace)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	if _, err := TryAcquire(workspace); err != ErrLocked {
+		t.Fatalf("Held lock should not be acquired again: %v", err)
+	}
+	if pid, err := Owner(workspace); err != nil || pid != os.Getpid() {
+		t.Fatalf("Wrong owner %v: %v", pid, err)
+	}
+	other, err := TryAcquire(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatalf("Unrelated resources should not conflict: %v", err)
+	}
+	other.Release()
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	if pid, err := Owner(workspace); err != nil || pid != 0 {
+		t.Fatalf("Released lock still owned by %v: %v", pid, err)
+	}
+	if l, err = TryAcquire(workspace); err != nil {
+		t.Fatalf("Released lock should be available: %v", err)
+	}
+	l.Release()
+}
@@ -25,8 +25,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/solus-project/libosdev/pkg"
+	"libuspin/backend"
 	"libuspin/config"
+	"libuspin/seed"
 	"libuspin/spec"
+	"libuspin/uuid"
 	"path/filepath"
 	"strings"
 )
@@ -37,18 +40,53 @@ var (
 
 	// ErrUnknownOperation is returned when we don't know how to handle an operation
 	ErrUnknownOperation = errors.New("Unknown or unsupported operation requested")
+
+	// ErrRemoveUnsupported is returned when the package manager cannot remove packages
+	ErrRemoveUnsupported = errors.New("Package manager does not support removing packages")
 )
 
+// A PackageRemover is a package manager which can also remove packages
+type PackageRemover interface {
+
+	// RemovePackages will remove the named packages from the root. Packages
+	// depending on them are left installed when ignoreSafety is set.
+	RemovePackages(ignoreSafety bool, packages []string) error
+}
+
 // ImageSpec is a validated/loaded image configuration ready for building
 type ImageSpec struct {
-	Stack   *spec.OpStack
-	Config  *config.ImageConfiguration
-	BaseDir string // Used to join filename paths relative to the .spin file, i.e. packages
+	Stack    *spec.OpStack
+	Config   *config.ImageConfiguration
+	BaseDir  string // Used to join filename paths relative to the .spin file, i.e. packages
+	SpinFile string // Absolute path to the .spin file
+	IDs      *uuid.Generator
+	Staging  string // Artifacts are written here before being moved into place
+	Arch     string // Architecture being built, selects [arch=...] blocks in the Packages file
+	Profile  string // Profile being built, selects [profile=...] blocks in the Packages file
+
+	// Absolute paths of the Packages file and every file it includes
+	SpecFiles []string
 }
 
 // NewImageSpec is a factory function to load a .spin file with it's associated
 // Packages file prepped into a usable stack.
 func NewImageSpec(spinFile string) (*ImageSpec, error) {
+	is, err := loadImageSpec(spinFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := is.Config
+	if conf.Image.PackageManager == backend.PackageManagerAuto {
+		if conf.Image.PackageManager, err = is.detectPackageManager(conf.Image.Seed); err != nil {
+			return nil, fmt.Errorf("Failed to detect the package manager: %v", err)
+		}
+	}
+	return is, nil
+}
+
+// loadImageSpec will parse the .spin file and its Packages file without
+// looking at anything else, i.e. the seed.
+func loadImageSpec(spinFile string) (*ImageSpec, error) {
 	is := &ImageSpec{}
 
 	if !strings.HasSuffix(spinFile, ".spin") {
@@ -62,39 +100,174 @@ func NewImageSpec(spinFile string) (*ImageSpec, error) {
 	}
 
 	// Grab the base directory from the .spin file
-	is.BaseDir, err = filepath.Abs(filepath.Dir(spinFile))
+	is.SpinFile, err = filepath.Abs(spinFile)
 	if err != nil {
 		return nil, err
 	}
+	is.BaseDir = filepath.Dir(is.SpinFile)
 
-	// Load packages file relative to the spin file
+	is.Arch = conf.Image.Arch
+	if is.Profile = strings.TrimSpace(conf.Image.Profile); is.Profile == "" {
+		is.Profile = strings.TrimSuffix(filepath.Base(is.SpinFile), ".spin")
+	}
+
+	// Load packages file relative to the spin file, with only the operations
+	// that apply to this spin
 	parser := spec.NewParser()
+	parser.Arch = is.Arch
+	parser.Profile = is.Profile
+	parser.Variables = conf.Lookup
 	pkgsFile := filepath.Join(is.BaseDir, conf.Image.Packages)
 	if err = parser.Parse(pkgsFile); err != nil {
 		return nil, err
 	}
 
-	// Return new ImageSpec with our own copies
-	return &ImageSpec{
-		Stack:  parser.Stack,
-		Config: conf,
-	}, nil
+	is.Stack = parser.Stack
+	is.SpecFiles = parser.Files
+	is.Config = conf
+	is.IDs = uuid.NewGenerator(conf.IDs.Seed)
+	return is, nil
+}
+
+// detectPackageManager will detect the backend from the os-release of the
+// seed, or of the host if there is no seed
+func (i *ImageSpec) detectPackageManager(source string) (pkg.PackageManager, error) {
+	if source == "" {
+		return backend.Detect("")
+	}
+	s, err := seed.Resolve(source, i.BaseDir)
+	if err != nil {
+		return "", err
+	}
+	contents, err := s.Contents()
+	if err != nil {
+		return "", err
+	}
+	for _, c := range contents {
+		if name, err := backend.Detect(c); err != backend.ErrNoOSRelease {
+			return name, err
+		}
+	}
+	return "", fmt.Errorf("No os-release found in %v", source)
+}
+
+// JoinPath will resolve the given path relative to the .spin file
+func (i *ImageSpec) JoinPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(i.BaseDir, path)
+}
+
+// OutputTarget will return the configured filename of the image
+func (i *ImageSpec) OutputTarget() string {
+	switch i.Config.Image.Type {
+	case config.ImageTypeLiveOS, config.ImageTypeOCI, config.ImageTypeDisk:
+		return i.Config.Image.FileName
+	default:
+		return ""
+	}
+}
+
+// OutputFilename will return the filename of the image being produced,
+// within the staging directory if one has been set.
+func (i *ImageSpec) OutputFilename() string {
+	target := i.OutputTarget()
+	if i.Staging == "" || target == "" {
+		return target
+	}
+	return filepath.Join(i.Staging, filepath.Base(target))
+}
+
+// Repos will return all repository operations within the stack, in order
+func (i *ImageSpec) Repos() []*spec.OpRepo {
+	var ret []*spec.OpRepo
+	for _, opset := range i.Stack.Blocks {
+		for _, op := range opset.Ops {
+			if repo, ok := op.(*spec.OpRepo); ok {
+				ret = append(ret, repo)
+			}
+		}
+	}
+	return ret
+}
+
+// ResolveComponents will determine the concrete components of every group
+// within the ops that includes child components, so that they can be applied.
+func ResolveComponents(ops []spec.Operation, lister backend.ComponentLister) error {
+	var available []string
+	for _, op := range ops {
+		group, ok := op.(*spec.OpGroup)
+		if !ok || !group.Children {
+			continue
+		}
+		if available == nil {
+			var err error
+			if available, err = lister.Components(); err != nil {
+				return err
+			}
+		}
+		if err := group.ResolveComponents(available); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A BatchError is a transaction which failed while operations were being
+// applied in batches, identifying the batch and the names within it.
+type BatchError struct {
+	Batch   int      // Index of the failed batch, counting from 1
+	Batches int      // Total number of batches
+	Names   []string // Packages or groups within the failed batch
+	Err     error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("Batch %v of %v failed (%v): %v", e.Batch, e.Batches, strings.Join(e.Names, ", "), e.Err)
+}
+
+// applyBatches will pass the names to apply in batches of at most size names,
+// in order, stopping at the first batch to fail. A size of 0 or less applies
+// all of the names in a single transaction.
+func applyBatches(names []string, size int, apply func(names []string) error) error {
+	if size <= 0 || len(names) <= size {
+		return apply(names)
+	}
+	batches := (len(names) + size - 1) / size
+	for i := 0; i < batches; i++ {
+		end := (i + 1) * size
+		if end > len(names) {
+			end = len(names)
+		}
+		batch := names[i*size : end]
+		if err := apply(batch); err != nil {
+			return &BatchError{Batch: i + 1, Batches: batches, Names: batch, Err: err}
+		}
+	}
+	return nil
 }
 
 // ApplyOperations will apply the given spec operations against the package
-// manager instance
-func ApplyOperations(manager pkg.Manager, ops []spec.Operation) error {
+// manager instance, with at most batch packages or groups per transaction.
+func ApplyOperations(manager pkg.Manager, ops []spec.Operation, batch int) error {
 	if len(ops) == 0 {
 		return ErrNotEnoughOps
 	}
 	switch ops[0].(type) {
 	case *spec.OpRepo:
 		// Insert one repo at a time
+		var names []string
 		for _, op := range ops {
 			repo := op.(*spec.OpRepo)
 			if err := manager.AddRepo(repo.RepoName, repo.RepoURI); err != nil {
 				return err
 			}
+			names = append(names, repo.RepoName)
+		}
+		// Then fetch their metadata together, where the backend can
+		if refresher, ok := manager.(backend.RepoRefresher); ok {
+			return refresher.RefreshRepos(names)
 		}
 		return nil
 	case *spec.OpGroup:
@@ -102,9 +275,15 @@ func ApplyOperations(manager pkg.Manager, ops []spec.Operation) error {
 		ignoreSafety := ops[0].(*spec.OpGroup).IgnoreSafety
 		var names []string
 		for _, op := range ops {
-			names = append(names, op.(*spec.OpGroup).GroupName)
+			components, err := op.(*spec.OpGroup).Names()
+			if err != nil {
+				return err
+			}
+			names = append(names, components...)
 		}
-		return manager.InstallGroups(ignoreSafety, names)
+		return applyBatches(names, batch, func(names []string) error {
+			return manager.InstallGroups(ignoreSafety, names)
+		})
 	case *spec.OpPackage:
 		// Group/component goes in bulk
 		ignoreSafety := ops[0].(*spec.OpPackage).IgnoreSafety
@@ -112,7 +291,22 @@ func ApplyOperations(manager pkg.Manager, ops []spec.Operation) error {
 		for _, op := range ops {
 			names = append(names, op.(*spec.OpPackage).Name)
 		}
-		return manager.InstallPackages(ignoreSafety, names)
+		return applyBatches(names, batch, func(names []string) error {
+			return manager.InstallPackages(ignoreSafety, names)
+		})
+	case *spec.OpRemove:
+		remover, ok := manager.(PackageRemover)
+		if !ok {
+			return ErrRemoveUnsupported
+		}
+		ignoreSafety := ops[0].(*spec.OpRemove).IgnoreSafety
+		var names []string
+		for _, op := range ops {
+			names = append(names, op.(*spec.OpRemove).Name)
+		}
+		return applyBatches(names, batch, func(names []string) error {
+			return remover.RemovePackages(ignoreSafety, names)
+		})
 	default:
 		return ErrUnknownOperation
 	}
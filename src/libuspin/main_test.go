@@ -17,6 +17,18 @@
 package libuspin
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"libuspin/backend"
+	"libuspin/config"
+	"libuspin/signature"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -29,3 +41,525 @@ func TestImageSpec(t *testing.T) {
 		t.Fatalf("Cannot load image spec: %v", err)
 	}
 }
+
+func TestInputs(t *testing.T) {
+	inputs, err := ProfileInputs(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load inputs: %v", err)
+	}
+	var names []string
+	for _, path := range inputs {
+		if !filepath.IsAbs(path) {
+			t.Fatalf("Input is not absolute: %v", path)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	if !reflect.DeepEqual(names, []string{"minimal.packages", "minimal.spin"}) {
+		t.Fatalf("Incorrect inputs: %v", names)
+	}
+}
+
+func TestSignedTools(t *testing.T) {
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv is not available")
+	}
+	tmp, err := ioutil.TempDir("", "uspin-sign")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	home := filepath.Join(tmp, "gnupg")
+	if err := os.Mkdir(home, 00700); err != nil {
+		t.Fatalf("Cannot create gnupg home: %v", err)
+	}
+	defer os.Setenv("GNUPGHOME", os.Getenv("GNUPGHOME"))
+	os.Setenv("GNUPGHOME", home)
+	keyring := filepath.Join(tmp, "keyring.gpg")
+	for _, args := range [][]string{
+		{"--batch", "--passphrase", "", "--quick-gen-key", "uspin-test@example.com", "ed25519", "sign", "never"},
+		{"--batch", "--yes", "--output", keyring, "--export", "uspin-test@example.com"},
+	} {
+		if out, err := exec.Command("gpg", args...).CombinedOutput(); err != nil {
+			t.Skipf("Cannot create signing key: %v: %s", err, out)
+		}
+	}
+
+	profile := filepath.Join(tmp, "profile")
+	files := map[string]string{
+		"test.spin":        "format = 3\n\n[image]\npackages = \"test.packages\"\ntype = \"liveos\"\nfilename = \"test.iso\"\n\n[tools]\ndirectory = \"tools\"\n",
+		"test.packages":    "nano\n",
+		"tools/mksquashfs": "#!/bin/sh\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(profile, name)
+		os.MkdirAll(filepath.Dir(path), 00755)
+		if err := ioutil.WriteFile(path, []byte(contents), 00755); err != nil {
+			t.Fatalf("Cannot write %v: %v", name, err)
+		}
+	}
+	inputs, err := ProfileInputs(filepath.Join(profile, "test.spin"))
+	if err != nil {
+		t.Fatalf("Cannot load inputs: %v", err)
+	}
+	if err := signature.Sign(profile, "uspin-test@example.com", inputs); err != nil {
+		t.Fatalf("Cannot sign profile: %v", err)
+	}
+	if err := signature.VerifyDetached(profile, keyring, inputs); err != nil {
+		t.Fatalf("Signed profile should verify: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(profile, "tools/mksquashfs"), []byte("#!/bin/sh\nrm -rf /\n"), 00755); err != nil {
+		t.Fatalf("Cannot replace tool: %v", err)
+	}
+	if err := signature.VerifyDetached(profile, keyring, inputs); err == nil {
+		t.Fatalf("Changed tools should not verify")
+	}
+}
+
+func TestConfigureKeyKernel(t *testing.T) {
+	is, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	tmp, err := ioutil.TempDir("", "uspin-key")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	config := filepath.Join(tmp, "kernel.config")
+	if err := ioutil.WriteFile(config, []byte("CONFIG_EXT4_FS=y\n"), 00644); err != nil {
+		t.Fatalf("Cannot write kernel config: %v", err)
+	}
+	is.Config.Kernel.Config = config
+	before, err := is.ConfigureKey("rootfs")
+	if err != nil {
+		t.Fatalf("Cannot compute key: %v", err)
+	}
+	if err := ioutil.WriteFile(config, []byte("CONFIG_EXT4_FS=m\n"), 00644); err != nil {
+		t.Fatalf("Cannot write kernel config: %v", err)
+	}
+	after, err := is.ConfigureKey("rootfs")
+	if err != nil {
+		t.Fatalf("Cannot compute key: %v", err)
+	}
+	if before == after {
+		t.Fatalf("Key unchanged by the kernel config")
+	}
+}
+
+// writeProfile will write a profile including a second Packages file, with
+// a local repository so that its keys can be computed offline
+func writeProfile(t *testing.T, dir string) string {
+	files := map[string]string{
+		"test.spin": "format = 3\n\n[variables]\nFLAVOUR = \"lts\"\n\n" +
+			"[image]\npackages = \"test.packages\"\ntype = \"liveos\"\nfilename = \"${FLAVOUR}.iso\"\n",
+		"test.packages":  "Local = file://" + dir + "\n%include extra.packages\nlinux-${FLAVOUR}\n",
+		"extra.packages": "vim\n",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Cannot write %v: %v", name, err)
+		}
+	}
+	return filepath.Join(dir, "test.spin")
+}
+
+func TestKeysIncludes(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "uspin-key")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	spinFile := writeProfile(t, tmp)
+	keys := func() []string {
+		is, err := loadImageSpec(spinFile)
+		if err != nil {
+			t.Fatalf("Cannot load image spec: %v", err)
+		}
+		fp, err := is.Fingerprint()
+		if err != nil {
+			t.Fatalf("Cannot compute fingerprint: %v", err)
+		}
+		rootfs, err := is.RootfsKey()
+		if err != nil {
+			t.Fatalf("Cannot compute key: %v", err)
+		}
+		return []string{fp, rootfs}
+	}
+	before := keys()
+	if err := ioutil.WriteFile(filepath.Join(tmp, "extra.packages"), []byte("emacs\n"), 00644); err != nil {
+		t.Fatalf("Cannot write extra.packages: %v", err)
+	}
+	after := keys()
+	for n := range before {
+		if before[n] == after[n] {
+			t.Fatalf("Key %d unchanged by an included file", n)
+		}
+	}
+}
+
+func TestKeysVariables(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "uspin-key")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	spinFile := writeProfile(t, tmp)
+	defer delete(config.Overrides, "FLAVOUR")
+	keys := func(flavour string) []string {
+		config.Overrides["FLAVOUR"] = flavour
+		is, err := loadImageSpec(spinFile)
+		if err != nil {
+			t.Fatalf("Cannot load image spec: %v", err)
+		}
+		fp, err := is.Fingerprint()
+		if err != nil {
+			t.Fatalf("Cannot compute fingerprint: %v", err)
+		}
+		rootfs, err := is.RootfsKey()
+		if err != nil {
+			t.Fatalf("Cannot compute key: %v", err)
+		}
+		configure, err := is.ConfigureKey(rootfs)
+		if err != nil {
+			t.Fatalf("Cannot compute key: %v", err)
+		}
+		return []string{fp, rootfs, configure}
+	}
+	before := keys("lts")
+	after := keys("current")
+	for n := range before {
+		if before[n] == after[n] {
+			t.Fatalf("Key %d unchanged by a variable", n)
+		}
+	}
+}
+
+type fakeExpander struct{}
+
+func (f *fakeExpander) ExpandGroup(name string) ([]string, error) {
+	return []string{"bash", "glibc"}, nil
+}
+
+func TestPlan(t *testing.T) {
+	img, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	plan := NewPlan(img)
+	if len(plan.Steps) != 4 {
+		t.Fatalf("Incorrect number of plan steps: %v", len(plan.Steps))
+	}
+	if plan.Steps[0].Kind != PlanStepRepo || plan.Steps[0].URIs[0] == "" {
+		t.Fatalf("First step should add the repo")
+	}
+	if !plan.Steps[1].IgnoreSafety {
+		t.Fatalf("baselayout should ignore safety")
+	}
+	if !reflect.DeepEqual(plan.Artifacts, []string{"Solus-1.2.1.iso"}) {
+		t.Fatalf("Incorrect plan artifacts: %v", plan.Artifacts)
+	}
+	if err := plan.ExpandGroups(&fakeExpander{}); err != nil {
+		t.Fatalf("Failed to expand groups: %v", err)
+	}
+	if len(plan.Expansions["system.base"]) != 2 {
+		t.Fatalf("Group expansion missing from plan")
+	}
+}
+
+func (f *fakeExpander) Dependencies(name string) ([]string, error) {
+	if name == "bash" {
+		return []string{"ncurses", "readline"}, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeExpander) Close() error {
+	return nil
+}
+
+func TestRehearse(t *testing.T) {
+	img, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	plan := NewPlan(img)
+	if err := plan.Rehearse(img, &fakeExpander{}); err != nil {
+		t.Fatalf("Failed to rehearse plan: %v", err)
+	}
+	kinds := []backend.TransactionKind{
+		backend.TransactionAddRepo,
+		backend.TransactionInstallPackages,
+		backend.TransactionInstallGroups,
+		backend.TransactionInstallPackages,
+	}
+	if len(plan.Transactions) != len(kinds) {
+		t.Fatalf("Incorrect number of transactions: %v", len(plan.Transactions))
+	}
+	for i, kind := range kinds {
+		if plan.Transactions[i].Kind != kind {
+			t.Fatalf("Transaction %v is %v, expected %v", i, plan.Transactions[i].Kind, kind)
+		}
+	}
+	if !plan.Transactions[1].IgnoreSafety || plan.Transactions[3].IgnoreSafety {
+		t.Fatalf("Safety flags were not carried through")
+	}
+	if !reflect.DeepEqual(plan.Transactions[2].Packages, []string{"bash", "glibc"}) {
+		t.Fatalf("Group was not expanded: %v", plan.Transactions[2].Packages)
+	}
+	if len(plan.Transactions[3].Names) != 3 {
+		t.Fatalf("Packages should be installed in one batch: %v", plan.Transactions[3].Names)
+	}
+}
+
+func TestDependencyGraph(t *testing.T) {
+	img, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	plan := NewPlan(img)
+	if err := plan.ExpandGroups(&fakeExpander{}); err != nil {
+		t.Fatalf("Failed to expand groups: %v", err)
+	}
+	if err := plan.ResolveDependencies(&fakeExpander{}); err != nil {
+		t.Fatalf("Failed to resolve dependencies: %v", err)
+	}
+	// 4 explicit packages, 2 from the group, 2 dependencies of bash
+	if len(plan.Dependencies) != 8 {
+		t.Fatalf("Incorrect dependency graph size: %v", len(plan.Dependencies))
+	}
+	if err := plan.WriteGraph(ioutil.Discard, GraphFormat("svg")); err == nil {
+		t.Fatalf("Should not support unknown graph formats")
+	}
+}
+
+func TestWhy(t *testing.T) {
+	img, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	plan := NewPlan(img)
+	plan.ExpandGroups(&fakeExpander{})
+	plan.ResolveDependencies(&fakeExpander{})
+
+	reasons := plan.Why("readline")
+	if len(reasons) != 1 {
+		t.Fatalf("Expected a single reason for readline, got %v", len(reasons))
+	}
+	if reasons[0].Group != "system.base" || len(reasons[0].Path) != 2 {
+		t.Fatalf("Incorrect reason for readline: %v", reasons[0])
+	}
+	if reasons := plan.Why("kernel"); len(reasons) != 1 || !reasons[0].Explicit {
+		t.Fatalf("kernel should be explicitly installed")
+	}
+	if reasons := plan.Why("firefox"); len(reasons) != 0 {
+		t.Fatalf("firefox should not be in the plan")
+	}
+}
+
+func TestDownloadLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-downloads")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	lockfile := filepath.Join(dir, "minimal.downloads")
+	lock := &DownloadLock{Downloads: []*backend.Download{
+		{URL: "https://example.com/eopkg-index.xml.xz"},
+		{URL: "https://example.com/n/nano/nano.eopkg", Size: 10, SHA256: "abc"},
+	}}
+	if err := lock.Write(lockfile); err != nil {
+		t.Fatalf("Cannot write lockfile: %v", err)
+	}
+	if lock, err = LoadDownloadLock(lockfile); err != nil {
+		t.Fatalf("Cannot load lockfile: %v", err)
+	}
+
+	downloads := []*backend.Download{
+		{URL: "https://example.com/eopkg-index.xml.xz"},
+		{URL: "https://example.com/n/nano/nano.eopkg", Size: 10, SHA256: "abc"},
+	}
+	if problems := lock.Verify(downloads); len(problems) != 0 {
+		t.Fatalf("Declared downloads should verify: %v", problems)
+	}
+	downloads[1].SHA256 = "def"
+	downloads = append(downloads, &backend.Download{URL: "https://evil.com/x.eopkg"})
+	if problems := lock.Verify(downloads); len(problems) != 2 {
+		t.Fatalf("Expected a mismatch and an undeclared download: %v", problems)
+	}
+}
+
+func TestSafetyBypasses(t *testing.T) {
+	img, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	plan := NewPlan(img)
+	if err := plan.RecordSafety(img.Config.Safety.Essential, &fakeExpander{}); err != nil {
+		t.Fatalf("Failed to record safety: %v", err)
+	}
+	if len(plan.Safety) != 1 || plan.Safety[0].Step != 2 || plan.Safety[0].Names[0] != "baselayout" {
+		t.Fatalf("baselayout should be the only step ignoring safety: %+v", plan.Safety)
+	}
+	bypasses := plan.SafetyBypasses([]string{"baselayout", "glibc"})
+	if len(bypasses[0].Essential) != 1 || bypasses[0].Essential[0] != "baselayout" {
+		t.Fatalf("baselayout should be reported as essential: %+v", bypasses[0])
+	}
+}
+
+func TestUnexplainedProblems(t *testing.T) {
+	img, err := NewImageSpec(minimalFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	plan := NewPlan(img)
+	problems := []*backend.DatabaseProblem{
+		{Package: "baselayout", Message: "Missing file: /etc/profile"},
+		{Package: "nano", Message: "Corrupted file: /usr/bin/nano"},
+		{Message: "dependency problems found"},
+	}
+	unexplained := plan.UnexplainedProblems(problems)
+	if len(unexplained) != 2 || unexplained[0].Package != "nano" || unexplained[1].Package != "" {
+		t.Fatalf("Only the package ignoring safety should be exempt: %v", unexplained)
+	}
+}
+
+func TestLeafReport(t *testing.T) {
+	installed := []string{"bash", "glibc", "nano", "ncurses", "perl"}
+	deps := map[string][]string{
+		"bash":    {"glibc", "ncurses"},
+		"glibc":   {"glibc"},
+		"nano":    {"ncurses"},
+		"ncurses": {"glibc"},
+		"perl":    {"glibc"},
+		"vim":     {"perl"}, // Removed, so perl is an orphan
+	}
+	r := NewLeafReport(installed, deps, []string{"bash", "nano"})
+	if !reflect.DeepEqual(r.Leaves, []string{"bash", "nano"}) {
+		t.Fatalf("Wrong leaves: %v", r.Leaves)
+	}
+	if !reflect.DeepEqual(r.Orphans, []string{"perl"}) {
+		t.Fatalf("Wrong orphans: %v", r.Orphans)
+	}
+}
+
+type failingExpander struct {
+	fail string
+}
+
+func (f *failingExpander) ExpandGroup(name string) ([]string, error) {
+	if name == f.fail {
+		return nil, fmt.Errorf("Unknown group: %v", name)
+	}
+	return []string{name}, nil
+}
+
+func (f *failingExpander) Close() error {
+	return nil
+}
+
+func TestApplyBatches(t *testing.T) {
+	var ops []spec.Operation
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		ops = append(ops, &spec.OpPackage{Name: name})
+	}
+	m := backend.NewResolveOnly(nil)
+	if err := ApplyOperations(m, ops, 2); err != nil {
+		t.Fatalf("Failed to apply operations: %v", err)
+	}
+	batches := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if len(m.Transactions) != len(batches) {
+		t.Fatalf("Incorrect number of transactions: %v", len(m.Transactions))
+	}
+	for i, names := range batches {
+		if !reflect.DeepEqual(m.Transactions[i].Names, names) {
+			t.Fatalf("Batch %v contains %v, expected %v", i, m.Transactions[i].Names, names)
+		}
+	}
+
+	var groups []spec.Operation
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		groups = append(groups, &spec.OpGroup{GroupName: name})
+	}
+	m = backend.NewResolveOnly(&failingExpander{fail: "c"})
+	err := ApplyOperations(m, groups, 2)
+	batch, ok := err.(*BatchError)
+	if !ok {
+		t.Fatalf("Expected a batch error, got: %v", err)
+	}
+	if batch.Batch != 2 || batch.Batches != 3 || !reflect.DeepEqual(batch.Names, []string{"c", "d"}) {
+		t.Fatalf("Failure attributed to the wrong batch: %v", batch)
+	}
+	if len(m.Transactions) != 1 {
+		t.Fatalf("Batches after the failure should not be applied: %v", len(m.Transactions))
+	}
+}
+
+type fakeRoot struct {
+	versions map[string]string
+}
+
+func (f *fakeRoot) InstalledPackages() ([]string, error) {
+	return []string{"bash", "nano", "tzdata"}, nil
+}
+
+func (f *fakeRoot) Version(name string) (string, error) {
+	return f.versions[name], nil
+}
+
+func (f *fakeRoot) Origin(name string) (string, error) {
+	if name == "tzdata" {
+		return "", nil
+	}
+	return "https://example.com/repo", nil
+}
+
+func (f *fakeRoot) Close() error {
+	return nil
+}
+
+func TestPackageManifest(t *testing.T) {
+	root := &fakeRoot{versions: map[string]string{
+		"bash":   "5.2.021_1",
+		"nano":   "2.7.1-60",
+		"tzdata": "2024a",
+	}}
+	m, err := NewPackageManifest(root)
+	if err != nil {
+		t.Fatalf("Failed to create manifest: %v", err)
+	}
+	want := []*ManifestPackage{
+		{Name: "bash", Version: "5.2.021", Release: "1", Repo: "https://example.com/repo"},
+		{Name: "nano", Version: "2.7.1", Release: "60", Repo: "https://example.com/repo"},
+		{Name: "tzdata", Version: "2024a"},
+	}
+	if !reflect.DeepEqual(m.Packages, want) {
+		t.Fatalf("Wrong packages: %+v", m.Packages)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	text := "bash 5.2.021-1 https://example.com/repo\nnano 2.7.1-60 https://example.com/repo\ntzdata 2024a\n"
+	if buf.String() != text {
+		t.Fatalf("Wrong plain text manifest: %q", buf.String())
+	}
+
+	m.Groups = map[string][]string{"system.base": {"bash", "tzdata"}, "editors": {"nano"}}
+	buf.Reset()
+	if err := m.Write(&buf); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if want := text + "@editors nano\n@system.base bash tzdata\n"; buf.String() != want {
+		t.Fatalf("Wrong group expansions in manifest: %q", buf.String())
+	}
+	buf.Reset()
+	if err := m.WriteJSON(&buf); err != nil || !strings.Contains(buf.String(), `"groupExpansions"`) {
+		t.Fatalf("Group expansions missing from JSON manifest: %v", err)
+	}
+
+	if _, err := NewPackageManifest(&failingExpander{}); err != backend.ErrUnsupportedQuery {
+		t.Fatalf("Manifest needs installed packages and versions: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"libuspin/backend"
+	"sort"
+	"strings"
+)
+
+// A ManifestPackage records a single package installed within the image
+type ManifestPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Release string `json:"release,omitempty"`
+	Repo    string `json:"repo,omitempty"` // Unset when the package database does not record it
+}
+
+// A PackageManifest lists every package installed within the image, so that
+// releases may be compared
+type PackageManifest struct {
+	Packages []*ManifestPackage `json:"packages"`
+
+	// Groups mapped to the packages they expanded to when the build was
+	// planned, if the package manager could expand them
+	Groups map[string][]string `json:"groupExpansions,omitempty"`
+}
+
+// splitVersion will split the full version reported by a backend into the
+// version and release, which every supported manager separates with either
+// "-" or "_", i.e. "2.7.1-60", "1.36.1-r5" or "5.2.21_1"
+func splitVersion(full string) (string, string) {
+	if i := strings.LastIndexAny(full, "-_"); i > 0 {
+		return full[:i], full[i+1:]
+	}
+	return full, ""
+}
+
+// NewPackageManifest will record every package installed within the root
+// of the query, which must be able to list the packages and their versions
+func NewPackageManifest(query backend.Query) (*PackageManifest, error) {
+	lister, ok := query.(backend.PackageLister)
+	if !ok {
+		return nil, backend.ErrUnsupportedQuery
+	}
+	versions, ok := query.(backend.VersionReporter)
+	if !ok {
+		return nil, backend.ErrUnsupportedQuery
+	}
+	origins, _ := query.(backend.OriginReporter)
+
+	names, err := lister.InstalledPackages()
+	if err != nil {
+		return nil, err
+	}
+	m := &PackageManifest{}
+	for _, name := range names {
+		full, err := versions.Version(name)
+		if err != nil {
+			return nil, err
+		}
+		p := &ManifestPackage{Name: name}
+		p.Version, p.Release = splitVersion(full)
+		if origins != nil {
+			if p.Repo, err = origins.Origin(name); err != nil {
+				return nil, err
+			}
+		}
+		m.Packages = append(m.Packages, p)
+	}
+	return m, nil
+}
+
+// Write will emit the manifest as plain text, one package per line sorted
+// by name, followed by one line per group listing its packages. Columns are
+// deliberately not aligned, so that comparing releases with diff only shows
+// the packages which changed.
+func (m *PackageManifest) Write(w io.Writer) error {
+	for _, p := range m.Packages {
+		fields := []string{p.Name, p.Version}
+		if p.Release != "" {
+			fields[1] += "-" + p.Release
+		}
+		if p.Repo != "" {
+			fields = append(fields, p.Repo)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, " ")); err != nil {
+			return err
+		}
+	}
+	var groups []string
+	for name := range m.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		if _, err := fmt.Fprintf(w, "@%v %v\n", name, strings.Join(m.Groups[name], " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON will emit the manifest in JSON format for machine consumption
+func (m *PackageManifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(m)
+}
@@ -0,0 +1,113 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package overlay
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Attributes are the file properties that git cannot represent. Unset fields
+// are left untouched.
+type Attributes struct {
+	Owner        string `toml:"owner"`        // User name or uid within the image
+	Group        string `toml:"group"`        // Group name or gid within the image
+	Mode         string `toml:"mode"`         // Octal mode, i.e. "4755"
+	Capabilities string `toml:"capabilities"` // File capabilities in setcap format, i.e. "cap_net_raw+ep"
+	SELinux      string `toml:"selinux"`      // SELinux label, i.e. "system_u:object_r:bin_t:s0"
+}
+
+// lookupID will resolve the user or group name using the database within the
+// root, i.e. etc/passwd, accepting numeric IDs as is.
+func lookupID(root, database, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	fi, err := os.Open(filepath.Join(root, "etc", database))
+	if err != nil {
+		return -1, err
+	}
+	defer fi.Close()
+	sc := bufio.NewScanner(fi)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		return strconv.Atoi(fields[2])
+	}
+	return -1, fmt.Errorf("Unknown name in %v: %v", database, name)
+}
+
+// Validate will check the attributes can be parsed without touching any files
+func (a *Attributes) Validate() error {
+	if a.Mode != "" {
+		if _, err := strconv.ParseUint(a.Mode, 8, 32); err != nil {
+			return fmt.Errorf("Invalid mode: %v", a.Mode)
+		}
+	}
+	return nil
+}
+
+// Apply will set the attributes on path, which lives within root. Ownership is
+// applied first as changing it clears file capabilities.
+func (a *Attributes) Apply(root, path string) error {
+	if a.Owner != "" || a.Group != "" {
+		uid, gid := -1, -1
+		var err error
+		if a.Owner != "" {
+			if uid, err = lookupID(root, "passwd", a.Owner); err != nil {
+				return err
+			}
+		}
+		if a.Group != "" {
+			if gid, err = lookupID(root, "group", a.Group); err != nil {
+				return err
+			}
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	if a.Mode != "" {
+		mode, err := strconv.ParseUint(a.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid mode: %v", a.Mode)
+		}
+		// syscall.Chmod as os.Chmod won't set the setuid/setgid/sticky bits
+		if err := syscall.Chmod(path, uint32(mode)); err != nil {
+			return err
+		}
+	}
+	if a.Capabilities != "" {
+		if err := commands.ExecStdoutArgs("setcap", []string{a.Capabilities, path}); err != nil {
+			return err
+		}
+	}
+	if a.SELinux != "" {
+		if err := setLabel(path, a.SELinux); err != nil {
+			return err
+		}
+	}
+	return nil
+}
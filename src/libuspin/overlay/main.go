@@ -0,0 +1,144 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package overlay installs a directory tree from the profile over the rootfs,
+// i.e. configuration files kept in git alongside the .spin file.
+//
+// Since git cannot represent ownership, setuid modes, capabilities or SELinux
+// labels, overlay files are installed as root:root with their own mode, and
+// a metadata sidecar can declare anything else per file:
+//
+//	[[file]]
+//	path = "/usr/bin/ping"
+//	mode = "4755"
+//	capabilities = "cap_net_raw+ep"
+package overlay
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"libuspin/tree"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MetaSuffix is appended to the overlay directory to find the default sidecar
+const MetaSuffix = ".meta.toml"
+
+// A FileMeta declares the attributes of a single path within the overlay
+type FileMeta struct {
+	Path string `toml:"path"` // Absolute path within the image
+	Attributes
+}
+
+// Metadata is the sidecar describing the overlay files
+type Metadata struct {
+	Files []*FileMeta `toml:"file"`
+}
+
+// LoadMetadata will load the sidecar at the given path
+func LoadMetadata(path string) (*Metadata, error) {
+	m := &Metadata{}
+	if _, err := toml.DecodeFile(path, m); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if !strings.HasPrefix(f.Path, "/") {
+			return nil, fmt.Errorf("Overlay metadata paths must be absolute: %v", f.Path)
+		}
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("%v: %v", f.Path, err)
+		}
+	}
+	return m, nil
+}
+
+// Apply will install the overlay directory into root, replacing any existing
+// files, then apply the metadata, which may be nil.
+func Apply(dir, root string, meta *Metadata) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return install(path, filepath.Join(root, rel), info)
+	})
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+	for _, f := range meta.Files {
+		target := filepath.Join(root, strings.TrimPrefix(f.Path, "/"))
+		if _, err := os.Lstat(target); err != nil {
+			return fmt.Errorf("Overlay metadata for missing file: %v", f.Path)
+		}
+		if err := f.Apply(root, target); err != nil {
+			return fmt.Errorf("Failed to apply metadata to %v: %v", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// install will place a single overlay entry at target as root:root
+func install(path, target string, info os.FileInfo) error {
+	if info.IsDir() {
+		// Existing directories keep their attributes
+		if st, err := os.Lstat(target); err == nil && st.IsDir() {
+			return nil
+		}
+		if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Lchown(target, 0, 0); err != nil {
+			return err
+		}
+		return restoreMode(target, info)
+	}
+
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+		return os.Lchown(target, 0, 0)
+	}
+	if err := tree.CopyFile(path, target); err != nil {
+		return err
+	}
+	if err := os.Lchown(target, 0, 0); err != nil {
+		return err
+	}
+	return restoreMode(target, info)
+}
+
+// restoreMode will reapply the full source mode to target, as mkdir is
+// subject to the umask and chown clears the setuid and setgid bits.
+func restoreMode(target string, info os.FileInfo) error {
+	mode := info.Mode()
+	return os.Chmod(target, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
@@ -0,0 +1,182 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package overlay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-overlay")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	overlay := filepath.Join(dir, "overlay")
+	root := filepath.Join(dir, "root")
+	files := map[string]string{
+		"overlay/etc/motd":     "Welcome\n",
+		"overlay/usr/bin/ping": "#!/bin/sh\n",
+		"root/etc/motd":        "Old\n",
+		"root/etc/passwd":      "root:x:0:0::/root:/bin/bash\nmessagebus:x:18:18::/:/bin/false\n",
+		"root/etc/group":       "root:x:0:\nmessagebus:x:18:\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 00755)
+		if err := ioutil.WriteFile(path, []byte(content), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	metaPath := filepath.Join(dir, "overlay"+MetaSuffix)
+	meta := `
+[[file]]
+path = "/usr/bin/ping"
+owner = "messagebus"
+group = "18"
+mode = "4755"
+`
+	if err := ioutil.WriteFile(metaPath, []byte(meta), 00644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	m, err := LoadMetadata(metaPath)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if err := Apply(overlay, root, m); err != nil {
+		t.Fatalf("Failed to apply overlay: %v", err)
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "etc/motd")); string(data) != "Welcome\n" {
+		t.Fatalf("Overlay file not installed: %q", data)
+	}
+	info, err := os.Stat(filepath.Join(root, "usr/bin/ping"))
+	if err != nil {
+		t.Fatalf("Overlay file missing: %v", err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Mode&07777 != 04755 {
+		t.Fatalf("Incorrect mode: %o", st.Mode&07777)
+	}
+	if os.Getuid() == 0 && (st.Uid != 18 || st.Gid != 18) {
+		t.Fatalf("Incorrect ownership: %d:%d", st.Uid, st.Gid)
+	}
+}
+
+func TestOverlayModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-overlay")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	overlay := filepath.Join(dir, "overlay")
+	root := filepath.Join(dir, "root")
+	shared := filepath.Join(overlay, "srv/shared")
+	tool := filepath.Join(overlay, "usr/bin/tool")
+	os.MkdirAll(shared, 00755)
+	os.MkdirAll(filepath.Dir(tool), 00755)
+	os.MkdirAll(root, 00755)
+	if err := ioutil.WriteFile(tool, []byte("#!/bin/sh\n"), 00755); err != nil {
+		t.Fatalf("Failed to write tool: %v", err)
+	}
+	os.Chmod(shared, 00775|os.ModeSetgid)
+	os.Chmod(tool, 00755|os.ModeSetuid)
+
+	if err := Apply(overlay, root, nil); err != nil {
+		t.Fatalf("Failed to apply overlay: %v", err)
+	}
+	for path, want := range map[string]uint32{"srv/shared": 02775, "usr/bin/tool": 04755} {
+		info, err := os.Stat(filepath.Join(root, path))
+		if err != nil {
+			t.Fatalf("Overlay entry missing: %v", err)
+		}
+		if mode := info.Sys().(*syscall.Stat_t).Mode & 07777; mode != want {
+			t.Fatalf("Incorrect mode for %v: %o", path, mode)
+		}
+	}
+}
+
+func TestMetadataValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-overlay")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bad"+MetaSuffix)
+	ioutil.WriteFile(path, []byte("[[file]]\npath = \"usr/bin/ping\"\n"), 00644)
+	if _, err := LoadMetadata(path); err == nil {
+		t.Fatalf("Relative paths should be rejected")
+	}
+	ioutil.WriteFile(path, []byte("[[file]]\npath = \"/usr/bin/ping\"\nmode = \"rwx\"\n"), 00644)
+	if _, err := LoadMetadata(path); err == nil {
+		t.Fatalf("Invalid modes should be rejected")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-overlay")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "usr/bin"), 00755)
+	for _, name := range []string{"usr/bin/ping", "usr/bin/su", "usr/bin/nano"} {
+		ioutil.WriteFile(filepath.Join(root, name), nil, 00755)
+	}
+
+	rules := []*Rule{
+		{Path: "/usr/bin/*", Attributes: Attributes{Mode: "755"}},
+		{Path: "/usr/bin/su", Attributes: Attributes{Mode: "4755"}},
+		{Path: "/usr/sbin/*", Attributes: Attributes{Mode: "700"}},
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			t.Fatalf("Valid rule rejected: %v", err)
+		}
+	}
+	report, err := ApplyPermissions(root, rules)
+	if err != nil {
+		t.Fatalf("Failed to apply permissions: %v", err)
+	}
+	if report.Applied != 3 {
+		t.Fatalf("Expected 3 paths changed, got %v", report.Applied)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].Path != "/usr/bin/su" {
+		t.Fatalf("Incorrect conflicts: %v", report.Conflicts)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0] != "/usr/sbin/*" {
+		t.Fatalf("Incorrect unmatched rules: %v", report.Unmatched)
+	}
+	info, _ := os.Stat(filepath.Join(root, "usr/bin/su"))
+	if info.Sys().(*syscall.Stat_t).Mode&07777 != 04755 {
+		t.Fatalf("Later rule should win")
+	}
+	if err := (&Rule{Path: "usr/bin"}).Validate(); err == nil {
+		t.Fatalf("Relative rule should be rejected")
+	}
+}
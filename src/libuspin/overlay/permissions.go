@@ -0,0 +1,145 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package overlay
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Rule applies Attributes to every path within the image matching the glob
+type Rule struct {
+	Path string `toml:"path"` // Absolute glob within the image, i.e. "/usr/bin/*"
+	Attributes
+}
+
+// Validate will ensure the rule is usable
+func (r *Rule) Validate() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("Permission paths must be absolute: %v", r.Path)
+	}
+	if _, err := filepath.Match(r.Path, ""); err != nil {
+		return fmt.Errorf("Invalid permission glob %v: %v", r.Path, err)
+	}
+	return r.Attributes.Validate()
+}
+
+// A Conflict is a path where two rules disagree on an attribute. The later
+// rule always wins.
+type Conflict struct {
+	Path      string
+	Attribute string
+	Rules     [2]string // Globs of the earlier and later rule
+	Values    [2]string
+}
+
+func (c *Conflict) String() string {
+	return fmt.Sprintf("%v: %v is %q by %v but %q by %v", c.Path, c.Attribute,
+		c.Values[0], c.Rules[0], c.Values[1], c.Rules[1])
+}
+
+// A PermissionReport describes the application of the permission rules
+type PermissionReport struct {
+	Applied   int         // Number of paths changed
+	Conflicts []*Conflict // Rules disagreeing with each other
+	Unmatched []string    // Rules which matched nothing
+}
+
+// setting is a single attribute value along with the rule that set it
+type setting struct {
+	value string
+	rule  string
+}
+
+// ApplyPermissions will apply the rules, in order, to the rootfs
+func ApplyPermissions(root string, rules []*Rule) (*PermissionReport, error) {
+	report := &PermissionReport{}
+	settings := make(map[string]map[string]*setting) // Path to attribute settings
+
+	for _, rule := range rules {
+		matches, err := filepath.Glob(filepath.Join(root, strings.TrimPrefix(rule.Path, "/")))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			report.Unmatched = append(report.Unmatched, rule.Path)
+			continue
+		}
+		values := map[string]string{
+			"owner":        rule.Owner,
+			"group":        rule.Group,
+			"mode":         rule.Mode,
+			"capabilities": rule.Capabilities,
+			"selinux":      rule.SELinux,
+		}
+		for _, match := range matches {
+			attrs, ok := settings[match]
+			if !ok {
+				attrs = make(map[string]*setting)
+				settings[match] = attrs
+			}
+			for name, value := range values {
+				if value == "" {
+					continue
+				}
+				if prev, ok := attrs[name]; ok && prev.value != value {
+					rel, _ := filepath.Rel(root, match)
+					report.Conflicts = append(report.Conflicts, &Conflict{
+						Path:      "/" + rel,
+						Attribute: name,
+						Rules:     [2]string{prev.rule, rule.Path},
+						Values:    [2]string{prev.value, value},
+					})
+				}
+				attrs[name] = &setting{value: value, rule: rule.Path}
+			}
+		}
+	}
+
+	// Stable ordering for application and reporting
+	var paths []string
+	for path := range settings {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	sort.Slice(report.Conflicts, func(i, j int) bool {
+		return report.Conflicts[i].Path < report.Conflicts[j].Path
+	})
+
+	for _, path := range paths {
+		get := func(name string) string {
+			if s, ok := settings[path][name]; ok {
+				return s.value
+			}
+			return ""
+		}
+		attrs := &Attributes{
+			Owner:        get("owner"),
+			Group:        get("group"),
+			Mode:         get("mode"),
+			Capabilities: get("capabilities"),
+			SELinux:      get("selinux"),
+		}
+		if err := attrs.Apply(root, path); err != nil {
+			return nil, fmt.Errorf("Failed to apply permissions to %v: %v", path, err)
+		}
+		report.Applied++
+	}
+	return report, nil
+}
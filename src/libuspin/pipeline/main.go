@@ -0,0 +1,185 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package pipeline runs a build as an ordered set of named stages, allowing
+// embedders to insert their own stages, skip stages, or observe progress
+// between them.
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Stage is a single named step of the pipeline
+type Stage struct {
+	Name string
+	Run  func() error
+}
+
+// An EventKind is a transition of a stage
+type EventKind string
+
+const (
+	// EventStart is emitted before a stage runs
+	EventStart EventKind = "start"
+
+	// EventFinish is emitted once a stage has run successfully
+	EventFinish EventKind = "finish"
+
+	// EventFail is emitted when a stage fails, stopping the pipeline
+	EventFail EventKind = "fail"
+
+	// EventSkip is emitted in place of running a skipped stage
+	EventSkip EventKind = "skip"
+)
+
+// An Event describes the progress of the pipeline
+type Event struct {
+	Kind  EventKind
+	Stage string
+	Index int   // Position of the stage within the pipeline
+	Err   error // Only set for EventFail
+}
+
+// An Observer is notified of every event of the pipeline, in order
+type Observer func(e *Event)
+
+// A Pipeline is an ordered set of uniquely named stages
+type Pipeline struct {
+	stages    []*Stage
+	skipped   map[string]bool
+	observers []Observer
+}
+
+// New will return a pipeline of the given stages, in order
+func New(stages ...*Stage) (*Pipeline, error) {
+	p := &Pipeline{skipped: make(map[string]bool)}
+	for _, st := range stages {
+		if err := p.insert(len(p.stages), st); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Names returns the names of the stages, in order
+func (p *Pipeline) Names() []string {
+	var ret []string
+	for _, st := range p.stages {
+		ret = append(ret, st.Name)
+	}
+	return ret
+}
+
+// Index returns the position of the named stage within the pipeline
+func (p *Pipeline) Index(name string) (int, error) {
+	for i, st := range p.stages {
+		if st.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("Unknown stage '%v', expected one of: %v", name, strings.Join(p.Names(), ", "))
+}
+
+// insert will place the stage at the given position
+func (p *Pipeline) insert(index int, st *Stage) error {
+	if st.Name == "" || st.Run == nil {
+		return fmt.Errorf("Stage '%v' requires a name and a function to run", st.Name)
+	}
+	if _, err := p.Index(st.Name); err == nil {
+		return fmt.Errorf("Duplicate stage: %v", st.Name)
+	}
+	p.stages = append(p.stages, nil)
+	copy(p.stages[index+1:], p.stages[index:])
+	p.stages[index] = st
+	return nil
+}
+
+// InsertBefore will run the stage immediately before the named stage
+func (p *Pipeline) InsertBefore(name string, st *Stage) error {
+	i, err := p.Index(name)
+	if err != nil {
+		return err
+	}
+	return p.insert(i, st)
+}
+
+// InsertAfter will run the stage immediately after the named stage
+func (p *Pipeline) InsertAfter(name string, st *Stage) error {
+	i, err := p.Index(name)
+	if err != nil {
+		return err
+	}
+	return p.insert(i+1, st)
+}
+
+// Append will run the stage after every other stage
+func (p *Pipeline) Append(st *Stage) error {
+	return p.insert(len(p.stages), st)
+}
+
+// Skip will prevent the named stages from running
+func (p *Pipeline) Skip(names ...string) error {
+	for _, name := range names {
+		if _, err := p.Index(name); err != nil {
+			return err
+		}
+		p.skipped[name] = true
+	}
+	return nil
+}
+
+// Skipped determines if the named stage will not run
+func (p *Pipeline) Skipped(name string) bool {
+	return p.skipped[name]
+}
+
+// Observe will notify the observer of every event while running
+func (p *Pipeline) Observe(o Observer) {
+	p.observers = append(p.observers, o)
+}
+
+// emit notifies the observers of the event
+func (p *Pipeline) emit(kind EventKind, index int, err error) {
+	e := &Event{
+		Kind:  kind,
+		Stage: p.stages[index].Name,
+		Index: index,
+		Err:   err,
+	}
+	for _, o := range p.observers {
+		o(e)
+	}
+}
+
+// Run will run every stage that isn't skipped, in order, stopping at the
+// first stage to fail
+func (p *Pipeline) Run() error {
+	for i, st := range p.stages {
+		if p.skipped[st.Name] {
+			p.emit(EventSkip, i, nil)
+			continue
+		}
+		p.emit(EventStart, i, nil)
+		if err := st.Run(); err != nil {
+			p.emit(EventFail, i, err)
+			return err
+		}
+		p.emit(EventFinish, i, nil)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	var ran []string
+	stage := func(name string) *Stage {
+		return &Stage{Name: name, Run: func() error {
+			ran = append(ran, name)
+			return nil
+		}}
+	}
+	p, err := New(stage("prepare"), stage("rootfs"), stage("publish"))
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	if err := p.InsertAfter("rootfs", stage("custom")); err != nil {
+		t.Fatalf("Failed to insert stage: %v", err)
+	}
+	if err := p.InsertBefore("prepare", stage("early")); err != nil {
+		t.Fatalf("Failed to insert stage: %v", err)
+	}
+	if err := p.InsertAfter("rootfs", stage("custom")); err == nil {
+		t.Fatalf("Duplicate stages should not be allowed")
+	}
+	if err := p.Skip("publish"); err != nil {
+		t.Fatalf("Failed to skip stage: %v", err)
+	}
+	if err := p.Skip("missing"); err == nil {
+		t.Fatalf("Unknown stages should not be skipped")
+	}
+
+	var events []string
+	p.Observe(func(e *Event) {
+		events = append(events, string(e.Kind)+":"+e.Stage)
+	})
+	if err := p.Run(); err != nil {
+		t.Fatalf("Failed to run pipeline: %v", err)
+	}
+	if got := strings.Join(ran, ","); got != "early,prepare,rootfs,custom" {
+		t.Fatalf("Incorrect stage order: %v", got)
+	}
+	if got := events[len(events)-1]; got != "skip:publish" {
+		t.Fatalf("Incorrect final event: %v", got)
+	}
+}
+
+func TestPipelineFailure(t *testing.T) {
+	failure := errors.New("failed")
+	ran := false
+	p, err := New(
+		&Stage{Name: "fail", Run: func() error { return failure }},
+		&Stage{Name: "after", Run: func() error { ran = true; return nil }},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	var failed *Event
+	p.Observe(func(e *Event) {
+		if e.Kind == EventFail {
+			failed = e
+		}
+	})
+	if err := p.Run(); err != failure {
+		t.Fatalf("Incorrect error: %v", err)
+	}
+	if ran {
+		t.Fatalf("Stages after a failure should not run")
+	}
+	if failed == nil || failed.Stage != "fail" || failed.Err != failure {
+		t.Fatalf("Incorrect failure event: %v", failed)
+	}
+}
@@ -0,0 +1,266 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package pkgcache provides a package cache shared between builds, so that
+// rebuilding an image only downloads the packages which have changed.
+//
+// Packages are keyed by their file name, which carries the package name and
+// version in every supported format, along with their SHA256, so that
+// rebuilt packages with an unchanged version are never confused.
+package pkgcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultWorkers is the number of concurrent downloads if unconfigured
+const DefaultWorkers = 4
+
+// manifestDir holds the packages used by the previous build of each profile
+const manifestDir = "manifests"
+
+// An Entry is a single package within the cache
+type Entry struct {
+	Name   string `json:"name"`          // File name, i.e. "nano-2.7.1-60-1-x86_64.eopkg"
+	URL    string `json:"url,omitempty"` // Origin, only needed to fetch the package
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Validate will ensure the entry cannot escape the cache
+func (e *Entry) Validate() error {
+	if e.Name == "" || e.Name != filepath.Base(e.Name) || strings.HasPrefix(e.Name, ".") {
+		return fmt.Errorf("Invalid package file name: %q", e.Name)
+	}
+	if _, err := hex.DecodeString(e.SHA256); err != nil || len(e.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("Invalid SHA256 for %v: %q", e.Name, e.SHA256)
+	}
+	return nil
+}
+
+// A Cache is a directory of packages shared between builds
+type Cache struct {
+	dir string
+}
+
+// Open will open the cache at dir, creating it if needed
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, manifestDir), 00755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Path returns the location of the entry within the cache
+func (c *Cache) Path(e *Entry) string {
+	return filepath.Join(c.dir, e.Name, e.SHA256)
+}
+
+// Has returns true if the entry is already cached
+func (c *Cache) Has(e *Entry) bool {
+	st, err := os.Stat(c.Path(e))
+	return err == nil && st.Mode().IsRegular() && (e.Size == 0 || st.Size() == e.Size)
+}
+
+// Fetch will download every entry which isn't cached yet, using the given
+// number of concurrent workers, and return how many were downloaded. Entries
+// without a URL are skipped. Every download is attempted, and the first
+// failure returned.
+func (c *Cache) Fetch(entries []*Entry, workers int) (int, error) {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	var (
+		wg       sync.WaitGroup
+		mut      sync.Mutex
+		fetched  int
+		firstErr error
+	)
+	jobs := make(chan *Entry)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				err := c.download(e)
+				mut.Lock()
+				if err == nil {
+					fetched++
+				} else if firstErr == nil {
+					firstErr = err
+				}
+				mut.Unlock()
+			}
+		}()
+	}
+	for _, e := range entries {
+		if err := e.Validate(); err != nil {
+			mut.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mut.Unlock()
+			continue
+		}
+		if e.URL != "" && !c.Has(e) {
+			jobs <- e
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return fetched, firstErr
+}
+
+// download will fetch the entry into the cache, verifying its digest
+func (c *Cache) download(e *Entry) error {
+	resp, err := http.Get(e.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch %v: %v", e.URL, resp.Status)
+	}
+	return c.insert(e, resp.Body)
+}
+
+// insert will store the content as the entry, which is only put in place
+// once it has been verified
+func (c *Cache) insert(e *Entry, r io.Reader) error {
+	path := c.Path(e)
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".insert-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != e.SHA256 || (e.Size != 0 && n != e.Size) {
+		return fmt.Errorf("Digest mismatch for %v: expected %v (%d bytes), got %v (%d bytes)", e.Name, e.SHA256, e.Size, sum, n)
+	}
+	if err := os.Chmod(tmp.Name(), 00644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Store will add the package file at path to the cache, returning its entry
+func (c *Cache) Store(path string) (*Entry, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, fi)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Name: filepath.Base(path), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	if c.Has(e) {
+		return e, nil
+	}
+	if _, err := fi.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return e, c.insert(e, fi)
+}
+
+// Link will place the cached entry within dir under its file name, which
+// is a hardlink unless the cache is on another filesystem
+func (c *Cache) Link(e *Entry, dir string) error {
+	target := filepath.Join(dir, e.Name)
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(c.Path(e), target); err == nil {
+		return nil
+	}
+	src, err := os.Open(c.Path(e))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 00644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// manifestPath returns the location of the manifest for the named profile
+func (c *Cache) manifestPath(name string) string {
+	return filepath.Join(c.dir, manifestDir, filepath.Base(name)+".json")
+}
+
+// Manifest will return the entries recorded by WriteManifest for the named
+// profile, which is empty if it has never been built
+func (c *Cache) Manifest(name string) ([]*Entry, error) {
+	fi, err := os.Open(c.manifestPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fi.Close()
+	var ret []*Entry
+	if err := json.NewDecoder(fi).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("Invalid cache manifest for %v: %v", name, err)
+	}
+	return ret, nil
+}
+
+// WriteManifest will record the packages used by a build of the named profile,
+// which the next build can be seeded with
+func (c *Cache) WriteManifest(name string, entries []*Entry) error {
+	data, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := c.manifestPath(name)
+	if err := ioutil.WriteFile(path+".tmp", data, 00644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,117 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pkgcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func digest(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-pkgcache")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(filepath.Base(r.URL.Path)))
+	}))
+	defer srv.Close()
+
+	cache, err := Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	var entries []*Entry
+	for _, name := range []string{"nano-2.7.1-60-1-x86_64.eopkg", "bash-4.4-20-1-x86_64.eopkg", "vim-8.0-31-1-x86_64.eopkg"} {
+		entries = append(entries, &Entry{Name: name, URL: srv.URL + "/" + name, Size: int64(len(name)), SHA256: digest(name)})
+	}
+	if n, err := cache.Fetch(entries, 2); err != nil || n != 3 {
+		t.Fatalf("Failed to fetch: %v %v", n, err)
+	}
+	if n, err := cache.Fetch(entries, 2); err != nil || n != 0 || requests != 3 {
+		t.Fatalf("Cached entries should not be fetched again: %v %v %v", n, err, requests)
+	}
+
+	bad := &Entry{Name: "zsh-5.3-1-1-x86_64.eopkg", URL: srv.URL + "/zsh", Size: 3, SHA256: digest("nope")}
+	if _, err := cache.Fetch([]*Entry{bad}, 1); err == nil || cache.Has(bad) {
+		t.Fatalf("Digest mismatches should not be cached: %v", err)
+	}
+	escape := &Entry{Name: "../escape", URL: srv.URL + "/escape", SHA256: digest("escape")}
+	if _, err := cache.Fetch([]*Entry{escape}, 1); err == nil {
+		t.Fatalf("Entries escaping the cache should be rejected")
+	}
+
+	root := filepath.Join(dir, "root")
+	os.MkdirAll(root, 00755)
+	if err := cache.Link(entries[0], root); err != nil {
+		t.Fatalf("Failed to link entry: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(root, entries[0].Name)); string(data) != entries[0].Name {
+		t.Fatalf("Wrong content linked: %q", data)
+	}
+}
+
+func TestStoreManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-pkgcache")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	if m, err := cache.Manifest("desktop"); err != nil || m != nil {
+		t.Fatalf("Unbuilt profiles should have no manifest: %v %v", m, err)
+	}
+	path := filepath.Join(dir, "bash_5.2.15-2_amd64.deb")
+	if err := ioutil.WriteFile(path, []byte("bash"), 00644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	e, err := cache.Store(path)
+	if err != nil {
+		t.Fatalf("Failed to store package: %v", err)
+	}
+	want := &Entry{Name: "bash_5.2.15-2_amd64.deb", Size: 4, SHA256: digest("bash")}
+	if !reflect.DeepEqual(e, want) || !cache.Has(want) {
+		t.Fatalf("Wrong entry stored: %+v", e)
+	}
+	if err := cache.WriteManifest("desktop", []*Entry{e}); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if m, err := cache.Manifest("desktop"); err != nil || !reflect.DeepEqual(m, []*Entry{want}) {
+		t.Fatalf("Wrong manifest: %v %v", m, err)
+	}
+}
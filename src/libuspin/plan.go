@@ -0,0 +1,442 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"libuspin/backend"
+	"libuspin/config"
+	"libuspin/deprecation"
+	"libuspin/hooks"
+	"libuspin/spec"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PlanStepKind identifies the type of operation a PlanStep performs
+type PlanStepKind string
+
+const (
+	// PlanStepRepo will add repositories to the target
+	PlanStepRepo PlanStepKind = "repo"
+
+	// PlanStepGroup will install groups or components
+	PlanStepGroup PlanStepKind = "group"
+
+	// PlanStepPackage will install packages
+	PlanStepPackage PlanStepKind = "package"
+
+	// PlanStepRemove will remove packages
+	PlanStepRemove PlanStepKind = "remove"
+)
+
+// A PlanStep is a single package manager transaction, mapping directly to
+// an OpSet within the stack.
+type PlanStep struct {
+	Kind         PlanStepKind `json:"kind"`
+	IgnoreSafety bool         `json:"ignoreSafety,omitempty"`
+	Names        []string     `json:"names"`
+	URIs         []string     `json:"uris,omitempty"` // Only set for repo steps
+}
+
+// A PlanScript is a script of the profile run during the build
+type PlanScript struct {
+	Phase hooks.Phase `json:"phase"`
+	Path  string      `json:"path"` // As given in the profile
+}
+
+// A Plan is the resolved, ordered set of operations that a build of the
+// ImageSpec will perform. Creating a Plan never touches the host or rootfs.
+type Plan struct {
+	ImageType config.ImageType `json:"imageType"`
+	Steps     []*PlanStep      `json:"steps"`
+
+	// Groups mapped to their concrete package lists, only populated when
+	// ExpandGroups has been used
+	Expansions map[string][]string `json:"groupExpansions,omitempty"`
+
+	// Groups including child components mapped to the concrete components
+	Components map[string][]string `json:"componentExpansions,omitempty"`
+
+	// Every package in the resolved set mapped to its direct dependencies,
+	// only populated when ResolveDependencies has been used
+	Dependencies map[string][]string `json:"dependencies,omitempty"`
+
+	// Package versions, only populated when ResolveVersions has been used
+	Versions map[string]string `json:"versions,omitempty"`
+
+	// Everything fetched by the package manager, only populated when
+	// RecordDownloads has been used
+	Downloads []*backend.Download `json:"downloads,omitempty"`
+
+	// Steps ignoring package manager safety, only populated when
+	// RecordSafety has been used
+	Safety []*SafetyBypass `json:"safety,omitempty"`
+
+	// Deprecated features used by the profile
+	Deprecations []*deprecation.Notice `json:"deprecations,omitempty"`
+
+	// Scripts of the profile, in the order they will run
+	Scripts []*PlanScript `json:"scripts,omitempty"`
+
+	// Files the build will produce, image first
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// Package manager calls the build will make, only populated when
+	// Rehearse has been used
+	Transactions []*backend.Transaction `json:"transactions,omitempty"`
+
+	groups map[string]*spec.OpGroup // Groups including children, by name in the plan
+}
+
+// NewPlan will construct a Plan from the stack of the given ImageSpec
+func NewPlan(img *ImageSpec) *Plan {
+	p := &Plan{
+		ImageType:    img.Config.Image.Type,
+		Deprecations: img.Config.Deprecations,
+		groups:       make(map[string]*spec.OpGroup),
+	}
+	for _, opset := range img.Stack.Blocks {
+		if len(opset.Ops) == 0 {
+			continue
+		}
+		step := &PlanStep{}
+		for _, op := range opset.Ops {
+			switch o := op.(type) {
+			case *spec.OpRepo:
+				step.Kind = PlanStepRepo
+				step.Names = append(step.Names, o.RepoName)
+				step.URIs = append(step.URIs, o.RepoURI)
+			case *spec.OpGroup:
+				step.Kind = PlanStepGroup
+				step.IgnoreSafety = o.IgnoreSafety
+				step.Names = append(step.Names, o.String())
+				if o.Children {
+					p.groups[o.String()] = o
+				}
+			case *spec.OpPackage:
+				step.Kind = PlanStepPackage
+				step.IgnoreSafety = o.IgnoreSafety
+				step.Names = append(step.Names, o.Name)
+			case *spec.OpRemove:
+				step.Kind = PlanStepRemove
+				step.IgnoreSafety = o.IgnoreSafety
+				step.Names = append(step.Names, o.Name)
+			}
+		}
+		p.Steps = append(p.Steps, step)
+	}
+	p.addScripts(img)
+	p.addArtifacts(img)
+	return p
+}
+
+// addScripts will record the scripts of every phase, in the order of the build
+func (p *Plan) addScripts(img *ImageSpec) {
+	scripts := img.Config.Scripts
+	phases := []struct {
+		phase hooks.Phase
+		paths []string
+	}{
+		{hooks.PhasePostInstall, scripts.PostInstall},
+		{hooks.PhasePreCompress, scripts.PreCompress},
+		{hooks.PhasePostImage, scripts.PostImage},
+	}
+	for _, phase := range phases {
+		for _, path := range phase.paths {
+			p.Scripts = append(p.Scripts, &PlanScript{Phase: phase.phase, Path: path})
+		}
+	}
+}
+
+// addArtifacts will record the image, the additional outputs built from its
+// rootfs and their checksums, if the image type produces a file
+func (p *Plan) addArtifacts(img *ImageSpec) {
+	target := img.OutputTarget()
+	if target == "" {
+		return
+	}
+	files := []string{target}
+	for _, format := range img.Config.Image.Outputs {
+		files = append(files, target+format.Suffix())
+	}
+	p.Artifacts = append(p.Artifacts, files...)
+	for _, file := range files {
+		for _, algorithm := range img.Config.Checksums.Algorithms {
+			p.Artifacts = append(p.Artifacts, file+algorithm.Suffix())
+			if img.Config.Checksums.SignKey != "" {
+				// Armored detached signature, as written by publish.Sign
+				p.Artifacts = append(p.Artifacts, file+algorithm.Suffix()+".asc")
+			}
+		}
+	}
+}
+
+// Rehearse will apply the operations of the ImageSpec to a ResolveOnly
+// manager, recording the exact package manager calls of the build without
+// touching a rootfs. The query may be nil if there are no groups of child
+// components to resolve.
+func (p *Plan) Rehearse(img *ImageSpec, query backend.Query) error {
+	m := backend.NewResolveOnly(query)
+	for _, opset := range img.Stack.Blocks {
+		if opset == nil || len(opset.Ops) == 0 {
+			continue
+		}
+		if err := ResolveComponents(opset.Ops, m); err != nil {
+			return err
+		}
+		if err := ApplyOperations(m, opset.Ops, img.Config.Image.BatchSize); err != nil {
+			return err
+		}
+	}
+	p.Transactions = m.Transactions
+	return nil
+}
+
+// ExpandGroups will ask the backend for the contents of every group within
+// the plan, so that the plan shows exactly what each group pulls in.
+func (p *Plan) ExpandGroups(e backend.GroupExpander) error {
+	p.Expansions = make(map[string][]string)
+	for _, step := range p.Steps {
+		if step.Kind != PlanStepGroup {
+			continue
+		}
+		for _, name := range step.Names {
+			if _, ok := p.Expansions[name]; ok {
+				continue
+			}
+			components, err := p.components(name, e)
+			if err != nil {
+				return err
+			}
+			var pkgs []string
+			for _, component := range components {
+				contents, err := e.ExpandGroup(component)
+				if err != nil {
+					return fmt.Errorf("Failed to expand group '%v': %v", component, err)
+				}
+				pkgs = append(pkgs, contents...)
+			}
+			sort.Strings(pkgs)
+			p.Expansions[name] = pkgs
+		}
+	}
+	return nil
+}
+
+// components returns the concrete components of the named group, resolving
+// child components through the expander if needed
+func (p *Plan) components(name string, e backend.GroupExpander) ([]string, error) {
+	group, ok := p.groups[name]
+	if !ok {
+		return []string{name}, nil
+	}
+	if group.Components == nil {
+		lister, ok := e.(backend.ComponentLister)
+		if !ok {
+			return nil, backend.ErrUnsupportedQuery
+		}
+		available, err := lister.Components()
+		if err != nil {
+			return nil, err
+		}
+		if err := group.ResolveComponents(available); err != nil {
+			return nil, err
+		}
+	}
+	if p.Components == nil {
+		p.Components = make(map[string][]string)
+	}
+	p.Components[name] = group.Components
+	return group.Components, nil
+}
+
+// Packages returns the sorted set of packages explicitly requested by the plan,
+// including the contents of any expanded groups. Packages removed by a later
+// step are not included.
+func (p *Plan) Packages() []string {
+	requested := make(map[string]bool)
+	add := func(names []string) {
+		for _, name := range names {
+			requested[name] = true
+		}
+	}
+	for _, step := range p.Steps {
+		switch step.Kind {
+		case PlanStepPackage:
+			add(step.Names)
+		case PlanStepGroup:
+			for _, group := range step.Names {
+				add(p.Expansions[group])
+			}
+		case PlanStepRemove:
+			for _, name := range step.Names {
+				delete(requested, name)
+			}
+		}
+	}
+	var ret []string
+	for name := range requested {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ResolveDependencies will walk the dependencies of every package in the plan
+// to build the full dependency graph of the resolved package set. Groups must
+// have been expanded first for their contents to be included.
+func (p *Plan) ResolveDependencies(r backend.DependencyResolver) error {
+	p.Dependencies = make(map[string][]string)
+	queue := p.Packages()
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := p.Dependencies[name]; ok {
+			continue
+		}
+		deps, err := r.Dependencies(name)
+		if err != nil {
+			return fmt.Errorf("Failed to resolve dependencies of '%v': %v", name, err)
+		}
+		p.Dependencies[name] = deps
+		queue = append(queue, deps...)
+	}
+	return nil
+}
+
+// ResolveVersions will record the version of every package in the plan. The
+// full dependency set is used when it has been resolved.
+func (p *Plan) ResolveVersions(v backend.VersionReporter) error {
+	names := p.Packages()
+	if p.Dependencies != nil {
+		names = nil
+		for name := range p.Dependencies {
+			names = append(names, name)
+		}
+	}
+	p.Versions = make(map[string]string)
+	for _, name := range names {
+		version, err := v.Version(name)
+		if err != nil {
+			return fmt.Errorf("Failed to determine version of '%v': %v", name, err)
+		}
+		p.Versions[name] = version
+	}
+	return nil
+}
+
+// RecordDownloads will record every file the package manager fetched
+func (p *Plan) RecordDownloads(r backend.DownloadReporter) error {
+	downloads, err := r.Downloads()
+	if err != nil {
+		return fmt.Errorf("Failed to record downloads: %v", err)
+	}
+	p.Downloads = downloads
+	return nil
+}
+
+// Write will emit a human readable version of the plan
+func (p *Plan) Write(w io.Writer) error {
+	fmt.Fprintf(w, "Image type: %v\n\n", p.ImageType)
+	for i, step := range p.Steps {
+		var desc string
+		switch step.Kind {
+		case PlanStepRepo:
+			desc = "Add repositories"
+		case PlanStepGroup:
+			desc = "Install groups"
+		case PlanStepRemove:
+			desc = "Remove packages"
+		default:
+			desc = "Install packages"
+		}
+		if step.IgnoreSafety {
+			desc += " (ignoring safety)"
+		}
+		fmt.Fprintf(w, "%3d. %v\n", i+1, desc)
+		for j, name := range step.Names {
+			if step.Kind == PlanStepRepo {
+				fmt.Fprintf(w, "       %v = %v\n", name, step.URIs[j])
+				continue
+			}
+			fmt.Fprintf(w, "       %v\n", name)
+			if components, ok := p.Components[name]; ok {
+				fmt.Fprintf(w, "         components: %v\n", strings.Join(components, " "))
+			}
+			if pkgs, ok := p.Expansions[name]; ok && step.Kind == PlanStepGroup {
+				fmt.Fprintf(w, "         -> %v\n", strings.Join(pkgs, " "))
+			}
+		}
+	}
+	if len(p.Transactions) > 0 {
+		fmt.Fprintf(w, "\nPackage manager transactions:\n")
+		for i, t := range p.Transactions {
+			desc := string(t.Kind)
+			if t.IgnoreSafety {
+				desc += " (ignoring safety)"
+			}
+			fmt.Fprintf(w, "%3d. %v %v\n", i+1, desc, strings.Join(t.Names, " "))
+			if len(t.Packages) > 0 {
+				fmt.Fprintf(w, "       -> %v\n", strings.Join(t.Packages, " "))
+			}
+		}
+	}
+	if len(p.Scripts) > 0 {
+		fmt.Fprintf(w, "\nScripts:\n")
+		for _, script := range p.Scripts {
+			fmt.Fprintf(w, "    %-14v %v\n", script.Phase, script.Path)
+		}
+	}
+	if len(p.Artifacts) > 0 {
+		fmt.Fprintf(w, "\nArtifacts:\n")
+		for _, artifact := range p.Artifacts {
+			fmt.Fprintf(w, "    %v\n", artifact)
+		}
+	}
+	if len(p.Safety) > 0 {
+		fmt.Fprintf(w, "\nSafety checks bypassed:\n")
+		for _, bypass := range p.Safety {
+			fmt.Fprintf(w, "    %v\n", bypass)
+		}
+	}
+	return nil
+}
+
+// LoadPlan will load a plan previously stored with WriteJSON
+func LoadPlan(path string) (*Plan, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	p := &Plan{}
+	if err := json.NewDecoder(fi).Decode(p); err != nil {
+		return nil, fmt.Errorf("Invalid plan file %v: %v", path, err)
+	}
+	return p, nil
+}
+
+// WriteJSON will emit the plan in JSON format for machine consumption
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(p)
+}
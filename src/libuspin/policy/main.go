@@ -0,0 +1,144 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package policy provides organisation wide constraints on image builds.
+//
+// The policy is loaded from a system path rather than the profile, so that
+// it applies to every build on the machine regardless of what the profile
+// asks for.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"libuspin/signature"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultFile is where the policy for the machine is kept
+const DefaultFile = "/etc/uspin/policy.toml"
+
+// A Policy is the set of constraints imposed on every build
+type Policy struct {
+	MaxImageSize      string   `toml:"max_image_size"`     // i.e. "2G", unlimited if empty
+	ForbiddenPackages []string `toml:"forbidden_packages"` // Packages that may never be installed
+	RequiredPackages  []string `toml:"required_packages"`  // Packages that must be installed, i.e. hardening
+
+	// Profiles must be signed with this method, see signature.Method
+	RequireSignature signature.Method `toml:"require_signature"`
+	Keyring          string           `toml:"keyring"` // Trusted keys for detached signatures
+
+	maxImageSize int64
+}
+
+// Load will load the policy at the given path. A missing policy file is not
+// an error, and imposes no constraints.
+func Load(path string) (*Policy, error) {
+	p := &Policy{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return nil, err
+	}
+	if _, err := toml.Decode(string(data), p); err != nil {
+		return nil, fmt.Errorf("Invalid policy %v: %v", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid policy %v: %v", path, err)
+	}
+	return p, nil
+}
+
+// validate will ensure the policy can actually be enforced
+func (p *Policy) validate() error {
+	var err error
+	if p.maxImageSize, err = ParseSize(p.MaxImageSize); err != nil {
+		return err
+	}
+	if _, err := signature.ParseMethod(string(p.RequireSignature)); err != nil {
+		return err
+	}
+	if p.RequireSignature == signature.MethodDetached && p.Keyring == "" {
+		return errors.New("keyring is required for detached signatures")
+	}
+	return nil
+}
+
+// ParseSize will parse a size with an optional binary suffix, i.e. "512M".
+// An empty size is 0.
+func ParseSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for i, suffix := range "KMGT" {
+		if strings.HasSuffix(strings.ToUpper(size), string(suffix)) {
+			mult = 1 << (10 * uint(i+1))
+			size = size[:len(size)-1]
+			break
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size '%v'", size)
+	}
+	return n * mult, nil
+}
+
+// CheckPackages will return a description of every violation of the policy
+// by the installed packages
+func (p *Policy) CheckPackages(installed []string) []string {
+	have := make(map[string]bool)
+	for _, name := range installed {
+		have[name] = true
+	}
+	var ret []string
+	for _, name := range p.ForbiddenPackages {
+		if have[name] {
+			ret = append(ret, fmt.Sprintf("Forbidden package is installed: %v", name))
+		}
+	}
+	for _, name := range p.RequiredPackages {
+		if !have[name] {
+			ret = append(ret, fmt.Sprintf("Required package is missing: %v", name))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// CheckImage will ensure the image is within the size limit
+func (p *Policy) CheckImage(path string) error {
+	if p.maxImageSize == 0 {
+		return nil
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Size() > p.maxImageSize {
+		return fmt.Errorf("Image is %v bytes, exceeding the policy limit of %v", st.Size(), p.MaxImageSize)
+	}
+	return nil
+}
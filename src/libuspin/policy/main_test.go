@@ -0,0 +1,82 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-policy")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.toml")
+	if p, err := Load(path); err != nil || p.CheckPackages(nil) != nil {
+		t.Fatalf("A missing policy should impose nothing: %v", err)
+	}
+
+	data := `max_image_size = "1K"
+forbidden_packages = ["telnet"]
+required_packages = ["apparmor"]
+`
+	if err := ioutil.WriteFile(path, []byte(data), 00644); err != nil {
+		t.Fatalf("Cannot write policy: %v", err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Cannot load policy: %v", err)
+	}
+	if problems := p.CheckPackages([]string{"bash", "telnet"}); len(problems) != 2 {
+		t.Fatalf("Expected forbidden and missing packages: %v", problems)
+	}
+	if problems := p.CheckPackages([]string{"apparmor", "bash"}); len(problems) != 0 {
+		t.Fatalf("Unexpected violations: %v", problems)
+	}
+
+	image := filepath.Join(dir, "image.iso")
+	if err := ioutil.WriteFile(image, make([]byte, 2048), 00644); err != nil {
+		t.Fatalf("Cannot write image: %v", err)
+	}
+	if err := p.CheckImage(image); err == nil {
+		t.Fatalf("Oversized image should fail")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`require_signature = "detached"`), 00644); err != nil {
+		t.Fatalf("Cannot write policy: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("Detached signatures without a keyring should fail")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for size, expected := range map[string]int64{"": 0, "512": 512, "4K": 4096, "2g": 2 << 30} {
+		n, err := ParseSize(size)
+		if err != nil || n != expected {
+			t.Fatalf("Wrong size for %q: %v %v", size, n, err)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Fatalf("Invalid size should fail")
+	}
+}
@@ -0,0 +1,65 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package process
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+)
+
+// A Controller can suspend and resume a running build, along with every
+// child process it has spawned (package managers, mksquashfs, etc).
+type Controller struct {
+	mut          sync.Mutex
+	paused       bool
+	yieldPending bool // Pause at the next stage boundary
+}
+
+// NewController will return a new Controller for the current process
+func NewController() *Controller {
+	return &Controller{}
+}
+
+// Paused returns true if the build is currently suspended
+func (c *Controller) Paused() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.paused
+}
+
+// RequestYield will ask the build to suspend itself at the next stage
+// boundary, rather than immediately.
+func (c *Controller) RequestYield() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.yieldPending = true
+}
+
+// Checkpoint must be called by the build at each stage boundary. If a yield
+// was requested, the build stops here until it receives SIGCONT.
+func (c *Controller) Checkpoint() error {
+	c.mut.Lock()
+	pending := c.yieldPending
+	c.yieldPending = false
+	c.mut.Unlock()
+
+	if !pending {
+		return nil
+	}
+	log.Info("Yielding to a higher priority build")
+	return c.stop()
+}
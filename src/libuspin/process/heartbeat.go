@@ -0,0 +1,170 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package process
+
+import (
+	"bufio"
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Heartbeat logs periodically whenever the build has been silent for a
+// while, i.e. during large package transactions or mksquashfs, so that CI
+// systems with inactivity timeouts don't kill healthy builds. It is also a
+// logging hook, as any other log line counts as activity.
+type Heartbeat struct {
+	interval  time.Duration
+	operation string
+	started   time.Time
+	last      time.Time
+	beats     int // Number of heartbeats logged
+	stop      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewHeartbeat will return a Heartbeat beating after interval of silence
+func NewHeartbeat(interval time.Duration) *Heartbeat {
+	return &Heartbeat{interval: interval}
+}
+
+// Start will begin monitoring for silence
+func (h *Heartbeat) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stop != nil || h.interval <= 0 {
+		return
+	}
+	h.last = time.Now()
+	h.stop = make(chan struct{})
+	go h.run(h.stop)
+}
+
+// Stop will stop monitoring
+func (h *Heartbeat) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+}
+
+// SetOperation will name the operation now in progress, i.e. the stage
+func (h *Heartbeat) SetOperation(name string) {
+	h.mu.Lock()
+	h.operation = name
+	h.started = time.Now()
+	h.mu.Unlock()
+}
+
+// Levels returns all levels, as any output shows the build is alive
+func (h *Heartbeat) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire will note the activity
+func (h *Heartbeat) Fire(e *log.Entry) error {
+	h.mu.Lock()
+	h.last = time.Now()
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Heartbeat) run(stop chan struct{}) {
+	ticker := time.NewTicker(h.interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			silent := now.Sub(h.last) >= h.interval && h.operation != ""
+			operation, elapsed := h.operation, now.Sub(h.started)
+			if silent {
+				// The hook isn't guaranteed to see our own line
+				h.last = now
+				h.beats++
+			}
+			h.mu.Unlock()
+			if silent {
+				h.beat(operation, elapsed)
+			}
+		}
+	}
+}
+
+// beat will log that the operation is still in progress
+func (h *Heartbeat) beat(operation string, elapsed time.Duration) {
+	fields := log.Fields{
+		"operation": operation,
+		"elapsed":   elapsed / time.Second * time.Second,
+	}
+	if read, written, err := ChildIO(os.Getpid()); err == nil {
+		fields["bytesRead"] = read
+		fields["bytesWritten"] = written
+	}
+	log.WithFields(fields).Info("Still working")
+}
+
+// ChildIO returns the bytes read and written by the running descendants of
+// pid, as far as they can be determined.
+func ChildIO(pid int) (int64, int64, error) {
+	pids, err := Descendants(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	var read, written int64
+	for _, child := range pids {
+		// Processes may exit, or be unreadable to us
+		data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(child), "io"))
+		if err != nil {
+			continue
+		}
+		r, w := parseIO(data)
+		read += r
+		written += w
+	}
+	return read, written, nil
+}
+
+// parseIO extracts the character counts from /proc/$pid/io
+func parseIO(data []byte) (int64, int64) {
+	var read, written int64
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		n, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "rchar:":
+			read = n
+		case "wchar:":
+			written = n
+		}
+	}
+	return read, written
+}
@@ -0,0 +1,92 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescendants(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("Cannot spawn child: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	pids, err := Descendants(os.Getpid())
+	if err != nil {
+		t.Fatalf("Failed to list descendants: %v", err)
+	}
+	found := false
+	for _, pid := range pids {
+		if pid == cmd.Process.Pid {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Child %v not in descendants: %v", cmd.Process.Pid, pids)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	for i := 0; i < MaxTrace+1; i++ {
+		Trace(exec.Command("true", strconv.Itoa(i)))
+	}
+	traced := Traced()
+	if len(traced) != MaxTrace || !strings.HasSuffix(traced[len(traced)-1], "true 200") {
+		t.Fatalf("Unexpected trace: %v", traced[len(traced)-1])
+	}
+}
+
+func TestParseIO(t *testing.T) {
+	read, written := parseIO([]byte("rchar: 1024\nwchar: 2048\nsyscr: 5\nread_bytes: 0\n"))
+	if read != 1024 || written != 2048 {
+		t.Fatalf("Wrong I/O counters: %v %v", read, written)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	h := NewHeartbeat(20 * time.Millisecond)
+	h.SetOperation("rootfs")
+	h.Start()
+	defer h.Stop()
+
+	// Activity keeps the heartbeat quiet
+	for i := 0; i < 10; i++ {
+		h.Fire(nil)
+		time.Sleep(5 * time.Millisecond)
+	}
+	h.mu.Lock()
+	beats := h.beats
+	h.mu.Unlock()
+	if beats != 0 {
+		t.Fatalf("Heartbeat while active: %v", beats)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	h.mu.Lock()
+	beats = h.beats
+	h.mu.Unlock()
+	if beats == 0 {
+		t.Fatalf("No heartbeat while silent")
+	}
+}
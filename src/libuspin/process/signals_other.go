@@ -0,0 +1,51 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !unix
+
+package process
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errNoJobControl is returned on hosts without job control signals
+var errNoJobControl = errors.New("Suspending builds requires a Unix host")
+
+// Pause is unsupported on this host
+func (c *Controller) Pause() error {
+	return errNoJobControl
+}
+
+// Resume is unsupported on this host
+func (c *Controller) Resume() error {
+	return errNoJobControl
+}
+
+// stop is unsupported on this host
+func (c *Controller) stop() error {
+	return errNoJobControl
+}
+
+// HandleSignals does nothing on this host, which has no job control signals
+func (c *Controller) HandleSignals() {
+}
+
+// SignalDescendants is unsupported on this host
+func SignalDescendants(pid int, sig syscall.Signal) error {
+	return errNoJobControl
+}
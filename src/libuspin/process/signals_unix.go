@@ -0,0 +1,113 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build unix
+
+package process
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Pause will stop all child processes of the build. Note that USpin itself is
+// not stopped, so that it can later be resumed through Resume.
+func (c *Controller) Pause() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.paused {
+		return nil
+	}
+	log.Info("Pausing build")
+	if err := SignalDescendants(os.Getpid(), syscall.SIGSTOP); err != nil {
+		return err
+	}
+	c.paused = true
+	return nil
+}
+
+// Resume will continue all previously stopped child processes
+func (c *Controller) Resume() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if !c.paused {
+		return nil
+	}
+	log.Info("Resuming build")
+	if err := SignalDescendants(os.Getpid(), syscall.SIGCONT); err != nil {
+		return err
+	}
+	c.paused = false
+	return nil
+}
+
+// stop will pause the children, and then stop ourselves. SIGCONT wakes us.
+func (c *Controller) stop() error {
+	if err := c.Pause(); err != nil {
+		return err
+	}
+	return syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+}
+
+// HandleSignals will pause the build on SIGTSTP, stopping USpin too, and then
+// resume it again on SIGCONT. This allows "kill -TSTP" or ^Z to cleanly
+// suspend a long running low priority build on a shared machine.
+// SIGUSR1 will instead suspend the build at the next stage boundary, which
+// is used by the daemon to preempt lower priority builds.
+func (c *Controller) HandleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTSTP, syscall.SIGCONT, syscall.SIGUSR1)
+	go func() {
+		for sig := range ch {
+			var err error
+			switch sig {
+			case syscall.SIGTSTP:
+				err = c.stop()
+			case syscall.SIGUSR1:
+				c.RequestYield()
+			default:
+				// Continuing also cancels any outstanding yield request
+				c.mut.Lock()
+				c.yieldPending = false
+				c.mut.Unlock()
+				err = c.Resume()
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"signal": sig,
+					"error":  err,
+				}).Error("Failed to handle signal")
+			}
+		}
+	}()
+}
+
+// SignalDescendants will send the signal to every descendant of pid
+func SignalDescendants(pid int, sig syscall.Signal) error {
+	pids, err := Descendants(pid)
+	if err != nil {
+		return err
+	}
+	for _, child := range pids {
+		// Ignore processes that exited in the meantime
+		if err := syscall.Kill(child, sig); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package process
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxTrace is the number of commands kept by the trace
+const MaxTrace = 200
+
+var (
+	trace   []string
+	traceMu sync.Mutex
+)
+
+// Trace will record the command as having been run by the build, so that
+// it can be included in diagnostics should the build fail.
+func Trace(cmd *exec.Cmd) {
+	line := fmt.Sprintf("%v %v", time.Now().UTC().Format(time.RFC3339), strings.Join(cmd.Args, " "))
+	if cmd.Dir != "" {
+		line += fmt.Sprintf(" (in %v)", cmd.Dir)
+	}
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	trace = append(trace, line)
+	if len(trace) > MaxTrace {
+		trace = trace[len(trace)-MaxTrace:]
+	}
+}
+
+// Traced returns the most recently traced commands, oldest first
+func Traced() []string {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	return append([]string{}, trace...)
+}
@@ -0,0 +1,299 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package proxy provides an HTTP proxy for the package manager, recording
+// every response it fetches so that a later build can be replayed from the
+// recording alone, without any network access.
+//
+// Only plain HTTP can be recorded. HTTPS requests are tunnelled through
+// untouched when recording and refused when replaying.
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Mode controls whether the proxy reaches the network
+type Mode string
+
+const (
+	// ModeOff disables the proxy entirely
+	ModeOff Mode = ""
+
+	// ModeRecord fetches anything not yet recorded from the network
+	ModeRecord Mode = "record"
+
+	// ModeReplay serves only from the recording
+	ModeReplay Mode = "replay"
+)
+
+// A response is the stored metadata of a recorded response
+type response struct {
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+}
+
+// Stats describes the traffic seen by the proxy
+type Stats struct {
+	Recorded  int // Responses fetched from the network and stored
+	Replayed  int // Responses served from the recording
+	Missed    int // Requests refused as they were not recorded
+	Tunnelled int // HTTPS connections passed through unrecorded
+}
+
+// A Proxy records and replays the HTTP traffic of the package manager
+type Proxy struct {
+	dir      string
+	mode     Mode
+	upstream http.RoundTripper
+	listener net.Listener
+	server   *http.Server
+
+	mut   sync.Mutex
+	stats Stats
+}
+
+// New will return a proxy storing its recording within dir
+func New(dir string, mode Mode) *Proxy {
+	return &Proxy{
+		dir:  dir,
+		mode: mode,
+		// Never chain through the proxy we've been set up as
+		upstream: &http.Transport{Proxy: nil},
+	}
+}
+
+// Start will begin serving on a local port, returning the proxy URL
+func (p *Proxy) Start() (string, error) {
+	if err := os.MkdirAll(p.dir, 00755); err != nil {
+		return "", err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	p.listener = l
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(l)
+	return "http://" + l.Addr().String(), nil
+}
+
+// Stop will stop serving
+func (p *Proxy) Stop() error {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+// Stats returns the traffic seen so far
+func (p *Proxy) Stats() Stats {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.stats
+}
+
+// count will update the stats under lock
+func (p *Proxy) count(fn func(s *Stats)) {
+	p.mut.Lock()
+	fn(&p.stats)
+	p.mut.Unlock()
+}
+
+// ServeHTTP handles a single proxied request
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "Not a proxy request", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, fmt.Sprintf("Method %v cannot be recorded", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	url := r.URL.String()
+	key := p.key(url)
+	if p.replay(w, r, key) {
+		p.count(func(s *Stats) { s.Replayed++ })
+		return
+	}
+	if p.mode == ModeReplay {
+		p.count(func(s *Stats) { s.Missed++ })
+		log.WithFields(log.Fields{
+			"url": url,
+		}).Error("Request is not in the recording")
+		http.Error(w, "Not in the recording: "+url, http.StatusGatewayTimeout)
+		return
+	}
+	if err := p.record(w, r, url, key); err != nil {
+		log.WithFields(log.Fields{
+			"url":   url,
+			"error": err,
+		}).Error("Failed to record response")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// key returns the name of the recording for the URL
+func (p *Proxy) key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(p.dir, hex.EncodeToString(sum[:]))
+}
+
+// replay will serve the recorded response, if there is one
+func (p *Proxy) replay(w http.ResponseWriter, r *http.Request, key string) bool {
+	data, err := ioutil.ReadFile(key + ".json")
+	if err != nil {
+		return false
+	}
+	resp := &response{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return false
+	}
+	body, err := os.Open(key + ".body")
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.Status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+	return true
+}
+
+// record will fetch the URL from the network, storing the response if it
+// was successful. Failures are passed on without being recorded.
+func (p *Proxy) record(w http.ResponseWriter, r *http.Request, url, key string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.upstream.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+		return nil
+	}
+
+	if err := writeAtomic(key+".body", func(w io.Writer) error {
+		_, err := io.Copy(w, res.Body)
+		return err
+	}); err != nil {
+		return err
+	}
+	res.Header.Del("Content-Length")
+	if err := writeAtomic(key+".json", func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(&response{URL: url, Status: res.StatusCode, Header: res.Header})
+	}); err != nil {
+		return err
+	}
+	p.count(func(s *Stats) { s.Recorded++ })
+	p.replay(w, r, key)
+	return nil
+}
+
+// tunnel passes an HTTPS connection through untouched when recording, as
+// its content cannot be seen, and refuses it when replaying
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	if p.mode == ModeReplay {
+		p.count(func(s *Stats) { s.Missed++ })
+		log.WithFields(log.Fields{
+			"host": r.Host,
+		}).Error("HTTPS cannot be replayed, use a plain HTTP repository")
+		http.Error(w, "HTTPS cannot be replayed", http.StatusForbidden)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "Cannot tunnel", http.StatusInternalServerError)
+		return
+	}
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	p.count(func(s *Stats) { s.Tunnelled++ })
+	log.WithFields(log.Fields{
+		"host": r.Host,
+	}).Warning("HTTPS traffic is not recorded and cannot be replayed")
+
+	client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+// writeAtomic will write the file through a temporary, so that an
+// interrupted recording never leaves a truncated response behind
+func writeAtomic(path string, fn func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := fn(out); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
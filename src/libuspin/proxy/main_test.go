@@ -0,0 +1,96 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// get will fetch the URL through the proxy
+func get(t *testing.T, proxyURL, target string) (int, string) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatalf("Invalid proxy URL: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+	res, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("Failed to fetch %v: %v", target, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Failed to read %v: %v", target, err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-proxy")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eopkg-index.xml.xz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("index"))
+	}))
+	index := upstream.URL + "/eopkg-index.xml.xz"
+	missing := upstream.URL + "/missing.eopkg"
+
+	rec := New(dir, ModeRecord)
+	addr, err := rec.Start()
+	if err != nil {
+		t.Fatalf("Cannot start proxy: %v", err)
+	}
+	if status, body := get(t, addr, index); status != http.StatusOK || body != "index" {
+		t.Fatalf("Wrong response when recording: %v %v", status, body)
+	}
+	if status, _ := get(t, addr, missing); status != http.StatusNotFound {
+		t.Fatalf("Failures should be passed on, got %v", status)
+	}
+	rec.Stop()
+	if stats := rec.Stats(); stats.Recorded != 1 {
+		t.Fatalf("Expected one recorded response: %+v", stats)
+	}
+
+	// Replaying must not need the network at all
+	upstream.Close()
+	play := New(dir, ModeReplay)
+	if addr, err = play.Start(); err != nil {
+		t.Fatalf("Cannot start proxy: %v", err)
+	}
+	defer play.Stop()
+	if status, body := get(t, addr, index); status != http.StatusOK || body != "index" {
+		t.Fatalf("Wrong response when replaying: %v %v", status, body)
+	}
+	if status, _ := get(t, addr, missing); status != http.StatusGatewayTimeout {
+		t.Fatalf("Unrecorded requests should be refused, got %v", status)
+	}
+	if stats := play.Stats(); stats.Replayed != 1 || stats.Missed != 1 {
+		t.Fatalf("Wrong replay stats: %+v", stats)
+	}
+}
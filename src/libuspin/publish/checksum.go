@@ -0,0 +1,98 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumHashes maps each configured algorithm to its implementation
+var checksumHashes = map[config.ChecksumAlgorithm]func() hash.Hash{
+	config.ChecksumSHA256: sha256.New,
+	config.ChecksumSHA512: sha512.New,
+}
+
+// ChecksumPath returns the path of the checksum file for the artifact,
+// i.e. "image.iso.sha256sum"
+func ChecksumPath(path string, algorithm config.ChecksumAlgorithm) string {
+	return path + algorithm.Suffix()
+}
+
+// isChecksumFile determines if the file was written by WriteChecksum
+func isChecksumFile(name string) bool {
+	for algorithm := range checksumHashes {
+		if strings.HasSuffix(name, ChecksumPath("", algorithm)) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteChecksum will write the digest of the artifact alongside it in the
+// format understood by sha256sum -c, returning the path of the new file.
+func WriteChecksum(path string, algorithm config.ChecksumAlgorithm) (string, error) {
+	newHash, ok := checksumHashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("Unsupported checksum algorithm: %v", algorithm)
+	}
+	sum, err := hashFile(path, newHash())
+	if err != nil {
+		return "", err
+	}
+	output := ChecksumPath(path, algorithm)
+	line := fmt.Sprintf("%v  %v\n", sum, filepath.Base(path))
+	if err := ioutil.WriteFile(output+".tmp", []byte(line), 00644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(output+".tmp", output); err != nil {
+		os.Remove(output + ".tmp")
+		return "", err
+	}
+	return output, nil
+}
+
+// WriteChecksums will checksum every artifact with each algorithm, detach
+// signing the results when a key is given. The paths of all new files are
+// returned, so that they may be published with the artifacts.
+func WriteChecksums(paths []string, conf *config.SectionChecksums) ([]string, error) {
+	var ret []string
+	for _, path := range paths {
+		for _, algorithm := range conf.Algorithms {
+			output, err := WriteChecksum(path, algorithm)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, output)
+			if conf.SignKey == "" {
+				continue
+			}
+			if err := Sign(output, conf.SignKey); err != nil {
+				return nil, err
+			}
+			ret = append(ret, output+SignatureSuffix)
+		}
+	}
+	return ret, nil
+}
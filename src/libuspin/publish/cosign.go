@@ -0,0 +1,125 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"libuspin/config"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CosignSignatureSuffix names the base64 signature written by cosign
+	CosignSignatureSuffix = ".sig"
+
+	// CosignCertificateSuffix names the signing certificate of keyless signing
+	CosignCertificateSuffix = ".pem"
+
+	// CosignBundleSuffix names the bundle, including the transparency log entry
+	CosignBundleSuffix = ".cosign.bundle"
+)
+
+// A CosignSignature records the cosign signature of a single artifact along
+// with its entry in the Rekor transparency log.
+type CosignSignature struct {
+	File        string `json:"file"`
+	Signature   string `json:"signature"`
+	Certificate string `json:"certificate,omitempty"` // Keyless signing only
+	Bundle      string `json:"bundle"`
+
+	LogIndex       int64  `json:"logIndex"`
+	LogID          string `json:"logID"`
+	IntegratedTime int64  `json:"integratedTime"`
+}
+
+// cosignBundle is the subset of the cosign bundle format we care about
+type cosignBundle struct {
+	RekorBundle *struct {
+		Payload struct {
+			IntegratedTime int64  `json:"integratedTime"`
+			LogIndex       int64  `json:"logIndex"`
+			LogID          string `json:"logID"`
+		} `json:"Payload"`
+	} `json:"rekorBundle"`
+}
+
+// isCosignFile determines if the file was written by cosign
+func isCosignFile(name string) bool {
+	return strings.HasSuffix(name, CosignSignatureSuffix) ||
+		strings.HasSuffix(name, CosignCertificateSuffix) ||
+		strings.HasSuffix(name, CosignBundleSuffix)
+}
+
+// parseCosignBundle will read the transparency log entry from the bundle
+func parseCosignBundle(path string, sig *CosignSignature) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	bundle := &cosignBundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return fmt.Errorf("Invalid cosign bundle %v: %v", path, err)
+	}
+	if bundle.RekorBundle == nil {
+		return fmt.Errorf("Cosign bundle %v has no transparency log entry", path)
+	}
+	sig.LogIndex = bundle.RekorBundle.Payload.LogIndex
+	sig.LogID = bundle.RekorBundle.Payload.LogID
+	sig.IntegratedTime = bundle.RekorBundle.Payload.IntegratedTime
+	return nil
+}
+
+// cosignRelease will sign every artifact of the release with cosign, storing
+// the signatures and transparency log entries in the release descriptor.
+func (p *Publisher) cosignRelease(rel *Release) error {
+	dir := filepath.Join(p.Dir, rel.Path)
+	for _, file := range rel.Files {
+		sig := &CosignSignature{
+			File:      file,
+			Signature: file + CosignSignatureSuffix,
+			Bundle:    file + CosignBundleSuffix,
+		}
+		args := []string{
+			"sign-blob",
+			"--yes",
+			"--output-signature",
+			filepath.Join(dir, sig.Signature),
+			"--bundle",
+			filepath.Join(dir, sig.Bundle),
+		}
+		if p.cosign == config.CosignKeyless {
+			sig.Certificate = file + CosignCertificateSuffix
+			args = append(args, "--output-certificate", filepath.Join(dir, sig.Certificate))
+		} else {
+			args = append(args, "--key", p.cosign)
+		}
+		args = append(args, filepath.Join(dir, file))
+
+		if err := commands.ExecStdoutArgs("cosign", args); err != nil {
+			return fmt.Errorf("Failed to sign %v: %v", file, err)
+		}
+		if err := parseCosignBundle(filepath.Join(dir, sig.Bundle), sig); err != nil {
+			return err
+		}
+		rel.Signatures = append(rel.Signatures, sig)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// atomFeed is the minimal subset of RFC 4287 needed for a release feed
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link,omitempty"`
+	Summary string     `xml:"summary"`
+}
+
+// releaseID will return a stable identifier for the path within the tree
+func releaseID(baseURL, path string) string {
+	if baseURL != "" {
+		return baseURL + "/" + path
+	}
+	return "urn:uspin:" + path
+}
+
+// WriteFeed will regenerate the Atom feed at the root of the release tree,
+// listing every release newest first.
+func WriteFeed(dir, title, baseURL string) error {
+	releases, err := Releases(dir)
+	if err != nil {
+		return err
+	}
+
+	feed := &atomFeed{
+		Title:   title + " releases",
+		ID:      releaseID(baseURL, FeedFile),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if baseURL != "" {
+		feed.Links = []atomLink{{Href: baseURL + "/" + FeedFile, Rel: "self"}}
+	}
+	if len(releases) > 0 {
+		feed.Updated = releases[0].Date.Format(time.RFC3339)
+	}
+	for _, rel := range releases {
+		title := rel.Title + " " + rel.Name
+		if rel.Edition != "" {
+			title += " (" + rel.Edition + ", " + rel.Arch + ")"
+		}
+		entry := atomEntry{
+			Title:   title,
+			ID:      releaseID(baseURL, rel.Path+"/"),
+			Updated: rel.Date.Format(time.RFC3339),
+			Summary: rel.Summary,
+		}
+		if baseURL != "" {
+			entry.Links = []atomLink{{Href: baseURL + "/" + rel.Path + "/" + NotesFile}}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	out, err := os.Create(filepath.Join(dir, FeedFile) + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = out.WriteString(xml.Header); err == nil {
+		enc := xml.NewEncoder(out)
+		enc.Indent("", "  ")
+		err = enc.Encode(feed)
+	}
+	out.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), filepath.Join(dir, FeedFile))
+}
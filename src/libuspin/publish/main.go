@@ -0,0 +1,294 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package publish places the artifacts of successful builds into a release
+// tree, maintaining release notes and an optional feed for subscribers.
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/config"
+	"libuspin/lock"
+	"libuspin/tree"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ReleaseFile describes each release within its directory
+	ReleaseFile = "release.json"
+
+	// ManifestFile is the stored plan of the build, used to compute the
+	// package changes between releases
+	ManifestFile = "manifest.json"
+
+	// NotesFile is the generated release notes stub
+	NotesFile = "NOTES.md"
+
+	// FeedFile is the Atom feed at the root of the release tree
+	FeedFile = "releases.atom"
+)
+
+// A Release is a single published build
+type Release struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // Directory of the artifacts, relative to the tree
+	Title   string    `json:"title"`
+	Edition string    `json:"edition,omitempty"`
+	Arch    string    `json:"arch,omitempty"`
+	Date    time.Time `json:"date"`
+	Files   []string  `json:"files"`
+	Summary string    `json:"summary"` // Short description of the package changes
+
+	Signatures []*CosignSignature `json:"signatures,omitempty"`
+}
+
+// A Publisher places build artifacts into the configured release tree
+type Publisher struct {
+	Dir     string // Root of the release tree
+	conf    *config.SectionPublish
+	img     *libuspin.ImageSpec
+	title   string
+	edition string
+	cosign  string // Resolved cosign key reference
+}
+
+// NewPublisher will return a Publisher for the ImageSpec, or nil if
+// publishing has not been configured.
+func NewPublisher(img *libuspin.ImageSpec) *Publisher {
+	conf := &img.Config.Publish
+	if conf.Directory == "" {
+		return nil
+	}
+	edition := conf.Edition
+	if edition == "" {
+		edition = strings.TrimSuffix(filepath.Base(img.SpinFile), ".spin")
+	}
+	cosign := conf.Cosign
+	if cosign != "" && cosign != config.CosignKeyless && !strings.Contains(cosign, "://") {
+		cosign = img.JoinPath(cosign)
+	}
+	return &Publisher{
+		Dir:     img.JoinPath(conf.Directory),
+		conf:    conf,
+		img:     img,
+		title:   img.Config.Branding.Title,
+		edition: edition,
+		cosign:  cosign,
+	}
+}
+
+// path returns the artifact directory of the named release, relative to Dir
+func (p *Publisher) path(name string) string {
+	if p.conf.Layout == config.PublishLayoutMirror {
+		return filepath.Join(name, p.edition, p.conf.Arch)
+	}
+	return name
+}
+
+// releaseName will determine the name of the next release, defaulting to the
+// date with a numeric suffix for multiple releases on the same day.
+func (p *Publisher) releaseName(now time.Time) (string, error) {
+	if p.conf.Release != "" {
+		if _, err := os.Stat(filepath.Join(p.Dir, p.path(p.conf.Release))); err == nil {
+			return "", fmt.Errorf("Release %v has already been published", p.path(p.conf.Release))
+		}
+		return p.conf.Release, nil
+	}
+	base := now.Format("20060102")
+	name := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(p.Dir, p.path(name))); os.IsNotExist(err) {
+			return name, nil
+		}
+		name = fmt.Sprintf("%v.%d", base, n)
+	}
+}
+
+// Publish will copy the given files into a new release directory along with
+// the build plan, then write the release notes and update the feed.
+func (p *Publisher) Publish(files []string, plan *libuspin.Plan) (*Release, error) {
+	// Concurrent publishers would otherwise pick the same release name
+	l, err := lock.Acquire(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	now := time.Now().UTC()
+	name, err := p.releaseName(now)
+	if err != nil {
+		return nil, err
+	}
+	rel := &Release{
+		Name:  name,
+		Path:  p.path(name),
+		Title: p.title,
+		Date:  now,
+	}
+	if p.conf.Layout == config.PublishLayoutMirror {
+		rel.Edition = p.edition
+		rel.Arch = p.conf.Arch
+	}
+	previous, err := Latest(p.Dir, rel.Edition, rel.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(p.Dir, rel.Path)
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		base := filepath.Base(file)
+		if err := tree.CopyFile(file, filepath.Join(dir, base)); err != nil {
+			return nil, err
+		}
+		rel.Files = append(rel.Files, base)
+	}
+	if err := writeManifest(filepath.Join(dir, ManifestFile), plan); err != nil {
+		return nil, err
+	}
+	rel.Files = append(rel.Files, ManifestFile)
+
+	// Compare against the previous release, if it has a usable manifest
+	var prevPlan *libuspin.Plan
+	if previous != nil {
+		if prevPlan, err = libuspin.LoadPlan(filepath.Join(p.Dir, previous.Path, ManifestFile)); err != nil {
+			prevPlan = nil
+		}
+	}
+	diff := DiffPlans(prevPlan, plan)
+	rel.Summary = diff.Summary()
+	if err := writeNotes(filepath.Join(dir, NotesFile), rel, previous, diff); err != nil {
+		return nil, err
+	}
+	rel.Files = append(rel.Files, NotesFile)
+
+	artifacts := append([]string(nil), rel.Files[:len(files)]...)
+	if err := p.writeProvenance(rel, artifacts); err != nil {
+		return nil, err
+	}
+
+	if p.cosign != "" {
+		if err := p.cosignRelease(rel); err != nil {
+			return nil, err
+		}
+	}
+
+	mirror := p.conf.Layout == config.PublishLayoutMirror
+	if mirror {
+		if err := p.writeChecksums(rel); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeJSON(filepath.Join(dir, ReleaseFile), rel); err != nil {
+		return nil, err
+	}
+	if err := p.writeSums(filepath.Join(p.Dir, rel.Name)); err != nil {
+		return nil, err
+	}
+	if mirror {
+		if err := p.finishMirror(rel); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.conf.Feed {
+		if err := WriteFeed(p.Dir, p.title, p.conf.BaseURL); err != nil {
+			return nil, err
+		}
+	}
+	return rel, nil
+}
+
+// Releases will return all releases within the tree, newest first
+func Releases(dir string) ([]*Release, error) {
+	var ret []*Release
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		// Never descend through the "latest" symlinks
+		if info.Mode()&os.ModeSymlink != 0 || info.Name() != ReleaseFile {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel := &Release{}
+		if err := json.Unmarshal(data, rel); err != nil {
+			return fmt.Errorf("Invalid release %v: %v", path, err)
+		}
+		ret = append(ret, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Date.After(ret[j].Date)
+	})
+	return ret, nil
+}
+
+// Latest will return the most recent release of the edition and architecture
+// within the tree, if any
+func Latest(dir, edition, arch string) (*Release, error) {
+	releases, err := Releases(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range releases {
+		if rel.Edition == edition && rel.Arch == arch {
+			return rel, nil
+		}
+	}
+	return nil, nil
+}
+
+// writeManifest will store the build plan within the release
+func writeManifest(path string, plan *libuspin.Plan) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return plan.WriteJSON(out)
+}
+
+// writeJSON will atomically write v to path
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 00644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
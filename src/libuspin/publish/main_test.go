@@ -0,0 +1,498 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/config"
+	"libuspin/lock"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffPlans(t *testing.T) {
+	old := &libuspin.Plan{Versions: map[string]string{
+		"bash":  "4.4-10",
+		"nano":  "2.7.1-60",
+		"vim":   "8.0-1",
+		"glibc": "2.24-50",
+	}}
+	new := &libuspin.Plan{Versions: map[string]string{
+		"bash":  "4.4-10",
+		"nano":  "2.7.2-61",
+		"glibc": "2.24-50",
+		"zsh":   "5.2-3",
+	}}
+	d := DiffPlans(old, new)
+	if len(d.Added) != 1 || d.Added[0].Name != "zsh" {
+		t.Fatalf("Incorrect added packages: %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Name != "vim" {
+		t.Fatalf("Incorrect removed packages: %v", d.Removed)
+	}
+	if len(d.Updated) != 1 || d.Updated[0].Old != "2.7.1-60" || d.Updated[0].New != "2.7.2-61" {
+		t.Fatalf("Incorrect updated packages: %v", d.Updated)
+	}
+	if s := d.Summary(); s != "1 added, 1 removed, 1 updated" {
+		t.Fatalf("Incorrect summary: %v", s)
+	}
+	if d := DiffPlans(nil, new); !d.Initial || len(d.Added) != 4 {
+		t.Fatalf("Initial release should add all packages")
+	}
+}
+
+func TestFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	date := time.Date(2016, time.December, 1, 2, 0, 0, 0, time.UTC)
+	for i, name := range []string{"20161201", "20161202"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 00755); err != nil {
+			t.Fatalf("Failed to create release: %v", err)
+		}
+		rel := &Release{
+			Name:    name,
+			Path:    name,
+			Title:   "Solus",
+			Date:    date.AddDate(0, 0, i),
+			Summary: "No package changes",
+		}
+		if err := writeJSON(filepath.Join(dir, name, ReleaseFile), rel); err != nil {
+			t.Fatalf("Failed to write release: %v", err)
+		}
+	}
+
+	latest, err := Latest(dir, "", "")
+	if err != nil || latest == nil || latest.Name != "20161202" {
+		t.Fatalf("Incorrect latest release: %v %v", latest, err)
+	}
+
+	if err := WriteFeed(dir, "Solus", "https://example.com/iso"); err != nil {
+		t.Fatalf("Failed to write feed: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, FeedFile))
+	if err != nil {
+		t.Fatalf("Failed to read feed: %v", err)
+	}
+	feed := &atomFeed{}
+	if err := xml.Unmarshal(data, feed); err != nil {
+		t.Fatalf("Invalid feed: %v", err)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "Solus 20161202" {
+		t.Fatalf("Incorrect feed entries: %v", feed.Entries)
+	}
+	if !strings.HasPrefix(feed.Entries[1].ID, "https://example.com/iso/20161201") {
+		t.Fatalf("Incorrect entry ID: %v", feed.Entries[1].ID)
+	}
+}
+
+func TestMirrorIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifacts := filepath.Join(dir, "1.0", "budgie", "x86_64")
+	if err := os.MkdirAll(artifacts, 00755); err != nil {
+		t.Fatalf("Failed to create release: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(artifacts, "solus.iso"), []byte("iso"), 00644); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+	p := &Publisher{Dir: dir, conf: &config.SectionPublish{}}
+	rel := &Release{Name: "1.0", Path: "1.0/budgie/x86_64", Files: []string{"solus.iso"}}
+	if err := p.writeChecksums(rel); err != nil {
+		t.Fatalf("Failed to write checksums: %v", err)
+	}
+	if err := p.finishMirror(rel); err != nil {
+		t.Fatalf("Failed to finish mirror: %v", err)
+	}
+
+	want := "e0e4548df88a35d5854d052281c5deedad16f286f82cb2c23f2f9dea494834ac"
+	if sum, err := HashFile(filepath.Join(artifacts, "solus.iso")); err != nil || sum != want {
+		t.Fatalf("Invalid checksum: %v %v", sum, err)
+	}
+	var index []*IndexEntry
+	data, err := ioutil.ReadFile(filepath.Join(dir, "1.0", IndexFile))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("Invalid index: %v", err)
+	}
+	if len(index) != 2 || index[0].Path != "budgie/x86_64/solus.iso" || index[0].Size != 3 {
+		t.Fatalf("Incorrect index: %v", index)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, LatestLink)); err != nil || target != "1.0" {
+		t.Fatalf("Incorrect latest link: %v %v", target, err)
+	}
+}
+
+func TestSums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"solus.iso":              "iso",
+		"solus.iso.sha256":       "ignored",
+		"budgie/x86_64/a.iso":    "iso",
+		"budgie/x86_64/NOTES.md": "notes",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 00644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	p := &Publisher{Dir: dir, conf: &config.SectionPublish{}}
+	if err := p.writeSums(dir); err != nil {
+		t.Fatalf("Failed to write sums: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, SumsFile))
+	if err != nil {
+		t.Fatalf("Failed to read sums: %v", err)
+	}
+	want := "e0e4548df88a35d5854d052281c5deedad16f286f82cb2c23f2f9dea494834ac  solus.iso\n"
+	if string(data) != want {
+		t.Fatalf("Incorrect sums: %v", string(data))
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "budgie", "x86_64", SumsFile))
+	if err != nil || strings.Count(string(data), "\n") != 2 {
+		t.Fatalf("Incorrect nested sums: %v %v", string(data), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "budgie", SumsFile)); !os.IsNotExist(err) {
+		t.Fatalf("Empty directories should not have sums")
+	}
+}
+
+func TestWriteChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	iso := filepath.Join(dir, "solus.iso")
+	if err := ioutil.WriteFile(iso, []byte("iso"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	conf := &config.SectionChecksums{
+		Algorithms: []config.ChecksumAlgorithm{config.ChecksumSHA256, config.ChecksumSHA512},
+	}
+	written, err := WriteChecksums([]string{iso}, conf)
+	if err != nil {
+		t.Fatalf("Failed to write checksums: %v", err)
+	}
+	if len(written) != 2 || written[0] != iso+".sha256sum" || written[1] != iso+".sha512sum" {
+		t.Fatalf("Incorrect checksum files: %v", written)
+	}
+	data, err := ioutil.ReadFile(written[0])
+	if err != nil {
+		t.Fatalf("Failed to read checksum: %v", err)
+	}
+	want := "e0e4548df88a35d5854d052281c5deedad16f286f82cb2c23f2f9dea494834ac  solus.iso\n"
+	if string(data) != want {
+		t.Fatalf("Incorrect checksum: %v", string(data))
+	}
+	data, err = ioutil.ReadFile(written[1])
+	if err != nil || len(strings.Fields(string(data))[0]) != 128 {
+		t.Fatalf("Incorrect SHA512 checksum: %v %v", string(data), err)
+	}
+	for _, path := range written {
+		if isSumsCandidate(filepath.Base(path)) {
+			t.Fatalf("Checksum files should not be listed in %v: %v", SumsFile, path)
+		}
+	}
+}
+
+func TestVerifyArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	iso := filepath.Join(dir, "solus.iso")
+	manifest := filepath.Join(dir, ManifestFile)
+	if err := ioutil.WriteFile(iso, []byte("iso"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := VerifyArtifact(iso, &VerifyOptions{}); err == nil {
+		t.Fatalf("An artifact without checksums should not verify")
+	}
+
+	if err := writeManifest(manifest, &libuspin.Plan{ImageType: config.ImageTypeLiveOS}); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if _, err := WriteChecksum(iso, config.ChecksumSHA512); err != nil {
+		t.Fatalf("Failed to write checksum: %v", err)
+	}
+	isoSum, _ := HashFile(iso)
+	manifestSum, _ := HashFile(manifest)
+	statement := &Statement{
+		Subject: []Subject{{Name: "solus.iso", Digest: DigestSet{"sha256": isoSum}}},
+	}
+	statement.Predicate.Invocation.Parameters = map[string]string{"imageType": "liveos"}
+	statement.Predicate.Materials = []Material{{URI: "file://" + manifest, Digest: DigestSet{"sha256": manifestSum}}}
+	if err := writeJSON(iso+ProvenanceSuffix, statement); err != nil {
+		t.Fatalf("Failed to write provenance: %v", err)
+	}
+
+	opts := &VerifyOptions{Manifest: manifest}
+	if problems, err := VerifyArtifact(iso, opts); err != nil || len(problems) != 0 {
+		t.Fatalf("Untouched artifact failed to verify: %v %v", problems, err)
+	}
+
+	if err := writeManifest(manifest, &libuspin.Plan{ImageType: config.ImageTypeDisk}); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if err := ioutil.WriteFile(iso, []byte("tampered"), 00644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	problems, err := VerifyArtifact(iso, opts)
+	if err != nil {
+		t.Fatalf("Failed to verify artifact: %v", err)
+	}
+	// Checksum, subject digest, manifest digest and image type all differ
+	if len(problems) != 4 {
+		t.Fatalf("Incorrect problems: %v", problems)
+	}
+}
+
+func TestCosignBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := `{
+    "base64Signature": "MEUCIQ==",
+    "rekorBundle": {
+        "SignedEntryTimestamp": "MEQCIA==",
+        "Payload": {
+            "body": "eyJhcGlWZXJzaW9uIjoiMC4wLjEifQ==",
+            "integratedTime": 1480557600,
+            "logIndex": 12345,
+            "logID": "c0d23d6ad406973f"
+        }
+    }
+}`
+	path := filepath.Join(dir, "solus.iso"+CosignBundleSuffix)
+	if err := ioutil.WriteFile(path, []byte(bundle), 00644); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+	sig := &CosignSignature{}
+	if err := parseCosignBundle(path, sig); err != nil {
+		t.Fatalf("Failed to parse bundle: %v", err)
+	}
+	if sig.LogIndex != 12345 || sig.LogID != "c0d23d6ad406973f" || sig.IntegratedTime != 1480557600 {
+		t.Fatalf("Incorrect transparency log entry: %v", sig)
+	}
+	if isSumsCandidate("solus.iso"+CosignBundleSuffix) || isSumsCandidate("solus.iso.sig") {
+		t.Fatalf("Cosign files should not be in SHA256SUMS")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := libuspin.NewImageSpec("../../../testdata/minimal.spin")
+	if err != nil {
+		t.Fatalf("Failed to load image spec: %v", err)
+	}
+	conf := &config.SectionPublish{BuilderID: "uspin://test"}
+	p := &Publisher{Dir: dir, conf: conf, img: img}
+	rel := &Release{Name: "1.0", Path: "1.0", Date: time.Now().UTC()}
+	for name, content := range map[string]string{"solus.iso": "iso", ManifestFile: "{}"} {
+		if err := os.MkdirAll(filepath.Join(dir, rel.Path), 00755); err != nil {
+			t.Fatalf("Failed to create release: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, rel.Path, name), []byte(content), 00644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := p.writeProvenance(rel, []string{"solus.iso"}); err != nil {
+		t.Fatalf("Failed to write provenance: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, rel.Path, "solus.iso"+ProvenanceSuffix))
+	if err != nil {
+		t.Fatalf("Failed to read provenance: %v", err)
+	}
+	statement := &Statement{}
+	if err := json.Unmarshal(data, statement); err != nil {
+		t.Fatalf("Invalid provenance: %v", err)
+	}
+	if statement.Subject[0].Digest["sha256"] != "e0e4548df88a35d5854d052281c5deedad16f286f82cb2c23f2f9dea494834ac" {
+		t.Fatalf("Incorrect subject: %v", statement.Subject)
+	}
+	if statement.Predicate.Builder.ID != "uspin://test" {
+		t.Fatalf("Incorrect builder: %v", statement.Predicate.Builder.ID)
+	}
+	if len(statement.Predicate.Materials) < 3 {
+		t.Fatalf("Missing materials: %v", statement.Predicate.Materials)
+	}
+	if rel.Files[0] != "solus.iso"+ProvenanceSuffix {
+		t.Fatalf("Provenance should be a release file: %v", rel.Files)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	img, err := libuspin.NewImageSpec("../../../testdata/minimal.spin")
+	if err != nil {
+		t.Fatalf("Failed to load image spec: %v", err)
+	}
+	now := time.Date(2016, 12, 31, 0, 0, 0, 0, time.UTC)
+	if dir, err := OutputDir(img, now); err != nil || dir != "." {
+		t.Fatalf("Incorrect output directory without a template: %v %v", dir, err)
+	}
+	// Relative to the same base as the filename, not the .spin file
+	img.Config.Image.OutputDir = "out/{{.Edition}}/{{.Date}}"
+	if dir, err := OutputDir(img, now); err != nil || dir != filepath.Join("out", "minimal", "20161231") {
+		t.Fatalf("Incorrect output directory from a relative template: %v %v", dir, err)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := libuspin.NewImageSpec("../../../testdata/minimal.spin")
+	if err != nil {
+		t.Fatalf("Failed to load image spec: %v", err)
+	}
+	lock.LockDir = filepath.Join(dir, "locks")
+	img.Config.Image.OutputDir = filepath.Join(dir, "out", "{{.Edition}}")
+	img.Config.Image.OutputCollision = config.OutputCollisionSuffix
+	img.Staging = filepath.Join(dir, "staging")
+
+	stage := func() {
+		os.MkdirAll(img.Staging, 00755)
+		ioutil.WriteFile(img.OutputFilename(), []byte("iso"), 00644)
+		ioutil.WriteFile(img.OutputFilename()+".licenses.html", []byte("html"), 00644)
+	}
+	stage()
+	outputs, err := Deliver(img, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to deliver outputs: %v", err)
+	}
+	want := filepath.Join(dir, "out", "minimal", "Solus-1.2.1.iso")
+	if len(outputs) != 2 || outputs[0] != want || outputs[1] != want+".licenses.html" {
+		t.Fatalf("Incorrect outputs: %v", outputs)
+	}
+	if _, err := os.Stat(img.OutputFilename()); !os.IsNotExist(err) {
+		t.Fatalf("Staged image should have been moved: %v", err)
+	}
+	sum, err := ioutil.ReadFile(want + ChecksumSuffix)
+	if err != nil || !strings.HasSuffix(string(sum), "  Solus-1.2.1.iso\n") {
+		t.Fatalf("Incorrect checksum: %q %v", sum, err)
+	}
+
+	// Existing artifacts are never replaced by version-suffix
+	stage()
+	if outputs, err = Deliver(img, time.Now()); err != nil {
+		t.Fatalf("Failed to deliver outputs: %v", err)
+	}
+	want = filepath.Join(dir, "out", "minimal", "Solus-1.2.1-1.iso")
+	if outputs[0] != want || outputs[1] != want+".licenses.html" {
+		t.Fatalf("Incorrect suffixed outputs: %v", outputs)
+	}
+	sum, err = ioutil.ReadFile(want + ChecksumSuffix)
+	if err != nil || !strings.HasSuffix(string(sum), "  Solus-1.2.1-1.iso\n") {
+		t.Fatalf("Incorrect suffixed checksum: %q %v", sum, err)
+	}
+
+	stage()
+	img.Config.Image.OutputCollision = config.OutputCollisionError
+	if _, err = Deliver(img, time.Now()); err == nil {
+		t.Fatalf("Existing outputs should be an error")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-publish")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := &Status{
+		Profile: "r&d",
+		Status:  StatusSucceeded,
+		Version: "20161231",
+		Date:    time.Date(2016, 12, 31, 12, 0, 0, 0, time.UTC),
+		Image:   "Solus-1.2.1.iso",
+		Size:    1 << 30,
+	}
+	paths, err := WriteStatus(filepath.Join(dir, "status"), st, true)
+	if err != nil {
+		t.Fatalf("Failed to write status: %v", err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "r&d"+StatusSuffix || filepath.Base(paths[1]) != "r&d"+BadgeSuffix {
+		t.Fatalf("Unexpected status files: %v", paths)
+	}
+	data, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("Failed to read status: %v", err)
+	}
+	var got Status
+	if err := json.Unmarshal(data, &got); err != nil || got != *st {
+		t.Fatalf("Status did not round trip: %+v %v", got, err)
+	}
+
+	// The badge must be well formed, whatever the profile is called
+	var svg struct {
+		Title string `xml:"title"`
+	}
+	data, err = ioutil.ReadFile(paths[1])
+	if err != nil {
+		t.Fatalf("Failed to read badge: %v", err)
+	}
+	if err := xml.Unmarshal(data, &svg); err != nil || svg.Title != "r&d: 20161231" {
+		t.Fatalf("Invalid badge %q: %v", svg.Title, err)
+	}
+
+	st.Status = StatusFailed
+	if !strings.Contains(string(st.Badge()), ">failed</text>") {
+		t.Fatalf("Failed builds should show in the badge: %s", st.Badge())
+	}
+}
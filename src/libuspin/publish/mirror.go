@@ -0,0 +1,180 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// IndexFile lists every artifact within a release of the mirror layout
+	IndexFile = "index.json"
+
+	// LatestLink always points to the newest release in the mirror layout
+	LatestLink = "latest"
+
+	// ChecksumSuffix is appended to each artifact to name its checksum file
+	ChecksumSuffix = ".sha256"
+
+	// SignatureSuffix is appended to a file to name its detached signature
+	SignatureSuffix = ".asc"
+
+	// BinarySignatureSuffix names a binary detached signature
+	BinarySignatureSuffix = ".gpg"
+)
+
+// An IndexEntry describes a single file within the release index
+type IndexEntry struct {
+	Path   string `json:"path"` // Relative to the release directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// HashFile returns the hex encoded SHA256 digest of the file
+func HashFile(path string) (string, error) {
+	return hashFile(path, sha256.New())
+}
+
+// hashFile returns the hex encoded digest of the file using the given hash
+func hashFile(path string, h hash.Hash) (string, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fi.Close()
+	if _, err := io.Copy(h, fi); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksums will store a checksum file alongside each release artifact
+func (p *Publisher) writeChecksums(rel *Release) error {
+	dir := filepath.Join(p.Dir, rel.Path)
+	var sums []string
+	for _, file := range rel.Files {
+		if !isSumsCandidate(file) {
+			continue
+		}
+		sum, err := HashFile(filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		// Same format as sha256sum so that "sha256sum -c" works
+		line := fmt.Sprintf("%v  %v\n", sum, file)
+		if err := ioutil.WriteFile(filepath.Join(dir, file+ChecksumSuffix), []byte(line), 00644); err != nil {
+			return err
+		}
+		sums = append(sums, file+ChecksumSuffix)
+	}
+	rel.Files = append(rel.Files, sums...)
+	return nil
+}
+
+// finishMirror will regenerate the signed index for the release and point the
+// latest symlink at it.
+func (p *Publisher) finishMirror(rel *Release) error {
+	// Other editions may share the release, so the index covers the tree
+	releaseDir := filepath.Join(p.Dir, rel.Name)
+	if err := writeIndex(releaseDir); err != nil {
+		return err
+	}
+	if p.conf.SignKey != "" {
+		if err := Sign(filepath.Join(releaseDir, IndexFile), p.conf.SignKey); err != nil {
+			return err
+		}
+	}
+	return updateLatest(p.Dir, rel.Name)
+}
+
+// writeIndex will list every file within the release directory
+func writeIndex(dir string) error {
+	var index []*IndexEntry
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == IndexFile || rel == IndexFile+SignatureSuffix || strings.HasSuffix(rel, ".tmp") {
+			return nil
+		}
+		sum, err := HashFile(path)
+		if err != nil {
+			return err
+		}
+		index = append(index, &IndexEntry{
+			Path:   filepath.ToSlash(rel),
+			Size:   info.Size(),
+			SHA256: sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, IndexFile), index)
+}
+
+// Sign will create an ASCII armored detached signature for the file
+func Sign(path, key string) error {
+	return sign(path, path+SignatureSuffix, key, true)
+}
+
+// SignBinary will create a binary detached signature for the file, as
+// conventionally used for SHA256SUMS.gpg
+func SignBinary(path, key string) error {
+	return sign(path, path+BinarySignatureSuffix, key, false)
+}
+
+func sign(path, output, key string, armor bool) error {
+	args := []string{
+		"--batch",
+		"--yes",
+		"--local-user",
+		key,
+		"--detach-sign",
+		"--output",
+		output,
+		path,
+	}
+	if armor {
+		args = append([]string{"--armor"}, args...)
+	}
+	return commands.ExecStdoutArgs("gpg", args)
+}
+
+// updateLatest will atomically point the latest symlink at the release
+func updateLatest(dir, name string) error {
+	tmp := filepath.Join(dir, LatestLink+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, LatestLink))
+}
@@ -0,0 +1,153 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"fmt"
+	"libuspin"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A PackageChange records a single package differing between two releases
+type PackageChange struct {
+	Name string
+	Old  string // Previous version, empty when added
+	New  string // New version, empty when removed
+}
+
+// A Diff is the set of package changes between two releases
+type Diff struct {
+	Initial bool // No previous release to compare against
+	Added   []PackageChange
+	Removed []PackageChange
+	Updated []PackageChange
+}
+
+// planVersions returns every package in the plan mapped to its version, using
+// the most complete package set the plan has.
+func planVersions(p *libuspin.Plan) map[string]string {
+	ret := make(map[string]string)
+	for name, version := range p.Versions {
+		ret[name] = version
+	}
+	for name := range p.Dependencies {
+		if _, ok := ret[name]; !ok {
+			ret[name] = ""
+		}
+	}
+	for _, name := range p.Packages() {
+		if _, ok := ret[name]; !ok {
+			ret[name] = ""
+		}
+	}
+	return ret
+}
+
+// DiffPlans will compute the package changes from old to new. old may be nil
+// when there is no previous release.
+func DiffPlans(old, new *libuspin.Plan) *Diff {
+	d := &Diff{}
+	newVersions := planVersions(new)
+	if old == nil {
+		d.Initial = true
+		for name, version := range newVersions {
+			d.Added = append(d.Added, PackageChange{Name: name, New: version})
+		}
+		d.sort()
+		return d
+	}
+	oldVersions := planVersions(old)
+	for name, version := range newVersions {
+		prev, ok := oldVersions[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, PackageChange{Name: name, New: version})
+		case prev != version:
+			d.Updated = append(d.Updated, PackageChange{Name: name, Old: prev, New: version})
+		}
+	}
+	for name, version := range oldVersions {
+		if _, ok := newVersions[name]; !ok {
+			d.Removed = append(d.Removed, PackageChange{Name: name, Old: version})
+		}
+	}
+	d.sort()
+	return d
+}
+
+func (d *Diff) sort() {
+	for _, changes := range [][]PackageChange{d.Added, d.Removed, d.Updated} {
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Name < changes[j].Name
+		})
+	}
+}
+
+// Summary returns a one line description of the changes
+func (d *Diff) Summary() string {
+	if d.Initial {
+		return fmt.Sprintf("Initial release with %d packages", len(d.Added))
+	}
+	if len(d.Added)+len(d.Removed)+len(d.Updated) == 0 {
+		return "No package changes"
+	}
+	return fmt.Sprintf("%d added, %d removed, %d updated", len(d.Added), len(d.Removed), len(d.Updated))
+}
+
+// writeNotes will write the release notes stub, to be fleshed out by hand
+func writeNotes(path string, rel, previous *Release, d *Diff) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	fmt.Fprintf(out, "# %v\n\n", strings.TrimSpace(rel.Title+" "+rel.Name))
+	fmt.Fprintf(out, "Released %v\n\n", rel.Date.Format("2006-01-02"))
+	fmt.Fprintf(out, "## Highlights\n\nTODO: Describe this release.\n\n")
+
+	if d.Initial || previous == nil {
+		fmt.Fprintf(out, "## Packages\n\n%v.\n", d.Summary())
+		return nil
+	}
+	fmt.Fprintf(out, "## Package changes since %v\n\n%v.\n", previous.Name, d.Summary())
+	sections := []struct {
+		title   string
+		changes []PackageChange
+	}{
+		{"Added", d.Added},
+		{"Removed", d.Removed},
+		{"Updated", d.Updated},
+	}
+	for _, section := range sections {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n### %v\n\n", section.title)
+		for _, c := range section.changes {
+			switch {
+			case c.Old != "" && c.New != "":
+				fmt.Fprintf(out, " - %v %v -> %v\n", c.Name, c.Old, c.New)
+			default:
+				fmt.Fprintf(out, " - %v\n", strings.TrimSpace(c.Name+" "+c.Old+c.New))
+			}
+		}
+	}
+	return nil
+}
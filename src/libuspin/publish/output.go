@@ -0,0 +1,208 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/config"
+	"libuspin/lock"
+	"libuspin/tree"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// OutputContext is made available to the Image.OutputDir template
+type OutputContext struct {
+	Name    string // Basename of the .spin file
+	Edition string
+	Arch    string
+	Type    config.ImageType
+	Date    string // Build date, i.e. 20161231
+}
+
+// OutputDir will render the output directory for the image. Without a
+// template, the directory of the configured filename is used. Both are
+// relative to the working directory, i.e. the job directory of the daemon.
+func OutputDir(img *libuspin.ImageSpec, now time.Time) (string, error) {
+	conf := img.Config
+	if conf.Image.OutputDir == "" {
+		return filepath.Dir(img.OutputTarget()), nil
+	}
+	ctx := &OutputContext{
+		Name:    strings.TrimSuffix(filepath.Base(img.SpinFile), ".spin"),
+		Edition: conf.Publish.Edition,
+		Arch:    conf.Publish.Arch,
+		Type:    conf.Image.Type,
+		Date:    now.Format("20060102"),
+	}
+	if ctx.Edition == "" {
+		ctx.Edition = ctx.Name
+	}
+	tmpl, err := template.New("output_dir").Option("missingkey=error").Parse(conf.Image.OutputDir)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return filepath.Clean(buf.String()), nil
+}
+
+// stagedArtifacts returns the staged image followed by its companion files,
+// i.e. the license report, which share the image name as a prefix.
+func stagedArtifacts(primary string) ([]string, error) {
+	if _, err := os.Stat(primary); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(primary + "?*")
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, match := range matches {
+		if !strings.HasSuffix(match, ChecksumSuffix) {
+			ret = append(ret, match)
+		}
+	}
+	sort.Strings(ret)
+	return append([]string{primary}, ret...), nil
+}
+
+// suffixName will insert "-N" before the extension of the name
+func suffixName(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%v-%d%v", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// targetName will choose the final image name within dir, according to the
+// collision policy. Companions keep their suffix relative to the image.
+func targetName(dir, name string, companions []string, policy config.OutputCollision) (string, error) {
+	exists := func(name string) bool {
+		for _, file := range append([]string{""}, companions...) {
+			if _, err := os.Lstat(filepath.Join(dir, name+file)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	switch policy {
+	case config.OutputCollisionError:
+		if exists(name) {
+			return "", fmt.Errorf("Output already exists: %v", filepath.Join(dir, name))
+		}
+	case config.OutputCollisionSuffix:
+		for n := 1; exists(name); n++ {
+			if candidate := suffixName(name, n); !exists(candidate) {
+				return candidate, nil
+			}
+		}
+	}
+	return name, nil
+}
+
+// moveFile will atomically replace dst with src, copying via a temporary
+// file within the destination directory when they are on different devices.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	if err := tree.CopyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Deliver will checksum the staged artifacts and only then move them into
+// the output directory, so an incomplete image never appears there. The
+// checksums are moved last. The final artifact paths are returned.
+func Deliver(img *libuspin.ImageSpec, now time.Time) ([]string, error) {
+	staged, err := stagedArtifacts(img.OutputFilename())
+	if err != nil {
+		return nil, err
+	}
+	dir, err := OutputDir(img, now)
+	if err != nil {
+		return nil, err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return nil, err
+	}
+	l, err := lock.Acquire(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	primary := filepath.Base(staged[0])
+	var companions []string
+	for _, file := range staged[1:] {
+		companions = append(companions, strings.TrimPrefix(filepath.Base(file), primary))
+	}
+	name, err := targetName(dir, primary, append(companions, ChecksumSuffix), img.Config.Image.OutputCollision)
+	if err != nil {
+		return nil, err
+	}
+
+	var sums, targets []string
+	for i, file := range staged {
+		target := name
+		if i > 0 {
+			target += companions[i-1]
+		}
+		sum, err := HashFile(file)
+		if err != nil {
+			return nil, err
+		}
+		line := fmt.Sprintf("%v  %v\n", sum, target)
+		if err := ioutil.WriteFile(file+ChecksumSuffix, []byte(line), 00644); err != nil {
+			return nil, err
+		}
+		sums = append(sums, file+ChecksumSuffix)
+		targets = append(targets, filepath.Join(dir, target))
+	}
+
+	for i, file := range staged {
+		if err := moveFile(file, targets[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i, file := range sums {
+		if err := moveFile(file, targets[i]+ChecksumSuffix); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,159 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"path/filepath"
+	"time"
+)
+
+const (
+	// ProvenanceSuffix names the provenance attestation of each artifact
+	ProvenanceSuffix = ".intoto.json"
+
+	// StatementType is the in-toto statement version we emit
+	StatementType = "https://in-toto.io/Statement/v0.1"
+
+	// ProvenanceType is the SLSA provenance predicate version we emit
+	ProvenanceType = "https://slsa.dev/provenance/v0.2"
+
+	// BuildType identifies a USpin image build within the provenance
+	BuildType = "https://github.com/solus-project/USpin/spin@v1"
+)
+
+// A DigestSet maps digest algorithms to their hex encoded values
+type DigestSet map[string]string
+
+// A Subject is an artifact described by a Statement
+type Subject struct {
+	Name   string    `json:"name"`
+	Digest DigestSet `json:"digest"`
+}
+
+// A Material is an input of the build
+type Material struct {
+	URI    string    `json:"uri"`
+	Digest DigestSet `json:"digest,omitempty"`
+}
+
+// A Statement is an in-toto attestation with a SLSA provenance predicate
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Provenance describes how an artifact was built
+type Provenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		ConfigSource struct {
+			URI        string    `json:"uri"`
+			Digest     DigestSet `json:"digest,omitempty"`
+			EntryPoint string    `json:"entryPoint"`
+		} `json:"configSource"`
+		Parameters map[string]string `json:"parameters"`
+	} `json:"invocation"`
+	Metadata struct {
+		BuildFinishedOn string `json:"buildFinishedOn"`
+		Reproducible    bool   `json:"reproducible"`
+	} `json:"metadata"`
+	Materials []Material `json:"materials"`
+}
+
+// newProvenance will describe the build of the release from the ImageSpec
+func (p *Publisher) newProvenance(rel *Release) (*Provenance, error) {
+	img := p.img
+	prov := &Provenance{BuildType: BuildType}
+	prov.Builder.ID = p.conf.BuilderID
+
+	src := &prov.Invocation.ConfigSource
+	src.URI = "file://" + img.BaseDir
+	src.EntryPoint = filepath.Base(img.SpinFile)
+	if rev, err := img.GitRevision(); err == nil {
+		src.URI = "git+" + src.URI
+		src.Digest = DigestSet{"sha1": rev}
+	}
+	prov.Invocation.Parameters = map[string]string{
+		"imageType": string(img.Config.Image.Type),
+		"release":   rel.Name,
+	}
+	if rel.Edition != "" {
+		prov.Invocation.Parameters["edition"] = rel.Edition
+		prov.Invocation.Parameters["arch"] = rel.Arch
+	}
+	prov.Metadata.BuildFinishedOn = rel.Date.Format(time.RFC3339)
+	prov.Metadata.Reproducible = img.IDs.Seeded()
+
+	// The profile, its package list and the resolved package manifest
+	inputs := []string{
+		img.SpinFile,
+		img.JoinPath(img.Config.Image.Packages),
+		filepath.Join(p.Dir, rel.Path, ManifestFile),
+	}
+	for _, input := range inputs {
+		sum, err := HashFile(input)
+		if err != nil {
+			return nil, err
+		}
+		prov.Materials = append(prov.Materials, Material{
+			URI:    "file://" + input,
+			Digest: DigestSet{"sha256": sum},
+		})
+	}
+	for _, repo := range img.Repos() {
+		prov.Materials = append(prov.Materials, Material{URI: repo.RepoURI})
+	}
+	return prov, nil
+}
+
+// writeProvenance will write a provenance attestation alongside each of the
+// artifacts, signing it if a key is configured.
+func (p *Publisher) writeProvenance(rel *Release, artifacts []string) error {
+	prov, err := p.newProvenance(rel)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(p.Dir, rel.Path)
+	for _, artifact := range artifacts {
+		sum, err := HashFile(filepath.Join(dir, artifact))
+		if err != nil {
+			return err
+		}
+		statement := &Statement{
+			Type:          StatementType,
+			Subject:       []Subject{{Name: artifact, Digest: DigestSet{"sha256": sum}}},
+			PredicateType: ProvenanceType,
+			Predicate:     *prov,
+		}
+		path := filepath.Join(dir, artifact+ProvenanceSuffix)
+		if err := writeJSON(path, statement); err != nil {
+			return err
+		}
+		rel.Files = append(rel.Files, artifact+ProvenanceSuffix)
+		if p.conf.SignKey != "" {
+			if err := Sign(path, p.conf.SignKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
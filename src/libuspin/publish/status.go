@@ -0,0 +1,100 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// StatusSuffix is appended to the profile name for its status
+	StatusSuffix = ".status.json"
+
+	// BadgeSuffix is appended to the profile name for its badge
+	BadgeSuffix = ".status.svg"
+
+	// StatusSucceeded is the status of a build which produced its image
+	StatusSucceeded = "succeeded"
+
+	// StatusFailed is the status of a build which did not
+	StatusFailed = "failed"
+)
+
+// A Status is the result of the last build of a profile, for publishing next
+// to the artifacts so that download pages and dashboards can follow it
+type Status struct {
+	Profile string    `json:"profile"`
+	Status  string    `json:"status"`
+	Version string    `json:"version"` // Release name, or the build date
+	Date    time.Time `json:"date"`
+	Image   string    `json:"image,omitempty"` // Filename of the image, if built
+	Size    int64     `json:"size,omitempty"`  // Size of the image in bytes
+}
+
+// badgeCharWidth approximates the width of a character in the badge font
+const badgeCharWidth = 7
+
+// Badge returns the status as a flat SVG badge, labelled with the profile
+// and showing the version of a successful build
+func (s *Status) Badge() []byte {
+	message, color := s.Version, "#4c1"
+	if s.Status != StatusSucceeded {
+		message, color = s.Status, "#e05d44"
+	}
+	left := len(s.Profile)*badgeCharWidth + 10
+	right := len(message)*badgeCharWidth + 10
+	label, value := html.EscapeString(s.Profile), html.EscapeString(message)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<rect width="%[2]d" height="20" fill="#555"/>
+<rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, left+right, left, right, label, value, color, left/2, left+right/2))
+}
+
+// WriteStatus will write the status of the profile into dir, along with its
+// badge if requested, returning the paths written
+func WriteStatus(dir string, s *Status, badge bool) ([]string, error) {
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, s.Profile+StatusSuffix)
+	if err := writeJSON(path, s); err != nil {
+		return nil, err
+	}
+	paths := []string{path}
+	if !badge {
+		return paths, nil
+	}
+	path = filepath.Join(dir, s.Profile+BadgeSuffix)
+	if err := ioutil.WriteFile(path+".tmp", s.Badge(), 00644); err != nil {
+		return paths, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return paths, err
+	}
+	return append(paths, path), nil
+}
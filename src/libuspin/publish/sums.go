@@ -0,0 +1,89 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SumsFile is the aggregate checksum file written to each release directory
+const SumsFile = "SHA256SUMS"
+
+// isSumsCandidate determines if the file should be covered by SHA256SUMS,
+// excluding checksums and signatures themselves.
+func isSumsCandidate(name string) bool {
+	switch {
+	case strings.HasPrefix(name, SumsFile),
+		strings.HasSuffix(name, ChecksumSuffix),
+		isChecksumFile(name),
+		strings.HasSuffix(name, SignatureSuffix),
+		strings.HasSuffix(name, BinarySignatureSuffix),
+		isCosignFile(name),
+		strings.HasSuffix(name, ".tmp"):
+		return false
+	default:
+		return true
+	}
+}
+
+// writeDirSums will write SHA256SUMS for the regular files directly within
+// dir, returning false if there was nothing to list.
+func writeDirSums(dir string) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || !isSumsCandidate(entry.Name()) {
+			continue
+		}
+		sum, err := HashFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(&buf, "%v  %v\n", sum, entry.Name())
+	}
+	if buf.Len() == 0 {
+		return false, nil
+	}
+	path := filepath.Join(dir, SumsFile)
+	if err := ioutil.WriteFile(path+".tmp", buf.Bytes(), 00644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(path+".tmp", path)
+}
+
+// writeSums will write SHA256SUMS into every directory of the release that
+// contains files, signing each with SHA256SUMS.gpg when a key is configured.
+func (p *Publisher) writeSums(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		wrote, err := writeDirSums(path)
+		if err != nil || !wrote || p.conf.SignKey == "" {
+			return err
+		}
+		return SignBinary(filepath.Join(path, SumsFile), p.conf.SignKey)
+	})
+}
@@ -0,0 +1,291 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package publish
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyOptions are the optional inputs when verifying a downloaded artifact
+type VerifyOptions struct {
+	Manifest  string // Published manifest.json of the release
+	Signature string // Detached signature of the artifact, or of the file it is named after
+	Keyring   string // Verify signatures with gpgv against this keyring, instead of the gpg defaults
+
+	VerityHash string // dm-verity hash tree of the artifact
+	RootHash   string // Expected dm-verity root hash, required with VerityHash
+}
+
+// A verifier collects the problems found while checking a single artifact
+type verifier struct {
+	artifact string
+	opts     *VerifyOptions
+	checked  int
+	problems []string
+}
+
+// report records a problem with the artifact
+func (v *verifier) report(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// exists determines if a regular file is present
+func exists(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Mode().IsRegular()
+}
+
+// VerifyArtifact will re-check a published artifact against everything
+// published alongside it: checksum files, detached signatures and provenance
+// attestations, as well as the given options. Every problem found is
+// returned, and it is an error if there was nothing to check against.
+func VerifyArtifact(artifact string, opts *VerifyOptions) ([]string, error) {
+	if !exists(artifact) {
+		return nil, fmt.Errorf("No such artifact: %v", artifact)
+	}
+	v := &verifier{artifact: artifact, opts: opts}
+	if err := v.checksums(); err != nil {
+		return nil, err
+	}
+	if err := v.signature(); err != nil {
+		return nil, err
+	}
+	if err := v.provenance(); err != nil {
+		return nil, err
+	}
+	if err := v.verity(); err != nil {
+		return nil, err
+	}
+	if v.checked == 0 {
+		return nil, fmt.Errorf("Nothing was published alongside %v to verify against", artifact)
+	}
+	return v.problems, nil
+}
+
+// A checksumFile may accompany the artifact, listing its digest
+type checksumFile struct {
+	path    string
+	newHash func() hash.Hash
+}
+
+// checksumFiles returns every checksum file that may accompany the artifact
+func (v *verifier) checksumFiles() []checksumFile {
+	return []checksumFile{
+		{v.artifact + ChecksumSuffix, sha256.New},
+		{ChecksumPath(v.artifact, config.ChecksumSHA256), sha256.New},
+		{ChecksumPath(v.artifact, config.ChecksumSHA512), sha512.New},
+		{filepath.Join(filepath.Dir(v.artifact), SumsFile), sha256.New},
+	}
+}
+
+// checksums will compare the artifact against every checksum file found
+// next to it, verifying their signatures where present
+func (v *verifier) checksums() error {
+	name := filepath.Base(v.artifact)
+	for _, file := range v.checksumFiles() {
+		path := file.path
+		if !exists(path) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		want, ok := lookupSum(data, name)
+		if !ok {
+			if filepath.Base(path) != SumsFile {
+				v.report("%v does not list %v", filepath.Base(path), name)
+			}
+			continue
+		}
+		sum, err := hashFile(v.artifact, file.newHash())
+		if err != nil {
+			return err
+		}
+		v.checked++
+		if sum != want {
+			v.report("Checksum mismatch against %v", filepath.Base(path))
+		}
+		for _, suffix := range []string{SignatureSuffix, BinarySignatureSuffix} {
+			if exists(path + suffix) {
+				v.verifySignature(path+suffix, path)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupSum finds the digest of the named file within a sha256sum listing,
+// allowing for the "*" binary mode marker
+func lookupSum(data []byte, name string) (string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		file := strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+		if file == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// signature will check the explicit signature, which covers the file it is
+// named after if present, i.e. manifest.json.asc, and otherwise the artifact
+func (v *verifier) signature() error {
+	sig := v.opts.Signature
+	if sig == "" {
+		if sig = v.artifact + SignatureSuffix; !exists(sig) {
+			return nil
+		}
+	}
+	if !exists(sig) {
+		return fmt.Errorf("No such signature: %v", sig)
+	}
+	data := strings.TrimSuffix(strings.TrimSuffix(sig, SignatureSuffix), BinarySignatureSuffix)
+	if data == sig || !exists(data) {
+		data = v.artifact
+	}
+	v.verifySignature(sig, data)
+	return nil
+}
+
+// verifySignature checks a detached signature of the data file
+func (v *verifier) verifySignature(sig, data string) {
+	var cmd *exec.Cmd
+	if v.opts.Keyring != "" {
+		cmd = exec.Command("gpgv", "--keyring", v.opts.Keyring, sig, data)
+	} else {
+		cmd = exec.Command("gpg", "--batch", "--verify", sig, data)
+	}
+	v.checked++
+	if out, err := cmd.CombinedOutput(); err != nil {
+		v.report("Invalid signature %v: %v: %s", filepath.Base(sig), err, bytes.TrimSpace(out))
+	}
+}
+
+// provenance will ensure the provenance attestation describes the artifact
+// and the manifest, and that the manifest describes the same build
+func (v *verifier) provenance() error {
+	var plan *libuspin.Plan
+	if v.opts.Manifest != "" {
+		var err error
+		if plan, err = libuspin.LoadPlan(v.opts.Manifest); err != nil {
+			return err
+		}
+	}
+
+	path := v.artifact + ProvenanceSuffix
+	if !exists(path) {
+		if plan != nil {
+			return errors.New("A manifest can only be verified against a provenance attestation")
+		}
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	statement := &Statement{}
+	if err := json.Unmarshal(data, statement); err != nil {
+		return fmt.Errorf("Invalid provenance %v: %v", path, err)
+	}
+	if exists(path + SignatureSuffix) {
+		v.verifySignature(path+SignatureSuffix, path)
+	}
+
+	sum, err := HashFile(v.artifact)
+	if err != nil {
+		return err
+	}
+	v.checked++
+	name := filepath.Base(v.artifact)
+	found := false
+	for _, subject := range statement.Subject {
+		if subject.Name != name {
+			continue
+		}
+		found = true
+		if subject.Digest["sha256"] != sum {
+			v.report("Provenance records a different digest for %v", name)
+		}
+	}
+	if !found {
+		v.report("Provenance does not describe %v", name)
+	}
+	if plan == nil {
+		return nil
+	}
+
+	// The manifest must be the one the artifact was built from
+	sum, err = HashFile(v.opts.Manifest)
+	if err != nil {
+		return err
+	}
+	found = false
+	for _, material := range statement.Predicate.Materials {
+		if !strings.HasSuffix(material.URI, "/"+ManifestFile) {
+			continue
+		}
+		found = true
+		if material.Digest["sha256"] != sum {
+			v.report("Manifest differs from the one recorded in the provenance")
+		}
+	}
+	if !found {
+		v.report("Provenance does not record a manifest")
+	}
+	if t := statement.Predicate.Invocation.Parameters["imageType"]; t != string(plan.ImageType) {
+		v.report("Manifest describes a %v image, provenance describes a %v image", plan.ImageType, t)
+	}
+	return nil
+}
+
+// verity will check the artifact against its dm-verity hash tree
+func (v *verifier) verity() error {
+	if v.opts.VerityHash == "" {
+		return nil
+	}
+	if v.opts.RootHash == "" {
+		return errors.New("Verifying a verity hash tree requires the root hash")
+	}
+	if _, err := exec.LookPath("veritysetup"); err != nil {
+		return errors.New("veritysetup is required to verify the root hash")
+	}
+	v.checked++
+	out, err := exec.Command("veritysetup", "verify", v.artifact, v.opts.VerityHash, v.opts.RootHash).CombinedOutput()
+	if err != nil {
+		v.report("Verity root hash mismatch: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
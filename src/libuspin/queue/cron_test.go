@@ -0,0 +1,96 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	base := time.Date(2016, time.December, 1, 12, 0, 0, 0, time.UTC) // Thursday
+
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"@nightly", time.Date(2016, time.December, 2, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2016, time.December, 1, 12, 15, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2016, time.December, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2016, time.December, 4, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", test.expr, err)
+		}
+		if next := c.Next(base); !next.Equal(test.next) {
+			t.Fatalf("%v: expected %v, got %v", test.expr, test.next, next)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "61 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Fatalf("Should not parse invalid expression: %v", bad)
+		}
+	}
+}
+
+func TestScheduleDue(t *testing.T) {
+	sched := &Schedule{Cron: "0 2 * * *"}
+	at := time.Date(2016, time.December, 1, 2, 0, 30, 0, time.UTC)
+
+	if due, _ := sched.Due(at.Add(-time.Hour)); due {
+		t.Fatalf("Schedule should not be due at 01:00")
+	}
+	if due, _ := sched.Due(at); !due {
+		t.Fatalf("Schedule should be due at 02:00")
+	}
+	sched.LastRun = at.Truncate(time.Minute)
+	if due, _ := sched.Due(at.Add(10 * time.Second)); due {
+		t.Fatalf("Schedule should only fire once per minute")
+	}
+	if due, _ := sched.Due(at.Add(24 * time.Hour)); !due {
+		t.Fatalf("Schedule should be due again the next night")
+	}
+}
+
+func TestScheduleDebounce(t *testing.T) {
+	now := time.Date(2016, time.December, 1, 2, 0, 0, 0, time.UTC)
+	sched := &Schedule{OnRepoChange: true, Debounce: 30 * time.Minute}
+
+	sched.SetPackageState("a", now)
+	if sched.Settled(now.Add(10 * time.Minute)) {
+		t.Fatalf("Change should not settle within the debounce period")
+	}
+	// A further change restarts the debounce period
+	sched.SetPackageState("b", now.Add(20*time.Minute))
+	if sched.Settled(now.Add(40 * time.Minute)) {
+		t.Fatalf("Debounce period should restart on change")
+	}
+	if !sched.Settled(now.Add(50 * time.Minute)) {
+		t.Fatalf("Change should have settled")
+	}
+	if sched.PackageState != "b" {
+		t.Fatalf("Incorrect package state: %v", sched.PackageState)
+	}
+	sched.SetPackageState("b", now.Add(time.Hour))
+	if sched.Settled(now.Add(2 * time.Hour)) {
+		t.Fatalf("No change should be pending")
+	}
+}
@@ -0,0 +1,154 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package queue provides the priority ordered build queue used by the
+// USpin daemon, persisted as job files within a spool directory.
+package queue
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// A Priority is the class of a submitted build
+type Priority string
+
+const (
+	// PriorityRelease builds always run first
+	PriorityRelease Priority = "release"
+
+	// PriorityNightly builds run after releases
+	PriorityNightly Priority = "nightly"
+
+	// PriorityExperimental builds only run when nothing else is waiting
+	PriorityExperimental Priority = "experimental"
+)
+
+// Rank returns the numerical rank of the priority, lower is more important
+func (p Priority) Rank() int {
+	switch p {
+	case PriorityRelease:
+		return 0
+	case PriorityNightly:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// ParsePriority will validate the priority name
+func ParsePriority(name string) (Priority, error) {
+	switch p := Priority(name); p {
+	case PriorityRelease, PriorityNightly, PriorityExperimental:
+		return p, nil
+	default:
+		return "", fmt.Errorf("Unknown priority: %v", name)
+	}
+}
+
+// A JobState tracks the lifecycle of a Job
+type JobState string
+
+const (
+	// JobQueued is waiting to be run
+	JobQueued JobState = "queued"
+
+	// JobRunning is currently building
+	JobRunning JobState = "running"
+
+	// JobPaused was preempted by a higher priority build
+	JobPaused JobState = "paused"
+
+	// JobSucceeded completed successfully
+	JobSucceeded JobState = "succeeded"
+
+	// JobFailed did not complete successfully
+	JobFailed JobState = "failed"
+)
+
+// A Job is a single submitted build
+type Job struct {
+	ID        string    `json:"id"`
+	Spin      string    `json:"spin"` // Absolute path to the .spin file
+	Priority  Priority  `json:"priority"`
+	Submitted time.Time `json:"submitted"`
+	State     JobState  `json:"state"`
+	Held      bool      `json:"held,omitempty"` // Paused on request, until resumed
+
+	// Passed to "uspin build" as -var and -build-profile
+	Variables    map[string]string `json:"variables,omitempty"`
+	BuildProfile string            `json:"build_profile,omitempty"`
+}
+
+// Before returns true if this job should run before the other job
+func (j *Job) Before(o *Job) bool {
+	if j.Priority.Rank() != o.Priority.Rank() {
+		return j.Priority.Rank() < o.Priority.Rank()
+	}
+	return j.Submitted.Before(o.Submitted)
+}
+
+// jobHeap implements heap.Interface
+type jobHeap []*Job
+
+func (h jobHeap) Len() int            { return len(h) }
+func (h jobHeap) Less(i, j int) bool  { return h[i].Before(h[j]) }
+func (h jobHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(*Job)) }
+func (h *jobHeap) Pop() (ret interface{}) {
+	old := *h
+	ret = old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ret
+}
+
+// A Queue orders jobs by priority, and then by submission time
+type Queue struct {
+	jobs jobHeap
+}
+
+// NewQueue returns a new, empty Queue
+func NewQueue() *Queue {
+	return &Queue{}
+}
+
+// Len returns the number of queued jobs
+func (q *Queue) Len() int {
+	return len(q.jobs)
+}
+
+// Push will add the job to the queue
+func (q *Queue) Push(j *Job) {
+	j.State = JobQueued
+	heap.Push(&q.jobs, j)
+}
+
+// Peek returns the next job without removing it, or nil if empty
+func (q *Queue) Peek() *Job {
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	return q.jobs[0]
+}
+
+// Pop will remove and return the next job, or nil if empty
+func (q *Queue) Pop() *Job {
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	return heap.Pop(&q.jobs).(*Job)
+}
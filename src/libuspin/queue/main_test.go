@@ -0,0 +1,78 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	now := time.Now()
+	q := NewQueue()
+	q.Push(&Job{ID: "exp", Priority: PriorityExperimental, Submitted: now})
+	q.Push(&Job{ID: "nightly2", Priority: PriorityNightly, Submitted: now.Add(time.Second)})
+	q.Push(&Job{ID: "nightly1", Priority: PriorityNightly, Submitted: now})
+	q.Push(&Job{ID: "release", Priority: PriorityRelease, Submitted: now.Add(time.Hour)})
+
+	for _, want := range []string{"release", "nightly1", "nightly2", "exp"} {
+		if j := q.Pop(); j == nil || j.ID != want {
+			t.Fatalf("Expected %v next, got %v", want, j)
+		}
+	}
+	if q.Pop() != nil {
+		t.Fatalf("Queue should be empty")
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewSpool(dir)
+	if err != nil {
+		t.Fatalf("Cannot create spool: %v", err)
+	}
+	j, err := s.Submit("image.spin", PriorityRelease)
+	if err != nil {
+		t.Fatalf("Cannot submit job: %v", err)
+	}
+	if got, err := s.Job(j.ID); err != nil || got.State != JobQueued {
+		t.Fatalf("Submitted job should be found: %v %v", got, err)
+	}
+	jobs, err := s.Collect()
+	if err != nil {
+		t.Fatalf("Cannot collect jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != j.ID || jobs[0].Priority != PriorityRelease {
+		t.Fatalf("Incorrect jobs collected: %v", jobs)
+	}
+	if jobs, _ := s.Collect(); len(jobs) != 0 {
+		t.Fatalf("Jobs should only be collected once")
+	}
+	if got, err := s.Job(j.ID); err != nil || got.ID != j.ID {
+		t.Fatalf("Collected job should be found: %v %v", got, err)
+	}
+	if _, err := s.Job("../" + j.ID); err == nil {
+		t.Fatalf("Job IDs should not escape the spool")
+	}
+}
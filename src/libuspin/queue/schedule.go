@@ -0,0 +1,177 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Schedule registers a profile to be built automatically by the daemon,
+// either periodically using Cron, when the repositories change, or both.
+type Schedule struct {
+	Name     string    `json:"name"`
+	Spin     string    `json:"spin"` // Absolute path to the .spin file
+	Cron     string    `json:"cron,omitempty"`
+	Priority Priority  `json:"priority"`
+	LastRun  time.Time `json:"lastRun,omitempty"`
+
+	// Fingerprint of the build inputs at LastRun, used to skip builds
+	// when nothing has changed
+	LastFingerprint string `json:"lastFingerprint,omitempty"`
+
+	// Repository watching, rebuilding once relevant packages change and
+	// no further changes have been seen for the Debounce period
+	OnRepoChange bool          `json:"onRepoChange,omitempty"`
+	PollInterval time.Duration `json:"pollInterval,omitempty"`
+	Debounce     time.Duration `json:"debounce,omitempty"`
+	LastPoll     time.Time     `json:"lastPoll,omitempty"`
+	RepoState    string        `json:"repoState,omitempty"`    // Last seen repository state
+	PackageState string        `json:"packageState,omitempty"` // Package state of the last build
+	PendingState string        `json:"pendingState,omitempty"` // Package state awaiting a build
+	ChangedAt    time.Time     `json:"changedAt,omitempty"`    // When PendingState was last updated
+}
+
+func (s *Spool) schedulesDir() string {
+	return filepath.Join(s.Dir, "schedules")
+}
+
+// AddSchedule will register the schedule in the spool, replacing any
+// existing schedule with the same name.
+func (s *Spool) AddSchedule(sched *Schedule) error {
+	if sched.Cron == "" && !sched.OnRepoChange {
+		return fmt.Errorf("Schedule %v needs a cron expression or repo watching", sched.Name)
+	}
+	if sched.Cron != "" {
+		if _, err := ParseCron(sched.Cron); err != nil {
+			return err
+		}
+	}
+	spin, err := filepath.Abs(sched.Spin)
+	if err != nil {
+		return err
+	}
+	sched.Spin = spin
+	return s.UpdateSchedule(sched)
+}
+
+// UpdateSchedule will persist the schedule state
+func (s *Spool) UpdateSchedule(sched *Schedule) error {
+	if err := os.MkdirAll(s.schedulesDir(), 00755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sched, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.schedulesDir(), sched.Name+".json")
+	if err := ioutil.WriteFile(path+".tmp", data, 00644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RemoveSchedule will unregister the named schedule
+func (s *Spool) RemoveSchedule(name string) error {
+	return os.Remove(filepath.Join(s.schedulesDir(), name+".json"))
+}
+
+// Schedules will return all registered schedules
+func (s *Spool) Schedules() ([]*Schedule, error) {
+	files, err := ioutil.ReadDir(s.schedulesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ret []*Schedule
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.schedulesDir(), fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sched := &Schedule{}
+		if err := json.Unmarshal(data, sched); err != nil {
+			return nil, err
+		}
+		ret = append(ret, sched)
+	}
+	return ret, nil
+}
+
+// Due returns true if the schedule should fire at time now, and has not
+// already fired within the same minute.
+func (sched *Schedule) Due(now time.Time) (bool, error) {
+	if sched.Cron == "" {
+		return false, nil
+	}
+	c, err := ParseCron(sched.Cron)
+	if err != nil {
+		return false, err
+	}
+	now = now.Truncate(time.Minute)
+	if !sched.LastRun.IsZero() && !sched.LastRun.Before(now) {
+		return false, nil
+	}
+	// Catch up on the most recent firing missed since the last check
+	since := sched.LastRun
+	if since.IsZero() || now.Sub(since) > time.Hour {
+		since = now.Add(-time.Minute)
+	}
+	next := c.Next(since)
+	return !next.IsZero() && !next.After(now), nil
+}
+
+// PollDue returns true if the repositories should be checked for changes
+func (sched *Schedule) PollDue(now time.Time) bool {
+	return sched.OnRepoChange && !now.Before(sched.LastPoll.Add(sched.PollInterval))
+}
+
+// SetPackageState records the package state seen by the latest poll. A
+// changed state restarts the debounce period.
+func (sched *Schedule) SetPackageState(state string, now time.Time) {
+	target := sched.PackageState
+	if !sched.ChangedAt.IsZero() {
+		target = sched.PendingState
+	}
+	if state == target {
+		return
+	}
+	sched.PendingState = state
+	sched.ChangedAt = now
+}
+
+// Settled returns true if a package change is pending and the debounce period
+// has passed, at which point the pending state becomes the built state.
+func (sched *Schedule) Settled(now time.Time) bool {
+	if sched.ChangedAt.IsZero() || now.Before(sched.ChangedAt.Add(sched.Debounce)) {
+		return false
+	}
+	sched.PackageState = sched.PendingState
+	sched.PendingState = ""
+	sched.ChangedAt = time.Time{}
+	return true
+}
@@ -0,0 +1,198 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Spool is the on-disk location shared by "uspin submit" and the daemon.
+// Submitted jobs are written to the incoming directory, and the daemon then
+// moves them to their own job directory in which the build is run.
+type Spool struct {
+	Dir string
+}
+
+// NewSpool will return a Spool at the given directory, creating it if needed
+func NewSpool(dir string) (*Spool, error) {
+	s := &Spool{Dir: dir}
+	for _, d := range []string{s.incomingDir(), s.jobsDir(), s.UploadDir(), s.holdsDir()} {
+		if err := os.MkdirAll(d, 00755); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Spool) incomingDir() string {
+	return filepath.Join(s.Dir, "incoming")
+}
+
+func (s *Spool) jobsDir() string {
+	return filepath.Join(s.Dir, "jobs")
+}
+
+// holdsDir holds a file for each job paused on request, kept apart from
+// the job so the daemon never overwrites the request with the job state
+func (s *Spool) holdsDir() string {
+	return filepath.Join(s.Dir, "holds")
+}
+
+// UploadDir returns the directory holding profiles submitted remotely
+func (s *Spool) UploadDir() string {
+	return filepath.Join(s.Dir, "uploads")
+}
+
+// JobDir returns the working directory for the given job
+func (s *Spool) JobDir(id string) string {
+	return filepath.Join(s.jobsDir(), id)
+}
+
+// writeJSON will atomically write the job to the given path
+func writeJSON(path string, j *Job) error {
+	data, err := json.MarshalIndent(j, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 00644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Submit will create a new job for the .spin file and place it in the spool
+func (s *Spool) Submit(spin string, priority Priority) (*Job, error) {
+	return s.SubmitJob(&Job{Spin: spin, Priority: priority})
+}
+
+// SubmitJob will place the job in the spool, assigning its ID, submission
+// time and initial state
+func (s *Spool) SubmitJob(j *Job) (*Job, error) {
+	spin, err := filepath.Abs(j.Spin)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	j.ID = now.Format("20060102-150405") + "-" + hex.EncodeToString(id)
+	j.Spin = spin
+	j.Submitted = now
+	j.State = JobQueued
+	return j, writeJSON(filepath.Join(s.incomingDir(), j.ID+".json"), j)
+}
+
+// Collect will claim all newly submitted jobs, giving each one its own job
+// directory, and return them.
+func (s *Spool) Collect() ([]*Job, error) {
+	files, err := ioutil.ReadDir(s.incomingDir())
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Job
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(s.incomingDir(), fi.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		j := &Job{}
+		if err := json.Unmarshal(data, j); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(s.JobDir(j.ID), 00755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(path, filepath.Join(s.JobDir(j.ID), "job.json")); err != nil {
+			return nil, err
+		}
+		ret = append(ret, j)
+	}
+	return ret, nil
+}
+
+// checkID will ensure the job ID cannot name anything outside the spool
+func checkID(id string) error {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("Invalid job ID: %q", id)
+	}
+	return nil
+}
+
+// Job will return the current state of the job, whether or not the daemon
+// has claimed it yet
+func (s *Spool) Job(id string) (*Job, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.JobDir(id), "job.json"))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(filepath.Join(s.incomingDir(), id+".json"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	j := &Job{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Update will store the current state of the job in its job directory
+func (s *Spool) Update(j *Job) error {
+	return writeJSON(filepath.Join(s.JobDir(j.ID), "job.json"), j)
+}
+
+// SetHeld will ask the daemon to pause the job, or to release a job it
+// paused on request. Held jobs are neither started nor resumed.
+func (s *Spool) SetHeld(id string, held bool) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	path := filepath.Join(s.holdsDir(), id)
+	if !held {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(path, nil, 00644)
+}
+
+// Held returns true if the job has been paused on request
+func (s *Spool) Held(id string) bool {
+	if checkID(id) != nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.holdsDir(), id))
+	return err == nil
+}
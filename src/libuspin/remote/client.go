@@ -0,0 +1,255 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"libuspin/queue"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Client talks to the daemon on a remote builder
+type Client struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+// NewClient will return a Client for the target, which is either a URL or a
+// bare host, in which case https and the DefaultPort are assumed
+func NewClient(target, token string) (*Client, error) {
+	if !strings.Contains(target, "://") {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, DefaultPort)
+		}
+		target = "https://" + target
+	}
+	base, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported scheme for remote: %v", base.Scheme)
+	}
+	if base.Host == "" {
+		return nil, fmt.Errorf("Missing host for remote: %v", target)
+	}
+	return &Client{base: base, token: token, http: &http.Client{}}, nil
+}
+
+// do will send the request to the path, returning the response only if
+// it was successful
+func (c *Client) do(method, p string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("Remote returned %v: %v", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// getJSON will decode the response from the path into v
+func (c *Client) getJSON(p string, v interface{}) error {
+	resp, err := c.do(http.MethodGet, p, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Submit will ship the profile at dir to the remote and queue a build of it.
+// The Spin of the job is the path of the .spin file relative to dir.
+func (c *Client) Submit(dir string, job *queue.Job, skip func(name string, info os.FileInfo) bool) (*queue.Job, error) {
+	query := url.Values{}
+	query.Set("spin", filepath.ToSlash(job.Spin))
+	query.Set("priority", string(job.Priority))
+	if job.BuildProfile != "" {
+		query.Set("build_profile", job.BuildProfile)
+	}
+	names := make([]string, 0, len(job.Variables))
+	for name := range job.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query.Add("var", name+"="+job.Variables[name])
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(Pack(pw, dir, skip))
+	}()
+	defer pr.Close()
+	resp, err := c.do(http.MethodPost, "/jobs", query, pr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret := &queue.Job{}
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Job will return the current state of the job
+func (c *Client) Job(id string) (*queue.Job, error) {
+	job := &queue.Job{}
+	if err := c.getJSON("/jobs/"+id, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// hold will request the job be paused or resumed, returning its state
+func (c *Client) hold(id, action string) (*queue.Job, error) {
+	resp, err := c.do(http.MethodPost, "/jobs/"+id+"/"+action, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	job := &queue.Job{}
+	if err := json.NewDecoder(resp.Body).Decode(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Pause will ask the daemon to pause the job at its next stage boundary, or
+// not to start it if it is still queued, until it is resumed
+func (c *Client) Pause(id string) (*queue.Job, error) {
+	return c.hold(id, "pause")
+}
+
+// Resume will release a job paused with Pause, which then runs again once
+// a worker is free for it
+func (c *Client) Resume(id string) (*queue.Job, error) {
+	return c.hold(id, "resume")
+}
+
+// Follow will copy the build log to w as it is written, until the job has
+// either succeeded or failed, polling the remote at the given interval
+func (c *Client) Follow(id string, w io.Writer, interval time.Duration) (*queue.Job, error) {
+	var offset int64
+	for {
+		// Check the state first so that the log is complete once finished
+		job, err := c.Job(id)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(http.MethodGet, "/jobs/"+id+"/log", url.Values{"offset": {strconv.FormatInt(offset, 10)}}, nil)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(w, resp.Body)
+		resp.Body.Close()
+		offset += n
+		if err != nil {
+			return nil, err
+		}
+		if job.State == queue.JobSucceeded || job.State == queue.JobFailed {
+			return job, nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Artifacts will list the files produced by the job
+func (c *Client) Artifacts(id string) ([]*Artifact, error) {
+	var ret []*Artifact
+	if err := c.getJSON("/jobs/"+id+"/artifacts", &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// fetch will download a single artifact to the target, replacing it only
+// once the download has completed
+func (c *Client) fetch(id string, a *Artifact, target string) error {
+	resp, err := c.do(http.MethodGet, "/jobs/"+id+"/artifacts/"+a.Name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != a.Size {
+		err = fmt.Errorf("Short download of %v: %v of %v bytes", a.Name, n, a.Size)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// Download will fetch every artifact of the job into dir, returning the
+// paths written
+func (c *Client) Download(id, dir string) ([]string, error) {
+	artifacts, err := c.Artifacts(id)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, a := range artifacts {
+		target, err := safeName(dir, a.Name)
+		if err != nil {
+			return ret, err
+		}
+		if err := c.fetch(id, a, target); err != nil {
+			return ret, err
+		}
+		ret = append(ret, target)
+	}
+	return ret, nil
+}
@@ -0,0 +1,198 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package remote allows a build to be run by the daemon on another host, as
+// though it were local. The client ships the directory of the profile to
+// the daemon, follows the build log as it is written, and downloads the
+// artifacts once the build has finished.
+//
+// The daemon serves a small HTTP API, authenticated with a bearer token:
+//
+//	POST /jobs?spin=NAME&priority=P   Submit the profile, a .tar.gz of its directory
+//	GET  /jobs/ID                     State of the job
+//	GET  /jobs/ID/log?offset=N        Build log from the given offset
+//	GET  /jobs/ID/artifacts           Files produced by the build
+//	GET  /jobs/ID/artifacts/NAME      Download a single file
+//	POST /jobs/ID/pause               Pause the job until it is resumed
+//	POST /jobs/ID/resume              Resume a job paused with /pause
+package remote
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultPort is used when the remote is given without a port
+	DefaultPort = "8347"
+
+	// TokenEnv holds the bearer token of the client
+	TokenEnv = "USPIN_REMOTE_TOKEN"
+
+	// MaxUpload is the largest profile the daemon accepts, in bytes
+	MaxUpload = 1 << 30
+
+	// logFile is the build log within each job directory
+	logFile = "build.log"
+
+	// jobFile is the job state within each job directory
+	jobFile = "job.json"
+)
+
+// An Artifact is a file produced by a remote build
+type Artifact struct {
+	Name string `json:"name"` // Slash separated path within the job directory
+	Size int64  `json:"size"`
+}
+
+// Pack will write the tree at dir as a .tar.gz, leaving out anything skip
+// returns true for, given the slash separated path relative to dir
+func Pack(w io.Writer, dir string, skip func(name string, info os.FileInfo) bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if skip != nil && skip(name, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			// Sockets, FIFOs and device nodes have no place in a profile
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		fi, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+		_, err = io.Copy(tw, fi)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// safeName returns the slash separated name as a path within dir, or an
+// error if it would escape dir
+func safeName(dir, name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" || clean != "/"+strings.TrimSuffix(name, "/") {
+		return "", fmt.Errorf("Invalid path in archive: %q", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// Unpack will extract a .tar.gz written by Pack into dir. Symlinks are only
+// created once everything else has been extracted, so that no file can be
+// written through one.
+func Unpack(r io.Reader, dir string) error {
+	dir = filepath.Clean(dir)
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	links := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target, err := safeName(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 00755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			links[target] = hdr.Linkname
+		default:
+			return fmt.Errorf("Unsupported entry in archive: %q", hdr.Name)
+		}
+	}
+	for target, link := range links {
+		for parent := filepath.Dir(target); parent != dir; parent = filepath.Dir(parent) {
+			if _, ok := links[parent]; ok {
+				return fmt.Errorf("Symlink within a symlink in archive: %v", target)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
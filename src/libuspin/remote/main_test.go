@@ -0,0 +1,223 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package remote
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"libuspin/queue"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-remote")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := filepath.Join(dir, "profile")
+	for name, data := range map[string]string{
+		"test.spin":          "[image]\n",
+		"files/etc/motd":     "hello\n",
+		"old/test.img":       "stale",
+		"files/etc/hostname": "box\n",
+	} {
+		p := filepath.Join(profile, name)
+		if err := os.MkdirAll(filepath.Dir(p), 00755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+	if err := os.Symlink("motd", filepath.Join(profile, "files/etc/issue")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	spool, err := queue.NewSpool(filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatalf("Failed to create spool: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(spool, "secret"))
+	defer srv.Close()
+
+	if c, _ := NewClient(srv.URL, "wrong"); c != nil {
+		if _, err := c.Job("nope"); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Fatalf("Expected an invalid token to be refused, got %v", err)
+		}
+	}
+
+	c, err := NewClient(srv.URL, "secret")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	skip := func(name string, info os.FileInfo) bool { return name == "old" }
+	job, err := c.Submit(profile, &queue.Job{
+		Spin:      "test.spin",
+		Priority:  queue.PriorityNightly,
+		Variables: map[string]string{"RELEASE": "1.0"},
+	}, skip)
+	if err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	if job.State != queue.JobQueued || job.Variables["RELEASE"] != "1.0" {
+		t.Fatalf("Unexpected job: %+v", job)
+	}
+
+	// The daemon sees the unpacked profile
+	upload := filepath.Dir(job.Spin)
+	if data, err := ioutil.ReadFile(filepath.Join(upload, "files/etc/motd")); err != nil || string(data) != "hello\n" {
+		t.Fatalf("Expected unpacked motd, got %q: %v", data, err)
+	}
+	if link, err := os.Readlink(filepath.Join(upload, "files/etc/issue")); err != nil || link != "motd" {
+		t.Fatalf("Expected unpacked symlink, got %q: %v", link, err)
+	}
+	if _, err := os.Stat(filepath.Join(upload, "old")); !os.IsNotExist(err) {
+		t.Fatalf("Skipped directory should not be shipped")
+	}
+
+	// Pretend to be the daemon running the build
+	jobs, err := spool.Collect()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Expected one collected job, got %v: %v", jobs, err)
+	}
+	jobDir := spool.JobDir(job.ID)
+	if err := ioutil.WriteFile(filepath.Join(jobDir, logFile), []byte("Building\nDone\n"), 00644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(jobDir, "test.img"), []byte("image"), 00644); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+	jobs[0].State = queue.JobSucceeded
+	if err := spool.Update(jobs[0]); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+
+	var buf bytes.Buffer
+	done, err := c.Follow(job.ID, &buf, time.Millisecond)
+	if err != nil || done.State != queue.JobSucceeded {
+		t.Fatalf("Expected succeeded job, got %+v: %v", done, err)
+	}
+	if buf.String() != "Building\nDone\n" {
+		t.Fatalf("Unexpected log: %q", buf.String())
+	}
+
+	out := filepath.Join(dir, "out")
+	files, err := c.Download(job.ID, out)
+	if err != nil {
+		t.Fatalf("Failed to download artifacts: %v", err)
+	}
+	if len(files) != 1 || files[0] != filepath.Join(out, "test.img") {
+		t.Fatalf("Unexpected artifacts: %v", files)
+	}
+	if data, err := ioutil.ReadFile(files[0]); err != nil || string(data) != "image" {
+		t.Fatalf("Unexpected artifact contents %q: %v", data, err)
+	}
+}
+
+func TestUnpackUnsafe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-remote")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := func(entries ...*tar.Header) *bytes.Buffer {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, hdr := range entries {
+			tw.WriteHeader(hdr)
+		}
+		tw.Close()
+		gz.Close()
+		return &buf
+	}
+
+	cases := map[string]*bytes.Buffer{
+		"escape": archive(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}),
+		"device": archive(&tar.Header{Name: "null", Typeflag: tar.TypeChar, Mode: 0644}),
+		"through symlink": archive(
+			&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+			&tar.Header{Name: "link/evil", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		),
+	}
+	for name, buf := range cases {
+		target := filepath.Join(dir, strings.Replace(name, " ", "-", -1))
+		if err := Unpack(buf, target); err == nil {
+			t.Fatalf("Expected %v archive to be refused", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Fatalf("Archive escaped the target directory")
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-remote")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := queue.NewSpool(filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatalf("Failed to create spool: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(spool, "secret"))
+	defer srv.Close()
+	c, err := NewClient(srv.URL, "secret")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	job, err := spool.Submit(filepath.Join(dir, "test.spin"), queue.PriorityExperimental)
+	if err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+
+	paused, err := c.Pause(job.ID)
+	if err != nil || !paused.Held || !spool.Held(job.ID) {
+		t.Fatalf("Expected held job, got %+v: %v", paused, err)
+	}
+	resumed, err := c.Resume(job.ID)
+	if err != nil || resumed.Held || spool.Held(job.ID) {
+		t.Fatalf("Expected released job, got %+v: %v", resumed, err)
+	}
+	if _, err := c.Pause("nope"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("Expected an unknown job to be refused, got %v", err)
+	}
+
+	jobs, err := spool.Collect()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Expected one collected job, got %v: %v", jobs, err)
+	}
+	jobs[0].State = queue.JobFailed
+	if err := spool.Update(jobs[0]); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+	if _, err := c.Pause(job.ID); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("Expected a finished job to be refused, got %v", err)
+	}
+}
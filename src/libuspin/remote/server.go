@@ -0,0 +1,260 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package remote
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"libuspin/queue"
+	"libuspin/spec"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Server accepts remote builds into the spool of the daemon
+type Server struct {
+	spool *queue.Spool
+	token string
+}
+
+// NewServer will return a Server for the spool, which only accepts requests
+// bearing the token
+func NewServer(spool *queue.Spool, token string) *Server {
+	return &Server{spool: spool, token: token}
+}
+
+// authorized returns true if the request bears the token of the server
+func (s *Server) authorized(r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return s.token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+// ServeHTTP will route the request to the API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 4)
+	if parts[0] != "jobs" {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.submit(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.job(w, parts[1])
+	case len(parts) == 3 && parts[2] == "log" && r.Method == http.MethodGet:
+		s.log(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "artifacts" && r.Method == http.MethodGet:
+		s.artifacts(w, parts[1])
+	case len(parts) == 4 && parts[2] == "artifacts" && r.Method == http.MethodGet:
+		s.download(w, r, parts[1], parts[3])
+	case len(parts) == 3 && (parts[2] == "pause" || parts[2] == "resume") && r.Method == http.MethodPost:
+		s.hold(w, r, parts[1], parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON will send the value as the response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// submit will unpack the uploaded profile and queue a build of it
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	priority, err := queue.ParsePriority(q.Get("priority"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spin := q.Get("spin")
+	if !strings.HasSuffix(spin, ".spin") || path.IsAbs(spin) || path.Clean(spin) != spin || strings.HasPrefix(spin, "../") {
+		http.Error(w, fmt.Sprintf("Invalid .spin file: %q", spin), http.StatusBadRequest)
+		return
+	}
+	variables := make(map[string]string)
+	for _, v := range q["var"] {
+		fields := strings.SplitN(v, "=", 2)
+		if len(fields) != 2 || !spec.IsVariableName(fields[0]) {
+			http.Error(w, fmt.Sprintf("Expected NAME=value: %v", v), http.StatusBadRequest)
+			return
+		}
+		variables[fields[0]] = fields[1]
+	}
+
+	dir, err := ioutil.TempDir(s.spool.UploadDir(), "profile-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := Unpack(http.MaxBytesReader(w, r.Body, MaxUpload), dir); err != nil {
+		os.RemoveAll(dir)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := s.spool.SubmitJob(&queue.Job{
+		Spin:         filepath.Join(dir, filepath.FromSlash(spin)),
+		Priority:     priority,
+		Variables:    variables,
+		BuildProfile: q.Get("build_profile"),
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{
+		"job":    job.ID,
+		"remote": r.RemoteAddr,
+	}).Info("Accepted remote build")
+	writeJSON(w, http.StatusCreated, job)
+}
+
+// lookup will find the job, reporting an error to the client if it cannot
+func (s *Server) lookup(w http.ResponseWriter, id string) *queue.Job {
+	job, err := s.spool.Job(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "No such job: "+id, http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return nil
+	}
+	return job
+}
+
+// job will report the state of the job
+func (s *Server) job(w http.ResponseWriter, id string) {
+	if job := s.lookup(w, id); job != nil {
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// hold will ask the daemon to pause or resume the job, which it does the
+// next time it checks the spool
+func (s *Server) hold(w http.ResponseWriter, r *http.Request, id, action string) {
+	held := action == "pause"
+	job := s.lookup(w, id)
+	if job == nil {
+		return
+	}
+	if job.State == queue.JobSucceeded || job.State == queue.JobFailed {
+		http.Error(w, fmt.Sprintf("Job %v has already %v", id, job.State), http.StatusConflict)
+		return
+	}
+	if err := s.spool.SetHeld(id, held); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{
+		"job":    id,
+		"action": action,
+		"remote": r.RemoteAddr,
+	}).Info("Accepted remote request")
+	job.Held = held
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// log will send the build log from the requested offset
+func (s *Server) log(w http.ResponseWriter, r *http.Request, id string) {
+	if s.lookup(w, id) == nil {
+		return
+	}
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fi, err := os.Open(filepath.Join(s.spool.JobDir(id), logFile))
+	if os.IsNotExist(err) {
+		// The build hasn't started yet
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer fi.Close()
+	if _, err := fi.Seek(offset, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	io.Copy(w, fi)
+}
+
+// listArtifacts returns every file the build left within the job directory
+func listArtifacts(dir string) ([]*Artifact, error) {
+	ret := []*Artifact{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rel == jobFile, rel == logFile, strings.HasSuffix(rel, ".tmp"):
+		default:
+			ret = append(ret, &Artifact{Name: filepath.ToSlash(rel), Size: info.Size()})
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// artifacts will list the files produced by the build
+func (s *Server) artifacts(w http.ResponseWriter, id string) {
+	if s.lookup(w, id) == nil {
+		return
+	}
+	artifacts, err := listArtifacts(s.spool.JobDir(id))
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, artifacts)
+}
+
+// download will send a single artifact
+func (s *Server) download(w http.ResponseWriter, r *http.Request, id, name string) {
+	if s.lookup(w, id) == nil {
+		return
+	}
+	target, err := safeName(s.spool.JobDir(id), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if st, err := os.Lstat(target); err != nil || !st.Mode().IsRegular() {
+		http.Error(w, "No such artifact: "+name, http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, r, target)
+}
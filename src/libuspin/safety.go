@@ -0,0 +1,117 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"fmt"
+	"libuspin/backend"
+	"sort"
+)
+
+// A SafetyBypass is a step of the plan which ignores the safety checks of
+// the package manager, recorded so that every use is deliberate and auditable
+type SafetyBypass struct {
+	Step      int      `json:"step"`                // Number of the step within the plan, from 1
+	Kind      string   `json:"kind"`                // Kind of the step, see PlanStepKind
+	Names     []string `json:"names"`               // Names within the step
+	Essential []string `json:"essential,omitempty"` // Essential packages installed or removed by the step
+}
+
+// String will return a human readable description of the bypass
+func (s *SafetyBypass) String() string {
+	ret := fmt.Sprintf("Step %v (%v %v) ignores safety", s.Step, s.Kind, s.Names)
+	if len(s.Essential) > 0 {
+		ret += fmt.Sprintf(", affecting essential packages %v", s.Essential)
+	}
+	return ret
+}
+
+// SafetyBypasses returns every step of the plan that ignores safety. The
+// essential packages affected by each step are found within essential, and
+// require the groups of the plan to have been expanded.
+func (p *Plan) SafetyBypasses(essential []string) []*SafetyBypass {
+	isEssential := make(map[string]bool)
+	for _, name := range essential {
+		isEssential[name] = true
+	}
+	var ret []*SafetyBypass
+	for i, step := range p.Steps {
+		if !step.IgnoreSafety {
+			continue
+		}
+		bypass := &SafetyBypass{Step: i + 1, Kind: string(step.Kind), Names: step.Names}
+		pkgs := step.Names
+		if step.Kind == PlanStepGroup {
+			pkgs = nil
+			for _, group := range step.Names {
+				pkgs = append(pkgs, p.Expansions[group]...)
+			}
+		}
+		for _, name := range pkgs {
+			if isEssential[name] {
+				bypass.Essential = append(bypass.Essential, name)
+			}
+		}
+		sort.Strings(bypass.Essential)
+		ret = append(ret, bypass)
+	}
+	return ret
+}
+
+// RecordSafety will store every step of the plan that ignores safety. The
+// essential group is expanded with e to find the packages affected, unless
+// e is nil.
+func (p *Plan) RecordSafety(group string, e backend.GroupExpander) error {
+	essential, ok := p.Expansions[group]
+	if !ok && e != nil {
+		var err error
+		if essential, err = e.ExpandGroup(group); err != nil {
+			return fmt.Errorf("Failed to expand group '%v': %v", group, err)
+		}
+	}
+	p.Safety = p.SafetyBypasses(essential)
+	return nil
+}
+
+// UnexplainedProblems returns the problems of the package database which
+// don't belong to a package installed or removed by a step ignoring safety.
+// Groups must have been expanded for their packages to be exempt.
+func (p *Plan) UnexplainedProblems(problems []*backend.DatabaseProblem) []*backend.DatabaseProblem {
+	exempt := make(map[string]bool)
+	for _, step := range p.Steps {
+		if !step.IgnoreSafety {
+			continue
+		}
+		names := step.Names
+		if step.Kind == PlanStepGroup {
+			names = nil
+			for _, group := range step.Names {
+				names = append(names, p.Expansions[group]...)
+			}
+		}
+		for _, name := range names {
+			exempt[name] = true
+		}
+	}
+	var ret []*backend.DatabaseProblem
+	for _, problem := range problems {
+		if problem.Package == "" || !exempt[problem.Package] {
+			ret = append(ret, problem)
+		}
+	}
+	return ret
+}
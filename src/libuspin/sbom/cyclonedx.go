@@ -0,0 +1,136 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type cdxTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type cdxLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cdxHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cdxReference struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type cdxComponent struct {
+	Type       string         `json:"type"`
+	Ref        string         `json:"bom-ref"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	PURL       string         `json:"purl,omitempty"`
+	Licenses   []cdxLicense   `json:"licenses,omitempty"`
+	Hashes     []cdxHash      `json:"hashes,omitempty"`
+	References []cdxReference `json:"externalReferences,omitempty"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     []cdxTool     `json:"tools"`
+	Component *cdxComponent `json:"component"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+type cdxDocument struct {
+	Format       string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	Serial       string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []*cdxComponent `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+// WriteCycloneDX will emit the document as CycloneDX 1.5 JSON, with the
+// image as the operating system component containing every package.
+// Licenses are written by name, as package databases do not reliably use
+// SPDX identifiers.
+func (d *Document) WriteCycloneDX(w io.Writer) error {
+	tool := cdxTool{Name: d.Tool}
+	if i := strings.LastIndex(d.Tool, "-"); i > 0 {
+		tool = cdxTool{Name: d.Tool[:i], Version: d.Tool[i+1:]}
+	}
+	image := &cdxComponent{Type: "operating-system", Ref: "image", Name: d.Name}
+	doc := &cdxDocument{
+		Format:      "CycloneDX",
+		SpecVersion: "1.5",
+		Serial:      "urn:uuid:" + d.Serial,
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: d.Created.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{tool},
+			Component: image,
+		},
+		Components: []*cdxComponent{},
+	}
+	contains := cdxDependency{Ref: image.Ref, DependsOn: []string{}}
+	for i, p := range d.Packages {
+		c := &cdxComponent{
+			Type:    "library",
+			Ref:     fmt.Sprintf("package-%d-%v", i, p.Name),
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    d.purl(p),
+		}
+		for _, l := range p.Licenses {
+			if l = strings.TrimSpace(l); l != "" {
+				var lic cdxLicense
+				lic.License.Name = l
+				c.Licenses = append(c.Licenses, lic)
+			}
+		}
+		if p.SHA256 != "" {
+			c.Hashes = []cdxHash{{Algorithm: "SHA-256", Content: p.SHA256}}
+		}
+		if p.URL != "" {
+			c.References = append(c.References, cdxReference{Type: "distribution", URL: p.URL})
+		}
+		if p.Repo != "" {
+			c.References = append(c.References, cdxReference{Type: "distribution", URL: p.Repo, Comment: "Repository"})
+		}
+		doc.Components = append(doc.Components, c)
+		contains.DependsOn = append(contains.DependsOn, c.Ref)
+	}
+	doc.Dependencies = []cdxDependency{contains}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,95 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package sbom describes the software within an image as a Software Bill of
+// Materials, in the SPDX 2.3 and CycloneDX 1.5 JSON formats.
+package sbom
+
+import (
+	"github.com/solus-project/libosdev/pkg"
+	"libuspin/backend"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// A Package is a single package installed within the image
+type Package struct {
+	Name     string
+	Version  string   // Full version, including any release
+	Repo     string   // Repository installed from, if known
+	Licenses []string // As declared by the package
+	URL      string   // Location the package was downloaded from, if known
+	SHA256   string   // Digest of the downloaded package, if known
+}
+
+// A Document is the bill of materials of a single image
+type Document struct {
+	Name     string             // Name of the image, i.e. its file name
+	Serial   string             // UUID of the document, see the uuid package
+	Created  time.Time          // Creation time, which is written in UTC
+	Manager  pkg.PackageManager // Package manager of the image, selecting the package URL type
+	Tool     string             // Name and version of the generator, i.e. "uspin-0.1"
+	Packages []*Package
+}
+
+// AttachDownloads will record the origin and digest of every package from
+// the files the package manager fetched. Package files are matched by name
+// and version, which every supported format begins with, i.e.
+// "nano-2.7.1-60-1-x86_64.eopkg" or "libc6_2.36-9+deb12u4_amd64.deb".
+func (d *Document) AttachDownloads(downloads []*backend.Download) {
+	for _, p := range d.Packages {
+		// Epochs are never part of the file name
+		version := p.Version
+		if i := strings.Index(version, ":"); i >= 0 {
+			version = version[i+1:]
+		}
+		for _, dl := range downloads {
+			if dl.SHA256 == "" {
+				continue
+			}
+			name := path.Base(dl.URL)
+			if unescaped, err := url.PathUnescape(name); err == nil {
+				name = unescaped
+			}
+			if strings.HasPrefix(name, p.Name+"-"+version) || strings.HasPrefix(name, p.Name+"_"+version) {
+				if strings.Contains(dl.URL, "/") {
+					p.URL = dl.URL
+				}
+				p.SHA256 = dl.SHA256
+				break
+			}
+		}
+	}
+}
+
+// purlTypes maps the package managers to their package URL types
+var purlTypes = map[pkg.PackageManager]string{
+	backend.PackageManagerAPK:    "apk",
+	backend.PackageManagerAPT:    "deb",
+	backend.PackageManagerDNF:    "rpm",
+	backend.PackageManagerZypper: "rpm",
+}
+
+// purl returns the package URL of the package
+func (d *Document) purl(p *Package) string {
+	kind, ok := purlTypes[d.Manager]
+	if !ok {
+		kind = "generic"
+	}
+	return "pkg:" + kind + "/" + url.PathEscape(p.Name) + "@" + url.PathEscape(p.Version)
+}
@@ -0,0 +1,105 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"libuspin/backend"
+	"testing"
+	"time"
+)
+
+func testDocument() *Document {
+	return &Document{
+		Name:    "solus.iso",
+		Serial:  "8c3c9a4e-1c1f-4f4e-9a4a-3f7b1b0e2c11",
+		Created: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		Manager: backend.PackageManagerAPT,
+		Tool:    "uspin-0.1",
+		Packages: []*Package{
+			{Name: "libc6", Version: "2.36-9+deb12u4", Licenses: []string{"LGPL-2.1-or-later"}},
+			{Name: "nano", Version: "1:7.2-1", Licenses: []string{"GPL-3.0-or-later", "GNU GPL v3"}, Repo: "https://deb.debian.org/debian"},
+		},
+	}
+}
+
+func TestAttachDownloads(t *testing.T) {
+	d := testDocument()
+	d.AttachDownloads([]*backend.Download{
+		{URL: "https://deb.debian.org/debian/dists/bookworm/InRelease"},
+		{URL: "https://deb.debian.org/debian/pool/main/g/glibc/libc6_2.36-9%2Bdeb12u4_amd64.deb", Size: 10, SHA256: "aa"},
+		{URL: "nano_7.2-1_amd64.deb", Size: 10, SHA256: "bb"},
+	})
+	if p := d.Packages[0]; p.URL != "https://deb.debian.org/debian/pool/main/g/glibc/libc6_2.36-9%2Bdeb12u4_amd64.deb" || p.SHA256 != "aa" {
+		t.Fatalf("Wrong download for libc6: %v %v", p.URL, p.SHA256)
+	}
+	// Only the file name is known, which is no location
+	if p := d.Packages[1]; p.URL != "" || p.SHA256 != "bb" {
+		t.Fatalf("Wrong download for nano: %v %v", p.URL, p.SHA256)
+	}
+}
+
+func TestWriteSPDX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testDocument().WriteSPDX(&buf); err != nil {
+		t.Fatalf("Failed to write SPDX: %v", err)
+	}
+	var doc spdxDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid SPDX JSON: %v", err)
+	}
+	if doc.Version != "SPDX-2.3" || len(doc.Packages) != 3 || len(doc.Relationships) != 3 {
+		t.Fatalf("Wrong SPDX document: %+v", doc)
+	}
+	nano := doc.Packages[2]
+	if nano.LicenseDeclared != "GPL-3.0-or-later AND LicenseRef-GNU-GPL-v3" {
+		t.Fatalf("Wrong declared license: %v", nano.LicenseDeclared)
+	}
+	if len(doc.ExtractedLicenses) != 1 || doc.ExtractedLicenses[0].Name != "GNU GPL v3" {
+		t.Fatalf("Non-SPDX licenses should be referenced: %+v", doc.ExtractedLicenses)
+	}
+	if ref := nano.ExternalRefs[0].Locator; ref != "pkg:deb/nano@1:7.2-1" {
+		t.Fatalf("Wrong package URL: %v", ref)
+	}
+	if nano.DownloadLocation != spdxNoAssertion {
+		t.Fatalf("Unknown locations should not be asserted: %v", nano.DownloadLocation)
+	}
+}
+
+func TestWriteCycloneDX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testDocument().WriteCycloneDX(&buf); err != nil {
+		t.Fatalf("Failed to write CycloneDX: %v", err)
+	}
+	var doc cdxDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid CycloneDX JSON: %v", err)
+	}
+	if doc.Format != "CycloneDX" || doc.Serial != "urn:uuid:8c3c9a4e-1c1f-4f4e-9a4a-3f7b1b0e2c11" {
+		t.Fatalf("Wrong CycloneDX document: %+v", doc)
+	}
+	if len(doc.Components) != 2 || len(doc.Dependencies[0].DependsOn) != 2 {
+		t.Fatalf("Every package should be a component of the image: %+v", doc.Components)
+	}
+	if tool := doc.Metadata.Tools[0]; tool.Name != "uspin" || tool.Version != "0.1" {
+		t.Fatalf("Wrong tool: %+v", tool)
+	}
+	if doc.Metadata.Timestamp != "2026-10-16T12:00:00Z" {
+		t.Fatalf("Wrong timestamp: %v", doc.Metadata.Timestamp)
+	}
+}
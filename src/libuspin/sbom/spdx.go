@@ -0,0 +1,176 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const spdxNoAssertion = "NOASSERTION"
+
+// spdxInvalid matches the characters which may not appear in an SPDX
+// identifier or license reference
+var spdxInvalid = regexp.MustCompile(`[^A-Za-z0-9.\-]+`)
+
+// spdxLicenseID matches the form of an SPDX license identifier, optionally
+// followed by "+". Package databases which predate SPDX may still declare
+// names such as "GPLv2" which only look like an identifier.
+var spdxLicenseID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.\-]*\+?$`)
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	ID               string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	Version          string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExtractedLicense struct {
+	ID   string `json:"licenseId"`
+	Name string `json:"name"`
+	Text string `json:"extractedText"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	Version           string                 `json:"spdxVersion"`
+	DataLicense       string                 `json:"dataLicense"`
+	ID                string                 `json:"SPDXID"`
+	Name              string                 `json:"name"`
+	Namespace         string                 `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo       `json:"creationInfo"`
+	Packages          []*spdxPackage         `json:"packages"`
+	ExtractedLicenses []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+	Relationships     []spdxRelationship     `json:"relationships"`
+}
+
+// spdxLicense returns the declared license expression of the package,
+// registering a reference for every license which is not an identifier
+func spdxLicense(licenses []string, refs map[string]string) string {
+	var terms []string
+	for _, l := range licenses {
+		if l = strings.TrimSpace(l); l == "" {
+			continue
+		}
+		if !spdxLicenseID.MatchString(l) {
+			id := "LicenseRef-" + strings.Trim(spdxInvalid.ReplaceAllString(l, "-"), "-")
+			refs[id] = l
+			l = id
+		}
+		terms = append(terms, l)
+	}
+	if len(terms) == 0 {
+		return spdxNoAssertion
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// WriteSPDX will emit the document as SPDX 2.3 JSON, with the image as the
+// described package containing every installed package
+func (d *Document) WriteSPDX(w io.Writer) error {
+	doc := &spdxDocument{
+		Version:     "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		ID:          "SPDXRef-DOCUMENT",
+		Name:        d.Name,
+		Namespace:   "https://spdx.org/spdxdocs/" + spdxInvalid.ReplaceAllString(d.Name, "-") + "-" + d.Serial,
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + d.Tool},
+		},
+	}
+	image := &spdxPackage{
+		ID:               "SPDXRef-Image",
+		Name:             d.Name,
+		DownloadLocation: spdxNoAssertion,
+		LicenseConcluded: spdxNoAssertion,
+		LicenseDeclared:  spdxNoAssertion,
+		CopyrightText:    spdxNoAssertion,
+		PrimaryPurpose:   "OPERATING-SYSTEM",
+	}
+	doc.Packages = append(doc.Packages, image)
+	doc.Relationships = append(doc.Relationships, spdxRelationship{doc.ID, "DESCRIBES", image.ID})
+
+	refs := make(map[string]string)
+	for i, p := range d.Packages {
+		sp := &spdxPackage{
+			ID:               fmt.Sprintf("SPDXRef-Package-%d-%v", i, spdxInvalid.ReplaceAllString(p.Name, "-")),
+			Name:             p.Name,
+			Version:          p.Version,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxLicense(p.Licenses, refs),
+			CopyrightText:    spdxNoAssertion,
+			ExternalRefs: []spdxExternalRef{
+				{Category: "PACKAGE-MANAGER", Type: "purl", Locator: d.purl(p)},
+			},
+		}
+		if p.URL != "" {
+			sp.DownloadLocation = p.URL
+		}
+		if p.SHA256 != "" {
+			sp.Checksums = []spdxChecksum{{Algorithm: "SHA256", Value: p.SHA256}}
+		}
+		if p.Repo != "" {
+			sp.SourceInfo = "Installed from " + p.Repo
+		}
+		doc.Packages = append(doc.Packages, sp)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{image.ID, "CONTAINS", sp.ID})
+	}
+	for id, name := range refs {
+		doc.ExtractedLicenses = append(doc.ExtractedLicenses, spdxExtractedLicense{ID: id, Name: name, Text: spdxNoAssertion})
+	}
+	sort.Slice(doc.ExtractedLicenses, func(i, j int) bool { return doc.ExtractedLicenses[i].ID < doc.ExtractedLicenses[j].ID })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,244 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package secrets provides sensitive values to hooks and provisioners without
+// ever writing them to logs, plans or the image itself.
+//
+// Secrets are declared in the [secrets] section of the .spin file, mapping a
+// name to the source of the value:
+//
+//	WIFI_PSK = "env:SPIN_WIFI_PSK"          # Host environment variable
+//	LICENSE_KEY = "file:keys/license.txt"   # File relative to the .spin file
+//	SIGNING_PIN = "command:pass show oem"   # Standard output of a command
+//	OEM_KEY = "ENC[age:YWdlLWVu...]"        # Encrypted "value:..." literal
+//
+// Literal "value:" sources are only sensible when encrypted, see
+// config.Encrypt and "uspin encrypt -secret".
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Redacted is used in place of secret values in any output
+const Redacted = "********"
+
+// A Store holds the resolved secret values for the lifetime of a build
+type Store struct {
+	values map[string]string
+	extra  []string // Values only redacted, never provided, see Add
+}
+
+// resolve will retrieve the secret value from the given source
+func resolve(source, baseDir string) (string, error) {
+	fields := strings.SplitN(source, ":", 2)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("Invalid secret source: %v", source)
+	}
+	switch fields[0] {
+	case "value":
+		return fields[1], nil
+	case "env":
+		val, ok := os.LookupEnv(fields[1])
+		if !ok {
+			return "", fmt.Errorf("Environment variable not set: %v", fields[1])
+		}
+		return val, nil
+	case "file":
+		path := fields[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "command":
+		var stderr bytes.Buffer
+		cmd := exec.Command("/bin/sh", "-c", fields[1])
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("Secret command failed: %v: %v", err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	default:
+		return "", fmt.Errorf("Unknown secret source type: %v", fields[0])
+	}
+}
+
+// Files will return the absolute path of every file source within defs,
+// sorted, without reading them. Relative paths are resolved against baseDir.
+func Files(defs map[string]string, baseDir string) []string {
+	var ret []string
+	for _, source := range defs {
+		fields := strings.SplitN(source, ":", 2)
+		if len(fields) != 2 || fields[0] != "file" {
+			continue
+		}
+		path := fields[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		ret = append(ret, path)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// NewStore will resolve all of the declared secrets. Relative file sources
+// are resolved against baseDir.
+func NewStore(defs map[string]string, baseDir string) (*Store, error) {
+	s := &Store{
+		values: make(map[string]string),
+	}
+	for name, source := range defs {
+		val, err := resolve(source, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot resolve secret %v: %v", name, err)
+		}
+		s.values[name] = val
+	}
+	return s, nil
+}
+
+// Get will return the named secret value
+func (s *Store) Get(name string) (string, bool) {
+	val, ok := s.values[name]
+	return val, ok
+}
+
+// Environ will return the secrets in "NAME=value" form, sorted by name, for
+// use in the environment of hooks.
+func (s *Store) Environ() []string {
+	var ret []string
+	for name, val := range s.values {
+		ret = append(ret, name+"="+val)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Add will register sensitive values which aren't named secrets, i.e. the
+// decrypted values of the configuration, so that they are redacted too
+func (s *Store) Add(values ...string) {
+	s.extra = append(s.extra, values...)
+}
+
+// sorted returns every value to redact, longest first so that secrets
+// containing other secrets are fully hidden
+func (s *Store) sorted() []string {
+	var vals []string
+	for _, val := range s.values {
+		if val != "" {
+			vals = append(vals, val)
+		}
+	}
+	for _, val := range s.extra {
+		if val != "" {
+			vals = append(vals, val)
+		}
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		return len(vals[i]) > len(vals[j])
+	})
+	return vals
+}
+
+// Redact will replace all secret values within the input
+func (s *Store) Redact(in string) string {
+	for _, val := range s.sorted() {
+		in = strings.Replace(in, val, Redacted, -1)
+	}
+	return in
+}
+
+// A Writer redacts secret values from everything written to it, i.e. the
+// output of commands run during the build. Output ending with the start of
+// a secret is held back until it can be told apart, or Flush is called.
+type Writer struct {
+	w       io.Writer
+	store   *Store
+	pending []byte
+}
+
+// NewWriter will return a Writer redacting everything written to w
+func NewWriter(w io.Writer, store *Store) *Writer {
+	return &Writer{w: w, store: store}
+}
+
+// Write will write the redacted data to the underlying writer
+func (w *Writer) Write(p []byte) (int, error) {
+	out := w.store.Redact(string(w.pending) + string(p))
+	hold := 0
+	for _, val := range w.store.sorted() {
+		for n := len(val) - 1; n > hold; n-- {
+			if strings.HasSuffix(out, val[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+	w.pending = []byte(out[len(out)-hold:])
+	if _, err := io.WriteString(w.w, out[:len(out)-hold]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush will write any output held back, once no more will follow
+func (w *Writer) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.pending)
+	w.pending = nil
+	return err
+}
+
+// A Formatter wraps another logrus Formatter, redacting all secret values
+// from the formatted output.
+type Formatter struct {
+	log.Formatter
+	store *Store
+}
+
+// NewFormatter will return a Formatter wrapping the given formatter
+func NewFormatter(wrapped log.Formatter, store *Store) *Formatter {
+	return &Formatter{
+		Formatter: wrapped,
+		store:     store,
+	}
+}
+
+// Format will format the entry with the wrapped Formatter and redact it
+func (f *Formatter) Format(e *log.Entry) ([]byte, error) {
+	out, err := f.Formatter.Format(e)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(f.store.Redact(string(out))), nil
+}
@@ -0,0 +1,95 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package secrets
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.txt"), []byte("hunter2\n"), 00600); err != nil {
+		t.Fatalf("Cannot write secret file: %v", err)
+	}
+	os.Setenv("USPIN_TEST_SECRET", "s3cr3t")
+
+	s, err := NewStore(map[string]string{
+		"FILE":    "file:key.txt",
+		"ENV":     "env:USPIN_TEST_SECRET",
+		"COMMAND": "command:echo topsecret",
+		"VALUE":   "value:decrypted",
+	}, dir)
+	if err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	if val, _ := s.Get("FILE"); val != "hunter2" {
+		t.Fatalf("Incorrect file secret: %v", val)
+	}
+	env := s.Environ()
+	if len(env) != 4 || env[0] != "COMMAND=topsecret" {
+		t.Fatalf("Incorrect environment: %v", env)
+	}
+	if out := s.Redact("psk=hunter2 key=s3cr3t"); out != "psk="+Redacted+" key="+Redacted {
+		t.Fatalf("Secrets not redacted: %v", out)
+	}
+
+	files := Files(map[string]string{"FILE": "file:key.txt", "ABS": "file:/etc/key", "ENV": "env:HOME"}, dir)
+	if len(files) != 2 || files[0] != "/etc/key" || files[1] != filepath.Join(dir, "key.txt") {
+		t.Fatalf("Incorrect secret files: %v", files)
+	}
+
+	if val, _ := s.Get("VALUE"); val != "decrypted" {
+		t.Fatalf("Incorrect literal secret: %v", val)
+	}
+	if _, err := NewStore(map[string]string{"BAD": "vault:foo"}, dir); err == nil {
+		t.Fatalf("Should not resolve unknown source types")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	s, err := NewStore(map[string]string{"PSK": "value:hunter2"}, "")
+	if err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	s.Add("decrypted\nkey")
+
+	var out bytes.Buffer
+	w := NewWriter(&out, s)
+	for _, chunk := range []string{"psk=hun", "ter2\n", "a decrypted\n", "key\n", "ends with hun"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if out.String() != "psk="+Redacted+"\na "+Redacted+"\nends with " {
+		t.Fatalf("Secrets split across writes not redacted: %q", out.String())
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "ends with hun") {
+		t.Fatalf("Held back output not flushed: %q", out.String())
+	}
+}
@@ -0,0 +1,291 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package seed populates an empty rootfs from an existing one, such as a
+// stage3 tarball or a container image, for the package manager to build
+// upon. This allows images of distributions without a clean bootstrap path.
+package seed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"libuspin/tree"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Kind is the type of a seed
+type Kind string
+
+const (
+	// KindDirectory is an unpacked rootfs
+	KindDirectory Kind = "directory"
+
+	// KindTarball is a rootfs tarball, compressed or not
+	KindTarball Kind = "tarball"
+
+	// KindOCI is a local OCI image layout
+	KindOCI Kind = "oci"
+
+	// KindRemote is any image skopeo can fetch, i.e. "docker://alpine:3.19"
+	KindRemote Kind = "remote"
+)
+
+const (
+	ociMediaTypeIndex = "application/vnd.oci.image.index.v1+json"
+	ociWhiteout       = ".wh."
+	ociOpaque         = ".wh..wh..opq"
+)
+
+// ErrRemote is returned when the contents of a remote seed are needed before
+// it has been fetched
+var ErrRemote = errors.New("Remote seeds are only fetched during the build")
+
+// remoteTransports are the skopeo transports accepted as remote seeds
+var remoteTransports = []string{
+	"docker://",
+	"docker-archive:",
+	"docker-daemon:",
+	"oci-archive:",
+	"containers-storage:",
+}
+
+// A Seed is a resolved source for the initial rootfs
+type Seed struct {
+	Source string // Absolute path, or the skopeo reference of remote seeds
+	Kind   Kind
+}
+
+// Resolve will determine the kind of the seed, with local paths resolved
+// relative to baseDir
+func Resolve(source, baseDir string) (*Seed, error) {
+	for _, transport := range remoteTransports {
+		if strings.HasPrefix(source, transport) {
+			return &Seed{Source: source, Kind: KindRemote}, nil
+		}
+	}
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(baseDir, source)
+	}
+	st, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	s := &Seed{Source: source, Kind: KindTarball}
+	if st.IsDir() {
+		s.Kind = KindDirectory
+		if _, err := os.Stat(filepath.Join(source, "oci-layout")); err == nil {
+			s.Kind = KindOCI
+		}
+	}
+	return s, nil
+}
+
+// Contents returns the directories or tarballs making up the seed, topmost
+// first, i.e. to find its os-release
+func (s *Seed) Contents() ([]string, error) {
+	switch s.Kind {
+	case KindRemote:
+		return nil, ErrRemote
+	case KindOCI:
+		layers, err := ociLayers(s.Source)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+			layers[i], layers[j] = layers[j], layers[i]
+		}
+		return layers, nil
+	default:
+		return []string{s.Source}, nil
+	}
+}
+
+// Apply will populate root, which should be empty, from the seed
+func (s *Seed) Apply(root string) error {
+	switch s.Kind {
+	case KindDirectory:
+		return copyDir(s.Source, root)
+	case KindTarball:
+		return tar("-C", root, "--numeric-owner", "--xattrs", "--xattrs-include=*", "--acls", "-xpf", s.Source)
+	case KindOCI:
+		return applyLayout(s.Source, root)
+	default:
+		return applyRemote(s.Source, root)
+	}
+}
+
+// tar runs tar, including its output within any error
+func tar(args ...string) error {
+	out, err := exec.Command("tar", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tar failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// copyDir will copy everything within src into root. A lost+found created
+// along with the root filesystem is kept.
+func copyDir(src, root string) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == "lost+found" {
+			continue
+		}
+		if err := tree.Copy(filepath.Join(src, entry.Name()), filepath.Join(root, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRemote will fetch the image into a temporary layout with skopeo
+func applyRemote(ref, root string) error {
+	tmp, err := ioutil.TempDir("", "uspin-seed")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	out, err := exec.Command("skopeo", "copy", ref, "oci:"+tmp+":seed").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to fetch %v: %v: %v", ref, err, strings.TrimSpace(string(out)))
+	}
+	return applyLayout(tmp, root)
+}
+
+// An ociDescriptor points at a blob within the image layout
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// blobPath returns the location of the blob within the layout
+func blobPath(layout string, d *ociDescriptor) (string, error) {
+	parts := strings.SplitN(d.Digest, ":", 2)
+	if len(parts) != 2 || strings.ContainsAny(d.Digest, "/\\") {
+		return "", fmt.Errorf("Invalid digest: %q", d.Digest)
+	}
+	return filepath.Join(layout, "blobs", parts[0], parts[1]), nil
+}
+
+// readBlob will decode the JSON blob
+func readBlob(layout string, d *ociDescriptor, v interface{}) error {
+	p, err := blobPath(layout, d)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ociLayers returns the layer blobs of the single image in the layout, in
+// the order they are applied
+func ociLayers(layout string) ([]string, error) {
+	var index struct {
+		Manifests []*ociDescriptor `json:"manifests"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("Invalid OCI index in %v: %v", layout, err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].MediaType == ociMediaTypeIndex {
+		return nil, fmt.Errorf("OCI layout %v must hold exactly one single platform image", layout)
+	}
+	var manifest struct {
+		Layers []*ociDescriptor `json:"layers"`
+	}
+	if err := readBlob(layout, index.Manifests[0], &manifest); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, layer := range manifest.Layers {
+		p, err := blobPath(layout, layer)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// applyLayout will apply every layer of the image in the layout to root
+func applyLayout(layout, root string) error {
+	layers, err := ociLayers(layout)
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := applyLayer(layer, root); err != nil {
+			return fmt.Errorf("Failed to apply layer %v: %v", filepath.Base(layer), err)
+		}
+	}
+	return nil
+}
+
+// applyLayer will extract the layer over root. Whiteouts delete what the
+// lower layers provided, so are processed first and never extracted.
+func applyLayer(layer, root string) error {
+	out, err := exec.Command("tar", "-tf", layer).Output()
+	if err != nil {
+		return fmt.Errorf("Cannot list layer: %v", err)
+	}
+	for _, entry := range strings.Split(string(out), "\n") {
+		// Cleaning an absolute path keeps it within root
+		entry = path.Clean("/" + entry)
+		dir, name := path.Split(entry)
+		target := filepath.Join(root, dir)
+		switch {
+		case name == ociOpaque:
+			err = empty(target)
+		case strings.HasPrefix(name, ociWhiteout):
+			err = os.RemoveAll(filepath.Join(target, strings.TrimPrefix(name, ociWhiteout)))
+		default:
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return tar("-C", root, "--numeric-owner", "--xattrs", "--xattrs-include=*", "--acls", "--exclude", ociWhiteout+"*", "-xpf", layer)
+}
+
+// empty will remove everything within dir, but not dir itself
+func empty(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
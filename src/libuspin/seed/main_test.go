@@ -0,0 +1,131 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package seed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles will create the files beneath dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 00755)
+		if err := ioutil.WriteFile(path, []byte(content), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+}
+
+// addBlob will store the file as a blob of the layout, returning its digest
+func addBlob(t *testing.T, layout, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	writeFiles(t, layout, map[string]string{"blobs/sha256/" + digest: string(data)})
+	return "sha256:" + digest
+}
+
+func TestOCISeed(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not available")
+	}
+	dir, err := ioutil.TempDir("", "uspin-seed")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, filepath.Join(dir, "lower"), map[string]string{
+		"etc/os-release":    "ID=alpine\n",
+		"etc/motd":          "Welcome\n",
+		"var/cache/apk/old": "stale\n",
+	})
+	writeFiles(t, filepath.Join(dir, "upper"), map[string]string{
+		"etc/.wh.motd":               "",
+		"var/cache/apk/.wh..wh..opq": "",
+		"var/cache/apk/APKINDEX":     "fresh\n",
+	})
+	layout := filepath.Join(dir, "layout")
+	var layers, digests []string
+	for _, name := range []string{"lower", "upper"} {
+		tarball := filepath.Join(dir, name+".tar.gz")
+		if out, err := exec.Command("tar", "-czf", tarball, "-C", filepath.Join(dir, name), ".").CombinedOutput(); err != nil {
+			t.Fatalf("Failed to create layer: %v %s", err, out)
+		}
+		digests = append(digests, addBlob(t, layout, tarball))
+		layers = append(layers, fmt.Sprintf(`{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": %q}`, digests[len(digests)-1]))
+	}
+	manifest := filepath.Join(dir, "manifest.json")
+	writeFiles(t, dir, map[string]string{
+		"manifest.json": fmt.Sprintf(`{"schemaVersion": 2, "layers": [%v, %v]}`, layers[0], layers[1]),
+	})
+	writeFiles(t, layout, map[string]string{
+		"oci-layout": `{"imageLayoutVersion": "1.0.0"}`,
+		"index.json": fmt.Sprintf(`{"manifests": [{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": %q}]}`, addBlob(t, layout, manifest)),
+	})
+
+	s, err := Resolve("layout", dir)
+	if err != nil || s.Kind != KindOCI {
+		t.Fatalf("Layout not recognised: %v %v", s, err)
+	}
+	contents, err := s.Contents()
+	if err != nil || len(contents) != 2 || "sha256:"+filepath.Base(contents[0]) != digests[1] {
+		t.Fatalf("Contents should be the layers, topmost first: %v %v", contents, err)
+	}
+	root := filepath.Join(dir, "root")
+	os.MkdirAll(root, 00755)
+	if err := s.Apply(root); err != nil {
+		t.Fatalf("Failed to apply seed: %v", err)
+	}
+	for path, want := range map[string]string{
+		"etc/os-release":         "ID=alpine\n",
+		"var/cache/apk/APKINDEX": "fresh\n",
+	} {
+		if data, _ := ioutil.ReadFile(filepath.Join(root, path)); string(data) != want {
+			t.Fatalf("Wrong contents of %v: %q", path, data)
+		}
+	}
+	for _, path := range []string{"etc/motd", "etc/.wh.motd", "var/cache/apk/old", "var/cache/apk/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Fatalf("%v should have been removed: %v", path, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s, err := Resolve("docker://docker.io/library/alpine:3.19", "/srv/profile")
+	if err != nil || s.Kind != KindRemote {
+		t.Fatalf("Registry references should be remote: %v %v", s, err)
+	}
+	if _, err := s.Contents(); err != ErrRemote {
+		t.Fatalf("Remote seeds cannot be inspected before fetching: %v", err)
+	}
+	if _, err := Resolve("missing.tar.xz", "/srv/profile"); err == nil {
+		t.Fatalf("Missing seeds should fail")
+	}
+}
@@ -0,0 +1,310 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package signature verifies that a profile directory was signed by a
+// trusted key before it is built, so that build farms only build reviewed
+// profiles.
+//
+// Profiles may be signed with a detached signature over a listing of every
+// file the build reads, or by building from a signed git tag.
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// SumsFile lists the digest of every file within a signed profile
+	SumsFile = "PROFILE.sums"
+
+	// SignatureFile is the detached signature of the SumsFile
+	SignatureFile = SumsFile + ".asc"
+)
+
+// Method is the way in which a profile has been signed
+type Method string
+
+const (
+	// MethodNone disables verification
+	MethodNone Method = ""
+
+	// MethodDetached requires a valid SignatureFile matching the directory
+	MethodDetached Method = "detached"
+
+	// MethodGitTag requires the directory to be a clean checkout of a signed tag
+	MethodGitTag Method = "git-tag"
+)
+
+// ParseMethod will return the Method for the given name
+func ParseMethod(name string) (Method, error) {
+	switch m := Method(name); m {
+	case MethodNone, MethodDetached, MethodGitTag:
+		return m, nil
+	default:
+		return MethodNone, fmt.Errorf("Unknown signature method '%v', expected %v or %v", name, MethodDetached, MethodGitTag)
+	}
+}
+
+// Sums will list every file within the inputs of a profile in a stable
+// order. Each input may be a file or a directory, which includes everything
+// beneath it. Regular files are listed by their SHA256 and symlinks by their
+// target, while devices, pipes and sockets are refused. Inputs which don't
+// exist are left out, so that creating one is seen as a change. Paths are
+// relative to dir where possible, and absolute otherwise.
+func Sums(dir string, inputs []string) ([]byte, error) {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, input := range inputs {
+		root, err := filepath.EvalSymlinks(input)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Name everything beneath the input as given, not its target
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			name := sumName(dir, filepath.Join(input, rel))
+			if seen[name] || name == SumsFile || name == SignatureFile {
+				return nil
+			}
+			seen[name] = true
+			sum, err := sumEntry(path, info)
+			if err != nil || sum == "" {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("%v  %v\n", sum, name))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// sumName returns the name of path within the sums, relative to dir if it
+// is beneath it
+func sumName(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Clean(path)
+	}
+	return rel
+}
+
+// sumEntry returns the digest of a single entry, or an empty string for
+// directories, which are covered by their contents
+func sumEntry(path string, info os.FileInfo) (string, error) {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return "", nil
+	case mode.IsRegular():
+		return hashFile(path)
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.Sum256([]byte(target))
+		return "symlink:" + hex.EncodeToString(h[:]), nil
+	default:
+		return "", fmt.Errorf("Cannot sign special file %v (%v)", path, mode.Type())
+	}
+}
+
+// hashFile returns the hex encoded SHA256 of the file
+func hashFile(path string) (string, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fi.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fi); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign will write the SumsFile of the profile and sign it with gpg, using
+// the default key if key is empty
+func Sign(dir, key string, inputs []string) error {
+	sums, err := Sums(dir, inputs)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, SumsFile)
+	if err := ioutil.WriteFile(path, sums, 00644); err != nil {
+		return err
+	}
+	args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", filepath.Join(dir, SignatureFile)}
+	if key != "" {
+		args = append(args, "--local-user", key)
+	}
+	args = append(args, path)
+	if out, err := exec.Command("gpg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to sign profile: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// VerifyDetached will check the SignatureFile against the keyring, then
+// ensure the inputs contain exactly the files that were signed
+func VerifyDetached(dir, keyring string, inputs []string) error {
+	sumsPath := filepath.Join(dir, SumsFile)
+	if out, err := exec.Command("gpgv", "--keyring", keyring, filepath.Join(dir, SignatureFile), sumsPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("Profile signature is invalid: %v: %s", err, bytes.TrimSpace(out))
+	}
+	signed, err := ioutil.ReadFile(sumsPath)
+	if err != nil {
+		return err
+	}
+	actual, err := Sums(dir, inputs)
+	if err != nil {
+		return err
+	}
+	if changes := diffSums(signed, actual); len(changes) > 0 {
+		return fmt.Errorf("Profile differs from what was signed: %v", strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+// parseSums maps each file within a sums listing to its digest
+func parseSums(data []byte) map[string]string {
+	ret := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "  ", 2)
+		if len(fields) == 2 {
+			ret[fields[1]] = fields[0]
+		}
+	}
+	return ret
+}
+
+// diffSums describes every file added, removed or modified since signing
+func diffSums(signed, actual []byte) []string {
+	want := parseSums(signed)
+	have := parseSums(actual)
+	var ret []string
+	for name, sum := range have {
+		switch want[name] {
+		case "":
+			ret = append(ret, name+" added")
+		case sum:
+		default:
+			ret = append(ret, name+" modified")
+		}
+	}
+	for name := range want {
+		if _, ok := have[name]; !ok {
+			ret = append(ret, name+" removed")
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// VerifyGitTag will ensure the inputs are a clean checkout of a tag with
+// a valid signature, returning the tag. Inputs outside of the repository
+// aren't covered by the tag, and untracked or ignored files within it are
+// changes. Trust is decided by the gpg configuration of the user running git.
+func VerifyGitTag(dir string, inputs []string) (string, error) {
+	git := func(args ...string) (string, error) {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %v failed: %v: %s", args[0], err, bytes.TrimSpace(out))
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	tag, err := git("describe", "--exact-match", "--tags", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("Profile is not a tagged checkout: %v", err)
+	}
+	if _, err := git("verify-tag", tag); err != nil {
+		return "", fmt.Errorf("Tag %v is not validly signed: %v", tag, err)
+	}
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	if top, err = filepath.EvalSymlinks(top); err != nil {
+		return "", err
+	}
+	var outside []string
+	for _, input := range inputs {
+		path, err := filepath.EvalSymlinks(input)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if sumName(top, path) == filepath.Clean(path) {
+			outside = append(outside, input)
+		}
+	}
+	if len(outside) > 0 {
+		return "", fmt.Errorf("Profile uses files outside of the repository of tag %v: %v", tag, strings.Join(outside, ", "))
+	}
+	args := append([]string{"status", "--porcelain", "--ignored", "--untracked-files=all", "--"}, inputs...)
+	status, err := git(args...)
+	if err != nil {
+		return "", err
+	}
+	if status != "" {
+		return "", fmt.Errorf("Profile has changes since tag %v:\n%v", tag, status)
+	}
+	return tag, nil
+}
+
+// Verify will check the inputs of the profile with the given method
+func Verify(dir string, method Method, keyring string, inputs []string) error {
+	switch method {
+	case MethodNone:
+		return nil
+	case MethodDetached:
+		if keyring == "" {
+			return fmt.Errorf("A keyring is required to verify %v signatures", method)
+		}
+		return VerifyDetached(dir, keyring, inputs)
+	case MethodGitTag:
+		_, err := VerifyGitTag(dir, inputs)
+		return err
+	default:
+		return fmt.Errorf("Unknown signature method '%v'", method)
+	}
+}
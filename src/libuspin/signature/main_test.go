@@ -0,0 +1,109 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package signature
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-signature")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "uspin-signature")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outside)
+
+	write := func(path, data string) {
+		if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+			t.Fatalf("Cannot create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 00644); err != nil {
+			t.Fatalf("Cannot write file: %v", err)
+		}
+	}
+	for _, name := range []string{"minimal.spin", "overlay/etc/motd", "overlay/root/.profile", "workspace/rootfs.img", SumsFile} {
+		write(filepath.Join(dir, name), name)
+	}
+	write(filepath.Join(outside, "common.packages"), "nano")
+	if err := os.Symlink("/etc/hostname", filepath.Join(dir, "overlay/etc/hostname")); err != nil {
+		t.Fatalf("Cannot create symlink: %v", err)
+	}
+	inputs := []string{
+		filepath.Join(dir, "minimal.spin"),
+		filepath.Join(dir, "overlay"),
+		filepath.Join(dir, "overlay.meta.toml"),
+		filepath.Join(outside, "common.packages"),
+	}
+
+	signed, err := Sums(dir, inputs)
+	if err != nil {
+		t.Fatalf("Cannot compute sums: %v", err)
+	}
+	var names []string
+	for name := range parseSums(signed) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{
+		filepath.Join(outside, "common.packages"),
+		"minimal.spin",
+		"overlay/etc/hostname",
+		"overlay/etc/motd",
+		"overlay/root/.profile",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Wrong files in sums: %v", names)
+	}
+
+	write(filepath.Join(dir, "minimal.spin"), "changed")
+	write(filepath.Join(dir, "overlay.meta.toml"), "")
+	write(filepath.Join(dir, "overlay/root/.ssh/authorized_keys"), "ssh-ed25519 AAAA")
+	if err := os.Remove(filepath.Join(dir, "overlay/etc/motd")); err != nil {
+		t.Fatalf("Cannot remove file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "overlay/etc/hostname")); err != nil {
+		t.Fatalf("Cannot remove symlink: %v", err)
+	}
+	if err := os.Symlink("/etc/shadow", filepath.Join(dir, "overlay/etc/hostname")); err != nil {
+		t.Fatalf("Cannot create symlink: %v", err)
+	}
+	actual, err := Sums(dir, inputs)
+	if err != nil {
+		t.Fatalf("Cannot compute sums: %v", err)
+	}
+	changes := diffSums(signed, actual)
+	expected = []string{
+		"minimal.spin modified",
+		"overlay.meta.toml added",
+		"overlay/etc/hostname modified",
+		"overlay/etc/motd removed",
+		"overlay/root/.ssh/authorized_keys added",
+	}
+	if strings.Join(changes, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Wrong changes: %v", changes)
+	}
+}
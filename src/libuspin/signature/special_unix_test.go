@@ -0,0 +1,42 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build unix
+
+package signature
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSumsSpecialFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-signature")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 00644); err != nil {
+		t.Fatalf("Cannot create fifo: %v", err)
+	}
+	if _, err := Sums(dir, []string{dir}); err == nil || !strings.Contains(err.Error(), "special file") {
+		t.Fatalf("Special files should be refused: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package smoke
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrConsoleClosed is returned when the machine has gone away
+	ErrConsoleClosed = errors.New("Console closed")
+)
+
+// A Console is the serial console of a machine, which can be waited upon
+// for output and written to.
+type Console struct {
+	in     io.Writer
+	mu     sync.Mutex
+	buf    []byte        // Output not yet consumed by Expect
+	notify chan struct{} // Signalled whenever output arrives
+	closed bool
+
+	watchers []*watcher
+	history  []byte // Output seen by pending watchers
+}
+
+// A watcher is called once its pattern first appears in the output
+type watcher struct {
+	pattern *regexp.Regexp
+	fn      func()
+	from    int // Offset within history still to be searched
+}
+
+// watchOverlap is searched again on each read, as markers may be split
+// across reads.
+const watchOverlap = 1024
+
+// NewConsole will read the output until it is closed, copying everything to
+// the transcript if not nil.
+func NewConsole(in io.Writer, out io.Reader, transcript io.Writer) *Console {
+	c := &Console{
+		in:     in,
+		notify: make(chan struct{}, 1),
+	}
+	go c.read(out, transcript)
+	return c
+}
+
+func (c *Console) read(out io.Reader, transcript io.Writer) {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := out.Read(chunk)
+		if n > 0 {
+			if transcript != nil {
+				transcript.Write(chunk[:n])
+			}
+			c.mu.Lock()
+			c.buf = append(c.buf, chunk[:n]...)
+			c.checkWatchers(chunk[:n])
+			c.mu.Unlock()
+			c.signal()
+		}
+		if err != nil {
+			c.mu.Lock()
+			c.closed = true
+			c.mu.Unlock()
+			c.signal()
+			return
+		}
+	}
+}
+
+func (c *Console) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Expect will wait for the pattern to appear in the output, returning the
+// submatches. Everything up to the end of the match is consumed.
+func (c *Console) Expect(pattern *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	deadline := time.After(timeout)
+	for {
+		c.mu.Lock()
+		loc := pattern.FindSubmatchIndex(c.buf)
+		var match []string
+		if loc != nil {
+			for i := 0; i < len(loc); i += 2 {
+				if loc[i] < 0 {
+					match = append(match, "")
+				} else {
+					match = append(match, string(c.buf[loc[i]:loc[i+1]]))
+				}
+			}
+			c.buf = c.buf[loc[1]:]
+		}
+		closed := c.closed
+		c.mu.Unlock()
+
+		if match != nil {
+			return match, nil
+		}
+		if closed {
+			return nil, ErrConsoleClosed
+		}
+		select {
+		case <-c.notify:
+		case <-deadline:
+			return nil, fmt.Errorf("Timed out after %v waiting for %q", timeout, pattern)
+		}
+	}
+}
+
+// Watch will call fn, within a new goroutine, when the pattern is first seen
+// in output arriving from now on. Unlike Expect, no output is consumed.
+func (c *Console) Watch(pattern *regexp.Regexp, fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchers = append(c.watchers, &watcher{
+		pattern: pattern,
+		fn:      fn,
+		from:    len(c.history),
+	})
+}
+
+// checkWatchers must be called with the lock held
+func (c *Console) checkWatchers(data []byte) {
+	if len(c.watchers) == 0 {
+		c.history = nil
+		return
+	}
+	c.history = append(c.history, data...)
+	pending := c.watchers[:0]
+	for _, w := range c.watchers {
+		if w.pattern.Match(c.history[w.from:]) {
+			go w.fn()
+			continue
+		}
+		if w.from = len(c.history) - watchOverlap; w.from < 0 {
+			w.from = 0
+		}
+		pending = append(pending, w)
+	}
+	c.watchers = pending
+
+	// Drop the history no watcher will search again
+	if len(pending) == 0 {
+		c.history = nil
+		return
+	}
+	min := pending[0].from
+	for _, w := range pending {
+		if w.from < min {
+			min = w.from
+		}
+	}
+	c.history = c.history[min:]
+	for _, w := range pending {
+		w.from -= min
+	}
+}
+
+// Send will write the line to the console
+func (c *Console) Send(line string) error {
+	_, err := io.WriteString(c.in, line+"\n")
+	return err
+}
@@ -0,0 +1,308 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package smoke boots finished images within QEMU to test them, running
+// user provided scripts over the serial console.
+package smoke
+
+import (
+	"encoding/base64"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"libuspin/build"
+	"libuspin/config"
+	"libuspin/process"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The markers are echoed with a split string so that the echo of the typed
+// command itself can never match.
+var (
+	loginPrompt    = regexp.MustCompile(`login:\s*$`)
+	passwordPrompt = regexp.MustCompile(`Password:\s*$`)
+	readyMarker    = regexp.MustCompile(`USPIN-READY`)
+	resultMarker   = regexp.MustCompile(`USPIN-RESULT:(\d+)`)
+)
+
+// qemuBinaries maps the publish architecture to the QEMU system emulator
+var qemuBinaries = map[string]string{
+	"x86_64":  "qemu-system-x86_64",
+	"i686":    "qemu-system-i386",
+	"aarch64": "qemu-system-aarch64",
+}
+
+// ScriptPath is where each script is written within the booted image
+const ScriptPath = "/tmp/uspin-test.sh"
+
+// A Result is the outcome of a single test script
+type Result struct {
+	Script   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// A Machine is a running QEMU instance booted from the image
+type Machine struct {
+	Console *Console
+	cmd     *exec.Cmd
+	dir     string // Temporary directory holding the QMP socket
+	qmp     *QMP
+	qmpLock sync.Mutex
+}
+
+// qemuArgs returns the QEMU command line to boot the image
+func qemuArgs(conf *config.SectionTest, files *build.BootFiles, qmp string) []string {
+	args := []string{
+		"-qmp", "unix:" + qmp + ",server,nowait",
+		"-m", strconv.Itoa(conf.Memory),
+		"-smp", strconv.Itoa(conf.CPUs),
+		"-display", "none",
+		"-serial", "stdio",
+		"-monitor", "none",
+		"-no-reboot",
+		"-cdrom", files.Media,
+		"-kernel", files.Kernel,
+		"-initrd", files.Initrd,
+		"-append", files.Cmdline + " console=ttyS0,115200",
+	}
+	if _, err := os.Stat("/dev/kvm"); err == nil {
+		args = append(args, "-enable-kvm", "-cpu", "host")
+	}
+	return args
+}
+
+// Boot will start the image within QEMU, copying the serial console output
+// to the transcript.
+func Boot(conf *config.SectionTest, arch string, files *build.BootFiles, transcript io.Writer) (*Machine, error) {
+	binary := conf.QEMU
+	if binary == "" {
+		if binary = qemuBinaries[arch]; binary == "" {
+			return nil, fmt.Errorf("No QEMU emulator known for %v, set test.qemu", arch)
+		}
+	}
+	dir, err := ioutil.TempDir("", "uspin-qemu")
+	if err != nil {
+		return nil, err
+	}
+	qmp := filepath.Join(dir, "qmp.sock")
+	cmd := exec.Command(binary, qemuArgs(conf, files, qmp)...)
+	process.Trace(cmd)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	m := &Machine{
+		Console: NewConsole(in, out, transcript),
+		cmd:     cmd,
+		dir:     dir,
+	}
+	if m.qmp, err = DialQMP(qmp, 30*time.Second); err != nil {
+		m.Stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Login will wait for the login prompt and log in on the serial console
+func (m *Machine) Login(user, password string, timeout time.Duration) error {
+	if _, err := m.Console.Expect(loginPrompt, timeout); err != nil {
+		return fmt.Errorf("Image did not reach the login prompt: %v", err)
+	}
+	if err := m.Console.Send(user); err != nil {
+		return err
+	}
+	if password != "" {
+		if _, err := m.Console.Expect(passwordPrompt, time.Minute); err != nil {
+			return err
+		}
+		if err := m.Console.Send(password); err != nil {
+			return err
+		}
+	}
+	if err := m.Console.Send(`echo USPIN-""READY`); err != nil {
+		return err
+	}
+	if _, err := m.Console.Expect(readyMarker, time.Minute); err != nil {
+		return fmt.Errorf("Failed to log in as %v: %v", user, err)
+	}
+	return nil
+}
+
+// scriptCommands returns the console input needed to run the script. It is
+// sent base64 encoded so that the terminal cannot mangle it.
+func scriptCommands(script []byte) []string {
+	encoded := base64.StdEncoding.EncodeToString(script)
+	lines := []string{fmt.Sprintf("base64 -d > %v << 'USPIN_EOF'", ScriptPath)}
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded, "USPIN_EOF")
+	return append(lines, fmt.Sprintf(`sh %v; echo "USPIN-""RESULT:$?"`, ScriptPath))
+}
+
+// RunScript will run the script within the machine, returning its exit code
+func (m *Machine) RunScript(script []byte, timeout time.Duration) (int, error) {
+	for _, line := range scriptCommands(script) {
+		if err := m.Console.Send(line); err != nil {
+			return -1, err
+		}
+	}
+	match, err := m.Console.Expect(resultMarker, timeout)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(match[1])
+}
+
+// Stop will power off the machine
+func (m *Machine) Stop() error {
+	defer os.RemoveAll(m.dir)
+	if m.qmp != nil {
+		m.qmp.Close()
+	}
+	if err := m.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	m.cmd.Wait()
+	return nil
+}
+
+// scheduleScreenshots will take each screenshot once its delay has passed,
+// counted from boot or from its marker appearing. The returned function
+// waits for those in progress or timed; markers seen afterwards are ignored.
+func (m *Machine) scheduleScreenshots(shots []config.Screenshot, image string) func() {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	waiting := false
+
+	take := func(shot config.Screenshot) {
+		defer wg.Done()
+		time.Sleep(time.Duration(shot.After) * time.Second)
+		path := ScreenshotPath(image, shot.Name)
+		if err := m.Screenshot(path); err != nil {
+			log.WithFields(log.Fields{
+				"screenshot": shot.Name,
+				"error":      err,
+			}).Warning("Failed to take screenshot")
+			return
+		}
+		log.WithFields(log.Fields{
+			"screenshot": path,
+		}).Info("Took screenshot")
+	}
+
+	for _, shot := range shots {
+		shot := shot
+		if shot.Marker == "" {
+			wg.Add(1)
+			go take(shot)
+			continue
+		}
+		m.Console.Watch(regexp.MustCompile(shot.Marker), func() {
+			mu.Lock()
+			if waiting {
+				mu.Unlock()
+				return
+			}
+			wg.Add(1)
+			mu.Unlock()
+			take(shot)
+		})
+	}
+
+	return func() {
+		mu.Lock()
+		waiting = true
+		mu.Unlock()
+		wg.Wait()
+	}
+}
+
+// Run will boot the image and run each configured script in turn, relative
+// to baseDir. Screenshots are stored alongside files.Media. An error is
+// returned if the image fails to boot, or any of the scripts fail.
+func Run(conf *config.SectionTest, arch string, files *build.BootFiles, baseDir string, transcript io.Writer) ([]*Result, error) {
+	scripts := make([][]byte, len(conf.Scripts))
+	for i, script := range conf.Scripts {
+		if !filepath.IsAbs(script) {
+			script = filepath.Join(baseDir, script)
+		}
+		data, err := ioutil.ReadFile(script)
+		if err != nil {
+			return nil, err
+		}
+		scripts[i] = data
+	}
+
+	m, err := Boot(conf, arch, files, transcript)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Stop()
+
+	// Timed screenshots are still taken if the scripts finish first
+	defer m.scheduleScreenshots(conf.Screenshots, files.Media)()
+
+	if err := m.Login(conf.User, conf.Password, time.Duration(conf.BootTimeout)*time.Second); err != nil {
+		return nil, err
+	}
+
+	var results []*Result
+	var failed []string
+	for i, script := range scripts {
+		log.WithFields(log.Fields{
+			"script": conf.Scripts[i],
+		}).Info("Running test script")
+		start := time.Now()
+		code, err := m.RunScript(script, time.Duration(conf.ScriptTimeout)*time.Second)
+		if err != nil {
+			return results, fmt.Errorf("Test script %v did not complete: %v", conf.Scripts[i], err)
+		}
+		results = append(results, &Result{
+			Script:   conf.Scripts[i],
+			ExitCode: code,
+			Duration: time.Since(start),
+		})
+		if code != 0 {
+			failed = append(failed, fmt.Sprintf("%v (exit %d)", conf.Scripts[i], code))
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("Test scripts failed: %v", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
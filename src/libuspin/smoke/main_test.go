@@ -0,0 +1,124 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package smoke
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeShell answers the console like a booted image would
+func fakeShell(in io.Reader, out io.WriteCloser) {
+	defer out.Close()
+	io.WriteString(out, "Welcome to Solus\nsolus login: ")
+	sc := bufio.NewScanner(in)
+	var script []string
+	inScript := false
+	for sc.Scan() {
+		line := sc.Text()
+		// Echo everything typed, as a terminal would
+		io.WriteString(out, line+"\n")
+		switch {
+		case line == "root":
+			io.WriteString(out, "# ")
+		case strings.HasPrefix(line, "echo USPIN-"):
+			io.WriteString(out, "USPIN-READY\n# ")
+		case strings.HasPrefix(line, "base64 -d"):
+			inScript = true
+			script = nil
+		case line == "USPIN_EOF":
+			inScript = false
+		case inScript:
+			script = append(script, line)
+		case strings.HasPrefix(line, "sh "):
+			data, _ := base64.StdEncoding.DecodeString(strings.Join(script, ""))
+			if strings.Contains(string(data), "exit 3") {
+				io.WriteString(out, "USPIN-RESULT:3\n# ")
+			} else {
+				io.WriteString(out, "USPIN-RESULT:0\n# ")
+			}
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	inRead, inWrite := io.Pipe()
+	outRead, outWrite := io.Pipe()
+	go fakeShell(inRead, outWrite)
+	m := &Machine{Console: NewConsole(inWrite, outRead, nil)}
+
+	if err := m.Login("root", "", time.Second); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	// Long enough to be split across several lines
+	script := []byte("#!/bin/sh\n" + strings.Repeat("# padding\n", 20) + "test -d /usr\n")
+	if code, err := m.RunScript(script, time.Second); err != nil || code != 0 {
+		t.Fatalf("Script should pass: %v %v", code, err)
+	}
+	if code, err := m.RunScript([]byte("exit 3\n"), time.Second); err != nil || code != 3 {
+		t.Fatalf("Script should fail with 3: %v %v", code, err)
+	}
+
+	inWrite.Close()
+	if _, err := m.Console.Expect(loginPrompt, time.Second); err != ErrConsoleClosed {
+		t.Fatalf("Closed console not reported: %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	outRead, outWrite := io.Pipe()
+	c := NewConsole(nil, outRead, nil)
+	seen := make(chan struct{})
+	c.Watch(regexp.MustCompile("Started GNOME Display Manager"), func() {
+		close(seen)
+	})
+	// Split across writes, and never consumed by Expect
+	io.WriteString(outWrite, "[  OK  ] Started GNOME Dis")
+	io.WriteString(outWrite, "play Manager.\nsolus login: ")
+	select {
+	case <-seen:
+	case <-time.After(time.Second):
+		t.Fatalf("Marker was not seen")
+	}
+	if _, err := c.Expect(regexp.MustCompile("Display Manager"), time.Second); err != nil {
+		t.Fatalf("Watched output should remain for Expect: %v", err)
+	}
+	outWrite.Close()
+}
+
+func TestReadPPM(t *testing.T) {
+	ppm := append([]byte("P6\n2 1\n255\n"), 0xFF, 0x00, 0x00, 0x00, 0x00, 0xFF)
+	img, err := readPPM(bytes.NewReader(ppm))
+	if err != nil {
+		t.Fatalf("Failed to read PPM: %v", err)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r != 0xFFFF {
+		t.Fatalf("Incorrect first pixel: %v", img.At(0, 0))
+	}
+	if _, _, b, _ := img.At(1, 0).RGBA(); b != 0xFFFF {
+		t.Fatalf("Incorrect second pixel: %v", img.At(1, 0))
+	}
+	if _, err := readPPM(bytes.NewReader([]byte("P3\n1 1\n255\n0 0 0\n"))); err == nil {
+		t.Fatalf("ASCII PPM should be rejected")
+	}
+}
@@ -0,0 +1,100 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package smoke
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// A QMP is a connection to the QEMU Machine Protocol socket
+type QMP struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+// qmpResponse is any message from QEMU. Events are skipped.
+type qmpResponse struct {
+	Event  string          `json:"event"`
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// DialQMP will connect to the socket, waiting for QEMU to create it
+func DialQMP(path string, timeout time.Duration) (*QMP, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			q := &QMP{conn: conn, dec: json.NewDecoder(bufio.NewReader(conn))}
+			// Greeting, then leave negotiation mode
+			var greeting map[string]interface{}
+			if err := q.dec.Decode(&greeting); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			if err := q.Execute("qmp_capabilities", nil); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return q, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Cannot connect to QMP: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Execute will run the QMP command, waiting for it to complete
+func (q *QMP) Execute(command string, arguments interface{}) error {
+	req := map[string]interface{}{"execute": command}
+	if arguments != nil {
+		req["arguments"] = arguments
+	}
+	if err := json.NewEncoder(q.conn).Encode(req); err != nil {
+		return err
+	}
+	for {
+		var resp qmpResponse
+		if err := q.dec.Decode(&resp); err != nil {
+			return err
+		}
+		if resp.Event != "" {
+			continue
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("QMP %v failed: %v: %v", command, resp.Error.Class, resp.Error.Desc)
+		}
+		if resp.Return == nil {
+			return errors.New("Unexpected QMP response")
+		}
+		return nil
+	}
+}
+
+// Close will close the connection
+func (q *QMP) Close() error {
+	return q.conn.Close()
+}
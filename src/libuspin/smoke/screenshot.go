@@ -0,0 +1,103 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package smoke
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ScreenshotSuffix is inserted between the image name and screenshot name
+const ScreenshotSuffix = ".screenshot-"
+
+// ScreenshotPath returns the file for the named screenshot of the image
+func ScreenshotPath(image, name string) string {
+	return image + ScreenshotSuffix + name + ".png"
+}
+
+// readPPM will decode the binary (P6) PPM written by QEMU's screendump
+func readPPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	var magic string
+	var width, height, max int
+	if _, err := fmt.Fscan(br, &magic, &width, &height, &max); err != nil {
+		return nil, err
+	}
+	if magic != "P6" || max != 255 || width <= 0 || height <= 0 {
+		return nil, errors.New("Unsupported PPM screenshot")
+	}
+	// Exactly one whitespace byte separates the header from the pixels
+	if _, err := br.ReadByte(); err != nil {
+		return nil, err
+	}
+	pixels := make([]byte, width*height*3)
+	if _, err := io.ReadFull(br, pixels); err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		img.SetNRGBA(i%width, i/width, color.NRGBA{pixels[i*3], pixels[i*3+1], pixels[i*3+2], 0xFF})
+	}
+	return img, nil
+}
+
+// Screenshot will capture the framebuffer of the machine as a PNG
+func (m *Machine) Screenshot(path string) error {
+	if m.qmp == nil {
+		return errors.New("Machine has no QMP connection")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".screendump")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	m.qmpLock.Lock()
+	err = m.qmp.Execute("screendump", map[string]string{"filename": tmp.Name()})
+	m.qmpLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	img, err := readPPM(fi)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -37,18 +37,61 @@
 //      @system.base
 // The component named "system.base" would be installed.
 //
+// Components are arranged in a hierarchy by their names. Ending the name with
+// ".*" installs the component along with all of its children, and children
+// may be left out, along with their own children, by listing them with '!'.
+//      @desktop.* !desktop.kde !desktop.gnome
+//
 // Package lines
 //
 // Any non blank line neither qualifying as a repo or group line is interpreted
 // as a package installation.
 //
+// Removal lines
+//
+// A line beginning with the removal character '-' is interpreted as a request
+// to remove the named package once everything before it has been installed,
+// such as documentation pulled in as a dependency. Groups cannot be removed.
+//      -nano-docs
+//
+// Conditional blocks
+//
+// A line of the form '[key=value]' begins a block of lines which only apply
+// when the condition matches, allowing one file to serve several spins. The
+// keys "arch" and "profile" are supported, a key may list several values
+// separated by commas, and all keys within the brackets must match.
+//      [arch=aarch64]
+//      linux-firmware-arm
+//      [arch=x86_64 profile=desktop,minimal]
+//      intel-microcode
+//      [all]
+// The block lasts until the next header, and '[all]' returns to lines that
+// always apply.
+//
+// Includes
+//
+// A line of the form '%include file' merges the named packages file into the
+// stack at that point, as though its lines were written in place. Relative
+// paths are resolved against the directory of the including file, so common
+// sets of packages can be shared between spins.
+//      %include ../common/base.packages
+// Including a file which is already being included is reported as a cycle.
+//
+// Variables
+//
+// References of the form '${NAME}' are replaced with the value of the variable
+// before a line is interpreted, using the variables of the .spin file. A
+// literal '${' is written as '$${', and undefined variables are an error.
+//      linux-${KERNEL_FLAVOUR}
+//
 // Control Characters
 //
 // An additional character, '~', may be used by implementations to control the
 // 'IgnoreSafety' parameter of Package & Group install lines. Depending on the
 // implementation, this will bypass dependency safety checks in order to break
 // a cyclical dependency to inject a group or package before other dependencies
-// are met, such as for baselayout style packages.
+// are met, such as for baselayout style packages. On removal lines it leaves
+// any packages depending on the removed package installed.
 //
 // This control character must be the first character in the sequence.
 package spec
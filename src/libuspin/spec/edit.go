@@ -0,0 +1,64 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// SetRepo will change the URI of the named repository within the packages
+// file at path, leaving every other line untouched.
+func SetRepo(path, name, uri string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	p := NewParser()
+	lines := strings.Split(string(data), "\n")
+	found := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, p.CommentCharacter) || strings.HasPrefix(trimmed, "[") {
+			continue
+		}
+		eq := strings.Index(line, p.RepoSplitCharacter)
+		if eq < 0 || strings.TrimSpace(line[:eq]) != name {
+			continue
+		}
+		lines[i] = strings.TrimRight(line[:eq+len(p.RepoSplitCharacter)], " ") + " " + uri
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("No such repository '%v' in %v", name, path)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "\n")), st.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
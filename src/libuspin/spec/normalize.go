@@ -0,0 +1,54 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// utf8BOM is prepended to files by many Windows editors
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Normalize will strip any UTF-8 byte order mark, convert CRLF line endings
+// and remove trailing whitespace. A description of each fix is returned so
+// that the caller can warn about it, as these are usually the result of
+// editing profiles on Windows machines.
+func Normalize(data []byte) ([]byte, []string) {
+	var fixes []string
+	if bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+		fixes = append(fixes, "Removed UTF-8 byte order mark")
+	}
+	if n := bytes.Count(data, []byte("\r\n")); n > 0 {
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		fixes = append(fixes, fmt.Sprintf("Converted %d CRLF line ending(s)", n))
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	trimmed := 0
+	for i, line := range lines {
+		if stripped := bytes.TrimRight(line, " \t\r"); len(stripped) != len(line) {
+			lines[i] = stripped
+			trimmed++
+		}
+	}
+	if trimmed > 0 {
+		data = bytes.Join(lines, []byte("\n"))
+		fixes = append(fixes, fmt.Sprintf("Removed trailing whitespace from %d line(s)", trimmed))
+	}
+	return data, fixes
+}
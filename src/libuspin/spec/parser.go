@@ -18,8 +18,12 @@ package spec
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -30,10 +34,21 @@ type Parser struct {
 	RepoSplitCharacter string // Character to denote a repo definition. Defaults to '='
 	SafetyCharacter    string // Character to indicate ignoreSafety. Defaults to '~'
 	GroupCharacter     string // Character to indicate a group or component. Defaults to '@'
+	RemoveCharacter    string // Character to indicate a package removal. Defaults to '-'
+	IncludeDirective   string // Directive merging another file into the stack. Defaults to '%include'
+
+	Arch    string // Active architecture for [arch=...] blocks
+	Profile string // Active profile for [profile=...] blocks
+
+	Variables Lookup // Resolves ${NAME} references, which are left alone if nil
 
 	Stack *OpStack // The parsed stack so far
 
-	curSet *OpSet
+	Files []string // Absolute path of every file parsed, once each, in the order they were opened
+
+	curSet   *OpSet
+	repos    map[string]string // Position declaring each repo
+	problems []*ParseError
 }
 
 // NewParser will return a new parser for the image specification file
@@ -43,6 +58,8 @@ func NewParser() *Parser {
 		RepoSplitCharacter: "=",
 		SafetyCharacter:    "~",
 		GroupCharacter:     "@",
+		RemoveCharacter:    "-",
+		IncludeDirective:   "%include",
 		Stack:              &OpStack{},
 	}
 }
@@ -66,17 +83,173 @@ insertOp:
 	i.curSet.Ops = append(i.curSet.Ops, op)
 }
 
+// condition will determine whether a conditional block applies to the active
+// architecture and profile. Every "key=value" must match, and each may list
+// several values separated by commas, i.e. "arch=x86_64,aarch64". The
+// conditions "all" and "" apply everywhere, ending any previous block.
+func (i *Parser) condition(expr string) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" || expr == "all" {
+		return true, nil
+	}
+	ret := true
+	for _, field := range strings.Fields(expr) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return false, fmt.Errorf("Invalid condition '%v'", field)
+		}
+		var active string
+		switch kv[0] {
+		case "arch":
+			active = i.Arch
+		case "profile":
+			active = i.Profile
+		default:
+			return false, fmt.Errorf("Unknown condition '%v', expected arch or profile", kv[0])
+		}
+		matched := false
+		for _, value := range strings.Split(kv[1], ",") {
+			if value == active {
+				matched = true
+			}
+		}
+		ret = ret && matched
+	}
+	return ret, nil
+}
+
+// parseGroup will parse a group line without the group character, i.e.
+// "desktop.* !desktop.kde", where ".*" includes all child components and
+// "!" excludes one of them.
+func (i *Parser) parseGroup(line string) (*OpGroup, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Missing group name")
+	}
+	op := &OpGroup{GroupName: fields[0]}
+	if strings.HasSuffix(op.GroupName, ".*") {
+		op.GroupName = strings.TrimSuffix(op.GroupName, ".*")
+		op.Children = true
+	}
+	for _, field := range fields[1:] {
+		ex := strings.TrimPrefix(field, "!")
+		if !op.Children || ex == field {
+			return nil, fmt.Errorf("Invalid group '%v', exclusions need a group ending in .*", line)
+		}
+		if !strings.HasPrefix(ex, op.GroupName+".") {
+			return nil, fmt.Errorf("Cannot exclude '%v', it is not a child of '%v'", ex, op.GroupName)
+		}
+		op.Exclude = append(op.Exclude, ex)
+	}
+	return op, nil
+}
+
+// A ParseError is a malformed line within a packages file
+type ParseError struct {
+	File string // The including file, or one it includes
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v on line '%v' of %v", e.Err, e.Line, e.File)
+}
+
 // Parse will attempt to parse the given image speicifcation file at the given
 // path, and will return an error if this fails.
 func (i *Parser) Parse(path string) error {
-	fi, err := os.Open(path)
+	problems, err := i.ParseAll(path)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// ParseAll will parse the file like Parse, but carry on past malformed lines
+// so that every problem is reported. An error is only returned if the file
+// cannot be read.
+func (i *Parser) ParseAll(path string) ([]*ParseError, error) {
+	i.repos = make(map[string]string)
+	i.problems = nil
+	i.Files = nil
+	if err := i.parseFile(path, nil); err != nil {
+		return nil, err
+	}
+	// Every line may have been gated out, or the file empty
+	if i.curSet != nil {
+		i.Stack.Blocks = append(i.Stack.Blocks, i.curSet)
+	}
+	i.curSet = nil
+	return i.problems, nil
+}
+
+// include will parse the file named by an include directive, relative to the
+// including file, into the current stack
+func (i *Parser) include(from, name string, included []string) error {
+	if name == "" {
+		return fmt.Errorf("Missing file for %v", i.IncludeDirective)
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), name)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for j, prev := range included {
+		if prev == abs {
+			return fmt.Errorf("Include cycle: %v -> %v", strings.Join(included[j:], " -> "), abs)
+		}
+	}
+	if err := i.parseFile(path, included); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Cannot include '%v', no such file: %v", name, path)
+		}
+		return err
+	}
+	return nil
+}
+
+// addFile will record that the file has been parsed
+func (i *Parser) addFile(abs string) {
+	for _, path := range i.Files {
+		if path == abs {
+			return
+		}
+	}
+	i.Files = append(i.Files, abs)
+}
+
+// parseFile will parse a single file into the current stack. included lists
+// the absolute paths of the files including this one, outermost first.
+func (i *Parser) parseFile(path string, included []string) error {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer fi.Close()
-	sc := bufio.NewScanner(fi)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	included = append(included[:len(included):len(included)], abs)
+	i.addFile(abs)
+	data, fixes := Normalize(data)
+	for _, fix := range fixes {
+		log.WithFields(log.Fields{
+			"file": path,
+		}).Warning(fix)
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
 
 	lineno := 0
+	active := true
+	report := func(err error) {
+		i.problems = append(i.problems, &ParseError{File: path, Line: lineno, Err: err})
+	}
 
 	for sc.Scan() {
 		line := strings.TrimSpace(sc.Text())
@@ -95,17 +268,55 @@ func (i *Parser) Parse(path string) error {
 			continue
 		}
 
+		// Conditional block headers gate everything until the next header
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if active, err = i.condition(line[1 : len(line)-1]); err != nil {
+				report(err)
+			}
+			continue
+		}
+		if !active {
+			continue
+		}
+
+		if i.Variables != nil {
+			if line, err = Expand(line, i.Variables); err != nil {
+				report(err)
+				continue
+			}
+			// A variable may expand to nothing at all
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+		}
+
+		// Merge another file into the stack at this point
+		if fields := strings.Fields(line); fields[0] == i.IncludeDirective {
+			if len(fields) > 2 {
+				report(fmt.Errorf("Too many files for %v: %v", i.IncludeDirective, line))
+			} else if err := i.include(path, strings.TrimSpace(strings.TrimPrefix(line, i.IncludeDirective)), included); err != nil {
+				report(err)
+			}
+			continue
+		}
+
 		// Check if this is a repo
 		if strings.Contains(line, i.RepoSplitCharacter) {
 			fields := strings.Split(line, "=")
 			value := strings.TrimSpace(strings.Join(fields[1:], "="))
 			if value == "" {
-				return fmt.Errorf("Missing value for repo declaration '%v' on line '%v'\n", fields[0], lineno)
+				report(fmt.Errorf("Missing value for repo declaration '%v'", fields[0]))
+				continue
 			}
 			op := &OpRepo{
 				RepoName: strings.TrimSpace(fields[0]),
 				RepoURI:  value,
 			}
+			if prev, ok := i.repos[op.RepoName]; ok {
+				report(fmt.Errorf("Repository '%v' was already declared on %v", op.RepoName, prev))
+				continue
+			}
+			i.repos[op.RepoName] = fmt.Sprintf("line '%v' of %v", lineno, path)
 			i.pushOperation(op)
 			continue
 		}
@@ -116,6 +327,20 @@ func (i *Parser) Parse(path string) error {
 			line = line[len(i.SafetyCharacter):]
 		}
 
+		// Check if this is a removal, only packages can be removed
+		if strings.HasPrefix(line, i.RemoveCharacter) {
+			name := strings.TrimSpace(line[len(i.RemoveCharacter):])
+			if name == "" || strings.HasPrefix(name, i.GroupCharacter) {
+				report(fmt.Errorf("Invalid package removal '%v'", line))
+				continue
+			}
+			i.pushOperation(&OpRemove{
+				Name:         name,
+				IgnoreSafety: ignoreSafety,
+			})
+			continue
+		}
+
 		// Check if its a group or not
 		if strings.HasPrefix(line, i.GroupCharacter) {
 			isGroup = true
@@ -126,10 +351,16 @@ func (i *Parser) Parse(path string) error {
 
 		// Add the operation to the stack
 		if isGroup {
-			op = &OpGroup{
-				GroupName:    line,
-				IgnoreSafety: ignoreSafety,
+			group, err := i.parseGroup(line)
+			if err != nil {
+				report(err)
+				continue
 			}
+			group.IgnoreSafety = ignoreSafety
+			op = group
+		} else if line == "" {
+			report(fmt.Errorf("Missing package name"))
+			continue
 		} else {
 			op = &OpPackage{
 				Name:         line,
@@ -138,9 +369,5 @@ func (i *Parser) Parse(path string) error {
 		}
 		i.pushOperation(op)
 	}
-
-	i.Stack.Blocks = append(i.Stack.Blocks, i.curSet)
-	i.curSet = nil
-
 	return nil
 }
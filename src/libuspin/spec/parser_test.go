@@ -17,6 +17,10 @@
 package spec
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +39,313 @@ func TestParseMinimalImage(t *testing.T) {
 		t.Fatalf("Incorrect number of blocks for config: %v\n", len(p.Stack.Blocks))
 	}
 }
+
+func TestParseWindowsFile(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("\xEF\xBB\xBFSolus = https://example.com/eopkg-index.xml.xz \r\n@system.base\r\nnano\t\r\n")
+	fi.Close()
+
+	p := NewParser()
+	if err := p.Parse(fi.Name()); err != nil {
+		t.Fatalf("Failed to parse Windows file: %v", err)
+	}
+	repo := p.Stack.Blocks[0].Ops[0].(*OpRepo)
+	if repo.RepoName != "Solus" || repo.RepoURI != "https://example.com/eopkg-index.xml.xz" {
+		t.Fatalf("Incorrect repo: %v = %v", repo.RepoName, repo.RepoURI)
+	}
+	if pkg := p.Stack.Blocks[2].Ops[0].(*OpPackage); pkg.Name != "nano" {
+		t.Fatalf("Incorrect package: %q", pkg.Name)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	data, fixes := Normalize([]byte("\xEF\xBB\xBF[image]  \r\ntype = \"liveos\"\r\n"))
+	if string(data) != "[image]\ntype = \"liveos\"\n" {
+		t.Fatalf("Incorrect normalization: %q", data)
+	}
+	if len(fixes) != 3 {
+		t.Fatalf("Expected 3 fixes, got: %v", fixes)
+	}
+	if _, fixes := Normalize([]byte("nano\n")); len(fixes) != 0 {
+		t.Fatalf("Clean files should not need fixes: %v", fixes)
+	}
+}
+
+func TestSetRepo(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("# Main repository\nSolus = https://old.example.com/eopkg-index.xml.xz\n\n@system.base\nnano\n")
+	fi.Close()
+
+	if err := SetRepo(fi.Name(), "Solus", "https://new.example.com/eopkg-index.xml.xz"); err != nil {
+		t.Fatalf("Failed to set repo: %v", err)
+	}
+	data, _ := ioutil.ReadFile(fi.Name())
+	if string(data) != "# Main repository\nSolus = https://new.example.com/eopkg-index.xml.xz\n\n@system.base\nnano\n" {
+		t.Fatalf("Incorrect packages file: %q", data)
+	}
+	if err := SetRepo(fi.Name(), "Unstable", "https://example.com"); err == nil {
+		t.Fatalf("Set a repo that doesn't exist")
+	}
+}
+
+func TestParseRemove(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("@system.base\n-nano-docs\n~-glibc-devel\n")
+	fi.Close()
+
+	p := NewParser()
+	if err := p.Parse(fi.Name()); err != nil {
+		t.Fatalf("Failed to parse removals: %v", err)
+	}
+	if len(p.Stack.Blocks) != 3 {
+		t.Fatalf("Incorrect number of blocks: %v", len(p.Stack.Blocks))
+	}
+	if op := p.Stack.Blocks[1].Ops[0].(*OpRemove); op.Name != "nano-docs" || op.IgnoreSafety {
+		t.Fatalf("Incorrect removal: %+v", op)
+	}
+	if op := p.Stack.Blocks[2].Ops[0].(*OpRemove); op.Name != "glibc-devel" || !op.IgnoreSafety {
+		t.Fatalf("Incorrect unsafe removal: %+v", op)
+	}
+
+	fi, err = ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("-@system.devel\n")
+	fi.Close()
+	if err := NewParser().Parse(fi.Name()); err == nil {
+		t.Fatalf("Groups should not be removable")
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("bash\n[arch=aarch64]\nlinux-firmware-arm\n[arch=x86_64 profile=desktop,minimal]\nintel-microcode\n[profile=desktop]\nfirefox\n[all]\nnano\n")
+	fi.Close()
+
+	p := NewParser()
+	p.Arch = "x86_64"
+	p.Profile = "minimal"
+	if err := p.Parse(fi.Name()); err != nil {
+		t.Fatalf("Failed to parse conditions: %v", err)
+	}
+	var names []string
+	for _, op := range p.Stack.Blocks[0].Ops {
+		names = append(names, op.(*OpPackage).Name)
+	}
+	if strings.Join(names, " ") != "bash intel-microcode nano" {
+		t.Fatalf("Incorrect packages for x86_64 minimal: %v", names)
+	}
+
+	// Nothing applies to this arch, which is not a nil block
+	p = NewParser()
+	p.Arch = "riscv64"
+	p.Profile = "minimal"
+	fi, err = ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("[arch=x86_64]\nintel-microcode\n[arch=aarch64]\nlinux-firmware-arm\n")
+	fi.Close()
+	if err := p.Parse(fi.Name()); err != nil {
+		t.Fatalf("Failed to parse conditions: %v", err)
+	}
+	if len(p.Stack.Blocks) != 0 {
+		t.Fatalf("Gated out file should have no blocks: %v", p.Stack.Blocks)
+	}
+
+	fi, err = ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("[abi=x32]\nbash\n")
+	fi.Close()
+	if err := NewParser().Parse(fi.Name()); err == nil {
+		t.Fatalf("Unknown conditions should fail")
+	}
+}
+
+func TestParseComponents(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("@desktop.* !desktop.kde\n@system.base\n")
+	fi.Close()
+
+	p := NewParser()
+	if err := p.Parse(fi.Name()); err != nil {
+		t.Fatalf("Failed to parse components: %v", err)
+	}
+	group := p.Stack.Blocks[0].Ops[0].(*OpGroup)
+	if group.GroupName != "desktop" || !group.Children || group.String() != "desktop.* !desktop.kde" {
+		t.Fatalf("Incorrect group: %+v", group)
+	}
+	if _, err := group.Names(); err == nil {
+		t.Fatalf("Unresolved groups should not have names")
+	}
+	available := []string{"desktop", "desktop.budgie", "desktop.kde", "desktop.kde.core", "desktopia", "system.base"}
+	if err := group.ResolveComponents(available); err != nil {
+		t.Fatalf("Failed to resolve components: %v", err)
+	}
+	if names, _ := group.Names(); strings.Join(names, " ") != "desktop desktop.budgie" {
+		t.Fatalf("Incorrect components: %v", names)
+	}
+	if names, _ := p.Stack.Blocks[0].Ops[1].(*OpGroup).Names(); len(names) != 1 || names[0] != "system.base" {
+		t.Fatalf("Plain groups should name themselves: %v", names)
+	}
+
+	if _, err := p.parseGroup("desktop.* !system.base"); err == nil {
+		t.Fatalf("Only children should be excludable")
+	}
+	if _, err := p.parseGroup("desktop !desktop.kde"); err == nil {
+		t.Fatalf("Exclusions should need children")
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("Solus = https://example.com/a.xml\nSolus = https://example.com/b.xml\n-\n@system.base !foo\n@\n~@\n~\nnano\n")
+	fi.Close()
+
+	p := NewParser()
+	problems, err := p.ParseAll(fi.Name())
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if len(problems) != 6 {
+		t.Fatalf("Incorrect number of problems: %v", problems)
+	}
+	for i, line := range []int{2, 3, 4, 5, 6, 7} {
+		if problems[i].Line != line {
+			t.Fatalf("Problem reported on line %v instead of %v: %v", problems[i].Line, line, problems[i])
+		}
+	}
+	if err := NewParser().Parse(fi.Name()); err == nil || err.Error() != problems[0].Error() {
+		t.Fatalf("Parse should fail with the first problem: %v", err)
+	}
+	if pkg := p.Stack.Blocks[1].Ops[0].(*OpPackage); pkg.Name != "nano" {
+		t.Fatalf("Parsing should continue past problems: %v", pkg.Name)
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-include")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "common"), 00755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	files := map[string]string{
+		"main.packages":         "%include common/base.packages\nnano\n",
+		"common/base.packages":  "@system.base\n%include ../missing.packages\n%include extra.packages\n",
+		"common/extra.packages": "vim\n%include ../main.packages\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	p := NewParser()
+	problems, err := p.ParseAll(filepath.Join(dir, "main.packages"))
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Incorrect number of problems: %v", problems)
+	}
+	if !strings.Contains(problems[0].Error(), "Cannot include '../missing.packages'") || problems[0].Line != 2 {
+		t.Fatalf("Missing include not reported: %v", problems[0])
+	}
+	if !strings.Contains(problems[1].Error(), "Include cycle") || filepath.Base(problems[1].File) != "extra.packages" {
+		t.Fatalf("Include cycle not reported: %v", problems[1])
+	}
+
+	var names []string
+	for _, block := range p.Stack.Blocks {
+		for _, op := range block.Ops {
+			switch o := op.(type) {
+			case *OpGroup:
+				names = append(names, "@"+o.GroupName)
+			case *OpPackage:
+				names = append(names, o.Name)
+			}
+		}
+	}
+	if strings.Join(names, " ") != "@system.base vim nano" {
+		t.Fatalf("Included operations in the wrong order: %v", names)
+	}
+
+	var parsed []string
+	for _, path := range p.Files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatalf("Parsed file outside of the directory: %v", path)
+		}
+		parsed = append(parsed, rel)
+	}
+	if strings.Join(parsed, " ") != "main.packages common/base.packages common/extra.packages" {
+		t.Fatalf("Wrong files parsed: %v", parsed)
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	fi, err := ioutil.TempFile("", "uspin-packages")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(fi.Name())
+	fi.WriteString("linux-${FLAVOUR}\n${MISSING}\n${EMPTY}\n ${EMPTY} \n")
+	fi.Close()
+
+	p := NewParser()
+	p.Variables = func(name string) (string, bool) {
+		switch name {
+		case "FLAVOUR":
+			return "lts", true
+		case "EMPTY":
+			return "", true
+		}
+		return "", false
+	}
+	problems, err := p.ParseAll(fi.Name())
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Line != 2 {
+		t.Fatalf("Undefined variable not reported: %v", problems)
+	}
+	if pkg := p.Stack.Blocks[0].Ops[0].(*OpPackage); pkg.Name != "linux-lts" {
+		t.Fatalf("Variable was not expanded: %v", pkg.Name)
+	}
+	if len(p.Stack.Blocks) != 1 || len(p.Stack.Blocks[0].Ops) != 1 {
+		t.Fatalf("Empty lines should be skipped: %v", p.Stack.Blocks[0].Ops)
+	}
+}
@@ -17,7 +17,10 @@
 package spec
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 )
 
 // OpStack contains all top level blocks
@@ -52,6 +55,69 @@ type OpGroup struct {
 	Operation
 	GroupName    string // Name of this group or component
 	IgnoreSafety bool   // Whether to bypass dependency safety checks
+
+	Children   bool     // Whether child components are included, i.e. "@desktop.*"
+	Exclude    []string // Child components left out, along with their own children
+	Components []string // Concrete components, set by ResolveComponents
+}
+
+// String returns the group as written in the Packages file
+func (o *OpGroup) String() string {
+	if !o.Children {
+		return o.GroupName
+	}
+	ret := o.GroupName + ".*"
+	for _, ex := range o.Exclude {
+		ret += " !" + ex
+	}
+	return ret
+}
+
+// inComponent determines if the component is name or one of its children
+func inComponent(component, name string) bool {
+	return component == name || strings.HasPrefix(component, name+".")
+}
+
+// ResolveComponents will select the group and its children from the available
+// components, leaving out any exclusions.
+func (o *OpGroup) ResolveComponents(available []string) error {
+	if !o.Children {
+		o.Components = []string{o.GroupName}
+		return nil
+	}
+	var ret []string
+	for _, component := range available {
+		if !inComponent(component, o.GroupName) {
+			continue
+		}
+		excluded := false
+		for _, ex := range o.Exclude {
+			if inComponent(component, ex) {
+				excluded = true
+			}
+		}
+		if !excluded {
+			ret = append(ret, component)
+		}
+	}
+	if len(ret) == 0 {
+		return fmt.Errorf("No components match '%v'", o)
+	}
+	sort.Strings(ret)
+	o.Components = ret
+	return nil
+}
+
+// Names returns the components to install. Groups with children must have
+// been resolved first.
+func (o *OpGroup) Names() ([]string, error) {
+	if !o.Children {
+		return []string{o.GroupName}, nil
+	}
+	if o.Components == nil {
+		return nil, fmt.Errorf("Components of '%v' have not been resolved", o)
+	}
+	return o.Components, nil
 }
 
 // Compatible determines if two OpGroup's are compatible with one another
@@ -82,3 +148,22 @@ func (o *OpPackage) Compatible(o2 Operation) bool {
 	}
 	return true
 }
+
+// An OpRemove is an operation to remove a package after installation, i.e.
+// documentation pulled in as a dependency
+type OpRemove struct {
+	Operation
+	Name         string // Name of the package to remove
+	IgnoreSafety bool   // Whether to leave packages depending on it installed
+}
+
+// Compatible determines if two OpRemove's are compatible with one another
+func (o *OpRemove) Compatible(o2 Operation) bool {
+	if reflect.TypeOf(o) != reflect.TypeOf(o2) {
+		return false
+	}
+	if o2.(*OpRemove).IgnoreSafety != o.IgnoreSafety {
+		return false
+	}
+	return true
+}
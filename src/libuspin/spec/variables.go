@@ -0,0 +1,79 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Lookup resolves the value of a variable, and whether it is defined
+type Lookup func(name string) (string, bool)
+
+// Expand will replace every "${NAME}" reference within s with the value of
+// the variable. "$${" is an escape for a literal "${", and any other '$' is
+// left as it is. Referencing an undefined variable is an error.
+func Expand(s string, lookup Lookup) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("Unterminated variable reference: %v", s[i:])
+		}
+		name := s[i+2 : i+end]
+		if !IsVariableName(name) {
+			return "", fmt.Errorf("Invalid variable name: '%v'", name)
+		}
+		value, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("Undefined variable: '%v'", name)
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// IsVariableName determines if name is a valid variable name, i.e. "VERSION"
+func IsVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package toolcache provides a private directory of statically linked helper
+// tools, such as mksquashfs and xorriso, which take priority over those of
+// the build host. The output of a build then no longer depends upon whatever
+// version of each tool the distribution of the host happens to ship.
+//
+// Tools are either shipped within the directory alongside the profile, or
+// downloaded into it and verified against their SHA256.
+package toolcache
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Tool is a helper binary fetched into the cache
+type Tool struct {
+	Name   string // Name of the binary, i.e. "mksquashfs"
+	URL    string
+	SHA256 string
+}
+
+// Validate will ensure the tool cannot escape the cache
+func (t *Tool) Validate() error {
+	if t.Name == "" || t.Name != filepath.Base(t.Name) || strings.HasPrefix(t.Name, ".") {
+		return fmt.Errorf("Invalid tool name: %q", t.Name)
+	}
+	if t.URL == "" {
+		return fmt.Errorf("No URL for tool %v", t.Name)
+	}
+	if _, err := hex.DecodeString(t.SHA256); err != nil || len(t.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("Invalid SHA256 for tool %v: %q", t.Name, t.SHA256)
+	}
+	return nil
+}
+
+// A Cache is a directory of helper tools
+type Cache struct {
+	dir string
+}
+
+// Open will open the cache at dir, creating it if needed
+func Open(dir string) (*Cache, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Dir returns the absolute path of the cache
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Has returns true if the tool is already cached with the expected digest
+func (c *Cache) Has(t *Tool) bool {
+	sum, err := digest(filepath.Join(c.dir, t.Name))
+	return err == nil && sum == t.SHA256
+}
+
+// Fetch will download every tool which isn't cached yet, or whose cached
+// copy no longer matches, and return how many were downloaded.
+func (c *Cache) Fetch(tools []*Tool) (int, error) {
+	fetched := 0
+	for _, t := range tools {
+		if err := t.Validate(); err != nil {
+			return fetched, err
+		}
+		if c.Has(t) {
+			continue
+		}
+		if err := c.download(t); err != nil {
+			return fetched, err
+		}
+		fetched++
+	}
+	return fetched, nil
+}
+
+// download will fetch the tool into the cache, which is only put in place
+// once it has been verified
+func (c *Cache) download(t *Tool) error {
+	resp, err := http.Get(t.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch %v: %v", t.URL, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, ".fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != t.SHA256 {
+		return fmt.Errorf("Digest mismatch for tool %v: expected %v, got %v", t.Name, t.SHA256, sum)
+	}
+	if err := os.Chmod(tmp.Name(), 00755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.dir, t.Name))
+}
+
+// Tools returns the sorted names of every tool within the cache, refusing
+// any which isn't a statically linked executable, as it would still depend
+// on the libraries of the host.
+func (c *Cache) Tools() ([]string, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || e.IsDir() {
+			continue
+		}
+		if err := checkStatic(filepath.Join(c.dir, e.Name())); err != nil {
+			return nil, err
+		}
+		ret = append(ret, e.Name())
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Activate will give the tools within the cache priority over those of the
+// host, for this process and every command it runs on the host. Commands run
+// within a chroot have their own PATH, and are unaffected.
+func (c *Cache) Activate() error {
+	path := os.Getenv("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		if dir == c.dir {
+			return nil
+		}
+	}
+	if path == "" {
+		return os.Setenv("PATH", c.dir)
+	}
+	return os.Setenv("PATH", c.dir+string(filepath.ListSeparator)+path)
+}
+
+// checkStatic will return an error unless the file is an ELF executable
+// without a program interpreter, i.e. statically linked
+func checkStatic(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Mode()&0111 == 0 {
+		return fmt.Errorf("Tool is not executable: %v", path)
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return fmt.Errorf("Tool is not an ELF executable: %v", path)
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return fmt.Errorf("Tool is dynamically linked: %v", path)
+		}
+	}
+	return nil
+}
+
+// digest returns the SHA256 of the file
+func digest(path string) (string, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fi.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fi); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,118 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package toolcache
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-toolcache")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const data = "#!/bin/sh\necho mksquashfs\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(data))
+	tool := &Tool{Name: "mksquashfs", URL: srv.URL + "/mksquashfs", SHA256: hex.EncodeToString(sum[:])}
+	c, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	if n, err := c.Fetch([]*Tool{tool}); err != nil || n != 1 {
+		t.Fatalf("Failed to fetch tool: %v %v", n, err)
+	}
+	if n, err := c.Fetch([]*Tool{tool}); err != nil || n != 0 {
+		t.Fatalf("Cached tool should not be fetched again: %v %v", n, err)
+	}
+	if st, err := os.Stat(filepath.Join(dir, "mksquashfs")); err != nil || st.Mode().Perm() != 00755 {
+		t.Fatalf("Tool should be executable: %v", err)
+	}
+
+	bad := &Tool{Name: "xorriso", URL: srv.URL + "/xorriso", SHA256: strings.Repeat("0", 64)}
+	if _, err := c.Fetch([]*Tool{bad}); err == nil || !strings.Contains(err.Error(), "Digest mismatch") {
+		t.Fatalf("Tool with the wrong digest should be refused: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "xorriso")); !os.IsNotExist(err) {
+		t.Fatalf("Refused tool should not be cached")
+	}
+	escape := &Tool{Name: "../mksquashfs", URL: tool.URL, SHA256: tool.SHA256}
+	if _, err := c.Fetch([]*Tool{escape}); err == nil {
+		t.Fatalf("Tool names should not escape the cache")
+	}
+
+	// Scripts would run whatever the host provides
+	if _, err := c.Tools(); err == nil || !strings.Contains(err.Error(), "not an ELF") {
+		t.Fatalf("Scripts should be refused: %v", err)
+	}
+}
+
+func TestDynamicTool(t *testing.T) {
+	f, err := elf.Open("/bin/sh")
+	if err != nil {
+		t.Skip("No ELF /bin/sh on this host")
+	}
+	dynamic := false
+	for _, prog := range f.Progs {
+		dynamic = dynamic || prog.Type == elf.PT_INTERP
+	}
+	f.Close()
+	if !dynamic {
+		t.Skip("/bin/sh is statically linked on this host")
+	}
+	if err := checkStatic("/bin/sh"); err == nil || !strings.Contains(err.Error(), "dynamically linked") {
+		t.Fatalf("Dynamically linked tools should be refused: %v", err)
+	}
+}
+
+func TestActivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-toolcache")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+
+	c, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	os.Setenv("PATH", "/usr/bin:/bin")
+	for i := 0; i < 2; i++ {
+		if err := c.Activate(); err != nil {
+			t.Fatalf("Failed to activate cache: %v", err)
+		}
+	}
+	if path := os.Getenv("PATH"); path != c.Dir()+":/usr/bin:/bin" {
+		t.Fatalf("Wrong PATH: %v", path)
+	}
+}
@@ -0,0 +1,57 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ClampTimes will lower the access and modification times of everything
+// within root, including root itself, to no later than epoch. Symlinks are
+// left alone as their times cannot be set here. The number of paths changed
+// is returned.
+func ClampTimes(root string, epoch time.Time) (int, error) {
+	changed := 0
+	limit := syscall.NsecToTimespec(epoch.UnixNano())
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		atime, mtime := st.Atim, st.Mtim
+		if atime.Nano() <= limit.Nano() && mtime.Nano() <= limit.Nano() {
+			return nil
+		}
+		if atime.Nano() > limit.Nano() {
+			atime = limit
+		}
+		if mtime.Nano() > limit.Nano() {
+			mtime = limit
+		}
+		changed++
+		return syscall.UtimesNano(path, []syscall.Timespec{atime, mtime})
+	})
+	return changed, err
+}
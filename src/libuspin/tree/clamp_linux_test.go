@@ -0,0 +1,71 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package tree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClampTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-tree")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	epoch := time.Unix(1500000000, 0)
+	older := epoch.Add(-time.Hour)
+	if err := os.MkdirAll(filepath.Join(dir, "etc"), 00755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for name, when := range map[string]time.Time{"etc/new": time.Now(), "etc/old": older} {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(name), 00644); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+		if err := os.Chtimes(p, when, when); err != nil {
+			t.Fatalf("Failed to set times of %v: %v", name, err)
+		}
+	}
+	if err := os.Symlink("new", filepath.Join(dir, "etc/link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	changed, err := ClampTimes(dir, epoch)
+	if err != nil {
+		t.Fatalf("Failed to clamp times: %v", err)
+	}
+	// The root, etc and etc/new
+	if changed != 3 {
+		t.Fatalf("Expected 3 paths to change, got %v", changed)
+	}
+	for name, want := range map[string]time.Time{".": epoch, "etc": epoch, "etc/new": epoch, "etc/old": older} {
+		st, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %v: %v", name, err)
+		}
+		if !st.ModTime().Equal(want) {
+			t.Fatalf("Expected %v to be modified at %v, got %v", name, want, st.ModTime())
+		}
+	}
+}
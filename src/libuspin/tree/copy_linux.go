@@ -0,0 +1,208 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package tree
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Seek whence values for sparse files, not exposed by the os package
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// inodeKey uniquely identifies an inode on the host
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+// A copier tracks hardlinks and directories for a single tree copy
+type copier struct {
+	links map[inodeKey]string // Source inode to the first copied path
+	dirs  []string            // Directories to fix up times on
+	srcs  []string
+}
+
+// Copy will copy the tree at src to dst, which must not yet exist
+func Copy(src, dst string) error {
+	c := &copier{links: make(map[inodeKey]string)}
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return c.copyEntry(path, filepath.Join(dst, rel), info)
+	})
+	if err != nil {
+		return err
+	}
+	// Children modify the directory times, so fix them up last
+	for i := len(c.dirs) - 1; i >= 0; i-- {
+		info, err := os.Lstat(c.srcs[i])
+		if err != nil {
+			return err
+		}
+		if err := copyTimes(c.dirs[i], info.Sys().(*syscall.Stat_t)); err != nil {
+			return err
+		}
+	}
+	if os.Getenv(VerifyEnv) != "" {
+		return verified(Verify(src, dst))
+	}
+	return nil
+}
+
+// CopyFile will copy a single file, preserving sparse regions and metadata
+func CopyFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	c := &copier{links: make(map[inodeKey]string)}
+	if err := c.copyEntry(src, dst, info); err != nil {
+		return err
+	}
+	if os.Getenv(VerifyEnv) != "" {
+		return verified(Verify(src, dst))
+	}
+	return nil
+}
+
+// copyEntry will copy a single entry of any type
+func (c *copier) copyEntry(src, dst string, info os.FileInfo) error {
+	st := info.Sys().(*syscall.Stat_t)
+	mode := info.Mode()
+
+	// Recreate hardlinks rather than duplicating the content
+	if !mode.IsDir() && st.Nlink > 1 {
+		key := inodeKey{uint64(st.Dev), uint64(st.Ino)}
+		if first, ok := c.links[key]; ok {
+			return os.Link(first, dst)
+		}
+		c.links[key] = dst
+	}
+
+	switch {
+	case mode.IsDir():
+		if err := os.Mkdir(dst, 00700); err != nil {
+			return err
+		}
+		c.dirs = append(c.dirs, dst)
+		c.srcs = append(c.srcs, src)
+	case mode.IsRegular():
+		if err := copySparse(src, dst, info.Size()); err != nil {
+			return err
+		}
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+		// Symlinks have no meaningful mode, xattrs or settable times here
+		return os.Lchown(dst, int(st.Uid), int(st.Gid))
+	default:
+		// Device nodes, FIFOs and sockets
+		if err := syscall.Mknod(dst, st.Mode, int(st.Rdev)); err != nil {
+			return err
+		}
+	}
+
+	// Ownership first, as chown clears file capabilities
+	if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+	if err := syscall.Chmod(dst, st.Mode&07777); err != nil {
+		return err
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return err
+	}
+	if mode.IsDir() {
+		return nil
+	}
+	return copyTimes(dst, st)
+}
+
+// copyTimes will apply the access & modification times of st to path
+func copyTimes(path string, st *syscall.Stat_t) error {
+	return syscall.UtimesNano(path, []syscall.Timespec{st.Atim, st.Mtim})
+}
+
+// copySparse will copy the data regions of src, leaving holes in dst
+func copySparse(src, dst string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 00600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var offset int64
+	for offset < size {
+		data, err := in.Seek(offset, seekData)
+		if err != nil {
+			if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.ENXIO {
+				// Only a hole remains
+				break
+			}
+			// No SEEK_DATA support, copy everything from here
+			if err := copyRange(in, out, offset, size-offset); err != nil {
+				return err
+			}
+			break
+		}
+		hole, err := in.Seek(data, seekHole)
+		if err != nil {
+			return err
+		}
+		if err := copyRange(in, out, data, hole-data); err != nil {
+			return err
+		}
+		offset = hole
+	}
+	// Extend over any trailing hole
+	return out.Truncate(size)
+}
+
+// copyRange will copy length bytes at offset from in to out
+func copyRange(in, out *os.File, offset, length int64) error {
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(out, in, length)
+	return err
+}
@@ -0,0 +1,100 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package tree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-tree")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.MkdirAll(filepath.Join(src, "usr/bin"), 00755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	// 64MiB sparse file with a single block of data in the middle
+	sparse, err := os.Create(filepath.Join(src, "sparse.img"))
+	if err != nil {
+		t.Fatalf("Failed to create sparse file: %v", err)
+	}
+	sparse.WriteAt([]byte("data"), 32<<20)
+	sparse.Truncate(64 << 20)
+	sparse.Close()
+
+	ping := filepath.Join(src, "usr/bin/ping")
+	if err := ioutil.WriteFile(ping, []byte("#!/bin/sh\n"), 00755); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	os.Chmod(ping, os.ModeSetuid|00755)
+	if err := os.Link(ping, filepath.Join(src, "usr/bin/ping6")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	if err := os.Symlink("ping", filepath.Join(src, "usr/bin/ping4")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	xattrSupported := syscall.Setxattr(ping, "user.uspin", []byte("test"), 0) == nil
+
+	if err := Copy(src, dst); err != nil {
+		t.Fatalf("Failed to copy tree: %v", err)
+	}
+	problems, err := Verify(src, dst)
+	if err != nil {
+		t.Fatalf("Failed to verify tree: %v", err)
+	}
+	if len(problems) > 0 {
+		t.Fatalf("Copy not faithful: %v", problems)
+	}
+
+	// Make sure verification actually notices things
+	os.Chmod(filepath.Join(dst, "usr/bin/ping"), 00755)
+	os.Remove(filepath.Join(dst, "usr/bin/ping6"))
+	ioutil.WriteFile(filepath.Join(dst, "usr/bin/ping6"), []byte("#!/bin/sh\n"), 00755)
+	problems, err = Verify(src, dst)
+	if err != nil {
+		t.Fatalf("Failed to verify tree: %v", err)
+	}
+	want := map[string]bool{
+		"usr/bin/ping: Mode -rwxr-xr-x differs from urwxr-xr-x": false,
+		"usr/bin/ping6: Hardlink not preserved":                 false,
+	}
+	if xattrSupported {
+		want["usr/bin/ping6: Extended attribute user.uspin not preserved"] = false
+	}
+	for _, problem := range problems {
+		if _, ok := want[problem]; ok {
+			want[problem] = true
+		}
+	}
+	for problem, found := range want {
+		if !found {
+			t.Fatalf("Verification missed '%v', got: %v", problem, problems)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !linux
+
+package tree
+
+import (
+	"time"
+)
+
+// Copy is unsupported on this host
+func Copy(src, dst string) error {
+	return ErrUnsupportedHost
+}
+
+// CopyFile is unsupported on this host
+func CopyFile(src, dst string) error {
+	return ErrUnsupportedHost
+}
+
+// Verify is unsupported on this host
+func Verify(src, dst string) ([]string, error) {
+	return nil, ErrUnsupportedHost
+}
+
+// ClampTimes is unsupported on this host
+func ClampTimes(root string, epoch time.Time) (int, error) {
+	return 0, ErrUnsupportedHost
+}
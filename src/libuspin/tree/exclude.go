@@ -0,0 +1,78 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateExclude will ensure the exclusion pattern is usable. Patterns are
+// absolute globs, where a trailing "/**" matches everything beneath a
+// directory while keeping the directory itself.
+func ValidateExclude(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("Exclude patterns must be absolute: %v", pattern)
+	}
+	if _, err := filepath.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+		return fmt.Errorf("Invalid exclude pattern %v: %v", pattern, err)
+	}
+	return nil
+}
+
+// MatchExclude determines if the absolute path is matched by the pattern
+func MatchExclude(pattern, path string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+		ok, _ := filepath.Match(prefix, filepath.Dir(path))
+		return ok
+	}
+	ok, _ := filepath.Match(pattern, path)
+	return ok
+}
+
+// Exclude will remove every path within root matching any of the patterns,
+// returning the removed paths relative to the root of the image.
+func Exclude(root string, patterns []string) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = "/" + rel
+		for _, pattern := range patterns {
+			if !MatchExclude(pattern, rel) {
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			removed = append(removed, rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,66 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExclude(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-tree")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{
+		"var/cache/eopkg/packages/nano.eopkg",
+		"var/cache/ldconfig/aux-cache",
+		"usr/include/stdio.h",
+		"usr/bin/nano",
+		"etc/resolv.conf",
+	} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 00755)
+		ioutil.WriteFile(filepath.Join(root, name), nil, 00644)
+	}
+
+	patterns := []string{"/var/cache/**", "/usr/include", "/etc/resolv.conf"}
+	for _, pattern := range patterns {
+		if err := ValidateExclude(pattern); err != nil {
+			t.Fatalf("Valid pattern rejected: %v", err)
+		}
+	}
+	removed, err := Exclude(root, patterns)
+	if err != nil {
+		t.Fatalf("Failed to exclude paths: %v", err)
+	}
+	if len(removed) != 4 {
+		t.Fatalf("Incorrect paths removed: %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(root, "var/cache")); err != nil {
+		t.Fatalf("Parent of /** pattern should be kept")
+	}
+	if _, err := os.Stat(filepath.Join(root, "usr/bin/nano")); err != nil {
+		t.Fatalf("Unmatched file removed")
+	}
+	if err := ValidateExclude("var/cache"); err == nil {
+		t.Fatalf("Relative pattern should be rejected")
+	}
+}
@@ -0,0 +1,48 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package tree provides copying of filesystem trees that preserves everything
+// an image relies upon: sparse regions, hardlinks, ownership, extended
+// attributes (and so file capabilities and ACLs), device nodes and times.
+//
+// Setting USPIN_VERIFY_COPIES=1 (i.e. in CI) will verify every copy against
+// its source after the fact.
+//
+// Copying is only supported on Linux hosts, where images are built.
+package tree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedHost is returned when copying on hosts other than Linux,
+// which cannot preserve everything an image relies upon
+var ErrUnsupportedHost = errors.New("Copying filesystem trees requires a Linux host")
+
+// VerifyEnv enables verification of every copy when set to a non-empty value
+const VerifyEnv = "USPIN_VERIFY_COPIES"
+
+// verified turns the result of Verify into an error
+func verified(problems []string, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Copy verification failed: %v (and %d more)", problems[0], len(problems)-1)
+	}
+	return nil
+}
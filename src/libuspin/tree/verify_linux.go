@@ -0,0 +1,109 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package tree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Verify will compare the copy at dst against src, returning a description
+// of every difference found.
+func Verify(src, dst string) ([]string, error) {
+	var problems []string
+	links := make(map[inodeKey]inodeKey) // Source inode to copied inode
+	report := func(rel, format string, args ...interface{}) {
+		problems = append(problems, rel+": "+fmt.Sprintf(format, args...))
+	}
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if path == src && !info.IsDir() {
+			// Verifying a single file
+			target = dst
+		}
+		dinfo, err := os.Lstat(target)
+		if err != nil {
+			report(rel, "Missing from copy")
+			return nil
+		}
+		sst := info.Sys().(*syscall.Stat_t)
+		dst := dinfo.Sys().(*syscall.Stat_t)
+
+		if info.Mode() != dinfo.Mode() {
+			report(rel, "Mode %v differs from %v", dinfo.Mode(), info.Mode())
+		}
+		if sst.Uid != dst.Uid || sst.Gid != dst.Gid {
+			report(rel, "Ownership %d:%d differs from %d:%d", dst.Uid, dst.Gid, sst.Uid, sst.Gid)
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			a, _ := os.Readlink(path)
+			b, _ := os.Readlink(target)
+			if a != b {
+				report(rel, "Symlink target %v differs from %v", b, a)
+			}
+			return nil
+		case info.Mode().IsRegular():
+			if info.Size() != dinfo.Size() {
+				report(rel, "Size %d differs from %d", dinfo.Size(), info.Size())
+			}
+			// Allow a little slack for filesystem allocation differences
+			if dst.Blocks > sst.Blocks+8 {
+				report(rel, "Sparse regions lost, %d blocks allocated instead of %d", dst.Blocks, sst.Blocks)
+			}
+			if !info.Mode().IsDir() && sst.Nlink > 1 {
+				skey := inodeKey{uint64(sst.Dev), uint64(sst.Ino)}
+				dkey := inodeKey{uint64(dst.Dev), uint64(dst.Ino)}
+				if first, ok := links[skey]; !ok {
+					links[skey] = dkey
+				} else if first != dkey {
+					report(rel, "Hardlink not preserved")
+				}
+			}
+		}
+
+		sattrs, err := xattrs(path)
+		if err != nil {
+			return err
+		}
+		dattrs, err := xattrs(target)
+		if err != nil {
+			return err
+		}
+		for name, value := range sattrs {
+			if !bytes.Equal(value, dattrs[name]) {
+				report(rel, "Extended attribute %v not preserved", name)
+			}
+		}
+		return nil
+	})
+	return problems, err
+}
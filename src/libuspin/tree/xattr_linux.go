@@ -0,0 +1,89 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package tree
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// listXattrs returns the names of all extended attributes of path
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of the named extended attribute
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(path, name, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+// xattrs returns all extended attributes of path, which includes file
+// capabilities (security.capability) and ACLs (system.posix_acl_*)
+func xattrs(path string) (map[string][]byte, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]byte)
+	for _, name := range names {
+		if ret[name], err = getXattr(path, name); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// copyXattrs will copy all extended attributes from src to dst
+func copyXattrs(src, dst string) error {
+	attrs, err := xattrs(src)
+	if err != nil {
+		return err
+	}
+	for name, value := range attrs {
+		if err := syscall.Setxattr(dst, name, value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build linux
+
+package usb
+
+import (
+	"os"
+	"syscall"
+)
+
+// blkFlushBuf is the BLKFLSBUF ioctl, dropping the device's buffers
+const blkFlushBuf = 0x1261
+
+// flushBuffers will drop the cached contents of the block device
+func flushBuffers(dev *os.File) {
+	syscall.Syscall(syscall.SYS_IOCTL, dev.Fd(), blkFlushBuf, 0)
+}
@@ -0,0 +1,334 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package usb writes finished images to USB devices, with the safety checks
+// that dd never had.
+package usb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxSize is the largest device written without forcing, as anything
+	// larger is far more likely to be the wrong disk than a USB stick
+	MaxSize = 256 * 1024 * 1024 * 1024
+
+	// ChunkSize is the size of each write and verification read
+	ChunkSize = 4 * 1024 * 1024
+)
+
+var (
+	// sysBlock is the sysfs block class, only changed by the tests
+	sysBlock = "/sys/class/block"
+
+	// procMounts lists the mounted filesystems
+	procMounts = "/proc/self/mounts"
+
+	// procSwaps lists the active swap devices
+	procSwaps = "/proc/swaps"
+
+	// ErrVerifyFailed is returned when the device doesn't match the image
+	ErrVerifyFailed = errors.New("Device contents do not match the image")
+)
+
+// A Device is a whole block device which an image may be written to
+type Device struct {
+	Path      string // i.e. /dev/sdb
+	Name      string // i.e. sdb
+	Model     string
+	Size      int64
+	Removable bool
+	Mounted   []string // Uses of the device or its partitions, i.e. mounts
+}
+
+func (d *Device) String() string {
+	model := d.Model
+	if model == "" {
+		model = "Unknown device"
+	}
+	return fmt.Sprintf("%v (%v, %.1f GB)", d.Path, model, float64(d.Size)/1e9)
+}
+
+// readSys returns the trimmed contents of the sysfs attribute
+func readSys(name, attr string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(sysBlock, name, attr))
+	return strings.TrimSpace(string(data)), err
+}
+
+// Inspect will describe the block device at path, which must be a whole disk
+func Inspect(path string) (*Device, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if st.Mode()&os.ModeDevice == 0 || st.Mode()&os.ModeCharDevice != 0 {
+		return nil, fmt.Errorf("Not a block device: %v", path)
+	}
+	return inspect(resolved)
+}
+
+func inspect(path string) (*Device, error) {
+	d := &Device{Path: path, Name: filepath.Base(path)}
+	if _, err := os.Stat(filepath.Join(sysBlock, d.Name)); err != nil {
+		return nil, fmt.Errorf("Unknown block device: %v", path)
+	}
+	if _, err := os.Stat(filepath.Join(sysBlock, d.Name, "partition")); err == nil {
+		return nil, fmt.Errorf("%v is a partition, use the whole device", path)
+	}
+	sectors, err := readSys(d.Name, "size")
+	if err != nil {
+		return nil, err
+	}
+	// Always counted in 512 byte sectors, whatever the device uses
+	n, err := strconv.ParseInt(sectors, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	d.Size = n * 512
+	removable, _ := readSys(d.Name, "removable")
+	d.Removable = removable == "1"
+	d.Model, _ = readSys(d.Name, "device/model")
+	if d.Mounted, err = usedPartitions(d.Name); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// devices returns the names of the device and each of its partitions, as
+// listed beneath it in sysfs
+func devices(name string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(sysBlock, name, "*", "partition"))
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{name}
+	for _, match := range matches {
+		ret = append(ret, filepath.Base(filepath.Dir(match)))
+	}
+	return ret, nil
+}
+
+// deviceName returns the block device name of a mount or swap source, i.e.
+// sdb1 for /dev/disk/by-uuid/..., or "" if it isn't a path
+func deviceName(source string) string {
+	if !filepath.IsAbs(source) {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(source); err == nil {
+		source = resolved
+	}
+	return filepath.Base(source)
+}
+
+// sources returns the first two fields of each line of a /proc table whose
+// first field names a device, skipping header lines which don't
+func sources(table string) ([][2]string, error) {
+	data, err := ioutil.ReadFile(table)
+	if err != nil {
+		return nil, err
+	}
+	var ret [][2]string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 1 && filepath.IsAbs(fields[0]) {
+			ret = append(ret, [2]string{fields[0], fields[1]})
+		}
+	}
+	return ret, sc.Err()
+}
+
+// usedPartitions returns every use of the device, or any of its partitions:
+// mounted filesystems, swap, and holders such as device-mapper or md.
+func usedPartitions(name string) ([]string, error) {
+	names, err := devices(name)
+	if err != nil {
+		return nil, err
+	}
+	ours := make(map[string]bool)
+	for _, n := range names {
+		ours[n] = true
+	}
+
+	var ret []string
+	mounts, err := sources(procMounts)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		if ours[deviceName(m[0])] {
+			ret = append(ret, m[0]+" on "+m[1])
+		}
+	}
+	// Swap is optional, and the table is missing without it on some hosts
+	swaps, err := sources(procSwaps)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, s := range swaps {
+		if ours[deviceName(s[0])] {
+			ret = append(ret, s[0]+" as swap")
+		}
+	}
+	for _, n := range names {
+		holders, err := ioutil.ReadDir(filepath.Join(sysBlock, n, "holders"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, h := range holders {
+			ret = append(ret, "/dev/"+n+" held by "+h.Name())
+		}
+	}
+	return ret, nil
+}
+
+// Check will ensure the image can safely be written to the device. force
+// permits non-removable and unusually large devices, never mounted ones.
+func (d *Device) Check(imageSize int64, force bool) error {
+	if len(d.Mounted) > 0 {
+		return fmt.Errorf("%v is in use: %v", d.Path, strings.Join(d.Mounted, ", "))
+	}
+	if d.Size < imageSize {
+		return fmt.Errorf("%v is too small for the image (%d < %d bytes)", d, d.Size, imageSize)
+	}
+	if force {
+		return nil
+	}
+	if !d.Removable {
+		return fmt.Errorf("%v is not a removable device, use -force if you are sure", d)
+	}
+	if d.Size > MaxSize {
+		return fmt.Errorf("%v is unusually large for a USB device, use -force if you are sure", d)
+	}
+	return nil
+}
+
+// A ProgressFunc is called after each chunk is written or verified
+type ProgressFunc func(done, total int64)
+
+// copyChunks will copy total bytes, reporting progress
+func copyChunks(dst io.Writer, src io.Reader, total int64, progress ProgressFunc) error {
+	buf := make([]byte, ChunkSize)
+	var done int64
+	for done < total {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			done += int64(n)
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if done != total {
+		return fmt.Errorf("Short copy: %d of %d bytes", done, total)
+	}
+	return nil
+}
+
+// Write will write the image to the device, syncing it before returning.
+// The device is opened exclusively, so the kernel refuses if it was mounted
+// or claimed since it was checked.
+func Write(image, device string, progress ProgressFunc) error {
+	in, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(device, os.O_WRONLY|os.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	if err := copyChunks(out, in, st.Size(), progress); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Verify will read the image back from the device, comparing it chunk by
+// chunk with the original.
+func Verify(image, device string, progress ProgressFunc) error {
+	in, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	dev, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	// Read from the device itself, not what we just left in the page cache.
+	// This fails harmlessly for anything other than a block device.
+	flushBuffers(dev)
+
+	a := make([]byte, ChunkSize)
+	b := make([]byte, ChunkSize)
+	var done int64
+	for done < st.Size() {
+		n, err := io.ReadFull(in, a)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if _, err := io.ReadFull(dev, b[:n]); err != nil {
+			return err
+		}
+		if !bytes.Equal(a[:n], b[:n]) {
+			return ErrVerifyFailed
+		}
+		done += int64(n)
+		if progress != nil {
+			progress(done, st.Size())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package usb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-usb")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sysBlock = filepath.Join(dir, "sys")
+	procMounts = filepath.Join(dir, "mounts")
+	procSwaps = filepath.Join(dir, "swaps")
+
+	files := map[string]string{
+		"sys/sdb/size":             "15633408\n",
+		"sys/sdb/removable":        "1\n",
+		"sys/sdb/device/model":     "Cruzer Blade    \n",
+		"sys/sdb/sdb1/partition":   "1\n",
+		"sys/sdb1/partition":       "1\n",
+		"sys/sda/size":             "1953525168\n",
+		"sys/sda/removable":        "0\n",
+		"sys/sda/sda2/partition":   "2\n",
+		"sys/sdc/size":             "15633408\n",
+		"sys/sdc/removable":        "1\n",
+		"sys/sdc/sdc1/partition":   "1\n",
+		"sys/sdc1/partition":       "1\n",
+		"sys/sdc1/holders/dm-0":    "",
+		"sys/sdd/size":             "15633408\n",
+		"sys/sdd/removable":        "1\n",
+		"sys/sdd/sdd2/partition":   "2\n",
+		"sys/sdd2/partition":       "2\n",
+		"sys/sdbb/size":            "15633408\n",
+		"sys/sdbb/removable":       "1\n",
+		"sys/sdbb/sdbb1/partition": "1\n",
+		"dev/sdbb1":                "",
+		"mounts": "/dev/sda2 / ext4 rw 0 0\n" +
+			"tmpfs /tmp tmpfs rw 0 0\n" +
+			filepath.Join(dir, "by-uuid/1234") + " /media ext4 rw 0 0\n",
+		"swaps": "Filename Type Size Used Priority\n/dev/sdd2 partition 1024 0 -2\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 00755)
+		ioutil.WriteFile(path, []byte(content), 00644)
+	}
+	// Mounted by UUID, which must not be mistaken for sdb
+	os.MkdirAll(filepath.Join(dir, "by-uuid"), 00755)
+	os.Symlink(filepath.Join(dir, "dev/sdbb1"), filepath.Join(dir, "by-uuid/1234"))
+
+	usb, err := inspect("/dev/sdb")
+	if err != nil {
+		t.Fatalf("Failed to inspect device: %v", err)
+	}
+	if usb.Size != 15633408*512 || !usb.Removable || usb.Model != "Cruzer Blade" {
+		t.Fatalf("Incorrect device: %+v", usb)
+	}
+	if err := usb.Check(1<<30, false); err != nil {
+		t.Fatalf("USB device should be writable: %v", err)
+	}
+	if err := usb.Check(16<<30, true); err == nil {
+		t.Fatalf("Devices smaller than the image must be rejected")
+	}
+	if parts, err := partitions("sdb"); err != nil || len(parts) != 1 || parts[0] != 1 {
+		t.Fatalf("Incorrect partitions: %v %v", parts, err)
+	}
+
+	disk, err := inspect("/dev/sda")
+	if err != nil {
+		t.Fatalf("Failed to inspect device: %v", err)
+	}
+	if err := disk.Check(1<<30, false); err == nil {
+		t.Fatalf("Fixed disks must be rejected")
+	}
+	if err := disk.Check(1<<30, true); err == nil {
+		t.Fatalf("Mounted disks must be rejected even when forced")
+	}
+	if _, err := inspect("/dev/sdb1"); err == nil {
+		t.Fatalf("Partitions must be rejected")
+	}
+
+	for _, name := range []string{"sdbb", "sdc", "sdd"} {
+		d, err := inspect("/dev/" + name)
+		if err != nil {
+			t.Fatalf("Failed to inspect device: %v", err)
+		}
+		if err := d.Check(1<<30, true); err == nil {
+			t.Fatalf("Device in use must be rejected: %v", name)
+		}
+	}
+}
+
+func TestWriteVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-usb")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "solus.iso")
+	device := filepath.Join(dir, "device")
+	data := bytes.Repeat([]byte("uspin"), ChunkSize/2)
+	ioutil.WriteFile(image, data, 00644)
+	ioutil.WriteFile(device, make([]byte, len(data)+1024), 00644)
+
+	var last int64
+	if err := Write(image, device, func(done, total int64) { last = done }); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	if last != int64(len(data)) {
+		t.Fatalf("Incorrect progress: %v", last)
+	}
+	if err := Verify(image, device, nil); err != nil {
+		t.Fatalf("Failed to verify image: %v", err)
+	}
+
+	fi, _ := os.OpenFile(device, os.O_WRONLY, 0)
+	fi.WriteAt([]byte("X"), ChunkSize+1)
+	fi.Close()
+	if err := Verify(image, device, nil); err != ErrVerifyFailed {
+		t.Fatalf("Corruption not detected: %v", err)
+	}
+
+	if p := partitionPath("/dev/sdb", 3); p != "/dev/sdb3" {
+		t.Fatalf("Incorrect partition: %v", p)
+	}
+	if p := partitionPath("/dev/mmcblk0", 3); p != "/dev/mmcblk0p3" {
+		t.Fatalf("Incorrect partition: %v", p)
+	}
+}
@@ -0,0 +1,90 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package usb
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultPersistenceLabel is the filesystem label of the persistence partition
+const DefaultPersistenceLabel = "uspin-persist"
+
+// partitionPath returns the device node of the numbered partition
+func partitionPath(device string, n int) string {
+	// i.e. /dev/mmcblk0p3 and /dev/nvme0n1p3, but /dev/sdb3
+	last := device[len(device)-1]
+	if last >= '0' && last <= '9' {
+		return fmt.Sprintf("%vp%d", device, n)
+	}
+	return fmt.Sprintf("%v%d", device, n)
+}
+
+// partitions returns the partition numbers of the device, as known to sysfs
+func partitions(name string) ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join(sysBlock, name, name+"*", "partition"))
+	if err != nil {
+		return nil, err
+	}
+	var ret []int
+	for _, match := range matches {
+		value, err := readSys(filepath.Base(filepath.Dir(match)), "partition")
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, n)
+	}
+	sort.Ints(ret)
+	return ret, nil
+}
+
+// AddPersistence will append an ext4 partition filling the remainder of the
+// device, after the image has been written. It returns the partition path.
+func AddPersistence(d *Device, label string) (string, error) {
+	cmd := exec.Command("sfdisk", "--append", "--no-reread", d.Path)
+	cmd.Stdin = strings.NewReader(",,L\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("Failed to add persistence partition: %v: %s", err, out)
+	}
+	if err := commands.ExecStdoutArgs("partx", []string{"--update", d.Path}); err != nil {
+		return "", err
+	}
+	if err := commands.ExecStdoutArgs("udevadm", []string{"settle"}); err != nil {
+		return "", err
+	}
+	parts, err := partitions(d.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("No partitions found on %v", d.Path)
+	}
+	part := partitionPath(d.Path, parts[len(parts)-1])
+	if err := commands.ExecStdoutArgs("mkfs.ext4", []string{"-F", "-L", label, part}); err != nil {
+		return "", err
+	}
+	return part, nil
+}
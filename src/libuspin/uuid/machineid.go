@@ -0,0 +1,63 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package uuid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A MachineIDPolicy controls the /etc/machine-id shipped within the image
+type MachineIDPolicy string
+
+const (
+	// MachineIDEmpty ships an empty machine-id, generated on first boot
+	MachineIDEmpty MachineIDPolicy = "empty"
+
+	// MachineIDUninitialized ships "uninitialized", treating the first boot
+	// as such for systemd's ConditionFirstBoot
+	MachineIDUninitialized MachineIDPolicy = "uninitialized"
+
+	// MachineIDGenerate ships a machine-id from the Generator
+	MachineIDGenerate MachineIDPolicy = "generate"
+)
+
+// WriteMachineID will write /etc/machine-id within root according to policy
+func (g *Generator) WriteMachineID(root string, policy MachineIDPolicy) error {
+	var contents string
+	switch policy {
+	case MachineIDEmpty:
+		contents = ""
+	case MachineIDUninitialized:
+		contents = "uninitialized\n"
+	case MachineIDGenerate:
+		u, err := g.New("machine-id")
+		if err != nil {
+			return err
+		}
+		contents = u.Hex() + "\n"
+	default:
+		return fmt.Errorf("Unknown machine-id policy: %v", policy)
+	}
+	etc := filepath.Join(root, "etc")
+	if err := os.MkdirAll(etc, 00755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(etc, "machine-id"), []byte(contents), 00444)
+}
@@ -0,0 +1,73 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package uuid centralises the generation of all identifiers placed within
+// an image, such as filesystem UUIDs and the machine-id. A Generator may be
+// seeded to make every identifier reproducible for a given release, or left
+// unseeded to make every build unique.
+package uuid
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// A UUID is an RFC 4122 version 4 style identifier
+type UUID [16]byte
+
+// String returns the canonical hyphenated form of the UUID
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// Hex returns the UUID as 32 hex characters, as used by /etc/machine-id
+func (u UUID) Hex() string {
+	return hex.EncodeToString(u[:])
+}
+
+// A Generator is the single source of identifiers for a build
+type Generator struct {
+	seed string
+}
+
+// NewGenerator will return a new Generator. If seed is empty, every UUID will
+// be random, otherwise each UUID is derived from the seed and its purpose.
+func NewGenerator(seed string) *Generator {
+	return &Generator{seed: seed}
+}
+
+// Seeded returns true if this Generator produces reproducible UUIDs
+func (g *Generator) Seeded() bool {
+	return g.seed != ""
+}
+
+// New will return a UUID for the given purpose, i.e. "rootfs". With a seeded
+// Generator the same purpose always yields the same UUID, regardless of the
+// order in which identifiers are requested.
+func (g *Generator) New(purpose string) (UUID, error) {
+	var u UUID
+	if g.Seeded() {
+		sum := sha256.Sum256([]byte(g.seed + "\x00" + purpose))
+		copy(u[:], sum[:16])
+	} else if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40 // Version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return u, nil
+}
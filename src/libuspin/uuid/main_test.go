@@ -0,0 +1,44 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package uuid
+
+import (
+	"testing"
+)
+
+func TestGenerator(t *testing.T) {
+	g := NewGenerator("solus-1.2.1")
+	a, _ := g.New("rootfs")
+	b, _ := g.New("rootfs")
+	c, _ := g.New("machine-id")
+	if a != b {
+		t.Fatalf("Seeded generator is not reproducible: %v != %v", a, b)
+	}
+	if a == c {
+		t.Fatalf("Different purposes should not yield the same UUID")
+	}
+	if a.String()[14] != '4' || len(a.String()) != 36 {
+		t.Fatalf("Invalid v4 UUID: %v", a)
+	}
+
+	random := NewGenerator("")
+	x, _ := random.New("rootfs")
+	y, _ := random.New("rootfs")
+	if x == y {
+		t.Fatalf("Unseeded generator should be random")
+	}
+}
@@ -0,0 +1,131 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"fmt"
+	"libuspin/config"
+	"libuspin/spec"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Diagnostic is a single problem found while validating a profile
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"` // Unset if the problem has no position
+	Warning bool   `json:"warning,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	severity := "error"
+	if d.Warning {
+		severity = "warning"
+	}
+	if d.Line > 0 {
+		return fmt.Sprintf("%v:%v: %v: %v", d.File, d.Line, severity, d.Message)
+	}
+	return fmt.Sprintf("%v: %v: %v", d.File, severity, d.Message)
+}
+
+// Validate will check the .spin file along with its Packages file and every
+// file it refers to, returning all of the problems found rather than only
+// the first, ordered by file and line. Nothing is built or touched.
+func Validate(spinFile string) []*Diagnostic {
+	var ret []*Diagnostic
+	conf, problems := config.Check(spinFile)
+	for _, p := range problems {
+		ret = append(ret, &Diagnostic{
+			File:    spinFile,
+			Line:    p.Line,
+			Warning: p.Warning,
+			Message: p.Message,
+		})
+	}
+	if conf == nil {
+		return ret
+	}
+
+	baseDir := filepath.Dir(spinFile)
+	join := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+	missing := func(key, path string) {
+		if _, err := os.Stat(join(path)); err != nil {
+			ret = append(ret, &Diagnostic{
+				File:    spinFile,
+				Line:    config.KeyLine(spinFile, key),
+				Message: fmt.Sprintf("%v refers to a missing file: %v", key, path),
+			})
+		}
+	}
+	files := []struct {
+		key   string
+		paths []string
+	}{
+		{"image.license_policy", []string{conf.Image.LicensePolicy}},
+		{"image.overlay", []string{conf.Image.Overlay}},
+		{"scripts.post_install", conf.Scripts.PostInstall},
+		{"scripts.pre_compress", conf.Scripts.PreCompress},
+		{"scripts.post_image", conf.Scripts.PostImage},
+		{"boot.secure_boot_key", []string{conf.Boot.SecureBootKey}},
+		{"boot.secure_boot_cert", []string{conf.Boot.SecureBootCert}},
+	}
+	for _, f := range files {
+		for _, path := range f.paths {
+			if path != "" {
+				missing(f.key, path)
+			}
+		}
+	}
+
+	if conf.Image.Packages != "" {
+		ret = append(ret, validatePackages(spinFile, join(conf.Image.Packages), conf)...)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].File != ret[j].File {
+			return ret[i].File == spinFile
+		}
+		return ret[i].Line < ret[j].Line
+	})
+	return ret
+}
+
+// validatePackages will report every malformed line of the Packages file
+func validatePackages(spinFile, path string, conf *config.ImageConfiguration) []*Diagnostic {
+	parser := spec.NewParser()
+	parser.Arch = conf.Image.Arch
+	parser.Variables = conf.Lookup
+	if parser.Profile = strings.TrimSpace(conf.Image.Profile); parser.Profile == "" {
+		parser.Profile = strings.TrimSuffix(filepath.Base(spinFile), ".spin")
+	}
+	problems, err := parser.ParseAll(path)
+	if err != nil {
+		return []*Diagnostic{{File: path, Message: err.Error()}}
+	}
+	var ret []*Diagnostic
+	for _, p := range problems {
+		ret = append(ret, &Diagnostic{File: p.File, Line: p.Line, Message: p.Err.Error()})
+	}
+	return ret
+}
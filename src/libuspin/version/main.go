@@ -0,0 +1,123 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package version describes the version of uspin itself, allowing profiles
+// to require a particular range of versions, and the binary to update itself.
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version of uspin, kept in sync with the Makefile
+const Version = "0.1"
+
+// Compare returns -1, 0 or 1 if a is older, equal or newer than b. Versions
+// are dotted numbers, with missing components treated as 0.
+func Compare(a, b string) (int, error) {
+	as, err := parse(a)
+	if err != nil {
+		return 0, err
+	}
+	bs, err := parse(b)
+	if err != nil {
+		return 0, err
+	}
+	for len(as) < len(bs) {
+		as = append(as, 0)
+	}
+	for len(bs) < len(as) {
+		bs = append(bs, 0)
+	}
+	for i := range as {
+		switch {
+		case as[i] < bs[i]:
+			return -1, nil
+		case as[i] > bs[i]:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parse(version string) ([]int, error) {
+	var ret []int
+	for _, part := range strings.Split(strings.TrimSpace(version), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid version: '%v'", version)
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+// operators are tried longest first
+var operators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// Satisfies will determine if the version satisfies every comma separated
+// clause of the constraint, i.e. ">= 0.2, < 1.0". A bare version must match
+// exactly.
+func Satisfies(version, constraint string) (bool, error) {
+	for _, clause := range strings.Split(constraint, ",") {
+		clause = strings.TrimSpace(clause)
+		op := "="
+		for _, o := range operators {
+			if strings.HasPrefix(clause, o) {
+				op = o
+				clause = strings.TrimSpace(strings.TrimPrefix(clause, o))
+				break
+			}
+		}
+		cmp, err := Compare(version, clause)
+		if err != nil {
+			return false, err
+		}
+		ok := false
+		switch op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Require will return an error if this uspin does not satisfy the constraint
+func Require(constraint string) error {
+	ok, err := Satisfies(Version, constraint)
+	if err != nil {
+		return fmt.Errorf("Invalid required_uspin_version: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("Profile requires uspin %v, this is uspin %v", constraint, Version)
+	}
+	return nil
+}
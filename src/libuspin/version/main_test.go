@@ -0,0 +1,152 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package version
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSatisfies(t *testing.T) {
+	for _, tc := range []struct {
+		version    string
+		constraint string
+		ok         bool
+	}{
+		{"0.1", "0.1", true},
+		{"0.1", "0.1.0", true},
+		{"0.1", ">= 0.2", false},
+		{"0.10", ">= 0.2", true},
+		{"0.3", ">= 0.2, < 0.4", true},
+		{"0.4", ">= 0.2, < 0.4", false},
+		{"1.0", "!= 1.0", false},
+	} {
+		ok, err := Satisfies(tc.version, tc.constraint)
+		if err != nil || ok != tc.ok {
+			t.Fatalf("%v %v: expected %v, got %v %v", tc.version, tc.constraint, tc.ok, ok, err)
+		}
+	}
+	if _, err := Satisfies("0.1", ">= banana"); err == nil {
+		t.Fatalf("Invalid constraints should be rejected")
+	}
+	if err := Require(">= 99"); err == nil {
+		t.Fatalf("Unsatisfied requirement not reported")
+	}
+}
+
+func TestSelectInstall(t *testing.T) {
+	payload := []byte("#!/bin/sh\necho uspin 0.3\n")
+	sum := sha256.Sum256(payload)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	binaries := func(sum string) map[string]*Binary {
+		return map[string]*Binary{Platform(): {URL: srv.URL, SHA256: sum}}
+	}
+	meta := &Metadata{Releases: []*Release{
+		{Version: "0.2", Binaries: binaries(hex.EncodeToString(sum[:]))},
+		{Version: "0.10", Binaries: binaries("bad")},
+		{Version: "0.3", Binaries: binaries(hex.EncodeToString(sum[:]))},
+		{Version: "9.0", Binaries: map[string]*Binary{"plan9/sparc": {}}},
+		{Version: "9.1", Binaries: map[string]*Binary{runtime.GOARCH: {}}},
+	}}
+	rel, err := meta.Select("")
+	if err != nil || rel.Version != "0.10" {
+		t.Fatalf("Newest release not selected: %v %v", rel, err)
+	}
+	if rel, err = meta.Select("0.3"); err != nil || rel.Version != "0.3" {
+		t.Fatalf("Pinned release not selected: %v %v", rel, err)
+	}
+	if _, err = meta.Select("9.0"); err == nil {
+		t.Fatalf("Releases for other platforms should not be selected")
+	}
+	if _, err = meta.Select("9.1"); err == nil {
+		t.Fatalf("Releases for the architecture of another OS should not be selected")
+	}
+
+	dir, err := ioutil.TempDir("", "uspin-version")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "uspin")
+	ioutil.WriteFile(exe, []byte("old"), 00755)
+
+	if err := Install(meta.Releases[1], exe); err == nil {
+		t.Fatalf("Checksum mismatch not detected")
+	}
+	if data, _ := ioutil.ReadFile(exe); string(data) != "old" {
+		t.Fatalf("Executable replaced despite checksum mismatch")
+	}
+	if err := Install(rel, exe); err != nil {
+		t.Fatalf("Failed to install release: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(exe); string(data) != string(payload) {
+		t.Fatalf("Executable not replaced")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		available string
+		current   string
+		pin       string
+		upgrade   bool
+	}{
+		{"0.3", "0.2", "", true},
+		{"0.2", "0.2", "", false},
+		{"0.1", "0.2", "", false},
+		{"0.2", "0.10", "", false},
+		{"0.1", "0.2", "0.1", true},
+		{"0.2", "0.2", "0.2", false},
+	} {
+		upgrade, err := Upgrade(&Release{Version: tc.available}, tc.current, tc.pin)
+		if err != nil || upgrade != tc.upgrade {
+			t.Fatalf("%v over %v (pin %q): expected %v, got %v %v", tc.available, tc.current, tc.pin, tc.upgrade, upgrade, err)
+		}
+	}
+}
+
+func TestCheckSerial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-version")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	serialFile := filepath.Join(dir, "state", "update-serial")
+
+	for _, serial := range []int64{5, 5, 7} {
+		if err := (&Metadata{Serial: serial}).CheckSerial(serialFile); err != nil {
+			t.Fatalf("Metadata with serial %v should be accepted: %v", serial, err)
+		}
+	}
+	if err := (&Metadata{Serial: 6}).CheckSerial(serialFile); err == nil {
+		t.Fatalf("Replayed metadata should be refused")
+	}
+	if data, _ := ioutil.ReadFile(serialFile); string(data) != "7\n" {
+		t.Fatalf("Newest serial not recorded: %q", data)
+	}
+}
@@ -0,0 +1,247 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package version
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MetadataFile is the release metadata within the update URL
+	MetadataFile = "releases.json"
+
+	// SignatureSuffix is appended to the metadata for its detached signature
+	SignatureSuffix = ".asc"
+
+	// DefaultKeyring holds the keys trusted to sign release metadata
+	DefaultKeyring = "/usr/share/uspin/update-keyring.gpg"
+
+	// DefaultSerialFile records the serial of the newest metadata seen
+	DefaultSerialFile = "/var/lib/uspin/update-serial"
+)
+
+// A Binary is a uspin build for a single host platform
+type Binary struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// A Release is a single uspin release
+type Release struct {
+	Version  string             `json:"version"`
+	Binaries map[string]*Binary `json:"binaries"` // Keyed by Platform, i.e. "linux/amd64"
+}
+
+// Metadata lists every release available for update
+type Metadata struct {
+	Serial   int64      `json:"serial"` // Increased whenever the metadata is published
+	Releases []*Release `json:"releases"`
+}
+
+// Platform returns the key of the binaries which run on this host, as
+// "GOOS/GOARCH"
+func Platform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// fetch will download the URL to the writer
+func fetch(url string, w io.Writer) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch %v: %v", url, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// FetchMetadata will download the release metadata from the base URL and
+// verify its signature against the keyring before trusting any of it.
+func FetchMetadata(baseURL, keyring string) (*Metadata, error) {
+	dir, err := ioutil.TempDir("", "uspin-update")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	meta := filepath.Join(dir, MetadataFile)
+	for _, name := range []string{MetadataFile, MetadataFile + SignatureSuffix} {
+		fi, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		err = fetch(baseURL+"/"+name, fi)
+		fi.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if out, err := exec.Command("gpgv", "--keyring", keyring, meta+SignatureSuffix, meta).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("Release metadata signature is invalid: %v: %s", err, out)
+	}
+
+	data, err := ioutil.ReadFile(meta)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Metadata{}
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Select will return the release to install, which is the pinned version if
+// given, otherwise the newest. Only releases for this platform count.
+func (m *Metadata) Select(pin string) (*Release, error) {
+	var best *Release
+	for _, rel := range m.Releases {
+		if rel.Binaries[Platform()] == nil {
+			continue
+		}
+		if pin != "" {
+			if cmp, err := Compare(rel.Version, pin); err != nil {
+				return nil, err
+			} else if cmp == 0 {
+				return rel, nil
+			}
+			continue
+		}
+		if best == nil {
+			best = rel
+			continue
+		}
+		cmp, err := Compare(rel.Version, best.Version)
+		if err != nil {
+			return nil, err
+		}
+		if cmp > 0 {
+			best = rel
+		}
+	}
+	if pin != "" {
+		return nil, fmt.Errorf("uspin %v is not available for %v", pin, Platform())
+	}
+	if best == nil {
+		return nil, fmt.Errorf("No uspin releases available for %v", Platform())
+	}
+	return best, nil
+}
+
+// CheckSerial will refuse metadata older than the newest seen before, as
+// recorded in the serial file, so that old signed metadata cannot be
+// replayed. The serial of the metadata is then recorded.
+func (m *Metadata) CheckSerial(serialFile string) error {
+	data, err := ioutil.ReadFile(serialFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		last, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid serial in %v: %v", serialFile, err)
+		}
+		if m.Serial < last {
+			return fmt.Errorf("Release metadata is older than previously seen (serial %v < %v), refusing to use it", m.Serial, last)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(serialFile), 00755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(serialFile, []byte(fmt.Sprintf("%v\n", m.Serial)), 00644)
+}
+
+// Upgrade determines whether the release should replace the current
+// version. Only newer releases are installed, unless the release was pinned
+// with an explicit version, which is installed if it differs at all.
+func Upgrade(rel *Release, current, pin string) (bool, error) {
+	cmp, err := Compare(rel.Version, current)
+	if err != nil {
+		return false, err
+	}
+	if pin != "" {
+		return cmp != 0, nil
+	}
+	return cmp > 0, nil
+}
+
+// Install will download the release binary, verify its checksum and then
+// atomically replace the executable with it.
+func Install(rel *Release, executable string) error {
+	bin := rel.Binaries[Platform()]
+	if bin == nil {
+		return fmt.Errorf("uspin %v is not available for %v", rel.Version, Platform())
+	}
+	// Within the same directory so that the rename is atomic
+	tmp, err := ioutil.TempFile(filepath.Dir(executable), ".uspin-update")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	err = fetch(bin.URL, io.MultiWriter(tmp, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != bin.SHA256 {
+		return fmt.Errorf("Checksum mismatch for uspin %v: %v != %v", rel.Version, sum, bin.SHA256)
+	}
+	if err := os.Chmod(tmp.Name(), 00755); err != nil {
+		return err
+	}
+	return replace(tmp.Name(), executable)
+}
+
+// replace will move the new binary over the executable. Windows refuses to
+// replace a running executable, but allows it to be renamed out of the way,
+// leaving the old binary behind until the next update.
+func replace(path, executable string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(path, executable)
+	}
+	old := executable + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(executable, old); err != nil {
+		return err
+	}
+	if err := os.Rename(path, executable); err != nil {
+		os.Rename(old, executable)
+		return err
+	}
+	return nil
+}
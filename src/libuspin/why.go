@@ -0,0 +1,125 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libuspin
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A WhyReason explains how a single package came to be in the plan
+type WhyReason struct {
+	Explicit bool     // Explicitly listed in the Packages file
+	Group    string   // The group that pulled in Path[0], if any
+	Path     []string // Dependency chain, from the requested package to the target
+}
+
+// String will return a human readable explanation of the reason
+func (w *WhyReason) String() string {
+	var origin string
+	switch {
+	case w.Explicit:
+		origin = fmt.Sprintf("'%v' is listed in the Packages file", w.Path[0])
+	case w.Group != "":
+		origin = fmt.Sprintf("'%v' is part of the group '%v'", w.Path[0], w.Group)
+	}
+	if len(w.Path) == 1 {
+		return origin
+	}
+	chain := w.Path[0]
+	for _, name := range w.Path[1:] {
+		chain += " -> " + name
+	}
+	return fmt.Sprintf("%v, and requires it via: %v", origin, chain)
+}
+
+// Why will explain why the named package is included in the plan, returning
+// the shortest dependency chain for every explicit package or group that
+// causes it to be installed. An empty result means the package is not part
+// of the plan.
+func (p *Plan) Why(name string) []*WhyReason {
+	var ret []*WhyReason
+
+	explicit := make(map[string]bool)
+	for _, step := range p.Steps {
+		if step.Kind == PlanStepPackage {
+			for _, pkg := range step.Names {
+				explicit[pkg] = true
+			}
+		}
+	}
+
+	// Origin packages mapped to the group they came from ("" for explicit)
+	origins := make(map[string][]string)
+	for pkg := range explicit {
+		origins[pkg] = append(origins[pkg], "")
+	}
+	for group, pkgs := range p.Expansions {
+		for _, pkg := range pkgs {
+			origins[pkg] = append(origins[pkg], group)
+		}
+	}
+
+	var roots []string
+	for root := range origins {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		path := p.dependencyPath(root, name)
+		if path == nil {
+			continue
+		}
+		groups := origins[root]
+		sort.Strings(groups)
+		for _, group := range groups {
+			ret = append(ret, &WhyReason{
+				Explicit: group == "",
+				Group:    group,
+				Path:     path,
+			})
+		}
+	}
+	return ret
+}
+
+// dependencyPath performs a breadth first search through the dependency graph
+// to find the shortest chain from one package to another.
+func (p *Plan) dependencyPath(from, to string) []string {
+	parents := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == to {
+			var path []string
+			for ; cur != ""; cur = parents[cur] {
+				path = append([]string{cur}, path...)
+			}
+			return path
+		}
+		for _, dep := range p.Dependencies[cur] {
+			if _, ok := parents[dep]; ok {
+				continue
+			}
+			parents[dep] = cur
+			queue = append(queue, dep)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package workspace moves build workspaces between machines, so that a
+// build which failed elsewhere, i.e. on a CI runner, can be inspected
+// locally.
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MetadataFile describes the origin of an exported workspace, and is
+// stored at the root of the archive.
+const MetadataFile = "uspin-workspace.json"
+
+// Metadata records where and how an exported workspace was built
+type Metadata struct {
+	Profile string    `json:"profile"` // Name of the .spin file
+	Version string    `json:"version"` // Version of uspin that built it
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+}
+
+// tar runs tar, including its output within any error
+func tar(args ...string) error {
+	out, err := exec.Command("tar", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tar failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Export will archive the workspace directory, along with the metadata.
+// Compression is chosen by the archive suffix, i.e. ".tar.zst". The
+// rootfs image is stored sparse, and ownership is kept numeric so that
+// the rootfs remains usable on another host.
+func Export(dir, archive string, meta *Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+	metaPath := filepath.Join(dir, MetadataFile)
+	if err := ioutil.WriteFile(metaPath, data, 00644); err != nil {
+		return err
+	}
+	defer os.Remove(metaPath)
+
+	archive, err = filepath.Abs(archive)
+	if err != nil {
+		return err
+	}
+	return tar("--create", "--auto-compress", "--sparse", "--xattrs", "--xattrs-include=*", "--numeric-owner",
+		"--file", archive, "--directory", dir, ".")
+}
+
+// Import will replace the workspace directory with the contents of the
+// archive, returning the metadata of the export. The existing workspace
+// is only removed once the archive has been extracted successfully.
+func Import(archive, dir string) (*Metadata, error) {
+	tmp := dir + ".import"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmp, 00755); err != nil {
+		return nil, err
+	}
+	if err := tar("--extract", "--sparse", "--xattrs", "--xattrs-include=*", "--numeric-owner", "--same-permissions",
+		"--file", archive, "--directory", tmp); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	metaPath := filepath.Join(tmp, MetadataFile)
+	data, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("Not an exported workspace: %v", archive)
+	}
+	meta := &Metadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	if err := os.Remove(metaPath); err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,72 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-workspace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "workspace")
+	if err := os.MkdirAll(filepath.Join(src, "LiveOS"), 00755); err != nil {
+		t.Fatalf("Failed to create workspace: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "plan.json"), []byte("{}"), 00644); err != nil {
+		t.Fatalf("Failed to write plan: %v", err)
+	}
+	archive := filepath.Join(dir, "workspace.tar.gz")
+	if err := Export(src, archive, &Metadata{Profile: "minimal.spin", Version: "0.1"}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(src, MetadataFile)); !os.IsNotExist(err) {
+		t.Fatalf("Metadata left in the workspace")
+	}
+
+	dst := filepath.Join(dir, "imported")
+	if err := os.MkdirAll(filepath.Join(dst, "stale"), 00755); err != nil {
+		t.Fatalf("Failed to create stale workspace: %v", err)
+	}
+	meta, err := Import(archive, dst)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if meta.Profile != "minimal.spin" {
+		t.Fatalf("Wrong metadata: %v", meta)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dst, "plan.json")); string(data) != "{}" {
+		t.Fatalf("Workspace not imported")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale")); !os.IsNotExist(err) {
+		t.Fatalf("Stale workspace not replaced")
+	}
+
+	if _, err := Import(filepath.Join(dir, "missing.tar"), dst); err == nil {
+		t.Fatalf("Imported a missing archive")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "plan.json")); err != nil {
+		t.Fatalf("Failed import removed the workspace: %v", err)
+	}
+}
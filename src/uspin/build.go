@@ -16,39 +16,125 @@
 
 package main
 
-// Build will attempt to build the image, and return an error if this fails
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/events"
+	"libuspin/journal"
+	"runtime"
+	"time"
+)
+
+// requireLinux will refuse to work on the rootfs on other hosts, which may
+// only validate and plan profiles, see libuspin/disk
+func requireLinux() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("Building images requires a Linux host, not %v", runtime.GOOS)
+	}
+	return nil
+}
+
+// Build will attempt to build the image, and return an error if this fails.
+// A failure bundle is written for any failed build, and the error has already
+// been logged by the time it is returned.
 func (s *USpin) Build() error {
+	s.recordLogs()
+	if s.heartbeat != nil {
+		log.AddHook(s.heartbeat)
+		s.heartbeat.Start()
+		defer s.heartbeat.Stop()
+	}
+	if s.events != nil {
+		log.AddHook(s.events)
+		defer s.events.Close()
+	}
+	start := time.Now()
+	s.emit(&events.Event{
+		Kind: events.KindBuildStart,
+		Fields: map[string]interface{}{
+			"spin": s.spec.SpinFile,
+			"type": s.spec.Config.Image.Type,
+			"arch": s.spec.Arch,
+		},
+	})
+	err := s.build()
+	if err != nil {
+		s.writeFailureBundle(err)
+	}
+	s.writeStatus(err)
+	end := &events.Event{Kind: events.KindBuildEnd, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		end.Error = err.Error()
+	}
+	s.emit(end)
+	if err != nil {
+		return loggedError{err}
+	}
+	return nil
+}
+
+// build performs the actual build, with all cleanup done by the time it
+// returns
+func (s *USpin) build() error {
+	// Remind the maintainer once the noise of the build is over
+	defer s.summariseDeprecations()
+
+	if err := requireLinux(); err != nil {
+		s.logImage.Error(err)
+		return err
+	}
+
+	// Builders check the host for their tools while initialising
+	if err := s.prepareTools(); err != nil {
+		s.logImage.Error(err)
+		return err
+	}
+	if err := s.exportEpoch(); err != nil {
+		s.logImage.Error(err)
+		return err
+	}
+
 	// Initialise our builder before we go anywhere
 	if err := s.builder.Init(s.spec); err != nil {
 		s.logImage.Error(err)
 		return err
 	}
-	// Make sure that the package manager requirements are met
-	if err := s.packager.Init(); err != nil {
-		s.logPackage.Error(err)
+	// Nobody else may use our workspace or shared caches until we're done
+	if err := s.acquireLocks(); err != nil {
+		s.logImage.Error(err)
 		return err
 	}
+	defer s.releaseLocks()
 
-	// Always perform cleanup duty.
-	defer s.builder.Cleanup()
+	if s.checkpoint {
+		if err := s.openCheckpoints(); err != nil {
+			s.logImage.Error(err)
+			return err
+		}
+	}
 
-	// Start building the base parts of the image
-	if err := s.StartImageBuild(); err != nil {
+	// Record our progress, so the workspace state survives a crash
+	if err := s.openJournal(); err != nil {
 		s.logImage.Error(err)
 		return err
 	}
+	defer s.record(journal.EventEnd, "", nil)
 
-	// Hand over to the package manager
-	if err := s.InstallPackages(); err != nil {
+	// Make sure that the package manager requirements are met
+	if err := s.packager.Init(); err != nil {
 		s.logPackage.Error(err)
 		return err
 	}
 
-	// And now finish the image build
-	if err := s.FinishImageBuild(); err != nil {
-		s.logImage.Error(err)
+	// Always perform cleanup duty.
+	defer s.builder.Cleanup()
+
+	stopProxy, err := s.startProxy()
+	if err != nil {
+		s.logPackage.Error(err)
 		return err
 	}
+	defer stopProxy()
 
-	return nil
+	return s.runStages()
 }
@@ -0,0 +1,80 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/publish"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeStatus will record the result of the build for dashboards, next to
+// the artifacts unless a status directory is configured. Builds that neither
+// failed nor delivered an image, i.e. with -only, leave the status alone.
+func (s *USpin) writeStatus(buildErr error) {
+	if buildErr == nil && len(s.outputs) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	conf := s.spec.Config
+	st := &publish.Status{
+		Profile: s.spec.Profile,
+		Status:  publish.StatusSucceeded,
+		Version: s.release,
+		Date:    now,
+	}
+	if st.Version == "" {
+		st.Version = conf.Publish.Release
+	}
+	if st.Version == "" {
+		st.Version = now.Format("20060102")
+	}
+	if buildErr != nil {
+		st.Status = publish.StatusFailed
+	} else if fi, err := os.Stat(s.outputs[0]); err == nil {
+		st.Image = filepath.Base(s.outputs[0])
+		st.Size = fi.Size()
+	}
+
+	dir := conf.Status.Directory
+	if dir != "" {
+		dir = s.spec.JoinPath(dir)
+	} else if s.outputs != nil {
+		dir = filepath.Dir(s.outputs[0])
+	} else {
+		var err error
+		if dir, err = publish.OutputDir(s.spec, now); err != nil {
+			s.logImage.WithFields(log.Fields{
+				"error": err,
+			}).Warning("Failed to write build status")
+			return
+		}
+	}
+	paths, err := publish.WriteStatus(dir, st, conf.Status.Badge)
+	if err != nil {
+		s.logImage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to write build status")
+		return
+	}
+	s.logImage.WithFields(log.Fields{
+		"status": st.Status,
+		"files":  paths,
+	}).Info("Wrote build status")
+}
@@ -0,0 +1,166 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/checkpoint"
+	"libuspin/lint"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckpointDir holds the rootfs checkpoints, beside the workspace as
+// preparing the workspace purges it
+const CheckpointDir = "checkpoints"
+
+// openCheckpoints will open the checkpoint store of the workspace
+func (s *USpin) openCheckpoints() error {
+	dir := filepath.Join(filepath.Dir(s.builder.GetWorkspace()), CheckpointDir)
+	store, err := checkpoint.Open(dir)
+	if err != nil {
+		return err
+	}
+	s.checkpoints = store
+	return nil
+}
+
+// cachedKey returns the named checkpoint key, which is only computed once
+// per build as it queries every repository
+func (s *USpin) cachedKey(name string, compute func() (string, error)) (string, error) {
+	if key, ok := s.checkpointKeys[name]; ok {
+		return key, nil
+	}
+	key, err := compute()
+	if err != nil {
+		return "", err
+	}
+	if s.checkpointKeys == nil {
+		s.checkpointKeys = make(map[string]string)
+	}
+	s.checkpointKeys[name] = key
+	return key, nil
+}
+
+// rootfsKey identifies the inputs of the rootfs stage
+func (s *USpin) rootfsKey() (string, error) {
+	return s.cachedKey("rootfs", s.spec.RootfsKey)
+}
+
+// configureKey identifies the inputs of the rootfs and configure stages
+func (s *USpin) configureKey() (string, error) {
+	rootfs, err := s.rootfsKey()
+	if err != nil {
+		return "", err
+	}
+	return s.cachedKey("configure", func() (string, error) {
+		return s.spec.ConfigureKey(rootfs)
+	})
+}
+
+// restoreCheckpoint will restore the latest checkpoint whose inputs are
+// unchanged, returning the index of the stage it was taken after, or -1.
+// Only an empty rootfs, as created by the prepare stage, is restored into.
+func (s *USpin) restoreCheckpoint(first int) int {
+	if s.checkpoints == nil || !s.stages[0] {
+		return -1
+	}
+	// Checkpoints are only usable if every stage they replace was selected
+	last := first
+	for last+1 < len(stages) && s.stages[last+1] {
+		last++
+	}
+	for i := last; i >= first; i-- {
+		st := stages[i]
+		if st.key == nil {
+			continue
+		}
+		key, err := st.key(s)
+		if err != nil {
+			s.logImage.WithFields(log.Fields{
+				"error": err,
+			}).Warning("Unable to identify the inputs of the build, not using checkpoints")
+			return -1
+		}
+		c, ok := s.checkpoints.Lookup(st.name, key)
+		if !ok {
+			continue
+		}
+		s.logImage.WithFields(log.Fields{
+			"stage":   st.name,
+			"created": c.Created,
+		}).Info("Restoring checkpoint")
+		if err := s.checkpoints.Restore(c, s.builder.GetRootDir(), s.builder.GetWorkspace()); err != nil {
+			s.logImage.WithFields(log.Fields{
+				"error": err,
+			}).Warning("Unable to restore checkpoint, rebuilding")
+			// Don't trip over a damaged checkpoint again
+			s.checkpoints.Remove(st.name)
+			return -1
+		}
+		return i
+	}
+	return -1
+}
+
+// saveCheckpoint will snapshot the rootfs after the stage. Failing to do so
+// is not fatal, as the next build simply repeats the stage.
+func (s *USpin) saveCheckpoint(st *stage) {
+	if s.checkpoints == nil {
+		return
+	}
+	if err := s.snapshot(st); err != nil {
+		s.logImage.WithFields(log.Fields{
+			"stage": st.name,
+			"error": err,
+		}).Warning("Unable to save checkpoint")
+	}
+}
+
+// snapshot will store the rootfs and workspace outputs of the stage
+func (s *USpin) snapshot(st *stage) error {
+	key, err := st.key(s)
+	if err != nil {
+		return err
+	}
+	root := filepath.Clean(s.builder.GetRootDir())
+
+	// Anything mounted within the rootfs, i.e. by the chroot, is not part of it
+	mounts, err := lint.MountsBeneath(root)
+	if err != nil {
+		return err
+	}
+	var exclude []string
+	for _, mount := range mounts {
+		if mount != root {
+			exclude = append(exclude, strings.TrimPrefix(mount, root))
+		}
+	}
+	var files []string
+	if st.outputs != nil {
+		if path := st.outputs(s); strings.HasPrefix(path, s.builder.GetWorkspace()+"/") {
+			if _, err := os.Stat(path); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+	s.logImage.WithFields(log.Fields{
+		"stage": st.name,
+	}).Info("Saving checkpoint")
+	return s.checkpoints.Save(st.name, key, root, exclude, files)
+}
@@ -0,0 +1,46 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/publish"
+)
+
+// ChecksumOutputs will write checksums for the delivered artifacts, signing
+// them if configured, so that they are published alongside the artifacts.
+func (s *USpin) ChecksumOutputs() error {
+	conf := &s.spec.Config.Checksums
+	if len(conf.Algorithms) == 0 {
+		return nil
+	}
+	if len(s.outputs) == 0 {
+		return errors.New("Nothing to checksum, the deliver stage did not run")
+	}
+	written, err := publish.WriteChecksums(s.outputs, conf)
+	if err != nil {
+		return err
+	}
+	for _, path := range written {
+		s.logImage.WithFields(log.Fields{
+			"output": path,
+		}).Info("Wrote checksum")
+	}
+	s.outputs = append(s.outputs, written...)
+	return nil
+}
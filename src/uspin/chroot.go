@@ -0,0 +1,112 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"libuspin/build"
+	"libuspin/chroot"
+	"libuspin/secrets"
+	"os"
+	"strings"
+)
+
+var cmdChroot = &Command{
+	Name:  "chroot",
+	Usage: "image.spin [command...]",
+	Short: "Run a shell or command within the rootfs of the previous build",
+}
+
+func init() {
+	cmdChroot.Run = runChroot
+	registerCommand(cmdChroot)
+}
+
+// getChroot will return the Chroot for the rootfs with the configured host
+// directories, ccache and secrets available to commands run within it, and
+// secrets redacted from their output. The same Chroot is shared by every
+// stage so that ccache statistics cover the whole build.
+func (s *USpin) getChroot() *chroot.Chroot {
+	if s.chroot != nil {
+		return s.chroot
+	}
+	binds := chroot.BindsFromConfig(s.spec.Config.Mounts, s.spec.BaseDir)
+	s.chroot = chroot.New(s.builder.GetRootDir(), binds, s.secrets.Environ())
+	s.chroot.Stdout = secrets.NewWriter(os.Stdout, s.secrets)
+	s.chroot.Stderr = secrets.NewWriter(os.Stderr, s.secrets)
+	if ccache := &s.spec.Config.CCache; ccache.Directory != "" {
+		s.chroot.UseCCache(s.spec.JoinPath(ccache.Directory), ccache.MaxSize, ccache.Wrappers)
+	}
+	return s.chroot
+}
+
+func runChroot(args []string) error {
+	fs := cmdChroot.flagSet()
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errUsage
+	}
+	if err := requireLinux(); err != nil {
+		return err
+	}
+	spin, err := NewUSpin(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := spin.builder.Init(spin.spec); err != nil {
+		return err
+	}
+	cached, ok := spin.builder.(build.CachedRootfs)
+	if !ok {
+		return fmt.Errorf("Image type %v has no reusable rootfs", spin.spec.Config.Image.Type)
+	}
+	if err := spin.acquireLocks(); err != nil {
+		return err
+	}
+	defer spin.releaseLocks()
+
+	if err := cached.OpenWorkspace(); err != nil {
+		return err
+	}
+	if err := spin.builder.MountStorage(); err != nil {
+		return err
+	}
+	defer spin.builder.Cleanup()
+
+	c := spin.getChroot()
+	if err := c.Enter(); err != nil {
+		return err
+	}
+	defer c.Leave()
+
+	script := "exec /bin/sh -l"
+	if fs.NArg() > 1 {
+		script = strings.Join(fs.Args()[1:], " ")
+	}
+	cmd := c.Command(script)
+	cmd.Stdin = os.Stdin
+	if fs.NArg() == 1 {
+		// Redaction would hold back the prompt of the interactive shell
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+	err = cmd.Run()
+	if ferr := c.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// A Command is a subcommand of the uspin binary
+type Command struct {
+	Name  string                    // Name used on the command line
+	Usage string                    // Argument synopsis, i.e. "[flags] image.spin"
+	Short string                    // One line description for the help output
+	Run   func(args []string) error // Entry point, given all remaining arguments
+}
+
+var (
+	// subcommands is populated by each command's init()
+	subcommands = make(map[string]*Command)
+
+	// errUsage is returned by a Command when the arguments are incorrect
+	errUsage = errors.New("Invalid usage")
+)
+
+// registerCommand will make the command available on the command line
+func registerCommand(c *Command) {
+	subcommands[c.Name] = c
+}
+
+// printUsage will print the synopsis for this command to stderr
+func (c *Command) printUsage() {
+	fmt.Fprintf(os.Stderr, "%s %s %s\n\n%s\n", os.Args[0], c.Name, c.Usage, c.Short)
+}
+
+// flagSet returns a new flag.FlagSet whose usage output is tied to the command
+func (c *Command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		c.printUsage()
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
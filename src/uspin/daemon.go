@@ -0,0 +1,545 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/backend"
+	"libuspin/queue"
+	"libuspin/remote"
+	"libuspin/signature"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSpoolDir is where the daemon and "uspin submit" share jobs
+const DefaultSpoolDir = "/var/lib/uspin/spool"
+
+var cmdSubmit = &Command{
+	Name:  "submit",
+	Usage: "[flags] image.spin",
+	Short: "Queue a build with the USpin daemon",
+}
+
+var cmdDaemon = &Command{
+	Name:  "daemon",
+	Usage: "[flags]",
+	Short: "Run queued builds in priority order",
+}
+
+func init() {
+	cmdSubmit.Run = runSubmit
+	cmdDaemon.Run = runDaemon
+	registerCommand(cmdSubmit)
+	registerCommand(cmdDaemon)
+}
+
+func runSubmit(args []string) error {
+	fs := cmdSubmit.flagSet()
+	spoolDir := fs.String("spool", DefaultSpoolDir, "Spool directory shared with the daemon")
+	priorityName := fs.String("priority", string(queue.PriorityExperimental), "One of release, nightly or experimental")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	priority, err := queue.ParsePriority(*priorityName)
+	if err != nil {
+		return err
+	}
+	spool, err := queue.NewSpool(*spoolDir)
+	if err != nil {
+		return err
+	}
+	job, err := spool.Submit(fs.Arg(0), priority)
+	if err != nil {
+		return err
+	}
+	fmt.Println(job.ID)
+	return nil
+}
+
+// serveRemote will accept remote builds into the spool in the background,
+// once the address is known to be usable
+func serveRemote(spool *queue.Spool, addr, tokenFile, tlsCert, tlsKey string) error {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(token)) == "" {
+		return fmt.Errorf("Empty token in %v", tokenFile)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: remote.NewServer(spool, strings.TrimSpace(string(token)))}
+	go func() {
+		var err error
+		if tlsCert != "" {
+			err = srv.ServeTLS(l, tlsCert, tlsKey)
+		} else {
+			err = srv.Serve(l)
+		}
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Stopped accepting remote builds")
+	}()
+	log.WithFields(log.Fields{
+		"address": l.Addr().String(),
+		"tls":     tlsCert != "",
+	}).Info("Accepting remote builds")
+	return nil
+}
+
+// A daemonJob is a job which has been started by the daemon
+type daemonJob struct {
+	job *queue.Job
+	cmd *exec.Cmd
+	err error
+}
+
+// The daemon runs each build as a child "uspin build" within the job directory,
+// preempting lower priority builds at their next stage boundary when a more
+// important build is waiting.
+type daemon struct {
+	spool   *queue.Spool
+	queue   *queue.Queue
+	workers int
+	jobs    map[string]*daemonJob // Running and paused jobs
+	held    []*queue.Job          // Queued jobs paused on request
+	done    chan *daemonJob
+
+	buildArgs []string // Extra flags for every "uspin build"
+}
+
+func runDaemon(args []string) error {
+	fs := cmdDaemon.flagSet()
+	spoolDir := fs.String("spool", DefaultSpoolDir, "Spool directory shared with \"uspin submit\"")
+	workers := fs.Int("workers", 1, "Number of builds to run concurrently")
+	interval := fs.Duration("interval", 10*time.Second, "How often to check for submitted jobs")
+	verify := fs.String("require-signature", "", "Only build signed profiles, either detached or git-tag")
+	keyring := fs.String("keyring", "", "Keyring trusted for detached profile signatures")
+	listen := fs.String("listen", "", "Accept remote builds on this address, i.e. :"+remote.DefaultPort)
+	tokenFile := fs.String("token-file", "", "File holding the token remote clients must present")
+	tlsCert := fs.String("tls-cert", "", "Certificate for serving remote builds over https")
+	tlsKey := fs.String("tls-key", "", "Key of the -tls-cert certificate")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *workers < 1 {
+		return errUsage
+	}
+	if *listen != "" && *tokenFile == "" {
+		return errors.New("-token-file is required with -listen")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	method, err := signature.ParseMethod(*verify)
+	if err != nil {
+		return err
+	}
+	if method == signature.MethodDetached && *keyring == "" {
+		return errors.New("-keyring is required for detached signatures")
+	}
+	spool, err := queue.NewSpool(*spoolDir)
+	if err != nil {
+		return err
+	}
+	d := &daemon{
+		spool:   spool,
+		queue:   queue.NewQueue(),
+		workers: *workers,
+		jobs:    make(map[string]*daemonJob),
+		done:    make(chan *daemonJob),
+	}
+	if method != signature.MethodNone {
+		d.buildArgs = []string{"-verify-signature", string(method), "-keyring", *keyring}
+	}
+	if *listen != "" {
+		if err := serveRemote(spool, *listen, *tokenFile, *tlsCert, *tlsKey); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"spool":   *spoolDir,
+		"workers": *workers,
+	}).Info("USpin daemon started")
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		d.checkSchedules(time.Now())
+		if err := d.collect(); err != nil {
+			return err
+		}
+		d.applyHolds()
+		d.schedule()
+		select {
+		case j := <-d.done:
+			d.finish(j)
+		case <-ticker.C:
+		}
+	}
+}
+
+// setState will update the job state, persisting it in the spool
+func (d *daemon) setState(j *queue.Job, state queue.JobState) {
+	j.State = state
+	log.WithFields(log.Fields{
+		"job":      j.ID,
+		"priority": j.Priority,
+		"state":    state,
+	}).Info("Job state changed")
+	if err := d.spool.Update(j); err != nil {
+		log.WithFields(log.Fields{
+			"job":   j.ID,
+			"error": err,
+		}).Error("Failed to store job state")
+	}
+}
+
+// collect will enqueue all newly submitted jobs
+func (d *daemon) collect() error {
+	jobs, err := d.spool.Collect()
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		d.queue.Push(j)
+		d.setState(j, queue.JobQueued)
+	}
+	return nil
+}
+
+// setHeld will record whether the job was paused on request, returning
+// true if that changed
+func (d *daemon) setHeld(j *queue.Job, held bool) bool {
+	if j.Held == held {
+		return false
+	}
+	j.Held = held
+	msg := "Job released"
+	if held {
+		msg = "Job held"
+	}
+	log.WithFields(log.Fields{
+		"job": j.ID,
+	}).Info(msg)
+	if err := d.spool.Update(j); err != nil {
+		log.WithFields(log.Fields{
+			"job":   j.ID,
+			"error": err,
+		}).Error("Failed to store job state")
+	}
+	return true
+}
+
+// applyHolds will act on the pause and resume requests in the spool, i.e.
+// from remote clients. Running jobs are paused, and held jobs are left out
+// of scheduling until they are released.
+func (d *daemon) applyHolds() {
+	for _, j := range d.jobs {
+		d.setHeld(j.job, d.spool.Held(j.job.ID))
+		if !j.job.Held || j.job.State != queue.JobRunning {
+			continue
+		}
+		if yieldSignal == nil {
+			log.WithFields(log.Fields{
+				"job": j.job.ID,
+			}).Warning("Cannot pause builds on this host")
+			continue
+		}
+		d.signal(j, yieldSignal, queue.JobPaused)
+	}
+
+	queued := d.held
+	for d.queue.Len() > 0 {
+		queued = append(queued, d.queue.Pop())
+	}
+	d.held = nil
+	for _, job := range queued {
+		d.setHeld(job, d.spool.Held(job.ID))
+		if job.Held {
+			d.held = append(d.held, job)
+		} else {
+			d.queue.Push(job)
+		}
+	}
+}
+
+// checkSchedules will submit jobs for all schedules that are due, unless
+// none of the build inputs have changed since the last scheduled build.
+func (d *daemon) checkSchedules(now time.Time) {
+	schedules, err := d.spool.Schedules()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to load schedules")
+		return
+	}
+	for _, sched := range schedules {
+		if err := d.runSchedule(sched, now); err != nil {
+			log.WithFields(log.Fields{
+				"schedule": sched.Name,
+				"error":    err,
+			}).Error("Failed to run schedule")
+		}
+		if err := d.watchSchedule(sched, now); err != nil {
+			log.WithFields(log.Fields{
+				"schedule": sched.Name,
+				"error":    err,
+			}).Error("Failed to check repositories")
+		}
+	}
+}
+
+// runSchedule will submit a job for the schedule if it is due
+func (d *daemon) runSchedule(sched *queue.Schedule, now time.Time) error {
+	due, err := sched.Due(now)
+	if err != nil || !due {
+		return err
+	}
+	sched.LastRun = now.Truncate(time.Minute)
+	defer d.spool.UpdateSchedule(sched)
+
+	img, err := libuspin.NewImageSpec(sched.Spin)
+	if err != nil {
+		return err
+	}
+	fp, err := img.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if fp == sched.LastFingerprint {
+		log.WithFields(log.Fields{
+			"schedule": sched.Name,
+		}).Info("Skipping scheduled build, nothing has changed")
+		return nil
+	}
+
+	job, err := d.spool.Submit(sched.Spin, sched.Priority)
+	if err != nil {
+		return err
+	}
+	sched.LastFingerprint = fp
+	log.WithFields(log.Fields{
+		"schedule": sched.Name,
+		"job":      job.ID,
+	}).Info("Submitted scheduled build")
+	return nil
+}
+
+// watchSchedule will poll the repositories used by the schedule, submitting
+// a job once a change relevant to the image has settled.
+func (d *daemon) watchSchedule(sched *queue.Schedule, now time.Time) error {
+	if !sched.PollDue(now) {
+		return nil
+	}
+	sched.LastPoll = now
+	defer d.spool.UpdateSchedule(sched)
+
+	img, err := libuspin.NewImageSpec(sched.Spin)
+	if err != nil {
+		return err
+	}
+
+	// Cheaply check the repository metadata before resolving packages
+	repoState, err := img.RepoState()
+	if err != nil {
+		return err
+	}
+	if repoState != sched.RepoState {
+		query, err := backend.NewQuery(img.Config.Image.PackageManager, img.Repos())
+		if err != nil {
+			return err
+		}
+		pkgState, err := img.PackageState(query)
+		query.Close()
+		if err != nil {
+			return err
+		}
+		sched.RepoState = repoState
+		sched.SetPackageState(pkgState, now)
+		if !sched.ChangedAt.IsZero() {
+			log.WithFields(log.Fields{
+				"schedule": sched.Name,
+				"debounce": sched.Debounce,
+			}).Info("Relevant packages changed, waiting for repositories to settle")
+		}
+	}
+
+	if !sched.Settled(now) {
+		return nil
+	}
+	job, err := d.spool.Submit(sched.Spin, sched.Priority)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"schedule": sched.Name,
+		"job":      job.ID,
+	}).Info("Submitted build for repository changes")
+	return nil
+}
+
+// active returns the number of jobs actually consuming a worker
+func (d *daemon) active() int {
+	n := 0
+	for _, j := range d.jobs {
+		if j.job.State == queue.JobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// find returns the first job in the given state, ordered by Before. If
+// reverse is set, the last job is returned instead. Held jobs are ignored.
+func (d *daemon) find(state queue.JobState, reverse bool) *daemonJob {
+	var ret *daemonJob
+	for _, j := range d.jobs {
+		if j.job.State != state || j.job.Held {
+			continue
+		}
+		if ret == nil || j.job.Before(ret.job) != reverse {
+			ret = j
+		}
+	}
+	return ret
+}
+
+// schedule will start, resume and preempt jobs until all workers are
+// used by the most important jobs. Scheduling stops if a job cannot be
+// signalled, i.e. it has already exited, until the job is finished.
+func (d *daemon) schedule() {
+	for {
+		next := d.queue.Peek()
+		if d.active() < d.workers {
+			// Paused jobs take precedence over equal or lower queued jobs
+			if paused := d.find(queue.JobPaused, false); paused != nil && (next == nil || !next.Before(paused.job)) {
+				if err := d.signal(paused, resumeSignal, queue.JobRunning); err != nil {
+					return
+				}
+				continue
+			}
+			if next == nil {
+				return
+			}
+			d.start(d.queue.Pop())
+			continue
+		}
+
+		// Preempt the least important running job if the next job outranks it
+		victim := d.find(queue.JobRunning, true)
+		if next == nil || victim == nil || yieldSignal == nil || next.Priority.Rank() >= victim.job.Priority.Rank() {
+			return
+		}
+		if err := d.signal(victim, yieldSignal, queue.JobPaused); err != nil {
+			return
+		}
+	}
+}
+
+// signal will send the signal to a job's build process and update its state,
+// leaving the state alone if the process cannot be signalled
+func (d *daemon) signal(j *daemonJob, sig os.Signal, state queue.JobState) error {
+	if err := j.cmd.Process.Signal(sig); err != nil {
+		log.WithFields(log.Fields{
+			"job":   j.job.ID,
+			"error": err,
+		}).Error("Failed to signal build")
+		return err
+	}
+	d.setState(j.job, state)
+	return nil
+}
+
+// start will spawn the build for the job within its job directory
+func (d *daemon) start(job *queue.Job) {
+	j := &daemonJob{job: job}
+	dir := d.spool.JobDir(job.ID)
+
+	logFile, err := os.Create(filepath.Join(dir, "build.log"))
+	if err != nil {
+		j.err = err
+		d.finish(j)
+		return
+	}
+	defer logFile.Close()
+
+	self, err := os.Executable()
+	if err != nil {
+		j.err = err
+		d.finish(j)
+		return
+	}
+	args := append([]string{"build"}, d.buildArgs...)
+	if job.BuildProfile != "" {
+		args = append(args, "-build-profile", job.BuildProfile)
+	}
+	var names []string
+	for name := range job.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-var", name+"="+job.Variables[name])
+	}
+	j.cmd = exec.Command(self, append(args, job.Spin)...)
+	j.cmd.Dir = dir
+	j.cmd.Stdout = logFile
+	j.cmd.Stderr = logFile
+	if j.err = j.cmd.Start(); j.err != nil {
+		d.finish(j)
+		return
+	}
+
+	d.jobs[job.ID] = j
+	d.setState(job, queue.JobRunning)
+	go func() {
+		j.err = j.cmd.Wait()
+		d.done <- j
+	}()
+}
+
+// finish will record the result of a job
+func (d *daemon) finish(j *daemonJob) {
+	delete(d.jobs, j.job.ID)
+	j.job.Held = false
+	d.spool.SetHeld(j.job.ID, false)
+	if j.err != nil {
+		log.WithFields(log.Fields{
+			"job":   j.job.ID,
+			"error": j.err,
+		}).Error("Build failed")
+		d.setState(j.job, queue.JobFailed)
+		return
+	}
+	d.setState(j.job, queue.JobSucceeded)
+}
@@ -0,0 +1,158 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"libuspin/queue"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// newTestDaemon returns a daemon with a single worker and an empty spool
+func newTestDaemon(t *testing.T) (*daemon, func()) {
+	dir, err := ioutil.TempDir("", "uspin-daemon")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	spool, err := queue.NewSpool(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Cannot create spool: %v", err)
+	}
+	d := &daemon{
+		spool:   spool,
+		queue:   queue.NewQueue(),
+		workers: 1,
+		jobs:    make(map[string]*daemonJob),
+		done:    make(chan *daemonJob, 1),
+	}
+	return d, func() { os.RemoveAll(dir) }
+}
+
+// exitedJob adds a job in the given state whose build has already exited
+func exitedJob(t *testing.T, d *daemon, id string, state queue.JobState) *daemonJob {
+	if err := os.MkdirAll(d.spool.JobDir(id), 00755); err != nil {
+		t.Fatalf("Cannot create job dir: %v", err)
+	}
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot run true: %v", err)
+	}
+	j := &daemonJob{
+		job: &queue.Job{ID: id, Priority: queue.PriorityExperimental, State: state},
+		cmd: cmd,
+	}
+	d.jobs[id] = j
+	return j
+}
+
+func TestScheduleExitedJob(t *testing.T) {
+	if resumeSignal == nil {
+		t.Skip("Builds cannot be paused on this host")
+	}
+	d, cleanup := newTestDaemon(t)
+	defer cleanup()
+	j := exitedJob(t, d, "paused", queue.JobPaused)
+
+	scheduled := make(chan struct{})
+	go func() {
+		d.schedule()
+		close(scheduled)
+	}()
+	select {
+	case <-scheduled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Scheduling an exited job should not spin forever")
+	}
+	if j.job.State != queue.JobPaused {
+		t.Fatalf("State of a job which cannot be signalled should not change: %v", j.job.State)
+	}
+	if err := d.signal(j, resumeSignal, queue.JobRunning); err == nil {
+		t.Fatalf("Signalling an exited job should fail")
+	}
+}
+
+func TestApplyHolds(t *testing.T) {
+	d, cleanup := newTestDaemon(t)
+	defer cleanup()
+	for _, id := range []string{"first", "second"} {
+		if err := os.MkdirAll(d.spool.JobDir(id), 00755); err != nil {
+			t.Fatalf("Cannot create job dir: %v", err)
+		}
+		d.queue.Push(&queue.Job{ID: id, Priority: queue.PriorityNightly, Submitted: time.Now()})
+	}
+	if err := d.spool.SetHeld("first", true); err != nil {
+		t.Fatalf("Cannot hold job: %v", err)
+	}
+	d.applyHolds()
+	if next := d.queue.Peek(); next == nil || next.ID != "second" || d.queue.Len() != 1 {
+		t.Fatalf("Held job should not be scheduled: %+v", next)
+	}
+	if len(d.held) != 1 || !d.held[0].Held {
+		t.Fatalf("Held job should be kept aside: %+v", d.held)
+	}
+
+	if err := d.spool.SetHeld("first", false); err != nil {
+		t.Fatalf("Cannot release job: %v", err)
+	}
+	d.applyHolds()
+	if next := d.queue.Peek(); next == nil || next.ID != "first" || next.Held || len(d.held) != 0 {
+		t.Fatalf("Released job should be scheduled first: %+v", next)
+	}
+}
+
+func TestHeldPausedJob(t *testing.T) {
+	if resumeSignal == nil {
+		t.Skip("Builds cannot be paused on this host")
+	}
+	d, cleanup := newTestDaemon(t)
+	defer cleanup()
+	if err := os.MkdirAll(d.spool.JobDir("paused"), 00755); err != nil {
+		t.Fatalf("Cannot create job dir: %v", err)
+	}
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("Cannot run sleep: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	j := &daemonJob{
+		job: &queue.Job{ID: "paused", Priority: queue.PriorityExperimental, State: queue.JobPaused},
+		cmd: cmd,
+	}
+	d.jobs["paused"] = j
+	if err := d.spool.SetHeld("paused", true); err != nil {
+		t.Fatalf("Cannot hold job: %v", err)
+	}
+	d.applyHolds()
+	d.schedule()
+	if j.job.State != queue.JobPaused || !j.job.Held {
+		t.Fatalf("Held job should not be resumed: %+v", j.job)
+	}
+
+	if err := d.spool.SetHeld("paused", false); err != nil {
+		t.Fatalf("Cannot release job: %v", err)
+	}
+	d.applyHolds()
+	d.schedule()
+	if j.job.State != queue.JobRunning || j.job.Held {
+		t.Fatalf("Released job should be resumed: %+v", j.job)
+	}
+}
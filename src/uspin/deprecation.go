@@ -0,0 +1,36 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+// summariseDeprecations will repeat any deprecation warnings from loading
+// the profile, as they're otherwise long gone from the terminal.
+func (s *USpin) summariseDeprecations() {
+	notices := s.spec.Config.Deprecations
+	if len(notices) == 0 {
+		return
+	}
+	for _, n := range notices {
+		n.Warn()
+	}
+	s.logImage.WithFields(log.Fields{
+		"count": len(notices),
+	}).Warning("Profile uses deprecated features, build with -strict to make them errors")
+}
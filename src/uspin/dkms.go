@@ -0,0 +1,45 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"libuspin/boot"
+)
+
+// buildDKMS will build the configured DKMS modules against every kernel
+func (s *USpin) buildDKMS() error {
+	var modules []*boot.DKMSModule
+	for _, id := range s.spec.Config.DKMS.Modules {
+		module, err := boot.NewDKMSModule(id)
+		if err != nil {
+			return err
+		}
+		modules = append(modules, module)
+	}
+	if len(modules) == 0 {
+		return nil
+	}
+	c := s.getChroot()
+	if err := c.Enter(); err != nil {
+		return err
+	}
+	err := boot.BuildDKMS(c, modules)
+	if lerr := c.Leave(); err == nil {
+		err = lerr
+	}
+	return err
+}
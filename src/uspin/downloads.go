@@ -0,0 +1,110 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"path/filepath"
+)
+
+var cmdDownloads = &Command{
+	Name:  "downloads",
+	Usage: "[flags] image.spin",
+	Short: "List or lock the downloads of the previous build",
+}
+
+func init() {
+	cmdDownloads.Run = runDownloads
+	registerCommand(cmdDownloads)
+}
+
+func runDownloads(args []string) error {
+	fs := cmdDownloads.flagSet()
+	lock := fs.Bool("lock", false, "Write the downloads to the lockfile, declaring them for future builds")
+	output := fs.String("o", "", "Lockfile to write, defaults to downloads.lockfile")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	spin, err := NewUSpin(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	plan, err := libuspin.LoadPlan(filepath.Join(spin.builder.GetWorkspace(), PlanFile))
+	if err != nil {
+		return err
+	}
+	if plan.Downloads == nil {
+		return errors.New("The previous build did not record its downloads")
+	}
+
+	lockfile := spin.spec.Config.Downloads.Lockfile
+	if *output != "" {
+		lockfile = *output
+	}
+	if *lock {
+		if lockfile == "" {
+			return errors.New("No lockfile configured, set downloads.lockfile or use -o")
+		}
+		return (&libuspin.DownloadLock{Downloads: plan.Downloads}).Write(spin.spec.JoinPath(lockfile))
+	}
+
+	for _, d := range plan.Downloads {
+		if d.SHA256 == "" {
+			fmt.Println(d.URL)
+			continue
+		}
+		fmt.Printf("%v %v %v\n", d.URL, d.Size, d.SHA256)
+	}
+	return nil
+}
+
+// verifyDownloads will check the downloads recorded in the stored plan against
+// the lockfile, failing the build if the profile is strict about them
+func (s *USpin) verifyDownloads() error {
+	conf := s.spec.Config.Downloads
+	if conf.Lockfile == "" {
+		return nil
+	}
+	lock, err := libuspin.LoadDownloadLock(s.spec.JoinPath(conf.Lockfile))
+	var plan *libuspin.Plan
+	if err == nil {
+		plan, err = libuspin.LoadPlan(filepath.Join(s.builder.GetWorkspace(), PlanFile))
+	}
+	if err != nil {
+		if conf.Strict {
+			return fmt.Errorf("Cannot verify downloads: %v", err)
+		}
+		s.logPackage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Cannot verify downloads")
+		return nil
+	}
+
+	problems := lock.Verify(plan.Downloads)
+	for _, problem := range problems {
+		s.logPackage.Warning(problem)
+	}
+	if len(problems) > 0 && conf.Strict {
+		return fmt.Errorf("%v downloads were not declared in %v", len(problems), conf.Lockfile)
+	}
+	return nil
+}
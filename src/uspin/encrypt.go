@@ -0,0 +1,61 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"strings"
+)
+
+var cmdEncrypt = &Command{
+	Name:  "encrypt",
+	Usage: "[flags] -recipient age1...",
+	Short: "Encrypt a value from stdin for use within a .spin file",
+}
+
+func init() {
+	cmdEncrypt.Run = runEncrypt
+	registerCommand(cmdEncrypt)
+}
+
+func runEncrypt(args []string) error {
+	fs := cmdEncrypt.flagSet()
+	recipient := fs.String("recipient", "", "age recipient (public key) able to decrypt the value")
+	secret := fs.Bool("secret", false, "Encrypt as a literal [secrets] value")
+	fs.Parse(args)
+
+	if *recipient == "" || fs.NArg() != 0 {
+		return errUsage
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	plaintext := strings.TrimRight(string(data), "\r\n")
+	if *secret {
+		plaintext = "value:" + plaintext
+	}
+	value, err := config.Encrypt([]byte(plaintext), *recipient)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
@@ -0,0 +1,88 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/backend"
+	"libuspin/events"
+	"libuspin/spec"
+)
+
+// emit will write the event if machine-readable events were requested
+func (s *USpin) emit(ev *events.Event) {
+	if s.events != nil {
+		s.emitted(s.events.Emit(ev))
+	}
+}
+
+// emitted will warn if an event could not be written
+func (s *USpin) emitted(err error) {
+	// Only once, rather than for every event of the build
+	if err != nil && !s.eventsFailed {
+		s.eventsFailed = true
+		s.logImage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to emit build events")
+	}
+}
+
+// transactionKind returns the kind of package manager transaction the
+// operations will become
+func transactionKind(op spec.Operation) backend.TransactionKind {
+	switch op.(type) {
+	case *spec.OpRepo:
+		return backend.TransactionAddRepo
+	case *spec.OpGroup:
+		return backend.TransactionInstallGroups
+	case *spec.OpRemove:
+		return backend.TransactionRemovePackages
+	default:
+		return backend.TransactionInstallPackages
+	}
+}
+
+// emitTransaction will report the progress through the operations of the
+// Packages file, once the block at index is applied
+func (s *USpin) emitTransaction(index int, opset *spec.OpSet) {
+	s.emit(&events.Event{
+		Kind: events.KindTransaction,
+		Fields: map[string]interface{}{
+			"operation": transactionKind(opset.Ops[0]),
+			"count":     len(opset.Ops),
+			"index":     index + 1,
+			"total":     len(s.spec.Stack.Blocks),
+		},
+	})
+}
+
+// emitPackages will report the size of the installed package set
+func (s *USpin) emitPackages(plan *libuspin.Plan) {
+	var size int64
+	for _, d := range plan.Downloads {
+		size += d.Size
+	}
+	s.emit(&events.Event{
+		Kind: events.KindPackages,
+		Fields: map[string]interface{}{
+			"installed":     len(plan.Versions),
+			"downloads":     len(plan.Downloads),
+			"downloadBytes": size,
+		},
+	})
+}
@@ -0,0 +1,100 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/failure"
+	"libuspin/process"
+	"libuspin/version"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FailureLogLines is the number of log lines kept from each stage for the
+// failure bundle
+const FailureLogLines = 200
+
+// recordLogs will start keeping recent log lines for the failure bundle
+func (s *USpin) recordLogs() {
+	s.logs = failure.NewLogRecorder(FailureLogLines)
+	log.AddHook(s.logs)
+}
+
+// profileName returns the name of a profile file within the failure bundle,
+// keeping its path relative to the .spin file. Files outside of the profile,
+// i.e. shared includes, are kept by their absolute path.
+func profileName(baseDir, path string) string {
+	rel, err := filepath.Rel(baseDir, path)
+	if rel = filepath.ToSlash(rel); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "profile/external" + filepath.ToSlash(path)
+	}
+	return "profile/" + rel
+}
+
+// writeFailureBundle will collect everything needed to diagnose the failed
+// build into a single archive within the bundle directory.
+func (s *USpin) writeFailureBundle(buildErr error) {
+	if s.bundleDir == "" {
+		return
+	}
+	b := failure.New()
+	b.Add("error.txt", []byte(buildErr.Error()+"\n"))
+
+	// Raw profile only, the loaded config may hold decrypted values
+	b.AddFile("profile/"+filepath.Base(s.spec.SpinFile), s.spec.SpinFile)
+	for _, path := range s.spec.SpecFiles {
+		b.AddFile(profileName(s.spec.BaseDir, path), path)
+	}
+
+	planPath := ""
+	if workspace := s.builder.GetWorkspace(); workspace != "" {
+		planPath = filepath.Join(workspace, PlanFile)
+	}
+	if _, err := os.Stat(planPath); err == nil {
+		b.AddFile(PlanFile, planPath)
+	} else {
+		var buf bytes.Buffer
+		libuspin.NewPlan(s.spec).WriteJSON(&buf)
+		b.Add(PlanFile, buf.Bytes())
+	}
+
+	if s.logs != nil {
+		s.logs.AddTo(b)
+	}
+	b.Add("commands.log", []byte(strings.Join(process.Traced(), "\n")+"\n"))
+	b.AddEnvironment(os.Environ())
+	b.AddHostInfo(version.Version)
+	b.Redact(s.secrets.Redact)
+
+	name := strings.TrimSuffix(filepath.Base(s.spec.SpinFile), ".spin")
+	path := filepath.Join(s.bundleDir, fmt.Sprintf("uspin-failure-%v-%v.tar.gz", name, time.Now().Format("20060102-150405")))
+	if err := b.Write(path); err != nil {
+		s.logImage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to write failure bundle")
+		return
+	}
+	s.logImage.WithFields(log.Fields{
+		"bundle": path,
+	}).Error("Build failed, please attach the failure bundle to bug reports")
+}
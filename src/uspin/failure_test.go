@@ -0,0 +1,34 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"testing"
+)
+
+func TestProfileName(t *testing.T) {
+	for path, want := range map[string]string{
+		"/srv/spins/desktop.packages":     "profile/desktop.packages",
+		"/srv/spins/common/base.packages": "profile/common/base.packages",
+		"/srv/common/base.packages":       "profile/external/srv/common/base.packages",
+		"/srv/spins-old/legacy.packages":  "profile/external/srv/spins-old/legacy.packages",
+	} {
+		if got := profileName("/srv/spins", path); got != want {
+			t.Fatalf("Incorrect name for %v: %v", path, got)
+		}
+	}
+}
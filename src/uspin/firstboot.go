@@ -0,0 +1,68 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/firstboot"
+	"libuspin/initsys"
+)
+
+// installFirstboot will generate the services for the deferred first boot tasks
+func (s *USpin) installFirstboot() error {
+	tasks := s.spec.Config.Firstboot
+	if s.spec.Config.Image.GrowRoot {
+		task, err := firstboot.GrowRootTask(s.builder.GetRootDir())
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	s.logImage.WithFields(log.Fields{
+		"tasks": len(tasks),
+	}).Info("Installing firstboot tasks")
+	sys, err := initsys.New(s.spec.Config.Image.Init)
+	if err != nil {
+		return err
+	}
+	return firstboot.Install(s.builder.GetRootDir(), tasks, sys)
+}
+
+// enableServices will enable the packaged services requested by the profile
+func (s *USpin) enableServices() error {
+	services := s.spec.Config.Image.Services
+	if len(services) == 0 {
+		return nil
+	}
+	sys, err := initsys.New(s.spec.Config.Image.Init)
+	if err != nil {
+		return err
+	}
+	for _, name := range services {
+		s.logImage.WithFields(log.Fields{
+			"service": name,
+			"init":    sys.Type(),
+		}).Info("Enabling service")
+		if err := sys.Enable(s.builder.GetRootDir(), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"libuspin"
+	"os"
+)
+
+var cmdGraph = &Command{
+	Name:  "graph",
+	Usage: "[flags] image.spin",
+	Short: "Emit the dependency graph of the resolved package set",
+}
+
+func init() {
+	cmdGraph.Run = runGraph
+	registerCommand(cmdGraph)
+}
+
+func runGraph(args []string) error {
+	fs := cmdGraph.flagSet()
+	format := fs.String("format", string(libuspin.GraphFormatDot), "Output format, dot or json")
+	variableFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	img, err := libuspin.NewImageSpec(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	plan := libuspin.NewPlan(img)
+	if err := queryPlan(img, plan, true, true); err != nil {
+		return err
+	}
+	return plan.WriteGraph(os.Stdout, libuspin.GraphFormat(*format))
+}
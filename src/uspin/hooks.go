@@ -0,0 +1,41 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"libuspin/hooks"
+	"path/filepath"
+)
+
+// runScripts will run the scripts of the profile for the phase, if any
+func (s *USpin) runScripts(phase hooks.Phase, scripts []string) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+	runner := &hooks.Runner{
+		Chroot:  s.getChroot(),
+		BaseDir: s.spec.BaseDir,
+	}
+	if phase == hooks.PhasePostImage {
+		image, err := filepath.Abs(s.spec.OutputFilename())
+		if err != nil {
+			return err
+		}
+		runner.Env = append(runner.Env, "USPIN_IMAGE="+image)
+	}
+	return runner.Run(phase, scripts)
+}
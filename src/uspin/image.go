@@ -16,6 +16,15 @@
 
 package main
 
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/hooks"
+	"libuspin/lint"
+	"libuspin/tree"
+	"os"
+	"path/filepath"
+)
+
 // StartImageBuild will perform all steps up until the point where it is time
 // for the pkg.Manager to step in and populate the rootfs.
 func (s *USpin) StartImageBuild() error {
@@ -26,6 +35,12 @@ func (s *USpin) StartImageBuild() error {
 		return err
 	}
 
+	// Artifacts are only moved into the output directory once complete
+	s.spec.Staging = filepath.Join(s.builder.GetWorkspace(), StagingDir)
+	if err = os.MkdirAll(s.spec.Staging, 00755); err != nil {
+		return err
+	}
+
 	s.logImage.Info("Creating storage")
 	if err = s.builder.CreateStorage(); err != nil {
 		return err
@@ -38,6 +53,56 @@ func (s *USpin) StartImageBuild() error {
 	return nil
 }
 
+// ConfigureRootfs will perform all steps on top of the installed packages,
+// such as the kernel, overlays, post-install scripts and permissions.
+func (s *USpin) ConfigureRootfs() error {
+	if err := s.installKernel(); err != nil {
+		return err
+	}
+
+	if err := s.buildDKMS(); err != nil {
+		return err
+	}
+
+	if err := s.applyOverlay(); err != nil {
+		return err
+	}
+
+	if err := s.enableServices(); err != nil {
+		return err
+	}
+
+	if err := s.installFirstboot(); err != nil {
+		return err
+	}
+
+	if err := s.runScripts(hooks.PhasePostInstall, s.spec.Config.Scripts.PostInstall); err != nil {
+		return err
+	}
+
+	if err := s.applyPermissions(); err != nil {
+		return err
+	}
+
+	if err := s.processLicenses(); err != nil {
+		return err
+	}
+
+	if err := s.writeManifest(); err != nil {
+		return err
+	}
+
+	if err := s.writeSBOM(); err != nil {
+		return err
+	}
+
+	ids := &s.spec.Config.IDs
+	s.logImage.WithFields(log.Fields{
+		"policy": ids.MachineID,
+	}).Info("Writing machine-id")
+	return s.spec.IDs.WriteMachineID(s.builder.GetRootDir(), ids.MachineID)
+}
+
 // FinishImageBuild will perform all the last steps required to finalize an
 // image for final "spin".
 func (s *USpin) FinishImageBuild() error {
@@ -46,9 +111,80 @@ func (s *USpin) FinishImageBuild() error {
 		return err
 	}
 
+	if err := s.runScripts(hooks.PhasePreCompress, s.spec.Config.Scripts.PreCompress); err != nil {
+		return err
+	}
+
+	// Last chance before the rootfs is sealed into the media
+	if err := s.excludePaths(); err != nil {
+		return err
+	}
+
+	if err := s.checkContamination(); err != nil {
+		return err
+	}
+
+	if err := s.clampTimes(); err != nil {
+		return err
+	}
+
+	if err := s.exportOutputs(); err != nil {
+		return err
+	}
+
 	if err := s.builder.UnmountStorage(); err != nil {
 		return err
 	}
 	s.logImage.Info("Finalizing image")
-	return s.builder.FinalizeImage()
+	if err := s.builder.FinalizeImage(); err != nil {
+		return err
+	}
+	if s.policy != nil {
+		if err := s.policy.CheckImage(s.spec.OutputFilename()); err != nil {
+			return err
+		}
+	}
+	return s.runScripts(hooks.PhasePostImage, s.spec.Config.Scripts.PostImage)
+}
+
+// checkContamination will fail the build if the host has leaked into the
+// rootfs, before it can make it into the media.
+func (s *USpin) checkContamination() error {
+	s.logImage.Info("Checking for host contamination")
+	issues, err := lint.HostContamination(s.builder.GetRootDir(), s.spec.Config.Image.Arch)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		s.logImage.WithFields(log.Fields{
+			"check": issue.Check,
+			"path":  issue.Path,
+		}).Error(issue.Message)
+	}
+	return lint.Error(issues)
+}
+
+// excludePaths will remove all excluded paths from the media, along with
+// any excluded classes of files the package manager could not filter.
+// Nothing else reads the rootfs after this point, so the plan, license
+// report and assets still reflect the complete rootfs.
+func (s *USpin) excludePaths() error {
+	patterns := s.spec.Config.Image.ExcludePatterns()
+	if len(patterns) == 0 {
+		return nil
+	}
+	s.logImage.Info("Excluding paths from image")
+	removed, err := tree.Exclude(s.builder.GetRootDir(), patterns)
+	if err != nil {
+		return err
+	}
+	for _, path := range removed {
+		s.logImage.WithFields(log.Fields{
+			"path": path,
+		}).Debug("Excluded path")
+	}
+	s.logImage.WithFields(log.Fields{
+		"paths": len(removed),
+	}).Info("Excluded paths from image")
+	return nil
 }
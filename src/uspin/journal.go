@@ -0,0 +1,110 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/disk"
+	"libuspin/journal"
+	"libuspin/lint"
+)
+
+// workspaceState returns the state of the workspace according to its journal
+func (s *USpin) workspaceState() (*journal.State, error) {
+	entries, err := journal.Load(s.builder.GetWorkspace())
+	if err != nil {
+		return nil, err
+	}
+	return journal.Replay(entries, stages[0].name), nil
+}
+
+// openJournal will start journalling the build. The workspace lock must be
+// held, so any build the journal claims is still running has died, and its
+// workspace is taken over.
+func (s *USpin) openJournal() error {
+	state, err := s.workspaceState()
+	if err != nil {
+		return err
+	}
+	if s.resume {
+		if err := s.resumeFrom(state); err != nil {
+			return err
+		}
+	}
+	s.journal = journal.Open(s.builder.GetWorkspace())
+	s.record(journal.EventBegin, "", nil)
+	if state.Interrupted() {
+		s.logImage.WithFields(log.Fields{
+			"pid":   state.PID,
+			"stage": state.Running,
+		}).Warning("Taking over the workspace of an interrupted build")
+		if err := unmountStale(s.builder.GetWorkspace()); err != nil {
+			return err
+		}
+		s.record(journal.EventTakeover, state.Running, nil)
+	}
+	return nil
+}
+
+// record will journal the event, which should never fail the build itself
+func (s *USpin) record(event journal.Event, stage string, failure error) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Record(event, stage, failure); err != nil {
+		s.logImage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to update the build journal")
+	}
+}
+
+// resumeFrom will select every stage from the first that did not complete
+// in the previous build.
+func (s *USpin) resumeFrom(state *journal.State) error {
+	if state.PID == 0 {
+		return errors.New("Nothing to resume, no previous build in the workspace")
+	}
+	for _, st := range stages {
+		if state.Done(st.name) {
+			continue
+		}
+		s.logImage.WithFields(log.Fields{
+			"stage": st.name,
+		}).Info("Resuming build")
+		return s.SelectStages("", "", st.name)
+	}
+	return errors.New("Nothing to resume, the previous build completed")
+}
+
+// unmountStale will unmount anything left mounted within the workspace by
+// a build that died, innermost first.
+func unmountStale(workspace string) error {
+	mounts, err := lint.MountsBeneath(workspace)
+	if err != nil {
+		return err
+	}
+	for i := len(mounts) - 1; i >= 0; i-- {
+		log.WithFields(log.Fields{
+			"target": mounts[i],
+		}).Warning("Unmounting stale mount")
+		if err := disk.Unmount(mounts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/boot"
+)
+
+// installKernel will build or extract the custom kernel, if configured
+func (s *USpin) installKernel() error {
+	conf := &s.spec.Config.Kernel
+	if !conf.Enabled() {
+		return nil
+	}
+	s.logImage.Info("Installing custom kernel")
+	c := s.getChroot()
+	if err := c.Enter(); err != nil {
+		return err
+	}
+	release, err := boot.NewCustomKernel(conf, s.spec.BaseDir).Install(c)
+	if lerr := c.Leave(); err == nil {
+		err = lerr
+	}
+	if err != nil {
+		return err
+	}
+	s.logImage.WithFields(log.Fields{
+		"release": release,
+	}).Info("Installed custom kernel")
+	return nil
+}
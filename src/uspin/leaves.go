@@ -0,0 +1,105 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/backend"
+	"os"
+	"path/filepath"
+)
+
+// LeavesFile is the name of the stored leaf report within the workspace
+const LeavesFile = "leaves.json"
+
+var cmdLeaves = &Command{
+	Name:  "leaves",
+	Usage: "[flags]",
+	Short: "List installed packages which nothing else depends on",
+}
+
+func init() {
+	cmdLeaves.Run = runLeaves
+	registerCommand(cmdLeaves)
+}
+
+func runLeaves(args []string) error {
+	fs := cmdLeaves.flagSet()
+	workspace := fs.String("workspace", "workspace", "Workspace of a previous build")
+	asJSON := fs.Bool("json", false, "Emit the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	report, err := libuspin.LoadLeafReport(filepath.Join(*workspace, LeavesFile))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.Write(os.Stdout)
+}
+
+// storeLeaves will find the leaves of the installed packages, and store the
+// report within the workspace for "uspin leaves". The stored plan provides
+// the packages requested by the profile.
+func (s *USpin) storeLeaves() error {
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+	lister, ok := query.(backend.PackageLister)
+	if !ok {
+		return backend.ErrUnsupportedQuery
+	}
+	resolver, ok := query.(backend.DependencyResolver)
+	if !ok {
+		return backend.ErrUnsupportedQuery
+	}
+	plan, err := libuspin.LoadPlan(filepath.Join(s.builder.GetWorkspace(), PlanFile))
+	if err != nil {
+		return err
+	}
+
+	installed, err := lister.InstalledPackages()
+	if err != nil {
+		return err
+	}
+	deps := make(map[string][]string)
+	for _, name := range installed {
+		if deps[name], err = resolver.Dependencies(name); err != nil {
+			return err
+		}
+	}
+	report := libuspin.NewLeafReport(installed, deps, plan.Packages())
+	if len(report.Orphans) > 0 {
+		s.logPackage.WithFields(log.Fields{
+			"orphans": len(report.Orphans),
+		}).Info("Automatically installed packages are no longer needed, see \"uspin leaves\"")
+	}
+
+	out, err := os.Create(filepath.Join(s.builder.GetWorkspace(), LeavesFile))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return report.WriteJSON(out)
+}
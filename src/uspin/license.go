@@ -0,0 +1,112 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin/backend"
+	"libuspin/license"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// processLicenses will collect the licenses of all installed packages, then
+// evaluate the license policy and write the license report, if configured.
+func (s *USpin) processLicenses() error {
+	conf := &s.spec.Config.Image
+	if conf.LicensePolicy == "" && conf.LicenseReport == "" {
+		return nil
+	}
+
+	s.logPackage.Info("Collecting package licenses")
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+	licenses, err := license.Collect(query)
+	if err != nil {
+		return err
+	}
+
+	if conf.LicensePolicy != "" {
+		if err := s.checkLicenses(licenses); err != nil {
+			return err
+		}
+	}
+	if conf.LicenseReport != "" {
+		return s.writeLicenseReport(licenses)
+	}
+	return nil
+}
+
+// checkLicenses will evaluate the installed packages against the license policy
+func (s *USpin) checkLicenses(licenses map[string][]string) error {
+	policy, err := license.LoadPolicy(s.spec.JoinPath(s.spec.Config.Image.LicensePolicy))
+	if err != nil {
+		return err
+	}
+
+	s.logPackage.Info("Checking license policy")
+	violations := policy.Check(licenses)
+	for _, v := range violations {
+		s.logPackage.WithFields(log.Fields{
+			"package": v.Package,
+			"license": v.License,
+		}).Warning(v.Reason)
+	}
+	if len(violations) > 0 && policy.Action == license.ActionFail {
+		return fmt.Errorf("License policy violated by %v package(s)", len(violations))
+	}
+	return nil
+}
+
+// writeLicenseReport will write the consolidated license report into the
+// rootfs, and alongside the resulting image.
+func (s *USpin) writeLicenseReport(licenses map[string][]string) error {
+	root := s.builder.GetRootDir()
+	report, err := license.Gather(root, licenses)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := license.WriteHTML(&buf, s.spec.Config.Branding.Title, report); err != nil {
+		return err
+	}
+
+	target := filepath.Join(root, strings.TrimPrefix(s.spec.Config.Image.LicenseReport, "/"))
+	if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(target, buf.Bytes(), 00644); err != nil {
+		return err
+	}
+
+	output, err := filepath.Abs(s.spec.OutputFilename() + ".licenses.html")
+	if err != nil {
+		return err
+	}
+	s.logPackage.WithFields(log.Fields{
+		"report": output,
+	}).Info("Wrote license report")
+	return ioutil.WriteFile(output, buf.Bytes(), 00644)
+}
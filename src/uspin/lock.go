@@ -0,0 +1,55 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"libuspin/lock"
+	"sort"
+)
+
+// acquireLocks will lock the workspace and any writable host directories
+// mounted into the chroot, such as a shared package cache, for the duration
+// of the build. Locks are always taken in the same order.
+func (s *USpin) acquireLocks() error {
+	resources := []string{s.builder.GetWorkspace()}
+	var mounts []string
+	for _, mount := range s.spec.Config.Mounts {
+		if !mount.ReadOnly {
+			mounts = append(mounts, s.spec.JoinPath(mount.Source))
+		}
+	}
+	sort.Strings(mounts)
+	resources = append(resources, mounts...)
+
+	for _, resource := range resources {
+		l, err := lock.Acquire(resource)
+		if err != nil {
+			s.releaseLocks()
+			return err
+		}
+		s.locks = append(s.locks, l)
+	}
+	return nil
+}
+
+// releaseLocks will release all locks held by the build, in reverse order
+func (s *USpin) releaseLocks() {
+	for i := len(s.locks) - 1; i >= 0; i-- {
+		s.locks[i].Release()
+	}
+	s.locks = nil
+}
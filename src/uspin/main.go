@@ -18,20 +18,41 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/solus-project/libosdev/pkg"
 	"libuspin"
+	"libuspin/backend"
 	"libuspin/build"
+	"libuspin/checkpoint"
+	"libuspin/chroot"
+	"libuspin/config"
+	"libuspin/deprecation"
+	"libuspin/events"
+	"libuspin/failure"
+	"libuspin/journal"
+	"libuspin/lock"
+	"libuspin/policy"
+	"libuspin/process"
+	"libuspin/queue"
+	"libuspin/remote"
+	"libuspin/secrets"
+	"libuspin/signature"
 	"os"
+	"sort"
+	"time"
 )
 
+// logFormatter is the main logger formatting used in USpin
+var logFormatter = &log.TextFormatter{
+	FullTimestamp:   true,
+	TimestampFormat: "15:04:05",
+}
+
 // Set up the main logger formatting used in USpin
 func init() {
-	form := &log.TextFormatter{}
-	form.FullTimestamp = true
-	form.TimestampFormat = "15:04:05"
-	log.SetFormatter(form)
+	log.SetFormatter(logFormatter)
 	log.SetLevel(log.DebugLevel)
 }
 
@@ -43,12 +64,34 @@ type USpin struct {
 	builder  build.Builder
 	packager pkg.Manager
 	spec     *libuspin.ImageSpec
+	secrets  *secrets.Store
+	control  *process.Controller
+	chroot   *chroot.Chroot
+	outputs  []string // Delivered artifacts, image first
+	release  string   // Name of the published release, if any
+	stages   []bool   // Selected build stages, all if nil
+
+	logs         *failure.LogRecorder
+	journal      *journal.Journal
+	resume       bool // Continue from the first stage the previous build didn't complete
+	heartbeat    *process.Heartbeat
+	events       *events.Emitter // Machine-readable progress, if requested
+	eventsFailed bool            // A failure to emit has already been reported
+	bundleDir    string          // Failure bundles are written here, disabled if empty
+	policy       *policy.Policy
+	locks        []*lock.Lock
+
+	checkpoint     bool              // Snapshot the rootfs after the expensive stages
+	checkpoints    *checkpoint.Store // Opened once the workspace is known
+	checkpointKeys map[string]string // Inputs of each checkpointed stage
 }
 
 // NewUSpin will return a new USpin instance which stores global
 // state for the duration of an image spin process.
 func NewUSpin(path string) (*USpin, error) {
-	ret := &USpin{}
+	ret := &USpin{
+		control: process.NewController(),
+	}
 	var err error
 
 	// Attempt to get the image spec first
@@ -56,6 +99,13 @@ func NewUSpin(path string) (*USpin, error) {
 		return nil, err
 	}
 
+	// Resolve secrets up front and ensure they never reach the logs
+	if ret.secrets, err = secrets.NewStore(ret.spec.Config.Secrets, ret.spec.BaseDir); err != nil {
+		return nil, err
+	}
+	ret.secrets.Add(ret.spec.Config.Decrypted()...)
+	log.SetFormatter(secrets.NewFormatter(logFormatter, ret.secrets))
+
 	// Get a builder
 	buildType := ret.spec.Config.Image.Type
 	if ret.builder, err = build.NewBuilder(buildType); err != nil {
@@ -65,11 +115,13 @@ func NewUSpin(path string) (*USpin, error) {
 	// Get our image log
 	ret.logImage = log.WithFields(log.Fields{"imageType": buildType})
 
-	// TODO: Stop hardcoding this!
-	pkgType := pkg.PackageManagerEopkg
-
 	// Get our package manager
-	if ret.packager, err = pkg.NewManager(pkgType); err != nil {
+	pkgType := ret.spec.Config.Image.PackageManager
+	pkgBackend, err := backend.Lookup(pkgType)
+	if err != nil {
+		return nil, err
+	}
+	if ret.packager, err = pkgBackend.NewManager(ret.spec.Config, ret.spec.BaseDir); err != nil {
 		return nil, err
 	}
 
@@ -79,6 +131,12 @@ func NewUSpin(path string) (*USpin, error) {
 	return ret, nil
 }
 
+// A loggedError was logged where it happened, and is only returned so that
+// the exit status reflects it
+type loggedError struct {
+	error
+}
+
 func printUsage(exitCode int) {
 	var fd *os.File
 	if exitCode == 0 {
@@ -87,21 +145,140 @@ func printUsage(exitCode int) {
 		fd = os.Stderr
 	}
 
-	fmt.Fprintf(fd, "%s [image.spin]\n", os.Args[0])
+	fmt.Fprintf(fd, "%s [command] [arguments]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(fd, "    %-12s %s\n", name, subcommands[name].Short)
+	}
 	os.Exit(exitCode)
 }
 
+// buildImage is the default command, spinning the given .spin file
+func buildImage(args []string) error {
+	fs := cmdBuild.flagSet()
+	strict := fs.Bool("strict", false, "Fail if the profile uses deprecated features")
+	only := fs.String("only", "", "Only run these stages, comma separated")
+	skip := fs.String("skip", "", "Skip these stages, comma separated")
+	from := fs.String("from", "", "Start from this stage, reusing the previous workspace")
+	bundleDir := fs.String("failure-bundle", ".", "Directory for diagnostics of failed builds, empty to disable")
+	resume := fs.Bool("resume", false, "Continue from the first stage the previous build didn't complete")
+	checkpoint := fs.Bool("checkpoint", false, "Snapshot the rootfs after installing and configuring it, restoring unchanged snapshots")
+	heartbeat := fs.Duration("heartbeat", time.Minute, "Log progress after this long without output, 0 to disable")
+	verify := fs.String("verify-signature", "", "Require the profile to be signed, either detached or git-tag")
+	keyring := fs.String("keyring", "", "Keyring trusted for detached profile signatures")
+	dryRun := fs.Bool("dry-run", false, "Print the plan of the build without building, as \"uspin plan\" does")
+	eventsTarget := fs.String("events", "", "Write progress events as JSON lines to a file, unix:/path or tcp:host:port")
+	buildProfile := fs.String("build-profile", "", "Use the defaults of a build profile: fast, small or reproducible")
+	remoteTarget := fs.String("remote", "", "Build with the daemon on this host, authenticated by $"+remote.TokenEnv)
+	priorityName := fs.String("priority", string(queue.PriorityExperimental), "Priority of a remote build: release, nightly or experimental")
+	variableFlags(fs)
+	fs.Parse(args)
+	config.SelectedBuildProfile = config.BuildProfile(*buildProfile)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	if *dryRun {
+		return runPlan(fs.Args())
+	}
+	method, err := signature.ParseMethod(*verify)
+	if err != nil {
+		return err
+	}
+	pol, err := policy.Load(policy.DefaultFile)
+	if err != nil {
+		return err
+	}
+	// The organisation policy cannot be weakened from the command line
+	if pol.RequireSignature != signature.MethodNone {
+		method = pol.RequireSignature
+		*keyring = pol.Keyring
+	}
+	// Nothing from an untrusted profile may be acted upon, including secrets
+	if err := verifyProfile(fs.Arg(0), method, *keyring); err != nil {
+		return err
+	}
+	if *remoteTarget != "" {
+		if *only != "" || *skip != "" || *from != "" || *resume || *checkpoint {
+			return errors.New("-remote cannot be combined with -only, -skip, -from, -resume or -checkpoint")
+		}
+		priority, err := queue.ParsePriority(*priorityName)
+		if err != nil {
+			return err
+		}
+		return buildRemote(fs.Arg(0), *remoteTarget, priority)
+	}
+	spin, err := NewUSpin(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *strict {
+		if err := deprecation.Error(spin.spec.Config.Deprecations); err != nil {
+			return err
+		}
+	}
+	if err := spin.SelectStages(*only, *skip, *from); err != nil {
+		return err
+	}
+	spin.bundleDir = *bundleDir
+	spin.policy = pol
+	if *resume && (*only != "" || *skip != "" || *from != "") {
+		return errors.New("-resume cannot be combined with -only, -skip or -from")
+	}
+	spin.resume = *resume
+	spin.checkpoint = *checkpoint
+	spin.heartbeat = process.NewHeartbeat(*heartbeat)
+	if *eventsTarget != "" {
+		if spin.events, err = events.Open(*eventsTarget); err != nil {
+			return err
+		}
+	}
+	// Allow ^Z / SIGTSTP to suspend the whole build
+	spin.control.HandleSignals()
+	return spin.Build()
+}
+
+var cmdBuild = &Command{
+	Name:  "build",
+	Usage: "[flags] image.spin",
+	Short: "Build the image described by the .spin file",
+}
+
+func init() {
+	cmdBuild.Run = buildImage
+	registerCommand(cmdBuild)
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage(1)
 	}
 
-	spin, err := NewUSpin(os.Args[1])
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+	switch os.Args[1] {
+	case "-h", "--help", "help":
+		printUsage(0)
 	}
-	if err := spin.Build(); err != nil {
+
+	// Retain support for plain "uspin image.spin"
+	cmd, ok := subcommands[os.Args[1]]
+	args := os.Args[2:]
+	if !ok {
+		cmd = subcommands["build"]
+		args = os.Args[1:]
+	}
+
+	if err := cmd.Run(args); err != nil {
+		if err == errUsage {
+			cmd.printUsage()
+			os.Exit(1)
+		}
+		if _, ok := err.(loggedError); !ok {
+			log.Error(err)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,115 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/backend"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// plannedGroups returns the group expansions of the plan stored in the
+// workspace by the rootfs stage, if any
+func (s *USpin) plannedGroups() (map[string][]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.builder.GetWorkspace(), PlanFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only the expansions are needed, as written by libuspin.Plan
+	var plan struct {
+		Expansions map[string][]string `json:"groupExpansions"`
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, err
+	}
+	return plan.Expansions, nil
+}
+
+// packageManifest will list the packages installed within the rootfs, and
+// the packages each group expanded to
+func (s *USpin) packageManifest() (*libuspin.PackageManifest, error) {
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+	manifest, err := libuspin.NewPackageManifest(query)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Groups, err = s.plannedGroups(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeManifest will record every package installed within the image,
+// alongside the image and within it when image.manifest is set. Without
+// image.manifest, package managers unable to list their packages are skipped.
+func (s *USpin) writeManifest() error {
+	manifest, err := s.packageManifest()
+	if err == backend.ErrUnsupportedQuery && s.spec.Config.Image.Manifest == "" {
+		s.logPackage.Debug("Package manager cannot list packages, skipping manifest")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var text, data bytes.Buffer
+	if err := manifest.Write(&text); err != nil {
+		return err
+	}
+	if err := manifest.WriteJSON(&data); err != nil {
+		return err
+	}
+
+	if conf := s.spec.Config.Image.Manifest; conf != "" {
+		target := filepath.Join(s.builder.GetRootDir(), strings.TrimPrefix(conf, "/"))
+		if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target+".txt", text.Bytes(), 00644); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target+".json", data.Bytes(), 00644); err != nil {
+			return err
+		}
+	}
+
+	output, err := filepath.Abs(s.spec.OutputFilename() + ".packages")
+	if err != nil {
+		return err
+	}
+	s.logPackage.WithFields(log.Fields{
+		"manifest": output + ".json",
+		"packages": len(manifest.Packages),
+	}).Info("Wrote package manifest")
+	if err := ioutil.WriteFile(output+".txt", text.Bytes(), 00644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(output+".json", data.Bytes(), 00644)
+}
@@ -0,0 +1,65 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/config"
+)
+
+var cmdMigrate = &Command{
+	Name:  "migrate",
+	Usage: "[flags] image.spin...",
+	Short: "Upgrade .spin files to the current format in place",
+}
+
+func init() {
+	cmdMigrate.Run = runMigrate
+	registerCommand(cmdMigrate)
+}
+
+func runMigrate(args []string) error {
+	fs := cmdMigrate.flagSet()
+	dryRun := fs.Bool("dry-run", false, "Only report the changes that would be made")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errUsage
+	}
+	for _, path := range fs.Args() {
+		from, notes, err := config.MigrateFile(path, *dryRun)
+		if err != nil {
+			return err
+		}
+		fields := log.Fields{
+			"file":   path,
+			"format": from,
+		}
+		if from == config.CurrentFormat {
+			log.WithFields(fields).Info("Already using the current format")
+			continue
+		}
+		for _, n := range notes {
+			log.WithFields(fields).Info(n)
+		}
+		if *dryRun {
+			continue
+		}
+		log.WithFields(fields).Infof("Upgraded to format %v, original kept as %v.bak", config.CurrentFormat, path)
+	}
+	return nil
+}
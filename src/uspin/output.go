@@ -0,0 +1,139 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/build"
+	"libuspin/config"
+	"libuspin/publish"
+	"libuspin/spec"
+	"libuspin/tree"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StagingDir is the workspace directory artifacts are written to
+const StagingDir = "output"
+
+// DeliverOutputs will move the finished artifacts into the output directory
+func (s *USpin) DeliverOutputs() error {
+	outputs, err := publish.Deliver(s.spec, time.Now())
+	if err != nil {
+		return err
+	}
+	s.outputs = outputs
+	for _, output := range outputs {
+		s.logImage.WithFields(log.Fields{
+			"output": output,
+		}).Info("Delivered artifact")
+	}
+	return nil
+}
+
+// exportOutputs will build the additional outputs of the profile from the
+// finished rootfs, before it is sealed into the image
+func (s *USpin) exportOutputs() error {
+	for _, format := range s.spec.Config.Image.Outputs {
+		if err := s.exportOutput(format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportOutput will build a single additional output, from a snapshot of the
+// rootfs if the output overrides the image
+func (s *USpin) exportOutput(format config.OutputFormat) error {
+	rootfs := s.builder.GetRootDir()
+	if override := s.spec.Config.Output[string(format)]; override != nil {
+		snapshot, err := s.snapshotOutput(format, override)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(snapshot)
+		rootfs = snapshot
+	}
+	s.logImage.WithFields(log.Fields{
+		"format": format,
+	}).Info("Exporting rootfs")
+	output, err := build.Export(s.spec, format, rootfs, s.builder.GetWorkspace())
+	if err != nil {
+		return err
+	}
+	s.logImage.WithFields(log.Fields{
+		"output": output,
+	}).Info("Exported rootfs")
+	return nil
+}
+
+// snapshotOutput will copy the rootfs for the output and apply its overrides
+// to the copy, returning its path
+func (s *USpin) snapshotOutput(format config.OutputFormat, o *config.SectionOutput) (string, error) {
+	root := filepath.Join(s.builder.GetWorkspace(), build.ExportDir, string(format)+".rootfs")
+	if err := os.RemoveAll(root); err != nil {
+		return "", err
+	}
+	s.logImage.WithFields(log.Fields{
+		"format": format,
+	}).Info("Snapshotting rootfs")
+	if err := tree.Copy(s.builder.GetRootDir(), root); err != nil {
+		return "", err
+	}
+	if o.HasPackages() {
+		if err := s.applyOutputPackages(root, o); err != nil {
+			return "", err
+		}
+	}
+	if len(o.Exclude) > 0 {
+		if _, err := tree.Exclude(root, o.Exclude); err != nil {
+			return "", err
+		}
+	}
+	return root, nil
+}
+
+// applyOutputPackages will install and then remove the packages of the
+// output within the snapshot
+func (s *USpin) applyOutputPackages(root string, o *config.SectionOutput) error {
+	defer func() {
+		if err := s.packager.Cleanup(); err != nil {
+			s.logPackage.Error(err)
+		}
+	}()
+	if err := s.packager.InitRoot(root); err != nil {
+		return err
+	}
+	var install, remove []spec.Operation
+	for _, name := range o.Install {
+		install = append(install, &spec.OpPackage{Name: name})
+	}
+	for _, name := range o.Remove {
+		remove = append(remove, &spec.OpRemove{Name: name})
+	}
+	for _, ops := range [][]spec.Operation{install, remove} {
+		if len(ops) == 0 {
+			continue
+		}
+		if err := libuspin.ApplyOperations(s.packager, ops, s.spec.Config.Image.BatchSize); err != nil {
+			return err
+		}
+	}
+	return s.packager.FinalizeRoot()
+}
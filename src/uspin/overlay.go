@@ -0,0 +1,79 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/overlay"
+	"os"
+)
+
+// applyOverlay will install the profile's overlay directory into the rootfs
+func (s *USpin) applyOverlay() error {
+	conf := &s.spec.Config.Image
+	if conf.Overlay == "" {
+		return nil
+	}
+	dir := s.spec.JoinPath(conf.Overlay)
+
+	// The sidecar is optional unless explicitly configured
+	var meta *overlay.Metadata
+	metaPath := dir + overlay.MetaSuffix
+	if conf.OverlayMeta != "" {
+		metaPath = s.spec.JoinPath(conf.OverlayMeta)
+	}
+	if _, err := os.Stat(metaPath); err == nil || conf.OverlayMeta != "" {
+		var err error
+		if meta, err = overlay.LoadMetadata(metaPath); err != nil {
+			return err
+		}
+	}
+
+	s.logImage.WithFields(log.Fields{
+		"overlay": dir,
+	}).Info("Applying overlay")
+	return overlay.Apply(dir, s.builder.GetRootDir(), meta)
+}
+
+// applyPermissions will apply the declared permission rules to the rootfs,
+// reporting any conflicts between them.
+func (s *USpin) applyPermissions() error {
+	rules := s.spec.Config.Permissions
+	if len(rules) == 0 {
+		return nil
+	}
+	s.logImage.Info("Applying permission rules")
+	report, err := overlay.ApplyPermissions(s.builder.GetRootDir(), rules)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range report.Conflicts {
+		s.logImage.WithFields(log.Fields{
+			"path":      conflict.Path,
+			"attribute": conflict.Attribute,
+		}).Warning(conflict)
+	}
+	for _, path := range report.Unmatched {
+		s.logImage.WithFields(log.Fields{
+			"path": path,
+		}).Warning("Permission rule matched nothing")
+	}
+	s.logImage.WithFields(log.Fields{
+		"paths": report.Applied,
+	}).Info("Applied permission rules")
+	return nil
+}
@@ -17,12 +17,26 @@
 package main
 
 import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
 	"libuspin"
+	"libuspin/backend"
+	"libuspin/config"
+	"libuspin/policy"
+	"libuspin/spec"
+	"os"
+	"path/filepath"
 )
 
+// PlanFile is the name of the stored plan within the workspace
+const PlanFile = "plan.json"
+
 // InstallPackages will install all required packages into the rootfs
 func (s *USpin) InstallPackages() error {
 	s.logPackage.Info("Applying operations")
+	if err := s.checkSafety(); err != nil {
+		return err
+	}
 
 	// First thing first, ensure that it always cleans up within this context,
 	// so that we know it's done before we return to building the image
@@ -32,16 +46,67 @@ func (s *USpin) InstallPackages() error {
 		}
 	}()
 
+	if err := s.applySeed(); err != nil {
+		return err
+	}
+
 	// Attempt to init root now
 	s.logPackage.Info("Initialising root with package manager")
 	if err := s.packager.InitRoot(s.builder.GetRootDir()); err != nil {
 		return err
 	}
+	if err := s.filterFiles(); err != nil {
+		return err
+	}
+	if err := s.bootstrapRoot(); err != nil {
+		return err
+	}
+	if err := s.seedPackageCache(); err != nil {
+		s.logPackage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to seed packages from the cache")
+	}
 
-	for _, opset := range s.spec.Stack.Blocks {
-		if err := libuspin.ApplyOperations(s.packager, opset.Ops); err != nil {
+	for i, opset := range s.spec.Stack.Blocks {
+		if err := s.resolveComponents(opset.Ops); err != nil {
+			return err
+		}
+		if err := libuspin.ApplyOperations(s.packager, opset.Ops, s.spec.Config.Image.BatchSize); err != nil {
 			return err
 		}
+		s.emitTransaction(i, opset)
+	}
+
+	if err := s.checkDatabase(); err != nil {
+		return err
+	}
+
+	// Record what was installed and why, for "uspin why"
+	if err := s.storePlan(); err != nil {
+		s.logPackage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to record installation plan")
+	}
+	if err := s.storeLeaves(); err != nil {
+		s.logPackage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to report leaf packages")
+	}
+
+	// Must happen before finalizing, which empties the package cache
+	if err := s.verifyDownloads(); err != nil {
+		return err
+	}
+
+	if err := s.checkPolicyPackages(); err != nil {
+		return err
+	}
+
+	// Must happen before finalizing, which empties the package cache
+	if err := s.harvestPackageCache(); err != nil {
+		s.logPackage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to store packages in the cache")
 	}
 
 	s.logPackage.Info("Finalizing package operations")
@@ -50,3 +115,202 @@ func (s *USpin) InstallPackages() error {
 	}
 	return nil
 }
+
+// checkSafety will refuse to build a profile ignoring package manager safety
+// if the safety policy is enforced
+func (s *USpin) checkSafety() error {
+	conf := &s.spec.Config.Safety
+	bypasses := libuspin.NewPlan(s.spec).SafetyBypasses(nil)
+	if conf.Policy != config.SafetyEnforce || len(bypasses) == 0 {
+		return nil
+	}
+	for _, bypass := range bypasses {
+		s.logPackage.Error(bypass)
+	}
+	return fmt.Errorf("%v steps ignore safety, which safety.policy forbids", len(bypasses))
+}
+
+// filterFiles will have the package manager leave out the excluded classes
+// of files while installing, where it can
+func (s *USpin) filterFiles() error {
+	classes := s.spec.Config.Image.ExcludeClasses
+	filter, ok := s.packager.(backend.FileFilter)
+	if len(classes) == 0 || !ok {
+		return nil
+	}
+	return filter.FilterFiles(classes)
+}
+
+// checkDatabase will fail the build if the package database of the root is
+// inconsistent. Problems with the packages of steps which deliberately
+// ignored safety are only warned about, anything else is corruption.
+func (s *USpin) checkDatabase() error {
+	checker, ok := s.packager.(backend.DatabaseChecker)
+	if !ok {
+		return nil
+	}
+	s.logPackage.Info("Checking package database")
+	err := checker.CheckDatabase()
+	if err == nil {
+		return nil
+	}
+	dbErr, ok := err.(*backend.DatabaseError)
+	if !ok {
+		return fmt.Errorf("Package database is inconsistent: %v", err)
+	}
+	plan := libuspin.NewPlan(s.spec)
+	if err := s.expandBypassedGroups(plan); err != nil {
+		return err
+	}
+	unexplained := plan.UnexplainedProblems(dbErr.Problems)
+	if len(unexplained) > 0 {
+		return fmt.Errorf("Package database is inconsistent: %v", &backend.DatabaseError{Problems: unexplained})
+	}
+	for _, problem := range dbErr.Problems {
+		s.logPackage.WithFields(log.Fields{
+			"package": problem.Package,
+			"problem": problem.Message,
+		}).Warning("Package database is inconsistent, as the package ignored safety")
+	}
+	return nil
+}
+
+// expandBypassedGroups will expand the groups of the plan when any of them
+// ignored safety, so that their packages are known. Groups stay unexpanded,
+// and so not exempt, if the backend cannot expand them.
+func (s *USpin) expandBypassedGroups(plan *libuspin.Plan) error {
+	needed := false
+	for _, step := range plan.Steps {
+		if step.IgnoreSafety && step.Kind == libuspin.PlanStepGroup {
+			needed = true
+		}
+	}
+	if !needed {
+		return nil
+	}
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+	expander, ok := query.(backend.GroupExpander)
+	if !ok {
+		return nil
+	}
+	return plan.ExpandGroups(expander)
+}
+
+// reportSafety will warn about every step that ignored safety, once the
+// essential packages it affected are known
+func (s *USpin) reportSafety(bypasses []*libuspin.SafetyBypass) {
+	if s.spec.Config.Safety.Policy != config.SafetyWarn {
+		return
+	}
+	for _, bypass := range bypasses {
+		s.logPackage.WithFields(log.Fields{
+			"step":      bypass.Step,
+			"names":     bypass.Names,
+			"essential": bypass.Essential,
+		}).Warning("Package manager safety was ignored")
+	}
+}
+
+// resolveComponents will find the child components of any groups in ops,
+// using the repositories already enabled in the rootfs
+func (s *USpin) resolveComponents(ops []spec.Operation) error {
+	needed := false
+	for _, op := range ops {
+		if group, ok := op.(*spec.OpGroup); ok && group.Children {
+			needed = true
+		}
+	}
+	if !needed {
+		return nil
+	}
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+	lister, ok := query.(backend.ComponentLister)
+	if !ok {
+		return backend.ErrUnsupportedQuery
+	}
+	return libuspin.ResolveComponents(ops, lister)
+}
+
+// storePlan will query the populated rootfs for group contents & dependencies,
+// storing the resulting plan in the workspace.
+func (s *USpin) storePlan() error {
+	plan := libuspin.NewPlan(s.spec)
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	if expander, ok := query.(backend.GroupExpander); ok {
+		if err := plan.ExpandGroups(expander); err != nil {
+			return err
+		}
+	}
+	if resolver, ok := query.(backend.DependencyResolver); ok {
+		if err := plan.ResolveDependencies(resolver); err != nil {
+			return err
+		}
+	}
+	if versions, ok := query.(backend.VersionReporter); ok {
+		if err := plan.ResolveVersions(versions); err != nil {
+			return err
+		}
+	}
+	if expander, ok := query.(backend.GroupExpander); ok {
+		if err := plan.RecordSafety(s.spec.Config.Safety.Essential, expander); err != nil {
+			return err
+		}
+		s.reportSafety(plan.Safety)
+	}
+	if downloads, ok := query.(backend.DownloadReporter); ok {
+		if err := plan.RecordDownloads(downloads); err != nil {
+			return err
+		}
+	}
+
+	s.emitPackages(plan)
+
+	out, err := os.Create(filepath.Join(s.builder.GetWorkspace(), PlanFile))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return plan.WriteJSON(out)
+}
+
+// checkPolicyPackages will fail the build if the installed packages violate
+// the organisation policy
+func (s *USpin) checkPolicyPackages() error {
+	if s.policy == nil || (len(s.policy.ForbiddenPackages) == 0 && len(s.policy.RequiredPackages) == 0) {
+		return nil
+	}
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+	lister, ok := query.(backend.PackageLister)
+	if !ok {
+		return backend.ErrUnsupportedQuery
+	}
+	installed, err := lister.InstalledPackages()
+	if err != nil {
+		return err
+	}
+	problems := s.policy.CheckPackages(installed)
+	for _, problem := range problems {
+		s.logPackage.Error(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("The image violates the policy in %v", policy.DefaultFile)
+	}
+	return nil
+}
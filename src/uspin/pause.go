@@ -0,0 +1,100 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/queue"
+	"libuspin/remote"
+	"os"
+)
+
+var cmdPause = &Command{
+	Name:  "pause",
+	Usage: "[flags] job",
+	Short: "Pause a daemon build at its next stage boundary",
+}
+
+var cmdResume = &Command{
+	Name:  "resume",
+	Usage: "[flags] job",
+	Short: "Resume a daemon build paused with \"uspin pause\"",
+}
+
+func init() {
+	cmdPause.Run = func(args []string) error { return runHold(cmdPause, args, true) }
+	cmdResume.Run = func(args []string) error { return runHold(cmdResume, args, false) }
+	registerCommand(cmdPause)
+	registerCommand(cmdResume)
+}
+
+// holdJob will pause or resume the job through the spool of the local daemon
+func holdJob(spoolDir, id string, held bool) (*queue.Job, error) {
+	spool, err := queue.NewSpool(spoolDir)
+	if err != nil {
+		return nil, err
+	}
+	job, err := spool.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.State == queue.JobSucceeded || job.State == queue.JobFailed {
+		return nil, fmt.Errorf("Job %v has already %v", id, job.State)
+	}
+	if err := spool.SetHeld(id, held); err != nil {
+		return nil, err
+	}
+	job.Held = held
+	return job, nil
+}
+
+// runHold will ask the daemon, local or remote, to pause or resume the job
+func runHold(cmd *Command, args []string, held bool) error {
+	fs := cmd.flagSet()
+	spoolDir := fs.String("spool", DefaultSpoolDir, "Spool directory shared with the daemon")
+	target := fs.String("remote", "", "Daemon on this host, authenticated by $"+remote.TokenEnv)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	var job *queue.Job
+	var err error
+	if *target != "" {
+		client, cerr := remote.NewClient(*target, os.Getenv(remote.TokenEnv))
+		if cerr != nil {
+			return cerr
+		}
+		if held {
+			job, err = client.Pause(fs.Arg(0))
+		} else {
+			job, err = client.Resume(fs.Arg(0))
+		}
+	} else {
+		job, err = holdJob(*spoolDir, fs.Arg(0), held)
+	}
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"job":   job.ID,
+		"state": job.State,
+		"held":  job.Held,
+	}).Info("Request sent to the daemon")
+	return nil
+}
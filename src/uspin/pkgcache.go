@@ -0,0 +1,140 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/backend"
+	"libuspin/pkgcache"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// packageCache will open the shared package cache, returning nil if it is
+// disabled or the package manager keeps no packages within the root
+func (s *USpin) packageCache() (*pkgcache.Cache, string, error) {
+	conf := s.spec.Config.PackageCache
+	if conf.Directory == "" {
+		return nil, "", nil
+	}
+	cacher, ok := s.packager.(backend.PackageCacher)
+	if !ok {
+		return nil, "", nil
+	}
+	cache, err := pkgcache.Open(s.spec.JoinPath(conf.Directory))
+	if err != nil {
+		return nil, "", err
+	}
+	return cache, filepath.Join(s.builder.GetRootDir(), cacher.PackageCache()), nil
+}
+
+// seedEntries returns the packages expected to be needed by this build, being
+// those declared in the lockfile, or those used by the previous build
+func (s *USpin) seedEntries(cache *pkgcache.Cache) ([]*pkgcache.Entry, error) {
+	lockfile := s.spec.Config.Downloads.Lockfile
+	if lockfile == "" {
+		return cache.Manifest(s.spec.Profile)
+	}
+	lock, err := libuspin.LoadDownloadLock(s.spec.JoinPath(lockfile))
+	if err != nil {
+		return nil, err
+	}
+	var ret []*pkgcache.Entry
+	for _, d := range lock.Downloads {
+		// Repository indexes have no digest, and change anyway
+		if d.SHA256 == "" {
+			continue
+		}
+		e := &pkgcache.Entry{Name: path.Base(d.URL), Size: d.Size, SHA256: d.SHA256}
+		if strings.HasPrefix(d.URL, "http://") || strings.HasPrefix(d.URL, "https://") {
+			e.URL = d.URL
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// seedPackageCache will fetch any missing packages into the shared cache in
+// parallel, then place them within the root where the package manager will
+// find them instead of downloading them again
+func (s *USpin) seedPackageCache() error {
+	cache, dir, err := s.packageCache()
+	if err != nil || cache == nil {
+		return err
+	}
+	entries, err := s.seedEntries(cache)
+	if err != nil {
+		return err
+	}
+	fetched, err := cache.Fetch(entries, s.spec.Config.PackageCache.Workers)
+	if err != nil {
+		s.logPackage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to fetch all packages into the cache")
+	}
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return err
+	}
+	seeded := 0
+	for _, e := range entries {
+		if !cache.Has(e) {
+			continue
+		}
+		if err := cache.Link(e, dir); err != nil {
+			return err
+		}
+		seeded++
+	}
+	s.logPackage.WithFields(log.Fields{
+		"fetched": fetched,
+		"seeded":  seeded,
+	}).Info("Seeded packages from the cache")
+	return nil
+}
+
+// harvestPackageCache will store every package fetched by the package manager
+// in the shared cache, recording them for the next build of the profile
+func (s *USpin) harvestPackageCache() error {
+	cache, dir, err := s.packageCache()
+	if err != nil || cache == nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var entries []*pkgcache.Entry
+	for _, file := range files {
+		// Skip partial downloads and lock files
+		if !file.Mode().IsRegular() || file.Size() == 0 {
+			continue
+		}
+		e, err := cache.Store(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	return cache.WriteManifest(s.spec.Profile, entries)
+}
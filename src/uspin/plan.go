@@ -0,0 +1,120 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"libuspin"
+	"libuspin/backend"
+	"os"
+)
+
+var cmdPlan = &Command{
+	Name:  "plan",
+	Usage: "[flags] image.spin",
+	Short: "Print the operations a build would perform, without building",
+}
+
+func init() {
+	cmdPlan.Run = runPlan
+	registerCommand(cmdPlan)
+}
+
+func runPlan(args []string) error {
+	fs := cmdPlan.flagSet()
+	expandGroups := fs.Bool("expand-groups", false, "Ask the package manager to expand groups into packages")
+	resolveDeps := fs.Bool("resolve-deps", false, "Ask the package manager for the full dependency graph (implies -expand-groups)")
+	jsonOutput := fs.Bool("json", false, "Emit the plan as JSON")
+	rehearse := fs.Bool("rehearse", false, "List the exact package manager calls of the build, resolving groups if the backend can")
+	variableFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	img, err := libuspin.NewImageSpec(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	plan := libuspin.NewPlan(img)
+
+	if *expandGroups || *resolveDeps {
+		if err := queryPlan(img, plan, true, *resolveDeps); err != nil {
+			return err
+		}
+	} else if err := plan.RecordSafety(img.Config.Safety.Essential, nil); err != nil {
+		return err
+	}
+	if *rehearse {
+		if err := rehearsePlan(img, plan); err != nil {
+			return err
+		}
+	}
+
+	if *jsonOutput {
+		return plan.WriteJSON(os.Stdout)
+	}
+	return plan.Write(os.Stdout)
+}
+
+// queryPlan will use the package manager to fill in the requested details of
+// the plan, without installing anything.
+func queryPlan(img *libuspin.ImageSpec, plan *libuspin.Plan, expandGroups, resolveDeps bool) error {
+	query, err := backend.NewQuery(img.Config.Image.PackageManager, img.Repos())
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	if expandGroups {
+		expander, ok := query.(backend.GroupExpander)
+		if !ok {
+			return backend.ErrUnsupportedQuery
+		}
+		if err := plan.ExpandGroups(expander); err != nil {
+			return err
+		}
+		if err := plan.RecordSafety(img.Config.Safety.Essential, expander); err != nil {
+			return err
+		}
+	}
+	if resolveDeps {
+		resolver, ok := query.(backend.DependencyResolver)
+		if !ok {
+			return backend.ErrUnsupportedQuery
+		}
+		if err := plan.ResolveDependencies(resolver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rehearsePlan will record the package manager calls of the build, using the
+// repositories to resolve groups where the backend supports querying them
+func rehearsePlan(img *libuspin.ImageSpec, plan *libuspin.Plan) error {
+	query, err := backend.NewQuery(img.Config.Image.PackageManager, img.Repos())
+	switch err {
+	case nil:
+		defer query.Close()
+	case backend.ErrUnsupportedQuery:
+		query = nil
+	default:
+		return err
+	}
+	return plan.Rehearse(img, query)
+}
@@ -0,0 +1,72 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/proxy"
+	"os"
+)
+
+// proxyVariables are honoured by the package manager and anything it runs
+var proxyVariables = []string{"http_proxy", "HTTP_PROXY", "https_proxy", "HTTPS_PROXY"}
+
+// startProxy will route all network access of the package manager through
+// the recording proxy, if configured. The returned function stops the proxy
+// and restores the environment.
+func (s *USpin) startProxy() (func(), error) {
+	conf := s.spec.Config.Proxy
+	if conf.Mode == proxy.ModeOff {
+		return func() {}, nil
+	}
+	p := proxy.New(s.spec.JoinPath(conf.Directory), conf.Mode)
+	addr, err := p.Start()
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[string]*string)
+	for _, name := range proxyVariables {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = &value
+		} else {
+			saved[name] = nil
+		}
+		os.Setenv(name, addr)
+	}
+	s.logPackage.WithFields(log.Fields{
+		"mode":      conf.Mode,
+		"directory": conf.Directory,
+	}).Info("Routing package manager traffic through the proxy")
+
+	return func() {
+		p.Stop()
+		for name, value := range saved {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+		stats := p.Stats()
+		s.logPackage.WithFields(log.Fields{
+			"recorded":  stats.Recorded,
+			"replayed":  stats.Replayed,
+			"missed":    stats.Missed,
+			"tunnelled": stats.Tunnelled,
+		}).Info("Proxy finished")
+	}, nil
+}
@@ -0,0 +1,59 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/publish"
+	"path/filepath"
+)
+
+// Publish will place the resulting image into the release tree, if configured
+func (s *USpin) Publish() error {
+	publisher := publish.NewPublisher(s.spec)
+	if publisher == nil {
+		return nil
+	}
+	if len(s.outputs) == 0 {
+		return errors.New("Nothing to publish, the deliver stage did not run")
+	}
+
+	plan, err := libuspin.LoadPlan(filepath.Join(s.builder.GetWorkspace(), PlanFile))
+	if err != nil {
+		// Still publish, the release notes just won't list package changes
+		s.logImage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("No installation plan available")
+		plan = libuspin.NewPlan(s.spec)
+	}
+
+	s.logImage.WithFields(log.Fields{
+		"directory": publisher.Dir,
+	}).Info("Publishing release")
+	rel, err := publisher.Publish(s.outputs, plan)
+	if err != nil {
+		return err
+	}
+	s.release = rel.Name
+	s.logImage.WithFields(log.Fields{
+		"release": rel.Name,
+		"changes": rel.Summary,
+	}).Info("Published release")
+	return nil
+}
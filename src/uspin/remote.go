@@ -0,0 +1,131 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/config"
+	"libuspin/queue"
+	"libuspin/remote"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteInterval is how often the remote build log is polled
+const remoteInterval = 2 * time.Second
+
+// within returns true if path is dir, or anything beneath it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// remoteRoot returns the closest directory containing both the directory of
+// the profile and every input of the build, i.e. a shared include in ../
+func remoteRoot(baseDir string, inputs []string) string {
+	root := baseDir
+	for _, input := range inputs {
+		for !within(root, input) && filepath.Dir(root) != root {
+			root = filepath.Dir(root)
+		}
+	}
+	return root
+}
+
+// remoteFiles returns a function reporting whether the slash separated name
+// within root is shipped for a remote build: everything in the directory of
+// the profile, as before, and each input outside of it. Nothing else beneath
+// root is shipped.
+func remoteFiles(root, baseDir string, inputs []string) func(name string) bool {
+	paths := append([]string{baseDir}, inputs...)
+	return func(name string) bool {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		for _, p := range paths {
+			// Parents of an input are needed to reach it
+			if within(p, path) || within(path, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// buildRemote will ship the directory of the profile, and any inputs outside
+// of it, to the daemon on the remote host, follow its build log and download
+// the artifacts into the current directory, even if the build fails.
+func buildRemote(spinFile, target string, priority queue.Priority) error {
+	// Catch mistakes in the profile before uploading it
+	img, err := libuspin.NewImageSpec(spinFile)
+	if err != nil {
+		return err
+	}
+	client, err := remote.NewClient(target, os.Getenv(remote.TokenEnv))
+	if err != nil {
+		return err
+	}
+
+	// Leave out images from previous local builds
+	output := filepath.Base(img.OutputTarget())
+	inputs := img.Inputs()
+	root := remoteRoot(img.BaseDir, inputs)
+	shipped := remoteFiles(root, img.BaseDir, inputs)
+	skip := func(name string, info os.FileInfo) bool {
+		if !shipped(name) {
+			return true
+		}
+		return img.OutputTarget() != "" && strings.HasPrefix(filepath.Base(name), output)
+	}
+	spin, err := filepath.Rel(root, img.SpinFile)
+	if err != nil {
+		return err
+	}
+	job, err := client.Submit(root, &queue.Job{
+		Spin:         spin,
+		Priority:     priority,
+		Variables:    config.Overrides,
+		BuildProfile: string(config.SelectedBuildProfile),
+	}, skip)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"remote": target,
+		"job":    job.ID,
+	}).Info("Submitted remote build")
+
+	job, err = client.Follow(job.ID, os.Stdout, remoteInterval)
+	if err != nil {
+		return err
+	}
+	files, err := client.Download(job.ID, ".")
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"job":       job.ID,
+		"state":     job.State,
+		"artifacts": files,
+	}).Info("Downloaded remote artifacts")
+	if job.State != queue.JobSucceeded {
+		return fmt.Errorf("Remote build %v failed", job.ID)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRemoteFiles(t *testing.T) {
+	baseDir := "/srv/spins/desktop"
+	inputs := []string{
+		"/srv/spins/desktop/desktop.spin",
+		"/srv/spins/common/base.packages",
+		"/srv/spins/overlay",
+	}
+	root := remoteRoot(baseDir, inputs)
+	if root != "/srv/spins" {
+		t.Fatalf("Incorrect root: %v", root)
+	}
+	if root := remoteRoot(baseDir, inputs[:1]); root != baseDir {
+		t.Fatalf("Profile directory should be the root without outside inputs: %v", root)
+	}
+	shipped := remoteFiles(root, baseDir, inputs)
+	for name, want := range map[string]bool{
+		"desktop":               true,
+		"desktop/desktop.spin":  true,
+		"desktop/notes.txt":     true,
+		"common":                true,
+		"common/base.packages":  true,
+		"common/other.packages": false,
+		"overlay/etc/motd":      true,
+		"overlay-old/etc/motd":  false,
+		"server/server.spin":    false,
+	} {
+		if shipped(name) != want {
+			t.Fatalf("Incorrect decision for %v, expected %v", name, want)
+		}
+	}
+}
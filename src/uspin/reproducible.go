@@ -0,0 +1,57 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/config"
+	"libuspin/tree"
+	"os"
+)
+
+// exportEpoch will hand the epoch of a reproducible build to every tool we
+// run, such as mksquashfs, xorriso and mkfs, which honour SOURCE_DATE_EPOCH
+// for the timestamps they would otherwise take from the clock.
+func (s *USpin) exportEpoch() error {
+	image := &s.spec.Config.Image
+	if !image.Reproducible() {
+		return nil
+	}
+	s.logImage.WithFields(log.Fields{
+		"epoch": image.Epoch(),
+	}).Info("Building reproducibly")
+	return os.Setenv(config.SourceDateEpochEnv, fmt.Sprint(image.SourceDateEpoch))
+}
+
+// clampTimes will ensure nothing within the rootfs of a reproducible build
+// is newer than the epoch, once nothing else will change within it
+func (s *USpin) clampTimes() error {
+	image := &s.spec.Config.Image
+	if !image.Reproducible() {
+		return nil
+	}
+	changed, err := tree.ClampTimes(s.builder.GetRootDir(), image.Epoch())
+	if err != nil {
+		return err
+	}
+	s.logImage.WithFields(log.Fields{
+		"paths": changed,
+		"epoch": image.Epoch(),
+	}).Info("Clamped timestamps")
+	return nil
+}
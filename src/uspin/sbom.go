@@ -0,0 +1,119 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin"
+	"libuspin/backend"
+	"libuspin/config"
+	"libuspin/license"
+	"libuspin/sbom"
+	"libuspin/version"
+	"path/filepath"
+	"time"
+)
+
+// sbomDocument will describe every package installed within the rootfs,
+// along with their licenses and, from the stored plan, the package files
+// they were installed from
+func (s *USpin) sbomDocument() (*sbom.Document, error) {
+	query, err := backend.NewRootQuery(s.spec.Config.Image.PackageManager, s.builder.GetRootDir())
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+	manifest, err := libuspin.NewPackageManifest(query)
+	if err != nil {
+		return nil, err
+	}
+	licenses, err := license.Collect(query)
+	if err != nil && err != backend.ErrUnsupportedQuery {
+		return nil, err
+	}
+	serial, err := s.spec.IDs.New("sbom")
+	if err != nil {
+		return nil, err
+	}
+
+	created := time.Now()
+	if image := &s.spec.Config.Image; image.Reproducible() {
+		created = image.Epoch()
+	}
+	doc := &sbom.Document{
+		Name:    filepath.Base(s.spec.OutputFilename()),
+		Serial:  serial.String(),
+		Created: created,
+		Manager: s.spec.Config.Image.PackageManager,
+		Tool:    "uspin-" + version.Version,
+	}
+	for _, p := range manifest.Packages {
+		full := p.Version
+		if p.Release != "" {
+			full += "-" + p.Release
+		}
+		doc.Packages = append(doc.Packages, &sbom.Package{
+			Name:     p.Name,
+			Version:  full,
+			Repo:     p.Repo,
+			Licenses: licenses[p.Name],
+		})
+	}
+	if plan, err := libuspin.LoadPlan(filepath.Join(s.builder.GetWorkspace(), PlanFile)); err == nil {
+		doc.AttachDownloads(plan.Downloads)
+	}
+	return doc, nil
+}
+
+// writeSBOM will write each configured bill of materials alongside the image
+func (s *USpin) writeSBOM() error {
+	formats := s.spec.Config.Image.SBOM
+	if len(formats) == 0 {
+		return nil
+	}
+	s.logPackage.Info("Generating software bill of materials")
+	doc, err := s.sbomDocument()
+	if err != nil {
+		return err
+	}
+	for _, format := range formats {
+		var buf bytes.Buffer
+		switch format {
+		case config.SBOMSPDX:
+			err = doc.WriteSPDX(&buf)
+		case config.SBOMCycloneDX:
+			err = doc.WriteCycloneDX(&buf)
+		}
+		if err != nil {
+			return err
+		}
+		output, err := filepath.Abs(s.spec.OutputFilename() + format.Suffix())
+		if err != nil {
+			return err
+		}
+		s.logPackage.WithFields(log.Fields{
+			"format": format,
+			"sbom":   output,
+		}).Info("Wrote software bill of materials")
+		if err := ioutil.WriteFile(output, buf.Bytes(), 00644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"libuspin/queue"
+	"strings"
+	"time"
+)
+
+var cmdSchedule = &Command{
+	Name:  "schedule",
+	Usage: "[flags] [name image.spin]",
+	Short: "Register a profile to be built periodically or on repo changes",
+}
+
+func init() {
+	cmdSchedule.Run = runSchedule
+	registerCommand(cmdSchedule)
+}
+
+func runSchedule(args []string) error {
+	fs := cmdSchedule.flagSet()
+	spoolDir := fs.String("spool", DefaultSpoolDir, "Spool directory shared with the daemon")
+	cron := fs.String("cron", "@nightly", "Cron expression or macro, i.e. \"0 2 * * *\", or empty")
+	onRepoChange := fs.Bool("on-repo-change", false, "Rebuild when packages in the repositories change")
+	pollInterval := fs.Duration("poll", 5*time.Minute, "How often to check the repositories for changes")
+	debounce := fs.Duration("debounce", 30*time.Minute, "How long repositories must be unchanged before rebuilding")
+	priorityName := fs.String("priority", string(queue.PriorityNightly), "One of release, nightly or experimental")
+	remove := fs.Bool("remove", false, "Remove the named schedule")
+	fs.Parse(args)
+
+	spool, err := queue.NewSpool(*spoolDir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *remove && fs.NArg() == 1:
+		return spool.RemoveSchedule(fs.Arg(0))
+	case fs.NArg() == 0:
+		// List the current schedules
+		schedules, err := spool.Schedules()
+		if err != nil {
+			return err
+		}
+		for _, sched := range schedules {
+			trigger := sched.Cron
+			if sched.OnRepoChange {
+				trigger = strings.TrimPrefix(trigger+",repo", ",")
+			}
+			fmt.Printf("%-20s %-14s %-12s %v\n", sched.Name, trigger, sched.Priority, sched.Spin)
+		}
+		return nil
+	case fs.NArg() != 2:
+		return errUsage
+	}
+
+	priority, err := queue.ParsePriority(*priorityName)
+	if err != nil {
+		return err
+	}
+	return spool.AddSchedule(&queue.Schedule{
+		Name:         fs.Arg(0),
+		Spin:         fs.Arg(1),
+		Cron:         *cron,
+		Priority:     priority,
+		OnRepoChange: *onRepoChange,
+		PollInterval: *pollInterval,
+		Debounce:     *debounce,
+	})
+}
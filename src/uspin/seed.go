@@ -0,0 +1,55 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/backend"
+	"libuspin/seed"
+)
+
+// applySeed will populate the empty rootfs from the seed of the profile, if
+// any, before the package manager builds upon it
+func (s *USpin) applySeed() error {
+	source := s.spec.Config.Image.Seed
+	if source == "" {
+		return nil
+	}
+	sd, err := seed.Resolve(source, s.spec.BaseDir)
+	if err != nil {
+		return err
+	}
+	s.logImage.WithFields(log.Fields{
+		"seed": sd.Source,
+		"kind": sd.Kind,
+	}).Info("Seeding rootfs")
+	return sd.Apply(s.builder.GetRootDir())
+}
+
+// bootstrapRoot will have the package manager create the base system of the
+// empty rootfs, when there is no seed to build upon
+func (s *USpin) bootstrapRoot() error {
+	if s.spec.Config.Image.Seed != "" {
+		return nil
+	}
+	bootstrapper, ok := s.packager.(backend.Bootstrapper)
+	if !ok {
+		return nil
+	}
+	s.logPackage.Info("Bootstrapping rootfs")
+	return bootstrapper.Bootstrap(s.spec.Repos())
+}
@@ -0,0 +1,106 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/config"
+	"libuspin/spec"
+	"path/filepath"
+	"strings"
+)
+
+var cmdSet = &Command{
+	Name:  "set",
+	Usage: "[flags] image.spin key=value...",
+	Short: "Change keys of a .spin file, or repositories with -repo",
+}
+
+func init() {
+	cmdSet.Run = runSet
+	registerCommand(cmdSet)
+}
+
+func runSet(args []string) error {
+	fs := cmdSet.flagSet()
+	repo := fs.Bool("repo", false, "Set repository URIs (name=uri) in the packages file instead")
+	variableFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+	path := fs.Arg(0)
+	var pairs [][]string
+	for _, arg := range fs.Args()[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return errUsage
+		}
+		pairs = append(pairs, kv)
+	}
+
+	// Validate the whole profile first, so we never edit a broken one
+	conf, err := config.New(path)
+	if err != nil {
+		return err
+	}
+
+	if *repo {
+		pkgs := filepath.Join(filepath.Dir(path), conf.Image.Packages)
+		for _, kv := range pairs {
+			if err := spec.SetRepo(pkgs, kv[0], kv[1]); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"file": pkgs,
+				"repo": kv[0],
+				"uri":  kv[1],
+			}).Info("Updated repository")
+		}
+		return nil
+	}
+
+	doc, err := config.LoadDocument(path)
+	if err != nil {
+		return err
+	}
+	orig, err := config.LoadDocument(path)
+	if err != nil {
+		return err
+	}
+	for _, kv := range pairs {
+		if err := doc.Set(kv[0], config.ParseValue(kv[1])); err != nil {
+			return err
+		}
+	}
+	if err := doc.Save(path); err != nil {
+		return err
+	}
+	// Don't leave the user with a profile that no longer loads
+	if _, err := config.New(path); err != nil {
+		if err := orig.Save(path); err != nil {
+			return err
+		}
+		return err
+	}
+	log.WithFields(log.Fields{
+		"file": path,
+		"keys": len(pairs),
+	}).Info("Updated profile")
+	return nil
+}
@@ -0,0 +1,88 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/signature"
+	"path/filepath"
+)
+
+var cmdSign = &Command{
+	Name:  "sign",
+	Usage: "[flags] image.spin",
+	Short: "Sign the profile directory for verified builds",
+}
+
+func init() {
+	cmdSign.Run = runSign
+	registerCommand(cmdSign)
+}
+
+func runSign(args []string) error {
+	fs := cmdSign.flagSet()
+	key := fs.String("key", "", "GPG key to sign with, defaults to the default key")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	dir, inputs, err := profileInputs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := signature.Sign(dir, *key, inputs); err != nil {
+		return err
+	}
+	fmt.Printf("Signed %v\n", filepath.Join(dir, signature.SignatureFile))
+	return nil
+}
+
+// profileInputs returns the directory of the .spin file, along with every
+// file the build of the profile would read
+func profileInputs(path string) (string, []string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	inputs, err := libuspin.ProfileInputs(abs)
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Dir(abs), inputs, nil
+}
+
+// verifyProfile will refuse to build a profile without a trusted signature
+func verifyProfile(path string, method signature.Method, keyring string) error {
+	if method == signature.MethodNone {
+		return nil
+	}
+	dir, inputs, err := profileInputs(path)
+	if err != nil {
+		return err
+	}
+	if err := signature.Verify(dir, method, keyring, inputs); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"profile": dir,
+		"method":  method,
+	}).Info("Profile signature verified")
+	return nil
+}
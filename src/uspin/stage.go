@@ -0,0 +1,301 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/build"
+	"libuspin/journal"
+	"libuspin/pipeline"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A stage is one step of the build pipeline which may be run on its own,
+// provided the stages before it have run at some point in the workspace.
+type stage struct {
+	name    string
+	run     func(s *USpin) error
+	rootfs  bool                  // Needs the rootfs to be mounted
+	outputs func(s *USpin) string // What later stages need from it, if known
+
+	// Identifies the inputs of every stage up to this one, if the rootfs is
+	// checkpointed after it
+	key func(s *USpin) (string, error)
+}
+
+// stages are the build pipeline, in order
+var stages = []*stage{
+	{
+		name: "prepare",
+		run:  (*USpin).StartImageBuild,
+	},
+	{
+		name:   "rootfs",
+		run:    (*USpin).InstallPackages,
+		rootfs: true,
+		outputs: func(s *USpin) string {
+			return filepath.Join(s.builder.GetWorkspace(), PlanFile)
+		},
+		key: (*USpin).rootfsKey,
+	},
+	{
+		name:   "configure",
+		run:    (*USpin).ConfigureRootfs,
+		rootfs: true,
+		key:    (*USpin).configureKey,
+	},
+	{
+		name:   "mediagen",
+		run:    (*USpin).FinishImageBuild,
+		rootfs: true,
+		outputs: func(s *USpin) string {
+			return s.spec.OutputFilename()
+		},
+	},
+	{
+		name: "test",
+		run:  (*USpin).SmokeTest,
+	},
+	{
+		name: "deliver",
+		run:  (*USpin).DeliverOutputs,
+	},
+	{
+		name: "checksum",
+		run:  (*USpin).ChecksumOutputs,
+	},
+	{
+		name: "publish",
+		run:  (*USpin).Publish,
+	},
+}
+
+// stageNames returns the names of all stages, for diagnostics
+func stageNames() []string {
+	var ret []string
+	for _, st := range stages {
+		ret = append(ret, st.name)
+	}
+	return ret
+}
+
+// stageIndex returns the position of the named stage within the pipeline
+func stageIndex(name string) (int, error) {
+	for i, st := range stages {
+		if st.name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("Unknown stage '%v', expected one of: %v", name, strings.Join(stageNames(), ", "))
+}
+
+// splitStages parses a comma separated list of stage names
+func splitStages(list string) ([]int, error) {
+	var ret []int
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		i, err := stageIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+// SelectStages will restrict the build to a subset of the pipeline. only
+// and skip are comma separated stage names, and from is the first stage
+// to run. Empty values select everything, but leaving nothing selected is
+// an error.
+func (s *USpin) SelectStages(only, skip, from string) error {
+	selected := make([]bool, len(stages))
+	if only != "" {
+		indices, err := splitStages(only)
+		if err != nil {
+			return err
+		}
+		for _, i := range indices {
+			selected[i] = true
+		}
+	} else {
+		for i := range selected {
+			selected[i] = true
+		}
+	}
+	if from != "" {
+		first, err := stageIndex(from)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < first; i++ {
+			selected[i] = false
+		}
+	}
+	indices, err := splitStages(skip)
+	if err != nil {
+		return err
+	}
+	for _, i := range indices {
+		selected[i] = false
+	}
+	for _, sel := range selected {
+		if sel {
+			s.stages = selected
+			return nil
+		}
+	}
+	return errors.New("No stages selected, nothing would be built")
+}
+
+// runStages will run the selected stages of the pipeline in order
+func (s *USpin) runStages() error {
+	if s.stages == nil {
+		s.SelectStages("", "", "")
+	}
+	if !s.stages[0] {
+		if err := s.reopenWorkspace(); err != nil {
+			s.logImage.Error(err)
+			return err
+		}
+	}
+	p, err := s.newPipeline()
+	if err != nil {
+		s.logImage.Error(err)
+		return err
+	}
+	// Failed stages are logged as the pipeline observes them
+	return p.Run()
+}
+
+// newPipeline returns the build as a pipeline, skipping the stages that
+// weren't selected. The first checkpoint that can be restored replaces
+// running the stages it covers.
+func (s *USpin) newPipeline() (*pipeline.Pipeline, error) {
+	p, err := pipeline.New()
+	if err != nil {
+		return nil, err
+	}
+	restored := -1
+	for i, st := range stages {
+		i, st := i, st
+		run := func() error {
+			if restored < 0 && st.key != nil {
+				restored = s.restoreCheckpoint(i)
+			}
+			if i <= restored {
+				return nil
+			}
+			if err := s.control.Checkpoint(); err != nil {
+				return err
+			}
+			if err := st.run(s); err != nil {
+				return err
+			}
+			if st.key != nil {
+				s.saveCheckpoint(st)
+			}
+			return nil
+		}
+		if err := p.Append(&pipeline.Stage{Name: st.name, Run: run}); err != nil {
+			return nil, err
+		}
+		if !s.stages[i] {
+			p.Skip(st.name)
+		}
+	}
+	p.Observe(s.observeStage)
+	return p, nil
+}
+
+// observeStage will journal and log the progress of the pipeline
+func (s *USpin) observeStage(e *pipeline.Event) {
+	switch e.Kind {
+	case pipeline.EventSkip:
+		s.checkSkipped(e.Index)
+	case pipeline.EventStart:
+		if s.logs != nil {
+			s.logs.SetStage(e.Stage)
+		}
+		if s.heartbeat != nil {
+			s.heartbeat.SetOperation(e.Stage)
+		}
+		if s.events != nil {
+			s.emitted(s.events.StartStage(e.Stage))
+		}
+		s.record(journal.EventStart, e.Stage, nil)
+	case pipeline.EventFinish:
+		if s.events != nil {
+			s.emitted(s.events.EndStage(nil))
+		}
+		s.record(journal.EventFinish, e.Stage, nil)
+	case pipeline.EventFail:
+		if s.events != nil {
+			s.emitted(s.events.EndStage(e.Err))
+		}
+		s.record(journal.EventFail, e.Stage, e.Err)
+		s.logImage.WithFields(log.Fields{
+			"stage": e.Stage,
+		}).Error(e.Err)
+	}
+}
+
+// checkSkipped will warn if a skipped stage has nothing for the stages
+// after it to use.
+func (s *USpin) checkSkipped(index int) {
+	st := stages[index]
+	later := false
+	for i := index + 1; i < len(stages); i++ {
+		later = later || s.stages[i]
+	}
+	if !later || st.outputs == nil {
+		return
+	}
+	path := st.outputs(s)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	s.logImage.WithFields(log.Fields{
+		"stage": st.name,
+		"path":  path,
+	}).Warning("Skipped stage has no output from a previous build")
+}
+
+// reopenWorkspace will reuse the workspace of a previous build in place of
+// the prepare stage, mounting the rootfs if any selected stage needs it.
+func (s *USpin) reopenWorkspace() error {
+	cached, ok := s.builder.(build.CachedRootfs)
+	if !ok {
+		return fmt.Errorf("Image type %v cannot skip the prepare stage", s.spec.Config.Image.Type)
+	}
+	if err := cached.OpenWorkspace(); err != nil {
+		return err
+	}
+	s.spec.Staging = filepath.Join(s.builder.GetWorkspace(), StagingDir)
+	for i, st := range stages {
+		if s.stages[i] && st.rootfs {
+			s.logImage.Info("Mounting cached storage")
+			return s.builder.MountStorage()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectStages(t *testing.T) {
+	cases := []struct {
+		only, skip, from string
+		want             string // Selected stages in pipeline order, or the error
+	}{
+		{"", "", "", "prepare rootfs configure mediagen test deliver checksum publish"},
+		{"rootfs,configure", "", "", "rootfs configure"},
+		{"checksum, rootfs", "", "", "rootfs checksum"},
+		{"rootfs,,rootfs", "", "", "rootfs"},
+		{"", "test,publish", "", "prepare rootfs configure mediagen deliver checksum"},
+		{"", "", "mediagen", "mediagen test deliver checksum publish"},
+		{"", "", "publish", "publish"},
+		{"", "test", "mediagen", "mediagen deliver checksum publish"},
+		{"prepare,mediagen,test", "", "configure", "mediagen test"},
+		{"rootfs", "rootfs", "", "No stages selected"},
+		{"prepare,rootfs", "", "configure", "No stages selected"},
+		{"rootfs,bogus", "", "", "Unknown stage 'bogus'"},
+		{"", "bogus", "", "Unknown stage 'bogus'"},
+		{"", "", "bogus", "Unknown stage 'bogus'"},
+	}
+	for _, c := range cases {
+		s := &USpin{}
+		err := s.SelectStages(c.only, c.skip, c.from)
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), c.want) {
+				t.Fatalf("Incorrect error for %+v: %v", c, err)
+			}
+			continue
+		}
+		var got []string
+		for i, selected := range s.stages {
+			if selected {
+				got = append(got, stages[i].name)
+			}
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Fatalf("Incorrect stages for %+v: %v", c, got)
+		}
+	}
+}
+
+func TestSplitStages(t *testing.T) {
+	indices, err := splitStages(" deliver , prepare ,")
+	if err != nil {
+		t.Fatalf("Cannot split stages: %v", err)
+	}
+	if !reflect.DeepEqual(indices, []int{5, 0}) {
+		t.Fatalf("Stages should be listed as given: %v", indices)
+	}
+	if _, err := splitStages("prepare,Rootfs"); err == nil || !strings.Contains(err.Error(), "prepare, rootfs") {
+		t.Fatalf("Unknown stages should list the valid ones: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/lint"
+	"libuspin/lock"
+	"os"
+	"strings"
+)
+
+var cmdStatus = &Command{
+	Name:  "status",
+	Usage: "image.spin",
+	Short: "Report the state of the build workspace",
+}
+
+var cmdClean = &Command{
+	Name:  "clean",
+	Usage: "image.spin",
+	Short: "Safely remove the build workspace",
+}
+
+func init() {
+	cmdStatus.Run = runStatus
+	registerCommand(cmdStatus)
+	cmdClean.Run = runClean
+	registerCommand(cmdClean)
+}
+
+// openWorkspace will return a USpin whose workspace is known
+func openWorkspace(args []string) (*USpin, error) {
+	if len(args) != 1 {
+		return nil, errUsage
+	}
+	spin, err := NewUSpin(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := spin.builder.Init(spin.spec); err != nil {
+		return nil, err
+	}
+	return spin, nil
+}
+
+func runStatus(args []string) error {
+	spin, err := openWorkspace(args)
+	if err != nil {
+		return err
+	}
+	workspace := spin.builder.GetWorkspace()
+	state, err := spin.workspaceState()
+	if err != nil {
+		return err
+	}
+	owner, err := lock.Owner(workspace)
+	if err != nil {
+		return err
+	}
+	mounts, err := lint.MountsBeneath(workspace)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Workspace: %v\n", workspace)
+	if state.PID == 0 {
+		fmt.Printf("State:     no build recorded\n")
+		return nil
+	}
+	fmt.Printf("Build:     pid %v, began %v\n", state.PID, state.Began.Local().Format("2006-01-02 15:04:05"))
+	switch {
+	case owner != 0:
+		fmt.Printf("State:     running stage %v (pid %v)\n", state.Running, owner)
+	case state.Interrupted():
+		fmt.Printf("State:     interrupted during stage %v, resume with -resume\n", state.Running)
+	case state.Failed != "":
+		fmt.Printf("State:     failed in stage %v: %v\n", state.Failed, state.Error)
+	default:
+		fmt.Printf("State:     finished\n")
+	}
+	fmt.Printf("Completed: %v\n", strings.Join(state.Completed, ", "))
+	if owner == 0 && len(mounts) > 0 {
+		fmt.Printf("Mounts:    %v left behind, removed by clean or the next build\n", len(mounts))
+	}
+	return nil
+}
+
+func runClean(args []string) error {
+	spin, err := openWorkspace(args)
+	if err != nil {
+		return err
+	}
+	workspace := spin.builder.GetWorkspace()
+	l, err := lock.TryAcquire(workspace)
+	if err == lock.ErrLocked {
+		owner, _ := lock.Owner(workspace)
+		return fmt.Errorf("Workspace is in use by pid %v", owner)
+	}
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	// Never remove through a mount, or we'd remove host files
+	if err := unmountStale(workspace); err != nil {
+		return err
+	}
+	if mounts, err := lint.MountsBeneath(workspace); err != nil || len(mounts) > 0 {
+		return fmt.Errorf("Refusing to remove workspace with mounts remaining: %v %v", mounts, err)
+	}
+	if err := os.RemoveAll(workspace); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"workspace": workspace,
+	}).Info("Removed workspace")
+	return nil
+}
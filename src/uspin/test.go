@@ -0,0 +1,66 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/build"
+	"libuspin/smoke"
+	"os"
+	"path/filepath"
+)
+
+// SerialLog is the workspace file holding the boot test console transcript
+const SerialLog = "serial.log"
+
+// SmokeTest will boot the finished image and run the test scripts within it,
+// before it is delivered.
+func (s *USpin) SmokeTest() error {
+	conf := &s.spec.Config.Test
+	if !conf.Enabled() {
+		return nil
+	}
+	target, ok := s.builder.(build.BootTarget)
+	if !ok {
+		return fmt.Errorf("Image type %v cannot be boot tested", s.spec.Config.Image.Type)
+	}
+	files, err := target.BootFiles()
+	if err != nil {
+		return err
+	}
+
+	transcript, err := os.Create(filepath.Join(s.builder.GetWorkspace(), SerialLog))
+	if err != nil {
+		return err
+	}
+	defer transcript.Close()
+
+	s.logImage.WithFields(log.Fields{
+		"scripts":    len(conf.Scripts),
+		"transcript": transcript.Name(),
+	}).Info("Boot testing image")
+	results, err := smoke.Run(conf, s.spec.Config.Image.Arch, files, s.spec.BaseDir, transcript)
+	for _, result := range results {
+		s.logImage.WithFields(log.Fields{
+			"script":   result.Script,
+			"exitCode": result.ExitCode,
+			"duration": result.Duration,
+		}).Info("Test script finished")
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/toolcache"
+)
+
+// prepareTools will fetch the configured helper tools into the tool
+// directory, and give them priority over those of the host, before any
+// builder checks the host for the tools it needs.
+func (s *USpin) prepareTools() error {
+	conf := &s.spec.Config.Tools
+	if conf.Directory == "" {
+		return nil
+	}
+	cache, err := toolcache.Open(s.spec.JoinPath(conf.Directory))
+	if err != nil {
+		return err
+	}
+	fetched, err := cache.Fetch(conf.Tools())
+	if err != nil {
+		return err
+	}
+	tools, err := cache.Tools()
+	if err != nil {
+		return err
+	}
+	s.logImage.WithFields(log.Fields{
+		"directory": cache.Dir(),
+		"fetched":   fetched,
+		"tools":     tools,
+	}).Info("Using private helper tools")
+	return cache.Activate()
+}
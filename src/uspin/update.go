@@ -0,0 +1,110 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/version"
+	"os"
+	"path/filepath"
+)
+
+var cmdSelfUpdate = &Command{
+	Name:  "self-update",
+	Usage: "[flags]",
+	Short: "Update uspin from signed release metadata",
+}
+
+var cmdVersion = &Command{
+	Name:  "version",
+	Usage: "",
+	Short: "Print the uspin version",
+}
+
+func init() {
+	cmdSelfUpdate.Run = runSelfUpdate
+	registerCommand(cmdSelfUpdate)
+	cmdVersion.Run = runVersion
+	registerCommand(cmdVersion)
+}
+
+func runVersion(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	fmt.Println(version.Version)
+	return nil
+}
+
+func runSelfUpdate(args []string) error {
+	fs := cmdSelfUpdate.flagSet()
+	url := fs.String("url", os.Getenv("USPIN_UPDATE_URL"), "Base URL of the release metadata (USPIN_UPDATE_URL)")
+	keyring := fs.String("keyring", version.DefaultKeyring, "Keyring trusted to sign the release metadata")
+	serialFile := fs.String("serial-file", version.DefaultSerialFile, "Records the newest release metadata seen, refusing older metadata")
+	pin := fs.String("version", "", "Install exactly this version, even if older")
+	check := fs.Bool("check", false, "Only report the version that would be installed")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	if *url == "" {
+		return errors.New("No update URL, use -url or set USPIN_UPDATE_URL")
+	}
+	meta, err := version.FetchMetadata(*url, *keyring)
+	if err != nil {
+		return err
+	}
+	if err := meta.CheckSerial(*serialFile); err != nil {
+		return err
+	}
+	rel, err := meta.Select(*pin)
+	if err != nil {
+		return err
+	}
+	upgrade, err := version.Upgrade(rel, version.Version, *pin)
+	if err != nil {
+		return err
+	}
+	fields := log.Fields{
+		"current":   version.Version,
+		"available": rel.Version,
+	}
+	if !upgrade {
+		log.WithFields(fields).Info("uspin is up to date")
+		return nil
+	}
+	if *check {
+		log.WithFields(fields).Info("uspin update available")
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := version.Install(rel, exe); err != nil {
+		return err
+	}
+	log.WithFields(fields).Info("Updated uspin")
+	return nil
+}
@@ -0,0 +1,75 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"libuspin"
+	"os"
+)
+
+var cmdValidate = &Command{
+	Name:  "validate",
+	Usage: "[flags] image.spin",
+	Short: "Report every problem with the .spin and Packages files",
+}
+
+func init() {
+	cmdValidate.Run = runValidate
+	registerCommand(cmdValidate)
+}
+
+func runValidate(args []string) error {
+	fs := cmdValidate.flagSet()
+	jsonOutput := fs.Bool("json", false, "Emit the diagnostics as JSON")
+	variableFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	diags := libuspin.Validate(fs.Arg(0))
+	errors := 0
+	for _, d := range diags {
+		if !d.Warning {
+			errors++
+		}
+	}
+
+	if *jsonOutput {
+		if diags == nil {
+			diags = []*libuspin.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(diags); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%v problems found in %v", errors, fs.Arg(0))
+	}
+	if !*jsonOutput {
+		fmt.Printf("%v is valid\n", fs.Arg(0))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"libuspin/config"
+	"libuspin/spec"
+	"strings"
+)
+
+// variableFlag sets a configuration variable from "-var NAME=value"
+type variableFlag struct{}
+
+func (v variableFlag) String() string {
+	return ""
+}
+
+func (v variableFlag) Set(value string) error {
+	fields := strings.SplitN(value, "=", 2)
+	if len(fields) != 2 || !spec.IsVariableName(fields[0]) {
+		return fmt.Errorf("Expected NAME=value: %v", value)
+	}
+	config.Overrides[fields[0]] = fields[1]
+	return nil
+}
+
+// variableFlags will add the -var flag for commands loading a .spin file
+func variableFlags(fs *flag.FlagSet) {
+	fs.Var(variableFlag{}, "var", "Set a variable referenced as ${NAME}, as NAME=value, may be repeated")
+}
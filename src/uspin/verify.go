@@ -0,0 +1,64 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"libuspin/publish"
+)
+
+var cmdVerify = &Command{
+	Name:  "verify",
+	Usage: "[flags] image.iso [manifest.json [signature]]",
+	Short: "Verify a published image against its checksums, signatures and provenance",
+}
+
+func init() {
+	cmdVerify.Run = runVerify
+	registerCommand(cmdVerify)
+}
+
+func runVerify(args []string) error {
+	fs := cmdVerify.flagSet()
+	keyring := fs.String("keyring", "", "Keyring to verify signatures against, defaults to the gpg keyring")
+	verityHash := fs.String("verity-hash", "", "dm-verity hash tree of the image")
+	rootHash := fs.String("root-hash", "", "Expected dm-verity root hash of the image")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 3 {
+		return errUsage
+	}
+	opts := &publish.VerifyOptions{
+		Manifest:   fs.Arg(1),
+		Signature:  fs.Arg(2),
+		Keyring:    *keyring,
+		VerityHash: *verityHash,
+		RootHash:   *rootHash,
+	}
+	problems, err := publish.VerifyArtifact(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%v failed verification", fs.Arg(0))
+	}
+	fmt.Printf("%v verified\n", fs.Arg(0))
+	return nil
+}
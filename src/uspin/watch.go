@@ -0,0 +1,171 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin"
+	"libuspin/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var cmdWatch = &Command{
+	Name:  "watch",
+	Usage: "[flags] image.spin",
+	Short: "Re-plan, re-lint and re-apply overlays whenever the profile changes",
+}
+
+func init() {
+	cmdWatch.Run = runWatch
+	registerCommand(cmdWatch)
+}
+
+func runWatch(args []string) error {
+	fs := cmdWatch.flagSet()
+	interval := fs.Duration("interval", 2*time.Second, "How often to check the profile for changes")
+	noRootfs := fs.Bool("no-rootfs", false, "Don't re-apply overlays and permissions to the cached rootfs")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+
+	// Changes we make within the workspace mustn't trigger another cycle
+	skip := make(map[string]bool)
+	last := ""
+	for {
+		state, err := profileState(dir, skip)
+		if err != nil {
+			return err
+		}
+		if state != last {
+			if workspace := watchCycle(path, !*noRootfs); workspace != "" {
+				skip[workspace] = true
+			}
+			if last, err = profileState(dir, skip); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"directory": dir,
+			}).Info("Watching for changes")
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// profileState returns a digest of the names, sizes and modification times
+// of every file within the profile directory.
+func profileState(dir string, skip map[string]bool) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may disappear from under us while editors save
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && (skip[path] || (path != dir && strings.HasPrefix(info.Name(), "."))) {
+			return filepath.SkipDir
+		}
+		fmt.Fprintf(h, "%v %v %v\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+// watchCycle will run the cheap stages against the profile, logging any
+// failures rather than returning them so that watching continues. The
+// workspace is returned if known.
+func watchCycle(path string, rootfs bool) string {
+	spin, err := NewUSpin(path)
+	if err != nil {
+		log.Error(err)
+		return ""
+	}
+	spin.summariseDeprecations()
+	if err := libuspin.NewPlan(spin.spec).Write(os.Stdout); err != nil {
+		log.Error(err)
+		return ""
+	}
+	if !rootfs {
+		return ""
+	}
+	if err := spin.builder.Init(spin.spec); err != nil {
+		spin.logImage.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Unable to use the cached rootfs")
+		return ""
+	}
+	workspace := spin.builder.GetWorkspace()
+	if err := spin.reapply(); err != nil {
+		spin.logImage.Error(err)
+	}
+	return workspace
+}
+
+// reapply will re-run the overlay and permission stages against the rootfs
+// of a previous build, then lint the result.
+func (s *USpin) reapply() error {
+	cached, ok := s.builder.(build.CachedRootfs)
+	if !ok {
+		s.logImage.Warning("Image type cannot reuse a cached rootfs")
+		return nil
+	}
+	if err := s.acquireLocks(); err != nil {
+		return err
+	}
+	defer s.releaseLocks()
+
+	if err := cached.OpenWorkspace(); err != nil {
+		if err == build.ErrNoCachedRootfs {
+			s.logImage.Warning(err)
+			return nil
+		}
+		return err
+	}
+	if err := s.builder.MountStorage(); err != nil {
+		return err
+	}
+	defer s.builder.Cleanup()
+
+	if err := s.applyOverlay(); err != nil {
+		return err
+	}
+	if err := s.installFirstboot(); err != nil {
+		return err
+	}
+	if err := s.applyPermissions(); err != nil {
+		return err
+	}
+	if err := s.checkContamination(); err != nil {
+		return err
+	}
+	s.logImage.Info("Re-applied profile to the cached rootfs")
+	return s.builder.UnmountStorage()
+}
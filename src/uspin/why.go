@@ -0,0 +1,66 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"libuspin"
+	"path/filepath"
+)
+
+var cmdWhy = &Command{
+	Name:  "why",
+	Usage: "[flags] package",
+	Short: "Explain why a package was included in the image",
+}
+
+func init() {
+	cmdWhy.Run = runWhy
+	registerCommand(cmdWhy)
+}
+
+func runWhy(args []string) error {
+	fs := cmdWhy.flagSet()
+	workspace := fs.String("workspace", "workspace", "Workspace of a previous build")
+	planPath := fs.String("plan", "", "Use a plan stored by \"uspin plan -json -resolve-deps\" instead of the workspace")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	name := fs.Arg(0)
+
+	if *planPath == "" {
+		*planPath = filepath.Join(*workspace, PlanFile)
+	}
+	plan, err := libuspin.LoadPlan(*planPath)
+	if err != nil {
+		return err
+	}
+	if plan.Dependencies == nil {
+		return fmt.Errorf("The plan %v does not contain dependency information", *planPath)
+	}
+
+	reasons := plan.Why(name)
+	if len(reasons) == 0 {
+		return fmt.Errorf("'%v' is not part of the image", name)
+	}
+	for _, reason := range reasons {
+		fmt.Println(reason)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/version"
+	"libuspin/workspace"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var cmdWorkspace = &Command{
+	Name:  "workspace",
+	Usage: "export|import image.spin archive",
+	Short: "Export or import the build workspace, i.e. to debug CI builds",
+}
+
+func init() {
+	cmdWorkspace.Run = runWorkspace
+	registerCommand(cmdWorkspace)
+}
+
+func runWorkspace(args []string) error {
+	fs := cmdWorkspace.flagSet()
+	fs.Parse(args)
+
+	if fs.NArg() != 3 {
+		return errUsage
+	}
+	action, path, archive := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	if action != "export" && action != "import" {
+		return errUsage
+	}
+
+	spin, err := NewUSpin(path)
+	if err != nil {
+		return err
+	}
+	if err := spin.builder.Init(spin.spec); err != nil {
+		return err
+	}
+	if err := spin.acquireLocks(); err != nil {
+		return err
+	}
+	defer spin.releaseLocks()
+	dir := spin.builder.GetWorkspace()
+
+	if action == "export" {
+		host, _ := os.Hostname()
+		meta := &workspace.Metadata{
+			Profile: filepath.Base(path),
+			Version: version.Version,
+			Host:    host,
+			Created: time.Now().UTC(),
+		}
+		if err := workspace.Export(dir, archive, meta); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"workspace": dir,
+			"archive":   archive,
+		}).Info("Exported workspace")
+		return nil
+	}
+
+	meta, err := workspace.Import(archive, dir)
+	if err != nil {
+		return err
+	}
+	fields := log.Fields{
+		"workspace": dir,
+		"profile":   meta.Profile,
+		"host":      meta.Host,
+		"version":   meta.Version,
+		"created":   meta.Created,
+	}
+	if meta.Profile != filepath.Base(path) {
+		log.WithFields(fields).Warning("Workspace was exported from a different profile")
+	}
+	if meta.Version != version.Version {
+		log.WithFields(fields).Warning("Workspace was exported by a different uspin version")
+	}
+	log.WithFields(fields).Info("Imported workspace, inspect it with 'uspin chroot'")
+	return nil
+}
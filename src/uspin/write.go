@@ -0,0 +1,114 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"libuspin/usb"
+	"os"
+	"strings"
+)
+
+var cmdWrite = &Command{
+	Name:  "write",
+	Usage: "[flags] image.iso /dev/sdX",
+	Short: "Write an image to a USB device, verifying it afterwards",
+}
+
+func init() {
+	cmdWrite.Run = runWrite
+	registerCommand(cmdWrite)
+}
+
+// progress returns a usb.ProgressFunc printing the percentage to stderr
+func progress(verb string) usb.ProgressFunc {
+	last := -1
+	return func(done, total int64) {
+		if pct := int(done * 100 / total); pct != last {
+			last = pct
+			fmt.Fprintf(os.Stderr, "\r%v: %3d%%", verb, pct)
+			if done == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+}
+
+// confirm will ask the user to type YES before the device is destroyed
+func confirm(dev *usb.Device) bool {
+	fmt.Fprintf(os.Stderr, "All data on %v will be destroyed.\nType YES to continue: ", dev)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line) == "YES"
+}
+
+func runWrite(args []string) error {
+	fs := cmdWrite.flagSet()
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	force := fs.Bool("force", false, "Allow non-removable and unusually large devices")
+	noVerify := fs.Bool("no-verify", false, "Skip reading the image back from the device")
+	persistence := fs.Bool("persistence", false, "Use the remaining space for a persistence partition")
+	label := fs.String("label", usb.DefaultPersistenceLabel, "Filesystem label of the persistence partition")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	image, device := fs.Arg(0), fs.Arg(1)
+	st, err := os.Stat(image)
+	if err != nil {
+		return err
+	}
+	dev, err := usb.Inspect(device)
+	if err != nil {
+		return err
+	}
+	if err := dev.Check(st.Size(), *force); err != nil {
+		return err
+	}
+	if !*yes && !confirm(dev) {
+		return fmt.Errorf("Not writing to %v", dev.Path)
+	}
+
+	log.WithFields(log.Fields{
+		"image":  image,
+		"device": dev.Path,
+	}).Info("Writing image")
+	if err := usb.Write(image, dev.Path, progress("Writing")); err != nil {
+		return err
+	}
+	if !*noVerify {
+		if err := usb.Verify(image, dev.Path, progress("Verifying")); err != nil {
+			return err
+		}
+	}
+	if *persistence {
+		part, err := usb.AddPersistence(dev, *label)
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"partition": part,
+			"label":     *label,
+		}).Info("Created persistence partition, boot with rd.live.overlay=LABEL=" + *label)
+	}
+	log.WithFields(log.Fields{
+		"device": dev.Path,
+	}).Info("Image written successfully")
+	return nil
+}